@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+	"oauth-tutorial/internal/config"
+	"oauth-tutorial/internal/infrastructure"
+	"oauth-tutorial/internal/infrastructure/htpasswd"
+	uDecision "oauth-tutorial/internal/usecase/decision"
+	"oauth-tutorial/pkg/mylogger"
+)
+
+// ログインIDとパスワードによる認証の方式
+// users_fileを指定した場合はファイルのユーザー、それ以外は永続化の実装に登録したユーザーで認証する
+func newAuthenticator(logger mylogger.Logger, cfg *config.Config, st *stores) (uDecision.IAuthenticator, error) {
+	if cfg.UsersFile == "" {
+		return infrastructure.NewUserStoreAuthenticator(logger, st.users, cfg.PasswordHasher()), nil
+	}
+	file, err := htpasswd.Open(cfg.UsersFile, cfg.PasswordHasher())
+	if err != nil {
+		return nil, fmt.Errorf("open users file: %w", err)
+	}
+	// セッションや同意からユーザーを参照できるよう、ファイルのユーザーを永続化の実装にも登録する。パスワードは登録しない
+	for _, user := range file.Users() {
+		if err := st.users.Save(user); err != nil {
+			return nil, fmt.Errorf("save user: %w", err)
+		}
+	}
+	return file, nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"oauth-tutorial/internal/config"
+	"oauth-tutorial/internal/session"
+	"oauth-tutorial/pkg/mylogger"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func Test_htpasswd形式のファイルのユーザーでのログイン統合テスト(t *testing.T) {
+	// given: sqliteの保存先と、htpasswd形式のファイルのユーザー
+	dir := t.TempDir()
+	hash, err := bcrypt.GenerateFromPassword([]byte("alice-password"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("GenerateFromPassword() error = %v", err)
+	}
+	usersFile := filepath.Join(dir, "users.htpasswd")
+	writeConfigFile(t, usersFile, "alice@example.com:"+string(hash)+":alice\n")
+	path := filepath.Join(dir, "config.yaml")
+	writeConfigFile(t, path, fmt.Sprintf(`
+storage:
+  driver: sqlite
+  dsn: %s
+scopes: [read]
+clients:
+  - id: partner
+    name: パートナー
+    type: confidential
+    secret: secret
+    redirect_uris: [https://partner.example.com/callback]
+users_file: %s
+`, filepath.Join(dir, "oauth.db"), usersFile))
+	cfg, err := config.Load(path, func(string) string { return "" })
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	realms, err := openRealms(mylogger.NewMockLogger(), cfg, renderer)
+	if err != nil {
+		t.Fatalf("openRealms() error = %v", err)
+	}
+	defer closeRealms(realms)
+	server := httptest.NewServer(newRealmRouter(realms))
+	defer server.Close()
+
+	client := &http.Client{
+		Timeout: 5 * time.Second,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	do := func(method, path, body string, cookies ...*http.Cookie) *http.Response {
+		req, _ := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		return resp
+	}
+	// ログインIDとパスワードでログインし、/decisionのレスポンスを返す
+	login := func(loginID, password string) *http.Response {
+		resp := do("GET", "/authorize?response_type=code&client_id=partner&redirect_uri=https://partner.example.com/callback&state=xyz&scope=read", "")
+		defer resp.Body.Close()
+		var authorizeResult map[string]any
+		if err := json.NewDecoder(resp.Body).Decode(&authorizeResult); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		return do("POST", "/decision", url.Values{
+			"approved":       {"true"},
+			"transaction_id": {fmt.Sprint(authorizeResult["transaction_id"])},
+			"csrf_token":     {fmt.Sprint(authorizeResult["csrf_token"])},
+			"login_id":       {loginID},
+			"password":       {password},
+		}.Encode(), resp.Cookies()...)
+	}
+
+	t.Run("ファイルのユーザーでログインし、セッションからユーザーを参照できる", func(t *testing.T) {
+		// when
+		resp := login("alice@example.com", "alice-password")
+		resp.Body.Close()
+
+		// then
+		location, _ := url.Parse(resp.Header.Get("Location"))
+		if resp.StatusCode != http.StatusSeeOther || location.Query().Get("code") == "" {
+			t.Fatalf("Expected redirect with code, got %d: %s", resp.StatusCode, resp.Header.Get("Location"))
+		}
+		var sessionCookie *http.Cookie
+		for _, c := range resp.Cookies() {
+			if c.Name == session.SessionIDCookieName {
+				sessionCookie = c
+			}
+		}
+		resp = do("GET", "/account/apps", "", sessionCookie)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("Expected status %d for /account/apps, got %d", http.StatusOK, resp.StatusCode)
+		}
+	})
+
+	t.Run("誤ったパスワードと組み込みのユーザーではログインできない", func(t *testing.T) {
+		for _, credentials := range [][2]string{{"alice@example.com", "wrong-password"}, {"test-user@example.com", "password"}} {
+			resp := login(credentials[0], credentials[1])
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusUnauthorized {
+				t.Errorf("%s: Expected status %d, got %d", credentials[0], http.StatusUnauthorized, resp.StatusCode)
+			}
+		}
+	})
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"oauth-tutorial/internal/config"
+	"oauth-tutorial/internal/domain"
+	"oauth-tutorial/internal/infrastructure/oidc/oidctest"
+	"oauth-tutorial/internal/session"
+	"oauth-tutorial/pkg/mylogger"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_上流のIdPでのログイン統合テスト(t *testing.T) {
+	// given: テスト用のIdPと、IdPを設定した認可サーバー
+	idp := oidctest.NewServer(t, "oauth-tutorial", "idp-secret")
+	idp.SetUser(map[string]any{"sub": "alice-at-idp", "email": "alice@corp.example.com", "email_verified": true})
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfigFile(t, path, fmt.Sprintf(`
+server:
+  issuer: https://auth.example.com
+scopes: [read, write]
+clients:
+  - id: partner
+    name: パートナー
+    type: confidential
+    secret: secret
+    redirect_uris: [https://partner.example.com/callback]
+identity_providers:
+  - name: corp
+    display_name: 社内アカウント
+    issuer: %s
+    client_id: oauth-tutorial
+    client_secret: idp-secret
+`, idp.Issuer()))
+	cfg, err := config.Load(path, func(string) string { return "" })
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	realms, err := openRealms(mylogger.NewMockLogger(), cfg, renderer)
+	if err != nil {
+		t.Fatalf("openRealms() error = %v", err)
+	}
+	defer closeRealms(realms)
+	server := httptest.NewServer(newRealmRouter(realms))
+	defer server.Close()
+
+	client := &http.Client{
+		Timeout: 5 * time.Second,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	do := func(method, target, body string, cookies ...*http.Cookie) *http.Response {
+		req, _ := http.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		return resp
+	}
+	decode := func(resp *http.Response) map[string]any {
+		defer resp.Body.Close()
+		var v map[string]any
+		if err := json.NewDecoder(resp.Body).Decode(&v); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		return v
+	}
+	sessionCookie := func(resp *http.Response) *http.Cookie {
+		for _, c := range resp.Cookies() {
+			if c.Name == session.SessionIDCookieName {
+				return c
+			}
+		}
+		t.Fatalf("Expected session cookie, got none")
+		return nil
+	}
+	// 認可リクエストからIdPでのログインを経て、コールバックのレスポンスを返す
+	loginWithIdP := func(t *testing.T) *http.Response {
+		t.Helper()
+		resp := do("GET", server.URL+"/authorize?response_type=code&client_id=partner&redirect_uri=https://partner.example.com/callback&state=xyz&scope=read", "")
+		cookie := sessionCookie(resp)
+		authorizeResult := decode(resp)
+
+		resp = do("POST", server.URL+"/federation/login", fmt.Sprintf("provider=corp&transaction_id=%s&csrf_token=%s", authorizeResult["transaction_id"], authorizeResult["csrf_token"]), cookie)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusSeeOther || !strings.HasPrefix(resp.Header.Get("Location"), idp.Issuer()+"/authorize?") {
+			t.Fatalf("Expected redirect to the identity provider, got %d: %s", resp.StatusCode, resp.Header.Get("Location"))
+		}
+
+		// IdPはログインしたユーザーの認可コードを付けてリダイレクトURIにリダイレクトする
+		resp = do("GET", resp.Header.Get("Location"), "")
+		resp.Body.Close()
+		callback, _ := url.Parse(resp.Header.Get("Location"))
+		if callback == nil || callback.Scheme+"://"+callback.Host+callback.Path != "https://auth.example.com/federation/callback" {
+			t.Fatalf("Expected redirect to the callback, got %q", resp.Header.Get("Location"))
+		}
+		return do("GET", server.URL+"/federation/callback?"+callback.RawQuery, "", cookie)
+	}
+
+	t.Run("初回のログインでユーザーを作成し、同意の後に認可コードを発行する", func(t *testing.T) {
+		// when
+		resp := loginWithIdP(t)
+
+		// then
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d", http.StatusOK, resp.StatusCode)
+		}
+		cookie := sessionCookie(resp)
+		consentResult := decode(resp)
+		if consentResult["prompt"] != "consent" {
+			t.Fatalf("Expected consent prompt, got %v", consentResult)
+		}
+		resp = do("POST", server.URL+"/decision", fmt.Sprintf("approved=true&transaction_id=%s&csrf_token=%s", consentResult["transaction_id"], consentResult["csrf_token"]), cookie)
+		resp.Body.Close()
+		location, _ := url.Parse(resp.Header.Get("Location"))
+		if resp.StatusCode != http.StatusSeeOther || location.Query().Get("code") == "" {
+			t.Fatalf("Expected redirect with code, got %d: %s", resp.StatusCode, resp.Header.Get("Location"))
+		}
+
+		identity, _ := domain.NewFederatedIdentity(idp.Issuer(), "alice-at-idp")
+		user, err := realms[0].stores.users.FindByFederatedIdentity(identity)
+		if err != nil {
+			t.Fatalf("Expected federated user to be created: %v", err)
+		}
+		if user.LoginID() != "corp:alice@corp.example.com" {
+			t.Errorf("Expected login id %q, got %q", "corp:alice@corp.example.com", user.LoginID())
+		}
+	})
+
+	t.Run("同意済みの場合は同じユーザーで認可コードを発行する", func(t *testing.T) {
+		// when
+		resp := loginWithIdP(t)
+		resp.Body.Close()
+
+		// then
+		location, _ := url.Parse(resp.Header.Get("Location"))
+		if resp.StatusCode != http.StatusSeeOther || location.Query().Get("code") == "" {
+			t.Fatalf("Expected redirect with code, got %d: %s", resp.StatusCode, resp.Header.Get("Location"))
+		}
+		code, err := realms[0].stores.authCode.FindByCode(location.Query().Get("code"))
+		if err != nil {
+			t.Fatalf("FindByCode() error = %v", err)
+		}
+		identity, _ := domain.NewFederatedIdentity(idp.Issuer(), "alice-at-idp")
+		user, _ := realms[0].stores.users.FindByFederatedIdentity(identity)
+		if code.UserID() != user.UserID() {
+			t.Errorf("Expected code for user %q, got %q", user.UserID(), code.UserID())
+		}
+	})
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"oauth-tutorial/internal/config"
+	"oauth-tutorial/pkg/mylogger"
+	"os"
+	"path/filepath"
+)
+
+// hash-passwordsサブコマンド。環境変数CONFIG_FILEの設定ファイルのusersの平文のpasswordを、
+// password_hashingの設定でハッシュ化したpassword_hashに置き換える
+// 置き換えた内容を設定として読み込めることを確認してから、元のファイルを置き換える
+// 続けてレルム毎に永続化の実装を開き、以前のバージョンで保存した平文のパスワードも置き換える
+func runHashPasswords(logger mylogger.Logger) int {
+	path := os.Getenv("CONFIG_FILE")
+	if path == "" {
+		logger.Error("CONFIG_FILE is required")
+		return 1
+	}
+	cfg, err := loadConfig()
+	if err != nil {
+		logger.Error("invalid configuration", "err", err)
+		return 1
+	}
+	if err := hashPasswordsInFile(logger, path, cfg); err != nil {
+		logger.Error("failed to hash passwords", "file", path, "err", err)
+		return 1
+	}
+	if cfg, err = loadConfig(); err != nil {
+		logger.Error("invalid configuration", "err", err)
+		return 1
+	}
+	code := 0
+	for _, name := range append([]string{""}, cfg.RealmNames()...) {
+		realmLogger := logger
+		if name != "" {
+			realmLogger = mylogger.With(logger, "realm", name)
+		}
+		if hashPasswordsInStorage(realmLogger, realmConfig(cfg, name)) != 0 {
+			code = 1
+		}
+	}
+	return code
+}
+
+// 永続化の実装を開いた時点で平文のパスワードを置き換えるため、開いてスナップショットを保存し直す
+func hashPasswordsInStorage(logger mylogger.Logger, cfg *config.Config) int {
+	st, snap, err := openStoresFromConfig(logger, cfg)
+	if err != nil {
+		logger.Error("failed to open storage", "err", err)
+		return 1
+	}
+	defer st.close()
+	if snap != nil {
+		if err := snap.save(); err != nil {
+			return 1
+		}
+	}
+	return 0
+}
+
+func hashPasswordsInFile(logger mylogger.Logger, path string, cfg *config.Config) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	hashed, migration, err := config.HashPlaintextPasswords(data, cfg.PasswordHasher())
+	if err != nil {
+		return err
+	}
+	for _, field := range migration.Skipped {
+		logger.Info("password_file is not rewritten; the password is hashed when loaded", "user", field)
+	}
+	if len(migration.Hashed) == 0 {
+		logger.Info("no plaintext passwords found")
+		return nil
+	}
+
+	// 同じディレクトリの一時ファイルに書き出してから置き換える
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(hashed); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), info.Mode().Perm()); err != nil {
+		return err
+	}
+	if _, err := config.Load(tmp.Name(), os.Getenv); err != nil {
+		return fmt.Errorf("rewritten config is invalid: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	logger.Info("replaced plaintext passwords with password_hash", "file", path, "users", migration.Hashed)
+	return nil
+}
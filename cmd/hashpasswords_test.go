@@ -0,0 +1,125 @@
+package main
+
+import (
+	"oauth-tutorial/internal/config"
+	"oauth-tutorial/internal/domain"
+	"oauth-tutorial/pkg/mylogger"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_hash_passwordsサブコマンド(t *testing.T) {
+	// given: 平文のパスワードのユーザーを書いた設定ファイル
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfigFile(t, path, `
+password_hashing:
+  memory: 64
+  iterations: 1
+  parallelism: 1
+users:
+  - id: user-1
+    login_id: user@example.com
+    password: user-password
+`)
+	cfg, err := config.Load(path, func(string) string { return "" })
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	// 置き換える前も、永続化の実装にはハッシュ値を保存する
+	st, err := openStores(cfg)
+	if err != nil {
+		t.Fatalf("openStores() error = %v", err)
+	}
+	before, err := st.users.FindByLoginID("user@example.com")
+	if err != nil || !strings.HasPrefix(before.PasswordHash(), "$argon2id$") {
+		t.Fatalf("FindByLoginID() = %+v, %v", before, err)
+	}
+
+	// when
+	err = hashPasswordsInFile(mylogger.NewMockLogger(), path, cfg)
+
+	// then
+	if err != nil {
+		t.Fatalf("hashPasswordsInFile() error = %v", err)
+	}
+	data, _ := os.ReadFile(path)
+	if strings.Contains(string(data), "user-password") {
+		t.Errorf("plaintext password remains:\n%s", data)
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0o600 {
+		t.Errorf("Mode() = %v, want 0600", info.Mode().Perm())
+	}
+	rewritten, err := config.Load(path, func(string) string { return "" })
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	st, err = openStores(rewritten)
+	if err != nil {
+		t.Fatalf("openStores() error = %v", err)
+	}
+	authenticator, err := newAuthenticator(mylogger.NewMockLogger(), rewritten, st)
+	if err != nil {
+		t.Fatalf("newAuthenticator() error = %v", err)
+	}
+	if result, err := authenticator.Authenticate("user@example.com", "user-password"); err != nil || !result.Succeeded() {
+		t.Errorf("Authenticate() = %+v, %v", result, err)
+	}
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	if len(entries) != 1 {
+		t.Errorf("temporary file remains: %v", entries)
+	}
+}
+
+func Test_保存先の平文のパスワードの置き換え(t *testing.T) {
+	// given: 以前のバージョンで平文のパスワードを保存したsqliteの保存先(設定ファイルにはないユーザー)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	writeConfigFile(t, path, `
+storage:
+  driver: sqlite
+  dsn: `+filepath.Join(dir, "oauth.db")+`
+password_hashing:
+  memory: 64
+  iterations: 1
+  parallelism: 1
+`)
+	cfg, err := config.Load(path, func(string) string { return "" })
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	st, err := openStores(cfg)
+	if err != nil {
+		t.Fatalf("openStores() error = %v", err)
+	}
+	if err := st.users.Save(domain.ReconstructUser("legacy-user", "legacy@example.com", "legacy-password")); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	st.close()
+
+	// when
+	t.Setenv("CONFIG_FILE", path)
+	code := runHashPasswords(mylogger.NewMockLogger())
+
+	// then
+	if code != 0 {
+		t.Fatalf("runHashPasswords() = %d, want 0", code)
+	}
+	st, err = openStores(cfg)
+	if err != nil {
+		t.Fatalf("openStores() error = %v", err)
+	}
+	defer st.close()
+	user, err := st.users.FindByLoginID("legacy@example.com")
+	if err != nil || !strings.HasPrefix(user.PasswordHash(), "$argon2id$") {
+		t.Fatalf("FindByLoginID() = %+v, %v", user, err)
+	}
+	authenticator, err := newAuthenticator(mylogger.NewMockLogger(), cfg, st)
+	if err != nil {
+		t.Fatalf("newAuthenticator() error = %v", err)
+	}
+	if result, err := authenticator.Authenticate("legacy@example.com", "legacy-password"); err != nil || !result.Succeeded() {
+		t.Errorf("Authenticate() = %+v, %v", result, err)
+	}
+}
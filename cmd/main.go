@@ -1,42 +1,195 @@
 package main
 
 import (
-	"log"
+	"context"
+	"errors"
+	"expvar"
 	"net/http"
+	"oauth-tutorial/internal/config"
 	"oauth-tutorial/internal/infrastructure"
-	pAuthorize "oauth-tutorial/internal/presentation/authorize"
-	pDecision "oauth-tutorial/internal/presentation/decision"
-	pToken "oauth-tutorial/internal/presentation/token"
-	"oauth-tutorial/internal/session"
-	uAuthorize "oauth-tutorial/internal/usecase/authorize"
-	uDecision "oauth-tutorial/internal/usecase/decision"
-	"oauth-tutorial/pkg/mycrypto"
+	"oauth-tutorial/internal/presentation/view"
 	"oauth-tutorial/pkg/mylogger"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+const (
+	// 停止時に処理中のリクエストを待つ時間
+	shutdownTimeout = 10 * time.Second
 )
 
 func main() {
 	// ロガー構築
 	logger := mylogger.NewLogger()
-	logger.Info("start server")
 
-	// 認可リクエストのためのコンポーネントを初期化
-	cr := infrastructure.NewClientRepository()
-	sig := session.NewSessionIDGenerator()
-	ss := infrastructure.NewSessionStorage()
-	acf := uAuthorize.NewAuthorizationCodeFlow(logger, cr, sig, ss)
+	// 有効期限切れのデータを1回だけ削除して終了する
+	if len(os.Args) > 1 && os.Args[1] == "purge" {
+		os.Exit(runPurge(logger))
+	}
+	// 設定ファイルの平文のパスワードをハッシュ値に置き換えて終了する
+	if len(os.Args) > 1 && os.Args[1] == "hash-passwords" {
+		os.Exit(runHashPasswords(logger))
+	}
+
+	// 設定(環境変数CONFIG_FILEで指定したYAMLまたはJSONのファイルを、環境変数で上書きしたもの)
+	cfg, err := loadConfig()
+	if err != nil {
+		logger.Error("invalid configuration", "err", err)
+		os.Exit(1)
+	}
+	logger.Info("start server", "addr", cfg.Server.Addr, "issuer", cfg.Issuer(), "tls", cfg.Server.TLS.Enabled(), "storage", cfg.Storage.Driver, "realms", cfg.RealmNames())
 
-	// 認可コード発行のためのコンポーネントを初期化
-	rg := &mycrypto.RandomGenerator{}
-	ur := infrastructure.NewUserRepository()
-	ar := infrastructure.NewAuthCodeRepository()
-	pac := uDecision.NewPublishAuthorizationCodeUseCase(logger, rg, ss, ur, ar)
+	// HTML画面のテンプレート(server.template_dirのディレクトリに同名のファイルがあれば、埋め込みのテンプレートの代わりに使用する)
+	renderer, err := view.NewRenderer(cfg.Server.TemplateDir)
+	if err != nil {
+		logger.Error("failed to load templates", "err", err)
+		os.Exit(1)
+	}
 
-	// ハンドラーの登録
-	http.Handle("GET /authorize", pAuthorize.NewAuthorizeHandler(logger, acf))
-	http.Handle("POST /decision", pDecision.NewDecisionHandler(logger, pac))
-	http.Handle("POST /token", pToken.NewTokenHandler(logger))
+	// 既定のレルムと設定したレルム毎に、永続化の実装・スコープ・鍵を分けてハンドラーを登録する
+	// /debug/varsを公開しないよう、http.DefaultServeMuxは使わない
+	realms, err := openRealms(logger, cfg, renderer)
+	if err != nil {
+		logger.Error("failed to open realms", "err", err)
+		os.Exit(1)
+	}
+	defer closeRealms(realms)
+	for _, r := range realms {
+		// storage.snapshot.intervalの間隔と停止時にスナップショットを保存する
+		if r.snap != nil {
+			stopSnapshot := infrastructure.RunPeriodically(cfg.Storage.Snapshot.Interval, func() { r.snap.save() })
+			defer stopSnapshot()
+		}
+		// 有効期限切れのデータを定期的に削除する
+		stopPurger := infrastructure.RunPeriodically(cfg.Storage.Purge.Interval, func() { r.purger.Run(time.Now()) })
+		defer stopPurger()
+		// セッション数や有効期間切れによる破棄数、削除した数などを管理用のサーバーの/debug/varsで公開する
+		expvar.Publish(realmVarName("sessions", r), expvar.Func(func() any {
+			stats, err := r.stores.sessions.Stats()
+			if err != nil {
+				logger.Error("failed to get session stats", "realm", r.name, "err", err)
+				return nil
+			}
+			return stats
+		}))
+		expvar.Publish(realmVarName("purge", r), expvar.Func(func() any { return r.purger.Stats() }))
+	}
 
 	// サーバーの起動
-	logger.Info("Listening on :8080")
-	log.Fatal(http.ListenAndServe(":8080", nil))
+	server := &http.Server{Addr: cfg.Server.Addr, Handler: newRealmRouter(realms)}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// SIGHUPを受け取った場合と設定ファイルが変更された場合に、レルム毎のクライアントとスコープを読み込み直す
+	configPath := os.Getenv("CONFIG_FILE")
+	reloader := newConfigReloader(logger, configPath, os.Getenv, realms, cfg)
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	go func() {
+		for {
+			select {
+			case <-hup:
+				reloader.reload(reloadTriggerSIGHUP)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	if configPath != "" {
+		stopWatch := infrastructure.RunPeriodically(configWatchInterval, func() { reloader.reloadIfChanged() })
+		defer stopWatch()
+	}
+	// server.metrics_addrを指定した場合のみ、レルムとは別のアドレスで/debug/varsを公開する
+	var metricsServer *http.Server
+	if cfg.Server.MetricsAddr != "" {
+		metricsServer = newMetricsServer(cfg.Server.MetricsAddr)
+		go func() {
+			logger.Info("Serving metrics on " + cfg.Server.MetricsAddr)
+			if err := metricsServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				logger.Error("metrics server stopped", "err", err)
+				stop()
+			}
+		}()
+	}
+	go func() {
+		logger.Info("Listening on " + cfg.Server.Addr)
+		var err error
+		if cfg.Server.TLS.Enabled() {
+			err = server.ListenAndServeTLS(cfg.Server.TLS.CertFile, cfg.Server.TLS.KeyFile)
+		} else {
+			err = server.ListenAndServe()
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("server stopped", "err", err)
+			stop()
+		}
+	}()
+	<-ctx.Done()
+
+	// 処理中のリクエストを待ってから停止する
+	logger.Info("shutting down server")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		logger.Error("failed to shut down server", "err", err)
+	}
+	if metricsServer != nil {
+		if err := metricsServer.Shutdown(shutdownCtx); err != nil {
+			logger.Error("failed to shut down metrics server", "err", err)
+		}
+	}
+	for _, r := range realms {
+		if r.snap != nil {
+			r.snap.save()
+		}
+	}
+}
+
+// purgeサブコマンド。サーバーと同じ設定で永続化の実装を開き、有効期限切れのデータを削除する
+// スナップショットを使う場合は、復元した内容から削除した結果を保存し直す。レルム毎に削除する
+func runPurge(logger mylogger.Logger) int {
+	cfg, err := loadConfig()
+	if err != nil {
+		logger.Error("invalid configuration", "err", err)
+		return 1
+	}
+	code := 0
+	for _, name := range append([]string{""}, cfg.RealmNames()...) {
+		realmLogger := logger
+		if name != "" {
+			realmLogger = mylogger.With(logger, "realm", name)
+		}
+		if purgeRealm(realmLogger, realmConfig(cfg, name)) != 0 {
+			code = 1
+		}
+	}
+	return code
+}
+
+func purgeRealm(logger mylogger.Logger, cfg *config.Config) int {
+	st, snap, err := openStoresFromConfig(logger, cfg)
+	if err != nil {
+		logger.Error("failed to open storage", "err", err)
+		return 1
+	}
+	defer st.close()
+
+	result := infrastructure.NewPurger(logger, cfg.Storage.Purge.BatchSize, purgeTargets(st, nil, nil, nil)...).Run(time.Now())
+	if snap != nil {
+		if err := snap.save(); err != nil {
+			return 1
+		}
+	}
+	if result.Failures > 0 {
+		return 1
+	}
+	return 0
+}
+
+// 環境変数CONFIG_FILEの設定ファイルを読み込み、環境変数で上書きする。未指定の場合は既定値に環境変数を反映する
+func loadConfig() (*config.Config, error) {
+	return config.Load(os.Getenv("CONFIG_FILE"), os.Getenv)
 }
@@ -6,14 +6,22 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"oauth-tutorial/internal/domain"
 	"oauth-tutorial/internal/infrastructure"
 	"oauth-tutorial/internal/infrastructure/dto"
+	"oauth-tutorial/internal/presentation"
+	pAccount "oauth-tutorial/internal/presentation/account"
 	pAuthorize "oauth-tutorial/internal/presentation/authorize"
 	pDecision "oauth-tutorial/internal/presentation/decision"
+	pToken "oauth-tutorial/internal/presentation/token"
+	"oauth-tutorial/internal/presentation/view"
 	"oauth-tutorial/internal/session"
+	uAccount "oauth-tutorial/internal/usecase/account"
 	uAuthorize "oauth-tutorial/internal/usecase/authorize"
 	uDecision "oauth-tutorial/internal/usecase/decision"
+	uToken "oauth-tutorial/internal/usecase/token"
+	"oauth-tutorial/pkg/mycrypto"
 	"oauth-tutorial/pkg/mylogger"
 	"strings"
 	"testing"
@@ -21,25 +29,45 @@ import (
 )
 
 const (
-	mockSessionID = session.SessionID("mock-session-id")
+	testIssuer        = "https://auth.example.com"
+	mockSessionID     = session.SessionID("mock-session-id")
+	mockTransactionID = session.TransactionID("mock-transaction-id")
 )
 
+var renderer = func() *view.Renderer {
+	r, err := view.NewRenderer("")
+	if err != nil {
+		panic(err)
+	}
+	return r
+}()
+
+var csrfProtector = presentation.NewCSRFProtector([]byte("test-secret"))
+
 type MockSessionIDGenerator struct{}
 
 func (m *MockSessionIDGenerator) Generate() session.SessionID {
 	return mockSessionID
 }
 
+type MockTransactionIDGenerator struct{}
+
+func (m *MockTransactionIDGenerator) Generate() session.TransactionID {
+	return mockTransactionID
+}
+
 func Test_認可リクエスト統合テスト(t *testing.T) {
 	// given
 	logger := mylogger.NewLogger()
 	cr := infrastructure.NewClientRepository()
 	sig := &MockSessionIDGenerator{}
-	ss := infrastructure.NewSessionStorage()
-	acf := uAuthorize.NewAuthorizationCodeFlow(logger, cr, sig, ss)
+	ss := infrastructure.NewSessionStorage(0, 0)
+	ts := infrastructure.NewTransactionStorage()
+	ar := infrastructure.NewAuthCodeRepository()
+	acf := uAuthorize.NewAuthorizationCodeFlow(logger, cr, sig, ss, &MockTransactionIDGenerator{}, ts, &MockAuthzCodeGenerator{}, ar, infrastructure.NewConsentRepository(), domain.DefaultLifetimes(), domain.NewScopeProvider(nil))
 
 	mux := http.NewServeMux()
-	mux.Handle("GET /authorize", pAuthorize.NewAuthorizeHandler(logger, acf))
+	mux.Handle("GET /authorize", pAuthorize.NewAuthorizeHandler(logger, acf, renderer, csrfProtector, testIssuer, domain.NewScopeProvider(nil), nil))
 
 	server := httptest.NewServer(mux)
 	defer server.Close()
@@ -81,14 +109,31 @@ func Test_認可リクエスト統合テスト(t *testing.T) {
 	if message, ok := result["message"]; !ok || message != "OK" {
 		t.Errorf("Expected message 'OK', got %v", message)
 	}
+	if transactionID := result["transaction_id"]; transactionID != string(mockTransactionID) {
+		t.Errorf("Expected transaction_id %s, got %v", mockTransactionID, transactionID)
+	}
+	if prompt := result["prompt"]; prompt != "login" {
+		t.Errorf("Expected prompt 'login', got %v", prompt)
+	}
 
-	sessiondata, err := ss.Get(mockSessionID)
+	// 未ログインのブラウザセッションが作成されていること
+	browserSession, err := ss.Get(mockSessionID)
+	if err != nil {
+		t.Errorf("Failed to get session: %v", err)
+	} else if browserSession.IsAuthenticated() {
+		t.Error("Expected anonymous session")
+	}
+
+	sessiondata, err := ts.Get(mockTransactionID)
 	if err != nil {
 		t.Errorf("Failed to get session parameter: %v", err)
 	}
 	if sessiondata == nil {
 		t.Error("Expected non-nil session parameter, got nil")
 	} else {
+		if sessiondata.SessionID() != mockSessionID {
+			t.Errorf("Expected transaction bound to session %s, got %s", mockSessionID, sessiondata.SessionID())
+		}
 		expectedResponseType, _ := domain.GetResponseType(responseType)
 		if sessiondata.AuthParam().ResponseType() != expectedResponseType {
 			t.Errorf("Expected response type %d, got %d", expectedResponseType, sessiondata.AuthParam().ResponseType())
@@ -123,26 +168,29 @@ func Test_認可コード発行統合テスト(t *testing.T) {
 	rg := &MockAuthzCodeGenerator{}
 
 	testRedirectURI := "http://callback.example.com"
-	ss := infrastructure.NewSessionStorage()
+	ss := infrastructure.NewSessionStorage(0, 0)
+	ts := infrastructure.NewTransactionStorage()
 	mockState := "mock-state"
-	param, _ := domain.NewAuthorizationCodeFlowParam(logger, "code", "client_1", testRedirectURI, "read", mockState)
-	ss.Save(mockSessionID, dto.NewSessionData(param, nil))
+	param, _ := domain.NewAuthorizationCodeFlowParam(logger, domain.DefaultScopeRegistry(), "code", "client_1", testRedirectURI, "read", mockState)
+	ss.Save(mockSessionID, dto.NewSessionData(nil, time.Time{}, nil))
+	ts.Save(dto.NewAuthorizationTransaction(mockTransactionID, mockSessionID, param, time.Now()))
 
 	ur := infrastructure.NewUserRepository()
 	ar := infrastructure.NewAuthCodeRepository()
-	pac := uDecision.NewPublishAuthorizationCodeUseCase(logger, rg, ss, ur, ar)
+	renewedSessionID := session.SessionID("renewed-session-id")
+	pac := uDecision.NewPublishAuthorizationCodeUseCase(logger, rg, ss, &fixedSessionIDGenerator{id: renewedSessionID}, ts, infrastructure.NewUserStoreAuthenticator(logger, ur, mycrypto.NewPasswordHasher(mycrypto.DefaultArgon2Params())), infrastructure.NewTOTPRepository(), ar, infrastructure.NewConsentRepository(), infrastructure.NewClientRepository(), 0, domain.DefaultLifetimes(), domain.NewScopeProvider(nil))
 
 	mux := http.NewServeMux()
-	mux.Handle("POST /decision", pDecision.NewDecisionHandler(logger, pac))
+	mux.Handle("POST /decision", pDecision.NewDecisionHandler(logger, pac, renderer, csrfProtector, testIssuer, domain.NewScopeProvider(nil), nil))
 
 	server := httptest.NewServer(mux)
 	defer server.Close()
 
 	// リクエストの準備
-	url := fmt.Sprintf("%s/decision", server.URL)
+	reqURL := fmt.Sprintf("%s/decision", server.URL)
 	header := "application/x-www-form-urlencoded"
-	requestBody := fmt.Sprintf("approved=%s&login_id=%s&password=%s", "true", "test-user@example.com", "password")
-	req, err := http.NewRequest("POST", url, strings.NewReader(requestBody))
+	requestBody := fmt.Sprintf("approved=%s&transaction_id=%s&login_id=%s&password=%s&csrf_token=%s", "true", mockTransactionID, "test-user@example.com", "password", csrfProtector.Issue(mockSessionID))
+	req, err := http.NewRequest("POST", reqURL, strings.NewReader(requestBody))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -173,7 +221,7 @@ func Test_認可コード発行統合テスト(t *testing.T) {
 	}
 
 	// リダイレクト先が正しいこと
-	expectedLocation := fmt.Sprintf("%s?code=%s&state=%s", testRedirectURI, "mock-authz-code", mockState)
+	expectedLocation := testRedirectURI + "?" + url.Values{"code": {"mock-authz-code"}, "state": {mockState}, "iss": {testIssuer}}.Encode()
 	actualLocation := resp.Header.Get("Location")
 	if actualLocation != expectedLocation {
 		t.Errorf("Expected Location header %q, got %q", expectedLocation, actualLocation)
@@ -189,15 +237,32 @@ func Test_認可コード発行統合テスト(t *testing.T) {
 		t.Errorf("Expected non-empty response body, got %q", string(result))
 	}
 
-	// 認可リクエストのパラメーターがセッションから削除されていること
+	// 認可リクエストのトランザクションが削除されていること
+	_, err = ts.Get(mockTransactionID)
+	if err != infrastructure.ErrTransactionNotFound {
+		t.Errorf("Expected ErrTransactionNotFound, got %v", err)
+	}
+
+	// ログイン前のセッションが破棄され、再生成されたセッションがログイン済みであること
 	_, err = ss.Get(mockSessionID)
-	if err == nil {
-		t.Error("Expected error when getting deleted session")
-	} else {
-		if err != infrastructure.ErrSessionNotFound {
-			t.Errorf("Expected ErrSessionNotFound, got %v", err)
+	if err != infrastructure.ErrSessionNotFound {
+		t.Errorf("Expected ErrSessionNotFound, got %v", err)
+	}
+	renewedSession, err := ss.Get(renewedSessionID)
+	if err != nil {
+		t.Errorf("Failed to get renewed session: %v", err)
+	} else if !renewedSession.IsAuthenticated() || renewedSession.User().UserID() != "IU7ewbuvey" {
+		t.Errorf("Expected renewed session to be authenticated")
+	}
+	var renewedCookie string
+	for _, c := range resp.Cookies() {
+		if c.Name == session.SessionIDCookieName {
+			renewedCookie = c.Value
 		}
 	}
+	if renewedCookie != string(renewedSessionID) {
+		t.Errorf("Expected session cookie %q, got %q", renewedSessionID, renewedCookie)
+	}
 
 	// 認可コードレポジトリに認可コードが保存されていること
 	authzCode, err := ar.FindByCode("mock-authz-code")
@@ -217,3 +282,379 @@ func Test_認可コード発行統合テスト(t *testing.T) {
 		// ...他の検証
 	}
 }
+
+type fixedSessionIDGenerator struct {
+	id session.SessionID
+}
+
+func (g *fixedSessionIDGenerator) Generate() session.SessionID {
+	return g.id
+}
+
+func Test_シングルサインオン統合テスト(t *testing.T) {
+	// given
+	logger := mylogger.NewMockLogger()
+	cr := infrastructure.NewClientRepository()
+	sig := session.NewSessionIDGenerator()
+	ss := infrastructure.NewSessionStorage(0, 0)
+	tig := session.NewTransactionIDGenerator()
+	ts := infrastructure.NewTransactionStorage()
+	rg := &mycrypto.RandomGenerator{}
+	ar := infrastructure.NewAuthCodeRepository()
+	ur := infrastructure.NewUserRepository()
+	csr := infrastructure.NewConsentRepository()
+	acf := uAuthorize.NewAuthorizationCodeFlow(logger, cr, sig, ss, tig, ts, rg, ar, csr, domain.DefaultLifetimes(), domain.NewScopeProvider(nil))
+	pac := uDecision.NewPublishAuthorizationCodeUseCase(logger, rg, ss, sig, ts, infrastructure.NewUserStoreAuthenticator(logger, ur, mycrypto.NewPasswordHasher(mycrypto.DefaultArgon2Params())), infrastructure.NewTOTPRepository(), ar, csr, cr, 0, domain.DefaultLifetimes(), domain.NewScopeProvider(nil))
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /authorize", pAuthorize.NewAuthorizeHandler(logger, acf, renderer, csrfProtector, testIssuer, domain.NewScopeProvider(nil), nil))
+	mux.Handle("POST /decision", pDecision.NewDecisionHandler(logger, pac, renderer, csrfProtector, testIssuer, domain.NewScopeProvider(nil), nil))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	client := &http.Client{
+		Timeout: 5 * time.Second,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	// テストサーバーはhttpのため、Secure属性のCookieはcookiejarでは送信されない。そのため手動で付与する
+	var sessionID string
+	authorize := func(scope string) *http.Response {
+		req, _ := http.NewRequest("GET", server.URL+"/authorize?response_type=code&client_id=iouobrnea&redirect_uri=https://client.example.com/callback&state=xyz&scope="+scope, nil)
+		if sessionID != "" {
+			req.AddCookie(&http.Cookie{Name: session.SessionIDCookieName, Value: sessionID})
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		for _, c := range resp.Cookies() {
+			if c.Name == session.SessionIDCookieName {
+				sessionID = c.Value
+			}
+		}
+		return resp
+	}
+	decide := func(body string) *http.Response {
+		req, _ := http.NewRequest("POST", server.URL+"/decision", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.AddCookie(&http.Cookie{Name: session.SessionIDCookieName, Value: sessionID})
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		for _, c := range resp.Cookies() {
+			if c.Name == session.SessionIDCookieName {
+				sessionID = c.Value
+			}
+		}
+		return resp
+	}
+	decodeAuthorize := func(resp *http.Response) map[string]string {
+		defer resp.Body.Close()
+		var result map[string]string
+		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		return result
+	}
+
+	// when: 1回目の認可リクエストはログインを求められる
+	first := decodeAuthorize(authorize("read"))
+	if first["prompt"] != "login" {
+		t.Fatalf("Expected prompt login, got %v", first["prompt"])
+	}
+	anonymousSessionID := sessionID
+
+	resp := decide("approved=true&transaction_id=" + first["transaction_id"] + "&csrf_token=" + first["csrf_token"] + "&login_id=test-user@example.com&password=password")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusSeeOther {
+		t.Fatalf("Expected status %d, got %d", http.StatusSeeOther, resp.StatusCode)
+	}
+	// then: ログイン時にセッションIDが再生成されていること
+	if sessionID == anonymousSessionID {
+		t.Error("Expected session ID to be regenerated at login")
+	}
+
+	// when: 同じスコープでの2回目の認可リクエストは、ログインも同意も求められずに認可コードが発行される
+	resp = authorize("read")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("Expected status %d, got %d", http.StatusFound, resp.StatusCode)
+	}
+	location, _ := url.Parse(resp.Header.Get("Location"))
+	if _, err := ar.FindByCode(location.Query().Get("code")); err != nil {
+		t.Errorf("Expected authorization code to be saved: %v", err)
+	}
+
+	// when: 新しいスコープを要求すると同意のみを求められ、パスワード無しで認可コードが発行される
+	third := decodeAuthorize(authorize("read%20write"))
+	if third["prompt"] != "consent" {
+		t.Fatalf("Expected prompt consent, got %v", third["prompt"])
+	}
+	resp = decide("approved=true&transaction_id=" + third["transaction_id"] + "&csrf_token=" + third["csrf_token"])
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusSeeOther {
+		t.Fatalf("Expected status %d, got %d", http.StatusSeeOther, resp.StatusCode)
+	}
+	location, _ = url.Parse(resp.Header.Get("Location"))
+	code, err := ar.FindByCode(location.Query().Get("code"))
+	if err != nil {
+		t.Fatalf("Expected authorization code to be saved: %v", err)
+	}
+	if code.UserID() != "IU7ewbuvey" {
+		t.Errorf("Expected user ID %q, got %q", "IU7ewbuvey", code.UserID())
+	}
+
+	// when: 別のブラウザでログインした場合も、同意済みのスコープであれば同意画面を省略して認可コードが発行される
+	sessionID = ""
+	fourth := decodeAuthorize(authorize("write"))
+	if fourth["prompt"] != "login" {
+		t.Fatalf("Expected prompt login, got %v", fourth["prompt"])
+	}
+	resp = decide("transaction_id=" + fourth["transaction_id"] + "&csrf_token=" + fourth["csrf_token"] + "&login_id=test-user@example.com&password=password")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusSeeOther {
+		t.Fatalf("Expected status %d, got %d", http.StatusSeeOther, resp.StatusCode)
+	}
+
+	// when: 同意していないスコープを含む場合は、ログイン後に同意を求められる
+	csr.Delete("IU7ewbuvey", "iouobrnea")
+	sessionID = ""
+	fifth := decodeAuthorize(authorize("read"))
+	resp = decide("transaction_id=" + fifth["transaction_id"] + "&csrf_token=" + fifth["csrf_token"] + "&login_id=test-user@example.com&password=password")
+	consentResult := decodeAuthorize(resp)
+	if resp.StatusCode != http.StatusOK || consentResult["prompt"] != "consent" {
+		t.Fatalf("Expected consent prompt, got status %d, body %v", resp.StatusCode, consentResult)
+	}
+	resp = decide("approved=true&transaction_id=" + consentResult["transaction_id"] + "&csrf_token=" + consentResult["csrf_token"])
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusSeeOther {
+		t.Fatalf("Expected status %d, got %d", http.StatusSeeOther, resp.StatusCode)
+	}
+	if _, err := csr.FindByUserAndClient("IU7ewbuvey", "iouobrnea"); err != nil {
+		t.Errorf("Expected consent to be saved: %v", err)
+	}
+}
+
+func Test_連携中のアプリの管理統合テスト(t *testing.T) {
+	// given
+	logger := mylogger.NewMockLogger()
+	cr := infrastructure.NewClientRepository()
+	sig := session.NewSessionIDGenerator()
+	ss := infrastructure.NewSessionStorage(0, 0)
+	tig := session.NewTransactionIDGenerator()
+	ts := infrastructure.NewTransactionStorage()
+	rg := &mycrypto.RandomGenerator{}
+	ar := infrastructure.NewAuthCodeRepository()
+	ur := infrastructure.NewUserRepository()
+	csr := infrastructure.NewConsentRepository()
+	tr := infrastructure.NewTokenRespository()
+	acf := uAuthorize.NewAuthorizationCodeFlow(logger, cr, sig, ss, tig, ts, rg, ar, csr, domain.DefaultLifetimes(), domain.NewScopeProvider(nil))
+	pac := uDecision.NewPublishAuthorizationCodeUseCase(logger, rg, ss, sig, ts, infrastructure.NewUserStoreAuthenticator(logger, ur, mycrypto.NewPasswordHasher(mycrypto.DefaultArgon2Params())), infrastructure.NewTOTPRepository(), ar, csr, cr, 0, domain.DefaultLifetimes(), domain.NewScopeProvider(nil))
+	pts := uToken.NewPublishTokenStrategy(logger, cr, ar, tr, csr, domain.DefaultLifetimes(), domain.NewScopeProvider(nil))
+	accountHandler := pAccount.NewAccountHandler(logger, uAccount.NewListConnectedAppsUseCase(logger, ss, cr, csr, tr), uAccount.NewRevokeConnectedAppUseCase(logger, ss, csr, tr, ar), renderer, csrfProtector)
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /authorize", pAuthorize.NewAuthorizeHandler(logger, acf, renderer, csrfProtector, testIssuer, domain.NewScopeProvider(nil), nil))
+	mux.Handle("POST /decision", pDecision.NewDecisionHandler(logger, pac, renderer, csrfProtector, testIssuer, domain.NewScopeProvider(nil), nil))
+	mux.Handle("POST /token", pToken.NewTokenHandler(logger, *pts))
+	mux.HandleFunc("GET /account/apps", accountHandler.ServeListAPI)
+	mux.HandleFunc("DELETE /account/apps/{client_id}", accountHandler.ServeRevokeAPI)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	client := &http.Client{
+		Timeout: 5 * time.Second,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	var sessionID string
+	do := func(method, path, body string) *http.Response {
+		req, _ := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if sessionID != "" {
+			req.AddCookie(&http.Cookie{Name: session.SessionIDCookieName, Value: sessionID})
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		for _, c := range resp.Cookies() {
+			if c.Name == session.SessionIDCookieName {
+				sessionID = c.Value
+			}
+		}
+		return resp
+	}
+	decode := func(resp *http.Response, v any) {
+		defer resp.Body.Close()
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+	}
+
+	// 未ログインでは一覧を取得できない
+	resp := do("GET", "/account/apps", "")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Expected status %d, got %d", http.StatusUnauthorized, resp.StatusCode)
+	}
+
+	// ログインして一部のスコープに同意し、トークンを取得する
+	var authorizeResult map[string]string
+	decode(do("GET", "/authorize?response_type=code&client_id=iouobrnea&redirect_uri=https://client.example.com/callback&state=xyz&scope=read%20write", ""), &authorizeResult)
+	resp = do("POST", "/decision", "approved=true&scope=read&transaction_id="+authorizeResult["transaction_id"]+"&csrf_token="+authorizeResult["csrf_token"]+"&login_id=test-user@example.com&password=password")
+	resp.Body.Close()
+	location, _ := url.Parse(resp.Header.Get("Location"))
+	resp = do("POST", "/token", "grant_type=authorization_code&client_id=iouobrnea&client_secret=password&redirect_uri=https://client.example.com/callback&code="+location.Query().Get("code"))
+	var tokenResult map[string]any
+	decode(resp, &tokenResult)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %v", http.StatusOK, resp.StatusCode, tokenResult)
+	}
+	if tokenResult["scope"] != "read" {
+		t.Errorf("Expected scope %q, got %v", "read", tokenResult["scope"])
+	}
+
+	// when: 連携中のアプリの一覧を取得する
+	var apps pAccount.ConnectedAppsResponse
+	decode(do("GET", "/account/apps", ""), &apps)
+
+	// then: 同意したスコープ、利用日時、有効なリフレッシュトークンが表示されること
+	if len(apps.Apps) != 1 {
+		t.Fatalf("Expected 1 connected app, got %d", len(apps.Apps))
+	}
+	app := apps.Apps[0]
+	if app.ClientID != "iouobrnea" || app.ClientName != "client-1" {
+		t.Errorf("Expected client iouobrnea (client-1), got %s (%s)", app.ClientID, app.ClientName)
+	}
+	if len(app.Scopes) != 1 || app.Scopes[0] != "read" {
+		t.Errorf("Expected scopes [read], got %v", app.Scopes)
+	}
+	if app.FirstUsedAt == "" || app.LastUsedAt == "" {
+		t.Errorf("Expected first and last used at, got %q, %q", app.FirstUsedAt, app.LastUsedAt)
+	}
+	if len(app.RefreshTokens) != 1 {
+		t.Errorf("Expected 1 refresh token, got %d", len(app.RefreshTokens))
+	}
+
+	// when: 連携を解除する
+	resp = do("DELETE", "/account/apps/iouobrnea", "")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("Expected status %d, got %d", http.StatusNoContent, resp.StatusCode)
+	}
+
+	// then: 一覧から消え、発行済みのリフレッシュトークンは使えなくなること
+	decode(do("GET", "/account/apps", ""), &apps)
+	if len(apps.Apps) != 0 {
+		t.Errorf("Expected no connected app, got %v", apps.Apps)
+	}
+	resp = do("POST", "/token", fmt.Sprintf("grant_type=refresh_token&client_id=iouobrnea&client_secret=password&refresh_token=%s", tokenResult["refresh_token"]))
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected status %d, got %d", http.StatusBadRequest, resp.StatusCode)
+	}
+	if _, err := tr.FindByAccessToken(tokenResult["access_token"].(string)); err == nil {
+		t.Error("Expected access token to be revoked")
+	}
+}
+
+func Test_認可コードの再利用統合テスト(t *testing.T) {
+	// given
+	logger := mylogger.NewMockLogger()
+	cr := infrastructure.NewClientRepository()
+	sig := session.NewSessionIDGenerator()
+	ss := infrastructure.NewSessionStorage(0, 0)
+	tig := session.NewTransactionIDGenerator()
+	ts := infrastructure.NewTransactionStorage()
+	rg := &mycrypto.RandomGenerator{}
+	ar := infrastructure.NewAuthCodeRepository()
+	ur := infrastructure.NewUserRepository()
+	csr := infrastructure.NewConsentRepository()
+	tr := infrastructure.NewTokenRespository()
+	acf := uAuthorize.NewAuthorizationCodeFlow(logger, cr, sig, ss, tig, ts, rg, ar, csr, domain.DefaultLifetimes(), domain.NewScopeProvider(nil))
+	pac := uDecision.NewPublishAuthorizationCodeUseCase(logger, rg, ss, sig, ts, infrastructure.NewUserStoreAuthenticator(logger, ur, mycrypto.NewPasswordHasher(mycrypto.DefaultArgon2Params())), infrastructure.NewTOTPRepository(), ar, csr, cr, 0, domain.DefaultLifetimes(), domain.NewScopeProvider(nil))
+	pts := uToken.NewPublishTokenStrategy(logger, cr, ar, tr, csr, domain.DefaultLifetimes(), domain.NewScopeProvider(nil))
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /authorize", pAuthorize.NewAuthorizeHandler(logger, acf, renderer, csrfProtector, testIssuer, domain.NewScopeProvider(nil), nil))
+	mux.Handle("POST /decision", pDecision.NewDecisionHandler(logger, pac, renderer, csrfProtector, testIssuer, domain.NewScopeProvider(nil), nil))
+	mux.Handle("POST /token", pToken.NewTokenHandler(logger, *pts))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	client := &http.Client{
+		Timeout: 5 * time.Second,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	var sessionID string
+	do := func(method, path, body string) *http.Response {
+		req, _ := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if sessionID != "" {
+			req.AddCookie(&http.Cookie{Name: session.SessionIDCookieName, Value: sessionID})
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		for _, c := range resp.Cookies() {
+			if c.Name == session.SessionIDCookieName {
+				sessionID = c.Value
+			}
+		}
+		return resp
+	}
+	decode := func(resp *http.Response, v any) {
+		defer resp.Body.Close()
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+	}
+
+	var authorizeResult map[string]string
+	decode(do("GET", "/authorize?response_type=code&client_id=iouobrnea&redirect_uri=https://client.example.com/callback&state=xyz&scope=read", ""), &authorizeResult)
+	resp := do("POST", "/decision", "approved=true&transaction_id="+authorizeResult["transaction_id"]+"&csrf_token="+authorizeResult["csrf_token"]+"&login_id=test-user@example.com&password=password")
+	resp.Body.Close()
+	location, _ := url.Parse(resp.Header.Get("Location"))
+	exchangeCode := "grant_type=authorization_code&client_id=iouobrnea&client_secret=password&redirect_uri=https://client.example.com/callback&code=" + location.Query().Get("code")
+
+	// 認可コードでトークンを取得し、リフレッシュトークンをローテーションする
+	var issued, rotated map[string]any
+	resp = do("POST", "/token", exchangeCode)
+	decode(resp, &issued)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %v", http.StatusOK, resp.StatusCode, issued)
+	}
+	resp = do("POST", "/token", fmt.Sprintf("grant_type=refresh_token&client_id=iouobrnea&client_secret=password&refresh_token=%s", issued["refresh_token"]))
+	decode(resp, &rotated)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %v", http.StatusOK, resp.StatusCode, rotated)
+	}
+
+	// when: 同じ認可コードを再度使用する
+	var replayed map[string]any
+	resp = do("POST", "/token", exchangeCode)
+	decode(resp, &replayed)
+
+	// then: 拒否され、認可コードから発行したトークンはローテーション後のものも含めて無効になること
+	if resp.StatusCode != http.StatusBadRequest || replayed["error"] != "invalid_grant" {
+		t.Errorf("Expected %d invalid_grant, got %d: %v", http.StatusBadRequest, resp.StatusCode, replayed)
+	}
+	for _, accessToken := range []any{issued["access_token"], rotated["access_token"]} {
+		if _, err := tr.FindByAccessToken(accessToken.(string)); err == nil {
+			t.Errorf("Expected access token %v to be revoked", accessToken)
+		}
+	}
+	resp = do("POST", "/token", fmt.Sprintf("grant_type=refresh_token&client_id=iouobrnea&client_secret=password&refresh_token=%s", rotated["refresh_token"]))
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected status %d for the rotated refresh token, got %d", http.StatusBadRequest, resp.StatusCode)
+	}
+}
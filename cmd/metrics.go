@@ -0,0 +1,14 @@
+package main
+
+import (
+	"expvar"
+	"net/http"
+)
+
+// /debug/varsで統計情報を公開する管理用のサーバー
+// expvarは起動時の引数やメモリの統計も公開するため、レルムのハンドラーとは別のmuxに登録する
+func newMetricsServer(addr string) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("GET /debug/vars", expvar.Handler())
+	return &http.Server{Addr: addr, Handler: mux}
+}
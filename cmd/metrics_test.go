@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"oauth-tutorial/internal/config"
+	"oauth-tutorial/pkg/mylogger"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_統計情報は管理用のサーバーでのみ公開する(t *testing.T) {
+	// given
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfigFile(t, path, realmTestConfig)
+	cfg, err := config.Load(path, func(string) string { return "" })
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	realms, err := openRealms(mylogger.NewMockLogger(), cfg, renderer)
+	if err != nil {
+		t.Fatalf("openRealms() error = %v", err)
+	}
+	defer closeRealms(realms)
+
+	// when
+	realmRec := httptest.NewRecorder()
+	newRealmRouter(realms).ServeHTTP(realmRec, httptest.NewRequest(http.MethodGet, "/debug/vars", nil))
+	metricsRec := httptest.NewRecorder()
+	newMetricsServer("127.0.0.1:0").Handler.ServeHTTP(metricsRec, httptest.NewRequest(http.MethodGet, "/debug/vars", nil))
+
+	// then: レルムのハンドラーでは公開しない
+	if realmRec.Code != http.StatusNotFound {
+		t.Errorf("GET /debug/vars on the realm router status = %d, want %d", realmRec.Code, http.StatusNotFound)
+	}
+	if metricsRec.Code != http.StatusOK || !strings.Contains(metricsRec.Body.String(), `"memstats"`) {
+		t.Errorf("GET /debug/vars on the metrics server = %d %q, want 200 with memstats", metricsRec.Code, metricsRec.Body.String())
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"oauth-tutorial/internal/config"
+	"oauth-tutorial/internal/domain"
+	"oauth-tutorial/internal/infrastructure/webauthn/webauthntest"
+	"oauth-tutorial/internal/session"
+	"oauth-tutorial/pkg/mylogger"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_パスキーによるログイン統合テスト(t *testing.T) {
+	// given: SQLiteに保存する認可サーバー。RP IDは認可サーバーの識別子のホスト名
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	writeConfigFile(t, path, fmt.Sprintf(`
+server:
+  issuer: https://auth.example.com:8443
+storage:
+  driver: sqlite
+  dsn: %s
+scopes: [read]
+clients:
+  - id: partner
+    name: パートナー
+    type: confidential
+    secret: secret
+    redirect_uris: [https://partner.example.com/callback]
+users:
+  - id: alice
+    login_id: alice@example.com
+    password: password
+`, filepath.Join(dir, "oauth.db")))
+	cfg, err := config.Load(path, func(string) string { return "" })
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	realms, err := openRealms(mylogger.NewMockLogger(), cfg, renderer)
+	if err != nil {
+		t.Fatalf("openRealms() error = %v", err)
+	}
+	defer closeRealms(realms)
+	server := httptest.NewServer(newRealmRouter(realms))
+	defer server.Close()
+
+	client := &http.Client{
+		Timeout: 5 * time.Second,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	do := func(method, target string, form url.Values, cookie *http.Cookie) *http.Response {
+		req, _ := http.NewRequest(method, server.URL+target, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		return resp
+	}
+	decode := func(resp *http.Response, v any) {
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			body, _ := io.ReadAll(resp.Body)
+			t.Fatalf("Expected status 200, got %d: %s", resp.StatusCode, body)
+		}
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+	}
+	sessionCookie := func(resp *http.Response, current *http.Cookie) *http.Cookie {
+		for _, c := range resp.Cookies() {
+			if c.Name == session.SessionIDCookieName {
+				return c
+			}
+		}
+		return current
+	}
+	encode := base64.RawURLEncoding.EncodeToString
+	// 認可リクエストを始め、ログイン画面のトランザクションIDとCSRFトークン、セッションCookieを返す
+	authorize := func() (map[string]any, *http.Cookie) {
+		resp := do("GET", "/authorize?response_type=code&client_id=partner&redirect_uri=https://partner.example.com/callback&state=xyz&scope=read", nil, nil)
+		cookie := sessionCookie(resp, nil)
+		var result map[string]any
+		decode(resp, &result)
+		return result, cookie
+	}
+
+	// パスワードでログインし、アカウント画面からパスキーを登録する
+	authorizeResult, cookie := authorize()
+	resp := do("POST", "/decision", url.Values{
+		"approved": {"true"}, "transaction_id": {authorizeResult["transaction_id"].(string)}, "csrf_token": {authorizeResult["csrf_token"].(string)},
+		"login_id": {"alice@example.com"}, "password": {"password"},
+	}, cookie)
+	resp.Body.Close()
+	cookie = sessionCookie(resp, cookie)
+	resp = do("GET", "/account/passkeys", nil, cookie)
+	page, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	csrfToken := regexp.MustCompile(`name="csrf_token" value="([^"]+)"`).FindStringSubmatch(string(page))
+	if csrfToken == nil {
+		t.Fatalf("Expected csrf token in the page, got %s", page)
+	}
+	var registrationOptions struct {
+		Challenge string `json:"challenge"`
+		RP        struct {
+			ID string `json:"id"`
+		} `json:"rp"`
+		User struct {
+			ID string `json:"id"`
+		} `json:"user"`
+	}
+	decode(do("POST", "/account/passkeys/options", url.Values{"csrf_token": {csrfToken[1]}}, cookie), &registrationOptions)
+	if registrationOptions.RP.ID != "auth.example.com" || registrationOptions.User.ID != encode([]byte("alice")) {
+		t.Fatalf("Unexpected registration options: %+v", registrationOptions)
+	}
+	authenticator := webauthntest.NewAuthenticator(t, "auth.example.com", "https://auth.example.com:8443")
+	attestation := authenticator.Create(registrationOptions.Challenge, []byte("alice"))
+	resp = do("POST", "/account/passkeys", url.Values{
+		"csrf_token":         {csrfToken[1]},
+		"client_data_json":   {encode(attestation.ClientDataJSON)},
+		"attestation_object": {encode(attestation.AttestationObject)},
+		"name":               {"業務用ノートPC"},
+	}, cookie)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusSeeOther {
+		t.Fatalf("Expected redirect after registration, got %d", resp.StatusCode)
+	}
+
+	// when: 新しいブラウザセッションでログイン画面からパスキーでログインする
+	authorizeResult, cookie = authorize()
+	form := url.Values{"transaction_id": {authorizeResult["transaction_id"].(string)}, "csrf_token": {authorizeResult["csrf_token"].(string)}}
+	var loginOptions struct {
+		Challenge string `json:"challenge"`
+		RPID      string `json:"rpId"`
+	}
+	decode(do("POST", "/passkey/login/options", form, cookie), &loginOptions)
+	assertion := authenticator.Get(loginOptions.Challenge)
+	loginForm := url.Values{
+		"csrf_token":         form["csrf_token"],
+		"credential_id":      {encode(assertion.CredentialID)},
+		"client_data_json":   {encode(assertion.ClientDataJSON)},
+		"authenticator_data": {encode(assertion.AuthenticatorData)},
+		"signature":          {encode(assertion.Signature)},
+		"user_handle":        {encode(assertion.UserHandle)},
+	}
+	resp = do("POST", "/passkey/login", loginForm, cookie)
+	resp.Body.Close()
+
+	// then: パスワードなしで認可コードを発行し、amrにhwkとuserを記録する
+	location, _ := url.Parse(resp.Header.Get("Location"))
+	if resp.StatusCode != http.StatusSeeOther || location.Query().Get("code") == "" {
+		t.Fatalf("Expected redirect with code, got %d: %s", resp.StatusCode, resp.Header.Get("Location"))
+	}
+	renewed := sessionCookie(resp, cookie)
+	if renewed.Value == cookie.Value {
+		t.Error("Expected the session ID to be regenerated")
+	}
+	sessionData, err := realms[0].stores.sessions.Get(session.SessionID(renewed.Value))
+	if err != nil || sessionData.User().UserID() != "alice" || !slices.Equal(sessionData.AMR(), []string{domain.AMRHardwareKey, domain.AMRUserPresence}) {
+		t.Errorf("Expected session with amr [hwk user], got %+v, %v", sessionData, err)
+	}
+	credential, err := realms[0].stores.passkeys.FindByCredentialID(authenticator.CredentialID())
+	if err != nil || credential.SignCount() != 1 || credential.Name() != "業務用ノートPC" {
+		t.Errorf("Expected the sign count to be saved, got %+v, %v", credential, err)
+	}
+
+	// 同じ署名は再び使えない(チャレンジは1回のみ使える)
+	resp = do("POST", "/passkey/login", loginForm, cookie)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected the replayed assertion to be rejected, got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"oauth-tutorial/internal/infrastructure"
+	"time"
+)
+
+// 有効期限切れのデータを削除する対象
+// 認可リクエストのトランザクション・上流のIdPへの認可リクエストの状態・パスキーのチャレンジはサーバーのメモリ上にのみ存在するため、
+// ts・ls・csがnilの場合は対象にしない
+func purgeTargets(st *stores, ts *infrastructure.TransactionStorage, ls *infrastructure.FederatedLoginStateStorage, cs *infrastructure.PasskeyChallengeStorage) []infrastructure.PurgeTarget {
+	targets := []infrastructure.PurgeTarget{
+		{Name: "auth_codes", Purge: st.authCode.PurgeExpired},
+		{Name: "tokens", Purge: st.tokens.PurgeExpired},
+		{Name: "consents", Purge: st.consents.PurgeExpired},
+		// セッションの有効期間はセッションストアの時計で判定する
+		{Name: "sessions", Purge: func(_ time.Time, limit int) (int, error) { return st.sessions.Sweep(limit) }},
+	}
+	if ts != nil {
+		targets = append(targets, infrastructure.PurgeTarget{Name: "transactions", Purge: ts.PurgeExpired})
+	}
+	if ls != nil {
+		targets = append(targets, infrastructure.PurgeTarget{Name: "federated_login_states", Purge: ls.PurgeExpired})
+	}
+	if cs != nil {
+		targets = append(targets, infrastructure.PurgeTarget{Name: "passkey_challenges", Purge: cs.PurgeExpired})
+	}
+	return targets
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"oauth-tutorial/internal/config"
+	"oauth-tutorial/internal/domain"
+	"oauth-tutorial/internal/infrastructure"
+	"oauth-tutorial/internal/infrastructure/oidc"
+	"oauth-tutorial/internal/infrastructure/webauthn"
+	"oauth-tutorial/internal/presentation"
+	pAccount "oauth-tutorial/internal/presentation/account"
+	pAuthorize "oauth-tutorial/internal/presentation/authorize"
+	pDecision "oauth-tutorial/internal/presentation/decision"
+	pFederation "oauth-tutorial/internal/presentation/federation"
+	pMetadata "oauth-tutorial/internal/presentation/metadata"
+	pPasskey "oauth-tutorial/internal/presentation/passkey"
+	pToken "oauth-tutorial/internal/presentation/token"
+	"oauth-tutorial/internal/presentation/view"
+	"oauth-tutorial/internal/session"
+	uAccount "oauth-tutorial/internal/usecase/account"
+	uAuthorize "oauth-tutorial/internal/usecase/authorize"
+	uDecision "oauth-tutorial/internal/usecase/decision"
+	uFederation "oauth-tutorial/internal/usecase/federation"
+	uPasskey "oauth-tutorial/internal/usecase/passkey"
+	uToken "oauth-tutorial/internal/usecase/token"
+	"oauth-tutorial/pkg/mycrypto"
+	"oauth-tutorial/pkg/mylogger"
+	"strings"
+)
+
+// レルム毎の永続化の実装・スコープ・鍵・ハンドラー。レルム間でクライアントやユーザー、認可コード、トークン、セッションを共有しない
+type realm struct {
+	// 既定のレルムは空文字
+	name string
+	// ホスト名で選択する場合のホスト名
+	host   string
+	cfg    *config.Config
+	issuer string
+	stores *stores
+	snap   *snapshotter
+	// 設定の再読み込みで差し替える
+	scopes *domain.ScopeProvider
+	// 認可リクエストのトランザクションは短命なため、永続化の実装に関わらずメモリ上に保持する
+	transactions *infrastructure.TransactionStorage
+	purger       *infrastructure.Purger
+	handler      http.Handler
+}
+
+// 既定のレルムと設定したレルムを全て開く。失敗した場合は開いたレルムを閉じる
+// 既定のレルムのハンドラーはdefaultMuxに登録する
+func openRealms(logger mylogger.Logger, cfg *config.Config, renderer *view.Renderer) ([]*realm, error) {
+	defaultRealm, err := openRealm(logger, "", cfg, renderer, http.NewServeMux())
+	if err != nil {
+		return nil, err
+	}
+	realms := []*realm{defaultRealm}
+	for _, rc := range cfg.Realms {
+		r, err := openRealm(logger, rc.Name, cfg.Realm(rc.Name), renderer, http.NewServeMux())
+		if err != nil {
+			closeRealms(realms)
+			return nil, fmt.Errorf("realm %s: %w", rc.Name, err)
+		}
+		r.host = rc.Host
+		realms = append(realms, r)
+	}
+	return realms, nil
+}
+
+func closeRealms(realms []*realm) {
+	for _, r := range realms {
+		r.stores.close()
+	}
+}
+
+// レルムの設定(既定のレルムの場合は設定全体)に従って永続化の実装を開き、ハンドラーをmuxに登録する
+func openRealm(logger mylogger.Logger, name string, cfg *config.Config, renderer *view.Renderer, mux *http.ServeMux) (*realm, error) {
+	if name != "" {
+		logger = mylogger.With(logger, "realm", name)
+	}
+	// サポートするスコープと、認可コード・トークンの有効期間
+	registry, err := cfg.ScopeRegistry()
+	if err != nil {
+		return nil, fmt.Errorf("invalid scope definitions: %w", err)
+	}
+	scopes := domain.NewScopeProvider(registry)
+	lifetimes := cfg.DomainLifetimes()
+	// 認可レスポンスのissとメタデータで使う認可サーバーの識別子 RFC 9207
+	issuer := cfg.Issuer()
+
+	// /decisionのCSRFトークンを発行する鍵(未指定の場合は起動毎に生成する)。レルム毎に異なる鍵を使う
+	rg := &mycrypto.RandomGenerator{}
+	csrfSecret := cfg.Server.CSRFSecret
+	if csrfSecret == "" {
+		csrfSecret = rg.GenerateURLSafeRandomString(32)
+	}
+	csrfProtector := presentation.NewCSRFProtector([]byte(csrfSecret))
+
+	// 永続化の実装とスナップショット
+	st, snap, err := openStoresFromConfig(logger, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open storage: %w", err)
+	}
+
+	// 認可リクエストのためのコンポーネントを初期化
+	cr := st.clients
+	sig := session.NewSessionIDGenerator()
+	ss := st.sessions
+	tig := session.NewTransactionIDGenerator()
+	ts := infrastructure.NewTransactionStorage()
+	ar := st.authCode
+	csr := st.consents
+	acf := uAuthorize.NewAuthorizationCodeFlow(logger, cr, sig, ss, tig, ts, rg, ar, csr, lifetimes, scopes)
+
+	// 認可コード発行のためのコンポーネントを初期化
+	ur := st.users
+	authenticator, err := newAuthenticator(logger, cfg, st)
+	if err != nil {
+		st.close()
+		return nil, err
+	}
+	pac := uDecision.NewPublishAuthorizationCodeUseCase(logger, rg, ss, sig, ts, authenticator, st.totp, ar, csr, cr, cfg.Lifetimes.Consent, lifetimes, scopes)
+
+	// トークン発行のためのコンポーネントを初期化
+	tr := st.tokens
+	pts := uToken.NewPublishTokenStrategy(logger, cr, ar, tr, csr, lifetimes, scopes)
+
+	// アカウント画面(連携中のアプリの管理)のためのコンポーネントを初期化
+	lca := uAccount.NewListConnectedAppsUseCase(logger, ss, cr, csr, tr)
+	rca := uAccount.NewRevokeConnectedAppUseCase(logger, ss, csr, tr, ar)
+	accountHandler := pAccount.NewAccountHandler(logger, lca, rca, renderer, csrfProtector)
+
+	// アカウント画面からのTOTPの登録のためのコンポーネントを初期化
+	gts := uAccount.NewGetTOTPStatusUseCase(logger, ss, st.totp)
+	ste := uAccount.NewStartTOTPEnrollmentUseCase(logger, ss, st.totp, rg, totpIssuerName(issuer))
+	cte := uAccount.NewConfirmTOTPEnrollmentUseCase(logger, ss, st.totp, rg)
+	totpHandler := pAccount.NewTOTPHandler(logger, gts, ste, cte, renderer, csrfProtector)
+
+	// パスキー(WebAuthn)の登録とログインのためのコンポーネントを初期化
+	rp := passkeyRelyingParty(issuer)
+	// チャレンジは短命なため、トランザクションと同様にメモリ上に保持する
+	pcs := infrastructure.NewPasskeyChallengeStorage()
+	lpk := uAccount.NewListPasskeysUseCase(logger, ss, st.passkeys)
+	bpr := uAccount.NewBeginPasskeyRegistrationUseCase(logger, ss, st.passkeys, pcs, rg, rp)
+	fpr := uAccount.NewFinishPasskeyRegistrationUseCase(logger, ss, st.passkeys, pcs, rp)
+	passkeysHandler := pAccount.NewPasskeyHandler(logger, lpk, bpr, fpr, renderer, csrfProtector)
+	bpl := uPasskey.NewBeginPasskeyLoginUseCase(logger, ts, pcs, rg, rp)
+	cpl := uPasskey.NewCompletePasskeyLoginUseCase(logger, pcs, ts, st.passkeys, ur, ss, sig, rp)
+
+	// 上流のIdPでのログインのためのコンポーネントを初期化
+	providers, loginProviders := identityProviders(cfg, issuer)
+	// IdPへの認可リクエストの状態は、トランザクションと同様にメモリ上に保持する
+	ls := infrastructure.NewFederatedLoginStateStorage()
+	sfl := uFederation.NewStartFederatedLoginUseCase(logger, providers, ts, ls, rg)
+	cfl := uFederation.NewCompleteFederatedLoginUseCase(logger, providers, ls, ts, ur, ss, sig, rg)
+
+	// ハンドラーの登録
+	decisionHandler := pDecision.NewDecisionHandler(logger, pac, renderer, csrfProtector, issuer, scopes, loginProviders)
+	federationHandler := pFederation.NewFederationHandler(logger, sfl, cfl, decisionHandler, renderer, csrfProtector)
+	passkeyHandler := pPasskey.NewPasskeyHandler(logger, bpl, cpl, decisionHandler, renderer, csrfProtector)
+	mux.Handle("GET /authorize", pAuthorize.NewAuthorizeHandler(logger, acf, renderer, csrfProtector, issuer, scopes, loginProviders))
+	mux.Handle("POST /decision", decisionHandler)
+	mux.HandleFunc("POST /federation/login", federationHandler.ServeLogin)
+	mux.HandleFunc("GET /federation/callback", federationHandler.ServeCallback)
+	mux.HandleFunc("POST /passkey/login/options", passkeyHandler.ServeOptions)
+	mux.HandleFunc("POST /passkey/login", passkeyHandler.ServeLogin)
+	mux.Handle("POST /token", pToken.NewTokenHandler(logger, *pts))
+	mux.Handle("GET "+presentation.MetadataPath, pMetadata.NewMetadataHandler(logger, issuer, scopes))
+	mux.HandleFunc("GET /account", accountHandler.ServePage)
+	mux.HandleFunc("POST /account/revoke", accountHandler.ServeRevoke)
+	mux.HandleFunc("GET /account/apps", accountHandler.ServeListAPI)
+	mux.HandleFunc("DELETE /account/apps/{client_id}", accountHandler.ServeRevokeAPI)
+	mux.HandleFunc("GET /account/totp", totpHandler.ServePage)
+	mux.HandleFunc("POST /account/totp", totpHandler.ServeStart)
+	mux.HandleFunc("POST /account/totp/confirm", totpHandler.ServeConfirm)
+	mux.HandleFunc("GET /account/passkeys", passkeysHandler.ServePage)
+	mux.HandleFunc("POST /account/passkeys/options", passkeysHandler.ServeOptions)
+	mux.HandleFunc("POST /account/passkeys", passkeysHandler.ServeRegister)
+
+	return &realm{
+		name:         name,
+		cfg:          cfg,
+		issuer:       issuer,
+		stores:       st,
+		snap:         snap,
+		scopes:       scopes,
+		transactions: ts,
+		purger:       infrastructure.NewPurger(logger, cfg.Storage.Purge.BatchSize, purgeTargets(st, ts, ls, pcs)...),
+		handler:      mux,
+	}, nil
+}
+
+// 設定した上流のIdPと、ログイン画面に表示するIdPの一覧
+// IdPにはリダイレクトURIとして{認可サーバーの識別子}/federation/callbackを登録する
+func identityProviders(cfg *config.Config, issuer string) (map[string]uFederation.IIdentityProvider, []view.LoginProvider) {
+	providers := map[string]uFederation.IIdentityProvider{}
+	var loginProviders []view.LoginProvider
+	for _, p := range cfg.IdentityProviders {
+		providers[p.Name] = oidc.NewProvider(oidc.Config{
+			Issuer:       p.Issuer,
+			ClientID:     p.ClientID,
+			ClientSecret: p.ClientSecret,
+			RedirectURI:  strings.TrimSuffix(issuer, "/") + "/federation/callback",
+			Scopes:       p.ScopesOrDefault(),
+			LoginIDClaim: p.LoginIDClaimOrDefault(),
+		}, nil)
+		loginProviders = append(loginProviders, view.LoginProvider{Name: p.Name, DisplayName: p.DisplayNameOrDefault()})
+	}
+	return providers, loginProviders
+}
+
+// 認証アプリに表示するTOTPの発行者名。認可サーバーの識別子のホスト名とパス(レルムの場合は/realms/{name})を使う
+// ポート番号の":"は発行者名とアカウント名の区切りと紛らわしいため含めない
+func totpIssuerName(issuer string) string {
+	u, err := url.Parse(issuer)
+	if err != nil || u.Hostname() == "" {
+		return issuer
+	}
+	return u.Hostname() + strings.TrimSuffix(u.Path, "/")
+}
+
+// パスキーのRelying Party。RP IDは認可サーバーの識別子のホスト名、登録・ログインを受け付けるオリジンはそのスキームとホスト
+// パスで分けたレルム(/realms/{name})は同じRP IDを共有するが、パスキーはレルム毎に保存するため別のレルムでは使えない
+func passkeyRelyingParty(issuer string) *webauthn.RelyingParty {
+	u, err := url.Parse(issuer)
+	if err != nil || u.Hostname() == "" {
+		return webauthn.NewRelyingParty(issuer, issuer, issuer)
+	}
+	return webauthn.NewRelyingParty(u.Hostname(), totpIssuerName(issuer), u.Scheme+"://"+u.Host)
+}
+
+// レルムのハンドラーにリクエストを振り分けるハンドラー。realms[0]は既定のレルム
+func newRealmRouter(realms []*realm) http.Handler {
+	router := presentation.NewRealmRouter(realms[0].handler)
+	for _, r := range realms[1:] {
+		router.Add(r.name, r.host, r.handler)
+	}
+	return router
+}
+
+// /debug/varsで公開する変数名。既定のレルム以外はレルム名を付ける(例: sessions.sales)
+func realmVarName(name string, r *realm) string {
+	if r.name == "" {
+		return name
+	}
+	return name + "." + r.name
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"oauth-tutorial/internal/config"
+	"oauth-tutorial/internal/session"
+	"oauth-tutorial/pkg/mylogger"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 既定のレルムと営業部のレルムに、同じIDとシークレットのクライアントを登録する
+const realmTestConfig = `
+server:
+  issuer: https://auth.example.com
+scopes: [read, write]
+clients:
+  - id: partner
+    name: パートナー
+    type: confidential
+    secret: secret
+    redirect_uris: [https://partner.example.com/callback]
+users:
+  - id: user-1
+    login_id: user@example.com
+    password: password
+realms:
+  - name: sales
+    scopes: [read, report]
+    clients:
+      - id: partner
+        name: パートナー
+        type: confidential
+        secret: secret
+        redirect_uris: [https://partner.example.com/callback]
+      - id: sales-app
+        name: 営業アプリ
+        type: confidential
+        secret: secret
+        redirect_uris: [https://sales-app.example.com/callback]
+    users:
+      - id: sales-user
+        login_id: sales@example.com
+        password: password
+  - name: support
+    host: support.example.com
+    clients:
+      - id: partner
+        name: パートナー
+        type: confidential
+        secret: secret
+        redirect_uris: [https://partner.example.com/callback]
+    users:
+      - id: support-user
+        login_id: support@example.com
+        password: password
+`
+
+func Test_レルムの分離統合テスト(t *testing.T) {
+	// given
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfigFile(t, path, realmTestConfig)
+	cfg, err := config.Load(path, func(string) string { return "" })
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	realms, err := openRealms(mylogger.NewMockLogger(), cfg, renderer)
+	if err != nil {
+		t.Fatalf("openRealms() error = %v", err)
+	}
+	defer closeRealms(realms)
+	server := httptest.NewServer(newRealmRouter(realms))
+	defer server.Close()
+
+	client := &http.Client{
+		Timeout: 5 * time.Second,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	do := func(method, host, path, body string, cookies ...*http.Cookie) *http.Response {
+		req, _ := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+		if host != "" {
+			req.Host = host
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		return resp
+	}
+	decode := func(resp *http.Response) map[string]any {
+		defer resp.Body.Close()
+		var v map[string]any
+		if err := json.NewDecoder(resp.Body).Decode(&v); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		return v
+	}
+	// レルムで認可コードを発行し、リダイレクト先のクエリを返す
+	authorize := func(host, base, loginID string) url.Values {
+		t.Helper()
+		resp := do("GET", host, base+"/authorize?response_type=code&client_id=partner&redirect_uri=https://partner.example.com/callback&state=xyz&scope=read", "")
+		var sessionCookie *http.Cookie
+		for _, c := range resp.Cookies() {
+			if c.Name == session.SessionIDCookieName {
+				sessionCookie = c
+			}
+		}
+		authorizeResult := decode(resp)
+		if sessionCookie == nil {
+			t.Fatalf("Expected session cookie, got %v", authorizeResult)
+		}
+		// セッションのCookieはレルムのパスに限定する
+		if expected := base; expected != "" && sessionCookie.Path != expected {
+			t.Errorf("Expected cookie path %q, got %q", expected, sessionCookie.Path)
+		}
+		resp = do("POST", host, base+"/decision", fmt.Sprintf("approved=true&transaction_id=%s&csrf_token=%s&login_id=%s&password=password",
+			authorizeResult["transaction_id"], authorizeResult["csrf_token"], loginID), sessionCookie)
+		resp.Body.Close()
+		location, _ := url.Parse(resp.Header.Get("Location"))
+		if resp.StatusCode != http.StatusSeeOther || location.Query().Get("code") == "" {
+			t.Fatalf("Expected redirect with code, got %d: %s", resp.StatusCode, resp.Header.Get("Location"))
+		}
+		return location.Query()
+	}
+	exchange := func(host, base, code string) (int, map[string]any) {
+		resp := do("POST", host, base+"/token", "grant_type=authorization_code&client_id=partner&client_secret=secret&redirect_uri=https://partner.example.com/callback&code="+code)
+		return resp.StatusCode, decode(resp)
+	}
+	refresh := func(host, base, refreshToken string) (int, map[string]any) {
+		resp := do("POST", host, base+"/token", "grant_type=refresh_token&client_id=partner&client_secret=secret&refresh_token="+refreshToken)
+		return resp.StatusCode, decode(resp)
+	}
+
+	t.Run("他のレルムのクライアントは使えない", func(t *testing.T) {
+		resp := do("GET", "", "/authorize?response_type=code&client_id=sales-app&redirect_uri=https://sales-app.example.com/callback&state=xyz&scope=read", "")
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("Expected status %d, got %d", http.StatusBadRequest, resp.StatusCode)
+		}
+		resp = do("GET", "", "/realms/sales/authorize?response_type=code&client_id=sales-app&redirect_uri=https://sales-app.example.com/callback&state=xyz&scope=read", "")
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("Expected status %d in the sales realm, got %d: %s", http.StatusOK, resp.StatusCode, resp.Header.Get("Location"))
+		}
+	})
+
+	t.Run("他のレルムのユーザーではログインできない", func(t *testing.T) {
+		resp := do("GET", "", "/realms/sales/authorize?response_type=code&client_id=partner&redirect_uri=https://partner.example.com/callback&state=xyz&scope=read", "")
+		authorizeResult := decode(resp)
+		resp = do("POST", "", "/realms/sales/decision", fmt.Sprintf("approved=true&transaction_id=%s&csrf_token=%s&login_id=user@example.com&password=password",
+			authorizeResult["transaction_id"], authorizeResult["csrf_token"]), resp.Cookies()...)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, resp.StatusCode)
+		}
+	})
+
+	t.Run("他のレルムの認可コードとトークンは使えない", func(t *testing.T) {
+		// パスで選択したレルムで認可コードを発行する
+		query := authorize("", "/realms/sales", "sales@example.com")
+		if expected := "https://auth.example.com/realms/sales"; query.Get("iss") != expected {
+			t.Errorf("Expected iss %q, got %q", expected, query.Get("iss"))
+		}
+
+		// 既定のレルムとホスト名で選択したレルムでは、同じIDのクライアントでも認可コードを使えない
+		for _, host := range []string{"", "support.example.com"} {
+			if status, result := exchange(host, "", query.Get("code")); status != http.StatusBadRequest || result["error"] != "invalid_grant" {
+				t.Errorf("host %q: Expected %d invalid_grant, got %d: %v", host, http.StatusBadRequest, status, result)
+			}
+		}
+
+		status, issued := exchange("", "/realms/sales", query.Get("code"))
+		if status != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d: %v", http.StatusOK, status, issued)
+		}
+		if status, result := refresh("", "", issued["refresh_token"].(string)); status != http.StatusBadRequest || result["error"] != "invalid_grant" {
+			t.Errorf("Expected %d invalid_grant, got %d: %v", http.StatusBadRequest, status, result)
+		}
+		accessToken := issued["access_token"].(string)
+		if _, err := realms[0].stores.tokens.FindByAccessToken(accessToken); err == nil {
+			t.Error("Expected access token not to be stored in the default realm")
+		}
+		if _, err := realms[1].stores.tokens.FindByAccessToken(accessToken); err != nil {
+			t.Errorf("Expected access token to be stored in the sales realm: %v", err)
+		}
+		if status, result := refresh("", "/realms/sales", issued["refresh_token"].(string)); status != http.StatusOK {
+			t.Errorf("Expected status %d, got %d: %v", http.StatusOK, status, result)
+		}
+	})
+
+	t.Run("ホスト名で選択したレルム", func(t *testing.T) {
+		query := authorize("support.example.com", "", "support@example.com")
+		// ホスト名を置き換えた識別子。ポートはserver.issuerと同じ
+		if expected := "https://support.example.com"; query.Get("iss") != expected {
+			t.Errorf("Expected iss %q, got %q", expected, query.Get("iss"))
+		}
+		if status, result := exchange("", "/realms/sales", query.Get("code")); status != http.StatusBadRequest {
+			t.Errorf("Expected status %d in the sales realm, got %d: %v", http.StatusBadRequest, status, result)
+		}
+		if status, result := exchange("support.example.com", "", query.Get("code")); status != http.StatusOK {
+			t.Errorf("Expected status %d, got %d: %v", http.StatusOK, status, result)
+		}
+	})
+
+	t.Run("レルム毎のメタデータ", func(t *testing.T) {
+		tests := []struct {
+			host, path, issuer string
+		}{
+			{"", "/.well-known/oauth-authorization-server", "https://auth.example.com"},
+			// RFC 8414 3.1 パスを含むissuerのメタデータ
+			{"", "/.well-known/oauth-authorization-server/realms/sales", "https://auth.example.com/realms/sales"},
+			{"support.example.com", "/.well-known/oauth-authorization-server", "https://support.example.com"},
+		}
+		for _, tt := range tests {
+			resp := do("GET", tt.host, tt.path, "")
+			metadata := decode(resp)
+			if metadata["issuer"] != tt.issuer || metadata["token_endpoint"] != tt.issuer+"/token" {
+				t.Errorf("%s%s: Expected issuer %q, got %v", tt.host, tt.path, tt.issuer, metadata)
+			}
+		}
+	})
+
+	t.Run("存在しないレルム", func(t *testing.T) {
+		for _, path := range []string{"/realms/unknown/authorize", "/.well-known/oauth-authorization-server/realms/unknown"} {
+			resp := do("GET", "", path, "")
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusNotFound {
+				t.Errorf("%s: Expected status %d, got %d", path, http.StatusNotFound, resp.StatusCode)
+			}
+		}
+	})
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"crypto/sha256"
+	"oauth-tutorial/internal/config"
+	"oauth-tutorial/internal/domain"
+	"oauth-tutorial/pkg/mylogger"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// 設定ファイルの変更を確認する間隔
+	configWatchInterval = 2 * time.Second
+
+	reloadTriggerSIGHUP     = "sighup"
+	reloadTriggerFileChange = "file_change"
+)
+
+// 設定ファイルを読み込み直し、レルム毎のクライアントとスコープを差し替える
+// 不正な設定の場合は差し替えず、それまでの設定を使い続ける
+type configReloader struct {
+	logger mylogger.Logger
+	path   string
+	getenv func(string) string
+	// realms[0]は既定のレルム
+	realms []*realm
+
+	mu      sync.Mutex
+	current *config.Config
+	// 最後に読み込んだ設定ファイルの内容のハッシュ。変更の検知に使う
+	digest [sha256.Size]byte
+}
+
+func newConfigReloader(logger mylogger.Logger, path string, getenv func(string) string, realms []*realm, current *config.Config) *configReloader {
+	r := &configReloader{logger: logger, path: path, getenv: getenv, realms: realms, current: current}
+	r.digest, _ = fileDigest(path)
+	return r
+}
+
+// レルムの設定。既定のレルムの場合は設定全体、削除したレルムの場合はnil
+func realmConfig(cfg *config.Config, name string) *config.Config {
+	if name == "" {
+		return cfg
+	}
+	return cfg.Realm(name)
+}
+
+// 差し替えた場合はtrueを返す
+func (r *configReloader) reload(trigger string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.digest, _ = fileDigest(r.path)
+
+	next, err := config.Load(r.path, r.getenv)
+	if err != nil {
+		mylogger.AuditEvent(r.logger, "config_reload_rejected", "trigger", trigger, "path", r.path, "err", err)
+		return false
+	}
+	// 全てのレルムのスコープを構築できた場合のみ差し替える
+	// レルムの追加・削除は再起動するまで反映しない
+	registries := make([]*domain.ScopeRegistry, len(r.realms))
+	for i, realm := range r.realms {
+		cfg := realmConfig(next, realm.name)
+		if cfg == nil {
+			continue
+		}
+		registries[i], err = cfg.ScopeRegistry()
+		if err != nil {
+			mylogger.AuditEvent(r.logger, "config_reload_rejected", "trigger", trigger, "path", r.path, "realm", realm.name, "err", err)
+			return false
+		}
+	}
+	for i, realm := range r.realms {
+		before, after := realmConfig(r.current, realm.name), realmConfig(next, realm.name)
+		if after == nil {
+			continue
+		}
+		// クライアントはストアの差し替えで一度に切り替わるため、処理中のリクエストは古いか新しいどちらかの設定を参照する
+		if err := realm.stores.clients.ReplaceAll(after.SeedClients()); err != nil {
+			mylogger.AuditEvent(r.logger, "config_reload_rejected", "trigger", trigger, "path", r.path, "realm", realm.name, "err", err)
+			return false
+		}
+		realm.scopes.Replace(registries[i])
+
+		logger := r.logger
+		if realm.name != "" {
+			logger = mylogger.With(logger, "realm", realm.name)
+		}
+		changes := config.Diff(before, after)
+		mylogger.AuditEvent(logger, "config_reloaded", "trigger", trigger, "path", r.path,
+			"clientsAdded", changes.ClientsAdded,
+			"clientsRemoved", changes.ClientsRemoved,
+			"clientsUpdated", changes.ClientsUpdated,
+			"scopesAdded", changes.ScopesAdded,
+			"scopesRemoved", changes.ScopesRemoved,
+			"scopesUpdated", changes.ScopesUpdated)
+		if len(changes.RestartRequired) > 0 {
+			logger.Warn("some configuration changes require a restart", "sections", changes.RestartRequired)
+		}
+	}
+	r.current = next
+	return true
+}
+
+// 設定ファイルの内容が変わっていれば読み込み直す
+func (r *configReloader) reloadIfChanged() bool {
+	digest, err := fileDigest(r.path)
+	if err != nil {
+		// 書き換えの途中でファイルが一時的に存在しない場合があるため、次の確認を待つ
+		return false
+	}
+	r.mu.Lock()
+	changed := digest != r.digest
+	r.mu.Unlock()
+	if !changed {
+		return false
+	}
+	return r.reload(reloadTriggerFileChange)
+}
+
+func fileDigest(path string) ([sha256.Size]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return [sha256.Size]byte{}, err
+	}
+	return sha256.Sum256(data), nil
+}
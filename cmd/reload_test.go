@@ -0,0 +1,189 @@
+package main
+
+import (
+	"oauth-tutorial/internal/config"
+	"oauth-tutorial/internal/domain"
+	"oauth-tutorial/internal/infrastructure"
+	"oauth-tutorial/pkg/mylogger"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+const reloadTestConfig = `
+scopes: [read, write]
+clients:
+  - id: partner
+    name: パートナー
+    type: confidential
+    secret: secret
+    redirect_uris: [https://partner.example.com/callback]
+`
+
+func writeConfigFile(t *testing.T, path string, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+}
+
+// 設定ファイルを読み込み、レルム毎にクライアントを登録したストアとスコープを持つ再読み込みの処理を返す
+func newTestReloaderWithConfig(t *testing.T, content string) (*configReloader, []*realm, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfigFile(t, path, content)
+	cfg, err := config.Load(path, func(string) string { return "" })
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	var realms []*realm
+	for _, name := range append([]string{""}, cfg.RealmNames()...) {
+		realmCfg := realmConfig(cfg, name)
+		scopes, err := realmCfg.ScopeRegistry()
+		if err != nil {
+			t.Fatalf("ScopeRegistry() error = %v", err)
+		}
+		realms = append(realms, &realm{
+			name:   name,
+			stores: &stores{clients: infrastructure.NewClientRepositoryWithClients(realmCfg.SeedClients())},
+			scopes: domain.NewScopeProvider(scopes),
+		})
+	}
+	return newConfigReloader(mylogger.NewMockLogger(), path, func(string) string { return "" }, realms, cfg), realms, path
+}
+
+// 既定のレルムのみの設定を読み込んだ再読み込みの処理と、既定のレルムのクライアントのストアを返す
+func newTestReloader(t *testing.T) (*configReloader, infrastructure.ClientStore, *domain.ScopeProvider, string) {
+	t.Helper()
+	reloader, realms, path := newTestReloaderWithConfig(t, reloadTestConfig)
+	return reloader, realms[0].stores.clients, realms[0].scopes, path
+}
+
+func Test_設定の再読み込み(t *testing.T) {
+	t.Run("クライアントとスコープを差し替える", func(t *testing.T) {
+		// given
+		reloader, clients, scopes, path := newTestReloader(t)
+		writeConfigFile(t, path, `
+scopes: [read, admin]
+clients:
+  - id: spa
+    name: SPA
+    type: public
+    redirect_uris: [https://spa.example.com/callback]
+`)
+
+		// when
+		reloaded := reloader.reload(reloadTriggerSIGHUP)
+
+		// then
+		if !reloaded {
+			t.Fatal("reload() = false, want true")
+		}
+		if _, err := clients.SelectByClientID("partner"); err == nil {
+			t.Error("削除したクライアントが残っている")
+		}
+		if _, err := clients.SelectByClientID("spa"); err != nil {
+			t.Errorf("追加したクライアントが見つからない: %v", err)
+		}
+		if got := scopes.Current().Names(); !slices.Equal(got, []string{"read", "admin"}) {
+			t.Errorf("Current().Names() = %v", got)
+		}
+	})
+
+	t.Run("不正な設定の場合はそれまでの設定を使い続ける", func(t *testing.T) {
+		// given
+		reloader, clients, scopes, path := newTestReloader(t)
+		writeConfigFile(t, path, `
+scopes: [read, admin]
+clients:
+  - id: spa
+    type: unknown
+`)
+
+		// when
+		reloaded := reloader.reload(reloadTriggerSIGHUP)
+
+		// then
+		if reloaded {
+			t.Fatal("reload() = true, want false")
+		}
+		if _, err := clients.SelectByClientID("partner"); err != nil {
+			t.Errorf("元のクライアントが見つからない: %v", err)
+		}
+		if got := scopes.Current().Names(); !slices.Equal(got, []string{"read", "write"}) {
+			t.Errorf("Current().Names() = %v", got)
+		}
+	})
+
+	t.Run("設定ファイルが変更された場合のみ読み込み直す", func(t *testing.T) {
+		// given
+		reloader, _, _, path := newTestReloader(t)
+
+		// when, then
+		if reloader.reloadIfChanged() {
+			t.Error("変更していないのに読み込み直した")
+		}
+		writeConfigFile(t, path, reloadTestConfig+"  - id: spa\n    name: SPA\n    type: public\n    redirect_uris: [https://spa.example.com/callback]\n")
+		if !reloader.reloadIfChanged() {
+			t.Error("変更したのに読み込み直さなかった")
+		}
+		if reloader.reloadIfChanged() {
+			t.Error("読み込み直した後に再度読み込み直した")
+		}
+	})
+}
+
+const reloadTestRealmConfig = `
+scopes: [read, write]
+clients:
+  - id: partner
+    name: パートナー
+    type: confidential
+    secret: secret
+    redirect_uris: [https://partner.example.com/callback]
+realms:
+  - name: sales
+    scopes: [read]
+    users:
+      - id: sales-user
+        login_id: sales@example.com
+        password: password
+    clients:
+      - id: partner
+        name: 営業部のパートナー
+        type: confidential
+        secret: sales-secret
+        redirect_uris: [https://sales.example.com/callback]
+`
+
+func Test_レルム毎の設定の再読み込み(t *testing.T) {
+	// given
+	reloader, realms, path := newTestReloaderWithConfig(t, reloadTestRealmConfig)
+	writeConfigFile(t, path, strings.Replace(reloadTestRealmConfig, "    scopes: [read]\n", "    scopes: [read, report]\n", 1)+`      - id: sales-app
+        name: 営業アプリ
+        type: public
+        redirect_uris: [https://sales-app.example.com/callback]
+`)
+
+	// when
+	if !reloader.reload(reloadTriggerSIGHUP) {
+		t.Fatal("reload() = false, want true")
+	}
+
+	// then
+	// レルムのクライアントとスコープのみを差し替え、既定のレルムには追加しない
+	if _, err := realms[1].stores.clients.SelectByClientID("sales-app"); err != nil {
+		t.Errorf("レルムに追加したクライアントが見つからない: %v", err)
+	}
+	if _, err := realms[0].stores.clients.SelectByClientID("sales-app"); err == nil {
+		t.Error("既定のレルムにレルムのクライアントが追加された")
+	}
+	if got := realms[1].scopes.Current().Names(); !slices.Equal(got, []string{"read", "report"}) {
+		t.Errorf("Current().Names() = %v", got)
+	}
+	if got := realms[0].scopes.Current().Names(); !slices.Equal(got, []string{"read", "write"}) {
+		t.Errorf("既定のレルムのCurrent().Names() = %v", got)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"errors"
+	"oauth-tutorial/internal/infrastructure"
+	"oauth-tutorial/pkg/mylogger"
+	"os"
+	"time"
+)
+
+// インメモリの実装の内容を暗号化したファイルに保存・復元する
+type snapshotter struct {
+	logger mylogger.Logger
+	stores *infrastructure.MemoryStores
+	path   string
+	key    []byte
+}
+
+// ファイルが存在しない場合は何もしない
+func (s *snapshotter) restore() error {
+	snapshot, err := infrastructure.ReadSnapshotFile(s.path, s.key)
+	if errors.Is(err, os.ErrNotExist) {
+		s.logger.Info("snapshot file not found, starting with empty stores", "path", s.path)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	skipped := s.stores.Restore(snapshot, time.Now())
+	s.logger.Info("snapshot restored", "path", s.path, "takenAt", snapshot.TakenAt, "skippedExpired", skipped)
+	return nil
+}
+
+// 失敗した場合はログを出力した上でエラーを返す
+func (s *snapshotter) save() error {
+	start := time.Now()
+	snapshot := s.stores.Snapshot(start)
+	if err := infrastructure.WriteSnapshotFile(s.path, s.key, snapshot); err != nil {
+		s.logger.Error("failed to save snapshot", "path", s.path, "err", err)
+		return err
+	}
+	s.logger.Info("snapshot saved", "path", s.path,
+		"authCodes", len(snapshot.AuthCodes),
+		"accessTokens", len(snapshot.AccessTokens),
+		"refreshTokens", len(snapshot.RefreshTokens),
+		"consents", len(snapshot.Consents),
+		"sessions", len(snapshot.Sessions),
+		"elapsed", time.Since(start))
+	return nil
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"oauth-tutorial/internal/config"
+	"oauth-tutorial/internal/infrastructure"
+	"oauth-tutorial/internal/infrastructure/sqlstore"
+	"oauth-tutorial/pkg/mylogger"
+)
+
+// 永続化の実装をまとめたもの
+type stores struct {
+	clients  infrastructure.ClientStore
+	users    infrastructure.UserStore
+	authCode infrastructure.AuthCodeStore
+	tokens   infrastructure.TokenStore
+	consents infrastructure.ConsentStore
+	totp     infrastructure.TOTPStore
+	passkeys infrastructure.PasskeyStore
+	sessions infrastructure.SessionStore
+	// インメモリの実装の場合のみ設定する。スナップショットの保存・復元に使う
+	memory *infrastructure.MemoryStores
+	close  func() error
+}
+
+// 設定に従って永続化の実装を構築する。サーバーとpurge・hash-passwordsサブコマンドで共通
+// storage.snapshot.fileが指定されている場合はスナップショットから復元し、保存に使うsnapshotterを返す
+// 以前のバージョンで保存した平文のパスワードは、開いた時点でハッシュ値に置き換える
+func openStoresFromConfig(logger mylogger.Logger, cfg *config.Config) (*stores, *snapshotter, error) {
+	st, err := openStores(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	var snap *snapshotter
+	if cfg.Storage.Snapshot.File != "" {
+		snap = &snapshotter{logger: logger, stores: st.memory, path: cfg.Storage.Snapshot.File, key: infrastructure.SnapshotKey(cfg.Storage.Snapshot.Key)}
+		if err := snap.restore(); err != nil {
+			st.close()
+			return nil, nil, fmt.Errorf("restore snapshot %s: %w", cfg.Storage.Snapshot.File, err)
+		}
+		// スナップショットを保存した後に設定ファイルを変更した場合も、設定したクライアントを使う
+		if err := st.clients.ReplaceAll(cfg.SeedClients()); err != nil {
+			st.close()
+			return nil, nil, err
+		}
+		// ユーザーも同様に、スナップショットの保存後に変更した設定のパスワードを反映する
+		if err := seedUsers(st.users, cfg); err != nil {
+			st.close()
+			return nil, nil, err
+		}
+	}
+	hashed, err := st.users.HashPlaintextPasswords(cfg.PasswordHasher().Hash)
+	if err != nil {
+		st.close()
+		return nil, nil, fmt.Errorf("hash plaintext passwords: %w", err)
+	}
+	if len(hashed) > 0 {
+		logger.Info("replaced plaintext passwords in storage with password hashes", "users", hashed)
+	}
+	return st, snap, nil
+}
+
+// storage.driverに応じた永続化の実装を構築し、設定したクライアントとユーザーを登録する
+// クライアントは設定ファイルで管理するため、設定にないクライアントは削除する
+func openStores(cfg *config.Config) (*stores, error) {
+	clients := cfg.SeedClients()
+	sessionIdleTimeout, sessionAbsoluteTimeout := cfg.Lifetimes.SessionIdle, cfg.Lifetimes.SessionAbsolute
+
+	switch cfg.Storage.Driver {
+	case config.StorageDriverMemory:
+		memory := &infrastructure.MemoryStores{
+			Clients:   infrastructure.NewClientRepositoryWithClients(clients),
+			Users:     infrastructure.NewUserRepositoryWithUsers(nil),
+			AuthCodes: infrastructure.NewAuthCodeRepository(),
+			Tokens:    infrastructure.NewTokenRespository(),
+			Consents:  infrastructure.NewConsentRepository(),
+			TOTP:      infrastructure.NewTOTPRepository(),
+			Passkeys:  infrastructure.NewPasskeyRepository(),
+			Sessions:  infrastructure.NewSessionStorage(sessionIdleTimeout, sessionAbsoluteTimeout),
+		}
+		if err := seedUsers(memory.Users, cfg); err != nil {
+			return nil, fmt.Errorf("save user: %w", err)
+		}
+		return &stores{
+			clients:  memory.Clients,
+			users:    memory.Users,
+			authCode: memory.AuthCodes,
+			tokens:   memory.Tokens,
+			consents: memory.Consents,
+			totp:     memory.TOTP,
+			passkeys: memory.Passkeys,
+			sessions: memory.Sessions,
+			memory:   memory,
+			close:    func() error { return nil },
+		}, nil
+	case config.StorageDriverSQLite:
+		db, err := sqlstore.Open(cfg.Storage.DSN)
+		if err != nil {
+			return nil, err
+		}
+		s := &stores{
+			clients:  sqlstore.NewClientRepository(db),
+			users:    sqlstore.NewUserRepository(db),
+			authCode: sqlstore.NewAuthCodeRepository(db),
+			tokens:   sqlstore.NewTokenRepository(db),
+			consents: sqlstore.NewConsentRepository(db),
+			totp:     sqlstore.NewTOTPRepository(db),
+			passkeys: sqlstore.NewPasskeyRepository(db),
+			sessions: sqlstore.NewSessionStorage(db, sessionIdleTimeout, sessionAbsoluteTimeout),
+			close:    db.Close,
+		}
+		if err := s.clients.ReplaceAll(clients); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("save clients: %w", err)
+		}
+		if err := seedUsers(s.users, cfg); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("save user: %w", err)
+		}
+		return s, nil
+	default:
+		return nil, fmt.Errorf("unknown storage driver %q", cfg.Storage.Driver)
+	}
+}
+
+// 設定したユーザーを登録する。登録済みのユーザーは、設定したパスワードが前回の登録から変わった場合のみ置き換える
+// ログイン時に再ハッシュしたパスワードのハッシュ値を、起動の度に設定の値に戻さないため
+func seedUsers(users infrastructure.UserStore, cfg *config.Config) error {
+	hasher := cfg.PasswordHasher()
+	for _, seed := range cfg.SeedUsers() {
+		existing, err := users.FindByLoginID(seed.LoginID)
+		if err != nil && !errors.Is(err, infrastructure.ErrUserNotFound) {
+			return err
+		}
+		if err == nil && existing.UserID() == seed.ID {
+			seeded, err := users.FindSeededPasswordHash(seed.LoginID)
+			if err != nil {
+				return err
+			}
+			if !seed.PasswordChanged(seeded, hasher) {
+				continue
+			}
+		}
+		if err := users.SaveSeedUser(seed.DomainUser(hasher)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
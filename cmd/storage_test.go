@@ -0,0 +1,105 @@
+package main
+
+import (
+	"oauth-tutorial/internal/config"
+	"oauth-tutorial/pkg/mylogger"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func Test_再起動しても再ハッシュしたパスワードのハッシュ値を保持する(t *testing.T) {
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("user-password"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("GenerateFromPassword() error = %v", err)
+	}
+	changedHash, err := bcrypt.GenerateFromPassword([]byte("changed-password"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("GenerateFromPassword() error = %v", err)
+	}
+	tests := []struct {
+		name    string
+		storage string
+	}{
+		{name: "sqlite", storage: "driver: sqlite\n  dsn: %s/oauth.db"},
+		{name: "スナップショット", storage: "driver: memory\n  snapshot:\n    file: %s/snapshot.bin\n    key: test-snapshot-key"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// given: bcryptのハッシュ値を設定したユーザー
+			dir := t.TempDir()
+			path := filepath.Join(dir, "config.yaml")
+			writeConfig := func(passwordHash string) *config.Config {
+				t.Helper()
+				writeConfigFile(t, path, `
+storage:
+  `+strings.ReplaceAll(tt.storage, "%s", dir)+`
+password_hashing:
+  memory: 64
+  iterations: 1
+  parallelism: 1
+users:
+  - id: user-1
+    login_id: user@example.com
+    password_hash: `+passwordHash+`
+`)
+				cfg, err := config.Load(path, func(string) string { return "" })
+				if err != nil {
+					t.Fatalf("Load() error = %v", err)
+				}
+				return cfg
+			}
+			// 起動してログインし、停止するまでに保存されたパスワードのハッシュ値を返す
+			boot := func(cfg *config.Config, password string) string {
+				t.Helper()
+				st, snap, err := openStoresFromConfig(mylogger.NewMockLogger(), cfg)
+				if err != nil {
+					t.Fatalf("openStoresFromConfig() error = %v", err)
+				}
+				defer st.close()
+				authenticator, err := newAuthenticator(mylogger.NewMockLogger(), cfg, st)
+				if err != nil {
+					t.Fatalf("newAuthenticator() error = %v", err)
+				}
+				if result, err := authenticator.Authenticate("user@example.com", password); err != nil || !result.Succeeded() {
+					t.Fatalf("Authenticate() = %+v, %v", result, err)
+				}
+				if snap != nil {
+					if err := snap.save(); err != nil {
+						t.Fatalf("save() error = %v", err)
+					}
+				}
+				user, err := st.users.FindByLoginID("user@example.com")
+				if err != nil {
+					t.Fatalf("FindByLoginID() error = %v", err)
+				}
+				return user.PasswordHash()
+			}
+			cfg := writeConfig(string(bcryptHash))
+			rehashed := boot(cfg, "user-password")
+			if !strings.HasPrefix(rehashed, "$argon2id$") {
+				t.Fatalf("PasswordHash() after login = %q, want argon2id", rehashed)
+			}
+
+			// when: 設定を変えずに再起動する
+			st, _, err := openStoresFromConfig(mylogger.NewMockLogger(), cfg)
+			if err != nil {
+				t.Fatalf("openStoresFromConfig() error = %v", err)
+			}
+			user, err := st.users.FindByLoginID("user@example.com")
+			st.close()
+
+			// then: 再ハッシュしたハッシュ値のまま
+			if err != nil || user.PasswordHash() != rehashed {
+				t.Errorf("PasswordHash() after restart = %+v, %v, want %q", user, err, rehashed)
+			}
+
+			// 設定のパスワードを変更した場合は置き換える
+			if changed := boot(writeConfig(string(changedHash)), "changed-password"); changed == rehashed {
+				t.Errorf("PasswordHash() after changing the config = %q, want replaced", changed)
+			}
+		})
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/base32"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"oauth-tutorial/internal/config"
+	"oauth-tutorial/internal/domain"
+	"oauth-tutorial/internal/session"
+	"oauth-tutorial/pkg/mylogger"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_TOTPによる2段階認証統合テスト(t *testing.T) {
+	// given: SQLiteに保存する認可サーバー
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	writeConfigFile(t, path, fmt.Sprintf(`
+server:
+  issuer: https://auth.example.com:8443
+storage:
+  driver: sqlite
+  dsn: %s
+scopes: [read]
+clients:
+  - id: partner
+    name: パートナー
+    type: confidential
+    secret: secret
+    redirect_uris: [https://partner.example.com/callback]
+users:
+  - id: alice
+    login_id: alice@example.com
+    password: password
+`, filepath.Join(dir, "oauth.db")))
+	cfg, err := config.Load(path, func(string) string { return "" })
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	realms, err := openRealms(mylogger.NewMockLogger(), cfg, renderer)
+	if err != nil {
+		t.Fatalf("openRealms() error = %v", err)
+	}
+	defer closeRealms(realms)
+	server := httptest.NewServer(newRealmRouter(realms))
+	defer server.Close()
+
+	client := &http.Client{
+		Timeout: 5 * time.Second,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	do := func(method, target, body string, cookie *http.Cookie) *http.Response {
+		req, _ := http.NewRequest(method, server.URL+target, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		return resp
+	}
+	decode := func(resp *http.Response) map[string]any {
+		defer resp.Body.Close()
+		var v map[string]any
+		if err := json.NewDecoder(resp.Body).Decode(&v); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		return v
+	}
+	readBody := func(resp *http.Response) string {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return string(body)
+	}
+	sessionCookie := func(resp *http.Response, current *http.Cookie) *http.Cookie {
+		for _, c := range resp.Cookies() {
+			if c.Name == session.SessionIDCookieName {
+				return c
+			}
+		}
+		return current
+	}
+	// 認可リクエストを始め、パスワードでログインした際のレスポンスとセッションCookieを返す
+	loginWithPassword := func(t *testing.T) (*http.Response, *http.Cookie) {
+		t.Helper()
+		resp := do("GET", "/authorize?response_type=code&client_id=partner&redirect_uri=https://partner.example.com/callback&state=xyz&scope=read", "", nil)
+		cookie := sessionCookie(resp, nil)
+		authorizeResult := decode(resp)
+		resp = do("POST", "/decision", fmt.Sprintf("approved=true&transaction_id=%s&csrf_token=%s&login_id=alice@example.com&password=password", authorizeResult["transaction_id"], authorizeResult["csrf_token"]), cookie)
+		return resp, sessionCookie(resp, cookie)
+	}
+	// パスワードの後に確認コードを送信し、レスポンスとセッションCookieを返す
+	loginWithOTP := func(t *testing.T, otp string) (*http.Response, *http.Cookie) {
+		t.Helper()
+		resp, cookie := loginWithPassword(t)
+		otpResult := decode(resp)
+		if resp.StatusCode != http.StatusOK || otpResult["prompt"] != "otp" {
+			t.Fatalf("Expected otp prompt, got %d: %v", resp.StatusCode, otpResult)
+		}
+		resp = do("POST", "/decision", fmt.Sprintf("transaction_id=%s&csrf_token=%s&otp=%s", otpResult["transaction_id"], otpResult["csrf_token"], url.QueryEscape(otp)), cookie)
+		return resp, sessionCookie(resp, cookie)
+	}
+	csrfTokenPattern := regexp.MustCompile(`name="csrf_token" value="([^"]+)"`)
+
+	// TOTPを登録する前はパスワードのみでログインできる
+	resp, cookie := loginWithPassword(t)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusSeeOther {
+		t.Fatalf("Expected redirect with code, got %d", resp.StatusCode)
+	}
+
+	// when: アカウント画面から認証アプリを登録し、最初のコードを確認する
+	page := readBody(do("GET", "/account/totp", "", cookie))
+	csrfToken := csrfTokenPattern.FindStringSubmatch(page)
+	if csrfToken == nil {
+		t.Fatalf("Expected csrf token in the page, got %s", page)
+	}
+	page = readBody(do("POST", "/account/totp", "csrf_token="+csrfToken[1], cookie))
+	if !strings.Contains(page, "otpauth://totp/auth.example.com:alice") {
+		t.Errorf("Expected provisioning URI labeled with the issuer host, got %s", page)
+	}
+	encodedSecret := regexp.MustCompile(`シークレット: <code>([A-Z2-7]+)</code>`).FindStringSubmatch(page)
+	if encodedSecret == nil {
+		t.Fatalf("Expected secret in the page, got %s", page)
+	}
+	secret, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(encodedSecret[1])
+	if err != nil {
+		t.Fatalf("Failed to decode secret: %v", err)
+	}
+	page = readBody(do("POST", "/account/totp/confirm", "csrf_token="+csrfToken[1]+"&otp="+domain.TOTPCode(secret, time.Now()), cookie))
+	var recoveryCodes []string
+	for _, m := range regexp.MustCompile(`<code>([A-Z2-7]{4}-[A-Z2-7]{4})</code>`).FindAllStringSubmatch(page, -1) {
+		recoveryCodes = append(recoveryCodes, m[1])
+	}
+	if len(recoveryCodes) != domain.RecoveryCodeCount {
+		t.Fatalf("Expected %d recovery codes, got %s", domain.RecoveryCodeCount, page)
+	}
+
+	// then: パスワードの後に確認コードを求め、amrにotpを記録する
+	// 確認に使ったステップのコードは使えないため、次のステップのコードを使う
+	nextCode := domain.TOTPCode(secret, time.Now().Add(domain.TOTPPeriod))
+	resp, cookie = loginWithOTP(t, nextCode)
+	resp.Body.Close()
+	location, _ := url.Parse(resp.Header.Get("Location"))
+	if resp.StatusCode != http.StatusSeeOther || location.Query().Get("code") == "" {
+		t.Fatalf("Expected redirect with code, got %d: %s", resp.StatusCode, resp.Header.Get("Location"))
+	}
+	sessionData, err := realms[0].stores.sessions.Get(session.SessionID(cookie.Value))
+	if err != nil || !slices.Equal(sessionData.AMR(), []string{domain.AMRPassword, domain.AMROTP}) {
+		t.Errorf("Expected session with amr [pwd otp], got %+v, %v", sessionData, err)
+	}
+
+	// 同じコードは再び使えない
+	resp, _ = loginWithOTP(t, nextCode)
+	if result := decode(resp); resp.StatusCode != http.StatusUnauthorized || result["prompt"] != "otp" {
+		t.Errorf("Expected the reused code to be rejected, got %d: %v", resp.StatusCode, result)
+	}
+
+	// リカバリーコードは1回のみ使える
+	resp, _ = loginWithOTP(t, strings.ToLower(recoveryCodes[0]))
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusSeeOther {
+		t.Errorf("Expected login with a recovery code, got %d", resp.StatusCode)
+	}
+	resp, _ = loginWithOTP(t, recoveryCodes[0])
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected the used recovery code to be rejected, got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,393 @@
+// サーバーの設定。設定ファイル(YAMLまたはJSON)から読み込み、環境変数で上書きする
+package config
+
+import (
+	"fmt"
+	"net"
+	"oauth-tutorial/internal/domain"
+	"oauth-tutorial/internal/infrastructure"
+	"oauth-tutorial/pkg/mycrypto"
+	"slices"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	StorageDriverMemory = "memory"
+	StorageDriverSQLite = "sqlite"
+
+	ClientTypeConfidential = "confidential"
+	ClientTypePublic       = "public"
+)
+
+// 設定ファイルで省略した項目は既定値(Default)のまま
+// 秘密情報は値を直接書く代わりに、xxx_fileで値を書いたファイルのパスを指定できる
+type Config struct {
+	Server    ServerConfig    `yaml:"server"`
+	Storage   StorageConfig   `yaml:"storage"`
+	Lifetimes LifetimesConfig `yaml:"lifetimes"`
+	// サポートするスコープ
+	Scopes []ScopeConfig `yaml:"scopes"`
+	// 起動時に登録するクライアントとユーザー。省略した場合は組み込みのクライアントとユーザーを登録する
+	Clients []ClientConfig `yaml:"clients"`
+	Users   []UserConfig   `yaml:"users"`
+	// ユーザーを登録したhtpasswd形式のファイル(パスワードはargon2idまたはbcryptのハッシュ値)。指定した場合はusersの代わりにこのファイルのユーザーでログインする
+	UsersFile string `yaml:"users_file"`
+	// パスワードのハッシュ化(Argon2id)のパラメータ。変更した場合、各ユーザーの次回のログイン時にハッシュ値を置き換える
+	PasswordHashing PasswordHashingConfig `yaml:"password_hashing"`
+	// ログイン画面から選択できる上流のIdP
+	IdentityProviders []IdentityProviderConfig `yaml:"identity_providers"`
+	// 上記のクライアント・ユーザー・スコープとは分離したレルム。上記は既定のレルムとしてパスのプレフィックスなしで受け付ける
+	Realms []RealmConfig `yaml:"realms"`
+}
+
+type ServerConfig struct {
+	// 待ち受けるアドレス。例: ":8080"
+	Addr string `yaml:"addr"`
+	// 認可サーバーの識別子。httpsのURL(ループバックアドレスの場合はhttpも可)
+	// 認可レスポンスのissとメタデータに使う。省略した場合はaddrのポートで待ち受けるループバックアドレスのURL
+	Issuer string    `yaml:"issuer"`
+	TLS    TLSConfig `yaml:"tls"`
+	// /decisionのCSRFトークンを発行する鍵。未指定の場合は起動毎に生成する
+	CSRFSecret     string `yaml:"csrf_secret"`
+	CSRFSecretFile string `yaml:"csrf_secret_file"`
+	// 埋め込みのテンプレートの代わりに使うテンプレートのディレクトリ
+	TemplateDir string `yaml:"template_dir"`
+	// /debug/varsで統計情報を公開する管理用のアドレス。例: "127.0.0.1:9090"
+	// 起動時の引数やメモリの統計を含むため、addrとは別のアドレスで待ち受ける。省略した場合は公開しない
+	MetricsAddr string `yaml:"metrics_addr"`
+}
+
+// 証明書と秘密鍵の両方を指定した場合はHTTPSで待ち受ける
+type TLSConfig struct {
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+}
+
+func (c TLSConfig) Enabled() bool {
+	return c.CertFile != "" || c.KeyFile != ""
+}
+
+type StorageConfig struct {
+	// memoryまたはsqlite
+	Driver string `yaml:"driver"`
+	// sqliteの場合のデータベースファイルのパス
+	DSN      string         `yaml:"dsn"`
+	Snapshot SnapshotConfig `yaml:"snapshot"`
+	Purge    PurgeConfig    `yaml:"purge"`
+}
+
+// インメモリの実装の内容を暗号化して保存するファイル。fileが空の場合は保存しない
+type SnapshotConfig struct {
+	File     string        `yaml:"file"`
+	Key      string        `yaml:"key"`
+	KeyFile  string        `yaml:"key_file"`
+	Interval time.Duration `yaml:"interval"`
+}
+
+// 有効期限切れのデータを削除する間隔と、1回の削除で削除する最大の件数
+type PurgeConfig struct {
+	Interval  time.Duration `yaml:"interval"`
+	BatchSize int           `yaml:"batch_size"`
+}
+
+// 有効期間。"10m"や"24h"のように指定する
+type LifetimesConfig struct {
+	AuthorizationCode time.Duration `yaml:"authorization_code"`
+	AccessToken       time.Duration `yaml:"access_token"`
+	RefreshToken      time.Duration `yaml:"refresh_token"`
+	// 同意の有効期間。0の場合は無期限
+	Consent time.Duration `yaml:"consent"`
+	// ブラウザセッションの最終アクセスからと作成からの有効期間。0の場合は無期限
+	SessionIdle     time.Duration `yaml:"session_idle"`
+	SessionAbsolute time.Duration `yaml:"session_absolute"`
+}
+
+type ClientConfig struct {
+	ID   string `yaml:"id"`
+	Name string `yaml:"name"`
+	// confidentialまたはpublic
+	Type       string `yaml:"type"`
+	Secret     string `yaml:"secret"`
+	SecretFile string `yaml:"secret_file"`
+	// webまたはnative。省略した場合はweb
+	// nativeの場合のみカスタムスキーム(例: com.example.app:/callback)のリダイレクトURIを登録でき、ループバックアドレスのリダイレクトURIは任意のポートに一致する
+	ApplicationType string   `yaml:"application_type"`
+	RedirectURIs    []string `yaml:"redirect_uris"`
+	// 許可するgrant_typeとresponse_type。省略した場合はauthorization_codeとrefresh_token、code
+	GrantTypes    []string `yaml:"grant_types"`
+	ResponseTypes []string `yaml:"response_types"`
+	// 要求できるスコープ。省略した場合はscopesの全て
+	Scopes []string `yaml:"scopes"`
+	// scopeを省略した認可リクエストのスコープ。省略した場合はscopeを必須にする
+	DefaultScopes []string `yaml:"default_scopes"`
+	// client_secret_basic、client_secret_postまたはnone
+	// 省略した場合、confidentialはclient_secret_basicとclient_secret_postのどちらも使え、publicはnone
+	TokenEndpointAuthMethod string `yaml:"token_endpoint_auth_method"`
+	// 省略した項目はlifetimesの値を使う
+	Lifetimes ClientLifetimesConfig `yaml:"lifetimes"`
+	// falseの場合はリフレッシュトークンを発行しない。省略した場合はtrue
+	IssueRefreshToken *bool `yaml:"issue_refresh_token"`
+}
+
+type ClientLifetimesConfig struct {
+	AuthorizationCode time.Duration `yaml:"authorization_code"`
+	AccessToken       time.Duration `yaml:"access_token"`
+	RefreshToken      time.Duration `yaml:"refresh_token"`
+}
+
+// スコープの定義。名前のみを書いた場合(scopes: [read, write])は説明を省略したスコープ
+// 名前に{パラメータ名}を含む場合はパラメータ付きのスコープ(例: repo:{repo_id}:read)
+type ScopeConfig struct {
+	Name        string `yaml:"name"`
+	DisplayName string `yaml:"display_name"`
+	Description string `yaml:"description"`
+	// low、mediumまたはhigh。省略した場合はlow
+	Sensitivity string `yaml:"sensitivity"`
+	// このスコープが含むスコープ。例: writeはreadを含む
+	Implies []string `yaml:"implies"`
+	// パラメータ名毎の値の形式(正規表現)
+	Parameters map[string]string `yaml:"parameters"`
+}
+
+func (s *ScopeConfig) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*s = ScopeConfig{Name: node.Value}
+		return nil
+	}
+	// node.Decodeでは未知の項目を検出できないため、項目名を検証する
+	if node.Kind == yaml.MappingNode {
+		for i := 0; i < len(node.Content); i += 2 {
+			key := node.Content[i]
+			if !slices.Contains(scopeConfigFields, key.Value) {
+				return fmt.Errorf("line %d: field %s not found in type config.ScopeConfig", key.Line, key.Value)
+			}
+		}
+	}
+	type plain ScopeConfig
+	return node.Decode((*plain)(s))
+}
+
+var scopeConfigFields = []string{"name", "display_name", "description", "sensitivity", "implies", "parameters"}
+
+// passwordかpassword_hashのどちらか一方を指定する。平文のpasswordは読み込み時にハッシュ化する
+// 設定ファイルの平文のpasswordは、hash-passwordsサブコマンドでpassword_hashに置き換えられる
+type UserConfig struct {
+	ID           string `yaml:"id"`
+	LoginID      string `yaml:"login_id"`
+	Password     string `yaml:"password"`
+	PasswordFile string `yaml:"password_file"`
+	// mycrypto.PasswordHasherの形式(argon2id)またはbcryptのハッシュ値
+	PasswordHash string `yaml:"password_hash"`
+}
+
+type PasswordHashingConfig struct {
+	// メモリ使用量(KiB)
+	Memory      uint32 `yaml:"memory"`
+	Iterations  uint32 `yaml:"iterations"`
+	Parallelism uint8  `yaml:"parallelism"`
+}
+
+// 設定ファイルを使わない場合の設定
+func Default() *Config {
+	lifetimes := domain.DefaultLifetimes()
+	return &Config{
+		Server: ServerConfig{Addr: ":8080"},
+		Storage: StorageConfig{
+			Driver:   StorageDriverMemory,
+			DSN:      "oauth.db",
+			Snapshot: SnapshotConfig{Interval: 5 * time.Minute},
+			Purge:    PurgeConfig{Interval: time.Minute, BatchSize: 500},
+		},
+		Lifetimes: LifetimesConfig{
+			AuthorizationCode: lifetimes.AuthorizationCode,
+			AccessToken:       lifetimes.AccessToken,
+			RefreshToken:      lifetimes.RefreshToken,
+			SessionIdle:       time.Hour,
+			SessionAbsolute:   24 * time.Hour,
+		},
+		Scopes:          defaultScopes(),
+		PasswordHashing: defaultPasswordHashing(),
+	}
+}
+
+func defaultPasswordHashing() PasswordHashingConfig {
+	params := mycrypto.DefaultArgon2Params()
+	return PasswordHashingConfig{Memory: params.Memory, Iterations: params.Iterations, Parallelism: params.Parallelism}
+}
+
+func defaultScopes() []ScopeConfig {
+	definitions := domain.DefaultScopeDefinitions()
+	scopes := make([]ScopeConfig, 0, len(definitions))
+	for _, d := range definitions {
+		scopes = append(scopes, ScopeConfig{
+			Name:        d.Name,
+			DisplayName: d.DisplayName,
+			Description: d.Description,
+			Sensitivity: d.Sensitivity.String(),
+			Implies:     d.Implies,
+			Parameters:  d.Parameters,
+		})
+	}
+	return scopes
+}
+
+// スコープの名前の一覧
+func (c *Config) ScopeNames() []string {
+	names := make([]string, 0, len(c.Scopes))
+	for _, scope := range c.Scopes {
+		names = append(names, scope.Name)
+	}
+	return names
+}
+
+// サポートするスコープの一覧を構築する。定義に問題がある場合はエラー
+func (c *Config) ScopeRegistry() (*domain.ScopeRegistry, error) {
+	definitions := make([]domain.ScopeDefinition, 0, len(c.Scopes))
+	for _, scope := range c.Scopes {
+		sensitivity := domain.ScopeSensitivityLow
+		if scope.Sensitivity != "" {
+			s, err := domain.ParseScopeSensitivity(scope.Sensitivity)
+			if err != nil {
+				return nil, err
+			}
+			sensitivity = s
+		}
+		definitions = append(definitions, domain.ScopeDefinition{
+			Name:        scope.Name,
+			DisplayName: scope.DisplayName,
+			Description: scope.Description,
+			Sensitivity: sensitivity,
+			Implies:     scope.Implies,
+			Parameters:  scope.Parameters,
+		})
+	}
+	return domain.NewScopeRegistry(definitions)
+}
+
+// 認可サーバーの識別子。省略した場合はaddrのポートで待ち受けるループバックアドレスのURL(例: http://127.0.0.1:8080)
+// httpの識別子はserver.issuerと同じくループバックアドレスのIPリテラルとし、TLSの場合はlocalhostとする
+func (c *Config) Issuer() string {
+	if c.Server.Issuer != "" {
+		return c.Server.Issuer
+	}
+	scheme, loopback := "http", "127.0.0.1"
+	if c.Server.TLS.Enabled() {
+		scheme, loopback = "https", "localhost"
+	}
+	host, port, err := net.SplitHostPort(c.Server.Addr)
+	if err != nil {
+		return scheme + "://" + loopback
+	}
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = loopback
+	}
+	return scheme + "://" + net.JoinHostPort(host, port)
+}
+
+func (c *Config) DomainLifetimes() domain.Lifetimes {
+	return domain.Lifetimes{
+		AuthorizationCode: c.Lifetimes.AuthorizationCode,
+		AccessToken:       c.Lifetimes.AccessToken,
+		RefreshToken:      c.Lifetimes.RefreshToken,
+	}
+}
+
+// 起動時に登録するクライアント。設定していない場合は組み込みのクライアント
+func (c *Config) SeedClients() []*domain.Client {
+	if len(c.Clients) == 0 {
+		return infrastructure.DefaultClients()
+	}
+	return c.DomainClients()
+}
+
+// 検証済みの設定に対してのみ呼び出すこと
+func (c *Config) DomainClients() []*domain.Client {
+	clients := make([]*domain.Client, 0, len(c.Clients))
+	for _, client := range c.Clients {
+		clientType := domain.ConfidentialClient
+		if client.Type == ClientTypePublic {
+			clientType = domain.PublicClient
+		}
+		clients = append(clients, domain.ReconstructClientWithPolicy(domain.ClientID(client.ID), client.Name, clientType, client.Secret, client.RedirectURIs, client.policy()))
+	}
+	return clients
+}
+
+func (c ClientConfig) policy() domain.ClientPolicy {
+	policy := domain.DefaultClientPolicy()
+	if len(c.GrantTypes) > 0 {
+		policy.GrantTypes = nil
+		for _, value := range c.GrantTypes {
+			grantType, _ := domain.ResolveGrantType(value)
+			policy.GrantTypes = append(policy.GrantTypes, grantType)
+		}
+	}
+	if len(c.ResponseTypes) > 0 {
+		policy.ResponseTypes = nil
+		for _, value := range c.ResponseTypes {
+			responseType, _ := domain.GetResponseType(value)
+			policy.ResponseTypes = append(policy.ResponseTypes, responseType)
+		}
+	}
+	policy.Scopes = c.Scopes
+	policy.DefaultScopes = c.DefaultScopes
+	policy.TokenEndpointAuthMethod = domain.TokenEndpointAuthMethod(c.TokenEndpointAuthMethod)
+	policy.Lifetimes = domain.Lifetimes{
+		AuthorizationCode: c.Lifetimes.AuthorizationCode,
+		AccessToken:       c.Lifetimes.AccessToken,
+		RefreshToken:      c.Lifetimes.RefreshToken,
+	}
+	if c.IssueRefreshToken != nil {
+		policy.IssueRefreshToken = *c.IssueRefreshToken
+	}
+	policy.ApplicationType = domain.ApplicationType(c.ApplicationType)
+	return policy
+}
+
+// パスワードのハッシュ化の実装
+func (c *Config) PasswordHasher() *mycrypto.PasswordHasher {
+	params := mycrypto.DefaultArgon2Params()
+	params.Memory, params.Iterations, params.Parallelism = c.PasswordHashing.Memory, c.PasswordHashing.Iterations, c.PasswordHashing.Parallelism
+	return mycrypto.NewPasswordHasher(params)
+}
+
+// 起動時に登録するユーザーの設定。設定していない場合は組み込みのユーザー
+// users_fileを指定した場合はファイルのユーザーを登録するため、ここでは登録しない
+func (c *Config) SeedUsers() []UserConfig {
+	if c.UsersFile != "" {
+		return nil
+	}
+	if len(c.Users) == 0 {
+		defaults := infrastructure.DefaultUsers()
+		users := make([]UserConfig, 0, len(defaults))
+		for _, user := range defaults {
+			users = append(users, UserConfig{ID: user.UserID(), LoginID: user.LoginID(), PasswordHash: user.PasswordHash()})
+		}
+		return users
+	}
+	return c.Users
+}
+
+// 平文のpasswordを指定したユーザーは、呼び出し毎に新しいソルトでハッシュ化する
+func (u UserConfig) DomainUser(hasher *mycrypto.PasswordHasher) *domain.User {
+	passwordHash := u.PasswordHash
+	if passwordHash == "" {
+		passwordHash = hasher.Hash(u.Password)
+	}
+	return domain.ReconstructUser(u.ID, u.LoginID, passwordHash)
+}
+
+// 前回登録した時の設定のパスワードのハッシュ値(seeded)から、設定したパスワードが変わったか
+// 平文のpasswordはハッシュ値と照合し、一致しない場合に変わったとみなす
+func (u UserConfig) PasswordChanged(seeded string, hasher *mycrypto.PasswordHasher) bool {
+	if u.PasswordHash != "" {
+		return u.PasswordHash != seeded
+	}
+	if seeded == "" {
+		return true
+	}
+	match, _, err := hasher.Verify(seeded, u.Password)
+	return err != nil || !match
+}
@@ -0,0 +1,839 @@
+package config
+
+import (
+	"oauth-tutorial/internal/domain"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// テスト用の一時ファイルを作成し、パスを返す
+func writeFile(t *testing.T, name string, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	return path
+}
+
+func env(values map[string]string) func(string) string {
+	return func(name string) string { return values[name] }
+}
+
+func Test_設定ファイルの読み込み(t *testing.T) {
+	secretFile := writeFile(t, "client-secret", "file-secret\n")
+	yamlFile := writeFile(t, "config.yaml", `
+server:
+  addr: ":9000"
+  issuer: https://auth.example.com
+  tls:
+    cert_file: /etc/tls/cert.pem
+    key_file: /etc/tls/key.pem
+storage:
+  driver: sqlite
+  dsn: /var/lib/oauth/oauth.db
+  purge:
+    batch_size: 100
+lifetimes:
+  authorization_code: 1m
+  access_token: 15m
+  refresh_token: 720h
+  consent: 2160h
+scopes: [read, write, admin]
+clients:
+  - id: partner
+    name: パートナー
+    type: confidential
+    secret_file: `+secretFile+`
+    redirect_uris: [https://partner.example.com/callback]
+  - id: spa
+    name: SPA
+    type: public
+    redirect_uris: [https://spa.example.com/callback]
+users:
+  - id: user-1
+    login_id: user@example.com
+    password: password
+`)
+	jsonFile := writeFile(t, "config.json", `{
+  "server": {"addr": ":9000", "issuer": "https://auth.example.com", "tls": {"cert_file": "/etc/tls/cert.pem", "key_file": "/etc/tls/key.pem"}},
+  "storage": {"driver": "sqlite", "dsn": "/var/lib/oauth/oauth.db", "purge": {"batch_size": 100}},
+  "lifetimes": {"authorization_code": "1m", "access_token": "15m", "refresh_token": "720h", "consent": "2160h"},
+  "scopes": ["read", "write", "admin"],
+  "clients": [
+    {"id": "partner", "name": "パートナー", "type": "confidential", "secret_file": "`+secretFile+`", "redirect_uris": ["https://partner.example.com/callback"]},
+    {"id": "spa", "name": "SPA", "type": "public", "redirect_uris": ["https://spa.example.com/callback"]}
+  ],
+  "users": [{"id": "user-1", "login_id": "user@example.com", "password": "password"}]
+}`)
+
+	for _, path := range []string{yamlFile, jsonFile} {
+		t.Run(filepath.Ext(path), func(t *testing.T) {
+			// when
+			cfg, err := Load(path, env(nil))
+
+			// then
+			if err != nil {
+				t.Fatalf("Load() error = %v", err)
+			}
+			if cfg.Server.Addr != ":9000" || cfg.Server.Issuer != "https://auth.example.com" || !cfg.Server.TLS.Enabled() {
+				t.Errorf("Server = %+v", cfg.Server)
+			}
+			if cfg.Storage.Driver != StorageDriverSQLite || cfg.Storage.DSN != "/var/lib/oauth/oauth.db" {
+				t.Errorf("Storage = %+v", cfg.Storage)
+			}
+			// 省略した項目は既定値のまま
+			if cfg.Storage.Purge.BatchSize != 100 || cfg.Storage.Purge.Interval != time.Minute {
+				t.Errorf("Storage.Purge = %+v, want BatchSize=100 Interval=1m", cfg.Storage.Purge)
+			}
+			lifetimes := cfg.DomainLifetimes()
+			if lifetimes.AuthorizationCode != time.Minute || lifetimes.AccessToken != 15*time.Minute || lifetimes.RefreshToken != 720*time.Hour {
+				t.Errorf("DomainLifetimes() = %+v", lifetimes)
+			}
+			if cfg.Lifetimes.Consent != 2160*time.Hour || cfg.Lifetimes.SessionIdle != time.Hour {
+				t.Errorf("Lifetimes = %+v", cfg.Lifetimes)
+			}
+			if !slices.Equal(cfg.ScopeNames(), []string{"read", "write", "admin"}) {
+				t.Errorf("Scopes = %v", cfg.Scopes)
+			}
+			clients := cfg.DomainClients()
+			if len(clients) != 2 || clients[0].Secret() != "file-secret" || clients[0].ClientName() != "パートナー" {
+				t.Errorf("DomainClients() = %+v", clients)
+			}
+			users := cfg.SeedUsers()
+			if len(users) != 1 || users[0].LoginID != "user@example.com" {
+				t.Errorf("SeedUsers() = %+v", users)
+			}
+		})
+	}
+}
+
+func Test_クライアントのポリシーの読み込み(t *testing.T) {
+	// given
+	path := writeFile(t, "config.yaml", `
+scopes: [read, write]
+clients:
+  - id: spa
+    name: SPA
+    type: public
+    redirect_uris: [https://spa.example.com/callback]
+    grant_types: [authorization_code]
+    scopes: [read]
+    default_scopes: [read]
+    lifetimes:
+      access_token: 5m
+    issue_refresh_token: false
+  - id: partner
+    name: パートナー
+    type: confidential
+    secret: secret
+    redirect_uris: [https://partner.example.com/callback]
+`)
+
+	// when
+	cfg, err := Load(path, env(nil))
+
+	// then
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	clients := cfg.DomainClients()
+	expected := domain.ClientPolicy{
+		GrantTypes:    []domain.GrantType{domain.GrantTypeAuthorizationCode},
+		ResponseTypes: []domain.ResponseType{domain.ResponseTypeCode},
+		Scopes:        []string{"read"},
+		DefaultScopes: []string{"read"},
+		Lifetimes:     domain.Lifetimes{AccessToken: 5 * time.Minute},
+	}
+	if !reflect.DeepEqual(clients[0].Policy(), expected) {
+		t.Errorf("Policy() = %+v, want %+v", clients[0].Policy(), expected)
+	}
+	// 省略した場合は既定のポリシー
+	if !reflect.DeepEqual(clients[1].Policy(), domain.DefaultClientPolicy()) {
+		t.Errorf("Policy() = %+v, want %+v", clients[1].Policy(), domain.DefaultClientPolicy())
+	}
+}
+
+func Test_環境変数による上書き(t *testing.T) {
+	// given
+	keyFile := writeFile(t, "snapshot-key", "snapshot-secret\n")
+	path := writeFile(t, "config.yaml", `
+server:
+  addr: ":9000"
+lifetimes:
+  access_token: 15m
+`)
+
+	// when
+	cfg, err := Load(path, env(map[string]string{
+		"LISTEN_ADDR":           ":9443",
+		"ACCESS_TOKEN_LIFETIME": "5m",
+		"SNAPSHOT_FILE":         "/tmp/oauth.snapshot",
+		"SNAPSHOT_KEY_FILE":     keyFile,
+		"SCOPES":                "openid profile",
+	}))
+
+	// then
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.Server.Addr != ":9443" || cfg.Lifetimes.AccessToken != 5*time.Minute {
+		t.Errorf("Addr = %v, AccessToken = %v, want :9443, 5m", cfg.Server.Addr, cfg.Lifetimes.AccessToken)
+	}
+	if cfg.Storage.Snapshot.Key != "snapshot-secret" {
+		t.Errorf("Snapshot.Key = %q, want the content of the key file", cfg.Storage.Snapshot.Key)
+	}
+	if !slices.Equal(cfg.ScopeNames(), []string{"openid", "profile"}) {
+		t.Errorf("Scopes = %v", cfg.Scopes)
+	}
+}
+
+func Test_スコープの定義の読み込み(t *testing.T) {
+	path := writeFile(t, "config.yaml", `
+scopes:
+  - read
+  - name: write
+    display_name: 作成・更新
+    description: データの作成・更新
+    implies: [read]
+  - name: "repo:{repo_id}:read"
+    display_name: "リポジトリ{repo_id}の参照"
+    sensitivity: high
+    parameters:
+      repo_id: "[0-9]+"
+clients:
+  - id: client-1
+    name: クライアント1
+    type: public
+    redirect_uris: [https://client.example.com/callback]
+    scopes: [write, "repo:{repo_id}:read"]
+    default_scopes: [read, "repo:1:read"]
+`)
+
+	// when
+	cfg, err := Load(path, env(nil))
+
+	// then
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	registry, err := cfg.ScopeRegistry()
+	if err != nil {
+		t.Fatalf("ScopeRegistry() error = %v", err)
+	}
+	if !slices.Equal(registry.Names(), []string{"read", "write"}) {
+		t.Errorf("Names() = %v, want [read write]", registry.Names())
+	}
+	expected := domain.ScopeDescription{Scope: "repo:42:read", DisplayName: "リポジトリ42の参照", Description: "repo:42:read", Sensitivity: domain.ScopeSensitivityHigh}
+	if actual := registry.Describe("repo:42:read"); actual != expected {
+		t.Errorf("Describe() = %+v, want %+v", actual, expected)
+	}
+	if !registry.Covers([]string{"write"}, []string{"read"}) {
+		t.Error("Covers(write, read) = false, want true")
+	}
+
+	// SCOPESで名前のみ指定した場合も、設定ファイルの定義を使う
+	cfg, err = Load(path, env(map[string]string{"SCOPES": "read write repo:{repo_id}:read"}))
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.Scopes[1].DisplayName != "作成・更新" {
+		t.Errorf("Scopes[1] = %+v, want the definition in the config file", cfg.Scopes[1])
+	}
+}
+
+func Test_認可サーバーの識別子の検証(t *testing.T) {
+	tests := []struct {
+		issuer  string
+		wantErr bool
+	}{
+		{issuer: "https://auth.example.com"},
+		{issuer: "https://localhost:8443"},
+		{issuer: "http://127.0.0.1:8080"},
+		{issuer: "http://[::1]:8080"},
+		// リダイレクトURIと同じく、名前解決で別のホストを指し得るlocalhostはhttpを許可しない
+		{issuer: "http://localhost:8080", wantErr: true},
+		{issuer: "http://auth.example.com", wantErr: true},
+		{issuer: "https://auth.example.com?tenant=1", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.issuer, func(t *testing.T) {
+			if err := validateIssuer(tt.issuer); (err != nil) != tt.wantErr {
+				t.Errorf("validateIssuer(%q) error = %v, wantErr %v", tt.issuer, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_認可サーバーの識別子(t *testing.T) {
+	tests := []struct {
+		name     string
+		server   ServerConfig
+		expected string
+	}{
+		{name: "指定した場合はその値", server: ServerConfig{Addr: ":8080", Issuer: "https://auth.example.com/tenant"}, expected: "https://auth.example.com/tenant"},
+		{name: "省略した場合はループバックアドレス", server: ServerConfig{Addr: ":8080"}, expected: "http://127.0.0.1:8080"},
+		{name: "全てのアドレスで待ち受ける場合はループバックアドレス", server: ServerConfig{Addr: "0.0.0.0:9000"}, expected: "http://127.0.0.1:9000"},
+		{name: "待ち受けるアドレスを指定した場合はそのアドレス", server: ServerConfig{Addr: "127.0.0.1:9000"}, expected: "http://127.0.0.1:9000"},
+		{name: "TLSの場合はhttps", server: ServerConfig{Addr: ":8443", TLS: TLSConfig{CertFile: "cert.pem", KeyFile: "key.pem"}}, expected: "https://localhost:8443"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Default()
+			cfg.Server = tt.server
+			if actual := cfg.Issuer(); actual != tt.expected {
+				t.Errorf("Issuer() = %v, want %v", actual, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_レルムの設定(t *testing.T) {
+	// given
+	path := writeFile(t, "config.yaml", `
+server:
+  issuer: https://auth.example.com:8443
+  csrf_secret: default-secret
+storage:
+  driver: sqlite
+  dsn: /var/lib/oauth/oauth.db
+lifetimes:
+  access_token: 15m
+scopes: [read, write]
+realms:
+  - name: sales
+    csrf_secret: sales-secret
+    lifetimes:
+      refresh_token: 24h
+    clients:
+      - id: partner
+        name: パートナー
+        type: public
+        redirect_uris: [https://partner.example.com/callback]
+    users:
+      - id: sales-user
+        login_id: sales@example.com
+        password: password
+  - name: support
+    host: support.example.com
+    scopes: [tickets]
+    storage:
+      dsn: /var/lib/oauth/support.db
+    clients:
+      - id: desk
+        name: サポートデスク
+        type: public
+        redirect_uris: [https://desk.example.com/callback]
+    users:
+      - id: support-user
+        login_id: support@example.com
+        password: password
+`)
+
+	// when
+	cfg, err := Load(path, env(nil))
+
+	// then
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if got := cfg.RealmNames(); !slices.Equal(got, []string{"sales", "support"}) {
+		t.Errorf("RealmNames() = %v", got)
+	}
+	if cfg.Realm("unknown") != nil {
+		t.Error("Realm(unknown) != nil")
+	}
+
+	sales := cfg.Realm("sales")
+	if sales.Issuer() != "https://auth.example.com:8443/realms/sales" {
+		t.Errorf("Issuer() = %v", sales.Issuer())
+	}
+	if sales.Server.CSRFSecret != "sales-secret" {
+		t.Errorf("Server.CSRFSecret = %v", sales.Server.CSRFSecret)
+	}
+	// 永続化の実装は共通で、データベースのファイルをレルム毎に分ける
+	if sales.Storage.Driver != StorageDriverSQLite || sales.Storage.DSN != "/var/lib/oauth/oauth.sales.db" {
+		t.Errorf("Storage = %+v", sales.Storage)
+	}
+	// 省略した有効期間は全体の値を使う
+	if sales.Lifetimes.AccessToken != 15*time.Minute || sales.Lifetimes.RefreshToken != 24*time.Hour ||
+		sales.Lifetimes.AuthorizationCode != cfg.Lifetimes.AuthorizationCode {
+		t.Errorf("Lifetimes = %+v", sales.Lifetimes)
+	}
+	// 省略したスコープは全体の定義を使う
+	if got := sales.ScopeNames(); !slices.Equal(got, []string{"read", "write"}) {
+		t.Errorf("ScopeNames() = %v", got)
+	}
+	if len(sales.Clients) != 1 || sales.Clients[0].ID != "partner" || len(sales.Users) != 1 || sales.Users[0].ID != "sales-user" {
+		t.Errorf("Clients = %v, Users = %v", sales.Clients, sales.Users)
+	}
+	if len(sales.Realms) != 0 {
+		t.Errorf("Realms = %v, want empty", sales.Realms)
+	}
+
+	support := cfg.Realm("support")
+	// ホスト名で選択するレルムは、server.issuerのホスト名を置き換えた識別子
+	if support.Issuer() != "https://support.example.com:8443" {
+		t.Errorf("Issuer() = %v", support.Issuer())
+	}
+	// 未指定の場合は起動毎に生成する
+	if support.Server.CSRFSecret != "" {
+		t.Errorf("Server.CSRFSecret = %v, want empty", support.Server.CSRFSecret)
+	}
+	if support.Storage.DSN != "/var/lib/oauth/support.db" {
+		t.Errorf("Storage.DSN = %v", support.Storage.DSN)
+	}
+	if got := support.ScopeNames(); !slices.Equal(got, []string{"tickets"}) {
+		t.Errorf("ScopeNames() = %v", got)
+	}
+}
+
+func Test_上流のIdPの設定(t *testing.T) {
+	// given
+	secretFile := writeFile(t, "idp-secret", "idp-file-secret\n")
+	path := writeFile(t, "config.yaml", `
+identity_providers:
+  - name: corp
+    issuer: https://idp.example.com
+    client_id: oauth-tutorial
+    client_secret_file: `+secretFile+`
+  - name: partner
+    display_name: パートナーのアカウント
+    issuer: https://partner-idp.example.com
+    client_id: oauth-tutorial
+    scopes: [openid]
+    login_id_claim: preferred_username
+realms:
+  - name: sales
+    clients:
+      - id: partner
+        name: パートナー
+        type: public
+        redirect_uris: [https://partner.example.com/callback]
+    users:
+      - id: sales-user
+        login_id: sales@example.com
+        password: password
+`)
+
+	// when
+	cfg, err := Load(path, env(nil))
+
+	// then
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if len(cfg.IdentityProviders) != 2 {
+		t.Fatalf("IdentityProviders = %+v", cfg.IdentityProviders)
+	}
+	corp, partner := cfg.IdentityProviders[0], cfg.IdentityProviders[1]
+	if corp.ClientSecret != "idp-file-secret" {
+		t.Errorf("ClientSecret = %q", corp.ClientSecret)
+	}
+	// 省略した項目は既定値
+	if corp.DisplayNameOrDefault() != "corp" || !slices.Equal(corp.ScopesOrDefault(), []string{"openid", "email", "profile"}) || corp.LoginIDClaimOrDefault() != "email" {
+		t.Errorf("corp = %q, %v, %q", corp.DisplayNameOrDefault(), corp.ScopesOrDefault(), corp.LoginIDClaimOrDefault())
+	}
+	if partner.DisplayNameOrDefault() != "パートナーのアカウント" || !slices.Equal(partner.ScopesOrDefault(), []string{"openid"}) || partner.LoginIDClaimOrDefault() != "preferred_username" {
+		t.Errorf("partner = %q, %v, %q", partner.DisplayNameOrDefault(), partner.ScopesOrDefault(), partner.LoginIDClaimOrDefault())
+	}
+	// レルムは既定のレルムのIdPを使わない
+	if got := cfg.Realm("sales").IdentityProviders; len(got) != 0 {
+		t.Errorf("Realm(sales).IdentityProviders = %v, want empty", got)
+	}
+}
+
+func Test_パスワードのハッシュ化の設定(t *testing.T) {
+	// given
+	path := writeFile(t, "config.yaml", `
+password_hashing:
+  memory: 64
+  iterations: 1
+  parallelism: 1
+users:
+  - id: user-1
+    login_id: user1@example.com
+    password: password1
+  - id: user-2
+    login_id: user2@example.com
+    password_hash: $argon2id$v=19$m=64,t=1,p=1$c2FsdHNhbHRzYWx0c2FsdA$0Xb0oTsFIDZUNaAvlhX2z5nS7EDvLMDp03pHhPEX9io
+`)
+
+	// when
+	cfg, err := Load(path, env(nil))
+
+	// then
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	hasher := cfg.PasswordHasher()
+	users := []*domain.User{cfg.Users[0].DomainUser(hasher), cfg.Users[1].DomainUser(hasher)}
+	// 平文のパスワードはハッシュ化し、ハッシュ値はそのまま使う
+	if !strings.HasPrefix(users[0].PasswordHash(), "$argon2id$v=19$m=64,t=1,p=1$") {
+		t.Errorf("PasswordHash() = %q", users[0].PasswordHash())
+	}
+	if users[1].PasswordHash() != cfg.Users[1].PasswordHash {
+		t.Errorf("PasswordHash() = %q, want %q", users[1].PasswordHash(), cfg.Users[1].PasswordHash)
+	}
+	if match, needsRehash, err := hasher.Verify(users[0].PasswordHash(), "password1"); !match || needsRehash || err != nil {
+		t.Errorf("Verify() = %v, %v, %v, want true, false, nil", match, needsRehash, err)
+	}
+
+	// 前回登録した時のハッシュ値から、設定したパスワードが変わったかを判定する
+	tests := []struct {
+		name     string
+		user     UserConfig
+		seeded   string
+		expected bool
+	}{
+		{name: "平文のパスワードが一致する", user: cfg.Users[0], seeded: users[0].PasswordHash()},
+		{name: "平文のパスワードを変更した", user: UserConfig{ID: "user-1", Password: "changed"}, seeded: users[0].PasswordHash(), expected: true},
+		{name: "ハッシュ値が一致する", user: cfg.Users[1], seeded: cfg.Users[1].PasswordHash},
+		{name: "ハッシュ値を変更した", user: cfg.Users[1], seeded: users[0].PasswordHash(), expected: true},
+		{name: "設定から登録していない", user: cfg.Users[0], seeded: "", expected: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if actual := tt.user.PasswordChanged(tt.seeded, hasher); actual != tt.expected {
+				t.Errorf("PasswordChanged() = %v, want %v", actual, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_設定ファイルを指定しない場合(t *testing.T) {
+	// when
+	cfg, err := Load("", env(nil))
+
+	// then
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.Server.Addr != ":8080" || cfg.Storage.Driver != StorageDriverMemory || cfg.Server.TLS.Enabled() {
+		t.Errorf("Load() = %+v", cfg)
+	}
+	// 組み込みのクライアント・ユーザーを使う
+	if len(cfg.Clients) != 0 || len(cfg.Users) != 0 {
+		t.Errorf("Clients = %v, Users = %v, want empty", cfg.Clients, cfg.Users)
+	}
+}
+
+func Test_設定の検証(t *testing.T) {
+	tests := []struct {
+		name         string
+		content      string
+		env          map[string]string
+		expectedErrs []string
+	}{
+		{
+			name:         "異常系 - 未知の項目",
+			content:      "server:\n  adr: \":9000\"\n",
+			expectedErrs: []string{"field adr not found"},
+		},
+		{
+			name:         "異常系 - 有効期間の形式が不正",
+			content:      "lifetimes:\n  access_token: 15minutes\n",
+			expectedErrs: []string{"line 2", "15minutes"},
+		},
+		{
+			name:         "異常系 - 環境変数の形式が不正",
+			env:          map[string]string{"PURGE_BATCH_SIZE": "many"},
+			expectedErrs: []string{"PURGE_BATCH_SIZE"},
+		},
+		{
+			name: "異常系 - 問題を全てまとめて返す",
+			content: `
+server:
+  issuer: http://auth.example.com
+  tls:
+    cert_file: cert.pem
+  metrics_addr: ":8080"
+storage:
+  driver: postgres
+lifetimes:
+  access_token: 0s
+  consent: -1h
+scopes: [read, read, "a b"]
+clients:
+  - id: client-1
+    type: confidential
+    redirect_uris: [/callback]
+  - id: client-1
+    name: 重複
+    type: native
+users:
+  - id: user-1
+    login_id: user@example.com
+`,
+			expectedErrs: []string{
+				"server.issuer: must use https",
+				"server.tls: both cert_file and key_file are required",
+				"server.metrics_addr: must differ from server.addr",
+				`storage.driver: must be "memory" or "sqlite", got "postgres"`,
+				"lifetimes.access_token: must be positive",
+				"lifetimes.consent: must not be negative",
+				`scopes[1]: duplicate scope "read"`,
+				`scopes[2]: invalid scope "a b"`,
+				"clients[0].name: is required",
+				"clients[0].secret: is required for a confidential client",
+				"clients[0].redirect_uris[0]: redirect uri must be an absolute uri",
+				`clients[1].id: duplicate client id "client-1"`,
+				`clients[1].type: must be "confidential" or "public", got "native"`,
+				"clients[1].redirect_uris: at least one redirect uri is required",
+				"users[0].password: is required",
+			},
+		},
+		{
+			name: "異常系 - クライアントのポリシー",
+			content: `
+scopes: [read, write]
+clients:
+  - id: client-1
+    name: クライアント1
+    type: public
+    redirect_uris: [https://client.example.com/callback]
+    grant_types: [refresh_token, password]
+    response_types: [token]
+    scopes: [read, admin]
+    default_scopes: [write]
+    token_endpoint_auth_method: client_secret_basic
+    lifetimes:
+      access_token: -1m
+  - id: client-2
+    name: クライアント2
+    type: confidential
+    secret: secret
+    redirect_uris: [https://client.example.com/callback]
+    grant_types: [refresh_token]
+    token_endpoint_auth_method: none
+`,
+			expectedErrs: []string{
+				`clients[0].grant_types[1]: unsupported grant type "password"`,
+				`clients[0].response_types[0]: unsupported response type "token"`,
+				`clients[0].scopes[1]: scope "admin" is not listed in scopes`,
+				`clients[0].default_scopes[0]: scope "write" is not allowed for the client`,
+				`clients[0].token_endpoint_auth_method: must be "none" for a public client`,
+				"clients[0].lifetimes.access_token: must not be negative",
+				"clients[1].grant_types: authorization_code is required for the code response type",
+				`clients[1].token_endpoint_auth_method: must not be "none" for a confidential client`,
+			},
+		},
+		{
+			name: "異常系 - スコープの定義",
+			content: `
+scopes:
+  - name: write
+    implies: [read]
+  - name: admin
+    sensitivity: critical
+`,
+			expectedErrs: []string{
+				`scopes[1].sensitivity: unknown scope sensitivity: critical`,
+			},
+		},
+		{
+			name: "異常系 - 含むスコープとパラメータの定義",
+			content: `
+scopes:
+  - name: write
+    implies: [read]
+  - name: "repo:{repo_id}:read"
+    parameters:
+      id: "[0-9]+"
+clients:
+  - id: client-1
+    name: クライアント1
+    type: public
+    redirect_uris: [https://client.example.com/callback]
+    scopes: [write]
+`,
+			expectedErrs: []string{
+				`scopes: scope "write": implied scope "read" is not defined`,
+				`scopes: scope "repo:{repo_id}:read": parameter "id" is not used in the scope name`,
+			},
+		},
+		{
+			name:         "異常系 - スコープの定義の未知の項目",
+			content:      "scopes:\n  - name: read\n    desciption: 参照\n",
+			expectedErrs: []string{"field desciption not found"},
+		},
+		{
+			name: "異常系 - リダイレクトURI",
+			content: `
+clients:
+  - id: web
+    name: Web
+    type: confidential
+    secret: secret
+    redirect_uris:
+      - https://client.example.com/callback#top
+      - http://client.example.com/callback
+      - com.example.app:/callback
+      - https://client.example.com/redirect?to=https://evil.example.com
+      - http://127.0.0.1:8000/callback
+  - id: native
+    name: Native
+    type: public
+    application_type: native
+    redirect_uris:
+      - com.example.app:/callback
+      - http://127.0.0.1/callback
+      - myapp:/callback
+  - id: desktop
+    name: Desktop
+    type: public
+    application_type: desktop
+    redirect_uris: [http://127.0.0.1/callback]
+`,
+			expectedErrs: []string{
+				"clients[0].redirect_uris[0]: redirect uri must not contain a fragment",
+				"clients[0].redirect_uris[1]: redirect uri must use https unless it is a loopback address",
+				"clients[0].redirect_uris[2]: custom uri schemes are only allowed for native clients",
+				`clients[0].redirect_uris[3]: redirect uri must not forward to another uri: query parameter "to"`,
+				`clients[1].redirect_uris[2]: custom uri schemes are only allowed for native clients: scheme "myapp" must be a reverse domain name`,
+				`clients[2].application_type: must be "web" or "native", got "desktop"`,
+			},
+		},
+		{
+			name:         "異常系 - スナップショットの鍵がない",
+			env:          map[string]string{"SNAPSHOT_FILE": "/tmp/oauth.snapshot"},
+			expectedErrs: []string{"storage.snapshot.key: is required"},
+		},
+		{
+			name:         "異常系 - sqliteでスナップショットを指定",
+			env:          map[string]string{"STORAGE_DRIVER": "sqlite", "SNAPSHOT_FILE": "/tmp/oauth.snapshot", "SNAPSHOT_KEY": "key"},
+			expectedErrs: []string{"storage.snapshot.file: is only supported with the memory driver"},
+		},
+		{
+			name:         "異常系 - 秘密情報の値とファイルを両方指定",
+			env:          map[string]string{"CSRF_SECRET": "secret", "CSRF_SECRET_FILE": "/run/secrets/csrf"},
+			expectedErrs: []string{"server.csrf_secret: cannot be set together with server.csrf_secret_file"},
+		},
+		{
+			name: "異常系 - レルムの定義",
+			content: `
+server:
+  issuer: https://auth.example.com
+realms:
+  - name: Sales
+    clients:
+      - id: partner
+        name: パートナー
+        type: confidential
+        secret: secret
+        redirect_uris: [https://partner.example.com/callback]
+        scopes: [admin]
+    users:
+      - id: user-1
+        login_id: user@example.com
+        password: password
+  - name: support
+    host: support.example.com:8080
+  - name: support
+    issuer: https://auth.example.com
+    lifetimes:
+      access_token: -1m
+`,
+			expectedErrs: []string{
+				`realms[0].name: must consist of lowercase letters, digits and hyphens, got "Sales"`,
+				`realms[0].clients[0].scopes[0]: scope "admin" is not listed in scopes`,
+				`realms[1].host: must be a host name without a scheme, port or path, got "support.example.com:8080"`,
+				"realms[1].clients: at least one client is required",
+				"realms[1].users: at least one user or users_file is required",
+				`realms[2].name: duplicate realm name "support"`,
+				`realms[2].issuer: issuer "https://auth.example.com" is already used by the default realm`,
+				"realms[2].lifetimes.access_token: must not be negative",
+			},
+		},
+		{
+			name:         "異常系 - usersとusers_fileの併用",
+			content:      "users_file: /etc/oauth/users.htpasswd\nusers:\n  - id: user-1\n    login_id: user@example.com\n    password: password\n",
+			expectedErrs: []string{"users_file: cannot be set together with users"},
+		},
+		{
+			name: "異常系 - パスワードのハッシュ値",
+			content: `
+password_hashing:
+  memory: 4
+  parallelism: 1
+users:
+  - id: user-1
+    login_id: user1@example.com
+    password: password
+    password_hash: $argon2id$v=19$m=19456,t=2,p=1$c2FsdA$aGFzaA
+  - id: user-2
+    login_id: user2@example.com
+    password_hash: password
+  - id: user-3
+    login_id: user3@example.com
+    password_hash: $argon2id$v=19$m=19456,t=2,p=1$c2FsdA
+`,
+			expectedErrs: []string{
+				"password_hashing.memory: must be at least 8 * parallelism KiB",
+				"users[0].password: cannot be set together with password_hash",
+				"users[1].password_hash: unsupported password hash",
+				"users[2].password_hash: unsupported password hash: malformed argon2id hash",
+			},
+		},
+		{
+			name: "異常系 - 上流のIdPの定義",
+			content: `
+identity_providers:
+  - name: corp
+    issuer: http://idp.example.com
+    client_id: oauth-tutorial
+    scopes: [email]
+  - name: corp
+    issuer: https://idp.example.com
+  - name: Google
+    issuer: https://accounts.google.com
+    client_id: oauth-tutorial
+`,
+			expectedErrs: []string{
+				`identity_providers[0].issuer: must use https, got "http://idp.example.com"`,
+				"identity_providers[0].scopes: must include openid",
+				`identity_providers[1].name: duplicate identity provider name "corp"`,
+				"identity_providers[1].client_id: is required",
+				`identity_providers[2].name: must consist of lowercase letters, digits and hyphens, got "Google"`,
+			},
+		},
+		{
+			name:         "異常系 - httpの識別子はループバックアドレスのIPリテラルのみ",
+			content:      "server:\n  issuer: http://localhost:8080\n",
+			expectedErrs: []string{`server.issuer: must use https, got "http://localhost:8080"`},
+		},
+		{
+			name:         "異常系 - 秘密情報のファイルが存在しない",
+			content:      "users:\n  - id: user-1\n    login_id: user@example.com\n    password_file: /nonexistent/password\n",
+			expectedErrs: []string{"users[0].password_file"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// given
+			path := ""
+			if tt.content != "" {
+				path = writeFile(t, "config.yaml", tt.content)
+			}
+
+			// when
+			_, err := Load(path, env(tt.env))
+
+			// then
+			if err == nil {
+				t.Fatal("Load() error = nil, want error")
+			}
+			for _, expected := range tt.expectedErrs {
+				if !strings.Contains(err.Error(), expected) {
+					t.Errorf("Load() error = %v\nwant to contain %q", err, expected)
+				}
+			}
+		})
+	}
+}
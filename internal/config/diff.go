@@ -0,0 +1,123 @@
+package config
+
+import (
+	"oauth-tutorial/internal/domain"
+	"reflect"
+	"slices"
+)
+
+// 再読み込みで反映する設定の差分
+type Changes struct {
+	ClientsAdded   []string
+	ClientsRemoved []string
+	// client_id毎の変更した項目名。秘密情報の値は含めない
+	ClientsUpdated map[string][]string
+	ScopesAdded    []string
+	ScopesRemoved  []string
+	// 説明や含むスコープなどの定義を変更したスコープ
+	ScopesUpdated []string
+	// 再読み込みでは反映せず、再起動が必要な項目
+	RestartRequired []string
+}
+
+func (c Changes) Empty() bool {
+	return len(c.ClientsAdded) == 0 && len(c.ClientsRemoved) == 0 && len(c.ClientsUpdated) == 0 &&
+		len(c.ScopesAdded) == 0 && len(c.ScopesRemoved) == 0 && len(c.ScopesUpdated) == 0 &&
+		len(c.RestartRequired) == 0
+}
+
+// 検証済みの2つの設定の差分を返す
+func Diff(before *Config, after *Config) Changes {
+	changes := Changes{ClientsUpdated: map[string][]string{}}
+
+	beforeClients := map[domain.ClientID]*domain.Client{}
+	for _, client := range before.SeedClients() {
+		beforeClients[client.ClientID()] = client
+	}
+	for _, client := range after.SeedClients() {
+		old, ok := beforeClients[client.ClientID()]
+		delete(beforeClients, client.ClientID())
+		if !ok {
+			changes.ClientsAdded = append(changes.ClientsAdded, string(client.ClientID()))
+			continue
+		}
+		if fields := diffClient(old, client); len(fields) > 0 {
+			changes.ClientsUpdated[string(client.ClientID())] = fields
+		}
+	}
+	for clientID := range beforeClients {
+		changes.ClientsRemoved = append(changes.ClientsRemoved, string(clientID))
+	}
+	slices.Sort(changes.ClientsRemoved)
+
+	beforeScopes := map[string]ScopeConfig{}
+	for _, scope := range before.Scopes {
+		beforeScopes[scope.Name] = scope
+	}
+	for _, scope := range after.Scopes {
+		old, ok := beforeScopes[scope.Name]
+		delete(beforeScopes, scope.Name)
+		switch {
+		case !ok:
+			changes.ScopesAdded = append(changes.ScopesAdded, scope.Name)
+		case !reflect.DeepEqual(old, scope):
+			changes.ScopesUpdated = append(changes.ScopesUpdated, scope.Name)
+		}
+	}
+	for _, scope := range before.Scopes {
+		if _, ok := beforeScopes[scope.Name]; ok {
+			changes.ScopesRemoved = append(changes.ScopesRemoved, scope.Name)
+		}
+	}
+
+	for _, section := range []struct {
+		name          string
+		before, after any
+	}{
+		{"server", before.Server, after.Server},
+		{"storage", before.Storage, after.Storage},
+		{"lifetimes", before.Lifetimes, after.Lifetimes},
+		{"users", before.Users, after.Users},
+		{"users_file", before.UsersFile, after.UsersFile},
+		{"password_hashing", before.PasswordHashing, after.PasswordHashing},
+		{"identity_providers", before.IdentityProviders, after.IdentityProviders},
+		// レルムのクライアントとスコープはレルム毎の差分(Realmで取得した設定同士のDiff)で反映する
+		{"realms", withoutReloadable(before.Realms), withoutReloadable(after.Realms)},
+	} {
+		if !reflect.DeepEqual(section.before, section.after) {
+			changes.RestartRequired = append(changes.RestartRequired, section.name)
+		}
+	}
+	return changes
+}
+
+// 再読み込みで反映する項目を除いたレルムの設定
+func withoutReloadable(realms []RealmConfig) []RealmConfig {
+	stripped := make([]RealmConfig, 0, len(realms))
+	for _, realm := range realms {
+		realm.Clients = nil
+		realm.Scopes = nil
+		stripped = append(stripped, realm)
+	}
+	return stripped
+}
+
+func diffClient(before *domain.Client, after *domain.Client) []string {
+	var fields []string
+	if before.ClientName() != after.ClientName() {
+		fields = append(fields, "name")
+	}
+	if before.ClientType() != after.ClientType() {
+		fields = append(fields, "type")
+	}
+	if before.Secret() != after.Secret() {
+		fields = append(fields, "secret")
+	}
+	if !slices.Equal(before.RedirectURI(), after.RedirectURI()) {
+		fields = append(fields, "redirect_uris")
+	}
+	if !reflect.DeepEqual(before.Policy(), after.Policy()) {
+		fields = append(fields, "policy")
+	}
+	return fields
+}
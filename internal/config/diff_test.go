@@ -0,0 +1,84 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_設定の差分(t *testing.T) {
+	base := func() *Config {
+		cfg := Default()
+		cfg.Clients = []ClientConfig{
+			{ID: "partner", Name: "パートナー", Type: ClientTypeConfidential, Secret: "secret", RedirectURIs: []string{"https://partner.example.com/callback"}},
+			{ID: "spa", Name: "SPA", Type: ClientTypePublic, RedirectURIs: []string{"https://spa.example.com/callback"}},
+		}
+		return cfg
+	}
+
+	tests := []struct {
+		name   string
+		modify func(cfg *Config)
+		want   Changes
+	}{
+		{
+			name:   "変更がない場合",
+			modify: func(cfg *Config) {},
+			want:   Changes{ClientsUpdated: map[string][]string{}},
+		},
+		{
+			name: "クライアントの追加・削除・変更",
+			modify: func(cfg *Config) {
+				cfg.Clients[0].Secret = "rotated"
+				cfg.Clients[0].RedirectURIs = append(cfg.Clients[0].RedirectURIs, "https://partner.example.com/callback2")
+				cfg.Clients[1] = ClientConfig{ID: "native", Name: "ネイティブ", Type: ClientTypePublic, RedirectURIs: []string{"http://127.0.0.1/callback"}}
+			},
+			want: Changes{
+				ClientsAdded:   []string{"native"},
+				ClientsRemoved: []string{"spa"},
+				ClientsUpdated: map[string][]string{"partner": {"secret", "redirect_uris"}},
+			},
+		},
+		{
+			name: "スコープの追加・削除・変更",
+			modify: func(cfg *Config) {
+				cfg.Scopes = []ScopeConfig{{Name: "read", DisplayName: "閲覧"}, {Name: "admin"}}
+			},
+			want: Changes{
+				ClientsUpdated: map[string][]string{},
+				ScopesAdded:    []string{"admin"},
+				ScopesRemoved:  []string{"write"},
+				ScopesUpdated:  []string{"read"},
+			},
+		},
+		{
+			name: "再起動が必要な項目の変更",
+			modify: func(cfg *Config) {
+				cfg.Server.Addr = ":9000"
+				cfg.Lifetimes.AccessToken = time.Minute
+			},
+			want: Changes{
+				ClientsUpdated:  map[string][]string{},
+				RestartRequired: []string{"server", "lifetimes"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// given
+			before, after := base(), base()
+			tt.modify(after)
+
+			// when
+			got := Diff(before, after)
+
+			// then
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Diff() = %+v, want %+v", got, tt.want)
+			}
+			if got.Empty() != reflect.DeepEqual(tt.want, Changes{ClientsUpdated: map[string][]string{}}) {
+				t.Errorf("Empty() = %v", got.Empty())
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"oauth-tutorial/pkg/mycrypto"
+
+	"gopkg.in/yaml.v3"
+)
+
+var ErrJSONNotSupported = errors.New("json config files are not supported; replace password with password_hash manually")
+
+// 設定ファイルのパスワードの置き換えの結果。値は項目名(例: realms[0].users[1])
+type PasswordMigration struct {
+	// 平文のpasswordをpassword_hashに置き換えたユーザー
+	Hashed []string
+	// password_fileで指定したユーザー。ファイルの内容は書き換えず、読み込み時にハッシュ化する
+	Skipped []string
+}
+
+// 設定ファイルの内容のうち、usersとrealms[].usersの平文のpasswordをhasherでハッシュ化したpassword_hashに置き換える
+// コメントや他の項目はそのまま残す。JSONの設定ファイルは対応しない
+func HashPlaintextPasswords(data []byte, hasher *mycrypto.PasswordHasher) ([]byte, PasswordMigration, error) {
+	var migration PasswordMigration
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		return nil, migration, ErrJSONNotSupported
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, migration, fmt.Errorf("parse config file: %w", err)
+	}
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return data, migration, nil
+	}
+	root := doc.Content[0]
+
+	hashUsers := func(prefix string, users *yaml.Node) {
+		if users == nil || users.Kind != yaml.SequenceNode {
+			return
+		}
+		for i, user := range users.Content {
+			field := fmt.Sprintf("%susers[%d]", prefix, i)
+			if mappingValue(user, "password_file") != nil {
+				migration.Skipped = append(migration.Skipped, field)
+				continue
+			}
+			key, value := mappingEntry(user, "password")
+			if key == nil || value.Value == "" || mappingValue(user, "password_hash") != nil {
+				continue
+			}
+			key.Value = "password_hash"
+			value.Value, value.Tag, value.Style = hasher.Hash(value.Value), "!!str", 0
+			migration.Hashed = append(migration.Hashed, field)
+		}
+	}
+	hashUsers("", mappingValue(root, "users"))
+	if realms := mappingValue(root, "realms"); realms != nil && realms.Kind == yaml.SequenceNode {
+		for i, realm := range realms.Content {
+			hashUsers(fmt.Sprintf("realms[%d].", i), mappingValue(realm, "users"))
+		}
+	}
+	if len(migration.Hashed) == 0 {
+		return data, migration, nil
+	}
+
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&doc); err != nil {
+		return nil, migration, fmt.Errorf("encode config file: %w", err)
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, migration, fmt.Errorf("encode config file: %w", err)
+	}
+	return buf.Bytes(), migration, nil
+}
+
+// マッピングの項目のキーと値。存在しない場合はnil
+func mappingEntry(node *yaml.Node, key string) (*yaml.Node, *yaml.Node) {
+	if node.Kind != yaml.MappingNode {
+		return nil, nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i], node.Content[i+1]
+		}
+	}
+	return nil, nil
+}
+
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	_, value := mappingEntry(node, key)
+	return value
+}
@@ -0,0 +1,98 @@
+package config
+
+import (
+	"errors"
+	"oauth-tutorial/pkg/mycrypto"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func Test_設定ファイルの平文のパスワードの置き換え(t *testing.T) {
+	// given
+	passwordFile := writeFile(t, "password", "file-password\n")
+	content := `# 開発用の設定
+password_hashing:
+  memory: 64
+  iterations: 1
+  parallelism: 1
+users:
+  - id: user-1
+    login_id: user1@example.com
+    password: password1 # 平文
+  - id: user-2
+    login_id: user2@example.com
+    password_file: ` + passwordFile + `
+realms:
+  - name: sales
+    clients:
+      - id: partner
+        name: パートナー
+        type: public
+        redirect_uris: [https://partner.example.com/callback]
+    users:
+      - id: sales-user
+        login_id: sales@example.com
+        password: "sales password"
+`
+	hasher := mycrypto.NewPasswordHasher(mycrypto.Argon2Params{Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32})
+
+	// when
+	data, migration, err := HashPlaintextPasswords([]byte(content), hasher)
+
+	// then
+	if err != nil {
+		t.Fatalf("HashPlaintextPasswords() error = %v", err)
+	}
+	if !slices.Equal(migration.Hashed, []string{"users[0]", "realms[0].users[0]"}) || !slices.Equal(migration.Skipped, []string{"users[1]"}) {
+		t.Errorf("migration = %+v", migration)
+	}
+	if strings.Contains(string(data), "password1") || strings.Contains(string(data), "sales password") {
+		t.Errorf("plaintext password remains:\n%s", data)
+	}
+	if !strings.Contains(string(data), "# 開発用の設定") {
+		t.Errorf("comment was removed:\n%s", data)
+	}
+
+	// 置き換えた設定ファイルを読み込め、同じパスワードでログインできる
+	path := writeFile(t, "config.yaml", string(data))
+	cfg, err := Load(path, env(nil))
+	if err != nil {
+		t.Fatalf("Load() error = %v\n%s", err, data)
+	}
+	for _, tt := range []struct {
+		cfg      *Config
+		password string
+	}{
+		{cfg, "password1"},
+		{cfg.Realm("sales"), "sales password"},
+	} {
+		user := tt.cfg.Users[0]
+		if user.Password != "" {
+			t.Errorf("Password = %q, want empty", user.Password)
+		}
+		if match, _, err := hasher.Verify(user.PasswordHash, tt.password); !match || err != nil {
+			t.Errorf("Verify(%q) = %v, %v", user.PasswordHash, match, err)
+		}
+	}
+	if cfg.Users[1].Password != "file-password" {
+		t.Errorf("Password = %q, want %q", cfg.Users[1].Password, "file-password")
+	}
+}
+
+func Test_置き換えるパスワードが無い設定ファイル(t *testing.T) {
+	hasher := mycrypto.NewPasswordHasher(mycrypto.DefaultArgon2Params())
+	t.Run("内容を変更しない", func(t *testing.T) {
+		content := "users:\n  - id: user-1\n    login_id: user@example.com\n    password_hash: $argon2id$v=19$m=19456,t=2,p=1$c2FsdA$aGFzaA\n"
+		data, migration, err := HashPlaintextPasswords([]byte(content), hasher)
+		if err != nil || string(data) != content || len(migration.Hashed) != 0 {
+			t.Errorf("HashPlaintextPasswords() = %q, %+v, %v", data, migration, err)
+		}
+	})
+	t.Run("JSONは対応しない", func(t *testing.T) {
+		content := `{"users": [{"id": "user-1", "login_id": "user@example.com", "password": "password"}]}`
+		if _, _, err := HashPlaintextPasswords([]byte(content), hasher); !errors.Is(err, ErrJSONNotSupported) {
+			t.Errorf("HashPlaintextPasswords() error = %v, want %v", err, ErrJSONNotSupported)
+		}
+	})
+}
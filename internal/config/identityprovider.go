@@ -0,0 +1,79 @@
+package config
+
+import (
+	"fmt"
+	"slices"
+)
+
+// ログイン画面から選択できる上流のOpenID Connectプロバイダー(IdP)
+// IdPにはリダイレクトURIとして{認可サーバーの識別子}/federation/callbackを登録する
+type IdentityProviderConfig struct {
+	// 英小文字・数字・ハイフン。IdPのユーザーのログインIDの接頭辞にも使う(例: corp:alice@example.com)
+	Name string `yaml:"name"`
+	// ログイン画面のボタンに表示する名前。省略した場合はname
+	DisplayName string `yaml:"display_name"`
+	// IdPの識別子。{issuer}/.well-known/openid-configurationからエンドポイントと公開鍵を取得する
+	Issuer   string `yaml:"issuer"`
+	ClientID string `yaml:"client_id"`
+	// 省略した場合はパブリッククライアントとしてトークンリクエストを送る
+	ClientSecret     string `yaml:"client_secret"`
+	ClientSecretFile string `yaml:"client_secret_file"`
+	// 要求するスコープ。openidを含むこと。省略した場合はopenid email profile
+	Scopes []string `yaml:"scopes"`
+	// ログインIDに使うIDトークンのクレーム。省略した場合はemail。値が無い場合はsub
+	LoginIDClaim string `yaml:"login_id_claim"`
+}
+
+var defaultIdentityProviderScopes = []string{"openid", "email", "profile"}
+
+func (p IdentityProviderConfig) ScopesOrDefault() []string {
+	if len(p.Scopes) == 0 {
+		return defaultIdentityProviderScopes
+	}
+	return p.Scopes
+}
+
+func (p IdentityProviderConfig) LoginIDClaimOrDefault() string {
+	if p.LoginIDClaim == "" {
+		return "email"
+	}
+	return p.LoginIDClaim
+}
+
+func (p IdentityProviderConfig) DisplayNameOrDefault() string {
+	if p.DisplayName == "" {
+		return p.Name
+	}
+	return p.DisplayName
+}
+
+func (c *Config) validateIdentityProviders(prefix string, add addFunc) {
+	names := map[string]bool{}
+	for i, p := range c.IdentityProviders {
+		field := fmt.Sprintf("%sidentity_providers[%d]", prefix, i)
+		switch {
+		case !realmNamePattern.MatchString(p.Name):
+			add(field+".name", "must consist of lowercase letters, digits and hyphens, got %q", p.Name)
+		case names[p.Name]:
+			add(field+".name", "duplicate identity provider name %q", p.Name)
+		}
+		names[p.Name] = true
+		// IdPの識別子もRFC 8414と同じ形式(OpenID Connect Discovery 1.0 3.)
+		if p.Issuer == "" {
+			add(field+".issuer", "is required")
+		} else if err := validateIssuer(p.Issuer); err != nil {
+			add(field+".issuer", "%v", err)
+		}
+		if p.ClientID == "" {
+			add(field+".client_id", "is required")
+		}
+		if len(p.Scopes) > 0 && !slices.Contains(p.Scopes, "openid") {
+			add(field+".scopes", "must include openid")
+		}
+		for j, scope := range p.Scopes {
+			if !isScopeToken(scope) {
+				add(fmt.Sprintf("%s.scopes[%d]", field, j), "invalid scope %q", scope)
+			}
+		}
+	}
+}
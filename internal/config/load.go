@@ -0,0 +1,180 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// 設定ファイルを読み込み、環境変数で上書きした上で検証する
+// pathが空の場合は既定値に環境変数を反映する。getenvにはos.Getenvを渡す
+// 問題が複数ある場合は全てをまとめたエラーを返す
+func Load(path string, getenv func(string) string) (*Config, error) {
+	cfg := Default()
+	if path != "" {
+		if err := decodeFile(path, cfg); err != nil {
+			return nil, err
+		}
+	}
+	if err := cfg.applyEnv(getenv); err != nil {
+		return nil, err
+	}
+	if err := cfg.resolveSecrets(); err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// JSONはYAMLとして読み込める。綴りの誤りに気付けるよう、未知の項目はエラーにする
+func decodeFile(path string, cfg *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read config file: %w", err)
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("parse config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// 環境変数で上書きする項目
+func (c *Config) applyEnv(getenv func(string) string) error {
+	var errs []error
+	setString := func(name string, dst *string) {
+		if v := getenv(name); v != "" {
+			*dst = v
+		}
+	}
+	setDuration := func(name string, dst *time.Duration) {
+		if v := getenv(name); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", name, err))
+				return
+			}
+			*dst = d
+		}
+	}
+	setInt := func(name string, dst *int) {
+		if v := getenv(name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", name, err))
+				return
+			}
+			*dst = n
+		}
+	}
+
+	setString("LISTEN_ADDR", &c.Server.Addr)
+	setString("ISSUER", &c.Server.Issuer)
+	setString("TLS_CERT_FILE", &c.Server.TLS.CertFile)
+	setString("TLS_KEY_FILE", &c.Server.TLS.KeyFile)
+	setString("CSRF_SECRET", &c.Server.CSRFSecret)
+	setString("CSRF_SECRET_FILE", &c.Server.CSRFSecretFile)
+	setString("TEMPLATE_DIR", &c.Server.TemplateDir)
+	setString("METRICS_ADDR", &c.Server.MetricsAddr)
+
+	setString("STORAGE_DRIVER", &c.Storage.Driver)
+	setString("STORAGE_DSN", &c.Storage.DSN)
+	setString("SNAPSHOT_FILE", &c.Storage.Snapshot.File)
+	setString("SNAPSHOT_KEY", &c.Storage.Snapshot.Key)
+	setString("SNAPSHOT_KEY_FILE", &c.Storage.Snapshot.KeyFile)
+	setDuration("SNAPSHOT_INTERVAL", &c.Storage.Snapshot.Interval)
+	setDuration("PURGE_INTERVAL", &c.Storage.Purge.Interval)
+	setInt("PURGE_BATCH_SIZE", &c.Storage.Purge.BatchSize)
+
+	setDuration("AUTHORIZATION_CODE_LIFETIME", &c.Lifetimes.AuthorizationCode)
+	setDuration("ACCESS_TOKEN_LIFETIME", &c.Lifetimes.AccessToken)
+	setDuration("REFRESH_TOKEN_LIFETIME", &c.Lifetimes.RefreshToken)
+	setDuration("CONSENT_DURATION", &c.Lifetimes.Consent)
+	setDuration("SESSION_IDLE_TIMEOUT", &c.Lifetimes.SessionIdle)
+	setDuration("SESSION_ABSOLUTE_TIMEOUT", &c.Lifetimes.SessionAbsolute)
+
+	// SCOPESではスコープの名前のみ指定できる。設定ファイルで定義済みのスコープは定義をそのまま使う
+	if v := getenv("SCOPES"); v != "" {
+		scopes := make([]ScopeConfig, 0)
+		for _, name := range strings.Fields(v) {
+			scope := ScopeConfig{Name: name}
+			if i := slices.IndexFunc(c.Scopes, func(s ScopeConfig) bool { return s.Name == name }); i >= 0 {
+				scope = c.Scopes[i]
+			}
+			scopes = append(scopes, scope)
+		}
+		c.Scopes = scopes
+	}
+	return errors.Join(errs...)
+}
+
+// xxx_fileで指定したファイルから秘密情報を読み込む。値を直接指定した場合との併用はエラーにする
+func (c *Config) resolveSecrets() error {
+	var errs []error
+	resolve := func(field string, value *string, file string) {
+		if file == "" {
+			return
+		}
+		if *value != "" {
+			errs = append(errs, fmt.Errorf("%s: cannot be set together with %s_file", field, field))
+			return
+		}
+		secret, err := readSecretFile(file)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s_file: %w", field, err))
+			return
+		}
+		*value = secret
+	}
+
+	resolve("server.csrf_secret", &c.Server.CSRFSecret, c.Server.CSRFSecretFile)
+	resolve("storage.snapshot.key", &c.Storage.Snapshot.Key, c.Storage.Snapshot.KeyFile)
+	for i := range c.Clients {
+		resolve(fmt.Sprintf("clients[%d].secret", i), &c.Clients[i].Secret, c.Clients[i].SecretFile)
+	}
+	for i := range c.Users {
+		resolve(fmt.Sprintf("users[%d].password", i), &c.Users[i].Password, c.Users[i].PasswordFile)
+	}
+	for i := range c.IdentityProviders {
+		resolve(fmt.Sprintf("identity_providers[%d].client_secret", i), &c.IdentityProviders[i].ClientSecret, c.IdentityProviders[i].ClientSecretFile)
+	}
+	for i := range c.Realms {
+		realm := &c.Realms[i]
+		field := fmt.Sprintf("realms[%d]", i)
+		resolve(field+".csrf_secret", &realm.CSRFSecret, realm.CSRFSecretFile)
+		for j := range realm.Clients {
+			resolve(fmt.Sprintf("%s.clients[%d].secret", field, j), &realm.Clients[j].Secret, realm.Clients[j].SecretFile)
+		}
+		for j := range realm.Users {
+			resolve(fmt.Sprintf("%s.users[%d].password", field, j), &realm.Users[j].Password, realm.Users[j].PasswordFile)
+		}
+		for j := range realm.IdentityProviders {
+			resolve(fmt.Sprintf("%s.identity_providers[%d].client_secret", field, j), &realm.IdentityProviders[j].ClientSecret, realm.IdentityProviders[j].ClientSecretFile)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Kubernetesのシークレットなどのファイルは末尾に改行を含むことが多いため、取り除く
+func readSecretFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	secret := strings.TrimRight(string(data), "\r\n")
+	if secret == "" {
+		return "", fmt.Errorf("%s is empty", path)
+	}
+	return secret, nil
+}
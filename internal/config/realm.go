@@ -0,0 +1,219 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"oauth-tutorial/internal/domain"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// レルム。事業部などの利用者毎にクライアント・ユーザー・スコープ・鍵・認可サーバーの識別子を分離する
+// /realms/{name}/authorize のようにパスのプレフィックスで、またはhostを指定した場合はホスト名で選択する
+type RealmConfig struct {
+	// 英小文字・数字・ハイフン
+	Name string `yaml:"name"`
+	// 指定した場合は、このホスト名(ポートを除く)へのリクエストをパスのプレフィックスなしで受け付ける
+	Host string `yaml:"host"`
+	// 認可サーバーの識別子。省略した場合、hostを指定した場合はserver.issuerのホスト名をhostにしたURL、
+	// それ以外はserver.issuerに/realms/{name}を付けたURL
+	Issuer string `yaml:"issuer"`
+	// レルムのCSRFトークンを発行する鍵。未指定の場合は起動毎に生成する
+	CSRFSecret     string `yaml:"csrf_secret"`
+	CSRFSecretFile string `yaml:"csrf_secret_file"`
+	// 永続化の実装(storage.driver)は全てのレルムで共通で、データベースとスナップショットのファイルをレルム毎に分ける
+	Storage RealmStorageConfig `yaml:"storage"`
+	// 省略した項目(0)はlifetimesの値を使う
+	Lifetimes LifetimesConfig `yaml:"lifetimes"`
+	// 省略した場合はscopesと同じ定義を使う
+	Scopes []ScopeConfig `yaml:"scopes"`
+	// クライアントとユーザー(usersまたはusers_file)は必須。既定のレルムのクライアントとユーザーは使えない
+	Clients   []ClientConfig `yaml:"clients"`
+	Users     []UserConfig   `yaml:"users"`
+	UsersFile string         `yaml:"users_file"`
+	// 既定のレルムのIdPは使えない
+	IdentityProviders []IdentityProviderConfig `yaml:"identity_providers"`
+}
+
+// 省略した場合はstorage.dsnとstorage.snapshot.fileのファイル名にレルム名を付けたもの(例: oauth.sales.db)
+type RealmStorageConfig struct {
+	DSN          string `yaml:"dsn"`
+	SnapshotFile string `yaml:"snapshot_file"`
+}
+
+var realmNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+// レルムの名前の一覧
+func (c *Config) RealmNames() []string {
+	names := make([]string, 0, len(c.Realms))
+	for _, realm := range c.Realms {
+		names = append(names, realm.Name)
+	}
+	return names
+}
+
+// レルムの設定を、既定のレルムと同じ形式の設定にして返す。存在しない場合はnil
+// serverのissuerとcsrf_secret、storageのdsnとsnapshot.file、lifetimes、scopes、clients、users、users_file、identity_providersをレルムの値にする
+func (c *Config) Realm(name string) *Config {
+	i := slices.IndexFunc(c.Realms, func(r RealmConfig) bool { return r.Name == name })
+	if i < 0 {
+		return nil
+	}
+	return c.realm(c.Realms[i])
+}
+
+func (c *Config) realm(r RealmConfig) *Config {
+	realm := *c
+	realm.Realms = nil
+
+	realm.Server.Issuer = c.realmIssuer(r)
+	realm.Server.CSRFSecret = r.CSRFSecret
+	realm.Server.CSRFSecretFile = ""
+
+	realm.Storage.DSN = r.Storage.DSN
+	if realm.Storage.DSN == "" {
+		realm.Storage.DSN = realmFileName(c.Storage.DSN, r.Name)
+	}
+	realm.Storage.Snapshot.File = r.Storage.SnapshotFile
+	if realm.Storage.Snapshot.File == "" && c.Storage.Snapshot.File != "" {
+		realm.Storage.Snapshot.File = realmFileName(c.Storage.Snapshot.File, r.Name)
+	}
+
+	realm.Lifetimes = mergeLifetimes(c.Lifetimes, r.Lifetimes)
+
+	if len(r.Scopes) > 0 {
+		realm.Scopes = r.Scopes
+	}
+	realm.Clients = r.Clients
+	realm.Users = r.Users
+	realm.UsersFile = r.UsersFile
+	realm.IdentityProviders = r.IdentityProviders
+	return &realm
+}
+
+func (c *Config) realmIssuer(r RealmConfig) string {
+	if r.Issuer != "" {
+		return r.Issuer
+	}
+	base := c.Issuer()
+	if r.Host == "" {
+		return strings.TrimSuffix(base, "/") + "/realms/" + r.Name
+	}
+	u, err := url.Parse(base)
+	if err != nil {
+		return base
+	}
+	// ポートはserver.issuerと同じにする
+	if port := u.Port(); port != "" {
+		u.Host = net.JoinHostPort(r.Host, port)
+	} else {
+		u.Host = r.Host
+	}
+	u.Path = ""
+	return u.String()
+}
+
+// overrideで0以外の項目のみ置き換える
+func mergeLifetimes(base LifetimesConfig, override LifetimesConfig) LifetimesConfig {
+	merged := base
+	for _, l := range []struct {
+		dst *time.Duration
+		src time.Duration
+	}{
+		{&merged.AuthorizationCode, override.AuthorizationCode},
+		{&merged.AccessToken, override.AccessToken},
+		{&merged.RefreshToken, override.RefreshToken},
+		{&merged.Consent, override.Consent},
+		{&merged.SessionIdle, override.SessionIdle},
+		{&merged.SessionAbsolute, override.SessionAbsolute},
+	} {
+		if l.src != 0 {
+			*l.dst = l.src
+		}
+	}
+	return merged
+}
+
+// レルム毎の項目を検証する。registryは既定のレルムのスコープの一覧で、scopesを省略したレルムで使う
+func (c *Config) validateRealms(registry *domain.ScopeRegistry, add addFunc) {
+	names, hosts := map[string]bool{}, map[string]bool{}
+	// 認可サーバーの識別子はレルム間で重複してはならない RFC 9207 2.4
+	issuers := map[string]string{c.Issuer(): "the default realm"}
+	for i, r := range c.Realms {
+		field := fmt.Sprintf("realms[%d]", i)
+		switch {
+		case !realmNamePattern.MatchString(r.Name):
+			add(field+".name", "must consist of lowercase letters, digits and hyphens, got %q", r.Name)
+		case names[r.Name]:
+			add(field+".name", "duplicate realm name %q", r.Name)
+		}
+		names[r.Name] = true
+		if r.Host != "" {
+			host := strings.ToLower(r.Host)
+			switch {
+			case strings.ContainsAny(host, ":/?#@") || strings.TrimSpace(host) != host:
+				add(field+".host", "must be a host name without a scheme, port or path, got %q", r.Host)
+			case hosts[host]:
+				add(field+".host", "duplicate host %q", r.Host)
+			}
+			hosts[host] = true
+		}
+		if r.Issuer != "" {
+			if err := validateIssuer(r.Issuer); err != nil {
+				add(field+".issuer", "%v", err)
+			}
+		}
+		issuer := c.realmIssuer(r)
+		if other, ok := issuers[issuer]; ok {
+			add(field+".issuer", "issuer %q is already used by %s", issuer, other)
+		} else {
+			issuers[issuer] = fmt.Sprintf("realms[%d]", i)
+		}
+
+		if r.Storage.SnapshotFile != "" && c.Storage.Driver != StorageDriverMemory {
+			add(field+".storage.snapshot_file", "is only supported with the memory driver")
+		}
+
+		// 既定のレルムの組み込みのクライアントとユーザーを使わないよう、クライアントとユーザーを必須にする
+		if len(r.Clients) == 0 {
+			add(field+".clients", "at least one client is required")
+		}
+		if len(r.Users) == 0 && r.UsersFile == "" {
+			add(field+".users", "at least one user or users_file is required")
+		}
+
+		realm := c.realm(r)
+		realmRegistry := registry
+		if len(r.Scopes) > 0 {
+			realmRegistry = realm.validateScopes(field+".", add)
+		}
+		realm.validateClients(field+".", realmRegistry, add)
+		realm.validateUsers(field+".", add)
+		realm.validateIdentityProviders(field+".", add)
+		for _, l := range []struct {
+			field string
+			d     time.Duration
+		}{
+			{field + ".lifetimes.authorization_code", r.Lifetimes.AuthorizationCode},
+			{field + ".lifetimes.access_token", r.Lifetimes.AccessToken},
+			{field + ".lifetimes.refresh_token", r.Lifetimes.RefreshToken},
+			{field + ".lifetimes.consent", r.Lifetimes.Consent},
+			{field + ".lifetimes.session_idle", r.Lifetimes.SessionIdle},
+			{field + ".lifetimes.session_absolute", r.Lifetimes.SessionAbsolute},
+		} {
+			if l.d < 0 {
+				add(l.field, "must not be negative")
+			}
+		}
+	}
+}
+
+// ファイル名の拡張子の前にレルム名を挿入する。例: oauth.db → oauth.sales.db
+func realmFileName(path string, realm string) string {
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "." + realm + ext
+}
@@ -0,0 +1,325 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"oauth-tutorial/internal/domain"
+	"oauth-tutorial/pkg/mycrypto"
+	"slices"
+	"time"
+)
+
+// 設定の問題を項目名付きで全て返す
+func (c *Config) Validate() error {
+	var errs []error
+	add := func(field string, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%s: %s", field, fmt.Sprintf(format, args...)))
+	}
+
+	// サーバー
+	if c.Server.Addr == "" {
+		add("server.addr", "is required")
+	}
+	if c.Server.Issuer != "" {
+		if err := validateIssuer(c.Server.Issuer); err != nil {
+			add("server.issuer", "%v", err)
+		}
+	}
+	if c.Server.TLS.Enabled() && (c.Server.TLS.CertFile == "" || c.Server.TLS.KeyFile == "") {
+		add("server.tls", "both cert_file and key_file are required")
+	}
+	if c.Server.MetricsAddr != "" && c.Server.MetricsAddr == c.Server.Addr {
+		add("server.metrics_addr", "must differ from server.addr")
+	}
+
+	// 永続化
+	switch c.Storage.Driver {
+	case StorageDriverMemory:
+	case StorageDriverSQLite:
+		if c.Storage.DSN == "" {
+			add("storage.dsn", "is required for the sqlite driver")
+		}
+		if c.Storage.Snapshot.File != "" {
+			add("storage.snapshot.file", "is only supported with the memory driver")
+		}
+	default:
+		add("storage.driver", "must be %q or %q, got %q", StorageDriverMemory, StorageDriverSQLite, c.Storage.Driver)
+	}
+	if c.Storage.Snapshot.File != "" {
+		if c.Storage.Snapshot.Key == "" {
+			add("storage.snapshot.key", "is required when storage.snapshot.file is set")
+		}
+		if c.Storage.Snapshot.Interval <= 0 {
+			add("storage.snapshot.interval", "must be positive")
+		}
+	}
+	if c.Storage.Purge.Interval <= 0 {
+		add("storage.purge.interval", "must be positive")
+	}
+	if c.Storage.Purge.BatchSize <= 0 {
+		add("storage.purge.batch_size", "must be positive")
+	}
+
+	// 有効期間
+	for _, l := range []struct {
+		field    string
+		d        time.Duration
+		optional bool
+	}{
+		{"lifetimes.authorization_code", c.Lifetimes.AuthorizationCode, false},
+		{"lifetimes.access_token", c.Lifetimes.AccessToken, false},
+		{"lifetimes.refresh_token", c.Lifetimes.RefreshToken, false},
+		// 0は無期限
+		{"lifetimes.consent", c.Lifetimes.Consent, true},
+		{"lifetimes.session_idle", c.Lifetimes.SessionIdle, true},
+		{"lifetimes.session_absolute", c.Lifetimes.SessionAbsolute, true},
+	} {
+		switch {
+		case l.d < 0:
+			add(l.field, "must not be negative")
+		case l.d == 0 && !l.optional:
+			add(l.field, "must be positive")
+		}
+	}
+
+	// パスワードのハッシュ化 RFC 9106 3.1 メモリ使用量は並列度の8倍以上
+	if c.PasswordHashing.Iterations == 0 {
+		add("password_hashing.iterations", "must be positive")
+	}
+	if c.PasswordHashing.Parallelism == 0 {
+		add("password_hashing.parallelism", "must be positive")
+	}
+	if c.PasswordHashing.Memory < 8*uint32(c.PasswordHashing.Parallelism) {
+		add("password_hashing.memory", "must be at least 8 * parallelism KiB")
+	}
+
+	// スコープ・クライアント・ユーザー・IdP
+	registry := c.validateScopes("", add)
+	c.validateClients("", registry, add)
+	c.validateUsers("", add)
+	c.validateIdentityProviders("", add)
+
+	// レルム
+	c.validateRealms(registry, add)
+
+	return errors.Join(errs...)
+}
+
+// 設定の問題を追加する関数
+type addFunc func(field string, format string, args ...any)
+
+// スコープの定義を検証し、問題が無い場合はスコープの一覧を返す。prefixはレルムの項目名(例: realms[0].)
+func (c *Config) validateScopes(prefix string, add addFunc) *domain.ScopeRegistry {
+	if len(c.Scopes) == 0 {
+		add(prefix+"scopes", "at least one scope is required")
+	}
+	names := c.ScopeNames()
+	scopesValid := true
+	for i, scope := range c.Scopes {
+		field := fmt.Sprintf("%sscopes[%d]", prefix, i)
+		if !isScopeToken(scope.Name) {
+			add(field, "invalid scope %q", scope.Name)
+			scopesValid = false
+		}
+		if slices.Index(names, scope.Name) != i {
+			add(field, "duplicate scope %q", scope.Name)
+			scopesValid = false
+		}
+		if scope.Sensitivity != "" {
+			if _, err := domain.ParseScopeSensitivity(scope.Sensitivity); err != nil {
+				add(field+".sensitivity", "%v", err)
+				scopesValid = false
+			}
+		}
+	}
+	// 項目毎の問題が無い場合のみ、含むスコープやパラメータの定義を検証する
+	if scopesValid {
+		r, err := c.ScopeRegistry()
+		if joined, ok := err.(interface{ Unwrap() []error }); ok {
+			for _, e := range joined.Unwrap() {
+				add(prefix+"scopes", "%v", e)
+			}
+		} else if err != nil {
+			add(prefix+"scopes", "%v", err)
+		}
+		return r
+	}
+	return nil
+}
+
+// registryがnilの場合(スコープの定義に問題がある場合)はクライアントのスコープを検証しない
+func (c *Config) validateClients(prefix string, registry *domain.ScopeRegistry, add addFunc) {
+	clientIDs := map[string]bool{}
+	for i, client := range c.Clients {
+		field := fmt.Sprintf("%sclients[%d]", prefix, i)
+		if client.ID == "" {
+			add(field+".id", "is required")
+		} else if clientIDs[client.ID] {
+			add(field+".id", "duplicate client id %q", client.ID)
+		}
+		clientIDs[client.ID] = true
+		if client.Name == "" {
+			add(field+".name", "is required")
+		}
+		switch client.Type {
+		case ClientTypeConfidential:
+			if client.Secret == "" {
+				add(field+".secret", "is required for a confidential client")
+			}
+		case ClientTypePublic:
+		default:
+			add(field+".type", "must be %q or %q, got %q", ClientTypeConfidential, ClientTypePublic, client.Type)
+		}
+		if len(client.RedirectURIs) == 0 {
+			add(field+".redirect_uris", "at least one redirect uri is required")
+		}
+		applicationType := domain.ApplicationType(client.ApplicationType)
+		switch applicationType {
+		case "", domain.ApplicationTypeWeb, domain.ApplicationTypeNative:
+		default:
+			add(field+".application_type", "must be %q or %q, got %q", domain.ApplicationTypeWeb, domain.ApplicationTypeNative, client.ApplicationType)
+		}
+		for j, redirectURI := range client.RedirectURIs {
+			if err := domain.ValidateRedirectURI(redirectURI, applicationType); err != nil {
+				add(fmt.Sprintf("%s.redirect_uris[%d]", field, j), "%v, got %q", err, redirectURI)
+			}
+		}
+		c.validateClientPolicy(field, client, registry, add)
+	}
+}
+
+func (c *Config) validateUsers(prefix string, add addFunc) {
+	if c.UsersFile != "" && len(c.Users) > 0 {
+		add(prefix+"users_file", "cannot be set together with users")
+	}
+	userIDs, loginIDs := map[string]bool{}, map[string]bool{}
+	for i, user := range c.Users {
+		field := fmt.Sprintf("%susers[%d]", prefix, i)
+		if user.ID == "" {
+			add(field+".id", "is required")
+		} else if userIDs[user.ID] {
+			add(field+".id", "duplicate user id %q", user.ID)
+		}
+		userIDs[user.ID] = true
+		if user.LoginID == "" {
+			add(field+".login_id", "is required")
+		} else if loginIDs[user.LoginID] {
+			add(field+".login_id", "duplicate login id %q", user.LoginID)
+		}
+		loginIDs[user.LoginID] = true
+		switch {
+		case user.Password == "" && user.PasswordHash == "":
+			add(field+".password", "is required unless password_hash is set")
+		case user.Password != "" && user.PasswordHash != "":
+			add(field+".password", "cannot be set together with password_hash")
+		case user.PasswordHash != "":
+			if err := mycrypto.ValidatePasswordHash(user.PasswordHash); err != nil {
+				add(field+".password_hash", "%v", err)
+			}
+		}
+	}
+}
+
+// クライアント毎のポリシー
+func (c *Config) validateClientPolicy(field string, client ClientConfig, registry *domain.ScopeRegistry, add addFunc) {
+	for j, grantType := range client.GrantTypes {
+		if _, err := domain.ResolveGrantType(grantType); err != nil {
+			add(fmt.Sprintf("%s.grant_types[%d]", field, j), "unsupported grant type %q", grantType)
+		}
+	}
+	for j, responseType := range client.ResponseTypes {
+		if _, err := domain.GetResponseType(responseType); err != nil {
+			add(fmt.Sprintf("%s.response_types[%d]", field, j), "unsupported response type %q", responseType)
+		}
+	}
+	// response_type=codeで発行した認可コードは、authorization_codeのgrant_typeでしか使えない
+	if len(client.GrantTypes) > 0 && !slices.Contains(client.GrantTypes, "authorization_code") &&
+		(len(client.ResponseTypes) == 0 || slices.Contains(client.ResponseTypes, "code")) {
+		add(field+".grant_types", "authorization_code is required for the code response type")
+	}
+
+	// スコープの定義に問題がある場合は、クライアントのスコープを検証できない
+	if registry == nil {
+		return
+	}
+	// パラメータ付きのスコープは、名前(repo:{repo_id}:read)とパラメータの値を指定したスコープ(repo:1:read)のどちらも指定できる
+	for j, scope := range client.Scopes {
+		if !registry.IsDefined(scope) && registry.Validate([]string{scope}) != nil {
+			add(fmt.Sprintf("%s.scopes[%d]", field, j), "scope %q is not listed in scopes", scope)
+		}
+	}
+	for j, scope := range client.DefaultScopes {
+		switch {
+		case registry.Validate([]string{scope}) != nil:
+			add(fmt.Sprintf("%s.default_scopes[%d]", field, j), "scope %q is not listed in scopes", scope)
+		case len(client.Scopes) > 0 && registry.CheckAllowed(client.Scopes, []string{scope}) != nil:
+			add(fmt.Sprintf("%s.default_scopes[%d]", field, j), "scope %q is not allowed for the client", scope)
+		}
+	}
+
+	switch domain.TokenEndpointAuthMethod(client.TokenEndpointAuthMethod) {
+	case "":
+	case domain.AuthMethodClientSecretBasic, domain.AuthMethodClientSecretPost:
+		if client.Type == ClientTypePublic {
+			add(field+".token_endpoint_auth_method", "must be %q for a public client", domain.AuthMethodNone)
+		}
+	case domain.AuthMethodNone:
+		if client.Type == ClientTypeConfidential {
+			add(field+".token_endpoint_auth_method", "must not be %q for a confidential client", domain.AuthMethodNone)
+		}
+	default:
+		add(field+".token_endpoint_auth_method", "must be %q, %q or %q, got %q",
+			domain.AuthMethodClientSecretBasic, domain.AuthMethodClientSecretPost, domain.AuthMethodNone, client.TokenEndpointAuthMethod)
+	}
+
+	// 0はサーバー全体の有効期間
+	for _, l := range []struct {
+		field string
+		d     time.Duration
+	}{
+		{field + ".lifetimes.authorization_code", client.Lifetimes.AuthorizationCode},
+		{field + ".lifetimes.access_token", client.Lifetimes.AccessToken},
+		{field + ".lifetimes.refresh_token", client.Lifetimes.RefreshToken},
+	} {
+		if l.d < 0 {
+			add(l.field, "must not be negative")
+		}
+	}
+}
+
+// RFC 8414 2. クエリとフラグメントを含まないhttpsのURL。ローカルでの動作確認のため、リダイレクトURIと同じくループバックアドレスのIPリテラルのみhttpを許可する
+func validateIssuer(issuer string) error {
+	u, err := url.Parse(issuer)
+	if err != nil {
+		return err
+	}
+	if u.Host == "" {
+		return fmt.Errorf("must be an absolute url, got %q", issuer)
+	}
+	if u.RawQuery != "" || u.Fragment != "" {
+		return fmt.Errorf("must not contain a query or fragment, got %q", issuer)
+	}
+	switch u.Scheme {
+	case "https":
+		return nil
+	case "http":
+		if domain.IsLoopbackHost(u.Hostname()) {
+			return nil
+		}
+	}
+	return fmt.Errorf("must use https, got %q", issuer)
+}
+
+// RFC 6749 3.3 scope-token = 1*( %x21 / %x23-5B / %x5D-7E )
+func isScopeToken(scope string) bool {
+	if scope == "" {
+		return false
+	}
+	for _, c := range []byte(scope) {
+		if c < 0x21 || c == 0x22 || c == 0x5C || c > 0x7E {
+			return false
+		}
+	}
+	return true
+}
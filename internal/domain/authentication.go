@@ -0,0 +1,41 @@
+package domain
+
+// パスワード認証によるログインを表すamrの値(RFC 8176)
+const AMRPassword = "pwd"
+
+// ログインに失敗した理由。ログにのみ記録し、ユーザーには区別せずに伝える
+type AuthenticationFailureReason string
+
+const (
+	AuthenticationFailureUnknownUser     AuthenticationFailureReason = "unknown_user"
+	AuthenticationFailureInvalidPassword AuthenticationFailureReason = "invalid_password"
+	// 上流のIdPのユーザーなど、パスワードを持たないユーザー
+	AuthenticationFailurePasswordNotSet AuthenticationFailureReason = "password_not_set"
+	// 認証の方式がユーザーの有無とパスワードの誤りを区別できない場合
+	AuthenticationFailureInvalidCredentials AuthenticationFailureReason = "invalid_credentials"
+)
+
+// ログインIDとパスワードによる認証の結果
+type AuthenticationResult struct {
+	user *User
+	// 認証の方式 RFC 8176
+	amr           []string
+	failureReason AuthenticationFailureReason
+}
+
+func NewAuthenticationSuccess(user *User, amr []string) AuthenticationResult {
+	return AuthenticationResult{user: user, amr: amr}
+}
+
+func NewAuthenticationFailure(reason AuthenticationFailureReason) AuthenticationResult {
+	return AuthenticationResult{failureReason: reason}
+}
+
+func (r AuthenticationResult) Succeeded() bool { return r.user != nil }
+
+// 失敗した場合はnil
+func (r AuthenticationResult) User() *User   { return r.user }
+func (r AuthenticationResult) AMR() []string { return r.amr }
+
+// 成功した場合は空
+func (r AuthenticationResult) FailureReason() AuthenticationFailureReason { return r.failureReason }
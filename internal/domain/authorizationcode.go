@@ -14,23 +14,19 @@ type AuthorizationCode struct {
 	expiresAt   int64
 }
 
+// 設定ファイルで指定しない場合の有効期間
 const (
 	AUTHORIZATION_CODE_DURATION = 10 * time.Minute
 )
 
-var (
-	SUPPORTED_SCOPES = []string{"read", "write"}
-)
-
 type RandomGenerator interface {
 	GenerateURLSafeRandomString(n int) string
 }
 
-func NewAuthorizationCode(randomGenerator RandomGenerator, userID string, clientID string, scopes []string, redirectURI string, now time.Time) *AuthorizationCode {
-	expiresAt := now.Local().Add(AUTHORIZATION_CODE_DURATION).Unix()
+func NewAuthorizationCode(randomGenerator RandomGenerator, userID string, clientID string, scopes []string, redirectURI string, now time.Time, duration time.Duration) *AuthorizationCode {
+	expiresAt := now.Local().Add(duration).Unix()
 	v := randomGenerator.GenerateURLSafeRandomString(32)
 	// TODO: 衝突の危険性を考慮して、必要に応じて再生成する
-	// TODO: 期限切れの認可コードを削除するバッチ作成
 	return &AuthorizationCode{
 		value:       v,
 		userID:      userID,
@@ -41,20 +37,15 @@ func NewAuthorizationCode(randomGenerator RandomGenerator, userID string, client
 	}
 }
 
-func IsValidScopes(scopes []string) bool {
-	for _, scope := range scopes {
-		valid := false
-		for _, supportedScope := range SUPPORTED_SCOPES {
-			if scope == supportedScope {
-				valid = true
-				break
-			}
-		}
-		if !valid {
-			return false
-		}
+func ReconstructAuthorizationCode(value string, userID string, clientID string, scopes []string, redirectURI string, expiresAt int64) *AuthorizationCode {
+	return &AuthorizationCode{
+		value:       value,
+		userID:      userID,
+		clientID:    clientID,
+		scopes:      scopes,
+		redirectURI: redirectURI,
+		expiresAt:   expiresAt,
 	}
-	return true
 }
 
 func (a *AuthorizationCode) Value() string       { return a.value }
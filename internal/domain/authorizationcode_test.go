@@ -37,7 +37,7 @@ func Test_AuthorizationCode構築(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			ac := NewAuthorizationCode(&TestRandomGenerator{}, tt.userID, tt.clientID, tt.scopes, tt.redirectURI, tt.now)
+			ac := NewAuthorizationCode(&TestRandomGenerator{}, tt.userID, tt.clientID, tt.scopes, tt.redirectURI, tt.now, AUTHORIZATION_CODE_DURATION)
 
 			if ac.Value() != TEST_RANDOM_STRING {
 				t.Errorf("Value() = %v, want not %v", ac.Value(), TEST_RANDOM_STRING)
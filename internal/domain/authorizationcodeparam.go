@@ -14,7 +14,8 @@ type AuthorizationCodeFlowParam struct {
 	state        string
 }
 
-func NewAuthorizationCodeFlowParam(logger mylogger.Logger, responseType string, clientID string, redirectURI string, scope string, state string) (*AuthorizationCodeFlowParam, error) {
+// scopesはサポートするスコープの一覧。スコープを検証する
+func NewAuthorizationCodeFlowParam(logger mylogger.Logger, scopes *ScopeRegistry, responseType string, clientID string, redirectURI string, scope string, state string) (*AuthorizationCodeFlowParam, error) {
 	// 認可フローによって処理が異なるケースを想定。現状、認可コードフローのみサポートのため、取得した値を使用してはいない
 	rt, err := GetResponseType(responseType)
 	if err != nil {
@@ -32,15 +33,14 @@ func NewAuthorizationCodeFlowParam(logger mylogger.Logger, responseType string,
 		return &AuthorizationCodeFlowParam{}, errors.New("redirect_uri is required")
 	}
 
-	if scope == "" {
-		logger.Info("scope is empty")
-		return &AuthorizationCodeFlowParam{}, errors.New("scope is required")
+	// scopeを省略した場合は、クライアントの既定のスコープを使う(WithScopes)
+	var requested []string
+	if scope != "" {
+		requested = strings.Split(scope, " ")
 	}
-
-	scopes := strings.Split(scope, " ")
-	if !IsValidScopes(scopes) {
-		logger.Info("Invalid scopes", "scopes", scopes, "supportedScopes", SUPPORTED_SCOPES)
-		return &AuthorizationCodeFlowParam{}, errors.New("invalid scopes. Supported scopes are: " + strings.Join(SUPPORTED_SCOPES, ", "))
+	if err := scopes.Validate(requested); err != nil {
+		logger.Info("Invalid scopes", "scopes", requested, "error", err)
+		return &AuthorizationCodeFlowParam{}, err
 	}
 
 	if state == "" {
@@ -52,7 +52,7 @@ func NewAuthorizationCodeFlowParam(logger mylogger.Logger, responseType string,
 		responseType: rt,
 		clientID:     clientID,
 		redirectURI:  redirectURI,
-		scopes:       scopes,
+		scopes:       requested,
 		state:        state,
 	}, nil
 }
@@ -73,6 +73,12 @@ func (p AuthorizationCodeFlowParam) Scopes() []string {
 	return p.scopes
 }
 
+// スコープを置き換えたパラメータを返す
+func (p AuthorizationCodeFlowParam) WithScopes(scopes []string) *AuthorizationCodeFlowParam {
+	p.scopes = scopes
+	return &p
+}
+
 func (p AuthorizationCodeFlowParam) State() string {
 	return p.state
 }
@@ -81,14 +81,14 @@ func Test_認可コードフローで使用するparameter構築(t *testing.T) {
 			expectedErr:  "redirect_uri is required",
 		},
 		{
+			// scopeを省略した場合はクライアントの既定のスコープを使うため、ここではエラーにしない
 			name:         "空のscope",
 			responseType: "code",
 			clientID:     "client-1",
 			redirectURI:  "https://example.com/callback",
 			scope:        "",
 			state:        "state123",
-			wantErr:      true,
-			expectedErr:  "scope is required",
+			wantErr:      false,
 		},
 		{
 			name:         "正常系 scopeが複数",
@@ -107,7 +107,7 @@ func Test_認可コードフローで使用するparameter構築(t *testing.T) {
 			scope:        "read ",
 			state:        "state123",
 			wantErr:      true,
-			expectedErr:  "invalid scopes. Supported scopes are: read, write",
+			expectedErr:  "unknown scope",
 		},
 		{
 			name:         "サポートされていないscope",
@@ -117,7 +117,7 @@ func Test_認可コードフローで使用するparameter構築(t *testing.T) {
 			scope:        "invalid-scope",
 			state:        "state123",
 			wantErr:      true,
-			expectedErr:  "invalid scopes. Supported scopes are: read, write",
+			expectedErr:  `unknown scope: "invalid-scope"`,
 		},
 		{
 			name:         "空のstate",
@@ -133,7 +133,7 @@ func Test_認可コードフローで使用するparameter構築(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			actual, err := NewAuthorizationCodeFlowParam(logger, tt.responseType, tt.clientID, tt.redirectURI, tt.scope, tt.state)
+			actual, err := NewAuthorizationCodeFlowParam(logger, DefaultScopeRegistry(), tt.responseType, tt.clientID, tt.redirectURI, tt.scope, tt.state)
 
 			if tt.wantErr {
 				if err == nil {
@@ -161,7 +161,10 @@ func Test_認可コードフローで使用するparameter構築(t *testing.T) {
 			if actual.RedirectURI() != tt.redirectURI {
 				t.Errorf("RedirectURI() = %v, want %v", actual.RedirectURI(), tt.redirectURI)
 			}
-			expectedScopes := strings.Split(tt.scope, " ")
+			var expectedScopes []string
+			if tt.scope != "" {
+				expectedScopes = strings.Split(tt.scope, " ")
+			}
 			if !reflect.DeepEqual(actual.Scopes(), expectedScopes) {
 				t.Errorf("Scopes() = %v, want %v", actual.Scopes(), expectedScopes)
 			}
@@ -16,21 +16,32 @@ type Client struct {
 	clientType   ClientType
 	secret       string
 	redirectURIs []string
+	policy       ClientPolicy
 }
 
+// 既定のポリシー(DefaultClientPolicy)のクライアントを再構築する
 func ReconstructClient(clientID ClientID, clientName string, clientType ClientType, secret string, redirectURIs []string) *Client {
+	return ReconstructClientWithPolicy(clientID, clientName, clientType, secret, redirectURIs, DefaultClientPolicy())
+}
+
+func ReconstructClientWithPolicy(clientID ClientID, clientName string, clientType ClientType, secret string, redirectURIs []string, policy ClientPolicy) *Client {
 	return &Client{
 		clientID:     clientID,
 		clientName:   clientName,
 		clientType:   clientType,
 		secret:       secret,
 		redirectURIs: redirectURIs,
+		policy:       policy,
 	}
 }
 
+// 要求されたリダイレクトURIが登録済みか。検証(ValidateRedirectURI)を満たさない登録済みのURIには一致させない
 func (c *Client) ContainsRedirectURI(redirectURI string) bool {
 	for _, uri := range c.redirectURIs {
-		if uri == redirectURI {
+		if ValidateRedirectURI(uri, c.ApplicationType()) != nil {
+			continue
+		}
+		if matchRedirectURI(uri, redirectURI, c.ApplicationType()) {
 			return true
 		}
 	}
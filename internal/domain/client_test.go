@@ -1,8 +1,10 @@
 package domain
 
 import (
+	"errors"
 	"reflect"
 	"testing"
+	"time"
 )
 
 func Test_client再構築(t *testing.T) {
@@ -86,3 +88,95 @@ func Test_clientがredirectURIを持っているか検査(t *testing.T) {
 		})
 	}
 }
+
+func Test_clientのポリシー(t *testing.T) {
+	policy := ClientPolicy{
+		GrantTypes:              []GrantType{GrantTypeAuthorizationCode},
+		ResponseTypes:           []ResponseType{ResponseTypeCode},
+		Scopes:                  []string{"read", "write"},
+		DefaultScopes:           []string{"read"},
+		TokenEndpointAuthMethod: AuthMethodClientSecretPost,
+		Lifetimes:               Lifetimes{AccessToken: 15 * time.Minute},
+		IssueRefreshToken:       true,
+	}
+	client := ReconstructClientWithPolicy("client-1", "Test Client", ConfidentialClient, "secret", nil, policy)
+
+	t.Run("grant_type", func(t *testing.T) {
+		if !client.AllowsGrantType(GrantTypeAuthorizationCode) {
+			t.Error("AllowsGrantType(authorization_code) = false, want true")
+		}
+		if client.AllowsGrantType(GrantTypeRefreshToken) {
+			t.Error("AllowsGrantType(refresh_token) = true, want false")
+		}
+		// refresh_tokenのgrant_typeを許可していない場合は、IssueRefreshTokenがtrueでも発行しない
+		if client.IssuesRefreshToken() {
+			t.Error("IssuesRefreshToken() = true, want false")
+		}
+	})
+
+	t.Run("スコープ", func(t *testing.T) {
+		registry := newTestScopeRegistry(t)
+
+		tests := []struct {
+			name      string
+			requested []string
+			expected  []string
+			wantErr   error
+		}{
+			{name: "許可されたスコープ", requested: []string{"write"}, expected: []string{"write"}},
+			{name: "省略した場合は既定のスコープ", requested: nil, expected: []string{"read"}},
+			{name: "許可されていないスコープ", requested: []string{"read", "admin"}, wantErr: ErrScopeNotAllowed},
+			{name: "定義されていないスコープ", requested: []string{"delete"}, wantErr: ErrUnknownScope},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				actual, err := client.ResolveScopes(registry, tt.requested)
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("ResolveScopes() error = %v, want %v", err, tt.wantErr)
+				}
+				if !reflect.DeepEqual(actual, tt.expected) {
+					t.Errorf("ResolveScopes() = %v, want %v", actual, tt.expected)
+				}
+			})
+		}
+
+		// 既定のスコープが無い場合はscopeが必須
+		noDefault := ReconstructClient("client-2", "Test Client", ConfidentialClient, "secret", nil)
+		if _, err := noDefault.ResolveScopes(registry, nil); !errors.Is(err, ErrScopeRequired) {
+			t.Errorf("ResolveScopes(nil) error = %v, want %v", err, ErrScopeRequired)
+		}
+	})
+
+	t.Run("クライアント認証の方式", func(t *testing.T) {
+		tests := []struct {
+			name     string
+			client   *Client
+			method   TokenEndpointAuthMethod
+			expected bool
+		}{
+			{name: "登録された方式", client: client, method: AuthMethodClientSecretPost, expected: true},
+			{name: "登録された方式以外", client: client, method: AuthMethodClientSecretBasic, expected: false},
+			{name: "未指定の場合はBasic認証を許可", client: ReconstructClient("c", "c", ConfidentialClient, "secret", nil), method: AuthMethodClientSecretBasic, expected: true},
+			{name: "未指定の場合はフォームを許可", client: ReconstructClient("c", "c", ConfidentialClient, "secret", nil), method: AuthMethodClientSecretPost, expected: true},
+			{name: "コンフィデンシャルクライアントは認証必須", client: ReconstructClient("c", "c", ConfidentialClient, "secret", nil), method: AuthMethodNone, expected: false},
+			{name: "パブリッククライアントは認証しない", client: ReconstructClient("c", "c", PublicClient, "", nil), method: AuthMethodNone, expected: true},
+			{name: "パブリッククライアントにシークレット", client: ReconstructClient("c", "c", PublicClient, "", nil), method: AuthMethodClientSecretPost, expected: false},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				if actual := tt.client.AllowsAuthMethod(tt.method); actual != tt.expected {
+					t.Errorf("AllowsAuthMethod(%s) = %v, want %v", tt.method, actual, tt.expected)
+				}
+			})
+		}
+	})
+
+	t.Run("有効期間", func(t *testing.T) {
+		defaults := DefaultLifetimes()
+		actual := client.Lifetimes(defaults)
+		expected := Lifetimes{AuthorizationCode: defaults.AuthorizationCode, AccessToken: 15 * time.Minute, RefreshToken: defaults.RefreshToken}
+		if actual != expected {
+			t.Errorf("Lifetimes() = %+v, want %+v", actual, expected)
+		}
+	})
+}
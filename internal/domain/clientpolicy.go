@@ -0,0 +1,119 @@
+package domain
+
+import (
+	"errors"
+	"slices"
+)
+
+// トークンエンドポイントでのクライアント認証の方式 RFC 7591 2.
+type TokenEndpointAuthMethod string
+
+const (
+	AuthMethodClientSecretBasic TokenEndpointAuthMethod = "client_secret_basic"
+	AuthMethodClientSecretPost  TokenEndpointAuthMethod = "client_secret_post"
+	AuthMethodNone              TokenEndpointAuthMethod = "none"
+)
+
+var (
+	ErrScopeNotAllowed = errors.New("requested scope is not allowed for the client")
+	ErrScopeRequired   = errors.New("scope is required")
+)
+
+// クライアント毎に許可する操作と、発行する認可コード・トークンの設定
+type ClientPolicy struct {
+	GrantTypes    []GrantType
+	ResponseTypes []ResponseType
+	// 要求できるスコープ。パラメータ付きのスコープの名前(例: repo:{repo_id}:read)も指定できる
+	// 含むスコープ(例: writeに対するread)も要求できる。空の場合はサポートする全てのスコープ
+	Scopes []string
+	// scopeを省略した場合のスコープ。空の場合はscopeを必須にする
+	DefaultScopes []string
+	// 空の場合、コンフィデンシャルクライアントはclient_secret_basicとclient_secret_postのどちらも使える
+	TokenEndpointAuthMethod TokenEndpointAuthMethod
+	// 0の項目はサーバー全体の有効期間を使う
+	Lifetimes Lifetimes
+	// falseの場合はアクセストークンのみ発行する
+	IssueRefreshToken bool
+	// 空の場合はweb
+	ApplicationType ApplicationType
+}
+
+// 認可コードフローとリフレッシュトークンを許可する
+func DefaultClientPolicy() ClientPolicy {
+	return ClientPolicy{
+		GrantTypes:        []GrantType{GrantTypeAuthorizationCode, GrantTypeRefreshToken},
+		ResponseTypes:     []ResponseType{ResponseTypeCode},
+		IssueRefreshToken: true,
+	}
+}
+
+func (c *Client) Policy() ClientPolicy { return c.policy }
+
+func (c *Client) ApplicationType() ApplicationType {
+	if c.policy.ApplicationType == "" {
+		return ApplicationTypeWeb
+	}
+	return c.policy.ApplicationType
+}
+
+func (c *Client) AllowsGrantType(grantType GrantType) bool {
+	return slices.Contains(c.policy.GrantTypes, grantType)
+}
+
+func (c *Client) AllowsResponseType(responseType ResponseType) bool {
+	return slices.Contains(c.policy.ResponseTypes, responseType)
+}
+
+// 全てのスコープがクライアントに許可されているか検証する。許可されていない場合は*InvalidScopeErrorを返す
+// ポリシーのスコープを省略した場合は、サポートする全てのスコープを許可する
+func (c *Client) CheckScopes(registry *ScopeRegistry, scopes []string) error {
+	if len(c.policy.Scopes) == 0 {
+		return registry.Validate(scopes)
+	}
+	return registry.CheckAllowed(c.policy.Scopes, scopes)
+}
+
+// 認可リクエストのスコープを検証する。省略された場合は既定のスコープを返す
+func (c *Client) ResolveScopes(registry *ScopeRegistry, requested []string) ([]string, error) {
+	if len(requested) == 0 {
+		if len(c.policy.DefaultScopes) == 0 {
+			return nil, &InvalidScopeError{Err: ErrScopeRequired}
+		}
+		requested = c.policy.DefaultScopes
+	}
+	if err := c.CheckScopes(registry, requested); err != nil {
+		return nil, err
+	}
+	return requested, nil
+}
+
+// トークンエンドポイントで使われた認証方式を許可しているか
+func (c *Client) AllowsAuthMethod(method TokenEndpointAuthMethod) bool {
+	if c.clientType == PublicClient {
+		return method == AuthMethodNone
+	}
+	if c.policy.TokenEndpointAuthMethod == "" {
+		return method == AuthMethodClientSecretBasic || method == AuthMethodClientSecretPost
+	}
+	return method == c.policy.TokenEndpointAuthMethod
+}
+
+// クライアントの有効期間。指定していない項目はdefaultsの値を使う
+func (c *Client) Lifetimes(defaults Lifetimes) Lifetimes {
+	lifetimes := defaults
+	if c.policy.Lifetimes.AuthorizationCode > 0 {
+		lifetimes.AuthorizationCode = c.policy.Lifetimes.AuthorizationCode
+	}
+	if c.policy.Lifetimes.AccessToken > 0 {
+		lifetimes.AccessToken = c.policy.Lifetimes.AccessToken
+	}
+	if c.policy.Lifetimes.RefreshToken > 0 {
+		lifetimes.RefreshToken = c.policy.Lifetimes.RefreshToken
+	}
+	return lifetimes
+}
+
+// リフレッシュトークンを発行するか。refresh_tokenのgrant_typeを許可していない場合も発行しない
+func (c *Client) IssuesRefreshToken() bool {
+	return c.policy.IssueRefreshToken && c.AllowsGrantType(GrantTypeRefreshToken)
+}
@@ -0,0 +1,101 @@
+package domain
+
+import (
+	"slices"
+	"time"
+)
+
+// ユーザーがクライアントに対して同意したスコープの記録
+// ユーザーとクライアントの組につき1件のみ存在する
+type Consent struct {
+	userID    string
+	clientID  string
+	scopes    []string
+	grantedAt time.Time
+	updatedAt time.Time
+	// ゼロ値の場合は有効期限なし
+	expiresAt time.Time
+	// クライアントが同意に基づいてトークンを取得した最初と最後の日時。ゼロ値の場合は未使用
+	firstUsedAt time.Time
+	lastUsedAt  time.Time
+}
+
+// durationに0以下を指定した場合は有効期限なしの同意とする
+func NewConsent(userID string, clientID string, scopes []string, now time.Time, duration time.Duration) *Consent {
+	return &Consent{
+		userID:    userID,
+		clientID:  clientID,
+		scopes:    slices.Clone(scopes),
+		grantedAt: now,
+		updatedAt: now,
+		expiresAt: consentExpiresAt(now, duration),
+	}
+}
+
+func ReconstructConsent(userID string, clientID string, scopes []string, grantedAt time.Time, updatedAt time.Time, expiresAt time.Time, firstUsedAt time.Time, lastUsedAt time.Time) *Consent {
+	return &Consent{
+		userID:      userID,
+		clientID:    clientID,
+		scopes:      scopes,
+		grantedAt:   grantedAt,
+		updatedAt:   updatedAt,
+		expiresAt:   expiresAt,
+		firstUsedAt: firstUsedAt,
+		lastUsedAt:  lastUsedAt,
+	}
+}
+
+func consentExpiresAt(now time.Time, duration time.Duration) time.Time {
+	if duration <= 0 {
+		return time.Time{}
+	}
+	return now.Add(duration)
+}
+
+func (c *Consent) UserID() string         { return c.userID }
+func (c *Consent) ClientID() string       { return c.clientID }
+func (c *Consent) Scopes() []string       { return c.scopes }
+func (c *Consent) GrantedAt() time.Time   { return c.grantedAt }
+func (c *Consent) UpdatedAt() time.Time   { return c.updatedAt }
+func (c *Consent) ExpiresAt() time.Time   { return c.expiresAt }
+func (c *Consent) FirstUsedAt() time.Time { return c.firstUsedAt }
+func (c *Consent) LastUsedAt() time.Time  { return c.lastUsedAt }
+
+func (c *Consent) IsExpired(now time.Time) bool {
+	return !c.expiresAt.IsZero() && now.After(c.expiresAt)
+}
+
+// 要求されたスコープが全て同意済みかどうか
+func (c *Consent) Covers(registry *ScopeRegistry, scopes []string) bool {
+	return registry.Covers(c.scopes, scopes)
+}
+
+// 追加で同意したスコープを加えた同意を返す。有効期限は同意した時点から延長する
+func (c *Consent) Grant(scopes []string, now time.Time, duration time.Duration) *Consent {
+	merged := slices.Clone(c.scopes)
+	for _, scope := range scopes {
+		if !slices.Contains(merged, scope) {
+			merged = append(merged, scope)
+		}
+	}
+	return &Consent{
+		userID:      c.userID,
+		clientID:    c.clientID,
+		scopes:      merged,
+		grantedAt:   c.grantedAt,
+		updatedAt:   now,
+		expiresAt:   consentExpiresAt(now, duration),
+		firstUsedAt: c.firstUsedAt,
+		lastUsedAt:  c.lastUsedAt,
+	}
+}
+
+// クライアントが同意に基づいてトークンを取得したことを記録した同意を返す
+func (c *Consent) RecordUse(now time.Time) *Consent {
+	used := *c
+	if used.firstUsedAt.IsZero() {
+		used.firstUsedAt = now
+	}
+	used.lastUsedAt = now
+	return &used
+}
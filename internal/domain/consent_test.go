@@ -0,0 +1,108 @@
+package domain
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_同意の構築(t *testing.T) {
+	now := time.Date(2000, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		name              string
+		duration          time.Duration
+		expectedExpiresAt time.Time
+	}{
+		{name: "有効期限あり", duration: 24 * time.Hour, expectedExpiresAt: now.Add(24 * time.Hour)},
+		{name: "有効期限なし", duration: 0, expectedExpiresAt: time.Time{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewConsent("user-1", "client-1", []string{"read"}, now, tt.duration)
+
+			if c.UserID() != "user-1" || c.ClientID() != "client-1" {
+				t.Errorf("UserID(), ClientID() = %v, %v, want %v, %v", c.UserID(), c.ClientID(), "user-1", "client-1")
+			}
+			if !reflect.DeepEqual(c.Scopes(), []string{"read"}) {
+				t.Errorf("Scopes() = %v, want %v", c.Scopes(), []string{"read"})
+			}
+			if !c.GrantedAt().Equal(now) || !c.UpdatedAt().Equal(now) {
+				t.Errorf("GrantedAt(), UpdatedAt() = %v, %v, want %v", c.GrantedAt(), c.UpdatedAt(), now)
+			}
+			if !c.ExpiresAt().Equal(tt.expectedExpiresAt) {
+				t.Errorf("ExpiresAt() = %v, want %v", c.ExpiresAt(), tt.expectedExpiresAt)
+			}
+			if c.IsExpired(now.Add(365*24*time.Hour)) != (tt.duration > 0) {
+				t.Errorf("IsExpired() = %v, want %v", !(tt.duration > 0), tt.duration > 0)
+			}
+		})
+	}
+}
+
+func Test_同意済みスコープの判定と追加(t *testing.T) {
+	now := time.Date(2000, 1, 2, 3, 4, 5, 0, time.UTC)
+	c := NewConsent("user-1", "client-1", []string{"read"}, now, time.Hour)
+
+	tests := []struct {
+		name     string
+		consent  *Consent
+		scopes   []string
+		expected bool
+	}{
+		{name: "同意済みのスコープのみ", consent: c, scopes: []string{"read"}, expected: true},
+		{name: "未同意のスコープを含む", consent: c, scopes: []string{"read", "write"}, expected: false},
+		{name: "追加で同意した後", consent: c.Grant([]string{"write"}, now.Add(time.Minute), time.Hour), scopes: []string{"read", "write"}, expected: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if actual := tt.consent.Covers(DefaultScopeRegistry(), tt.scopes); actual != tt.expected {
+				t.Errorf("Covers(%v) = %v, want %v", tt.scopes, actual, tt.expected)
+			}
+		})
+	}
+
+	granted := c.Grant([]string{"write", "read"}, now.Add(time.Minute), time.Hour)
+	if !reflect.DeepEqual(granted.Scopes(), []string{"read", "write"}) {
+		t.Errorf("Grant() scopes = %v, want %v", granted.Scopes(), []string{"read", "write"})
+	}
+	if !granted.GrantedAt().Equal(now) {
+		t.Errorf("Grant() should keep GrantedAt, got %v", granted.GrantedAt())
+	}
+	if !granted.UpdatedAt().Equal(now.Add(time.Minute)) {
+		t.Errorf("Grant() UpdatedAt = %v, want %v", granted.UpdatedAt(), now.Add(time.Minute))
+	}
+	if !granted.ExpiresAt().Equal(now.Add(time.Minute + time.Hour)) {
+		t.Errorf("Grant() ExpiresAt = %v, want %v", granted.ExpiresAt(), now.Add(time.Minute+time.Hour))
+	}
+	if c.Covers(DefaultScopeRegistry(), []string{"write"}) {
+		t.Error("Grant() should not modify the original consent")
+	}
+}
+
+func Test_同意の使用記録(t *testing.T) {
+	now := time.Date(2000, 1, 2, 3, 4, 5, 0, time.UTC)
+	c := NewConsent("user-1", "client-1", []string{"read"}, now, 0)
+	if !c.FirstUsedAt().IsZero() || !c.LastUsedAt().IsZero() {
+		t.Fatalf("FirstUsedAt(), LastUsedAt() = %v, %v, want zero", c.FirstUsedAt(), c.LastUsedAt())
+	}
+
+	first := c.RecordUse(now.Add(time.Minute))
+	second := first.RecordUse(now.Add(time.Hour))
+	if !second.FirstUsedAt().Equal(now.Add(time.Minute)) {
+		t.Errorf("FirstUsedAt() = %v, want %v", second.FirstUsedAt(), now.Add(time.Minute))
+	}
+	if !second.LastUsedAt().Equal(now.Add(time.Hour)) {
+		t.Errorf("LastUsedAt() = %v, want %v", second.LastUsedAt(), now.Add(time.Hour))
+	}
+	// 追加で同意しても使用記録は引き継ぐ
+	granted := second.Grant([]string{"write"}, now.Add(2*time.Hour), 0)
+	if !granted.FirstUsedAt().Equal(second.FirstUsedAt()) || !granted.LastUsedAt().Equal(second.LastUsedAt()) {
+		t.Errorf("Grant() should keep usage, got %v, %v", granted.FirstUsedAt(), granted.LastUsedAt())
+	}
+	if !c.LastUsedAt().IsZero() {
+		t.Error("RecordUse() should not modify the original consent")
+	}
+}
@@ -0,0 +1,22 @@
+package domain
+
+import "errors"
+
+var ErrInvalidFederatedIdentity = errors.New("issuer and subject are required")
+
+// 上流のOpenID Connectプロバイダー(IdP)のユーザー
+// subはIdPの中でのみ一意なため、IDトークンのissとsubの組で識別する OpenID Connect Core 1.0 5.7
+type FederatedIdentity struct {
+	issuer  string
+	subject string
+}
+
+func NewFederatedIdentity(issuer, subject string) (FederatedIdentity, error) {
+	if issuer == "" || subject == "" {
+		return FederatedIdentity{}, ErrInvalidFederatedIdentity
+	}
+	return FederatedIdentity{issuer: issuer, subject: subject}, nil
+}
+
+func (i FederatedIdentity) Issuer() string  { return i.issuer }
+func (i FederatedIdentity) Subject() string { return i.subject }
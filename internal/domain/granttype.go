@@ -28,3 +28,12 @@ func ResolveGrantType(grantType string) (GrantType, error) {
 	}
 	return g, nil
 }
+
+func (g GrantType) String() string {
+	for value, grantType := range grantTypeValueMap {
+		if grantType == g {
+			return value
+		}
+	}
+	return ""
+}
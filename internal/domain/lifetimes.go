@@ -0,0 +1,18 @@
+package domain
+
+import "time"
+
+// 認可コードとトークンの有効期間
+type Lifetimes struct {
+	AuthorizationCode time.Duration
+	AccessToken       time.Duration
+	RefreshToken      time.Duration
+}
+
+func DefaultLifetimes() Lifetimes {
+	return Lifetimes{
+		AuthorizationCode: AUTHORIZATION_CODE_DURATION,
+		AccessToken:       AccessTokenDuration,
+		RefreshToken:      RefreshTokenDuration,
+	}
+}
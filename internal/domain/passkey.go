@@ -0,0 +1,111 @@
+package domain
+
+import (
+	"errors"
+	"slices"
+	"time"
+)
+
+// パスキー(WebAuthn)によるログインを表すamrの値(RFC 8176)
+const (
+	// ハードウェアで保護された鍵の所持の確認。端末から取り出せない(同期されない)パスキーの場合
+	AMRHardwareKey = "hwk"
+	// ユーザーの存在の確認(認証器へのタッチなど)
+	AMRUserPresence = "user"
+)
+
+// 署名カウンターが前回のログイン以下の値に戻った。認証器が複製された可能性がある
+var ErrPasskeySignCountRegressed = errors.New("passkey sign count did not increase")
+
+// ユーザーが登録したパスキー(WebAuthnの公開鍵クレデンシャル)
+type PasskeyCredential struct {
+	// 認証器が発行したクレデンシャルID
+	credentialID []byte
+	userID       string
+	// ログイン時にユーザーを検索するためのログインID
+	loginID string
+	// COSE_Key形式の公開鍵 RFC 9052 7.
+	publicKey []byte
+	// 最後に確認した署名カウンター。カウンターを実装しない認証器は常に0
+	signCount uint32
+	// 複数の端末に同期される(バックアップできる)パスキーかどうか。登録時の認証器データのBEフラグ
+	backupEligible bool
+	// ユーザーが登録時に付けた名前
+	name       string
+	createdAt  time.Time
+	lastUsedAt time.Time
+}
+
+func NewPasskeyCredential(credentialID []byte, userID, loginID string, publicKey []byte, signCount uint32, backupEligible bool, name string, now time.Time) *PasskeyCredential {
+	return &PasskeyCredential{
+		credentialID:   slices.Clone(credentialID),
+		userID:         userID,
+		loginID:        loginID,
+		publicKey:      slices.Clone(publicKey),
+		signCount:      signCount,
+		backupEligible: backupEligible,
+		name:           name,
+		createdAt:      now,
+	}
+}
+
+func ReconstructPasskeyCredential(credentialID []byte, userID, loginID string, publicKey []byte, signCount uint32, backupEligible bool, name string, createdAt, lastUsedAt time.Time) *PasskeyCredential {
+	return &PasskeyCredential{
+		credentialID:   credentialID,
+		userID:         userID,
+		loginID:        loginID,
+		publicKey:      publicKey,
+		signCount:      signCount,
+		backupEligible: backupEligible,
+		name:           name,
+		createdAt:      createdAt,
+		lastUsedAt:     lastUsedAt,
+	}
+}
+
+func (c *PasskeyCredential) CredentialID() []byte  { return c.credentialID }
+func (c *PasskeyCredential) UserID() string        { return c.userID }
+func (c *PasskeyCredential) LoginID() string       { return c.loginID }
+func (c *PasskeyCredential) PublicKey() []byte     { return c.publicKey }
+func (c *PasskeyCredential) SignCount() uint32     { return c.signCount }
+func (c *PasskeyCredential) BackupEligible() bool  { return c.backupEligible }
+func (c *PasskeyCredential) Name() string          { return c.name }
+func (c *PasskeyCredential) CreatedAt() time.Time  { return c.createdAt }
+func (c *PasskeyCredential) LastUsedAt() time.Time { return c.lastUsedAt }
+
+// ログインで確認した署名カウンターを記録したパスキー
+// WebAuthn Level 2 6.1.1 カウンターを実装する認証器は署名毎に値を増やすため、前回以下の値は複製された認証器からの署名とみなして拒否する
+// 前回と今回がどちらも0の場合は、カウンターを実装しない認証器として受け付ける
+func (c *PasskeyCredential) Use(signCount uint32, now time.Time) (*PasskeyCredential, error) {
+	if (signCount != 0 || c.signCount != 0) && signCount <= c.signCount {
+		return nil, ErrPasskeySignCountRegressed
+	}
+	used := *c
+	used.signCount = signCount
+	used.lastUsedAt = now
+	return &used, nil
+}
+
+// ログインで署名を検証した認証器データのフラグ WebAuthn Level 2 6.1
+type PasskeyAssertionFlags struct {
+	// UPフラグ
+	UserPresent bool
+	// UVフラグ(生体認証やPINによる本人の確認)
+	UserVerified bool
+	// BEフラグ。複数の端末に同期される(バックアップできる)パスキーかどうか
+	BackupEligible bool
+}
+
+// 検証した署名のフラグから求めたamr
+// 同期されるパスキーは端末に保護された鍵とはいえないため、hwkを含めない
+// 本人の確認はユーザーの存在の確認を兼ねる
+func (f PasskeyAssertionFlags) AMR() []string {
+	var amr []string
+	if !f.BackupEligible {
+		amr = append(amr, AMRHardwareKey)
+	}
+	if f.UserPresent || f.UserVerified {
+		amr = append(amr, AMRUserPresence)
+	}
+	return amr
+}
@@ -0,0 +1,67 @@
+package domain
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func Test_パスキーの署名カウンター(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name        string
+		stored      uint32
+		signCount   uint32
+		expectedErr error
+	}{
+		{name: "正常系 - カウンターが増えた", stored: 5, signCount: 6},
+		{name: "正常系 - カウンターを実装しない認証器", stored: 0, signCount: 0},
+		{name: "正常系 - 初回のログインでカウンターが増えた", stored: 0, signCount: 1},
+		{name: "異常系 - 前回と同じ値", stored: 5, signCount: 5, expectedErr: ErrPasskeySignCountRegressed},
+		{name: "異常系 - 前回より小さい値", stored: 5, signCount: 3, expectedErr: ErrPasskeySignCountRegressed},
+		{name: "異常系 - カウンターが0に戻った", stored: 5, signCount: 0, expectedErr: ErrPasskeySignCountRegressed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// given
+			credential := NewPasskeyCredential([]byte("credential-1"), "user-1", "alice@example.com", []byte("key"), tt.stored, false, "", now)
+
+			// when
+			used, err := credential.Use(tt.signCount, now)
+
+			// then
+			if !errors.Is(err, tt.expectedErr) {
+				t.Fatalf("Use() error = %v, want %v", err, tt.expectedErr)
+			}
+			if tt.expectedErr != nil {
+				return
+			}
+			if used.SignCount() != tt.signCount || !used.LastUsedAt().Equal(now) {
+				t.Errorf("Use() = signCount %d, lastUsedAt %v", used.SignCount(), used.LastUsedAt())
+			}
+			if credential.SignCount() != tt.stored || !credential.LastUsedAt().IsZero() {
+				t.Errorf("Use() changed the original credential")
+			}
+		})
+	}
+}
+
+func Test_パスキーでログインした場合のamr(t *testing.T) {
+	tests := []struct {
+		name     string
+		flags    PasskeyAssertionFlags
+		expected []string
+	}{
+		{name: "端末から取り出せないパスキー", flags: PasskeyAssertionFlags{UserPresent: true, UserVerified: true}, expected: []string{AMRHardwareKey, AMRUserPresence}},
+		{name: "同期されるパスキー", flags: PasskeyAssertionFlags{UserPresent: true, UserVerified: true, BackupEligible: true}, expected: []string{AMRUserPresence}},
+		{name: "ユーザーの存在を確認していない", flags: PasskeyAssertionFlags{}, expected: []string{AMRHardwareKey}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if actual := tt.flags.AMR(); !slices.Equal(actual, tt.expected) {
+				t.Errorf("AMR() = %v, want %v", actual, tt.expected)
+			}
+		})
+	}
+}
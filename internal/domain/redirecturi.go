@@ -0,0 +1,111 @@
+package domain
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// クライアントの種類 OpenID Connect Dynamic Client Registration 2.
+// nativeはスマートフォンやデスクトップのアプリで、カスタムスキームとループバックアドレスのリダイレクトURIを使う
+type ApplicationType string
+
+const (
+	ApplicationTypeWeb    ApplicationType = "web"
+	ApplicationTypeNative ApplicationType = "native"
+)
+
+var (
+	ErrRedirectURINotAbsolute    = errors.New("redirect uri must be an absolute uri")
+	ErrRedirectURIFragment       = errors.New("redirect uri must not contain a fragment")
+	ErrRedirectURIInsecure       = errors.New("redirect uri must use https unless it is a loopback address")
+	ErrRedirectURICustomScheme   = errors.New("custom uri schemes are only allowed for native clients")
+	ErrRedirectURIUnsafeScheme   = errors.New("redirect uri uses an unsafe scheme")
+	ErrRedirectURIOpenRedirector = errors.New("redirect uri must not forward to another uri")
+)
+
+// ブラウザでスクリプトの実行やローカルのファイルの参照になるスキームは、ネイティブアプリでも許可しない
+var unsafeRedirectURISchemes = []string{"javascript", "data", "file", "vbscript", "blob", "about"}
+
+// 登録するリダイレクトURIを検証する RFC 6749 3.1.2, RFC 8252 7, OAuth 2.0 Security BCP 4.1
+func ValidateRedirectURI(redirectURI string, applicationType ApplicationType) error {
+	u, err := url.Parse(redirectURI)
+	if err != nil || !u.IsAbs() {
+		return ErrRedirectURINotAbsolute
+	}
+	if u.Fragment != "" || strings.Contains(redirectURI, "#") {
+		return ErrRedirectURIFragment
+	}
+	scheme := strings.ToLower(u.Scheme)
+	switch {
+	case scheme == "https":
+	case scheme == "http":
+		if !IsLoopbackHost(u.Hostname()) {
+			return ErrRedirectURIInsecure
+		}
+	case slices.Contains(unsafeRedirectURISchemes, scheme):
+		return ErrRedirectURIUnsafeScheme
+	default:
+		// RFC 8252 7.1 カスタムスキームはアプリが管理するドメインを逆順にした名前(例: com.example.app)
+		if applicationType != ApplicationTypeNative {
+			return ErrRedirectURICustomScheme
+		}
+		if !strings.Contains(scheme, ".") {
+			return fmt.Errorf("%w: scheme %q must be a reverse domain name", ErrRedirectURICustomScheme, u.Scheme)
+		}
+	}
+	if (scheme == "https" || scheme == "http") && u.Host == "" {
+		return ErrRedirectURINotAbsolute
+	}
+	// 任意の転送先を受け取るURIやワイルドカード、ユーザー情報でホストを偽装したURIはオープンリダイレクタになり得る
+	if u.User != nil || strings.Contains(redirectURI, "*") {
+		return ErrRedirectURIOpenRedirector
+	}
+	for name, values := range u.Query() {
+		for _, value := range values {
+			if isForwardingTarget(value) {
+				return fmt.Errorf("%w: query parameter %q", ErrRedirectURIOpenRedirector, name)
+			}
+		}
+	}
+	return nil
+}
+
+// 絶対URIまたはスキームを省略したURI(//example.com)
+func isForwardingTarget(value string) bool {
+	if strings.HasPrefix(value, "//") || strings.HasPrefix(value, `\\`) {
+		return true
+	}
+	u, err := url.Parse(value)
+	return err == nil && u.Scheme != "" && u.Host != ""
+}
+
+// RFC 8252 8.3 localhost は名前解決でループバック以外を指し得るため、IPリテラルのみ受け付ける
+// リダイレクトURIと認可サーバーの識別子で、httpを許可するホストの判定に使う
+func IsLoopbackHost(host string) bool {
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// 登録済みのリダイレクトURI(registered)と要求されたリダイレクトURIが一致するか
+// RFC 8252 7.3 ネイティブアプリのループバックアドレスは起動毎にポートが変わるため、ポート以外が一致すればよい
+func matchRedirectURI(registered string, requested string, applicationType ApplicationType) bool {
+	if registered == requested {
+		return true
+	}
+	if applicationType != ApplicationTypeNative {
+		return false
+	}
+	r, err := url.Parse(registered)
+	if err != nil || r.Scheme != "http" || !IsLoopbackHost(r.Hostname()) {
+		return false
+	}
+	q, err := url.Parse(requested)
+	if err != nil || q.Scheme != r.Scheme || q.Hostname() != r.Hostname() || q.User != nil || q.Fragment != "" {
+		return false
+	}
+	return q.EscapedPath() == r.EscapedPath() && q.RawQuery == r.RawQuery
+}
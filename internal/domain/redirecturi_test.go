@@ -0,0 +1,71 @@
+package domain
+
+import (
+	"errors"
+	"testing"
+)
+
+func Test_リダイレクトURIの検証(t *testing.T) {
+	tests := []struct {
+		name            string
+		redirectURI     string
+		applicationType ApplicationType
+		wantErr         error
+	}{
+		{name: "https", redirectURI: "https://client.example.com/callback", applicationType: ApplicationTypeWeb},
+		{name: "クエリを含む", redirectURI: "https://client.example.com/callback?tenant=a", applicationType: ApplicationTypeWeb},
+		{name: "ループバックアドレスのhttp", redirectURI: "http://127.0.0.1:8000/callback", applicationType: ApplicationTypeWeb},
+		{name: "IPv6のループバックアドレスのhttp", redirectURI: "http://[::1]/callback", applicationType: ApplicationTypeNative},
+		{name: "ネイティブアプリのカスタムスキーム", redirectURI: "com.example.app:/callback", applicationType: ApplicationTypeNative},
+		{name: "相対URI", redirectURI: "/callback", applicationType: ApplicationTypeWeb, wantErr: ErrRedirectURINotAbsolute},
+		{name: "ホストがない", redirectURI: "https:///callback", applicationType: ApplicationTypeWeb, wantErr: ErrRedirectURINotAbsolute},
+		{name: "フラグメントを含む", redirectURI: "https://client.example.com/callback#top", applicationType: ApplicationTypeWeb, wantErr: ErrRedirectURIFragment},
+		{name: "空のフラグメントを含む", redirectURI: "https://client.example.com/callback#", applicationType: ApplicationTypeWeb, wantErr: ErrRedirectURIFragment},
+		{name: "ループバックアドレス以外のhttp", redirectURI: "http://client.example.com/callback", applicationType: ApplicationTypeNative, wantErr: ErrRedirectURIInsecure},
+		{name: "localhostのhttp", redirectURI: "http://localhost:8000/callback", applicationType: ApplicationTypeNative, wantErr: ErrRedirectURIInsecure},
+		{name: "webクライアントのカスタムスキーム", redirectURI: "com.example.app:/callback", applicationType: ApplicationTypeWeb, wantErr: ErrRedirectURICustomScheme},
+		{name: "ドメイン名でないカスタムスキーム", redirectURI: "myapp:/callback", applicationType: ApplicationTypeNative, wantErr: ErrRedirectURICustomScheme},
+		{name: "javascriptスキーム", redirectURI: "javascript:alert(1)", applicationType: ApplicationTypeNative, wantErr: ErrRedirectURIUnsafeScheme},
+		{name: "転送先を受け取るクエリ", redirectURI: "https://client.example.com/redirect?url=https://evil.example.com", applicationType: ApplicationTypeWeb, wantErr: ErrRedirectURIOpenRedirector},
+		{name: "スキームを省略した転送先を受け取るクエリ", redirectURI: "https://client.example.com/redirect?next=//evil.example.com", applicationType: ApplicationTypeWeb, wantErr: ErrRedirectURIOpenRedirector},
+		{name: "ワイルドカード", redirectURI: "https://*.example.com/callback", applicationType: ApplicationTypeWeb, wantErr: ErrRedirectURIOpenRedirector},
+		{name: "ユーザー情報を含む", redirectURI: "https://client.example.com@evil.example.com/callback", applicationType: ApplicationTypeWeb, wantErr: ErrRedirectURIOpenRedirector},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateRedirectURI(tt.redirectURI, tt.applicationType); !errors.Is(err, tt.wantErr) {
+				t.Errorf("ValidateRedirectURI(%q) error = %v, want %v", tt.redirectURI, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_リダイレクトURIの照合(t *testing.T) {
+	redirectURIs := []string{"https://client.example.com/callback", "http://127.0.0.1/callback", "http://localhost/callback", "http://client.example.com/unvalidated"}
+	web := ReconstructClient("web", "Web", ConfidentialClient, "secret", redirectURIs)
+	native := ReconstructClientWithPolicy("native", "Native", PublicClient, "", redirectURIs, ClientPolicy{ApplicationType: ApplicationTypeNative})
+
+	tests := []struct {
+		name        string
+		client      *Client
+		redirectURI string
+		expected    bool
+	}{
+		{name: "完全に一致する", client: web, redirectURI: "https://client.example.com/callback", expected: true},
+		{name: "パスが異なる", client: web, redirectURI: "https://client.example.com/callback/other", expected: false},
+		{name: "クエリを追加した", client: web, redirectURI: "https://client.example.com/callback?x=1", expected: false},
+		{name: "webクライアントのループバックアドレスはポートも一致が必要", client: web, redirectURI: "http://127.0.0.1:51234/callback", expected: false},
+		{name: "ネイティブアプリのループバックアドレスは任意のポート", client: native, redirectURI: "http://127.0.0.1:51234/callback", expected: true},
+		{name: "ネイティブアプリのループバックアドレスでもパスは一致が必要", client: native, redirectURI: "http://127.0.0.1:51234/other", expected: false},
+		{name: "ネイティブアプリのループバックアドレスでもホストは一致が必要", client: native, redirectURI: "http://[::1]:51234/callback", expected: false},
+		{name: "ネイティブアプリでもlocalhostは任意のポートにしない", client: native, redirectURI: "http://localhost:51234/callback", expected: false},
+		{name: "検証を満たさない登録済みのURIには一致しない", client: web, redirectURI: "http://client.example.com/unvalidated", expected: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if actual := tt.client.ContainsRedirectURI(tt.redirectURI); actual != tt.expected {
+				t.Errorf("ContainsRedirectURI(%q) = %v, want %v", tt.redirectURI, actual, tt.expected)
+			}
+		})
+	}
+}
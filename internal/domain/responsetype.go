@@ -16,14 +16,14 @@ type ResponseType int
 
 const (
 	notSupported ResponseType = iota
-	responseTypeCode
+	ResponseTypeCode
 	// responseTypeToken
 	// responseTypeIDToken
 )
 
 // とりあえずcodeのみサポート
 var codeValueMap = map[string]ResponseType{
-	"code": responseTypeCode,
+	"code": ResponseTypeCode,
 	// "token":    responseTypeToken,
 	// "id_token": responseTypeIDToken,
 }
@@ -36,3 +36,12 @@ func GetResponseType(responseType string) (ResponseType, error) {
 
 	return r, nil
 }
+
+func (r ResponseType) String() string {
+	for value, responseType := range codeValueMap {
+		if responseType == r {
+			return value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,36 @@
+package domain
+
+import (
+	"slices"
+	"strings"
+)
+
+// 付与済みのスコープ(granted)から、要求されたスコープ(requested)に絞り込む
+// requestedが空の場合は付与済みのスコープをそのまま返す。付与済みのスコープが含むスコープ(例: writeに対するread)にも絞り込める
+// 付与されていないスコープを含む場合はエラー
+func NarrowScopes(registry *ScopeRegistry, granted []string, requested []string) ([]string, error) {
+	if len(requested) == 0 {
+		return granted, nil
+	}
+	expanded := registry.Expand(granted)
+	narrowed := make([]string, 0, len(requested))
+	for _, scope := range requested {
+		if !slices.Contains(expanded, scope) {
+			return nil, &InvalidScopeError{Scope: scope, Err: ErrScopeNotGranted}
+		}
+		if !slices.Contains(narrowed, scope) {
+			narrowed = append(narrowed, scope)
+		}
+	}
+	return narrowed, nil
+}
+
+// スペース区切りのscopeパラメータを分割する。空文字の場合は空のスライスを返す
+func ParseScope(scope string) []string {
+	return strings.Fields(scope)
+}
+
+// スコープをスペース区切りのscopeパラメータの形式にする
+func FormatScope(scopes []string) string {
+	return strings.Join(scopes, " ")
+}
@@ -0,0 +1,52 @@
+package domain
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func Test_スコープの絞り込み(t *testing.T) {
+	granted := []string{"read", "write"}
+
+	tests := []struct {
+		name      string
+		requested []string
+		expected  []string
+		wantErr   error
+	}{
+		{name: "要求が空の場合は付与済みのスコープ", requested: nil, expected: []string{"read", "write"}},
+		{name: "一部のスコープに絞り込む", requested: []string{"read"}, expected: []string{"read"}},
+		{name: "同じスコープ", requested: []string{"write", "read"}, expected: []string{"write", "read"}},
+		{name: "重複したスコープはまとめる", requested: []string{"read", "read"}, expected: []string{"read"}},
+		{name: "付与されていないスコープを含む", requested: []string{"read", "admin"}, wantErr: ErrScopeNotGranted},
+	}
+	// writeはreadを含むため、writeのみ付与した場合もreadに絞り込める
+	if actual, err := NarrowScopes(DefaultScopeRegistry(), []string{"write"}, []string{"read"}); err != nil || !reflect.DeepEqual(actual, []string{"read"}) {
+		t.Errorf("NarrowScopes(write, read) = %v, %v, want [read]", actual, err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actual, err := NarrowScopes(DefaultScopeRegistry(), granted, tt.requested)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("NarrowScopes() error = %v, want %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(actual, tt.expected) {
+				t.Errorf("NarrowScopes() = %v, want %v", actual, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_scopeパラメータの変換(t *testing.T) {
+	if actual := ParseScope(" read  write "); !reflect.DeepEqual(actual, []string{"read", "write"}) {
+		t.Errorf("ParseScope() = %v, want %v", actual, []string{"read", "write"})
+	}
+	if actual := ParseScope(""); len(actual) != 0 {
+		t.Errorf("ParseScope(\"\") = %v, want empty", actual)
+	}
+	if actual := FormatScope([]string{"read", "write"}); actual != "read write" {
+		t.Errorf("FormatScope() = %v, want %v", actual, "read write")
+	}
+}
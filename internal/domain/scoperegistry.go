@@ -0,0 +1,386 @@
+package domain
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"sync/atomic"
+)
+
+// スコープの重要度。同意画面で注意を促すために使う
+type ScopeSensitivity int
+
+const (
+	ScopeSensitivityLow ScopeSensitivity = iota
+	ScopeSensitivityMedium
+	ScopeSensitivityHigh
+)
+
+var scopeSensitivityValueMap = map[string]ScopeSensitivity{
+	"low":    ScopeSensitivityLow,
+	"medium": ScopeSensitivityMedium,
+	"high":   ScopeSensitivityHigh,
+}
+
+func ParseScopeSensitivity(sensitivity string) (ScopeSensitivity, error) {
+	s, ok := scopeSensitivityValueMap[sensitivity]
+	if !ok {
+		return ScopeSensitivityLow, fmt.Errorf("unknown scope sensitivity: %s", sensitivity)
+	}
+	return s, nil
+}
+
+func (s ScopeSensitivity) String() string {
+	for value, sensitivity := range scopeSensitivityValueMap {
+		if sensitivity == s {
+			return value
+		}
+	}
+	return ""
+}
+
+// スコープの定義
+// 名前に{パラメータ名}を含む場合はパラメータ付きのスコープ。例: repo:{repo_id}:read は repo:123:read に一致する
+type ScopeDefinition struct {
+	Name string
+	// 同意画面に表示する名前と説明。{パラメータ名}はパラメータの値に置き換える。省略した場合はスコープ名
+	DisplayName string
+	Description string
+	Sensitivity ScopeSensitivity
+	// このスコープが含むスコープ。例: writeはreadを含む
+	// パラメータ付きのスコープの場合、{パラメータ名}は同じ名前のパラメータの値に置き換える
+	Implies []string
+	// パラメータ名毎の値の形式(正規表現)。省略したパラメータはdefaultScopeParameterPattern
+	Parameters map[string]string
+}
+
+// パラメータの値の既定の形式。区切り文字の:は含めない
+const defaultScopeParameterPattern = `[A-Za-z0-9_.-]+`
+
+var (
+	ErrUnknownScope          = errors.New("unknown scope")
+	ErrInvalidScopeParameter = errors.New("invalid scope parameter")
+	ErrScopeNotGranted       = errors.New("requested scope exceeds the granted scope")
+
+	scopePlaceholder = regexp.MustCompile(`\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+)
+
+// 不正なスコープ。各エンドポイントのinvalid_scopeのエラーの説明にはこのエラーを使う
+type InvalidScopeError struct {
+	// 問題のあるスコープ。スコープを省略した場合は空
+	Scope string
+	Err   error
+}
+
+func (e *InvalidScopeError) Error() string {
+	if e.Scope == "" {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("%v: %q", e.Err, e.Scope)
+}
+
+func (e *InvalidScopeError) Unwrap() error { return e.Err }
+
+// 同意画面に表示するスコープの情報
+type ScopeDescription struct {
+	Scope       string
+	DisplayName string
+	Description string
+	Sensitivity ScopeSensitivity
+}
+
+type registeredScope struct {
+	definition ScopeDefinition
+	// パラメータ付きのスコープの場合のみ
+	params []string
+	// パラメータの形式も検証するパターンと、形式の誤りを判別するためのパラメータを検証しないパターン
+	strict *regexp.Regexp
+	loose  *regexp.Regexp
+}
+
+// サポートするスコープの一覧。構築後は変更しない
+type ScopeRegistry struct {
+	scopes []*registeredScope
+	byName map[string]*registeredScope
+}
+
+// 定義の問題を全てまとめたエラーを返す
+func NewScopeRegistry(definitions []ScopeDefinition) (*ScopeRegistry, error) {
+	r := &ScopeRegistry{byName: map[string]*registeredScope{}}
+	var errs []error
+	for _, definition := range definitions {
+		if _, ok := r.byName[definition.Name]; ok {
+			errs = append(errs, fmt.Errorf("scope %q: duplicate scope", definition.Name))
+			continue
+		}
+		scope, err := compileScope(definition)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("scope %q: %w", definition.Name, err))
+			continue
+		}
+		r.scopes = append(r.scopes, scope)
+		r.byName[definition.Name] = scope
+	}
+	// 含むスコープは定義済みで、元のスコープのパラメータのみを使うこと
+	for _, scope := range r.scopes {
+		for _, implied := range scope.definition.Implies {
+			target, ok := r.byName[implied]
+			if !ok {
+				errs = append(errs, fmt.Errorf("scope %q: implied scope %q is not defined", scope.definition.Name, implied))
+				continue
+			}
+			for _, param := range target.params {
+				if !slices.Contains(scope.params, param) {
+					errs = append(errs, fmt.Errorf("scope %q: implied scope %q uses unknown parameter %q", scope.definition.Name, implied, param))
+				}
+			}
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func compileScope(definition ScopeDefinition) (*registeredScope, error) {
+	if !isScopeToken(definition.Name) {
+		return nil, errors.New("invalid scope name")
+	}
+	scope := &registeredScope{definition: definition}
+	matches := scopePlaceholder.FindAllStringSubmatchIndex(definition.Name, -1)
+	if len(matches) == 0 {
+		if len(definition.Parameters) > 0 {
+			return nil, errors.New("parameters are defined for a scope without placeholders")
+		}
+		return scope, nil
+	}
+
+	var strict, loose strings.Builder
+	strict.WriteString("^")
+	loose.WriteString("^")
+	last := 0
+	for _, m := range matches {
+		param := definition.Name[m[2]:m[3]]
+		if slices.Contains(scope.params, param) {
+			return nil, fmt.Errorf("duplicate parameter %q", param)
+		}
+		scope.params = append(scope.params, param)
+		pattern := defaultScopeParameterPattern
+		if p, ok := definition.Parameters[param]; ok {
+			pattern = p
+		}
+		if _, err := regexp.Compile(pattern); err != nil {
+			return nil, fmt.Errorf("parameter %q: %w", param, err)
+		}
+		literal := regexp.QuoteMeta(definition.Name[last:m[0]])
+		strict.WriteString(literal + "((?:" + pattern + "))")
+		loose.WriteString(literal + "(.*)")
+		last = m[1]
+	}
+	strict.WriteString(regexp.QuoteMeta(definition.Name[last:]) + "$")
+	loose.WriteString(regexp.QuoteMeta(definition.Name[last:]) + "$")
+	for param := range definition.Parameters {
+		if !slices.Contains(scope.params, param) {
+			return nil, fmt.Errorf("parameter %q is not used in the scope name", param)
+		}
+	}
+	scope.strict = regexp.MustCompile(strict.String())
+	scope.loose = regexp.MustCompile(loose.String())
+	return scope, nil
+}
+
+// RFC 6749 3.3 scope-token = 1*( %x21 / %x23-5B / %x5D-7E )
+func isScopeToken(scope string) bool {
+	if scope == "" {
+		return false
+	}
+	for _, c := range []byte(scope) {
+		if c < 0x21 || c == 0x22 || c == 0x5C || c > 0x7E {
+			return false
+		}
+	}
+	return true
+}
+
+// 要求されたスコープに一致する定義とパラメータの値を返す
+func (r *ScopeRegistry) lookup(scope string) (*registeredScope, map[string]string, error) {
+	if registered, ok := r.byName[scope]; ok && len(registered.params) == 0 {
+		return registered, nil, nil
+	}
+	var err error = ErrUnknownScope
+	for _, registered := range r.scopes {
+		if registered.strict == nil {
+			continue
+		}
+		if m := registered.strict.FindStringSubmatch(scope); m != nil {
+			params := make(map[string]string, len(registered.params))
+			for i, param := range registered.params {
+				params[param] = m[i+1]
+			}
+			return registered, params, nil
+		}
+		if registered.loose.MatchString(scope) {
+			err = ErrInvalidScopeParameter
+		}
+	}
+	return nil, nil, &InvalidScopeError{Scope: scope, Err: err}
+}
+
+// 全てのスコープが定義済みか検証し、最初に見つかった問題を返す
+func (r *ScopeRegistry) Validate(scopes []string) error {
+	for _, scope := range scopes {
+		if _, _, err := r.lookup(scope); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// スコープの名前として定義されているか。パラメータ付きのスコープは{パラメータ名}を含む名前
+func (r *ScopeRegistry) IsDefined(name string) bool {
+	_, ok := r.byName[name]
+	return ok
+}
+
+// パラメータを含まないスコープの名前の一覧
+func (r *ScopeRegistry) Names() []string {
+	names := make([]string, 0, len(r.scopes))
+	for _, scope := range r.scopes {
+		if len(scope.params) == 0 {
+			names = append(names, scope.definition.Name)
+		}
+	}
+	return names
+}
+
+// 含むスコープを全て加えたスコープの一覧を返す。定義されていないスコープはそのまま含める
+func (r *ScopeRegistry) Expand(scopes []string) []string {
+	expanded := make([]string, 0, len(scopes))
+	var visit func(scope string)
+	visit = func(scope string) {
+		if slices.Contains(expanded, scope) {
+			return
+		}
+		expanded = append(expanded, scope)
+		registered, params, err := r.lookup(scope)
+		if err != nil {
+			return
+		}
+		for _, implied := range registered.definition.Implies {
+			visit(instantiateScope(implied, params))
+		}
+	}
+	for _, scope := range scopes {
+		visit(scope)
+	}
+	return expanded
+}
+
+// 付与済みのスコープ(granted)が、要求されたスコープ(requested)を全て含むか
+func (r *ScopeRegistry) Covers(granted []string, requested []string) bool {
+	expanded := r.Expand(granted)
+	for _, scope := range requested {
+		if !slices.Contains(expanded, scope) {
+			return false
+		}
+	}
+	return true
+}
+
+// 許可するスコープの一覧(allowed)が要求されたスコープを全て含むか検証する
+// allowedにはパラメータ付きのスコープの名前(例: repo:{repo_id}:write)も指定でき、任意のパラメータの値を許可する
+func (r *ScopeRegistry) CheckAllowed(allowed []string, requested []string) error {
+	for _, scope := range requested {
+		_, params, err := r.lookup(scope)
+		if err != nil {
+			return err
+		}
+		candidates := make([]string, 0, len(allowed))
+		for _, a := range allowed {
+			candidates = append(candidates, instantiateScope(a, params))
+		}
+		if !slices.Contains(r.Expand(candidates), scope) {
+			return &InvalidScopeError{Scope: scope, Err: ErrScopeNotAllowed}
+		}
+	}
+	return nil
+}
+
+// 同意画面に表示する情報を返す。定義されていないスコープはスコープ名をそのまま使う
+func (r *ScopeRegistry) Describe(scope string) ScopeDescription {
+	description := ScopeDescription{Scope: scope, DisplayName: scope, Description: scope}
+	registered, params, err := r.lookup(scope)
+	if err != nil {
+		return description
+	}
+	if registered.definition.DisplayName != "" {
+		description.DisplayName = instantiateScope(registered.definition.DisplayName, params)
+	}
+	if registered.definition.Description != "" {
+		description.Description = instantiateScope(registered.definition.Description, params)
+	}
+	description.Sensitivity = registered.definition.Sensitivity
+	return description
+}
+
+// {パラメータ名}をパラメータの値に置き換える。値が無いパラメータはそのまま残す
+func instantiateScope(template string, params map[string]string) string {
+	if len(params) == 0 {
+		return template
+	}
+	return scopePlaceholder.ReplaceAllStringFunc(template, func(placeholder string) string {
+		if value, ok := params[placeholder[1:len(placeholder)-1]]; ok {
+			return value
+		}
+		return placeholder
+	})
+}
+
+// 設定ファイルで指定しない場合のスコープ
+func DefaultScopeDefinitions() []ScopeDefinition {
+	return []ScopeDefinition{
+		{Name: "read", DisplayName: "参照", Description: "データの参照", Sensitivity: ScopeSensitivityLow},
+		{Name: "write", DisplayName: "作成・更新", Description: "データの作成・更新", Sensitivity: ScopeSensitivityMedium, Implies: []string{"read"}},
+	}
+}
+
+var defaultScopeRegistry = func() *ScopeRegistry {
+	r, err := NewScopeRegistry(DefaultScopeDefinitions())
+	if err != nil {
+		panic(err)
+	}
+	return r
+}()
+
+// 既定のスコープ(DefaultScopeDefinitions)の一覧
+func DefaultScopeRegistry() *ScopeRegistry {
+	return defaultScopeRegistry
+}
+
+// サポートするスコープの一覧。レルム毎に持ち、設定の再読み込みで差し替える
+type ScopeProvider struct {
+	current atomic.Pointer[ScopeRegistry]
+}
+
+// nilを渡した場合は既定のスコープを使う
+func NewScopeProvider(r *ScopeRegistry) *ScopeProvider {
+	p := &ScopeProvider{}
+	p.Replace(r)
+	return p
+}
+
+// 現在のスコープの一覧を返す。1つのリクエストの中では、取得した一覧を使い続ける
+func (p *ScopeProvider) Current() *ScopeRegistry {
+	return p.current.Load()
+}
+
+// スコープの一覧を置き換える。処理中のリクエストに影響しないよう、一覧ごと置き換える
+// nilを渡した場合は既定のスコープに戻す
+func (p *ScopeProvider) Replace(r *ScopeRegistry) {
+	if r == nil {
+		r = defaultScopeRegistry
+	}
+	p.current.Store(r)
+}
@@ -0,0 +1,171 @@
+package domain
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func newTestScopeRegistry(t *testing.T) *ScopeRegistry {
+	t.Helper()
+	r, err := NewScopeRegistry([]ScopeDefinition{
+		{Name: "read", DisplayName: "参照", Description: "データの参照"},
+		{Name: "write", DisplayName: "作成・更新", Description: "データの作成・更新", Sensitivity: ScopeSensitivityMedium, Implies: []string{"read"}},
+		{Name: "admin", DisplayName: "管理", Description: "全ての操作", Sensitivity: ScopeSensitivityHigh, Implies: []string{"write"}},
+		{Name: "repo:{repo_id}:read", DisplayName: "リポジトリ{repo_id}の参照", Description: "リポジトリ{repo_id}の参照", Parameters: map[string]string{"repo_id": `[0-9]+`}},
+		{Name: "repo:{repo_id}:write", DisplayName: "リポジトリ{repo_id}の更新", Description: "リポジトリ{repo_id}の更新", Implies: []string{"repo:{repo_id}:read"}, Parameters: map[string]string{"repo_id": `[0-9]+`}},
+	})
+	if err != nil {
+		t.Fatalf("NewScopeRegistry() error = %v", err)
+	}
+	return r
+}
+
+func Test_スコープの検証(t *testing.T) {
+	r := newTestScopeRegistry(t)
+
+	tests := []struct {
+		name    string
+		scopes  []string
+		wantErr error
+	}{
+		{name: "定義済みのスコープ", scopes: []string{"read", "admin"}},
+		{name: "パラメータ付きのスコープ", scopes: []string{"repo:123:read", "repo:456:write"}},
+		{name: "定義されていないスコープ", scopes: []string{"read", "delete"}, wantErr: ErrUnknownScope},
+		{name: "パラメータの形式が不正", scopes: []string{"repo:abc:read"}, wantErr: ErrInvalidScopeParameter},
+		{name: "パラメータの値が空", scopes: []string{"repo::read"}, wantErr: ErrInvalidScopeParameter},
+		{name: "パラメータ付きのスコープの名前そのもの", scopes: []string{"repo:{repo_id}:read"}, wantErr: ErrInvalidScopeParameter},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := r.Validate(tt.scopes)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Validate() error = %v, want %v", err, tt.wantErr)
+			}
+			var scopeErr *InvalidScopeError
+			if tt.wantErr != nil && !errors.As(err, &scopeErr) {
+				t.Errorf("Validate() error = %T, want *InvalidScopeError", err)
+			}
+		})
+	}
+}
+
+func Test_スコープの階層(t *testing.T) {
+	r := newTestScopeRegistry(t)
+
+	if actual := r.Expand([]string{"admin"}); !reflect.DeepEqual(actual, []string{"admin", "write", "read"}) {
+		t.Errorf("Expand(admin) = %v, want [admin write read]", actual)
+	}
+	if actual := r.Expand([]string{"repo:1:write"}); !reflect.DeepEqual(actual, []string{"repo:1:write", "repo:1:read"}) {
+		t.Errorf("Expand(repo:1:write) = %v, want [repo:1:write repo:1:read]", actual)
+	}
+
+	tests := []struct {
+		name      string
+		granted   []string
+		requested []string
+		expected  bool
+	}{
+		{name: "同じスコープ", granted: []string{"read"}, requested: []string{"read"}, expected: true},
+		{name: "含むスコープ", granted: []string{"admin"}, requested: []string{"read", "write"}, expected: true},
+		{name: "含まれないスコープ", granted: []string{"read"}, requested: []string{"write"}, expected: false},
+		{name: "同じパラメータの含むスコープ", granted: []string{"repo:1:write"}, requested: []string{"repo:1:read"}, expected: true},
+		{name: "異なるパラメータのスコープ", granted: []string{"repo:1:write"}, requested: []string{"repo:2:read"}, expected: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if actual := r.Covers(tt.granted, tt.requested); actual != tt.expected {
+				t.Errorf("Covers(%v, %v) = %v, want %v", tt.granted, tt.requested, actual, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_許可するスコープの検証(t *testing.T) {
+	r := newTestScopeRegistry(t)
+
+	tests := []struct {
+		name      string
+		allowed   []string
+		requested []string
+		wantErr   error
+	}{
+		{name: "許可されたスコープ", allowed: []string{"read", "write"}, requested: []string{"write"}},
+		{name: "許可されたスコープが含むスコープ", allowed: []string{"write"}, requested: []string{"read"}},
+		{name: "許可されていないスコープ", allowed: []string{"read"}, requested: []string{"write"}, wantErr: ErrScopeNotAllowed},
+		{name: "パラメータ付きのスコープの名前で任意のパラメータを許可する", allowed: []string{"repo:{repo_id}:write"}, requested: []string{"repo:1:read", "repo:2:write"}},
+		{name: "特定のパラメータのみ許可する", allowed: []string{"repo:1:read"}, requested: []string{"repo:2:read"}, wantErr: ErrScopeNotAllowed},
+		{name: "定義されていないスコープ", allowed: []string{"read"}, requested: []string{"delete"}, wantErr: ErrUnknownScope},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := r.CheckAllowed(tt.allowed, tt.requested); !errors.Is(err, tt.wantErr) {
+				t.Errorf("CheckAllowed() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_スコープの説明(t *testing.T) {
+	r := newTestScopeRegistry(t)
+
+	tests := []struct {
+		scope    string
+		expected ScopeDescription
+	}{
+		{scope: "read", expected: ScopeDescription{Scope: "read", DisplayName: "参照", Description: "データの参照", Sensitivity: ScopeSensitivityLow}},
+		{scope: "admin", expected: ScopeDescription{Scope: "admin", DisplayName: "管理", Description: "全ての操作", Sensitivity: ScopeSensitivityHigh}},
+		{scope: "repo:42:read", expected: ScopeDescription{Scope: "repo:42:read", DisplayName: "リポジトリ42の参照", Description: "リポジトリ42の参照"}},
+		{scope: "unknown", expected: ScopeDescription{Scope: "unknown", DisplayName: "unknown", Description: "unknown"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.scope, func(t *testing.T) {
+			if actual := r.Describe(tt.scope); actual != tt.expected {
+				t.Errorf("Describe() = %+v, want %+v", actual, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_スコープの定義の検証(t *testing.T) {
+	tests := []struct {
+		name        string
+		definitions []ScopeDefinition
+	}{
+		{name: "重複したスコープ", definitions: []ScopeDefinition{{Name: "read"}, {Name: "read"}}},
+		{name: "不正なスコープ名", definitions: []ScopeDefinition{{Name: `re"ad`}}},
+		{name: "定義されていない含むスコープ", definitions: []ScopeDefinition{{Name: "write", Implies: []string{"read"}}}},
+		{name: "含むスコープが未知のパラメータを使う", definitions: []ScopeDefinition{{Name: "repo:{id}:read"}, {Name: "write", Implies: []string{"repo:{id}:read"}}}},
+		{name: "使われていないパラメータの形式", definitions: []ScopeDefinition{{Name: "repo:{id}:read", Parameters: map[string]string{"other": ".+"}}}},
+		{name: "不正なパラメータの形式", definitions: []ScopeDefinition{{Name: "repo:{id}:read", Parameters: map[string]string{"id": "("}}}},
+		{name: "重複したパラメータ", definitions: []ScopeDefinition{{Name: "{id}:{id}"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewScopeRegistry(tt.definitions); err == nil {
+				t.Error("NewScopeRegistry() error = nil, want error")
+			}
+		})
+	}
+}
+
+func Test_サポートするスコープの設定(t *testing.T) {
+	p := NewScopeProvider(nil)
+	if err := p.Current().Validate([]string{"read", "write"}); err != nil {
+		t.Fatalf("Validate() error = %v, want default scopes", err)
+	}
+
+	p.Replace(newTestScopeRegistry(t))
+	if err := p.Current().Validate([]string{"admin"}); err != nil {
+		t.Errorf("Validate(admin) error = %v", err)
+	}
+	// 他のレルムのスコープの一覧には影響しない
+	if err := NewScopeProvider(nil).Current().Validate([]string{"admin"}); !errors.Is(err, ErrUnknownScope) {
+		t.Errorf("Validate(admin) error = %v, want %v", err, ErrUnknownScope)
+	}
+
+	p.Replace(nil)
+	if err := p.Current().Validate([]string{"admin"}); !errors.Is(err, ErrUnknownScope) {
+		t.Errorf("Validate(admin) error = %v, want %v", err, ErrUnknownScope)
+	}
+}
@@ -1,6 +1,8 @@
 package domain
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"oauth-tutorial/pkg/mycrypto"
 	"time"
 )
@@ -11,22 +13,31 @@ type AccessToken struct {
 	userID    string
 	scopes    []string
 	expiresAt int64
+	// 発行の元になった認可コードの識別子。認可コードの再利用を検知した際に、同じ認可から発行したトークンを無効にするために使う
+	grantID string
 }
 
 type RefreshToken struct {
 	value     string
+	clientID  string
+	userID    string
+	scopes    []string
+	issuedAt  int64
 	expiresAt int64
+	// 発行の元になった認可コードの識別子。ローテーションしても引き継ぐ
+	grantID string
 }
 
+// 設定ファイルで指定しない場合の有効期間
 const (
 	AccessTokenDuration  = 24 * time.Hour      // Access token valid for 24 hours
 	RefreshTokenDuration = 60 * 24 * time.Hour // Refresh token valid for 60 days
 )
 
-func NewAccessToken(clientID, userID string, scopes []string, now time.Time) *AccessToken {
+func NewAccessToken(clientID, userID string, scopes []string, now time.Time, duration time.Duration) *AccessToken {
 	// TODO: generatorのinjectの仕方考える
 	g := mycrypto.RandomGenerator{}
-	expiresAt := now.Local().Add(AccessTokenDuration).Unix()
+	expiresAt := now.Local().Add(duration).Unix()
 	v := g.GenerateURLSafeRandomString(32)
 	return &AccessToken{
 		value:     v,
@@ -37,22 +48,86 @@ func NewAccessToken(clientID, userID string, scopes []string, now time.Time) *Ac
 	}
 }
 
+func ReconstructAccessToken(value, clientID, userID string, scopes []string, expiresAt int64, grantID string) *AccessToken {
+	return &AccessToken{
+		value:     value,
+		clientID:  clientID,
+		userID:    userID,
+		scopes:    scopes,
+		expiresAt: expiresAt,
+		grantID:   grantID,
+	}
+}
+
 func (t *AccessToken) Value() string    { return t.value }
 func (t *AccessToken) ClientID() string { return t.clientID }
 func (t *AccessToken) UserID() string   { return t.userID }
 func (t *AccessToken) Scopes() []string { return t.scopes }
 func (t *AccessToken) ExpiresAt() int64 { return t.expiresAt }
+func (t *AccessToken) GrantID() string  { return t.grantID }
+
+// 時刻nowからの残りの有効期間(秒)
+func (t *AccessToken) ExpiresIn(now time.Time) int64 {
+	return max(t.expiresAt-now.Unix(), 0)
+}
+
+// 発行の元になった認可の識別子を設定したトークンを返す
+func (t *AccessToken) IssuedFrom(grantID string) *AccessToken {
+	issued := *t
+	issued.grantID = grantID
+	return &issued
+}
 
-func NewRefreshToken(clientID string, now time.Time) *RefreshToken {
+// scopesにはアクセストークンを絞り込む前の、認可された全てのスコープを指定する
+func NewRefreshToken(clientID, userID string, scopes []string, now time.Time, duration time.Duration) *RefreshToken {
 	// TODO: generatorのinjectの仕方考える
 	g := mycrypto.RandomGenerator{}
-	expiresAt := now.Local().Add(RefreshTokenDuration).Unix()
+	expiresAt := now.Local().Add(duration).Unix()
 	v := g.GenerateURLSafeRandomString(32)
 	return &RefreshToken{
 		value:     v,
+		clientID:  clientID,
+		userID:    userID,
+		scopes:    scopes,
+		issuedAt:  now.Unix(),
+		expiresAt: expiresAt,
+	}
+}
+
+func ReconstructRefreshToken(value, clientID, userID string, scopes []string, issuedAt, expiresAt int64, grantID string) *RefreshToken {
+	return &RefreshToken{
+		value:     value,
+		clientID:  clientID,
+		userID:    userID,
+		scopes:    scopes,
+		issuedAt:  issuedAt,
 		expiresAt: expiresAt,
+		grantID:   grantID,
 	}
 }
 
 func (t *RefreshToken) Value() string    { return t.value }
+func (t *RefreshToken) ClientID() string { return t.clientID }
+func (t *RefreshToken) UserID() string   { return t.userID }
+func (t *RefreshToken) Scopes() []string { return t.scopes }
+func (t *RefreshToken) IssuedAt() int64  { return t.issuedAt }
 func (t *RefreshToken) ExpiresAt() int64 { return t.expiresAt }
+func (t *RefreshToken) GrantID() string  { return t.grantID }
+
+// 発行の元になった認可の識別子を設定したトークンを返す
+func (t *RefreshToken) IssuedFrom(grantID string) *RefreshToken {
+	issued := *t
+	issued.grantID = grantID
+	return &issued
+}
+
+func (t *RefreshToken) IsExpired(now time.Time) bool {
+	return now.Unix() > t.expiresAt
+}
+
+// 認可コードから発行したトークンに設定する、認可の識別子
+// 認可コードそのものを保存しないよう、ハッシュ値を使う
+func GrantIDFromCode(code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,197 @@
+package domain
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ワンタイムパスワードによるログインを表すamrの値(RFC 8176)。リカバリーコードでのログインも含む
+const AMROTP = "otp"
+
+// TOTPのパラメータ RFC 6238。認証アプリの多くが対応する既定値(HMAC-SHA1・6桁・30秒)を使う
+const (
+	TOTPDigits = 6
+	TOTPPeriod = 30 * time.Second
+	// 許容する時計のずれ(前後のステップ数)
+	TOTPSkew = 1
+	// シークレットのバイト数 RFC 4226 4. 160ビットを推奨
+	TOTPSecretBytes = 20
+	// 登録の確認時(最初のコードを確認した時点)に発行するリカバリーコードの数とバイト数(base32で8文字)
+	RecoveryCodeCount = 10
+	RecoveryCodeBytes = 5
+	// ユーザー毎に連続して失敗できる二要素目のコードの回数。認可リクエストをやり直しても数え直さない
+	// 上限に達するとTOTPLockoutDurationの間は確認せず、以降は失敗する毎に期間を倍に延ばす(最大TOTPMaxLockoutDuration)
+	TOTPMaxFailedAttempts  = 5
+	TOTPLockoutDuration    = time.Minute
+	TOTPMaxLockoutDuration = time.Hour
+)
+
+var base32NoPadding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// ユーザーのTOTPの登録
+// 認証アプリに登録した後、最初のコードを確認するまでは有効にしない
+type TOTPCredential struct {
+	userID string
+	secret []byte
+	// 最初のコードを確認した日時。ゼロ値の場合は確認待ち
+	confirmedAt time.Time
+	// 最後に使ったコードのステップ(Unix時刻/周期)。同じコードを再び使えないよう、これ以前のステップのコードは受け付けない
+	lastUsedStep int64
+	// 未使用のリカバリーコードのハッシュ値
+	recoveryCodeHashes []string
+	// 二要素目のコードの確認に連続して失敗した回数と、コードを確認しない期限
+	failedAttempts int
+	lockedUntil    time.Time
+}
+
+func NewTOTPCredential(userID string, secret []byte) *TOTPCredential {
+	return &TOTPCredential{userID: userID, secret: slices.Clone(secret)}
+}
+
+func ReconstructTOTPCredential(userID string, secret []byte, confirmedAt time.Time, lastUsedStep int64, recoveryCodeHashes []string, failedAttempts int, lockedUntil time.Time) *TOTPCredential {
+	return &TOTPCredential{
+		userID:             userID,
+		secret:             secret,
+		confirmedAt:        confirmedAt,
+		lastUsedStep:       lastUsedStep,
+		recoveryCodeHashes: recoveryCodeHashes,
+		failedAttempts:     failedAttempts,
+		lockedUntil:        lockedUntil,
+	}
+}
+
+func (c *TOTPCredential) UserID() string               { return c.userID }
+func (c *TOTPCredential) Secret() []byte               { return c.secret }
+func (c *TOTPCredential) ConfirmedAt() time.Time       { return c.confirmedAt }
+func (c *TOTPCredential) LastUsedStep() int64          { return c.lastUsedStep }
+func (c *TOTPCredential) RecoveryCodeHashes() []string { return c.recoveryCodeHashes }
+func (c *TOTPCredential) FailedAttempts() int          { return c.failedAttempts }
+func (c *TOTPCredential) LockedUntil() time.Time       { return c.lockedUntil }
+
+// 連続した失敗により、二要素目のコードを確認しない期間中かどうか
+func (c *TOTPCredential) LockedOut(now time.Time) bool { return now.Before(c.lockedUntil) }
+
+// 二要素目のコードの確認に失敗したことを記録する
+func (c *TOTPCredential) RecordFailure(now time.Time) *TOTPCredential {
+	failed := *c
+	failed.failedAttempts++
+	if lockout := TOTPLockout(failed.failedAttempts); lockout > 0 {
+		failed.lockedUntil = now.Add(lockout)
+	}
+	return &failed
+}
+
+// 連続して失敗した回数が1回増えてfailedAttemptsになった時点から、コードを確認しない期間
+// TOTPMaxFailedAttempts回に達するまでは0
+func TOTPLockout(failedAttempts int) time.Duration {
+	if failedAttempts < TOTPMaxFailedAttempts {
+		return 0
+	}
+	lockout := TOTPLockoutDuration
+	for range failedAttempts - TOTPMaxFailedAttempts {
+		lockout *= 2
+		if lockout >= TOTPMaxLockoutDuration {
+			return TOTPMaxLockoutDuration
+		}
+	}
+	return lockout
+}
+
+// 最初のコードを確認済みで、ログイン時にコードを求めるかどうか
+func (c *TOTPCredential) Enabled() bool { return !c.confirmedAt.IsZero() }
+
+// 認証アプリに登録するためのURI(QRコードにする内容)
+// https://github.com/google/google-authenticator/wiki/Key-Uri-Format
+func (c *TOTPCredential) ProvisioningURI(issuer string, accountName string) string {
+	query := url.Values{
+		"secret":    {c.EncodedSecret()},
+		"issuer":    {issuer},
+		"algorithm": {"SHA1"},
+		"digits":    {fmt.Sprint(TOTPDigits)},
+		"period":    {fmt.Sprint(int(TOTPPeriod / time.Second))},
+	}
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(accountName)
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+// 手入力で認証アプリに登録するためのシークレット(base32)
+func (c *TOTPCredential) EncodedSecret() string {
+	return base32NoPadding.EncodeToString(c.secret)
+}
+
+// codeが現在時刻の前後TOTPSkewステップのコードと一致し、かつ使用済みでなければそのステップを返す
+func (c *TOTPCredential) MatchStep(code string, now time.Time) (int64, bool) {
+	code = strings.TrimSpace(code)
+	if len(code) != TOTPDigits {
+		return 0, false
+	}
+	current := now.Unix() / int64(TOTPPeriod/time.Second)
+	for step := current - TOTPSkew; step <= current+TOTPSkew; step++ {
+		if step <= c.lastUsedStep {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(hotp(c.secret, uint64(step), TOTPDigits)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// 最初のコードを確認して有効にする。stepは確認に使ったコードのステップ
+func (c *TOTPCredential) Confirm(now time.Time, step int64, recoveryCodeHashes []string) *TOTPCredential {
+	return &TOTPCredential{
+		userID:             c.userID,
+		secret:             c.secret,
+		confirmedAt:        now,
+		lastUsedStep:       step,
+		recoveryCodeHashes: slices.Clone(recoveryCodeHashes),
+	}
+}
+
+// 未使用のリカバリーコードか
+func (c *TOTPCredential) HasRecoveryCode(code string) bool {
+	return slices.Contains(c.recoveryCodeHashes, HashRecoveryCode(code))
+}
+
+// ランダムなバイト列からリカバリーコードを作る(例: ABCD-EFGH)
+func NewRecoveryCode(random []byte) string {
+	encoded := base32NoPadding.EncodeToString(random)
+	return encoded[:len(encoded)/2] + "-" + encoded[len(encoded)/2:]
+}
+
+// リカバリーコードは十分な長さのランダムな値のため、SHA-256のハッシュ値で保存・照合する
+// 入力の揺れを許容するよう、区切りと空白を取り除き大文字にしてからハッシュ化する
+func HashRecoveryCode(code string) string {
+	normalized := strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(code))
+	digest := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(digest[:])
+}
+
+// HOTP RFC 4226 5.3
+func hotp(secret []byte, counter uint64, digits int) string {
+	mac := hmac.New(sha1.New, secret)
+	_ = binary.Write(mac, binary.BigEndian, counter)
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	modulo := uint32(1)
+	for range digits {
+		modulo *= 10
+	}
+	return fmt.Sprintf("%0*d", digits, value%modulo)
+}
+
+// 時刻atのTOTPのコード RFC 6238 4.2。認証アプリが表示するコードと同じ
+func TOTPCode(secret []byte, at time.Time) string {
+	return hotp(secret, uint64(at.Unix()/int64(TOTPPeriod/time.Second)), TOTPDigits)
+}
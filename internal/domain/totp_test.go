@@ -0,0 +1,136 @@
+package domain
+
+import (
+	"fmt"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// RFC 6238 Appendix B のテストベクトル(SHA-1)の下6桁
+func Test_TOTPのコードの計算(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	tests := []struct {
+		unix     int64
+		expected string
+	}{
+		{unix: 59, expected: "287082"},
+		{unix: 1111111109, expected: "081804"},
+		{unix: 1111111111, expected: "050471"},
+		{unix: 1234567890, expected: "005924"},
+		{unix: 2000000000, expected: "279037"},
+		{unix: 20000000000, expected: "353130"},
+	}
+	for _, tt := range tests {
+		if actual := TOTPCode(secret, time.Unix(tt.unix, 0)); actual != tt.expected {
+			t.Errorf("TOTPCode(%d) = %s, want %s", tt.unix, actual, tt.expected)
+		}
+	}
+}
+
+func Test_TOTPのコードの照合(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	now := time.Unix(1111111111, 0)
+	currentStep := now.Unix() / 30
+	tests := []struct {
+		name         string
+		lastUsedStep int64
+		code         string
+		expectedStep int64
+		expectedOK   bool
+	}{
+		{name: "正常系 - 現在のコード", code: TOTPCode(secret, now), expectedStep: currentStep, expectedOK: true},
+		{name: "正常系 - 1ステップ前のコード", code: TOTPCode(secret, now.Add(-30*time.Second)), expectedStep: currentStep - 1, expectedOK: true},
+		{name: "正常系 - 1ステップ後のコード", code: TOTPCode(secret, now.Add(30*time.Second)), expectedStep: currentStep + 1, expectedOK: true},
+		{name: "異常系 - 2ステップ前のコード", code: TOTPCode(secret, now.Add(-60*time.Second))},
+		{name: "異常系 - 使用済みのコード", lastUsedStep: currentStep, code: TOTPCode(secret, now)},
+		{name: "異常系 - 使用済みのステップより前のコード", lastUsedStep: currentStep, code: TOTPCode(secret, now.Add(-30*time.Second))},
+		{name: "正常系 - 使用済みのステップより後のコード", lastUsedStep: currentStep, code: TOTPCode(secret, now.Add(30*time.Second)), expectedStep: currentStep + 1, expectedOK: true},
+		{name: "異常系 - 桁数が異なる", code: "12345"},
+		{name: "異常系 - 異なるコード", code: "000000"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// given
+			credential := ReconstructTOTPCredential("user-1", secret, now, tt.lastUsedStep, nil, 0, time.Time{})
+
+			// when
+			step, ok := credential.MatchStep(tt.code, now)
+
+			// then
+			if ok != tt.expectedOK || step != tt.expectedStep {
+				t.Errorf("MatchStep() = %d, %v, want %d, %v", step, ok, tt.expectedStep, tt.expectedOK)
+			}
+		})
+	}
+}
+
+func Test_TOTPの登録(t *testing.T) {
+	// given
+	credential := NewTOTPCredential("user-1", []byte("12345678901234567890"))
+	if credential.Enabled() {
+		t.Fatal("Enabled() = true before confirmation")
+	}
+
+	// when
+	now := time.Unix(1111111111, 0)
+	code := NewRecoveryCode([]byte{0x01, 0x02, 0x03, 0x04, 0x05})
+	confirmed := credential.Confirm(now, 37037037, []string{HashRecoveryCode(code)})
+
+	// then
+	if !confirmed.Enabled() || confirmed.LastUsedStep() != 37037037 {
+		t.Errorf("Confirm() = %+v", confirmed)
+	}
+	if credential.Enabled() {
+		t.Error("Confirm() modified the original credential")
+	}
+	// 区切りや大文字・小文字の違いは許容する
+	if code != "AEBA-GBAF" || !confirmed.HasRecoveryCode("aebagbaf") || confirmed.HasRecoveryCode("AEBA-GBAG") {
+		t.Errorf("recovery code = %q", code)
+	}
+
+	uri, err := url.Parse(confirmed.ProvisioningURI("auth.example.com", "alice@example.com"))
+	if err != nil {
+		t.Fatalf("ProvisioningURI() error = %v", err)
+	}
+	query := uri.Query()
+	if uri.Scheme != "otpauth" || uri.Host != "totp" || uri.Path != "/auth.example.com:alice@example.com" ||
+		query.Get("secret") != "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ" || query.Get("issuer") != "auth.example.com" || query.Get("digits") != "6" || query.Get("period") != "30" {
+		t.Errorf("ProvisioningURI() = %s", uri)
+	}
+}
+
+func Test_TOTPの失敗による確認しない期間(t *testing.T) {
+	tests := []struct {
+		failedAttempts int
+		expected       time.Duration
+	}{
+		{failedAttempts: 1, expected: 0},
+		{failedAttempts: TOTPMaxFailedAttempts - 1, expected: 0},
+		{failedAttempts: TOTPMaxFailedAttempts, expected: TOTPLockoutDuration},
+		{failedAttempts: TOTPMaxFailedAttempts + 1, expected: 2 * TOTPLockoutDuration},
+		{failedAttempts: TOTPMaxFailedAttempts + 2, expected: 4 * TOTPLockoutDuration},
+		{failedAttempts: TOTPMaxFailedAttempts + 100, expected: TOTPMaxLockoutDuration},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%d回", tt.failedAttempts), func(t *testing.T) {
+			if actual := TOTPLockout(tt.failedAttempts); actual != tt.expected {
+				t.Errorf("TOTPLockout(%d) = %v, want %v", tt.failedAttempts, actual, tt.expected)
+			}
+		})
+	}
+
+	// given
+	now := time.Unix(1111111111, 0)
+	credential := NewTOTPCredential("user-1", []byte("12345678901234567890")).Confirm(now, 0, nil)
+
+	// when
+	for range TOTPMaxFailedAttempts {
+		credential = credential.RecordFailure(now)
+	}
+
+	// then
+	if credential.FailedAttempts() != TOTPMaxFailedAttempts || !credential.LockedOut(now) || credential.LockedOut(now.Add(TOTPLockoutDuration)) {
+		t.Errorf("RecordFailure() = (%d, %v)", credential.FailedAttempts(), credential.LockedUntil())
+	}
+}
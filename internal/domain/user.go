@@ -4,19 +4,41 @@ package domain
 // Standard Claims: https://openid.net/specs/openid-connect-core-1_0.html
 // とりあえずは簡易的に実装
 type User struct {
-	userID   string
-	loginID  string
-	password string
+	userID  string
+	loginID string
+	// パスワードのハッシュ値(mycrypto.PasswordHasherの形式)。平文のパスワードは保持しない
+	passwordHash string
+	// 上流のIdPでログインするユーザーの場合のみ設定する
+	federatedIdentity *FederatedIdentity
 }
 
-func ReconstructUser(userID, loginID, password string) *User {
+func ReconstructUser(userID, loginID, passwordHash string) *User {
 	return &User{
-		userID:   userID,
-		loginID:  loginID,
-		password: password,
+		userID:       userID,
+		loginID:      loginID,
+		passwordHash: passwordHash,
 	}
 }
 
-func (u *User) UserID() string   { return u.userID }
-func (u *User) LoginID() string  { return u.loginID }
-func (u *User) Password() string { return u.password }
+// 上流のIdPでログインするユーザー。パスワードを持たないため、パスワードではログインできない
+func NewFederatedUser(userID, loginID string, identity FederatedIdentity) *User {
+	return &User{
+		userID:            userID,
+		loginID:           loginID,
+		federatedIdentity: &identity,
+	}
+}
+
+func (u *User) UserID() string       { return u.userID }
+func (u *User) LoginID() string      { return u.loginID }
+func (u *User) PasswordHash() string { return u.passwordHash }
+
+// パスワードのハッシュ値を置き換えたユーザー。ハッシュ化のパラメータを変更した際の再ハッシュに使う
+func (u *User) WithPasswordHash(passwordHash string) *User {
+	copied := *u
+	copied.passwordHash = passwordHash
+	return &copied
+}
+
+// 上流のIdPのユーザーでない場合はnil
+func (u *User) FederatedIdentity() *FederatedIdentity { return u.federatedIdentity }
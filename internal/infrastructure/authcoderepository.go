@@ -4,27 +4,35 @@ import (
 	"errors"
 	"oauth-tutorial/internal/domain"
 	"sync"
+	"time"
 )
 
 var (
-	ErrAuthorizationCodeNotFound = errors.New("authorization code not found")
+	ErrAuthorizationCodeNotFound    = errors.New("authorization code not found")
+	ErrAuthorizationCodeAlreadyUsed = errors.New("authorization code already used")
 )
 
 type AuthCodeRepository struct {
 	authCodeStore map[string]*domain.AuthorizationCode
-	mu            sync.RWMutex
+	// 再利用を検知するため、使用済みの認可コードを有効期限まで保持する
+	usedCodes map[string]*domain.AuthorizationCode
+	mu        sync.RWMutex
 }
 
+var _ AuthCodeStore = (*AuthCodeRepository)(nil)
+
 func NewAuthCodeRepository() *AuthCodeRepository {
 	return &AuthCodeRepository{
 		authCodeStore: make(map[string]*domain.AuthorizationCode),
+		usedCodes:     make(map[string]*domain.AuthorizationCode),
 	}
 }
 
-func (r *AuthCodeRepository) Save(code *domain.AuthorizationCode) {
+func (r *AuthCodeRepository) Save(code *domain.AuthorizationCode) error {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	r.authCodeStore[code.Value()] = code
+	return nil
 }
 
 func (r *AuthCodeRepository) FindByCode(code string) (*domain.AuthorizationCode, error) {
@@ -37,8 +45,88 @@ func (r *AuthCodeRepository) FindByCode(code string) (*domain.AuthorizationCode,
 	return v, nil
 }
 
-func (r *AuthCodeRepository) Delete(code string) {
+func (r *AuthCodeRepository) Delete(code string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.authCodeStore, code)
+	delete(r.usedCodes, code)
+	return nil
+}
+
+// 認可コードを取得すると同時に使用済みにする
+func (r *AuthCodeRepository) Consume(code string) (*domain.AuthorizationCode, error) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
+	if _, used := r.usedCodes[code]; used {
+		return nil, ErrAuthorizationCodeAlreadyUsed
+	}
+	v, ok := r.authCodeStore[code]
+	if !ok {
+		return nil, ErrAuthorizationCodeNotFound
+	}
 	delete(r.authCodeStore, code)
+	r.usedCodes[code] = v
+	return v, nil
+}
+
+func (r *AuthCodeRepository) DeleteByUserAndClient(userID string, clientID string) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	deleted := 0
+	for value, code := range r.authCodeStore {
+		if code.UserID() == userID && code.ClientID() == clientID {
+			delete(r.authCodeStore, value)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// 使用済みのものを含め、有効期限切れの認可コードを最大limit件削除し、削除した数を返す
+func (r *AuthCodeRepository) PurgeExpired(now time.Time, limit int) (int, error) {
+	expired := func(code *domain.AuthorizationCode) bool { return code.IsExpired(now) }
+	return purgeExpiredKeys(limit, func(limit int) (int, int) {
+		// 全体の走査は読み取りロックで行い、書き込みロックは集めたキーの削除の間だけ取得する
+		r.mu.RLock()
+		unused := collectExpiredKeys(r.authCodeStore, limit, expired)
+		used := collectExpiredKeys(r.usedCodes, limit-len(unused), expired)
+		r.mu.RUnlock()
+
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		return len(unused) + len(used), deleteExpiredKeys(r.authCodeStore, unused, expired) + deleteExpiredKeys(r.usedCodes, used, expired)
+	}), nil
+}
+
+// スナップショットのために全ての認可コードを書き出す
+func (r *AuthCodeRepository) Export() []AuthCodeRecord {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	records := make([]AuthCodeRecord, 0, len(r.authCodeStore)+len(r.usedCodes))
+	for _, code := range r.authCodeStore {
+		records = append(records, newAuthCodeRecord(code, false))
+	}
+	for _, code := range r.usedCodes {
+		records = append(records, newAuthCodeRecord(code, true))
+	}
+	return records
+}
+
+// スナップショットから書き出した認可コードを取り込む。有効期限切れのものは取り込まず、その数を返す
+func (r *AuthCodeRepository) Import(records []AuthCodeRecord, now time.Time) (skipped int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, record := range records {
+		code := record.reconstruct()
+		if code.IsExpired(now) {
+			skipped++
+			continue
+		}
+		if record.Used {
+			r.usedCodes[code.Value()] = code
+			continue
+		}
+		r.authCodeStore[code.Value()] = code
+	}
+	return skipped
 }
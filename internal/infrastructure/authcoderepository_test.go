@@ -36,7 +36,7 @@ func Test_認可コードの保存(t *testing.T) {
 	repo := NewAuthCodeRepository()
 
 	// テスト用の認可コードを作成
-	authCode := domain.NewAuthorizationCode(&MockRandomGenerator{}, "test-user-id", "test-client-id", []string{"read"}, "https://example.com/callback", time.Now())
+	authCode := domain.NewAuthorizationCode(&MockRandomGenerator{}, "test-user-id", "test-client-id", []string{"read"}, "https://example.com/callback", time.Now(), domain.AUTHORIZATION_CODE_DURATION)
 
 	originalLength := len(repo.authCodeStore)
 
@@ -58,7 +58,7 @@ func Test_認可コードの検索(t *testing.T) {
 	repo := NewAuthCodeRepository()
 
 	// テスト用の認可コードを作成・保存
-	expectedAuthCode := domain.NewAuthorizationCode(&MockRandomGenerator{}, "test-user", "test-client", []string{"read"}, "https://example.com/callback", time.Now())
+	expectedAuthCode := domain.NewAuthorizationCode(&MockRandomGenerator{}, "test-user", "test-client", []string{"read"}, "https://example.com/callback", time.Now(), domain.AUTHORIZATION_CODE_DURATION)
 	repo.Save(expectedAuthCode)
 
 	tests := []struct {
@@ -150,7 +150,7 @@ func TestAuthCodeRepository_Delete(t *testing.T) {
 	repo := NewAuthCodeRepository()
 
 	// テスト用の認可コードを作成・保存
-	authCode := domain.NewAuthorizationCode(&MockRandomGenerator{}, "test-user", "test-client", []string{"read"}, "https://example.com/callback", time.Now())
+	authCode := domain.NewAuthorizationCode(&MockRandomGenerator{}, "test-user", "test-client", []string{"read"}, "https://example.com/callback", time.Now(), domain.AUTHORIZATION_CODE_DURATION)
 	repo.Save(authCode)
 
 	// 削除前の確認
@@ -187,6 +187,7 @@ func TestAuthCodeRepository_ConcurrentAccess(t *testing.T) {
 				[]string{"read"},
 				"https://example.com/callback",
 				time.Now(),
+				domain.AUTHORIZATION_CODE_DURATION,
 			)
 			repo.Save(authCode)
 		}(i)
@@ -0,0 +1,60 @@
+package infrastructure
+
+import (
+	"errors"
+	"oauth-tutorial/internal/domain"
+	"oauth-tutorial/pkg/mycrypto"
+	"oauth-tutorial/pkg/mylogger"
+)
+
+// 永続化の実装に登録したユーザーのログインIDとパスワードで認証する
+// 現在と異なるアルゴリズムやパラメータでハッシュ化したパスワードは、ログインに成功した際にハッシュ値を置き換える
+type UserStoreAuthenticator struct {
+	logger mylogger.Logger
+	users  UserStore
+	hasher *mycrypto.PasswordHasher
+}
+
+func NewUserStoreAuthenticator(logger mylogger.Logger, users UserStore, hasher *mycrypto.PasswordHasher) *UserStoreAuthenticator {
+	return &UserStoreAuthenticator{logger: logger, users: users, hasher: hasher}
+}
+
+func (a *UserStoreAuthenticator) Authenticate(loginID, password string) (domain.AuthenticationResult, error) {
+	user, err := a.users.FindByLoginID(loginID)
+	if errors.Is(err, ErrUserNotFound) {
+		// 応答時間からログインIDの有無を推測できないよう、存在する場合と同じ計算を行う
+		a.hasher.DummyVerify(password)
+		return domain.NewAuthenticationFailure(domain.AuthenticationFailureUnknownUser), nil
+	}
+	if err != nil {
+		return domain.AuthenticationResult{}, err
+	}
+	// 上流のIdPのユーザーはパスワードを持たない
+	if user.PasswordHash() == "" {
+		a.hasher.DummyVerify(password)
+		return domain.NewAuthenticationFailure(domain.AuthenticationFailurePasswordNotSet), nil
+	}
+
+	match, needsRehash, err := a.hasher.Verify(user.PasswordHash(), password)
+	if err != nil {
+		return domain.AuthenticationResult{}, err
+	}
+	if !match {
+		return domain.NewAuthenticationFailure(domain.AuthenticationFailureInvalidPassword), nil
+	}
+	if needsRehash {
+		user = a.rehash(user, password)
+	}
+	return domain.NewAuthenticationSuccess(user, []string{domain.AMRPassword}), nil
+}
+
+// 現在のパラメータでハッシュ化し直して保存する。保存に失敗してもログインは続ける
+func (a *UserStoreAuthenticator) rehash(user *domain.User, password string) *domain.User {
+	rehashed := user.WithPasswordHash(a.hasher.Hash(password))
+	if err := a.users.Save(rehashed); err != nil {
+		a.logger.Error("Failed to save rehashed password", "userID", user.UserID(), "err", err)
+		return user
+	}
+	a.logger.Info("Rehashed password with current parameters", "userID", user.UserID())
+	return rehashed
+}
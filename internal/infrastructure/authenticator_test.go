@@ -0,0 +1,98 @@
+package infrastructure
+
+import (
+	"oauth-tutorial/internal/domain"
+	"oauth-tutorial/pkg/mycrypto"
+	"oauth-tutorial/pkg/mylogger"
+	"strings"
+	"testing"
+)
+
+// テストを速くするため、メモリ使用量と繰り返し回数を小さくする
+var (
+	testOldParams = mycrypto.Argon2Params{Memory: 32, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+	testNewParams = mycrypto.Argon2Params{Memory: 64, Iterations: 2, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+)
+
+// "password"をコスト4でハッシュ化したbcryptのハッシュ値
+const testBcryptHash = "$2a$04$goHuQuoKbk7TrAlleF74vO2rvQkL2li9pmuPFKvxb5Fc4zl8MlBkS"
+
+func Test_ユーザーのパスワードによる認証(t *testing.T) {
+	identity, _ := domain.NewFederatedIdentity("https://idp.example.com", "alice")
+	tests := []struct {
+		name           string
+		loginID        string
+		password       string
+		expectedReason domain.AuthenticationFailureReason
+	}{
+		{name: "正常系", loginID: "user@example.com", password: "password"},
+		{name: "異常系 - パスワードが異なる", loginID: "user@example.com", password: "wrong", expectedReason: domain.AuthenticationFailureInvalidPassword},
+		{name: "異常系 - ログインIDが存在しない", loginID: "unknown@example.com", password: "password", expectedReason: domain.AuthenticationFailureUnknownUser},
+		{name: "異常系 - 上流のIdPのユーザー", loginID: "corp:alice@example.com", password: "", expectedReason: domain.AuthenticationFailurePasswordNotSet},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// given
+			hasher := mycrypto.NewPasswordHasher(testNewParams)
+			users := NewUserRepositoryWithUsers([]*domain.User{
+				domain.ReconstructUser("user-1", "user@example.com", hasher.Hash("password")),
+				domain.NewFederatedUser("user-2", "corp:alice@example.com", identity),
+			})
+			a := NewUserStoreAuthenticator(mylogger.NewMockLogger(), users, hasher)
+
+			// when
+			result, err := a.Authenticate(tt.loginID, tt.password)
+
+			// then
+			if err != nil {
+				t.Fatalf("Authenticate() error = %v", err)
+			}
+			if result.Succeeded() != (tt.expectedReason == "") || result.FailureReason() != tt.expectedReason {
+				t.Errorf("Authenticate() = %+v, want reason %q", result, tt.expectedReason)
+			}
+		})
+	}
+}
+
+func Test_ログイン時のパスワードの再ハッシュ(t *testing.T) {
+	tests := []struct {
+		name         string
+		storedHash   string
+		password     string
+		expectRehash bool
+	}{
+		{name: "パラメータを変更した場合は置き換える", storedHash: mycrypto.NewPasswordHasher(testOldParams).Hash("password"), password: "password", expectRehash: true},
+		{name: "bcryptは置き換える", storedHash: testBcryptHash, password: "password", expectRehash: true},
+		{name: "現在のパラメータの場合は置き換えない", storedHash: mycrypto.NewPasswordHasher(testNewParams).Hash("password"), password: "password"},
+		{name: "ログインに失敗した場合は置き換えない", storedHash: testBcryptHash, password: "wrong"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// given
+			users := NewUserRepositoryWithUsers([]*domain.User{domain.ReconstructUser("user-1", "user@example.com", tt.storedHash)})
+			a := NewUserStoreAuthenticator(mylogger.NewMockLogger(), users, mycrypto.NewPasswordHasher(testNewParams))
+
+			// when
+			_, err := a.Authenticate("user@example.com", tt.password)
+
+			// then
+			if err != nil {
+				t.Fatalf("Authenticate() error = %v", err)
+			}
+			stored, _ := users.FindByLoginID("user@example.com")
+			if rehashed := stored.PasswordHash() != tt.storedHash; rehashed != tt.expectRehash {
+				t.Fatalf("rehashed = %v, want %v", rehashed, tt.expectRehash)
+			}
+			if !tt.expectRehash {
+				return
+			}
+			if !strings.HasPrefix(stored.PasswordHash(), "$argon2id$v=19$m=64,t=2,p=1$") {
+				t.Errorf("PasswordHash() = %q", stored.PasswordHash())
+			}
+			// 置き換えた後も同じパスワードでログインできる
+			if result, _ := a.Authenticate("user@example.com", tt.password); !result.Succeeded() {
+				t.Errorf("Authenticate() after rehash = %+v", result)
+			}
+		})
+	}
+}
@@ -3,25 +3,89 @@ package infrastructure
 import (
 	"errors"
 	"oauth-tutorial/internal/domain"
+	"sync"
 )
 
 type ClientRepository struct {
 	clients map[domain.ClientID]*domain.Client
+	mu      sync.RWMutex
 }
 
 var ErrClientNotFound = errors.New("client not found")
 
+var _ ClientStore = (*ClientRepository)(nil)
+
+// 起動時に登録するクライアント
+func DefaultClients() []*domain.Client {
+	return []*domain.Client{
+		domain.ReconstructClient(domain.ClientID("iouobrnea"), "client-1", domain.ConfidentialClient, "password", []string{"https://client.example.com/callback"}),
+	}
+}
+
 func NewClientRepository() *ClientRepository {
-	clients := map[domain.ClientID]*domain.Client{
-		"iouobrnea": domain.ReconstructClient(domain.ClientID("iouobrnea"), "client-1", domain.ConfidentialClient, "password", []string{"https://client.example.com/callback"}),
+	return NewClientRepositoryWithClients(DefaultClients())
+}
+
+// 起動時に登録するクライアントを指定して構築する
+func NewClientRepositoryWithClients(seed []*domain.Client) *ClientRepository {
+	clients := make(map[domain.ClientID]*domain.Client)
+	for _, client := range seed {
+		clients[client.ClientID()] = client
 	}
 	return &ClientRepository{clients: clients}
 }
 
+func (r *ClientRepository) Save(client *domain.Client) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.clients[client.ClientID()] = client
+	return nil
+}
+
+// 新しいマップを構築してから差し替えるため、検索は置き換えの前後どちらかの状態を参照する
+func (r *ClientRepository) ReplaceAll(clients []*domain.Client) error {
+	replaced := make(map[domain.ClientID]*domain.Client, len(clients))
+	for _, client := range clients {
+		replaced[client.ClientID()] = client
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.clients = replaced
+	return nil
+}
+
 func (r *ClientRepository) SelectByClientID(clientID domain.ClientID) (*domain.Client, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
 	client, ok := r.clients[clientID]
 	if !ok {
 		return nil, ErrClientNotFound
 	}
 	return client, nil
 }
+
+// トークンエンドポイントではclient_idを文字列で受け取るため、文字列のIDで検索する
+func (r *ClientRepository) FindByID(clientID string) (*domain.Client, error) {
+	return r.SelectByClientID(domain.ClientID(clientID))
+}
+
+// スナップショットのために全てのクライアントを書き出す
+func (r *ClientRepository) Export() []ClientRecord {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	records := make([]ClientRecord, 0, len(r.clients))
+	for _, client := range r.clients {
+		records = append(records, newClientRecord(client))
+	}
+	return records
+}
+
+// スナップショットから書き出したクライアントを取り込む。同じclient_idのクライアントは上書きする
+func (r *ClientRepository) Import(records []ClientRecord) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, record := range records {
+		client := record.reconstruct()
+		r.clients[client.ClientID()] = client
+	}
+}
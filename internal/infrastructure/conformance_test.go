@@ -0,0 +1,25 @@
+package infrastructure_test
+
+import (
+	"oauth-tutorial/internal/infrastructure"
+	"oauth-tutorial/internal/infrastructure/storagetest"
+	"testing"
+	"time"
+)
+
+func Test_インメモリの実装の適合性(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) *storagetest.Backend {
+		return &storagetest.Backend{
+			Clients:   infrastructure.NewClientRepository(),
+			Users:     infrastructure.NewUserRepository(),
+			AuthCodes: infrastructure.NewAuthCodeRepository(),
+			Tokens:    infrastructure.NewTokenRespository(),
+			Consents:  infrastructure.NewConsentRepository(),
+			TOTP:      infrastructure.NewTOTPRepository(),
+			Passkeys:  infrastructure.NewPasskeyRepository(),
+			NewSessionStore: func(idleTimeout time.Duration, absoluteTimeout time.Duration, now func() time.Time) infrastructure.SessionStore {
+				return infrastructure.NewSessionStorageWithClock(idleTimeout, absoluteTimeout, now)
+			},
+		}
+	})
+}
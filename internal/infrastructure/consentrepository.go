@@ -0,0 +1,112 @@
+package infrastructure
+
+import (
+	"errors"
+	"oauth-tutorial/internal/domain"
+	"slices"
+	"sync"
+	"time"
+)
+
+var ErrConsentNotFound = errors.New("consent not found")
+
+type consentKey struct {
+	userID   string
+	clientID string
+}
+
+type ConsentRepository struct {
+	store map[consentKey]*domain.Consent
+	mu    sync.RWMutex
+}
+
+var _ ConsentStore = (*ConsentRepository)(nil)
+
+func NewConsentRepository() *ConsentRepository {
+	return &ConsentRepository{
+		store: make(map[consentKey]*domain.Consent),
+	}
+}
+
+func (r *ConsentRepository) Save(consent *domain.Consent) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.store[consentKey{userID: consent.UserID(), clientID: consent.ClientID()}] = consent
+	return nil
+}
+
+func (r *ConsentRepository) FindByUserAndClient(userID string, clientID string) (*domain.Consent, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	consent, ok := r.store[consentKey{userID: userID, clientID: clientID}]
+	if !ok {
+		return nil, ErrConsentNotFound
+	}
+	return consent, nil
+}
+
+// ユーザーの全ての同意を同意した日時の順に返す
+func (r *ConsentRepository) FindByUserID(userID string) ([]*domain.Consent, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	consents := make([]*domain.Consent, 0)
+	for key, consent := range r.store {
+		if key.userID == userID {
+			consents = append(consents, consent)
+		}
+	}
+	slices.SortFunc(consents, func(a, b *domain.Consent) int {
+		return a.GrantedAt().Compare(b.GrantedAt())
+	})
+	return consents, nil
+}
+
+func (r *ConsentRepository) Delete(userID string, clientID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.store, consentKey{userID: userID, clientID: clientID})
+	return nil
+}
+
+// 有効期限切れの同意を最大limit件削除し、削除した数を返す
+func (r *ConsentRepository) PurgeExpired(now time.Time, limit int) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	purged := 0
+	for key, consent := range r.store {
+		if purged >= limit {
+			break
+		}
+		if consent.IsExpired(now) {
+			delete(r.store, key)
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+// スナップショットのために全ての同意を書き出す
+func (r *ConsentRepository) Export() []ConsentRecord {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	records := make([]ConsentRecord, 0, len(r.store))
+	for _, consent := range r.store {
+		records = append(records, newConsentRecord(consent))
+	}
+	return records
+}
+
+// スナップショットから書き出した同意を取り込む。有効期限切れのものは取り込まず、その数を返す
+func (r *ConsentRepository) Import(records []ConsentRecord, now time.Time) (skipped int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, record := range records {
+		consent := record.reconstruct()
+		if consent.IsExpired(now) {
+			skipped++
+			continue
+		}
+		r.store[consentKey{userID: consent.UserID(), clientID: consent.ClientID()}] = consent
+	}
+	return skipped
+}
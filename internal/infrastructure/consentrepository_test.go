@@ -0,0 +1,79 @@
+package infrastructure
+
+import (
+	"oauth-tutorial/internal/domain"
+	"testing"
+	"time"
+)
+
+func Test_同意の保存と検索(t *testing.T) {
+	repo := NewConsentRepository()
+	consent := domain.NewConsent("user-1", "client-1", []string{"read"}, time.Now(), 0)
+	repo.Save(consent)
+
+	tests := []struct {
+		name        string
+		userID      string
+		clientID    string
+		expectedErr error
+	}{
+		{name: "存在する同意を検索", userID: "user-1", clientID: "client-1", expectedErr: nil},
+		{name: "別のクライアント", userID: "user-1", clientID: "client-2", expectedErr: ErrConsentNotFound},
+		{name: "別のユーザー", userID: "user-2", clientID: "client-1", expectedErr: ErrConsentNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actual, err := repo.FindByUserAndClient(tt.userID, tt.clientID)
+			if err != tt.expectedErr {
+				t.Fatalf("FindByUserAndClient() error = %v, want %v", err, tt.expectedErr)
+			}
+			if tt.expectedErr == nil && actual != consent {
+				t.Errorf("FindByUserAndClient() = %v, want %v", actual, consent)
+			}
+		})
+	}
+}
+
+func Test_同意の上書きと削除(t *testing.T) {
+	repo := NewConsentRepository()
+	now := time.Now()
+	consent := domain.NewConsent("user-1", "client-1", []string{"read"}, now, 0)
+	repo.Save(consent)
+
+	// 同じユーザーとクライアントの組は上書きされること
+	repo.Save(consent.Grant([]string{"write"}, now, 0))
+	actual, err := repo.FindByUserAndClient("user-1", "client-1")
+	if err != nil {
+		t.Fatalf("FindByUserAndClient() error = %v", err)
+	}
+	if !actual.Covers(domain.DefaultScopeRegistry(), []string{"read", "write"}) {
+		t.Errorf("Scopes() = %v, want read and write", actual.Scopes())
+	}
+	if len(repo.store) != 1 {
+		t.Errorf("store length = %d, want 1", len(repo.store))
+	}
+
+	repo.Delete("user-1", "client-1")
+	if _, err := repo.FindByUserAndClient("user-1", "client-1"); err != ErrConsentNotFound {
+		t.Errorf("FindByUserAndClient() after Delete error = %v, want %v", err, ErrConsentNotFound)
+	}
+}
+
+func Test_ユーザーの同意一覧(t *testing.T) {
+	repo := NewConsentRepository()
+	now := time.Now()
+	second := domain.NewConsent("user-1", "client-2", []string{"read"}, now, 0)
+	first := domain.NewConsent("user-1", "client-1", []string{"read"}, now.Add(-time.Hour), 0)
+	repo.Save(second)
+	repo.Save(first)
+	repo.Save(domain.NewConsent("user-2", "client-1", []string{"read"}, now, 0))
+
+	actual, _ := repo.FindByUserID("user-1")
+	if len(actual) != 2 || actual[0] != first || actual[1] != second {
+		t.Errorf("FindByUserID() = %v, want [%v %v]", actual, first, second)
+	}
+	if actual, _ := repo.FindByUserID("user-3"); len(actual) != 0 {
+		t.Errorf("FindByUserID() = %v, want empty", actual)
+	}
+}
@@ -0,0 +1,85 @@
+package dto
+
+import (
+	"oauth-tutorial/internal/domain"
+	"oauth-tutorial/internal/session"
+	"time"
+)
+
+const (
+	AUTHORIZATION_TRANSACTION_DURATION = 10 * time.Minute
+)
+
+// 認可リクエスト1件分の短命なトランザクション
+// 発行元のブラウザセッションに紐づけ、別のブラウザから利用できないようにする
+type AuthorizationTransaction struct {
+	id        session.TransactionID
+	sessionID session.SessionID
+	authParam *domain.AuthorizationCodeFlowParam
+	expiresAt time.Time
+	// パスワードの確認を終え、二要素目(TOTP)の確認を待っているログイン。待っていない場合はnil
+	pendingLogin *PendingLogin
+}
+
+// 二要素目の確認を待っているログイン
+type PendingLogin struct {
+	user *domain.User
+	// パスワードの確認で得たamr
+	amr []string
+	// 二要素目の確認に失敗した回数
+	failedAttempts int
+}
+
+func NewPendingLogin(user *domain.User, amr []string) *PendingLogin {
+	return &PendingLogin{user: user, amr: amr}
+}
+
+func (p *PendingLogin) User() *domain.User  { return p.user }
+func (p *PendingLogin) AMR() []string       { return p.amr }
+func (p *PendingLogin) FailedAttempts() int { return p.failedAttempts }
+
+// 確認に失敗した回数を1増やしたログイン
+func (p *PendingLogin) RecordFailure() *PendingLogin {
+	return &PendingLogin{user: p.user, amr: p.amr, failedAttempts: p.failedAttempts + 1}
+}
+
+func NewAuthorizationTransaction(id session.TransactionID, sessionID session.SessionID, authParam *domain.AuthorizationCodeFlowParam, now time.Time) *AuthorizationTransaction {
+	return &AuthorizationTransaction{
+		id:        id,
+		sessionID: sessionID,
+		authParam: authParam,
+		expiresAt: now.Add(AUTHORIZATION_TRANSACTION_DURATION),
+	}
+}
+
+func (t *AuthorizationTransaction) ID() session.TransactionID                     { return t.id }
+func (t *AuthorizationTransaction) SessionID() session.SessionID                  { return t.sessionID }
+func (t *AuthorizationTransaction) AuthParam() *domain.AuthorizationCodeFlowParam { return t.authParam }
+func (t *AuthorizationTransaction) ExpiresAt() time.Time                          { return t.expiresAt }
+func (t *AuthorizationTransaction) PendingLogin() *PendingLogin                   { return t.pendingLogin }
+
+func (t *AuthorizationTransaction) IsExpired(now time.Time) bool {
+	return now.After(t.expiresAt)
+}
+
+// セッションIDを再生成した際に、トランザクションを新しいセッションに紐づけ直す
+func (t *AuthorizationTransaction) BindTo(sessionID session.SessionID) *AuthorizationTransaction {
+	return &AuthorizationTransaction{
+		id:           t.id,
+		sessionID:    sessionID,
+		authParam:    t.authParam,
+		expiresAt:    t.expiresAt,
+		pendingLogin: t.pendingLogin,
+	}
+}
+
+// 二要素目の確認を待っているログインを設定したトランザクション。nilの場合は待ち状態を解除する
+func (t *AuthorizationTransaction) WithPendingLogin(pendingLogin *PendingLogin) *AuthorizationTransaction {
+	return &AuthorizationTransaction{
+		id:           t.id,
+		sessionID:    t.sessionID,
+		authParam:    t.authParam,
+		expiresAt:    t.expiresAt,
+		pendingLogin: pendingLogin,
+	}
+}
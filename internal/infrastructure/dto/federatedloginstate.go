@@ -0,0 +1,47 @@
+package dto
+
+import (
+	"oauth-tutorial/internal/session"
+	"time"
+)
+
+const (
+	FEDERATED_LOGIN_STATE_DURATION = 10 * time.Minute
+)
+
+// 上流のIdPへの認可リクエスト1件分の状態。IdPからのコールバックでstateから取得する
+// 発行元のブラウザセッションに紐づけ、別のブラウザでコールバックを受け付けないようにする
+type FederatedLoginState struct {
+	state         string
+	provider      string
+	transactionID session.TransactionID
+	sessionID     session.SessionID
+	nonce         string
+	// PKCEのコード検証器 RFC 7636
+	codeVerifier string
+	expiresAt    time.Time
+}
+
+func NewFederatedLoginState(state, provider string, transactionID session.TransactionID, sessionID session.SessionID, nonce, codeVerifier string, now time.Time) *FederatedLoginState {
+	return &FederatedLoginState{
+		state:         state,
+		provider:      provider,
+		transactionID: transactionID,
+		sessionID:     sessionID,
+		nonce:         nonce,
+		codeVerifier:  codeVerifier,
+		expiresAt:     now.Add(FEDERATED_LOGIN_STATE_DURATION),
+	}
+}
+
+func (s *FederatedLoginState) State() string                        { return s.state }
+func (s *FederatedLoginState) Provider() string                     { return s.provider }
+func (s *FederatedLoginState) TransactionID() session.TransactionID { return s.transactionID }
+func (s *FederatedLoginState) SessionID() session.SessionID         { return s.sessionID }
+func (s *FederatedLoginState) Nonce() string                        { return s.nonce }
+func (s *FederatedLoginState) CodeVerifier() string                 { return s.codeVerifier }
+func (s *FederatedLoginState) ExpiresAt() time.Time                 { return s.expiresAt }
+
+func (s *FederatedLoginState) IsExpired(now time.Time) bool {
+	return now.After(s.expiresAt)
+}
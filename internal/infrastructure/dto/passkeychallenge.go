@@ -0,0 +1,51 @@
+package dto
+
+import (
+	"oauth-tutorial/internal/session"
+	"time"
+)
+
+const (
+	PASSKEY_CHALLENGE_DURATION = 5 * time.Minute
+)
+
+// パスキーの登録・ログインのどちらで発行したチャレンジか
+type PasskeyCeremony string
+
+const (
+	PasskeyRegistration PasskeyCeremony = "registration"
+	PasskeyLogin        PasskeyCeremony = "login"
+)
+
+// パスキーの登録・ログインで発行したチャレンジ1件分の状態。ブラウザから受け取ったclientDataJSONのチャレンジから取得する
+// 発行元のブラウザセッションに紐づけ、別のブラウザで発行したチャレンジの署名を受け付けないようにする
+type PasskeyChallenge struct {
+	// base64urlでエンコードしたチャレンジ
+	challenge string
+	ceremony  PasskeyCeremony
+	sessionID session.SessionID
+	// ログインの場合のみ設定する。ログインした後に処理を続ける認可リクエスト
+	transactionID session.TransactionID
+	// 登録の場合のみ設定する。パスキーを登録するユーザー
+	userID    string
+	expiresAt time.Time
+}
+
+func NewPasskeyRegistrationChallenge(challenge string, sessionID session.SessionID, userID string, now time.Time) *PasskeyChallenge {
+	return &PasskeyChallenge{challenge: challenge, ceremony: PasskeyRegistration, sessionID: sessionID, userID: userID, expiresAt: now.Add(PASSKEY_CHALLENGE_DURATION)}
+}
+
+func NewPasskeyLoginChallenge(challenge string, sessionID session.SessionID, transactionID session.TransactionID, now time.Time) *PasskeyChallenge {
+	return &PasskeyChallenge{challenge: challenge, ceremony: PasskeyLogin, sessionID: sessionID, transactionID: transactionID, expiresAt: now.Add(PASSKEY_CHALLENGE_DURATION)}
+}
+
+func (c *PasskeyChallenge) Challenge() string                    { return c.challenge }
+func (c *PasskeyChallenge) Ceremony() PasskeyCeremony            { return c.ceremony }
+func (c *PasskeyChallenge) SessionID() session.SessionID         { return c.sessionID }
+func (c *PasskeyChallenge) TransactionID() session.TransactionID { return c.transactionID }
+func (c *PasskeyChallenge) UserID() string                       { return c.userID }
+func (c *PasskeyChallenge) ExpiresAt() time.Time                 { return c.expiresAt }
+
+func (c *PasskeyChallenge) IsExpired(now time.Time) bool {
+	return now.After(c.expiresAt)
+}
@@ -1,23 +1,39 @@
 package dto
 
-import "oauth-tutorial/internal/domain"
+import (
+	"oauth-tutorial/internal/domain"
+	"time"
+)
 
+// ブラウザ単位の長寿命なセッション(SSOセッション)
+// ログイン済みであれば、別のクライアントからの認可リクエストでもパスワードの再入力を求めない
 type SessionData struct {
-	authParam *domain.AuthorizationCodeFlowParam
-	user      *domain.User
+	user     *domain.User
+	authTime time.Time
+	amr      []string
 }
 
-func NewSessionData(authParam *domain.AuthorizationCodeFlowParam, user *domain.User) *SessionData {
+// 未ログインのセッションを生成する場合はuserにnilを渡す
+func NewSessionData(user *domain.User, authTime time.Time, amr []string) *SessionData {
 	return &SessionData{
-		authParam: authParam,
-		user:      user,
+		user:     user,
+		authTime: authTime,
+		amr:      amr,
 	}
 }
 
-func (sd *SessionData) AuthParam() *domain.AuthorizationCodeFlowParam {
-	return sd.authParam
-}
-
 func (sd *SessionData) User() *domain.User {
 	return sd.user
 }
+
+func (sd *SessionData) AuthTime() time.Time {
+	return sd.authTime
+}
+
+func (sd *SessionData) AMR() []string {
+	return sd.amr
+}
+
+func (sd *SessionData) IsAuthenticated() bool {
+	return sd.user != nil
+}
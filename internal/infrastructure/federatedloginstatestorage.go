@@ -0,0 +1,62 @@
+package infrastructure
+
+import (
+	"errors"
+	"oauth-tutorial/internal/infrastructure/dto"
+	"sync"
+	"time"
+)
+
+var (
+	ErrInvalidFederatedLoginState  = errors.New("invalid federated login state")
+	ErrFederatedLoginStateNotFound = errors.New("federated login state not found")
+)
+
+// 上流のIdPへの認可リクエストの状態。短命なため、永続化の実装に関わらずメモリ上に保持する
+type FederatedLoginStateStorage struct {
+	store map[string]*dto.FederatedLoginState
+	mu    sync.Mutex
+}
+
+func NewFederatedLoginStateStorage() *FederatedLoginStateStorage {
+	return &FederatedLoginStateStorage{store: make(map[string]*dto.FederatedLoginState)}
+}
+
+func (s *FederatedLoginStateStorage) Save(state *dto.FederatedLoginState) error {
+	if state == nil || state.State() == "" {
+		return ErrInvalidFederatedLoginState
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.store[state.State()] = state
+	return nil
+}
+
+// 取得すると同時に削除する。同じstateでコールバックを受け付けるのは1回のみ
+func (s *FederatedLoginStateStorage) Consume(state string) (*dto.FederatedLoginState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	loginState, ok := s.store[state]
+	if !ok {
+		return nil, ErrFederatedLoginStateNotFound
+	}
+	delete(s.store, state)
+	return loginState, nil
+}
+
+// 有効期限切れの状態を最大limit件削除し、削除した数を返す
+func (s *FederatedLoginStateStorage) PurgeExpired(now time.Time, limit int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	purged := 0
+	for key, state := range s.store {
+		if purged >= limit {
+			break
+		}
+		if state.IsExpired(now) {
+			delete(s.store, key)
+			purged++
+		}
+	}
+	return purged, nil
+}
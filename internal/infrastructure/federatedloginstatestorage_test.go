@@ -0,0 +1,56 @@
+package infrastructure
+
+import (
+	"errors"
+	"oauth-tutorial/internal/infrastructure/dto"
+	"testing"
+	"time"
+)
+
+func Test_上流のIdPへの認可リクエストの状態(t *testing.T) {
+	t.Run("1回のみ取得できる", func(t *testing.T) {
+		// given
+		s := NewFederatedLoginStateStorage()
+		state := dto.NewFederatedLoginState("state-1", "corp", "tx-1", "session-1", "nonce", "verifier", time.Now())
+		if err := s.Save(state); err != nil {
+			t.Fatalf("Save() error = %v", err)
+		}
+
+		// when
+		actual, err := s.Consume("state-1")
+
+		// then
+		if err != nil || actual != state {
+			t.Fatalf("Consume() = %v, %v, want %v", actual, err, state)
+		}
+		if _, err := s.Consume("state-1"); !errors.Is(err, ErrFederatedLoginStateNotFound) {
+			t.Errorf("Consume() error = %v, want %v", err, ErrFederatedLoginStateNotFound)
+		}
+	})
+
+	t.Run("空のstateは保存できない", func(t *testing.T) {
+		s := NewFederatedLoginStateStorage()
+		if err := s.Save(dto.NewFederatedLoginState("", "corp", "tx-1", "session-1", "nonce", "verifier", time.Now())); !errors.Is(err, ErrInvalidFederatedLoginState) {
+			t.Errorf("Save() error = %v, want %v", err, ErrInvalidFederatedLoginState)
+		}
+	})
+
+	t.Run("有効期限切れの状態を削除する", func(t *testing.T) {
+		// given
+		s := NewFederatedLoginStateStorage()
+		now := time.Now()
+		_ = s.Save(dto.NewFederatedLoginState("expired", "corp", "tx-1", "session-1", "nonce", "verifier", now.Add(-dto.FEDERATED_LOGIN_STATE_DURATION-time.Second)))
+		_ = s.Save(dto.NewFederatedLoginState("valid", "corp", "tx-2", "session-1", "nonce", "verifier", now))
+
+		// when
+		purged, err := s.PurgeExpired(now, 10)
+
+		// then
+		if err != nil || purged != 1 {
+			t.Errorf("PurgeExpired() = %d, %v, want 1", purged, err)
+		}
+		if _, err := s.Consume("valid"); err != nil {
+			t.Errorf("Consume() error = %v", err)
+		}
+	})
+}
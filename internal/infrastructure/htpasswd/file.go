@@ -0,0 +1,150 @@
+// htpasswd形式のファイルに登録したユーザーのログインIDとパスワードで認証する
+//
+// 1行に1ユーザーを「ログインID:パスワードのハッシュ値[:ユーザーID]」の形式で書く。
+// ユーザーIDを省略した場合はログインIDをユーザーIDにする。空行と#で始まる行は読み飛ばす。
+// ハッシュ値はmycrypto.PasswordHasherの形式(argon2id)と、bcrypt(htpasswd -B で生成したもの)を受け付ける。
+//
+//	alice@example.com:$argon2id$v=19$m=19456,t=2,p=1$...:IU7ewbuvey
+//	bob@example.com:$2y$10$...
+package htpasswd
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"oauth-tutorial/internal/domain"
+	"oauth-tutorial/pkg/mycrypto"
+	"os"
+	"strings"
+)
+
+var ErrInvalidFile = errors.New("invalid htpasswd file")
+
+type entry struct {
+	user *domain.User
+	hash string
+}
+
+type File struct {
+	// ログインIDをキーとする
+	entries map[string]entry
+	// 登録された順のユーザー
+	users  []*domain.User
+	hasher *mycrypto.PasswordHasher
+	// 存在しないユーザーの場合に検証するハッシュ値。登録済みのハッシュ値で最も多いアルゴリズムとパラメータで作る
+	dummyHash string
+}
+
+// pathのファイルを読み込む。形式の誤りや、argon2id・bcrypt以外のハッシュ値がある場合はエラー
+// ファイルを書き換えないため、hasherは検証と存在しないユーザーの場合のハッシュ値の計算にのみ使う
+func Open(path string, hasher *mycrypto.PasswordHasher) (*File, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	f, err := Parse(data, hasher)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return f, nil
+}
+
+func Parse(data []byte, hasher *mycrypto.PasswordHasher) (*File, error) {
+	f := &File{entries: map[string]entry{}, hasher: hasher}
+	userIDs := map[string]bool{}
+	// アルゴリズムとパラメータ毎のハッシュ値の数
+	paramCounts := map[string]int{}
+	var dummySource string
+	var errs []error
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.SplitN(line, ":", 3)
+		if len(fields) < 2 || fields[0] == "" {
+			errs = append(errs, fmt.Errorf("%w: line %d: must be login_id:hash[:user_id]", ErrInvalidFile, n))
+			continue
+		}
+		loginID, hash, userID := fields[0], fields[1], fields[0]
+		if len(fields) == 3 && fields[2] != "" {
+			userID = fields[2]
+		}
+		// 平文やMD5(apr1)、SHA-1のパスワードは受け付けない
+		if err := mycrypto.ValidatePasswordHash(hash); err != nil {
+			errs = append(errs, fmt.Errorf("%w: line %d: password of %q must be an argon2id or bcrypt hash", ErrInvalidFile, n, loginID))
+			continue
+		}
+		if _, ok := f.entries[loginID]; ok {
+			errs = append(errs, fmt.Errorf("%w: line %d: duplicate login id %q", ErrInvalidFile, n, loginID))
+			continue
+		}
+		if userIDs[userID] {
+			errs = append(errs, fmt.Errorf("%w: line %d: duplicate user id %q", ErrInvalidFile, n, userID))
+			continue
+		}
+		userIDs[userID] = true
+		// パスワードのハッシュ値はこのファイルでのみ保持し、ユーザーには持たせない
+		user := domain.ReconstructUser(userID, loginID, "")
+		f.entries[loginID] = entry{user: user, hash: hash}
+		f.users = append(f.users, user)
+		params := hashParameters(hash)
+		paramCounts[params]++
+		if dummySource == "" || paramCounts[params] > paramCounts[hashParameters(dummySource)] {
+			dummySource = hash
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	if dummySource != "" {
+		dummyHash, err := mycrypto.DummyPasswordHash(dummySource)
+		if err != nil {
+			return nil, err
+		}
+		f.dummyHash = dummyHash
+	}
+	return f, nil
+}
+
+// ソルトとハッシュ値を除いた、アルゴリズムとパラメータの部分。例: $2y$10$, $argon2id$v=19$m=19456,t=2,p=1$
+// ValidatePasswordHashで検証済みのハッシュ値を受け取る
+func hashParameters(hash string) string {
+	if strings.HasPrefix(hash, "$argon2id$") {
+		salt := strings.LastIndex(hash[:strings.LastIndex(hash, "$")], "$")
+		return hash[:salt+1]
+	}
+	return hash[:len("$2y$10$")]
+}
+
+// ファイルに登録したユーザー。パスワードを持たない
+func (f *File) Users() []*domain.User {
+	return f.users
+}
+
+func (f *File) Authenticate(loginID, password string) (domain.AuthenticationResult, error) {
+	e, ok := f.entries[loginID]
+	if !ok {
+		// 応答時間からユーザーの有無を推測されないよう、存在しない場合も登録済みのユーザーと同じアルゴリズムとパラメータで検証する
+		if f.dummyHash != "" {
+			f.hasher.Verify(f.dummyHash, password)
+		} else {
+			f.hasher.DummyVerify(password)
+		}
+		return domain.NewAuthenticationFailure(domain.AuthenticationFailureUnknownUser), nil
+	}
+	// ファイルは書き換えないため、以前の形式やパラメータでも再ハッシュはしない
+	match, _, err := f.hasher.Verify(e.hash, password)
+	if err != nil {
+		return domain.AuthenticationResult{}, err
+	}
+	if !match {
+		return domain.NewAuthenticationFailure(domain.AuthenticationFailureInvalidPassword), nil
+	}
+	return domain.NewAuthenticationSuccess(e.user, []string{domain.AMRPassword}), nil
+}
@@ -0,0 +1,142 @@
+package htpasswd
+
+import (
+	"errors"
+	"oauth-tutorial/internal/domain"
+	"oauth-tutorial/pkg/mycrypto"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// テストを速くするため、メモリ使用量と繰り返し回数を小さくする
+var testHasher = mycrypto.NewPasswordHasher(mycrypto.Argon2Params{Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32})
+
+func hash(t *testing.T, password string) string {
+	t.Helper()
+	h, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("GenerateFromPassword() error = %v", err)
+	}
+	return string(h)
+}
+
+func Test_htpasswd形式のファイルによる認証(t *testing.T) {
+	// given
+	path := filepath.Join(t.TempDir(), "users.htpasswd")
+	content := strings.Join([]string{
+		"# 管理者",
+		"alice@example.com:" + hash(t, "alice-password") + ":IU7ewbuvey",
+		"",
+		"bob:" + hash(t, "bob-password"),
+		"carol:" + testHasher.Hash("carol-password"),
+	}, "\n")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	f, err := Open(path, testHasher)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+
+	tests := []struct {
+		name           string
+		loginID        string
+		password       string
+		expectedUserID string
+		expectedReason domain.AuthenticationFailureReason
+	}{
+		{name: "正常系 - ユーザーIDを指定したユーザー", loginID: "alice@example.com", password: "alice-password", expectedUserID: "IU7ewbuvey"},
+		{name: "正常系 - ユーザーIDを省略したユーザー", loginID: "bob", password: "bob-password", expectedUserID: "bob"},
+		{name: "正常系 - argon2idのハッシュ値のユーザー", loginID: "carol", password: "carol-password", expectedUserID: "carol"},
+		{name: "異常系 - パスワードが誤っている", loginID: "alice@example.com", password: "bob-password", expectedReason: domain.AuthenticationFailureInvalidPassword},
+		{name: "異常系 - argon2idのハッシュ値のパスワードが誤っている", loginID: "carol", password: "alice-password", expectedReason: domain.AuthenticationFailureInvalidPassword},
+		{name: "異常系 - 存在しないユーザー", loginID: "dave", password: "alice-password", expectedReason: domain.AuthenticationFailureUnknownUser},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// when
+			result, err := f.Authenticate(tt.loginID, tt.password)
+
+			// then
+			if err != nil {
+				t.Fatalf("Authenticate() error = %v", err)
+			}
+			if result.FailureReason() != tt.expectedReason {
+				t.Errorf("FailureReason() = %q, want %q", result.FailureReason(), tt.expectedReason)
+			}
+			if tt.expectedReason != "" {
+				return
+			}
+			if !result.Succeeded() || result.User().UserID() != tt.expectedUserID || result.User().LoginID() != tt.loginID {
+				t.Errorf("User() = %+v", result.User())
+			}
+			if len(result.AMR()) != 1 || result.AMR()[0] != domain.AMRPassword {
+				t.Errorf("AMR() = %v", result.AMR())
+			}
+			// パスワードのハッシュ値はユーザーに持たせない
+			if result.User().PasswordHash() != "" {
+				t.Errorf("PasswordHash() = %q, want empty", result.User().PasswordHash())
+			}
+		})
+	}
+
+	if users := f.Users(); len(users) != 3 || users[0].LoginID() != "alice@example.com" || users[1].LoginID() != "bob" || users[2].LoginID() != "carol" {
+		t.Errorf("Users() = %v", users)
+	}
+}
+
+func Test_存在しないユーザーは登録済みのユーザーと同じアルゴリズムとパラメータで検証する(t *testing.T) {
+	// given: bcryptのユーザーが多いファイル
+	cost := bcrypt.MinCost + 1
+	bcryptHash := func(password string) string {
+		h, err := bcrypt.GenerateFromPassword([]byte(password), cost)
+		if err != nil {
+			t.Fatalf("GenerateFromPassword() error = %v", err)
+		}
+		return string(h)
+	}
+	content := strings.Join([]string{
+		"carol:" + testHasher.Hash("carol-password"),
+		"alice:" + bcryptHash("alice-password"),
+		"bob:" + bcryptHash("bob-password"),
+	}, "\n")
+
+	// when
+	f, err := Parse([]byte(content), testHasher)
+
+	// then: argon2idではなく、同じコストのbcryptで検証する
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if actual, err := bcrypt.Cost([]byte(f.dummyHash)); err != nil || actual != cost {
+		t.Errorf("bcrypt.Cost(dummyHash) = %d, %v, want %d", actual, err, cost)
+	}
+	if result, err := f.Authenticate("dave", "alice-password"); err != nil || result.FailureReason() != domain.AuthenticationFailureUnknownUser {
+		t.Errorf("Authenticate() = %+v, %v, want unknown user", result, err)
+	}
+}
+
+func Test_htpasswd形式のファイルの検証(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{name: "異常系 - 平文のパスワード", content: "alice:password"},
+		{name: "異常系 - MD5のハッシュ値", content: "alice:$apr1$7X6Kz9dN$Pq3pD3d8sJr0eG1pYHn6s/"},
+		{name: "異常系 - 形式が不正なargon2idのハッシュ値", content: "alice:$argon2id$v=19$m=0,t=1,p=1$c2FsdA$aGFzaA"},
+		{name: "異常系 - 区切りが無い", content: "alice"},
+		{name: "異常系 - 重複したログインID", content: "alice:" + hash(t, "a") + "\nalice:" + hash(t, "b")},
+		{name: "異常系 - 重複したユーザーID", content: "alice:" + hash(t, "a") + ":user-1\nbob:" + hash(t, "b") + ":user-1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Parse([]byte(tt.content), testHasher); !errors.Is(err, ErrInvalidFile) {
+				t.Errorf("Parse() error = %v, want %v", err, ErrInvalidFile)
+			}
+		})
+	}
+}
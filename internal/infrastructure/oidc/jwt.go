@@ -0,0 +1,231 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// 検証できる署名アルゴリズム。noneやHMAC(クライアントシークレットで署名したもの)は受け付けない
+const (
+	algRS256 = "RS256"
+	algES256 = "ES256"
+)
+
+// JWS Compact Serialization RFC 7515 7.1
+type jwt struct {
+	header       jwtHeader
+	payload      []byte
+	signingInput string
+	signature    []byte
+}
+
+type jwtHeader struct {
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+}
+
+func parseJWT(raw string) (*jwt, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed jwt")
+	}
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("malformed jwt header: %w", err)
+	}
+	var header jwtHeader
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		return nil, fmt.Errorf("malformed jwt header: %w", err)
+	}
+	if header.Algorithm != algRS256 && header.Algorithm != algES256 {
+		return nil, fmt.Errorf("unsupported alg %q", header.Algorithm)
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("malformed jwt payload: %w", err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed jwt signature: %w", err)
+	}
+	return &jwt{header: header, payload: payload, signingInput: parts[0] + "." + parts[1], signature: signature}, nil
+}
+
+func (t *jwt) verify(key crypto.PublicKey) error {
+	digest := sha256.Sum256([]byte(t.signingInput))
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if err := rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], t.signature); err != nil {
+			return errors.New("invalid signature")
+		}
+		return nil
+	case *ecdsa.PublicKey:
+		// RFC 7518 3.4 ES256の署名はRとSを32バイトずつ連結したもの
+		if len(t.signature) != 64 {
+			return errors.New("invalid signature")
+		}
+		r := new(big.Int).SetBytes(t.signature[:32])
+		s := new(big.Int).SetBytes(t.signature[32:])
+		if !ecdsa.Verify(k, digest[:], r, s) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	}
+	return errors.New("unsupported key type")
+}
+
+// IDトークンのクレーム OpenID Connect Core 1.0 2.
+type idTokenClaims struct {
+	Issuer          string   `json:"iss"`
+	Subject         string   `json:"sub"`
+	Audience        audience `json:"aud"`
+	AuthorizedParty string   `json:"azp"`
+	Expiry          int64    `json:"exp"`
+	IssuedAt        int64    `json:"iat"`
+	Nonce           string   `json:"nonce"`
+	// ログインIDに使うクレームを参照するため、全てのクレームを保持する
+	raw map[string]any
+}
+
+func (t *jwt) claims() (*idTokenClaims, error) {
+	var claims idTokenClaims
+	if err := json.Unmarshal(t.payload, &claims); err != nil {
+		return nil, fmt.Errorf("malformed claims: %w", err)
+	}
+	if err := json.Unmarshal(t.payload, &claims.raw); err != nil {
+		return nil, fmt.Errorf("malformed claims: %w", err)
+	}
+	return &claims, nil
+}
+
+// 文字列のクレームclaimの値。値が無い場合はsub
+// emailの場合は、email_verifiedがfalseであれば使わない
+func (c *idTokenClaims) loginID(claim string) string {
+	if claim == "" {
+		return c.Subject
+	}
+	value, _ := c.raw[claim].(string)
+	if claim == "email" {
+		if verified, ok := c.raw["email_verified"].(bool); ok && !verified {
+			return c.Subject
+		}
+	}
+	if value == "" {
+		return c.Subject
+	}
+	return value
+}
+
+// audは文字列または文字列の配列 RFC 7519 4.1.3
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+	var multiple []string
+	if err := json.Unmarshal(data, &multiple); err != nil {
+		return errors.New("aud must be a string or an array of strings")
+	}
+	*a = multiple
+	return nil
+}
+
+// JWK Set RFC 7517 5.
+type jsonWebKeySet struct {
+	Keys []jsonWebKey `json:"keys"`
+}
+
+type jsonWebKey struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	// RSA
+	N string `json:"n"`
+	E string `json:"e"`
+	// EC
+	Curve string `json:"crv"`
+	X     string `json:"x"`
+	Y     string `json:"y"`
+}
+
+type signingKey struct {
+	id        string
+	algorithm string
+	key       crypto.PublicKey
+}
+
+type keySet struct {
+	keys []signingKey
+}
+
+// 署名に使わない鍵と未対応の鍵は読み飛ばす
+func (s jsonWebKeySet) parse() (*keySet, error) {
+	set := &keySet{}
+	for _, k := range s.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		var key signingKey
+		switch k.KeyType {
+		case "RSA":
+			n, errN := base64.RawURLEncoding.DecodeString(k.N)
+			e, errE := base64.RawURLEncoding.DecodeString(k.E)
+			if errN != nil || errE != nil || len(e) == 0 || len(e) > 4 {
+				return nil, fmt.Errorf("invalid rsa key %q", k.KeyID)
+			}
+			key = signingKey{id: k.KeyID, algorithm: algRS256, key: &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}}
+		case "EC":
+			if k.Curve != "P-256" {
+				continue
+			}
+			x, errX := base64.RawURLEncoding.DecodeString(k.X)
+			y, errY := base64.RawURLEncoding.DecodeString(k.Y)
+			if errX != nil || errY != nil {
+				return nil, fmt.Errorf("invalid ec key %q", k.KeyID)
+			}
+			pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+			if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+				return nil, fmt.Errorf("invalid ec key %q", k.KeyID)
+			}
+			key = signingKey{id: k.KeyID, algorithm: algES256, key: pub}
+		default:
+			continue
+		}
+		if k.Algorithm != "" && k.Algorithm != key.algorithm {
+			continue
+		}
+		set.keys = append(set.keys, key)
+	}
+	return set, nil
+}
+
+// kidとアルゴリズムが一致する鍵。kidが無い場合は、アルゴリズムが一致する鍵が1つのみであればその鍵
+func (s *keySet) find(keyID string, algorithm string) (crypto.PublicKey, bool) {
+	var candidates []signingKey
+	for _, k := range s.keys {
+		if k.algorithm != algorithm {
+			continue
+		}
+		if keyID != "" && k.id == keyID {
+			return k.key, true
+		}
+		candidates = append(candidates, k)
+	}
+	if keyID == "" && len(candidates) == 1 {
+		return candidates[0].key, true
+	}
+	return nil, false
+}
@@ -0,0 +1,247 @@
+// テスト用の上流のOpenID Connectプロバイダー(IdP)
+//
+// Discovery・認可・トークン・JWKSのエンドポイントを持ち、認可リクエストでは画面を表示せずに
+// SetUserで指定したユーザーとして即座に認可コードを発行する。
+//
+//	idp := oidctest.NewServer(t, "client-id", "client-secret")
+//	idp.SetUser(map[string]any{"sub": "alice", "email": "alice@example.com"})
+package oidctest
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"maps"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+const keyID = "test-key"
+
+type Server struct {
+	*httptest.Server
+	clientID     string
+	clientSecret string
+	key          *rsa.PrivateKey
+	// JWKSで公開していない鍵。署名の検証に失敗するIDトークンの発行に使う
+	unknownKey *rsa.PrivateKey
+
+	mu    sync.Mutex
+	user  map[string]any
+	codes map[string]authorizationRequest
+	// 発行するIDトークンのクレームを書き換える
+	modifyClaims       func(claims map[string]any)
+	signWithUnknownKey bool
+}
+
+type authorizationRequest struct {
+	redirectURI   string
+	nonce         string
+	codeChallenge string
+	user          map[string]any
+}
+
+// clientSecretが空の場合はパブリッククライアントとして扱う
+// サーバーはテストの終了時に停止する
+func NewServer(t testing.TB, clientID string, clientSecret string) *Server {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey() error = %v", err)
+	}
+	unknownKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey() error = %v", err)
+	}
+	s := &Server{
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		key:          key,
+		unknownKey:   unknownKey,
+		user:         map[string]any{"sub": "test-subject"},
+		codes:        map[string]authorizationRequest{},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", s.serveDiscovery)
+	mux.HandleFunc("GET /authorize", s.serveAuthorize)
+	mux.HandleFunc("POST /token", s.serveToken)
+	mux.HandleFunc("GET /jwks", s.serveJWKS)
+	s.Server = httptest.NewServer(mux)
+	t.Cleanup(s.Close)
+	return s
+}
+
+// IdPの識別子
+func (s *Server) Issuer() string {
+	return s.URL
+}
+
+// 以降の認可リクエストでログインするユーザーのクレーム(subを含む)
+func (s *Server) SetUser(claims map[string]any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.user = maps.Clone(claims)
+}
+
+// 以降に発行するIDトークンのクレームをfnで書き換える。nilの場合は書き換えない
+func (s *Server) ModifyClaims(fn func(claims map[string]any)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.modifyClaims = fn
+}
+
+// trueの場合、以降のIDトークンをJWKSで公開していない鍵で署名する
+func (s *Server) SignWithUnknownKey(enabled bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.signWithUnknownKey = enabled
+}
+
+func (s *Server) serveDiscovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"issuer":                                s.Issuer(),
+		"authorization_endpoint":                s.URL + "/authorize",
+		"token_endpoint":                        s.URL + "/token",
+		"jwks_uri":                              s.URL + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+// ログイン画面を表示せず、SetUserのユーザーとして認可コードを発行してリダイレクトする
+func (s *Server) serveAuthorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("client_id") != s.clientID || q.Get("redirect_uri") == "" {
+		http.Error(w, "invalid client", http.StatusBadRequest)
+		return
+	}
+	redirect := func(params url.Values) {
+		u, _ := url.Parse(q.Get("redirect_uri"))
+		params.Set("state", q.Get("state"))
+		u.RawQuery = params.Encode()
+		http.Redirect(w, r, u.String(), http.StatusFound)
+	}
+	if q.Get("response_type") != "code" || q.Get("code_challenge") == "" || q.Get("code_challenge_method") != "S256" {
+		redirect(url.Values{"error": {"invalid_request"}})
+		return
+	}
+
+	code := randomString()
+	s.mu.Lock()
+	s.codes[code] = authorizationRequest{
+		redirectURI:   q.Get("redirect_uri"),
+		nonce:         q.Get("nonce"),
+		codeChallenge: q.Get("code_challenge"),
+		user:          maps.Clone(s.user),
+	}
+	s.mu.Unlock()
+	redirect(url.Values{"code": {code}})
+}
+
+func (s *Server) serveToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+	clientID, clientSecret, ok := r.BasicAuth()
+	if ok {
+		clientID, _ = url.QueryUnescape(clientID)
+		clientSecret, _ = url.QueryUnescape(clientSecret)
+	} else {
+		clientID = r.PostForm.Get("client_id")
+	}
+	if clientID != s.clientID || clientSecret != s.clientSecret {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	// 認可コードは1回のみ使える
+	s.mu.Lock()
+	req, found := s.codes[r.PostForm.Get("code")]
+	delete(s.codes, r.PostForm.Get("code"))
+	modify, signWithUnknownKey := s.modifyClaims, s.signWithUnknownKey
+	s.mu.Unlock()
+	if r.PostForm.Get("grant_type") != "authorization_code" || !found || req.redirectURI != r.PostForm.Get("redirect_uri") {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+	// RFC 7636 4.6 コード検証器のSHA-256がcode_challengeと一致すること
+	digest := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(digest[:]) != req.codeChallenge {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant", "error_description": "code_verifier does not match"})
+		return
+	}
+
+	now := time.Now()
+	claims := maps.Clone(req.user)
+	claims["iss"] = s.Issuer()
+	claims["aud"] = s.clientID
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(5 * time.Minute).Unix()
+	if req.nonce != "" {
+		claims["nonce"] = req.nonce
+	}
+	if modify != nil {
+		modify(claims)
+	}
+	key := s.key
+	if signWithUnknownKey {
+		key = s.unknownKey
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"access_token": randomString(),
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     sign(key, claims),
+	})
+}
+
+func (s *Server) serveJWKS(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": keyID,
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(s.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(s.key.E)).Bytes()),
+		}},
+	})
+}
+
+// RS256で署名したIDトークン
+func sign(key *rsa.PrivateKey, claims map[string]any) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": keyID})
+	payload, _ := json.Marshal(claims)
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		panic(err)
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func randomString() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,285 @@
+// 上流のOpenID Connectプロバイダー(IdP)でユーザーを認証するクライアント
+//
+// PKCE(S256)付きの認可コードフローでIDトークンを取得し、署名とクレームを検証する。
+// エンドポイントと署名鍵はDiscovery(/.well-known/openid-configuration)で取得する。
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"oauth-tutorial/internal/domain"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrDiscoveryFailed    = errors.New("failed to discover the provider metadata")
+	ErrTokenRequestFailed = errors.New("token request to the provider failed")
+	ErrInvalidIDToken     = errors.New("invalid id token")
+)
+
+// IDトークンのexp・iatの検証で許容する時計のずれ
+const clockSkew = time.Minute
+
+// レスポンスの最大サイズ
+const maxResponseSize = 1 << 20
+
+// 上流のIdPの設定
+type Config struct {
+	// IdPの識別子。Discoveryのissuer、IDトークンのissと一致すること
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// IdPに登録したこの認可サーバーのコールバックのURL
+	RedirectURI string
+	Scopes      []string
+	// ログインIDにするクレーム。値が無い場合はsubを使う
+	LoginIDClaim string
+}
+
+type Provider struct {
+	cfg        Config
+	httpClient *http.Client
+	now        func() time.Time
+
+	mu       sync.Mutex
+	metadata *providerMetadata
+	keys     *keySet
+}
+
+// Discovery 1.0 3. OpenID Provider Metadata のうち使用する項目
+type providerMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// httpClientがnilの場合はhttp.DefaultClientを使う
+func NewProvider(cfg Config, httpClient *http.Client) *Provider {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &Provider{cfg: cfg, httpClient: httpClient, now: time.Now}
+}
+
+// 認可リクエストのURL。codeChallengeはコード検証器のSHA-256(S256) RFC 7636 4.2
+func (p *Provider) AuthorizationURL(ctx context.Context, state, nonce, codeChallenge string) (string, error) {
+	metadata, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.cfg.ClientID},
+		"redirect_uri":          {p.cfg.RedirectURI},
+		"scope":                 {strings.Join(p.cfg.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {codeChallenge},
+		"code_challenge_method": {"S256"},
+	}
+	u, err := url.Parse(metadata.AuthorizationEndpoint)
+	if err != nil {
+		return "", fmt.Errorf("%w: invalid authorization_endpoint: %v", ErrDiscoveryFailed, err)
+	}
+	// 認可エンドポイントのURLに含まれるクエリは残す
+	q := u.Query()
+	for name, values := range query {
+		q[name] = values
+	}
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// 認可コードをIDトークンと交換し、検証したIDトークンのissとsub、ログインIDを返す
+func (p *Provider) Authenticate(ctx context.Context, code, codeVerifier, nonce string) (domain.FederatedIdentity, string, error) {
+	metadata, err := p.discover(ctx)
+	if err != nil {
+		return domain.FederatedIdentity{}, "", err
+	}
+	rawIDToken, err := p.exchange(ctx, metadata, code, codeVerifier)
+	if err != nil {
+		return domain.FederatedIdentity{}, "", err
+	}
+	claims, err := p.verifyIDToken(ctx, metadata, rawIDToken, nonce)
+	if err != nil {
+		return domain.FederatedIdentity{}, "", err
+	}
+	identity, err := domain.NewFederatedIdentity(claims.Issuer, claims.Subject)
+	if err != nil {
+		return domain.FederatedIdentity{}, "", fmt.Errorf("%w: %v", ErrInvalidIDToken, err)
+	}
+	return identity, claims.loginID(p.cfg.LoginIDClaim), nil
+}
+
+// トークンエンドポイントでIDトークンを取得する OpenID Connect Core 1.0 3.1.3
+func (p *Provider) exchange(ctx context.Context, metadata *providerMetadata, code, codeVerifier string) (string, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.cfg.RedirectURI},
+		"code_verifier": {codeVerifier},
+	}
+	// シークレットが無い場合はパブリッククライアントとしてclient_idのみ送信する
+	if p.cfg.ClientSecret == "" {
+		form.Set("client_id", p.cfg.ClientID)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, metadata.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrTokenRequestFailed, err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.cfg.ClientSecret != "" {
+		// RFC 6749 2.3.1 client_idとシークレットはURLエンコードしてからBasic認証に使う
+		req.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+	}
+
+	var body struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	status, err := p.doJSON(req, &body)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrTokenRequestFailed, err)
+	}
+	if status != http.StatusOK {
+		return "", fmt.Errorf("%w: status %d: %s %s", ErrTokenRequestFailed, status, body.Error, body.ErrorDescription)
+	}
+	if body.IDToken == "" {
+		return "", fmt.Errorf("%w: id_token is missing in the token response", ErrTokenRequestFailed)
+	}
+	return body.IDToken, nil
+}
+
+// Discoveryでメタデータを取得する。取得できた場合は以降のリクエストで使い回す
+func (p *Provider) discover(ctx context.Context) (*providerMetadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.metadata != nil {
+		return p.metadata, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(p.cfg.Issuer, "/")+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDiscoveryFailed, err)
+	}
+	var metadata providerMetadata
+	status, err := p.doJSON(req, &metadata)
+	switch {
+	case err != nil:
+		return nil, fmt.Errorf("%w: %v", ErrDiscoveryFailed, err)
+	case status != http.StatusOK:
+		return nil, fmt.Errorf("%w: status %d", ErrDiscoveryFailed, status)
+	// Discovery 1.0 4.3 issuerは設定した値と完全に一致すること
+	case metadata.Issuer != p.cfg.Issuer:
+		return nil, fmt.Errorf("%w: issuer %q does not match %q", ErrDiscoveryFailed, metadata.Issuer, p.cfg.Issuer)
+	case metadata.AuthorizationEndpoint == "" || metadata.TokenEndpoint == "" || metadata.JWKSURI == "":
+		return nil, fmt.Errorf("%w: authorization_endpoint, token_endpoint and jwks_uri are required", ErrDiscoveryFailed)
+	}
+	p.metadata = &metadata
+	return p.metadata, nil
+}
+
+// JSONのレスポンスをvに読み込み、ステータスコードを返す
+func (p *Provider) doJSON(req *http.Request, v any) (int, error) {
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(v); err != nil {
+		return resp.StatusCode, fmt.Errorf("invalid response: %w", err)
+	}
+	return resp.StatusCode, nil
+}
+
+// IDトークンの署名とクレームを検証する OpenID Connect Core 1.0 3.1.3.7
+func (p *Provider) verifyIDToken(ctx context.Context, metadata *providerMetadata, rawIDToken string, nonce string) (*idTokenClaims, error) {
+	token, err := parseJWT(rawIDToken)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidIDToken, err)
+	}
+	if err := p.verifySignature(ctx, metadata, token); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidIDToken, err)
+	}
+	claims, err := token.claims()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidIDToken, err)
+	}
+
+	now := p.now()
+	switch {
+	case claims.Issuer != p.cfg.Issuer:
+		return nil, fmt.Errorf("%w: iss %q does not match %q", ErrInvalidIDToken, claims.Issuer, p.cfg.Issuer)
+	case claims.Subject == "":
+		return nil, fmt.Errorf("%w: sub is required", ErrInvalidIDToken)
+	case !slices.Contains(claims.Audience, p.cfg.ClientID):
+		return nil, fmt.Errorf("%w: aud %v does not contain the client id", ErrInvalidIDToken, claims.Audience)
+	// 複数のaudを含む場合は、azpがこのクライアントであること
+	case len(claims.Audience) > 1 && claims.AuthorizedParty != p.cfg.ClientID:
+		return nil, fmt.Errorf("%w: azp %q does not match the client id", ErrInvalidIDToken, claims.AuthorizedParty)
+	case claims.Expiry == 0 || now.After(time.Unix(claims.Expiry, 0).Add(clockSkew)):
+		return nil, fmt.Errorf("%w: token is expired", ErrInvalidIDToken)
+	case claims.IssuedAt == 0 || time.Unix(claims.IssuedAt, 0).After(now.Add(clockSkew)):
+		return nil, fmt.Errorf("%w: iat is missing or in the future", ErrInvalidIDToken)
+	// 認可リクエストで送ったnonceと一致すること(リプレイ攻撃対策)
+	case claims.Nonce != nonce:
+		return nil, fmt.Errorf("%w: nonce does not match", ErrInvalidIDToken)
+	}
+	return claims, nil
+}
+
+// 署名鍵はjwks_uriから取得してキャッシュする。鍵のローテーションに対応するため、kidが見つからない場合は取得し直す
+func (p *Provider) verifySignature(ctx context.Context, metadata *providerMetadata, token *jwt) error {
+	p.mu.Lock()
+	keys := p.keys
+	p.mu.Unlock()
+
+	if keys != nil {
+		if key, ok := keys.find(token.header.KeyID, token.header.Algorithm); ok {
+			return token.verify(key)
+		}
+	}
+	keys, err := p.fetchKeys(ctx, metadata)
+	if err != nil {
+		return err
+	}
+	key, ok := keys.find(token.header.KeyID, token.header.Algorithm)
+	if !ok {
+		return fmt.Errorf("signing key %q is not found", token.header.KeyID)
+	}
+	return token.verify(key)
+}
+
+func (p *Provider) fetchKeys(ctx context.Context, metadata *providerMetadata) (*keySet, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, metadata.JWKSURI, nil)
+	if err != nil {
+		return nil, err
+	}
+	var jwks jsonWebKeySet
+	status, err := p.doJSON(req, &jwks)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch jwks: %w", err)
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch jwks: status %d", status)
+	}
+	keys, err := jwks.parse()
+	if err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	p.keys = keys
+	p.mu.Unlock()
+	return keys, nil
+}
@@ -0,0 +1,184 @@
+package oidc
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"oauth-tutorial/internal/infrastructure/oidc/oidctest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testClientID     = "oauth-tutorial"
+	testClientSecret = "idp-secret"
+	testRedirectURI  = "https://auth.example.com/federation/callback"
+	testVerifier     = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	testNonce        = "n-0S6_WzA2Mj"
+)
+
+func newTestProvider(idp *oidctest.Server) *Provider {
+	return NewProvider(Config{
+		Issuer:       idp.Issuer(),
+		ClientID:     testClientID,
+		ClientSecret: testClientSecret,
+		RedirectURI:  testRedirectURI,
+		Scopes:       []string{"openid", "email"},
+		LoginIDClaim: "email",
+	}, nil)
+}
+
+func codeChallenge(verifier string) string {
+	digest := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(digest[:])
+}
+
+// IdPの認可エンドポイントにアクセスし、リダイレクト先のクエリを返す
+func authorizeAtIdP(t *testing.T, p *Provider, state string) url.Values {
+	t.Helper()
+	authURL, err := p.AuthorizationURL(context.Background(), state, testNonce, codeChallenge(testVerifier))
+	if err != nil {
+		t.Fatalf("AuthorizationURL() error = %v", err)
+	}
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(authURL)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	resp.Body.Close()
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil || !strings.HasPrefix(location.String(), testRedirectURI) {
+		t.Fatalf("Location = %q, want redirect to %q", resp.Header.Get("Location"), testRedirectURI)
+	}
+	return location.Query()
+}
+
+func Test_上流のIdPでの認証(t *testing.T) {
+	tests := []struct {
+		name            string
+		user            map[string]any
+		expectedLoginID string
+	}{
+		{name: "正常系 - emailをログインIDにする", user: map[string]any{"sub": "alice", "email": "alice@example.com", "email_verified": true}, expectedLoginID: "alice@example.com"},
+		{name: "正常系 - emailが無い場合はsub", user: map[string]any{"sub": "bob"}, expectedLoginID: "bob"},
+		{name: "正常系 - 確認されていないemailは使わない", user: map[string]any{"sub": "carol", "email": "carol@example.com", "email_verified": false}, expectedLoginID: "carol"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// given
+			idp := oidctest.NewServer(t, testClientID, testClientSecret)
+			idp.SetUser(tt.user)
+			p := newTestProvider(idp)
+			query := authorizeAtIdP(t, p, "xyz")
+			if query.Get("state") != "xyz" {
+				t.Errorf("state = %q, want %q", query.Get("state"), "xyz")
+			}
+
+			// when
+			identity, loginID, err := p.Authenticate(context.Background(), query.Get("code"), testVerifier, testNonce)
+
+			// then
+			if err != nil {
+				t.Fatalf("Authenticate() error = %v", err)
+			}
+			if identity.Issuer() != idp.Issuer() || identity.Subject() != tt.user["sub"] {
+				t.Errorf("identity = %+v", identity)
+			}
+			if loginID != tt.expectedLoginID {
+				t.Errorf("loginID = %q, want %q", loginID, tt.expectedLoginID)
+			}
+		})
+	}
+}
+
+func Test_IDトークンの検証(t *testing.T) {
+	tests := []struct {
+		name     string
+		modify   func(claims map[string]any)
+		unknown  bool
+		verifier string
+		nonce    string
+		now      func() time.Time
+		expected error
+	}{
+		{name: "異常系 - audが異なる", modify: func(c map[string]any) { c["aud"] = "other-client" }, expected: ErrInvalidIDToken},
+		{name: "異常系 - 複数のaudでazpが異なる", modify: func(c map[string]any) { c["aud"] = []string{"other-client", testClientID} }, expected: ErrInvalidIDToken},
+		{name: "正常系 - 複数のaudでazpがこのクライアント", modify: func(c map[string]any) {
+			c["aud"] = []string{"other-client", testClientID}
+			c["azp"] = testClientID
+		}},
+		{name: "異常系 - issが異なる", modify: func(c map[string]any) { c["iss"] = "https://evil.example.com" }, expected: ErrInvalidIDToken},
+		{name: "異常系 - subが無い", modify: func(c map[string]any) { delete(c, "sub") }, expected: ErrInvalidIDToken},
+		{name: "異常系 - 有効期限切れ", now: func() time.Time { return time.Now().Add(10 * time.Minute) }, expected: ErrInvalidIDToken},
+		{name: "正常系 - 許容する時計のずれ", now: func() time.Time { return time.Now().Add(5*time.Minute + 30*time.Second) }},
+		{name: "異常系 - iatが未来", modify: func(c map[string]any) { c["iat"] = time.Now().Add(time.Hour).Unix() }, expected: ErrInvalidIDToken},
+		{name: "異常系 - nonceが異なる", nonce: "other-nonce", expected: ErrInvalidIDToken},
+		{name: "異常系 - 公開されていない鍵で署名", unknown: true, expected: ErrInvalidIDToken},
+		{name: "異常系 - コード検証器が異なる", verifier: "wrong-verifier-wrong-verifier-wrong-verifier", expected: ErrTokenRequestFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// given
+			idp := oidctest.NewServer(t, testClientID, testClientSecret)
+			idp.ModifyClaims(tt.modify)
+			idp.SignWithUnknownKey(tt.unknown)
+			p := newTestProvider(idp)
+			if tt.now != nil {
+				p.now = tt.now
+			}
+			query := authorizeAtIdP(t, p, "xyz")
+			verifier, nonce := testVerifier, testNonce
+			if tt.verifier != "" {
+				verifier = tt.verifier
+			}
+			if tt.nonce != "" {
+				nonce = tt.nonce
+			}
+
+			// when
+			_, _, err := p.Authenticate(context.Background(), query.Get("code"), verifier, nonce)
+
+			// then
+			if !errors.Is(err, tt.expected) {
+				t.Errorf("Authenticate() error = %v, want %v", err, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_Discoveryの検証(t *testing.T) {
+	// given: 設定したissuerとDiscoveryのissuerが異なる
+	idp := oidctest.NewServer(t, testClientID, testClientSecret)
+	p := NewProvider(Config{Issuer: idp.Issuer() + "/", ClientID: testClientID, RedirectURI: testRedirectURI}, nil)
+
+	// when
+	_, err := p.AuthorizationURL(context.Background(), "xyz", testNonce, codeChallenge(testVerifier))
+
+	// then
+	if !errors.Is(err, ErrDiscoveryFailed) {
+		t.Errorf("AuthorizationURL() error = %v, want %v", err, ErrDiscoveryFailed)
+	}
+}
+
+func Test_JWTの解析(t *testing.T) {
+	encode := func(s string) string { return base64.RawURLEncoding.EncodeToString([]byte(s)) }
+	tests := []struct {
+		name string
+		raw  string
+	}{
+		{name: "異常系 - 署名なし(alg=none)", raw: encode(`{"alg":"none"}`) + "." + encode(`{"sub":"alice"}`) + "."},
+		{name: "異常系 - HMAC", raw: encode(`{"alg":"HS256"}`) + "." + encode(`{"sub":"alice"}`) + "." + encode("signature")},
+		{name: "異常系 - 区切りの数が異なる", raw: encode(`{"alg":"RS256"}`) + "." + encode(`{"sub":"alice"}`)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := parseJWT(tt.raw); err == nil {
+				t.Error("parseJWT() error = nil, want error")
+			}
+		})
+	}
+}
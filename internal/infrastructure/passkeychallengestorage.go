@@ -0,0 +1,62 @@
+package infrastructure
+
+import (
+	"errors"
+	"oauth-tutorial/internal/infrastructure/dto"
+	"sync"
+	"time"
+)
+
+var (
+	ErrInvalidPasskeyChallenge  = errors.New("invalid passkey challenge")
+	ErrPasskeyChallengeNotFound = errors.New("passkey challenge not found")
+)
+
+// パスキーの登録・ログインで発行したチャレンジ。短命なため、永続化の実装に関わらずメモリ上に保持する
+type PasskeyChallengeStorage struct {
+	store map[string]*dto.PasskeyChallenge
+	mu    sync.Mutex
+}
+
+func NewPasskeyChallengeStorage() *PasskeyChallengeStorage {
+	return &PasskeyChallengeStorage{store: make(map[string]*dto.PasskeyChallenge)}
+}
+
+func (s *PasskeyChallengeStorage) Save(challenge *dto.PasskeyChallenge) error {
+	if challenge == nil || challenge.Challenge() == "" {
+		return ErrInvalidPasskeyChallenge
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.store[challenge.Challenge()] = challenge
+	return nil
+}
+
+// 取得すると同時に削除する。同じチャレンジの署名を受け付けるのは1回のみ
+func (s *PasskeyChallengeStorage) Consume(challenge string) (*dto.PasskeyChallenge, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.store[challenge]
+	if !ok {
+		return nil, ErrPasskeyChallengeNotFound
+	}
+	delete(s.store, challenge)
+	return c, nil
+}
+
+// 有効期限切れのチャレンジを最大limit件削除し、削除した数を返す
+func (s *PasskeyChallengeStorage) PurgeExpired(now time.Time, limit int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	purged := 0
+	for key, c := range s.store {
+		if purged >= limit {
+			break
+		}
+		if c.IsExpired(now) {
+			delete(s.store, key)
+			purged++
+		}
+	}
+	return purged, nil
+}
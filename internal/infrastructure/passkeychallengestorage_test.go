@@ -0,0 +1,56 @@
+package infrastructure
+
+import (
+	"errors"
+	"oauth-tutorial/internal/infrastructure/dto"
+	"testing"
+	"time"
+)
+
+func Test_パスキーのチャレンジ(t *testing.T) {
+	t.Run("1回のみ取得できる", func(t *testing.T) {
+		// given
+		s := NewPasskeyChallengeStorage()
+		challenge := dto.NewPasskeyLoginChallenge("challenge-1", "session-1", "tx-1", time.Now())
+		if err := s.Save(challenge); err != nil {
+			t.Fatalf("Save() error = %v", err)
+		}
+
+		// when
+		actual, err := s.Consume("challenge-1")
+
+		// then
+		if err != nil || actual != challenge {
+			t.Fatalf("Consume() = %v, %v, want %v", actual, err, challenge)
+		}
+		if _, err := s.Consume("challenge-1"); !errors.Is(err, ErrPasskeyChallengeNotFound) {
+			t.Errorf("Consume() error = %v, want %v", err, ErrPasskeyChallengeNotFound)
+		}
+	})
+
+	t.Run("空のチャレンジは保存できない", func(t *testing.T) {
+		s := NewPasskeyChallengeStorage()
+		if err := s.Save(dto.NewPasskeyRegistrationChallenge("", "session-1", "user-1", time.Now())); !errors.Is(err, ErrInvalidPasskeyChallenge) {
+			t.Errorf("Save() error = %v, want %v", err, ErrInvalidPasskeyChallenge)
+		}
+	})
+
+	t.Run("有効期限切れのチャレンジを削除する", func(t *testing.T) {
+		// given
+		s := NewPasskeyChallengeStorage()
+		now := time.Now()
+		_ = s.Save(dto.NewPasskeyLoginChallenge("expired", "session-1", "tx-1", now.Add(-dto.PASSKEY_CHALLENGE_DURATION-time.Second)))
+		_ = s.Save(dto.NewPasskeyRegistrationChallenge("valid", "session-1", "user-1", now))
+
+		// when
+		purged, err := s.PurgeExpired(now, 10)
+
+		// then
+		if err != nil || purged != 1 {
+			t.Errorf("PurgeExpired() = %d, %v, want 1", purged, err)
+		}
+		if _, err := s.Consume("valid"); err != nil {
+			t.Errorf("Consume() error = %v", err)
+		}
+	})
+}
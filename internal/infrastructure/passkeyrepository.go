@@ -0,0 +1,76 @@
+package infrastructure
+
+import (
+	"errors"
+	"oauth-tutorial/internal/domain"
+	"slices"
+	"sync"
+)
+
+var ErrPasskeyCredentialNotFound = errors.New("passkey credential not found")
+
+type PasskeyRepository struct {
+	// クレデンシャルIDをキーとする
+	store map[string]*domain.PasskeyCredential
+	mu    sync.RWMutex
+}
+
+var _ PasskeyStore = (*PasskeyRepository)(nil)
+
+func NewPasskeyRepository() *PasskeyRepository {
+	return &PasskeyRepository{
+		store: make(map[string]*domain.PasskeyCredential),
+	}
+}
+
+func (r *PasskeyRepository) Save(credential *domain.PasskeyCredential) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.store[string(credential.CredentialID())] = credential
+	return nil
+}
+
+func (r *PasskeyRepository) FindByCredentialID(credentialID []byte) (*domain.PasskeyCredential, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	credential, ok := r.store[string(credentialID)]
+	if !ok {
+		return nil, ErrPasskeyCredentialNotFound
+	}
+	return credential, nil
+}
+
+func (r *PasskeyRepository) FindByUserID(userID string) ([]*domain.PasskeyCredential, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	credentials := make([]*domain.PasskeyCredential, 0)
+	for _, credential := range r.store {
+		if credential.UserID() == userID {
+			credentials = append(credentials, credential)
+		}
+	}
+	slices.SortFunc(credentials, func(a, b *domain.PasskeyCredential) int {
+		return a.CreatedAt().Compare(b.CreatedAt())
+	})
+	return credentials, nil
+}
+
+// スナップショットのために全ての登録を書き出す
+func (r *PasskeyRepository) Export() []PasskeyCredentialRecord {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	records := make([]PasskeyCredentialRecord, 0, len(r.store))
+	for _, credential := range r.store {
+		records = append(records, newPasskeyCredentialRecord(credential))
+	}
+	return records
+}
+
+// スナップショットから書き出した登録を取り込む。同じクレデンシャルIDの登録は上書きする
+func (r *PasskeyRepository) Import(records []PasskeyCredentialRecord) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, record := range records {
+		r.store[string(record.CredentialID)] = record.reconstruct()
+	}
+}
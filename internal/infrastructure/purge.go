@@ -0,0 +1,156 @@
+package infrastructure
+
+import (
+	"maps"
+	"oauth-tutorial/pkg/mylogger"
+	"sync"
+	"time"
+)
+
+// 有効期限切れのデータを削除する対象
+// Purgeは有効期限切れのデータを最大limit件削除し、削除した数を返す
+type PurgeTarget struct {
+	Name  string
+	Purge func(now time.Time, limit int) (int, error)
+}
+
+// 1回の削除処理の結果
+type PurgeResult struct {
+	// 対象毎の削除した数
+	Purged  map[string]int
+	Elapsed time.Duration
+	// 削除に失敗した対象の数
+	Failures int
+}
+
+// 削除処理の累計の統計情報
+type PurgeStats struct {
+	Runs     uint64 `json:"runs"`
+	Failures uint64 `json:"failures"`
+	// 直近の削除処理の基準時刻と所要時間(ナノ秒)
+	LastRunAt   time.Time     `json:"last_run_at"`
+	LastElapsed time.Duration `json:"last_elapsed"`
+	// 対象毎の削除した数の累計
+	Purged map[string]uint64 `json:"purged"`
+}
+
+// 有効期限切れのデータを対象毎にbatchSize件ずつ削除する
+// 1回の削除毎にロックを解放するため、削除の間も他のリクエストを処理できる
+type Purger struct {
+	logger    mylogger.Logger
+	batchSize int
+	targets   []PurgeTarget
+
+	// 同時に複数の削除処理を実行しないためのロック
+	runMu sync.Mutex
+	mu    sync.Mutex
+	stats PurgeStats
+}
+
+func NewPurger(logger mylogger.Logger, batchSize int, targets ...PurgeTarget) *Purger {
+	return &Purger{
+		logger:    logger,
+		batchSize: batchSize,
+		targets:   targets,
+		stats:     PurgeStats{Purged: map[string]uint64{}},
+	}
+}
+
+// 全ての対象から時刻nowの時点で有効期限切れのデータを削除する
+// 削除に失敗した対象があっても、残りの対象の削除は続ける
+func (p *Purger) Run(now time.Time) PurgeResult {
+	p.runMu.Lock()
+	defer p.runMu.Unlock()
+
+	start := time.Now()
+	result := PurgeResult{Purged: make(map[string]int, len(p.targets))}
+	for _, target := range p.targets {
+		purged, err := p.purge(target, now)
+		result.Purged[target.Name] = purged
+		if err != nil {
+			result.Failures++
+			p.logger.Error("failed to purge expired entries", "target", target.Name, "purged", purged, "err", err)
+		}
+	}
+	result.Elapsed = time.Since(start)
+
+	p.mu.Lock()
+	p.stats.Runs++
+	p.stats.Failures += uint64(result.Failures)
+	p.stats.LastRunAt = now
+	p.stats.LastElapsed = result.Elapsed
+	for name, purged := range result.Purged {
+		p.stats.Purged[name] += uint64(purged)
+	}
+	p.mu.Unlock()
+
+	args := []any{"elapsed", result.Elapsed, "failures", result.Failures}
+	for _, target := range p.targets {
+		args = append(args, target.Name, result.Purged[target.Name])
+	}
+	p.logger.Info("purged expired entries", args...)
+	return result
+}
+
+// 削除した数がbatchSize未満になるまで繰り返し削除する
+func (p *Purger) purge(target PurgeTarget, now time.Time) (int, error) {
+	total := 0
+	for {
+		n, err := target.Purge(now, p.batchSize)
+		total += n
+		if err != nil || n < p.batchSize {
+			return total, err
+		}
+	}
+}
+
+func (p *Purger) Stats() PurgeStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	stats := p.stats
+	stats.Purged = maps.Clone(p.stats.Purged)
+	return stats
+}
+
+// 有効期限切れの要素のキーを最大limit件集める。呼び出し側は読み取りロックを取得しておく
+func collectExpiredKeys[K comparable, V any](m map[K]V, limit int, expired func(V) bool) []K {
+	keys := make([]K, 0, min(limit, len(m)))
+	for key, value := range m {
+		if len(keys) >= limit {
+			break
+		}
+		if expired(value) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// 集めたキーのうち、まだ有効期限切れのままの要素を削除し、削除した数を返す。呼び出し側は書き込みロックを取得しておく
+func deleteExpiredKeys[K comparable, V any](m map[K]V, keys []K, expired func(V) bool) int {
+	deleted := 0
+	for _, key := range keys {
+		if value, ok := m[key]; ok && expired(value) {
+			delete(m, key)
+			deleted++
+		}
+	}
+	return deleted
+}
+
+// 有効期限切れの要素を最大limit件削除し、削除した数を返す
+// passは残りの件数を上限に要素を集めて削除し、集めた数と削除した数を返す
+// 集めてから削除するまでの間に更新・削除された要素は数えないため、集めた数が上限に達した間は繰り返し、
+// 残りがあるのにlimit件未満を返してPurgerが削除を打ち切らないようにする
+func purgeExpiredKeys(limit int, pass func(limit int) (collected, deleted int)) int {
+	purged := 0
+	for purged < limit {
+		remaining := limit - purged
+		collected, deleted := pass(remaining)
+		purged += deleted
+		if collected < remaining {
+			break
+		}
+	}
+	return purged
+}
@@ -0,0 +1,169 @@
+package infrastructure
+
+import (
+	"errors"
+	"oauth-tutorial/pkg/mylogger"
+	"testing"
+	"time"
+)
+
+// 指定した数のデータを持ち、呼び出し毎の削除数を記録する削除対象
+type fakePurgeTarget struct {
+	remaining int
+	batches   []int
+	err       error
+}
+
+func (f *fakePurgeTarget) purge(now time.Time, limit int) (int, error) {
+	if f.err != nil {
+		return 0, f.err
+	}
+	n := min(f.remaining, limit)
+	f.remaining -= n
+	f.batches = append(f.batches, n)
+	return n, nil
+}
+
+func Test_有効期限切れのデータの削除(t *testing.T) {
+	tests := []struct {
+		name            string
+		remaining       int
+		err             error
+		expectedBatches []int
+		expectedPurged  int
+	}{
+		{
+			name:            "正常系 - 削除した数がバッチサイズ未満になるまで繰り返す",
+			remaining:       7,
+			expectedBatches: []int{3, 3, 1},
+			expectedPurged:  7,
+		},
+		{
+			name:            "正常系 - バッチサイズちょうどの場合は空になったことを確認する",
+			remaining:       3,
+			expectedBatches: []int{3, 0},
+			expectedPurged:  3,
+		},
+		{
+			name:            "正常系 - 削除するデータがない",
+			expectedBatches: []int{0},
+		},
+		{
+			name: "異常系 - 削除に失敗",
+			err:  errors.New("storage error"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// given
+			target := &fakePurgeTarget{remaining: tt.remaining, err: tt.err}
+			// 失敗した対象があっても他の対象の削除は続ける
+			other := &fakePurgeTarget{remaining: 2}
+			purger := NewPurger(mylogger.NewMockLogger(), 3,
+				PurgeTarget{Name: "target", Purge: target.purge},
+				PurgeTarget{Name: "other", Purge: other.purge})
+
+			// when
+			result := purger.Run(time.Now())
+
+			// then
+			if len(target.batches) != len(tt.expectedBatches) {
+				t.Errorf("batches = %v, want %v", target.batches, tt.expectedBatches)
+			}
+			for i := range min(len(target.batches), len(tt.expectedBatches)) {
+				if target.batches[i] != tt.expectedBatches[i] {
+					t.Errorf("batches = %v, want %v", target.batches, tt.expectedBatches)
+					break
+				}
+			}
+			if result.Purged["target"] != tt.expectedPurged || result.Purged["other"] != 2 {
+				t.Errorf("Purged = %v, want target=%d other=2", result.Purged, tt.expectedPurged)
+			}
+			expectedFailures := 0
+			if tt.err != nil {
+				expectedFailures = 1
+			}
+			if result.Failures != expectedFailures {
+				t.Errorf("Failures = %d, want %d", result.Failures, expectedFailures)
+			}
+		})
+	}
+}
+
+func Test_削除処理の統計情報(t *testing.T) {
+	// given
+	target := &fakePurgeTarget{remaining: 5}
+	purger := NewPurger(mylogger.NewMockLogger(), 2, PurgeTarget{Name: "target", Purge: target.purge})
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// when
+	purger.Run(now.Add(-time.Minute))
+	target.remaining = 1
+	purger.Run(now)
+
+	// then
+	stats := purger.Stats()
+	if stats.Runs != 2 || stats.Failures != 0 || !stats.LastRunAt.Equal(now) || stats.Purged["target"] != 6 {
+		t.Errorf("Stats() = %+v, want Runs=2 Failures=0 LastRunAt=%v Purged[target]=6", stats, now)
+	}
+	// 返した統計情報を変更しても内部の状態に影響しない
+	stats.Purged["target"] = 0
+	if purger.Stats().Purged["target"] != 6 {
+		t.Error("Stats() should return a copy")
+	}
+}
+
+func Test_集めたキーのうち有効期限切れのままのものだけを削除する(t *testing.T) {
+	// given
+	expiresAt := map[string]int{"expired": 1, "renewed": 1, "valid": 10}
+	expired := func(v int) bool { return v < 5 }
+	keys := collectExpiredKeys(expiresAt, 10, expired)
+	// 集めてから削除するまでの間に更新された
+	expiresAt["renewed"] = 10
+
+	// when
+	deleted := deleteExpiredKeys(expiresAt, keys, expired)
+
+	// then
+	if len(keys) != 2 {
+		t.Errorf("collectExpiredKeys() = %v, want 2 keys", keys)
+	}
+	if deleted != 1 {
+		t.Errorf("deleteExpiredKeys() = %d, want 1", deleted)
+	}
+	if _, ok := expiresAt["renewed"]; !ok {
+		t.Error("更新されたキーが削除された")
+	}
+	if _, ok := expiresAt["valid"]; !ok {
+		t.Error("有効期限内のキーが削除された")
+	}
+}
+
+func Test_集めてから削除するまでに更新された要素があっても残りがある間はlimit件削除したと返す(t *testing.T) {
+	// given
+	// 2種類の要素を同じ上限で削除する
+	unused := map[string]int{"expired1": 1, "renewed": 1}
+	used := map[string]int{"expired2": 1, "expired3": 1, "valid": 10}
+	expired := func(v int) bool { return v < 5 }
+	pass := func(limit int) (int, int) {
+		unusedKeys := collectExpiredKeys(unused, limit, expired)
+		usedKeys := collectExpiredKeys(used, limit-len(unusedKeys), expired)
+		// 集めてから削除するまでの間に更新された
+		if _, ok := unused["renewed"]; ok {
+			unused["renewed"] = 10
+		}
+		return len(unusedKeys) + len(usedKeys), deleteExpiredKeys(unused, unusedKeys, expired) + deleteExpiredKeys(used, usedKeys, expired)
+	}
+
+	// when
+	purged := []int{purgeExpiredKeys(2, pass), purgeExpiredKeys(2, pass)}
+
+	// then
+	if purged[0] != 2 || purged[1] != 1 {
+		t.Errorf("purgeExpiredKeys() = %v, want [2 1]", purged)
+	}
+	if len(unused) != 1 || len(used) != 1 {
+		t.Errorf("remaining = %v %v, want only renewed and valid", unused, used)
+	}
+}
@@ -4,21 +4,65 @@ import (
 	"errors"
 	"oauth-tutorial/internal/infrastructure/dto"
 	"oauth-tutorial/internal/session"
+	"sync"
+	"time"
 )
 
-type SessionStorage struct{}
-
-func NewSessionStorage() *SessionStorage {
-	return &SessionStorage{}
-}
-
 var (
-	sessionStore          = map[session.SessionID]dto.SessionData{}
 	ErrInvalidSessionID   = errors.New("sessionID is required")
 	ErrInvalidSessionData = errors.New("invalid session data")
 	ErrSessionNotFound    = errors.New("session not found")
 )
 
+// ブラウザセッションを保持するインメモリのストレージ
+// 最後にアクセスされてからidleTimeoutを過ぎたセッションと、作成からabsoluteTimeoutを過ぎたセッションは無効になる
+type SessionStorage struct {
+	store map[session.SessionID]*sessionEntry
+	mu    sync.Mutex
+	// 最終アクセスからの有効期間。0以下の場合は無期限
+	idleTimeout time.Duration
+	// 作成からの有効期間。0以下の場合は無期限
+	absoluteTimeout time.Duration
+	stats           SessionStats
+	now             func() time.Time
+}
+
+var _ SessionStore = (*SessionStorage)(nil)
+
+type sessionEntry struct {
+	data           dto.SessionData
+	createdAt      time.Time
+	lastAccessedAt time.Time
+}
+
+// セッションの統計情報
+type SessionStats struct {
+	// 保持しているセッションの数
+	Live int `json:"live"`
+	// 作成されたセッションの累計
+	Created uint64 `json:"created"`
+	// 明示的に削除されたセッションの累計
+	Deleted uint64 `json:"deleted"`
+	// 最終アクセスからの有効期間切れで破棄したセッションの累計
+	IdleEvictions uint64 `json:"idle_evictions"`
+	// 作成からの有効期間切れで破棄したセッションの累計
+	AbsoluteEvictions uint64 `json:"absolute_evictions"`
+}
+
+func NewSessionStorage(idleTimeout time.Duration, absoluteTimeout time.Duration) *SessionStorage {
+	return NewSessionStorageWithClock(idleTimeout, absoluteTimeout, time.Now)
+}
+
+// 有効期間の判定に使う現在時刻をnowで差し替える
+func NewSessionStorageWithClock(idleTimeout time.Duration, absoluteTimeout time.Duration, now func() time.Time) *SessionStorage {
+	return &SessionStorage{
+		store:           make(map[session.SessionID]*sessionEntry),
+		idleTimeout:     idleTimeout,
+		absoluteTimeout: absoluteTimeout,
+		now:             now,
+	}
+}
+
 func (s *SessionStorage) Save(sessionID session.SessionID, sessiondata *dto.SessionData) error {
 	if sessionID == "" {
 		return ErrInvalidSessionID
@@ -27,15 +71,34 @@ func (s *SessionStorage) Save(sessionID session.SessionID, sessiondata *dto.Sess
 		return ErrInvalidSessionData
 	}
 
-	sessionStore[sessionID] = *sessiondata
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	// 既存のセッションを上書きする場合も、作成日時は引き継いで有効期間を延長しない
+	if entry, ok := s.store[sessionID]; ok && !s.expired(entry, now) {
+		entry.data = *sessiondata
+		entry.lastAccessedAt = now
+		return nil
+	}
+	s.store[sessionID] = &sessionEntry{data: *sessiondata, createdAt: now, lastAccessedAt: now}
+	s.stats.Created++
 	return nil
 }
 
 func (s *SessionStorage) Get(sessionID session.SessionID) (*dto.SessionData, error) {
-	sessionData, ok := sessionStore[sessionID]
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.store[sessionID]
 	if !ok {
 		return nil, ErrSessionNotFound
 	}
+	now := s.now()
+	if s.expired(entry, now) {
+		s.evict(sessionID, entry, now)
+		return nil, ErrSessionNotFound
+	}
+	entry.lastAccessedAt = now
+	sessionData := entry.data
 	return &sessionData, nil
 }
 
@@ -44,6 +107,88 @@ func (s *SessionStorage) Delete(sessionID session.SessionID) error {
 		return ErrInvalidSessionID
 	}
 
-	delete(sessionStore, sessionID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.store[sessionID]; ok {
+		delete(s.store, sessionID)
+		s.stats.Deleted++
+	}
 	return nil
 }
+
+// 有効期間切れのセッションを最大limit件破棄し、破棄した数を返す
+func (s *SessionStorage) Sweep(limit int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	evicted := 0
+	for sessionID, entry := range s.store {
+		if evicted >= limit {
+			break
+		}
+		if s.expired(entry, now) {
+			s.evict(sessionID, entry, now)
+			evicted++
+		}
+	}
+	return evicted, nil
+}
+
+// セッションの統計情報を返す
+func (s *SessionStorage) Stats() (SessionStats, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := s.stats
+	stats.Live = len(s.store)
+	return stats, nil
+}
+
+func (s *SessionStorage) expired(entry *sessionEntry, now time.Time) bool {
+	return s.absoluteExpired(entry, now) || (s.idleTimeout > 0 && now.Sub(entry.lastAccessedAt) >= s.idleTimeout)
+}
+
+func (s *SessionStorage) absoluteExpired(entry *sessionEntry, now time.Time) bool {
+	return s.absoluteTimeout > 0 && now.Sub(entry.createdAt) >= s.absoluteTimeout
+}
+
+// 呼び出し元でロックを取得していること
+func (s *SessionStorage) evict(sessionID session.SessionID, entry *sessionEntry, now time.Time) {
+	delete(s.store, sessionID)
+	if s.absoluteExpired(entry, now) {
+		s.stats.AbsoluteEvictions++
+		return
+	}
+	s.stats.IdleEvictions++
+}
+
+// スナップショットのために有効期間内の全てのセッションを書き出す
+func (s *SessionStorage) Export() []SessionRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	records := make([]SessionRecord, 0, len(s.store))
+	for sessionID, entry := range s.store {
+		if s.expired(entry, now) {
+			continue
+		}
+		records = append(records, newSessionRecord(sessionID, entry))
+	}
+	return records
+}
+
+// スナップショットから書き出したセッションを取り込む。有効期間切れのものは取り込まず、その数を返す
+// 作成日時と最終アクセス日時は引き継ぐため、取り込んでも有効期間は延長しない
+func (s *SessionStorage) Import(records []SessionRecord) (skipped int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	for _, record := range records {
+		entry := record.reconstruct()
+		if record.SessionID == "" || s.expired(entry, now) {
+			skipped++
+			continue
+		}
+		s.store[session.SessionID(record.SessionID)] = entry
+	}
+	return skipped
+}
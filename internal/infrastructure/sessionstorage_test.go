@@ -1,28 +1,20 @@
 package infrastructure
 
 import (
+	"fmt"
 	"oauth-tutorial/internal/domain"
 	"oauth-tutorial/internal/infrastructure/dto"
 	"oauth-tutorial/internal/session"
-	"oauth-tutorial/pkg/mylogger"
+	"reflect"
+	"sync"
 	"testing"
+	"time"
 )
 
-func Test_認可リクエストパラメータの保存(t *testing.T) {
-	logger := mylogger.NewLogger()
-
-	// テスト用のAuthorizationCodeFlowParamを作成
-	validParam, err := domain.NewAuthorizationCodeFlowParam(
-		logger,
-		"code",
-		"test-client",
-		"https://example.com/callback",
-		"read write",
-		"test-state",
-	)
-	if err != nil {
-		t.Fatalf("Failed to create valid AuthorizationCodeFlowParam: %v", err)
-	}
+func Test_セッションの保存(t *testing.T) {
+	// テスト用のログイン済みセッションを作成
+	validUser := domain.ReconstructUser("test-user-id", "test-user@example.com", "password")
+	validSessionData := dto.NewSessionData(validUser, time.Now(), []string{"pwd"})
 
 	tests := []struct {
 		name        string
@@ -35,64 +27,54 @@ func Test_認可リクエストパラメータの保存(t *testing.T) {
 		{
 			name:        "正常ケース - 新しいセッションの保存",
 			sessionID:   session.SessionID("test-session-id"),
-			sessiondata: dto.NewSessionData(validParam, nil),
+			sessiondata: validSessionData,
 			expectedErr: nil,
 			setupFunc: func(ss *SessionStorage) {
-				sessionStore = make(map[session.SessionID]dto.SessionData)
 			},
 			checkFunc: func(t *testing.T, ss *SessionStorage, sessionID session.SessionID) {
 				// 正しいキーで保存されていること
-				if _, exists := sessionStore[sessionID]; !exists {
+				if _, exists := ss.store[sessionID]; !exists {
 					t.Error("authParam should be saved with correct sessionID")
 				}
-				if len(sessionStore) != 1 {
-					t.Errorf("sessionStore length = %d, want 1", len(sessionStore))
+				if len(ss.store) != 1 {
+					t.Errorf("sessionStore length = %d, want 1", len(ss.store))
 				}
 			},
 		},
 		{
 			name:        "正常ケース - 既存セッションの上書き",
 			sessionID:   "existing-session",
-			sessiondata: dto.NewSessionData(validParam, nil),
+			sessiondata: validSessionData,
 			expectedErr: nil,
 			setupFunc: func(ss *SessionStorage) {
-				sessionStore = make(map[session.SessionID]dto.SessionData)
-				oldParam, _ := domain.NewAuthorizationCodeFlowParam(
-					logger,
-					"code",
-					"old-client",
-					"https://old.com/callback",
-					"read",
-					"old-state",
-				)
-				sessionStore[session.SessionID("existing-session")] = *dto.NewSessionData(oldParam, nil)
+				oldUser := domain.ReconstructUser("old-user-id", "old-user@example.com", "password")
+				ss.store[session.SessionID("existing-session")] = &sessionEntry{data: *dto.NewSessionData(oldUser, time.Now(), []string{"pwd"})}
 			},
 			checkFunc: func(t *testing.T, ss *SessionStorage, sessionID session.SessionID) {
 				// 上書きされていること
-				if _, exists := sessionStore[sessionID]; !exists {
+				if _, exists := ss.store[sessionID]; !exists {
 					t.Error("authParam should be saved with correct sessionID")
 				}
 				// 新しい値で上書きされていること
-				saved := sessionStore[sessionID]
-				if saved.AuthParam().ClientID() != validParam.ClientID() {
-					t.Errorf("saved ClientID = %s, want %s", saved.AuthParam().ClientID(), validParam.ClientID())
+				saved := ss.store[sessionID].data
+				if saved.User().UserID() != validUser.UserID() {
+					t.Errorf("saved UserID = %s, want %s", saved.User().UserID(), validUser.UserID())
 				}
 			},
 		},
 		{
 			name:        "異常ケース - 空のセッションID",
 			sessionID:   session.SessionID(""),
-			sessiondata: dto.NewSessionData(validParam, nil),
+			sessiondata: validSessionData,
 			expectedErr: ErrInvalidSessionID,
 			setupFunc: func(ss *SessionStorage) {
-				sessionStore = make(map[session.SessionID]dto.SessionData)
 			},
 			checkFunc: func(t *testing.T, ss *SessionStorage, sessionID session.SessionID) {
-				if _, exists := sessionStore[sessionID]; exists {
+				if _, exists := ss.store[sessionID]; exists {
 					t.Error("sessionStore should not have entry for empty sessionID")
 				}
-				if len(sessionStore) != 0 {
-					t.Errorf("sessionStore length = %d, want 0", len(sessionStore))
+				if len(ss.store) != 0 {
+					t.Errorf("sessionStore length = %d, want 0", len(ss.store))
 				}
 			},
 		},
@@ -102,14 +84,13 @@ func Test_認可リクエストパラメータの保存(t *testing.T) {
 			sessiondata: nil,
 			expectedErr: ErrInvalidSessionData,
 			setupFunc: func(ss *SessionStorage) {
-				sessionStore = make(map[session.SessionID]dto.SessionData)
 			},
 			checkFunc: func(t *testing.T, ss *SessionStorage, sessionID session.SessionID) {
-				if _, exists := sessionStore[sessionID]; exists {
+				if _, exists := ss.store[sessionID]; exists {
 					t.Error("sessionStore should not have entry for empty sessionID")
 				}
-				if len(sessionStore) != 0 {
-					t.Errorf("sessionStore length = %d, want 0", len(sessionStore))
+				if len(ss.store) != 0 {
+					t.Errorf("sessionStore length = %d, want 0", len(ss.store))
 				}
 			},
 		},
@@ -117,15 +98,15 @@ func Test_認可リクエストパラメータの保存(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			session := NewSessionStorage()
+			ss := NewSessionStorage(0, 0)
 
 			// given
 			if tt.setupFunc != nil {
-				tt.setupFunc(session)
+				tt.setupFunc(ss)
 			}
 
 			// when
-			err := session.Save(tt.sessionID, tt.sessiondata)
+			err := ss.Save(tt.sessionID, tt.sessiondata)
 
 			// then
 			if tt.expectedErr != nil {
@@ -142,34 +123,21 @@ func Test_認可リクエストパラメータの保存(t *testing.T) {
 
 			// 追加のチェック
 			if tt.checkFunc != nil {
-				tt.checkFunc(t, session, tt.sessionID)
+				tt.checkFunc(t, ss, tt.sessionID)
 			}
 		})
 	}
 }
 
-func Test_認可リクエストパラメータの取得(t *testing.T) {
-	ss := NewSessionStorage()
-	logger := mylogger.NewLogger()
-
-	// sessionStoreを初期化（他のテストの影響を避けるため）
-	sessionStore = make(map[session.SessionID]dto.SessionData)
-
-	// テスト用のAuthorizationCodeFlowParamを作成・保存
-	param, err := domain.NewAuthorizationCodeFlowParam(
-		logger,
-		"code",
-		"test-client",
-		"https://example.com/callback",
-		"read write",
-		"test-state",
-	)
-	if err != nil {
-		t.Fatalf("Failed to create AuthorizationCodeFlowParam: %v", err)
-	}
+func Test_セッションの取得(t *testing.T) {
+	ss := NewSessionStorage(0, 0)
+
+	// テスト用のログイン済みセッションを作成・保存
+	user := domain.ReconstructUser("test-user-id", "test-user@example.com", "password")
+	authTime := time.Date(2000, 1, 2, 3, 4, 5, 0, time.UTC)
 
 	sessionID := session.SessionID("test-session-id")
-	err = ss.Save(sessionID, dto.NewSessionData(param, nil))
+	err := ss.Save(sessionID, dto.NewSessionData(user, authTime, []string{"pwd"}))
 	if err != nil {
 		t.Fatalf("Save() error = %v", err)
 	}
@@ -227,22 +195,21 @@ func Test_認可リクエストパラメータの取得(t *testing.T) {
 					return
 				}
 				// 値が正しく取得できることを確認
-				if result.AuthParam().ClientID() != param.ClientID() {
-					t.Errorf("Get() result.ClientID() = %v, want %v", result.AuthParam().ClientID(), param.ClientID())
+				if result.User().UserID() != user.UserID() {
+					t.Errorf("Get() result.User().UserID() = %v, want %v", result.User().UserID(), user.UserID())
 				}
-				if result.AuthParam().RedirectURI() != param.RedirectURI() {
-					t.Errorf("Get() result.RedirectURI() = %v, want %v", result.AuthParam().RedirectURI(), param.RedirectURI())
+				if !result.AuthTime().Equal(authTime) {
+					t.Errorf("Get() result.AuthTime() = %v, want %v", result.AuthTime(), authTime)
 				}
-				if result.AuthParam().State() != param.State() {
-					t.Errorf("Get() result.State() = %v, want %v", result.AuthParam().State(), param.State())
+				if !reflect.DeepEqual(result.AMR(), []string{"pwd"}) {
+					t.Errorf("Get() result.AMR() = %v, want %v", result.AMR(), []string{"pwd"})
 				}
 			}
 		})
 	}
 }
 
-func Test_認可リクエストパラメータの削除(t *testing.T) {
-	logger := mylogger.NewLogger()
+func Test_セッションのクリア(t *testing.T) {
 
 	tests := []struct {
 		name      string
@@ -252,33 +219,19 @@ func Test_認可リクエストパラメータの削除(t *testing.T) {
 		{
 			name: "正常系 - 既存セッションの削除",
 			setupFunc: func(ss *SessionStorage) {
-				sessionStore = make(map[session.SessionID]dto.SessionData)
-				param, err := domain.NewAuthorizationCodeFlowParam(
-					logger,
-					"code",
-					"test-client",
-					"https://example.com/callback",
-					"read write",
-					"test-state",
-				)
-				if err != nil {
-					t.Fatalf("Failed to create AuthorizationCodeFlowParam: %v", err)
-				}
-				sessionStore[session.SessionID("test-session-id")] = *dto.NewSessionData(param, nil)
+				ss.store[session.SessionID("test-session-id")] = &sessionEntry{data: *dto.NewSessionData(nil, time.Time{}, nil)}
 			},
 			sessionID: session.SessionID("test-session-id"),
 		},
 		{
 			name: "異常系 - 存在しないセッションの削除",
 			setupFunc: func(ss *SessionStorage) {
-				sessionStore = make(map[session.SessionID]dto.SessionData)
 			},
 			sessionID: session.SessionID("non-existing-session"),
 		},
 		{
 			name: "異常系 - 空のセッションIDの削除",
 			setupFunc: func(ss *SessionStorage) {
-				sessionStore = make(map[session.SessionID]dto.SessionData)
 			},
 			sessionID: session.SessionID(""),
 		},
@@ -286,11 +239,11 @@ func Test_認可リクエストパラメータの削除(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			session := NewSessionStorage()
-			tt.setupFunc(session)
+			ss := NewSessionStorage(0, 0)
+			tt.setupFunc(ss)
 
-			session.Delete(tt.sessionID)
-			afterLen := len(sessionStore)
+			ss.Delete(tt.sessionID)
+			afterLen := len(ss.store)
 
 			if afterLen != 0 {
 				t.Errorf("after Clear: sessionStore length = %d, want 0", afterLen)
@@ -300,7 +253,6 @@ func Test_認可リクエストパラメータの削除(t *testing.T) {
 }
 
 func Test_セッションの削除(t *testing.T) {
-	logger := mylogger.NewMockLogger()
 
 	tests := []struct {
 		name        string
@@ -312,16 +264,7 @@ func Test_セッションの削除(t *testing.T) {
 		{
 			name: "正常系 - 既存セッションの削除",
 			setupFunc: func(ss *SessionStorage) {
-				sessionStore = make(map[session.SessionID]dto.SessionData)
-				param, _ := domain.NewAuthorizationCodeFlowParam(
-					logger,
-					"code",
-					"test-client",
-					"https://example.com/callback",
-					"read write",
-					"test-state",
-				)
-				sessionStore[session.SessionID("delete-session-id")] = *dto.NewSessionData(param, nil)
+				ss.store[session.SessionID("delete-session-id")] = &sessionEntry{data: *dto.NewSessionData(nil, time.Time{}, nil)}
 			},
 			sessionID:   session.SessionID("delete-session-id"),
 			expectExist: false,
@@ -330,7 +273,6 @@ func Test_セッションの削除(t *testing.T) {
 		{
 			name: "異常系 - 存在しないセッションの削除",
 			setupFunc: func(ss *SessionStorage) {
-				sessionStore = make(map[session.SessionID]dto.SessionData)
 			},
 			sessionID:   session.SessionID("not-exist-session"),
 			expectExist: false,
@@ -339,7 +281,6 @@ func Test_セッションの削除(t *testing.T) {
 		{
 			name: "異常系 - 空のセッションIDの削除",
 			setupFunc: func(ss *SessionStorage) {
-				sessionStore = make(map[session.SessionID]dto.SessionData)
 			},
 			sessionID:   session.SessionID(""),
 			expectExist: false,
@@ -349,12 +290,12 @@ func Test_セッションの削除(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			ss := NewSessionStorage()
+			ss := NewSessionStorage(0, 0)
 			tt.setupFunc(ss)
 
 			err := ss.Delete(tt.sessionID)
 
-			if _, exists := sessionStore[tt.sessionID]; exists != tt.expectExist {
+			if _, exists := ss.store[tt.sessionID]; exists != tt.expectExist {
 				t.Errorf("sessionStore existence after Delete = %v, want %v", exists, tt.expectExist)
 			}
 			if err != nil && err != tt.expectErr {
@@ -366,3 +307,191 @@ func Test_セッションの削除(t *testing.T) {
 		})
 	}
 }
+
+func Test_セッションの有効期間(t *testing.T) {
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	sessionID := session.SessionID("test-session-id")
+
+	tests := []struct {
+		name            string
+		idleTimeout     time.Duration
+		absoluteTimeout time.Duration
+		// 保存後にGetする時刻(保存時刻からの経過時間)
+		accesses  []time.Duration
+		expectErr bool
+		wantStats SessionStats
+	}{
+		{
+			name:        "正常系 - 最終アクセスから有効期間内",
+			idleTimeout: 30 * time.Minute,
+			accesses:    []time.Duration{20 * time.Minute, 40 * time.Minute},
+			expectErr:   false,
+			wantStats:   SessionStats{Live: 1, Created: 1},
+		},
+		{
+			name:        "異常系 - 最終アクセスから有効期間切れ",
+			idleTimeout: 30 * time.Minute,
+			accesses:    []time.Duration{30 * time.Minute},
+			expectErr:   true,
+			wantStats:   SessionStats{Live: 0, Created: 1, IdleEvictions: 1},
+		},
+		{
+			name:            "異常系 - アクセスし続けても作成から有効期間切れ",
+			idleTimeout:     30 * time.Minute,
+			absoluteTimeout: time.Hour,
+			accesses:        []time.Duration{20 * time.Minute, 40 * time.Minute, 60 * time.Minute},
+			expectErr:       true,
+			wantStats:       SessionStats{Live: 0, Created: 1, AbsoluteEvictions: 1},
+		},
+		{
+			name:      "正常系 - 有効期間の指定なし",
+			accesses:  []time.Duration{24 * time.Hour * 365},
+			expectErr: false,
+			wantStats: SessionStats{Live: 1, Created: 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// given
+			ss := NewSessionStorage(tt.idleTimeout, tt.absoluteTimeout)
+			now := base
+			ss.now = func() time.Time { return now }
+			if err := ss.Save(sessionID, dto.NewSessionData(nil, time.Time{}, nil)); err != nil {
+				t.Fatalf("Save() error = %v", err)
+			}
+
+			// when
+			var err error
+			for _, elapsed := range tt.accesses {
+				now = base.Add(elapsed)
+				_, err = ss.Get(sessionID)
+			}
+
+			// then
+			if tt.expectErr && err != ErrSessionNotFound {
+				t.Errorf("Get() error = %v, want %v", err, ErrSessionNotFound)
+			}
+			if !tt.expectErr && err != nil {
+				t.Errorf("Get() error = %v, want nil", err)
+			}
+			if stats, _ := ss.Stats(); stats != tt.wantStats {
+				t.Errorf("Stats() = %+v, want %+v", stats, tt.wantStats)
+			}
+		})
+	}
+}
+
+func Test_上書き保存では作成からの有効期間を延長しない(t *testing.T) {
+	// given
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	ss := NewSessionStorage(0, time.Hour)
+	now := base
+	ss.now = func() time.Time { return now }
+	sessionID := session.SessionID("test-session-id")
+	ss.Save(sessionID, dto.NewSessionData(nil, time.Time{}, nil))
+
+	// when
+	now = base.Add(50 * time.Minute)
+	ss.Save(sessionID, dto.NewSessionData(domain.ReconstructUser("test-user-id", "test-user@example.com", "password"), now, []string{"pwd"}))
+	now = base.Add(time.Hour)
+	_, err := ss.Get(sessionID)
+
+	// then
+	if err != ErrSessionNotFound {
+		t.Errorf("Get() error = %v, want %v", err, ErrSessionNotFound)
+	}
+}
+
+func Test_有効期間切れのセッションの掃除(t *testing.T) {
+	// given
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	ss := NewSessionStorage(30*time.Minute, 0)
+	now := base
+	ss.now = func() time.Time { return now }
+	ss.Save("old-session", dto.NewSessionData(nil, time.Time{}, nil))
+	now = base.Add(20 * time.Minute)
+	ss.Save("new-session", dto.NewSessionData(nil, time.Time{}, nil))
+	ss.Save("deleted-session", dto.NewSessionData(nil, time.Time{}, nil))
+	ss.Delete("deleted-session")
+
+	// when
+	now = base.Add(40 * time.Minute)
+	evicted, err := ss.Sweep(100)
+
+	// then
+	if err != nil || evicted != 1 {
+		t.Errorf("Sweep() = %d, %v, want 1", evicted, err)
+	}
+	if _, exists := ss.store["old-session"]; exists {
+		t.Error("old-session should be evicted")
+	}
+	if _, err := ss.Get("new-session"); err != nil {
+		t.Errorf("Get(new-session) error = %v, want nil", err)
+	}
+	want := SessionStats{Live: 1, Created: 3, Deleted: 1, IdleEvictions: 1}
+	if stats, _ := ss.Stats(); stats != want {
+		t.Errorf("Stats() = %+v, want %+v", stats, want)
+	}
+}
+
+func Test_バックグラウンドでの掃除(t *testing.T) {
+	// given
+	ss := NewSessionStorage(time.Millisecond, 0)
+	ss.Save("test-session-id", dto.NewSessionData(nil, time.Time{}, nil))
+
+	// when
+	stop := RunPeriodically(time.Millisecond, func() { ss.Sweep(100) })
+	defer stop()
+
+	// then
+	deadline := time.Now().Add(time.Second)
+	for stats, _ := ss.Stats(); stats.Live != 0; stats, _ = ss.Stats() {
+		if time.Now().After(deadline) {
+			t.Fatal("session should be evicted by sweeper")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func Test_インスタンス毎のセッション(t *testing.T) {
+	// given
+	ss1 := NewSessionStorage(0, 0)
+	ss2 := NewSessionStorage(0, 0)
+
+	// when
+	ss1.Save("test-session-id", dto.NewSessionData(nil, time.Time{}, nil))
+
+	// then
+	if _, err := ss2.Get("test-session-id"); err != ErrSessionNotFound {
+		t.Errorf("Get() error = %v, want %v", err, ErrSessionNotFound)
+	}
+}
+
+func Test_セッションの並行アクセス(t *testing.T) {
+	// given
+	ss := NewSessionStorage(time.Hour, 0)
+	var wg sync.WaitGroup
+
+	// when
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			sessionID := session.SessionID(fmt.Sprintf("session-%d", i))
+			ss.Save(sessionID, dto.NewSessionData(nil, time.Time{}, nil))
+			ss.Get(sessionID)
+			ss.Sweep(100)
+			if i%2 == 0 {
+				ss.Delete(sessionID)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// then
+	want := SessionStats{Live: 25, Created: 50, Deleted: 25}
+	if stats, _ := ss.Stats(); stats != want {
+		t.Errorf("Stats() = %+v, want %+v", stats, want)
+	}
+}
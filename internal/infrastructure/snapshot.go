@@ -0,0 +1,383 @@
+package infrastructure
+
+import (
+	"oauth-tutorial/internal/domain"
+	"oauth-tutorial/internal/infrastructure/dto"
+	"oauth-tutorial/internal/session"
+	"time"
+)
+
+// スナップショットの形式のバージョン。記録の形式を変更した場合は上げること
+const SnapshotVersion = 1
+
+// インメモリの実装の内容をファイルに保存・復元するための記録
+// 認可リクエストのトランザクションは短命なため含めない
+type Snapshot struct {
+	TakenAt       time.Time            `json:"taken_at"`
+	Clients       []ClientRecord       `json:"clients"`
+	Users         []UserRecord         `json:"users"`
+	AuthCodes     []AuthCodeRecord     `json:"auth_codes"`
+	AccessTokens  []AccessTokenRecord  `json:"access_tokens"`
+	RefreshTokens []RefreshTokenRecord `json:"refresh_tokens"`
+	Consents      []ConsentRecord      `json:"consents"`
+	Sessions      []SessionRecord      `json:"sessions"`
+	// TOTPを追加する前のスナップショットには含まれない
+	TOTPCredentials []TOTPCredentialRecord `json:"totp_credentials,omitempty"`
+	// パスキーを追加する前のスナップショットには含まれない
+	PasskeyCredentials []PasskeyCredentialRecord `json:"passkey_credentials,omitempty"`
+}
+
+type ClientRecord struct {
+	ClientID     string   `json:"client_id"`
+	ClientName   string   `json:"client_name"`
+	ClientType   int      `json:"client_type"`
+	Secret       string   `json:"secret"`
+	RedirectURIs []string `json:"redirect_uris"`
+	// 省略した場合は既定のポリシー
+	Policy *ClientPolicyRecord `json:"policy,omitempty"`
+}
+
+// クライアントのポリシーの記録。grant_typeなどはパラメータの値で記録する
+type ClientPolicyRecord struct {
+	GrantTypes              []string `json:"grant_types"`
+	ResponseTypes           []string `json:"response_types"`
+	Scopes                  []string `json:"scopes,omitempty"`
+	DefaultScopes           []string `json:"default_scopes,omitempty"`
+	TokenEndpointAuthMethod string   `json:"token_endpoint_auth_method,omitempty"`
+	// 有効期間の秒数。0の場合はサーバー全体の有効期間
+	AuthorizationCodeLifetime int64  `json:"authorization_code_lifetime,omitempty"`
+	AccessTokenLifetime       int64  `json:"access_token_lifetime,omitempty"`
+	RefreshTokenLifetime      int64  `json:"refresh_token_lifetime,omitempty"`
+	IssueRefreshToken         bool   `json:"issue_refresh_token"`
+	ApplicationType           string `json:"application_type,omitempty"`
+}
+
+type UserRecord struct {
+	UserID  string `json:"user_id"`
+	LoginID string `json:"login_id"`
+	// 以前のスナップショットを読み込めるよう、キーはpasswordのままにする。値が平文の場合は起動時にハッシュ値に置き換える
+	PasswordHash string `json:"password"`
+	// 上流のIdPのユーザーの場合のみ
+	FederatedIssuer  string `json:"federated_issuer,omitempty"`
+	FederatedSubject string `json:"federated_subject,omitempty"`
+	// 設定ファイルから登録したユーザーの場合のみ。登録に使った設定のパスワードのハッシュ値
+	SeededPasswordHash string `json:"seeded_password_hash,omitempty"`
+}
+
+type AuthCodeRecord struct {
+	Value       string   `json:"value"`
+	UserID      string   `json:"user_id"`
+	ClientID    string   `json:"client_id"`
+	Scopes      []string `json:"scopes"`
+	RedirectURI string   `json:"redirect_uri"`
+	ExpiresAt   int64    `json:"expires_at"`
+	// 使用済みの認可コードの場合はtrue
+	Used bool `json:"used,omitempty"`
+}
+
+type AccessTokenRecord struct {
+	Value     string   `json:"value"`
+	ClientID  string   `json:"client_id"`
+	UserID    string   `json:"user_id"`
+	Scopes    []string `json:"scopes"`
+	ExpiresAt int64    `json:"expires_at"`
+	GrantID   string   `json:"grant_id,omitempty"`
+}
+
+type RefreshTokenRecord struct {
+	Value     string   `json:"value"`
+	ClientID  string   `json:"client_id"`
+	UserID    string   `json:"user_id"`
+	Scopes    []string `json:"scopes"`
+	IssuedAt  int64    `json:"issued_at"`
+	ExpiresAt int64    `json:"expires_at"`
+	GrantID   string   `json:"grant_id,omitempty"`
+	// 同時に発行したアクセストークン
+	AccessToken AccessTokenRecord `json:"access_token"`
+}
+
+type ConsentRecord struct {
+	UserID      string    `json:"user_id"`
+	ClientID    string    `json:"client_id"`
+	Scopes      []string  `json:"scopes"`
+	GrantedAt   time.Time `json:"granted_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	FirstUsedAt time.Time `json:"first_used_at"`
+	LastUsedAt  time.Time `json:"last_used_at"`
+}
+
+type TOTPCredentialRecord struct {
+	UserID             string    `json:"user_id"`
+	Secret             []byte    `json:"secret"`
+	ConfirmedAt        time.Time `json:"confirmed_at"`
+	LastUsedStep       int64     `json:"last_used_step"`
+	RecoveryCodeHashes []string  `json:"recovery_code_hashes"`
+	// 失敗の記録を追加する前のスナップショットには含まれない
+	FailedAttempts int       `json:"failed_attempts,omitempty"`
+	LockedUntil    time.Time `json:"locked_until"`
+}
+
+type PasskeyCredentialRecord struct {
+	CredentialID   []byte    `json:"credential_id"`
+	UserID         string    `json:"user_id"`
+	LoginID        string    `json:"login_id"`
+	PublicKey      []byte    `json:"public_key"`
+	SignCount      uint32    `json:"sign_count"`
+	BackupEligible bool      `json:"backup_eligible"`
+	Name           string    `json:"name"`
+	CreatedAt      time.Time `json:"created_at"`
+	LastUsedAt     time.Time `json:"last_used_at"`
+}
+
+type SessionRecord struct {
+	SessionID string `json:"session_id"`
+	// 未ログインのセッションの場合はnil
+	User           *UserRecord `json:"user"`
+	AuthTime       time.Time   `json:"auth_time"`
+	AMR            []string    `json:"amr"`
+	CreatedAt      time.Time   `json:"created_at"`
+	LastAccessedAt time.Time   `json:"last_accessed_at"`
+}
+
+func newClientRecord(c *domain.Client) ClientRecord {
+	return ClientRecord{
+		ClientID:     string(c.ClientID()),
+		ClientName:   c.ClientName(),
+		ClientType:   int(c.ClientType()),
+		Secret:       c.Secret(),
+		RedirectURIs: c.RedirectURI(),
+		Policy:       NewClientPolicyRecord(c.Policy()),
+	}
+}
+
+func (r ClientRecord) reconstruct() *domain.Client {
+	policy := domain.DefaultClientPolicy()
+	if r.Policy != nil {
+		policy = r.Policy.Reconstruct()
+	}
+	return domain.ReconstructClientWithPolicy(domain.ClientID(r.ClientID), r.ClientName, domain.ClientType(r.ClientType), r.Secret, r.RedirectURIs, policy)
+}
+
+func NewClientPolicyRecord(p domain.ClientPolicy) *ClientPolicyRecord {
+	record := &ClientPolicyRecord{
+		Scopes:                    p.Scopes,
+		DefaultScopes:             p.DefaultScopes,
+		TokenEndpointAuthMethod:   string(p.TokenEndpointAuthMethod),
+		AuthorizationCodeLifetime: int64(p.Lifetimes.AuthorizationCode / time.Second),
+		AccessTokenLifetime:       int64(p.Lifetimes.AccessToken / time.Second),
+		RefreshTokenLifetime:      int64(p.Lifetimes.RefreshToken / time.Second),
+		IssueRefreshToken:         p.IssueRefreshToken,
+		ApplicationType:           string(p.ApplicationType),
+	}
+	for _, grantType := range p.GrantTypes {
+		record.GrantTypes = append(record.GrantTypes, grantType.String())
+	}
+	for _, responseType := range p.ResponseTypes {
+		record.ResponseTypes = append(record.ResponseTypes, responseType.String())
+	}
+	return record
+}
+
+// サポートしなくなったgrant_typeやresponse_typeは無視する
+func (r ClientPolicyRecord) Reconstruct() domain.ClientPolicy {
+	policy := domain.ClientPolicy{
+		Scopes:                  r.Scopes,
+		DefaultScopes:           r.DefaultScopes,
+		TokenEndpointAuthMethod: domain.TokenEndpointAuthMethod(r.TokenEndpointAuthMethod),
+		Lifetimes: domain.Lifetimes{
+			AuthorizationCode: time.Duration(r.AuthorizationCodeLifetime) * time.Second,
+			AccessToken:       time.Duration(r.AccessTokenLifetime) * time.Second,
+			RefreshToken:      time.Duration(r.RefreshTokenLifetime) * time.Second,
+		},
+		IssueRefreshToken: r.IssueRefreshToken,
+		ApplicationType:   domain.ApplicationType(r.ApplicationType),
+	}
+	for _, value := range r.GrantTypes {
+		if grantType, err := domain.ResolveGrantType(value); err == nil {
+			policy.GrantTypes = append(policy.GrantTypes, grantType)
+		}
+	}
+	for _, value := range r.ResponseTypes {
+		if responseType, err := domain.GetResponseType(value); err == nil {
+			policy.ResponseTypes = append(policy.ResponseTypes, responseType)
+		}
+	}
+	return policy
+}
+
+func newUserRecord(u *domain.User, seededPasswordHash string) UserRecord {
+	record := UserRecord{UserID: u.UserID(), LoginID: u.LoginID(), PasswordHash: u.PasswordHash(), SeededPasswordHash: seededPasswordHash}
+	if identity := u.FederatedIdentity(); identity != nil {
+		record.FederatedIssuer, record.FederatedSubject = identity.Issuer(), identity.Subject()
+	}
+	return record
+}
+
+func (r UserRecord) reconstruct() *domain.User {
+	if identity, err := domain.NewFederatedIdentity(r.FederatedIssuer, r.FederatedSubject); err == nil {
+		return domain.NewFederatedUser(r.UserID, r.LoginID, identity)
+	}
+	return domain.ReconstructUser(r.UserID, r.LoginID, r.PasswordHash)
+}
+
+func newAuthCodeRecord(c *domain.AuthorizationCode, used bool) AuthCodeRecord {
+	return AuthCodeRecord{
+		Value:       c.Value(),
+		UserID:      c.UserID(),
+		ClientID:    c.ClientID(),
+		Scopes:      c.Scopes(),
+		RedirectURI: c.RedirectURI(),
+		ExpiresAt:   c.ExpiresAt(),
+		Used:        used,
+	}
+}
+
+func (r AuthCodeRecord) reconstruct() *domain.AuthorizationCode {
+	return domain.ReconstructAuthorizationCode(r.Value, r.UserID, r.ClientID, r.Scopes, r.RedirectURI, r.ExpiresAt)
+}
+
+func newAccessTokenRecord(t *domain.AccessToken) AccessTokenRecord {
+	return AccessTokenRecord{Value: t.Value(), ClientID: t.ClientID(), UserID: t.UserID(), Scopes: t.Scopes(), ExpiresAt: t.ExpiresAt(), GrantID: t.GrantID()}
+}
+
+func (r AccessTokenRecord) reconstruct() *domain.AccessToken {
+	return domain.ReconstructAccessToken(r.Value, r.ClientID, r.UserID, r.Scopes, r.ExpiresAt, r.GrantID)
+}
+
+func newRefreshTokenRecord(t *domain.RefreshToken, accessToken *domain.AccessToken) RefreshTokenRecord {
+	return RefreshTokenRecord{
+		Value:       t.Value(),
+		ClientID:    t.ClientID(),
+		UserID:      t.UserID(),
+		Scopes:      t.Scopes(),
+		IssuedAt:    t.IssuedAt(),
+		ExpiresAt:   t.ExpiresAt(),
+		GrantID:     t.GrantID(),
+		AccessToken: newAccessTokenRecord(accessToken),
+	}
+}
+
+func (r RefreshTokenRecord) reconstruct() *domain.RefreshToken {
+	return domain.ReconstructRefreshToken(r.Value, r.ClientID, r.UserID, r.Scopes, r.IssuedAt, r.ExpiresAt, r.GrantID)
+}
+
+func newConsentRecord(c *domain.Consent) ConsentRecord {
+	return ConsentRecord{
+		UserID:      c.UserID(),
+		ClientID:    c.ClientID(),
+		Scopes:      c.Scopes(),
+		GrantedAt:   c.GrantedAt(),
+		UpdatedAt:   c.UpdatedAt(),
+		ExpiresAt:   c.ExpiresAt(),
+		FirstUsedAt: c.FirstUsedAt(),
+		LastUsedAt:  c.LastUsedAt(),
+	}
+}
+
+func (r ConsentRecord) reconstruct() *domain.Consent {
+	return domain.ReconstructConsent(r.UserID, r.ClientID, r.Scopes, r.GrantedAt, r.UpdatedAt, r.ExpiresAt, r.FirstUsedAt, r.LastUsedAt)
+}
+
+func newTOTPCredentialRecord(c *domain.TOTPCredential) TOTPCredentialRecord {
+	return TOTPCredentialRecord{
+		UserID:             c.UserID(),
+		Secret:             c.Secret(),
+		ConfirmedAt:        c.ConfirmedAt(),
+		LastUsedStep:       c.LastUsedStep(),
+		RecoveryCodeHashes: c.RecoveryCodeHashes(),
+		FailedAttempts:     c.FailedAttempts(),
+		LockedUntil:        c.LockedUntil(),
+	}
+}
+
+func (r TOTPCredentialRecord) reconstruct() *domain.TOTPCredential {
+	return domain.ReconstructTOTPCredential(r.UserID, r.Secret, r.ConfirmedAt, r.LastUsedStep, r.RecoveryCodeHashes, r.FailedAttempts, r.LockedUntil)
+}
+
+func newPasskeyCredentialRecord(c *domain.PasskeyCredential) PasskeyCredentialRecord {
+	return PasskeyCredentialRecord{
+		CredentialID:   c.CredentialID(),
+		UserID:         c.UserID(),
+		LoginID:        c.LoginID(),
+		PublicKey:      c.PublicKey(),
+		SignCount:      c.SignCount(),
+		BackupEligible: c.BackupEligible(),
+		Name:           c.Name(),
+		CreatedAt:      c.CreatedAt(),
+		LastUsedAt:     c.LastUsedAt(),
+	}
+}
+
+func (r PasskeyCredentialRecord) reconstruct() *domain.PasskeyCredential {
+	return domain.ReconstructPasskeyCredential(r.CredentialID, r.UserID, r.LoginID, r.PublicKey, r.SignCount, r.BackupEligible, r.Name, r.CreatedAt, r.LastUsedAt)
+}
+
+func newSessionRecord(sessionID session.SessionID, entry *sessionEntry) SessionRecord {
+	record := SessionRecord{
+		SessionID:      string(sessionID),
+		AuthTime:       entry.data.AuthTime(),
+		AMR:            entry.data.AMR(),
+		CreatedAt:      entry.createdAt,
+		LastAccessedAt: entry.lastAccessedAt,
+	}
+	if entry.data.User() != nil {
+		user := newUserRecord(entry.data.User(), "")
+		record.User = &user
+	}
+	return record
+}
+
+func (r SessionRecord) reconstruct() *sessionEntry {
+	var user *domain.User
+	if r.User != nil {
+		user = r.User.reconstruct()
+	}
+	return &sessionEntry{
+		data:           *dto.NewSessionData(user, r.AuthTime, r.AMR),
+		createdAt:      r.CreatedAt,
+		lastAccessedAt: r.LastAccessedAt,
+	}
+}
+
+// スナップショットの対象となるインメモリの実装
+type MemoryStores struct {
+	Clients   *ClientRepository
+	Users     *UserRepository
+	AuthCodes *AuthCodeRepository
+	Tokens    *TokenRepository
+	Consents  *ConsentRepository
+	TOTP      *TOTPRepository
+	Passkeys  *PasskeyRepository
+	Sessions  *SessionStorage
+}
+
+// 全ての実装の内容を記録する
+func (s *MemoryStores) Snapshot(now time.Time) *Snapshot {
+	accessTokens, refreshTokens := s.Tokens.Export()
+	return &Snapshot{
+		TakenAt:            now,
+		Clients:            s.Clients.Export(),
+		Users:              s.Users.Export(),
+		AuthCodes:          s.AuthCodes.Export(),
+		AccessTokens:       accessTokens,
+		RefreshTokens:      refreshTokens,
+		Consents:           s.Consents.Export(),
+		Sessions:           s.Sessions.Export(),
+		TOTPCredentials:    s.TOTP.Export(),
+		PasskeyCredentials: s.Passkeys.Export(),
+	}
+}
+
+// スナップショットの内容を各実装に取り込む。有効期限切れのものは取り込まず、その数を返す
+func (s *MemoryStores) Restore(snapshot *Snapshot, now time.Time) (skipped int) {
+	s.Clients.Import(snapshot.Clients)
+	s.Users.Import(snapshot.Users)
+	skipped += s.AuthCodes.Import(snapshot.AuthCodes, now)
+	skipped += s.Tokens.Import(snapshot.AccessTokens, snapshot.RefreshTokens, now)
+	skipped += s.Consents.Import(snapshot.Consents, now)
+	s.TOTP.Import(snapshot.TOTPCredentials)
+	s.Passkeys.Import(snapshot.PasskeyCredentials)
+	skipped += s.Sessions.Import(snapshot.Sessions)
+	return skipped
+}
@@ -0,0 +1,180 @@
+package infrastructure
+
+import (
+	"encoding/binary"
+	"errors"
+	"oauth-tutorial/internal/domain"
+	"oauth-tutorial/internal/infrastructure/dto"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestMemoryStores(now func() time.Time) *MemoryStores {
+	return &MemoryStores{
+		Clients:   NewClientRepository(),
+		Users:     NewUserRepository(),
+		AuthCodes: NewAuthCodeRepository(),
+		Tokens:    NewTokenRespository(),
+		Consents:  NewConsentRepository(),
+		TOTP:      NewTOTPRepository(),
+		Passkeys:  NewPasskeyRepository(),
+		Sessions:  NewSessionStorageWithClock(time.Hour, 24*time.Hour, now),
+	}
+}
+
+func Test_スナップショットの保存と復元(t *testing.T) {
+	now := time.Now()
+	clock := func() time.Time { return now }
+	src := newTestMemoryStores(clock)
+	user := domain.ReconstructUser("user-2", "another@example.com", "password2")
+	src.Users.Save(user)
+	src.Clients.Save(domain.ReconstructClient("client-2", "client-2", domain.PublicClient, "", []string{"https://client2.example.com/callback"}))
+
+	code := domain.ReconstructAuthorizationCode("code-1", "user-2", "client-2", []string{"read"}, "https://client2.example.com/callback", now.Add(time.Minute).Unix())
+	expiredCode := domain.ReconstructAuthorizationCode("code-2", "user-2", "client-2", []string{"read"}, "https://client2.example.com/callback", now.Add(-time.Minute).Unix())
+	src.AuthCodes.Save(code)
+	src.AuthCodes.Save(expiredCode)
+
+	accessToken := domain.NewAccessToken("client-2", "user-2", []string{"read"}, now, domain.AccessTokenDuration)
+	refreshToken := domain.NewRefreshToken("client-2", "user-2", []string{"read"}, now, domain.RefreshTokenDuration)
+	src.Tokens.Save(accessToken)
+	src.Tokens.SaveRefreshToken(refreshToken, accessToken)
+	expiredAccessToken := domain.NewAccessToken("client-2", "user-2", []string{"read"}, now.Add(-2*domain.AccessTokenDuration), domain.AccessTokenDuration)
+	src.Tokens.Save(expiredAccessToken)
+
+	consent := domain.NewConsent("user-2", "client-2", []string{"read"}, now, 0).RecordUse(now)
+	src.Consents.Save(consent)
+	src.Consents.Save(domain.NewConsent("user-2", "client-3", []string{"read"}, now.Add(-2*time.Hour), time.Hour))
+
+	totp := domain.NewTOTPCredential("user-2", []byte("12345678901234567890")).Confirm(now, 100, []string{domain.HashRecoveryCode("ABCD-EFGH")})
+	src.TOTP.Save(totp)
+	passkey, _ := domain.NewPasskeyCredential([]byte("credential-1"), "user-2", "another@example.com", []byte("public-key"), 0, false, "ノートPC", now).Use(3, now)
+	src.Passkeys.Save(passkey)
+
+	src.Sessions.Save("session-1", dto.NewSessionData(user, now, []string{"pwd"}))
+
+	path := filepath.Join(t.TempDir(), "snapshot.bin")
+	key := SnapshotKey("test-secret")
+	if err := WriteSnapshotFile(path, key, src.Snapshot(now)); err != nil {
+		t.Fatalf("WriteSnapshotFile() error = %v", err)
+	}
+	snapshot, err := ReadSnapshotFile(path, key)
+	if err != nil {
+		t.Fatalf("ReadSnapshotFile() error = %v", err)
+	}
+
+	// 30分後に別のプロセスで復元する
+	now = now.Add(30 * time.Minute)
+	dst := newTestMemoryStores(clock)
+	skipped := dst.Restore(snapshot, now)
+
+	// 停止中も含めて有効期限切れになった認可コード2件・アクセストークン・同意は取り込まない
+	if skipped != 4 {
+		t.Errorf("Restore() skipped = %d, want 4", skipped)
+	}
+	if _, err := dst.AuthCodes.FindByCode(code.Value()); err == nil {
+		t.Error("authorization code expired during downtime should not be restored")
+	}
+	if _, err := dst.AuthCodes.FindByCode(expiredCode.Value()); err == nil {
+		t.Error("expired authorization code should not be restored")
+	}
+	if _, err := dst.Users.FindByLoginID("another@example.com"); err != nil {
+		t.Errorf("user should be restored: %v", err)
+	}
+	if _, err := dst.Clients.SelectByClientID("client-2"); err != nil {
+		t.Errorf("client should be restored: %v", err)
+	}
+	if _, err := dst.Tokens.FindByAccessToken(accessToken.Value()); err != nil {
+		t.Errorf("access token should be restored: %v", err)
+	}
+	if _, err := dst.Tokens.FindByAccessToken(expiredAccessToken.Value()); err == nil {
+		t.Error("expired access token should not be restored")
+	}
+	if actual, err := dst.Tokens.FindByRefreshToken(refreshToken.Value()); err != nil || actual.IssuedAt() != refreshToken.IssuedAt() {
+		t.Errorf("refresh token should be restored: %v, %v", actual, err)
+	}
+	if actual, err := dst.Consents.FindByUserAndClient("user-2", "client-2"); err != nil || !actual.LastUsedAt().Equal(consent.LastUsedAt()) {
+		t.Errorf("consent should be restored: %v, %v", actual, err)
+	}
+	if _, err := dst.Consents.FindByUserAndClient("user-2", "client-3"); err == nil {
+		t.Error("expired consent should not be restored")
+	}
+	if actual, err := dst.TOTP.FindByUserID("user-2"); err != nil || !actual.Enabled() || actual.LastUsedStep() != 100 || !actual.HasRecoveryCode("abcd-efgh") {
+		t.Errorf("totp credential should be restored: %v, %v", actual, err)
+	}
+	if actual, err := dst.Passkeys.FindByCredentialID([]byte("credential-1")); err != nil || actual.SignCount() != 3 || actual.LoginID() != "another@example.com" || !actual.LastUsedAt().Equal(now.Add(-30*time.Minute)) {
+		t.Errorf("passkey credential should be restored: %v, %v", actual, err)
+	}
+	sessionData, err := dst.Sessions.Get("session-1")
+	if err != nil {
+		t.Fatalf("session should be restored: %v", err)
+	}
+	if !sessionData.IsAuthenticated() || sessionData.User().UserID() != "user-2" {
+		t.Errorf("restored session = %+v, want authenticated session of user-2", sessionData)
+	}
+}
+
+func Test_有効期間切れのセッションは復元しない(t *testing.T) {
+	now := time.Now()
+	clock := func() time.Time { return now }
+	src := newTestMemoryStores(clock)
+	src.Sessions.Save("session-1", dto.NewSessionData(nil, time.Time{}, nil))
+	snapshot := src.Snapshot(now)
+
+	// 停止中に最終アクセスからの有効期間を過ぎた
+	now = now.Add(2 * time.Hour)
+	dst := newTestMemoryStores(clock)
+	if skipped := dst.Restore(snapshot, now); skipped != 1 {
+		t.Errorf("Restore() skipped = %d, want 1", skipped)
+	}
+	if _, err := dst.Sessions.Get("session-1"); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("Get() error = %v, want %v", err, ErrSessionNotFound)
+	}
+}
+
+func Test_スナップショットファイルの読み込みエラー(t *testing.T) {
+	key := SnapshotKey("test-secret")
+	dir := t.TempDir()
+	path := filepath.Join(dir, "snapshot.bin")
+	if err := WriteSnapshotFile(path, key, &Snapshot{TakenAt: time.Now()}); err != nil {
+		t.Fatalf("WriteSnapshotFile() error = %v", err)
+	}
+	valid, _ := os.ReadFile(path)
+
+	write := func(t *testing.T, data []byte) string {
+		p := filepath.Join(t.TempDir(), "snapshot.bin")
+		os.WriteFile(p, data, 0o600)
+		return p
+	}
+	tampered := append([]byte{}, valid...)
+	tampered[len(tampered)-1] ^= 0xff
+	newerVersion := append([]byte{}, valid...)
+	binary.BigEndian.PutUint16(newerVersion[len(snapshotMagic):], SnapshotVersion+1)
+
+	tests := []struct {
+		name        string
+		data        []byte
+		key         []byte
+		expectedErr error
+	}{
+		{name: "鍵が異なる", data: valid, key: SnapshotKey("other-secret"), expectedErr: ErrInvalidSnapshotFile},
+		{name: "改ざんされている", data: tampered, key: key, expectedErr: ErrInvalidSnapshotFile},
+		{name: "スナップショットファイルではない", data: []byte("{}"), key: key, expectedErr: ErrInvalidSnapshotFile},
+		{name: "未対応のバージョン", data: newerVersion, key: key, expectedErr: ErrUnsupportedSnapshotVersion},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ReadSnapshotFile(write(t, tt.data), tt.key); !errors.Is(err, tt.expectedErr) {
+				t.Errorf("ReadSnapshotFile() error = %v, want %v", err, tt.expectedErr)
+			}
+		})
+	}
+
+	t.Run("ファイルが存在しない", func(t *testing.T) {
+		if _, err := ReadSnapshotFile(filepath.Join(dir, "missing.bin"), key); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("ReadSnapshotFile() error = %v, want %v", err, os.ErrNotExist)
+		}
+	})
+}
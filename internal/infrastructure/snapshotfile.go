@@ -0,0 +1,118 @@
+package infrastructure
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+var (
+	ErrInvalidSnapshotFile        = errors.New("invalid snapshot file")
+	ErrUnsupportedSnapshotVersion = errors.New("unsupported snapshot version")
+)
+
+// スナップショットファイルの先頭に置く識別子
+// ファイルは 識別子 + バージョン(uint16, ビッグエンディアン) + nonce + AES-256-GCMで暗号化したJSON の形式
+var snapshotMagic = []byte("OASNAP")
+
+// スナップショットファイルの暗号化に使う鍵を秘密の文字列から導出する
+func SnapshotKey(secret string) []byte {
+	sum := sha256.Sum256([]byte(secret))
+	return sum[:]
+}
+
+// スナップショットを暗号化してファイルに書き込む
+// 書き込み途中で停止しても既存のファイルが壊れないよう、一時ファイルに書き込んでから置き換える
+func WriteSnapshotFile(path string, key []byte, snapshot *Snapshot) error {
+	plaintext, err := json.Marshal(snapshot)
+	if err != nil {
+		return fmt.Errorf("marshal snapshot: %w", err)
+	}
+	aead, err := newSnapshotAEAD(key)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("generate nonce: %w", err)
+	}
+	header := snapshotHeader(SnapshotVersion)
+	data := append(append(header, nonce...), aead.Seal(nil, nonce, plaintext, header)...)
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("create snapshot file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write snapshot file: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("sync snapshot file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("close snapshot file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("rename snapshot file: %w", err)
+	}
+	return nil
+}
+
+// スナップショットファイルを読み込んで復号する
+// ファイルが存在しない場合はos.ErrNotExistを返す
+func ReadSnapshotFile(path string, key []byte) (*Snapshot, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	headerLen := len(snapshotMagic) + 2
+	if len(data) < headerLen || !bytes.Equal(data[:len(snapshotMagic)], snapshotMagic) {
+		return nil, ErrInvalidSnapshotFile
+	}
+	if version := binary.BigEndian.Uint16(data[len(snapshotMagic):headerLen]); version != SnapshotVersion {
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedSnapshotVersion, version)
+	}
+
+	aead, err := newSnapshotAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < headerLen+aead.NonceSize() {
+		return nil, ErrInvalidSnapshotFile
+	}
+	header, nonce, ciphertext := data[:headerLen], data[headerLen:headerLen+aead.NonceSize()], data[headerLen+aead.NonceSize():]
+	// 鍵が異なる場合や改ざんされた場合は復号に失敗する
+	plaintext, err := aead.Open(nil, nonce, ciphertext, header)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSnapshotFile, err)
+	}
+
+	var snapshot Snapshot
+	if err := json.Unmarshal(plaintext, &snapshot); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSnapshotFile, err)
+	}
+	return &snapshot, nil
+}
+
+func snapshotHeader(version uint16) []byte {
+	return binary.BigEndian.AppendUint16(bytes.Clone(snapshotMagic), version)
+}
+
+func newSnapshotAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("create cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,93 @@
+package sqlstore
+
+import (
+	"database/sql"
+	"errors"
+	"oauth-tutorial/internal/domain"
+	"oauth-tutorial/internal/infrastructure"
+	"time"
+)
+
+type AuthCodeRepository struct {
+	db *sql.DB
+}
+
+var _ infrastructure.AuthCodeStore = (*AuthCodeRepository)(nil)
+
+func NewAuthCodeRepository(db *sql.DB) *AuthCodeRepository {
+	return &AuthCodeRepository{db: db}
+}
+
+func (r *AuthCodeRepository) Save(code *domain.AuthorizationCode) error {
+	_, err := r.db.Exec(`INSERT INTO authorization_codes (code_hash, user_id, client_id, scopes, redirect_uri, expires_at) VALUES (?, ?, ?, ?, ?, ?)`,
+		hashValue(code.Value()), code.UserID(), code.ClientID(), domain.FormatScope(code.Scopes()), code.RedirectURI(), code.ExpiresAt())
+	return err
+}
+
+// 使用済みの認可コードは見つからないものとして扱う
+func (r *AuthCodeRepository) FindByCode(code string) (*domain.AuthorizationCode, error) {
+	row := r.db.QueryRow(`SELECT user_id, client_id, scopes, redirect_uri, expires_at FROM authorization_codes WHERE code_hash = ? AND used_at IS NULL`, hashValue(code))
+	return scanAuthorizationCode(row, code)
+}
+
+func (r *AuthCodeRepository) Delete(code string) error {
+	_, err := r.db.Exec(`DELETE FROM authorization_codes WHERE code_hash = ?`, hashValue(code))
+	return err
+}
+
+// 認可コードを取得すると同時に使用済みにする
+// 1つのUPDATE文で未使用の確認と使用済みへの更新を行うため、並行して消費しても取得できるのは1回のみ
+func (r *AuthCodeRepository) Consume(code string) (*domain.AuthorizationCode, error) {
+	codeHash := hashValue(code)
+	row := r.db.QueryRow(`UPDATE authorization_codes SET used_at = ? WHERE code_hash = ? AND used_at IS NULL RETURNING user_id, client_id, scopes, redirect_uri, expires_at`,
+		time.Now().Unix(), codeHash)
+	authCode, err := scanAuthorizationCode(row, code)
+	if !errors.Is(err, infrastructure.ErrAuthorizationCodeNotFound) {
+		return authCode, err
+	}
+
+	// 更新できなかった場合は、存在しないのか使用済みなのかを区別する
+	var used int
+	if err := r.db.QueryRow(`SELECT COUNT(*) FROM authorization_codes WHERE code_hash = ? AND used_at IS NOT NULL`, codeHash).Scan(&used); err != nil {
+		return nil, err
+	}
+	if used > 0 {
+		return nil, infrastructure.ErrAuthorizationCodeAlreadyUsed
+	}
+	return nil, infrastructure.ErrAuthorizationCodeNotFound
+}
+
+func (r *AuthCodeRepository) DeleteByUserAndClient(userID string, clientID string) (int, error) {
+	result, err := r.db.Exec(`DELETE FROM authorization_codes WHERE user_id = ? AND client_id = ? AND used_at IS NULL`, userID, clientID)
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(n), nil
+}
+
+// 使用済みのものを含め、有効期限切れの認可コードを最大limit件削除し、削除した数を返す
+func (r *AuthCodeRepository) PurgeExpired(now time.Time, limit int) (int, error) {
+	return deleteBatch(r.db, "authorization_codes", "expires_at < ?", limit, now.Unix())
+}
+
+func scanAuthorizationCode(row *sql.Row, code string) (*domain.AuthorizationCode, error) {
+	var (
+		userID      string
+		clientID    string
+		scopes      string
+		redirectURI string
+		expiresAt   int64
+	)
+	err := row.Scan(&userID, &clientID, &scopes, &redirectURI, &expiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, infrastructure.ErrAuthorizationCodeNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return domain.ReconstructAuthorizationCode(code, userID, clientID, domain.ParseScope(scopes), redirectURI, expiresAt), nil
+}
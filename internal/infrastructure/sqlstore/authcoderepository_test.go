@@ -0,0 +1,79 @@
+package sqlstore
+
+import (
+	"oauth-tutorial/internal/domain"
+	"oauth-tutorial/internal/infrastructure"
+	"oauth-tutorial/pkg/mycrypto"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_認可コードの保存と検索(t *testing.T) {
+	repo := NewAuthCodeRepository(openTestDB(t))
+	code := domain.NewAuthorizationCode(&mycrypto.RandomGenerator{}, "user-1", "client-1", []string{"read", "write"}, "https://client.example.com/callback", time.Now(), domain.AUTHORIZATION_CODE_DURATION)
+	if err := repo.Save(code); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	actual, err := repo.FindByCode(code.Value())
+	if err != nil {
+		t.Fatalf("FindByCode() error = %v", err)
+	}
+	if !reflect.DeepEqual(actual, code) {
+		t.Errorf("FindByCode() = %v, want %v", actual, code)
+	}
+
+	if err := repo.Delete(code.Value()); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, err := repo.FindByCode(code.Value()); err != infrastructure.ErrAuthorizationCodeNotFound {
+		t.Errorf("FindByCode() error = %v, want %v", err, infrastructure.ErrAuthorizationCodeNotFound)
+	}
+}
+
+func Test_認可コードはハッシュ値で保存する(t *testing.T) {
+	db := openTestDB(t)
+	repo := NewAuthCodeRepository(db)
+	code := domain.NewAuthorizationCode(&mycrypto.RandomGenerator{}, "user-1", "client-1", []string{"read"}, "https://client.example.com/callback", time.Now(), domain.AUTHORIZATION_CODE_DURATION)
+	repo.Save(code)
+
+	var count int
+	db.QueryRow(`SELECT COUNT(*) FROM authorization_codes WHERE code_hash = ?`, code.Value()).Scan(&count)
+	if count != 0 {
+		t.Error("authorization code should not be stored as plain text")
+	}
+}
+
+func Test_認可コードを並行して消費しても取得できるのは1回のみ(t *testing.T) {
+	repo := NewAuthCodeRepository(openTestDB(t))
+	code := domain.NewAuthorizationCode(&mycrypto.RandomGenerator{}, "user-1", "client-1", []string{"read"}, "https://client.example.com/callback", time.Now(), domain.AUTHORIZATION_CODE_DURATION)
+	repo.Save(code)
+
+	const n = 10
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		succeeded int
+	)
+	for range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := repo.Consume(code.Value()); err == nil {
+				mu.Lock()
+				succeeded++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if succeeded != 1 {
+		t.Errorf("Consume() succeeded %d times, want 1", succeeded)
+	}
+	if _, err := repo.FindByCode(code.Value()); err != infrastructure.ErrAuthorizationCodeNotFound {
+		t.Errorf("FindByCode() error = %v, want %v", err, infrastructure.ErrAuthorizationCodeNotFound)
+	}
+}
@@ -0,0 +1,90 @@
+package sqlstore
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"oauth-tutorial/internal/domain"
+	"oauth-tutorial/internal/infrastructure"
+	"strings"
+)
+
+type ClientRepository struct {
+	db *sql.DB
+}
+
+var _ infrastructure.ClientStore = (*ClientRepository)(nil)
+
+func NewClientRepository(db *sql.DB) *ClientRepository {
+	return &ClientRepository{db: db}
+}
+
+func (r *ClientRepository) Save(client *domain.Client) error {
+	return saveClient(r.db, client)
+}
+
+// 1つのトランザクションで削除と登録を行う
+func (r *ClientRepository) ReplaceAll(clients []*domain.Client) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM clients`); err != nil {
+		return err
+	}
+	for _, client := range clients {
+		if err := saveClient(tx, client); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func saveClient(db interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}, client *domain.Client) error {
+	policy, err := json.Marshal(infrastructure.NewClientPolicyRecord(client.Policy()))
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`INSERT INTO clients (client_id, client_name, client_type, secret, redirect_uris, policy) VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (client_id) DO UPDATE SET client_name = excluded.client_name, client_type = excluded.client_type, secret = excluded.secret, redirect_uris = excluded.redirect_uris, policy = excluded.policy`,
+		string(client.ClientID()), client.ClientName(), int(client.ClientType()), client.Secret(), strings.Join(client.RedirectURI(), " "), string(policy))
+	return err
+}
+
+func (r *ClientRepository) SelectByClientID(clientID domain.ClientID) (*domain.Client, error) {
+	var (
+		clientName   string
+		clientType   int
+		secret       string
+		redirectURIs string
+		policyJSON   string
+	)
+	err := r.db.QueryRow(`SELECT client_name, client_type, secret, redirect_uris, policy FROM clients WHERE client_id = ?`, string(clientID)).
+		Scan(&clientName, &clientType, &secret, &redirectURIs, &policyJSON)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, infrastructure.ErrClientNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	// ポリシーを記録する前に登録したクライアントは既定のポリシー
+	policy := domain.DefaultClientPolicy()
+	if policyJSON != "" {
+		var record infrastructure.ClientPolicyRecord
+		if err := json.Unmarshal([]byte(policyJSON), &record); err != nil {
+			return nil, fmt.Errorf("decode client policy: %w", err)
+		}
+		policy = record.Reconstruct()
+	}
+	return domain.ReconstructClientWithPolicy(clientID, clientName, domain.ClientType(clientType), secret, strings.Fields(redirectURIs), policy), nil
+}
+
+// トークンエンドポイントではclient_idを文字列で受け取るため、文字列のIDで検索する
+func (r *ClientRepository) FindByID(clientID string) (*domain.Client, error) {
+	return r.SelectByClientID(domain.ClientID(clientID))
+}
@@ -0,0 +1,26 @@
+package sqlstore
+
+import (
+	"oauth-tutorial/internal/infrastructure"
+	"oauth-tutorial/internal/infrastructure/storagetest"
+	"testing"
+	"time"
+)
+
+func Test_SQLiteの実装の適合性(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) *storagetest.Backend {
+		db := openTestDB(t)
+		return &storagetest.Backend{
+			Clients:   NewClientRepository(db),
+			Users:     NewUserRepository(db),
+			AuthCodes: NewAuthCodeRepository(db),
+			Tokens:    NewTokenRepository(db),
+			Consents:  NewConsentRepository(db),
+			TOTP:      NewTOTPRepository(db),
+			Passkeys:  NewPasskeyRepository(db),
+			NewSessionStore: func(idleTimeout time.Duration, absoluteTimeout time.Duration, now func() time.Time) infrastructure.SessionStore {
+				return NewSessionStorageWithClock(db, idleTimeout, absoluteTimeout, now)
+			},
+		}
+	})
+}
@@ -0,0 +1,88 @@
+package sqlstore
+
+import (
+	"database/sql"
+	"errors"
+	"oauth-tutorial/internal/domain"
+	"oauth-tutorial/internal/infrastructure"
+	"time"
+)
+
+type ConsentRepository struct {
+	db *sql.DB
+}
+
+var _ infrastructure.ConsentStore = (*ConsentRepository)(nil)
+
+func NewConsentRepository(db *sql.DB) *ConsentRepository {
+	return &ConsentRepository{db: db}
+}
+
+func (r *ConsentRepository) Save(consent *domain.Consent) error {
+	_, err := r.db.Exec(`INSERT INTO consents (user_id, client_id, scopes, granted_at, updated_at, expires_at, first_used_at, last_used_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (user_id, client_id) DO UPDATE SET scopes = excluded.scopes, granted_at = excluded.granted_at, updated_at = excluded.updated_at,
+			expires_at = excluded.expires_at, first_used_at = excluded.first_used_at, last_used_at = excluded.last_used_at`,
+		consent.UserID(), consent.ClientID(), domain.FormatScope(consent.Scopes()),
+		consent.GrantedAt().UnixNano(), consent.UpdatedAt().UnixNano(),
+		toNullTime(consent.ExpiresAt()), toNullTime(consent.FirstUsedAt()), toNullTime(consent.LastUsedAt()))
+	return err
+}
+
+func (r *ConsentRepository) FindByUserAndClient(userID string, clientID string) (*domain.Consent, error) {
+	row := r.db.QueryRow(`SELECT user_id, client_id, scopes, granted_at, updated_at, expires_at, first_used_at, last_used_at FROM consents WHERE user_id = ? AND client_id = ?`,
+		userID, clientID)
+	consent, err := scanConsent(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, infrastructure.ErrConsentNotFound
+	}
+	return consent, err
+}
+
+// ユーザーの全ての同意を同意した日時の順に返す
+func (r *ConsentRepository) FindByUserID(userID string) ([]*domain.Consent, error) {
+	rows, err := r.db.Query(`SELECT user_id, client_id, scopes, granted_at, updated_at, expires_at, first_used_at, last_used_at FROM consents WHERE user_id = ? ORDER BY granted_at`,
+		userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	consents := make([]*domain.Consent, 0)
+	for rows.Next() {
+		consent, err := scanConsent(rows)
+		if err != nil {
+			return nil, err
+		}
+		consents = append(consents, consent)
+	}
+	return consents, rows.Err()
+}
+
+func (r *ConsentRepository) Delete(userID string, clientID string) error {
+	_, err := r.db.Exec(`DELETE FROM consents WHERE user_id = ? AND client_id = ?`, userID, clientID)
+	return err
+}
+
+// 有効期限切れの同意を最大limit件削除し、削除した数を返す
+func (r *ConsentRepository) PurgeExpired(now time.Time, limit int) (int, error) {
+	return deleteBatch(r.db, "consents", "expires_at IS NOT NULL AND expires_at < ?", limit, now.UnixNano())
+}
+
+func scanConsent(row interface{ Scan(dest ...any) error }) (*domain.Consent, error) {
+	var (
+		userID      string
+		clientID    string
+		scopes      string
+		grantedAt   int64
+		updatedAt   int64
+		expiresAt   sql.NullInt64
+		firstUsedAt sql.NullInt64
+		lastUsedAt  sql.NullInt64
+	)
+	if err := row.Scan(&userID, &clientID, &scopes, &grantedAt, &updatedAt, &expiresAt, &firstUsedAt, &lastUsedAt); err != nil {
+		return nil, err
+	}
+	return domain.ReconstructConsent(userID, clientID, domain.ParseScope(scopes),
+		time.Unix(0, grantedAt), time.Unix(0, updatedAt),
+		fromNullTime(expiresAt), fromNullTime(firstUsedAt), fromNullTime(lastUsedAt)), nil
+}
@@ -0,0 +1,45 @@
+package sqlstore
+
+import (
+	"oauth-tutorial/internal/domain"
+	"oauth-tutorial/internal/infrastructure"
+	"testing"
+	"time"
+)
+
+func Test_同意の保存と検索(t *testing.T) {
+	repo := NewConsentRepository(openTestDB(t))
+	now := time.Now()
+	first := domain.NewConsent("user-1", "client-1", []string{"read"}, now.Add(-time.Hour), 0)
+	second := domain.NewConsent("user-1", "client-2", []string{"read", "write"}, now, time.Hour).RecordUse(now)
+	repo.Save(second)
+	repo.Save(first)
+	repo.Save(domain.NewConsent("user-2", "client-1", []string{"read"}, now, 0))
+
+	actual, err := repo.FindByUserAndClient("user-1", "client-2")
+	if err != nil {
+		t.Fatalf("FindByUserAndClient() error = %v", err)
+	}
+	if !actual.Covers(domain.DefaultScopeRegistry(), []string{"read", "write"}) || !actual.ExpiresAt().Equal(second.ExpiresAt()) || !actual.LastUsedAt().Equal(now) {
+		t.Errorf("FindByUserAndClient() = %+v, want %+v", actual, second)
+	}
+	// 未設定の日時はゼロ値のまま復元されること
+	actual, _ = repo.FindByUserAndClient("user-1", "client-1")
+	if !actual.ExpiresAt().IsZero() || !actual.FirstUsedAt().IsZero() {
+		t.Errorf("unset times should be zero: %+v", actual)
+	}
+
+	// 同意した日時の順に返すこと
+	consents, err := repo.FindByUserID("user-1")
+	if err != nil {
+		t.Fatalf("FindByUserID() error = %v", err)
+	}
+	if len(consents) != 2 || consents[0].ClientID() != "client-1" || consents[1].ClientID() != "client-2" {
+		t.Errorf("FindByUserID() = %v, want [client-1 client-2]", consents)
+	}
+
+	repo.Delete("user-1", "client-1")
+	if _, err := repo.FindByUserAndClient("user-1", "client-1"); err != infrastructure.ErrConsentNotFound {
+		t.Errorf("FindByUserAndClient() error = %v, want %v", err, infrastructure.ErrConsentNotFound)
+	}
+}
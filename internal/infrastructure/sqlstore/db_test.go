@@ -0,0 +1,36 @@
+package sqlstore
+
+import (
+	"database/sql"
+	"io/fs"
+	"path/filepath"
+	"testing"
+)
+
+// テスト毎に一時ディレクトリにデータベースを作成する
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func Test_マイグレーションは再実行しても適用済みのものを再適用しない(t *testing.T) {
+	db := openTestDB(t)
+
+	if err := Migrate(db); err != nil {
+		t.Fatalf("Migrate() error = %v", err)
+	}
+
+	var count int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM schema_migrations`).Scan(&count); err != nil {
+		t.Fatalf("count schema_migrations: %v", err)
+	}
+	names, _ := fs.Glob(migrations, "migrations/*.sql")
+	if count != len(names) {
+		t.Errorf("applied migrations = %d, want %d", count, len(names))
+	}
+}
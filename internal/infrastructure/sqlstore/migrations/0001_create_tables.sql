@@ -0,0 +1,69 @@
+-- クライアント。redirect_urisはスペース区切り
+CREATE TABLE clients (
+    client_id     TEXT PRIMARY KEY,
+    client_name   TEXT NOT NULL,
+    client_type   INTEGER NOT NULL,
+    secret        TEXT NOT NULL,
+    redirect_uris TEXT NOT NULL
+);
+
+CREATE TABLE users (
+    user_id  TEXT PRIMARY KEY,
+    login_id TEXT NOT NULL UNIQUE,
+    password TEXT NOT NULL
+);
+
+-- 認可コード・トークン・セッションIDは値そのものではなく、SHA-256のハッシュ値で保存・検索する
+CREATE TABLE authorization_codes (
+    code_hash    TEXT PRIMARY KEY,
+    user_id      TEXT NOT NULL,
+    client_id    TEXT NOT NULL,
+    scopes       TEXT NOT NULL,
+    redirect_uri TEXT NOT NULL,
+    expires_at   INTEGER NOT NULL
+);
+
+CREATE TABLE access_tokens (
+    token_hash TEXT PRIMARY KEY,
+    client_id  TEXT NOT NULL,
+    user_id    TEXT NOT NULL,
+    scopes     TEXT NOT NULL,
+    expires_at INTEGER NOT NULL
+);
+CREATE INDEX idx_access_tokens_user_client ON access_tokens (user_id, client_id);
+
+CREATE TABLE refresh_tokens (
+    token_hash        TEXT PRIMARY KEY,
+    access_token_hash TEXT NOT NULL,
+    client_id         TEXT NOT NULL,
+    user_id           TEXT NOT NULL,
+    scopes            TEXT NOT NULL,
+    issued_at         INTEGER NOT NULL,
+    expires_at        INTEGER NOT NULL
+);
+CREATE INDEX idx_refresh_tokens_user_client ON refresh_tokens (user_id, client_id);
+
+-- 日時はUnix時間(ナノ秒)。NULLの場合は未設定
+CREATE TABLE consents (
+    user_id       TEXT NOT NULL,
+    client_id     TEXT NOT NULL,
+    scopes        TEXT NOT NULL,
+    granted_at    INTEGER NOT NULL,
+    updated_at    INTEGER NOT NULL,
+    expires_at    INTEGER,
+    first_used_at INTEGER,
+    last_used_at  INTEGER,
+    PRIMARY KEY (user_id, client_id)
+);
+
+-- user_idがNULLの場合は未ログインのセッション
+CREATE TABLE sessions (
+    session_hash     TEXT PRIMARY KEY,
+    user_id          TEXT,
+    auth_time        INTEGER,
+    amr              TEXT NOT NULL,
+    created_at       INTEGER NOT NULL,
+    last_accessed_at INTEGER NOT NULL
+);
+CREATE INDEX idx_sessions_created_at ON sessions (created_at);
+CREATE INDEX idx_sessions_last_accessed_at ON sessions (last_accessed_at);
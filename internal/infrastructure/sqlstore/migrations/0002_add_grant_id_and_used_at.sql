@@ -0,0 +1,8 @@
+-- 認可コードの再利用を検知するため、使用済みの認可コードは有効期限まで削除せずused_atを記録する
+ALTER TABLE authorization_codes ADD COLUMN used_at INTEGER;
+
+-- 発行の元になった認可コードの識別子。認可コードの再利用を検知した際に、同じ認可から発行したトークンを無効にする
+ALTER TABLE access_tokens ADD COLUMN grant_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE refresh_tokens ADD COLUMN grant_id TEXT NOT NULL DEFAULT '';
+CREATE INDEX idx_access_tokens_grant_id ON access_tokens (grant_id);
+CREATE INDEX idx_refresh_tokens_grant_id ON refresh_tokens (grant_id);
@@ -0,0 +1,5 @@
+-- 期限切れのデータを一括削除する際に全件を走査しないためのインデックス
+CREATE INDEX idx_authorization_codes_expires_at ON authorization_codes (expires_at);
+CREATE INDEX idx_access_tokens_expires_at ON access_tokens (expires_at);
+CREATE INDEX idx_refresh_tokens_expires_at ON refresh_tokens (expires_at);
+CREATE INDEX idx_consents_expires_at ON consents (expires_at);
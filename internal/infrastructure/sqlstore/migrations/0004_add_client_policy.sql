@@ -0,0 +1,2 @@
+-- クライアント毎のポリシー(許可するgrant_type・スコープ、有効期間など)のJSON。空文字の場合は既定のポリシー
+ALTER TABLE clients ADD COLUMN policy TEXT NOT NULL DEFAULT '';
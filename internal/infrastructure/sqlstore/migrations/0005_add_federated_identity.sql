@@ -0,0 +1,4 @@
+-- 上流のIdPのユーザーのissとsub。NULLの場合はパスワードでログインするユーザー
+ALTER TABLE users ADD COLUMN federated_issuer TEXT;
+ALTER TABLE users ADD COLUMN federated_subject TEXT;
+CREATE UNIQUE INDEX idx_users_federated_identity ON users (federated_issuer, federated_subject) WHERE federated_issuer IS NOT NULL;
@@ -0,0 +1,2 @@
+-- パスワードはハッシュ値で保存する。以前の平文のパスワードは起動時にハッシュ値に置き換える(UserRepository.HashPlaintextPasswords)
+ALTER TABLE users RENAME COLUMN password TO password_hash;
@@ -0,0 +1,14 @@
+-- ユーザー毎のTOTPの登録。confirmed_atがNULLの場合は最初のコードの確認待ち
+CREATE TABLE totp_credentials (
+    user_id        TEXT PRIMARY KEY,
+    secret         BLOB NOT NULL,
+    confirmed_at   INTEGER,
+    last_used_step INTEGER NOT NULL DEFAULT 0
+);
+
+-- 未使用のリカバリーコードのSHA-256のハッシュ値。使用すると削除する
+CREATE TABLE totp_recovery_codes (
+    user_id   TEXT NOT NULL,
+    code_hash TEXT NOT NULL,
+    PRIMARY KEY (user_id, code_hash)
+);
@@ -0,0 +1,14 @@
+-- ユーザーが登録したパスキー。public_keyはCOSE_Key形式の公開鍵
+CREATE TABLE passkey_credentials (
+    credential_id   BLOB PRIMARY KEY,
+    user_id         TEXT NOT NULL,
+    login_id        TEXT NOT NULL,
+    public_key      BLOB NOT NULL,
+    sign_count      INTEGER NOT NULL DEFAULT 0,
+    backup_eligible INTEGER NOT NULL DEFAULT 0,
+    name            TEXT NOT NULL DEFAULT '',
+    created_at      INTEGER NOT NULL,
+    last_used_at    INTEGER
+);
+
+CREATE INDEX idx_passkey_credentials_user_id ON passkey_credentials (user_id);
@@ -0,0 +1,3 @@
+-- 設定ファイルから登録したユーザーの、登録に使った設定のパスワードのハッシュ値。設定から登録していないユーザーは空文字列
+-- ログイン時に再ハッシュしたpassword_hashを、設定が変わっていなければ起動時に置き換えないために使う
+ALTER TABLE users ADD COLUMN seeded_password_hash TEXT NOT NULL DEFAULT '';
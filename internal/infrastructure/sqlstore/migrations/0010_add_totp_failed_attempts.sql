@@ -0,0 +1,4 @@
+-- 二要素目のコードの確認に連続して失敗した回数と、コードを確認しない期限。期限がNULLの場合は確認する
+-- 認可リクエスト毎ではなくユーザー毎に数え、確認に成功すると0に戻す
+ALTER TABLE totp_credentials ADD COLUMN failed_attempts INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE totp_credentials ADD COLUMN locked_until INTEGER;
@@ -0,0 +1,75 @@
+package sqlstore
+
+import (
+	"database/sql"
+	"errors"
+	"oauth-tutorial/internal/domain"
+	"oauth-tutorial/internal/infrastructure"
+	"time"
+)
+
+type PasskeyRepository struct {
+	db *sql.DB
+}
+
+var _ infrastructure.PasskeyStore = (*PasskeyRepository)(nil)
+
+func NewPasskeyRepository(db *sql.DB) *PasskeyRepository {
+	return &PasskeyRepository{db: db}
+}
+
+func (r *PasskeyRepository) Save(credential *domain.PasskeyCredential) error {
+	_, err := r.db.Exec(`INSERT INTO passkey_credentials (credential_id, user_id, login_id, public_key, sign_count, backup_eligible, name, created_at, last_used_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (credential_id) DO UPDATE SET user_id = excluded.user_id, login_id = excluded.login_id, public_key = excluded.public_key, sign_count = excluded.sign_count,
+			backup_eligible = excluded.backup_eligible, name = excluded.name, created_at = excluded.created_at, last_used_at = excluded.last_used_at`,
+		credential.CredentialID(), credential.UserID(), credential.LoginID(), credential.PublicKey(), credential.SignCount(),
+		credential.BackupEligible(), credential.Name(), credential.CreatedAt().UnixNano(), toNullTime(credential.LastUsedAt()))
+	return err
+}
+
+func (r *PasskeyRepository) FindByCredentialID(credentialID []byte) (*domain.PasskeyCredential, error) {
+	row := r.db.QueryRow(`SELECT credential_id, user_id, login_id, public_key, sign_count, backup_eligible, name, created_at, last_used_at FROM passkey_credentials WHERE credential_id = ?`,
+		credentialID)
+	credential, err := scanPasskeyCredential(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, infrastructure.ErrPasskeyCredentialNotFound
+	}
+	return credential, err
+}
+
+func (r *PasskeyRepository) FindByUserID(userID string) ([]*domain.PasskeyCredential, error) {
+	rows, err := r.db.Query(`SELECT credential_id, user_id, login_id, public_key, sign_count, backup_eligible, name, created_at, last_used_at FROM passkey_credentials WHERE user_id = ? ORDER BY created_at`,
+		userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	credentials := make([]*domain.PasskeyCredential, 0)
+	for rows.Next() {
+		credential, err := scanPasskeyCredential(rows)
+		if err != nil {
+			return nil, err
+		}
+		credentials = append(credentials, credential)
+	}
+	return credentials, rows.Err()
+}
+
+func scanPasskeyCredential(row interface{ Scan(dest ...any) error }) (*domain.PasskeyCredential, error) {
+	var (
+		credentialID   []byte
+		userID         string
+		loginID        string
+		publicKey      []byte
+		signCount      uint32
+		backupEligible bool
+		name           string
+		createdAt      int64
+		lastUsedAt     sql.NullInt64
+	)
+	if err := row.Scan(&credentialID, &userID, &loginID, &publicKey, &signCount, &backupEligible, &name, &createdAt, &lastUsedAt); err != nil {
+		return nil, err
+	}
+	return domain.ReconstructPasskeyCredential(credentialID, userID, loginID, publicKey, signCount, backupEligible, name, time.Unix(0, createdAt), fromNullTime(lastUsedAt)), nil
+}
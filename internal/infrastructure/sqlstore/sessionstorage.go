@@ -0,0 +1,205 @@
+package sqlstore
+
+import (
+	"database/sql"
+	"errors"
+	"oauth-tutorial/internal/domain"
+	"oauth-tutorial/internal/infrastructure"
+	"oauth-tutorial/internal/infrastructure/dto"
+	"oauth-tutorial/internal/session"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// ブラウザセッションをデータベースに保存するストレージ
+// 有効期間の扱いはinfrastructure.SessionStorageと同じ
+// 累計の統計情報はプロセス毎に数える
+type SessionStorage struct {
+	db *sql.DB
+	// 最終アクセスからの有効期間。0以下の場合は無期限
+	idleTimeout time.Duration
+	// 作成からの有効期間。0以下の場合は無期限
+	absoluteTimeout   time.Duration
+	created           atomic.Uint64
+	deleted           atomic.Uint64
+	idleEvictions     atomic.Uint64
+	absoluteEvictions atomic.Uint64
+	now               func() time.Time
+}
+
+var _ infrastructure.SessionStore = (*SessionStorage)(nil)
+
+func NewSessionStorage(db *sql.DB, idleTimeout time.Duration, absoluteTimeout time.Duration) *SessionStorage {
+	return NewSessionStorageWithClock(db, idleTimeout, absoluteTimeout, time.Now)
+}
+
+// 有効期間の判定に使う現在時刻をnowで差し替える
+func NewSessionStorageWithClock(db *sql.DB, idleTimeout time.Duration, absoluteTimeout time.Duration, now func() time.Time) *SessionStorage {
+	return &SessionStorage{
+		db:              db,
+		idleTimeout:     idleTimeout,
+		absoluteTimeout: absoluteTimeout,
+		now:             now,
+	}
+}
+
+func (s *SessionStorage) Save(sessionID session.SessionID, sessionData *dto.SessionData) error {
+	if sessionID == "" {
+		return infrastructure.ErrInvalidSessionID
+	}
+	if sessionData == nil {
+		return infrastructure.ErrInvalidSessionData
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := s.now()
+	sessionHash := hashValue(string(sessionID))
+	userID := sql.NullString{}
+	if sessionData.User() != nil {
+		userID = sql.NullString{String: sessionData.User().UserID(), Valid: true}
+	}
+	amr := strings.Join(sessionData.AMR(), " ")
+
+	// 既存のセッションを上書きする場合も、作成日時は引き継いで有効期間を延長しない
+	var createdAt, lastAccessedAt int64
+	err = tx.QueryRow(`SELECT created_at, last_accessed_at FROM sessions WHERE session_hash = ?`, sessionHash).Scan(&createdAt, &lastAccessedAt)
+	switch {
+	case err == nil && !s.expired(createdAt, lastAccessedAt, now):
+		_, err = tx.Exec(`UPDATE sessions SET user_id = ?, auth_time = ?, amr = ?, last_accessed_at = ? WHERE session_hash = ?`,
+			userID, toNullTime(sessionData.AuthTime()), amr, now.UnixNano(), sessionHash)
+		if err != nil {
+			return err
+		}
+		return tx.Commit()
+	case err != nil && !errors.Is(err, sql.ErrNoRows):
+		return err
+	}
+
+	_, err = tx.Exec(`INSERT OR REPLACE INTO sessions (session_hash, user_id, auth_time, amr, created_at, last_accessed_at) VALUES (?, ?, ?, ?, ?, ?)`,
+		sessionHash, userID, toNullTime(sessionData.AuthTime()), amr, now.UnixNano(), now.UnixNano())
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	s.created.Add(1)
+	return nil
+}
+
+func (s *SessionStorage) Get(sessionID session.SessionID) (*dto.SessionData, error) {
+	now := s.now()
+	sessionHash := hashValue(string(sessionID))
+	var (
+		userID         sql.NullString
+		loginID        sql.NullString
+		passwordHash   sql.NullString
+		issuer         sql.NullString
+		subject        sql.NullString
+		authTime       sql.NullInt64
+		amr            string
+		createdAt      int64
+		lastAccessedAt int64
+	)
+	err := s.db.QueryRow(`SELECT s.user_id, u.login_id, u.password_hash, u.federated_issuer, u.federated_subject, s.auth_time, s.amr, s.created_at, s.last_accessed_at
+		FROM sessions s LEFT JOIN users u ON u.user_id = s.user_id WHERE s.session_hash = ?`, sessionHash).
+		Scan(&userID, &loginID, &passwordHash, &issuer, &subject, &authTime, &amr, &createdAt, &lastAccessedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, infrastructure.ErrSessionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if s.expired(createdAt, lastAccessedAt, now) {
+		if _, err := s.db.Exec(`DELETE FROM sessions WHERE session_hash = ?`, sessionHash); err != nil {
+			return nil, err
+		}
+		s.countEviction(createdAt, now)
+		return nil, infrastructure.ErrSessionNotFound
+	}
+	if _, err := s.db.Exec(`UPDATE sessions SET last_accessed_at = ? WHERE session_hash = ?`, now.UnixNano(), sessionHash); err != nil {
+		return nil, err
+	}
+
+	var user *domain.User
+	// ユーザーが削除されている場合は未ログインのセッションとして扱う
+	if userID.Valid && loginID.Valid {
+		user = reconstructUser(userID.String, loginID.String, passwordHash.String, issuer, subject)
+	}
+	return dto.NewSessionData(user, fromNullTime(authTime), strings.Fields(amr)), nil
+}
+
+func (s *SessionStorage) Delete(sessionID session.SessionID) error {
+	if sessionID == "" {
+		return infrastructure.ErrInvalidSessionID
+	}
+
+	result, err := s.db.Exec(`DELETE FROM sessions WHERE session_hash = ?`, hashValue(string(sessionID)))
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n > 0 {
+		s.deleted.Add(uint64(n))
+	}
+	return nil
+}
+
+// 有効期間切れのセッションを最大limit件破棄し、破棄した数を返す
+func (s *SessionStorage) Sweep(limit int) (int, error) {
+	now := s.now()
+	evicted := 0
+	if s.absoluteTimeout > 0 {
+		n, err := deleteBatch(s.db, "sessions", "created_at <= ?", limit, now.Add(-s.absoluteTimeout).UnixNano())
+		if err != nil {
+			return evicted, err
+		}
+		s.absoluteEvictions.Add(uint64(n))
+		evicted += n
+	}
+	if s.idleTimeout > 0 && evicted < limit {
+		n, err := deleteBatch(s.db, "sessions", "last_accessed_at <= ?", limit-evicted, now.Add(-s.idleTimeout).UnixNano())
+		if err != nil {
+			return evicted, err
+		}
+		s.idleEvictions.Add(uint64(n))
+		evicted += n
+	}
+	return evicted, nil
+}
+
+// セッションの統計情報を返す
+func (s *SessionStorage) Stats() (infrastructure.SessionStats, error) {
+	var live int
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM sessions`).Scan(&live); err != nil {
+		return infrastructure.SessionStats{}, err
+	}
+	return infrastructure.SessionStats{
+		Live:              live,
+		Created:           s.created.Load(),
+		Deleted:           s.deleted.Load(),
+		IdleEvictions:     s.idleEvictions.Load(),
+		AbsoluteEvictions: s.absoluteEvictions.Load(),
+	}, nil
+}
+
+func (s *SessionStorage) expired(createdAt int64, lastAccessedAt int64, now time.Time) bool {
+	return s.absoluteExpired(createdAt, now) || (s.idleTimeout > 0 && now.Sub(time.Unix(0, lastAccessedAt)) >= s.idleTimeout)
+}
+
+func (s *SessionStorage) absoluteExpired(createdAt int64, now time.Time) bool {
+	return s.absoluteTimeout > 0 && now.Sub(time.Unix(0, createdAt)) >= s.absoluteTimeout
+}
+
+func (s *SessionStorage) countEviction(createdAt int64, now time.Time) {
+	if s.absoluteExpired(createdAt, now) {
+		s.absoluteEvictions.Add(1)
+		return
+	}
+	s.idleEvictions.Add(1)
+}
@@ -0,0 +1,77 @@
+package sqlstore
+
+import (
+	"oauth-tutorial/internal/domain"
+	"oauth-tutorial/internal/infrastructure"
+	"oauth-tutorial/internal/infrastructure/dto"
+	"testing"
+	"time"
+)
+
+func Test_セッションの保存と取得(t *testing.T) {
+	db := openTestDB(t)
+	user := domain.ReconstructUser("user-1", "test-user@example.com", "password")
+	NewUserRepository(db).Save(user)
+	storage := NewSessionStorage(db, time.Hour, 24*time.Hour)
+	authTime := time.Now().Truncate(time.Second)
+
+	if err := storage.Save("session-1", dto.NewSessionData(nil, time.Time{}, nil)); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	anonymous, err := storage.Get("session-1")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if anonymous.IsAuthenticated() {
+		t.Error("session without user should not be authenticated")
+	}
+
+	storage.Save("session-1", dto.NewSessionData(user, authTime, []string{"pwd"}))
+	actual, err := storage.Get("session-1")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if actual.User().UserID() != "user-1" || !actual.AuthTime().Equal(authTime) || len(actual.AMR()) != 1 || actual.AMR()[0] != "pwd" {
+		t.Errorf("Get() = %+v", actual)
+	}
+
+	storage.Delete("session-1")
+	if _, err := storage.Get("session-1"); err != infrastructure.ErrSessionNotFound {
+		t.Errorf("Get() error = %v, want %v", err, infrastructure.ErrSessionNotFound)
+	}
+	stats, _ := storage.Stats()
+	if stats.Created != 1 || stats.Deleted != 1 || stats.Live != 0 {
+		t.Errorf("Stats() = %+v, want Created=1 Deleted=1 Live=0", stats)
+	}
+}
+
+func Test_有効期間切れのセッションの破棄(t *testing.T) {
+	storage := NewSessionStorage(openTestDB(t), time.Hour, 24*time.Hour)
+	now := time.Now()
+	storage.now = func() time.Time { return now }
+	storage.Save("idle", dto.NewSessionData(nil, time.Time{}, nil))
+	storage.Save("absolute", dto.NewSessionData(nil, time.Time{}, nil))
+
+	// absoluteのみアクセスし続けて、最終アクセスからの有効期間を延長する
+	for range 28 {
+		now = now.Add(50 * time.Minute)
+		storage.Get("absolute")
+	}
+	if _, err := storage.Get("idle"); err != infrastructure.ErrSessionNotFound {
+		t.Errorf("Get(idle) error = %v, want %v", err, infrastructure.ErrSessionNotFound)
+	}
+	// 作成から24時間を過ぎたセッションは最終アクセスに関わらず破棄する
+	now = now.Add(50 * time.Minute)
+
+	evicted, err := storage.Sweep(100)
+	if err != nil {
+		t.Fatalf("Sweep() error = %v", err)
+	}
+	if evicted != 1 {
+		t.Errorf("Sweep() = %d, want 1", evicted)
+	}
+	stats, _ := storage.Stats()
+	if stats.IdleEvictions != 1 || stats.AbsoluteEvictions != 1 || stats.Live != 0 {
+		t.Errorf("Stats() = %+v, want IdleEvictions=1 AbsoluteEvictions=1 Live=0", stats)
+	}
+}
@@ -0,0 +1,209 @@
+package sqlstore
+
+import (
+	"database/sql"
+	"errors"
+	"oauth-tutorial/internal/domain"
+	"oauth-tutorial/internal/infrastructure"
+	"time"
+)
+
+type TokenRepository struct {
+	db *sql.DB
+}
+
+var _ infrastructure.TokenStore = (*TokenRepository)(nil)
+
+func NewTokenRepository(db *sql.DB) *TokenRepository {
+	return &TokenRepository{db: db}
+}
+
+func (r *TokenRepository) Save(token *domain.AccessToken) error {
+	_, err := r.db.Exec(`INSERT INTO access_tokens (token_hash, client_id, user_id, scopes, expires_at, grant_id) VALUES (?, ?, ?, ?, ?, ?)`,
+		hashValue(token.Value()), token.ClientID(), token.UserID(), domain.FormatScope(token.Scopes()), token.ExpiresAt(), token.GrantID())
+	return err
+}
+
+func (r *TokenRepository) SaveRefreshToken(token *domain.RefreshToken, accessToken *domain.AccessToken) error {
+	_, err := r.db.Exec(`INSERT INTO refresh_tokens (token_hash, access_token_hash, client_id, user_id, scopes, issued_at, expires_at, grant_id) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		hashValue(token.Value()), hashValue(accessToken.Value()), token.ClientID(), token.UserID(), domain.FormatScope(token.Scopes()), token.IssuedAt(), token.ExpiresAt(), token.GrantID())
+	return err
+}
+
+func (r *TokenRepository) FindByAccessToken(token string) (*domain.AccessToken, error) {
+	var (
+		clientID  string
+		userID    string
+		scopes    string
+		expiresAt int64
+		grantID   string
+	)
+	err := r.db.QueryRow(`SELECT client_id, user_id, scopes, expires_at, grant_id FROM access_tokens WHERE token_hash = ?`, hashValue(token)).
+		Scan(&clientID, &userID, &scopes, &expiresAt, &grantID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, infrastructure.ErrAccessTokenNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return domain.ReconstructAccessToken(token, clientID, userID, domain.ParseScope(scopes), expiresAt, grantID), nil
+}
+
+func (r *TokenRepository) FindByRefreshToken(token string) (*domain.RefreshToken, error) {
+	row := r.db.QueryRow(`SELECT client_id, user_id, scopes, issued_at, expires_at, grant_id FROM refresh_tokens WHERE token_hash = ?`, hashValue(token))
+	return scanRefreshToken(row, token)
+}
+
+func (r *TokenRepository) DeleteRefreshToken(token string) error {
+	_, err := r.db.Exec(`DELETE FROM refresh_tokens WHERE token_hash = ?`, hashValue(token))
+	return err
+}
+
+// リフレッシュトークンを取得すると同時に削除する
+// 1つのDELETE文で取得と削除を行うため、並行して消費しても取得できるのは1回のみ
+func (r *TokenRepository) ConsumeRefreshToken(token string) (*domain.RefreshToken, error) {
+	row := r.db.QueryRow(`DELETE FROM refresh_tokens WHERE token_hash = ? RETURNING client_id, user_id, scopes, issued_at, expires_at, grant_id`, hashValue(token))
+	return scanRefreshToken(row, token)
+}
+
+func scanRefreshToken(row *sql.Row, token string) (*domain.RefreshToken, error) {
+	var (
+		clientID  string
+		userID    string
+		scopes    string
+		issuedAt  int64
+		expiresAt int64
+		grantID   string
+	)
+	err := row.Scan(&clientID, &userID, &scopes, &issuedAt, &expiresAt, &grantID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, infrastructure.ErrRefreshTokenNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return domain.ReconstructRefreshToken(token, clientID, userID, domain.ParseScope(scopes), issuedAt, expiresAt, grantID), nil
+}
+
+// ユーザーがクライアントに対して発行した有効期限内のリフレッシュトークンを発行日時の順に返す
+// トークンの値はハッシュ値しか保存していないため、返すリフレッシュトークンの値は空文字になる
+func (r *TokenRepository) FindRefreshTokensByUser(userID string, now time.Time) ([]*domain.RefreshToken, error) {
+	rows, err := r.db.Query(`SELECT client_id, scopes, issued_at, expires_at, grant_id FROM refresh_tokens WHERE user_id = ? AND expires_at >= ? ORDER BY issued_at`,
+		userID, now.Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := make([]*domain.RefreshToken, 0)
+	for rows.Next() {
+		var (
+			clientID  string
+			scopes    string
+			issuedAt  int64
+			expiresAt int64
+			grantID   string
+		)
+		if err := rows.Scan(&clientID, &scopes, &issuedAt, &expiresAt, &grantID); err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, domain.ReconstructRefreshToken("", clientID, userID, domain.ParseScope(scopes), issuedAt, expiresAt, grantID))
+	}
+	return tokens, rows.Err()
+}
+
+func (r *TokenRepository) FindRefreshTokensByUserAndClient(userID string, clientID string, now time.Time) ([]*domain.RefreshToken, error) {
+	rows, err := r.db.Query(`SELECT scopes, issued_at, expires_at, grant_id FROM refresh_tokens WHERE user_id = ? AND client_id = ? AND expires_at >= ? ORDER BY issued_at`,
+		userID, clientID, now.Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := make([]*domain.RefreshToken, 0)
+	for rows.Next() {
+		var (
+			scopes    string
+			issuedAt  int64
+			expiresAt int64
+			grantID   string
+		)
+		if err := rows.Scan(&scopes, &issuedAt, &expiresAt, &grantID); err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, domain.ReconstructRefreshToken("", clientID, userID, domain.ParseScope(scopes), issuedAt, expiresAt, grantID))
+	}
+	return tokens, rows.Err()
+}
+
+// ユーザーがクライアントに対して発行した全てのアクセストークンとリフレッシュトークンを無効にし、無効にした数を返す
+func (r *TokenRepository) RevokeByUserAndClient(userID string, clientID string) (int, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	revoked := 0
+	for _, query := range []string{
+		`DELETE FROM access_tokens WHERE user_id = ? AND client_id = ?`,
+		`DELETE FROM refresh_tokens WHERE user_id = ? AND client_id = ?`,
+	} {
+		result, err := tx.Exec(query, userID, clientID)
+		if err != nil {
+			return 0, err
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return 0, err
+		}
+		revoked += int(n)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return revoked, nil
+}
+
+// 同じ認可から発行した全てのアクセストークンとリフレッシュトークンを無効にし、無効にした数を返す
+func (r *TokenRepository) RevokeByGrantID(grantID string) (int, error) {
+	// 認可コード以外から発行したトークンを巻き込まないよう、空の識別子では何もしない
+	if grantID == "" {
+		return 0, nil
+	}
+	tx, err := r.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	revoked := 0
+	for _, query := range []string{
+		`DELETE FROM access_tokens WHERE grant_id = ?`,
+		`DELETE FROM refresh_tokens WHERE grant_id = ?`,
+	} {
+		result, err := tx.Exec(query, grantID)
+		if err != nil {
+			return 0, err
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return 0, err
+		}
+		revoked += int(n)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return revoked, nil
+}
+
+// 有効期限切れのアクセストークンとリフレッシュトークンを最大limit件削除し、削除した数を返す
+func (r *TokenRepository) PurgeExpired(now time.Time, limit int) (int, error) {
+	purged, err := deleteBatch(r.db, "access_tokens", "expires_at < ?", limit, now.Unix())
+	if err != nil || purged >= limit {
+		return purged, err
+	}
+	n, err := deleteBatch(r.db, "refresh_tokens", "expires_at < ?", limit-purged, now.Unix())
+	return purged + n, err
+}
@@ -0,0 +1,83 @@
+package sqlstore
+
+import (
+	"oauth-tutorial/internal/domain"
+	"oauth-tutorial/internal/infrastructure"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_アクセストークンとリフレッシュトークンの保存と検索(t *testing.T) {
+	repo := NewTokenRepository(openTestDB(t))
+	now := time.Now()
+	accessToken := domain.NewAccessToken("client-1", "user-1", []string{"read"}, now, domain.AccessTokenDuration)
+	refreshToken := domain.NewRefreshToken("client-1", "user-1", []string{"read"}, now, domain.RefreshTokenDuration)
+	if err := repo.Save(accessToken); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	if err := repo.SaveRefreshToken(refreshToken, accessToken); err != nil {
+		t.Fatalf("SaveRefreshToken() error = %v", err)
+	}
+
+	actualAccess, err := repo.FindByAccessToken(accessToken.Value())
+	if err != nil {
+		t.Fatalf("FindByAccessToken() error = %v", err)
+	}
+	if !reflect.DeepEqual(actualAccess, accessToken) {
+		t.Errorf("FindByAccessToken() = %v, want %v", actualAccess, accessToken)
+	}
+	actualRefresh, err := repo.FindByRefreshToken(refreshToken.Value())
+	if err != nil {
+		t.Fatalf("FindByRefreshToken() error = %v", err)
+	}
+	if !reflect.DeepEqual(actualRefresh, refreshToken) {
+		t.Errorf("FindByRefreshToken() = %v, want %v", actualRefresh, refreshToken)
+	}
+
+	repo.DeleteRefreshToken(refreshToken.Value())
+	if _, err := repo.FindByRefreshToken(refreshToken.Value()); err != infrastructure.ErrRefreshTokenNotFound {
+		t.Errorf("FindByRefreshToken() error = %v, want %v", err, infrastructure.ErrRefreshTokenNotFound)
+	}
+	if _, err := repo.FindByAccessToken("unknown"); err != infrastructure.ErrAccessTokenNotFound {
+		t.Errorf("FindByAccessToken() error = %v, want %v", err, infrastructure.ErrAccessTokenNotFound)
+	}
+}
+
+func Test_ユーザーとクライアント単位でのトークンの検索と無効化(t *testing.T) {
+	repo := NewTokenRepository(openTestDB(t))
+	now := time.Now()
+	save := func(clientID, userID string, issuedAt time.Time) (*domain.AccessToken, *domain.RefreshToken) {
+		at := domain.NewAccessToken(clientID, userID, []string{"read"}, issuedAt, domain.AccessTokenDuration)
+		rt := domain.NewRefreshToken(clientID, userID, []string{"read"}, issuedAt, domain.RefreshTokenDuration)
+		repo.Save(at)
+		repo.SaveRefreshToken(rt, at)
+		return at, rt
+	}
+	target, targetRefresh := save("client-1", "user-1", now)
+	save("client-1", "user-1", now.Add(-2*domain.RefreshTokenDuration))
+	otherClient, _ := save("client-2", "user-1", now)
+
+	// 有効期限切れのリフレッシュトークンは含まない。値はハッシュ値しか保存していないため空になる
+	tokens, err := repo.FindRefreshTokensByUserAndClient("user-1", "client-1", now)
+	if err != nil {
+		t.Fatalf("FindRefreshTokensByUserAndClient() error = %v", err)
+	}
+	if len(tokens) != 1 || tokens[0].IssuedAt() != targetRefresh.IssuedAt() || tokens[0].Value() != "" {
+		t.Fatalf("FindRefreshTokensByUserAndClient() = %v, want 1 token issued at %d", tokens, targetRefresh.IssuedAt())
+	}
+
+	if _, err := repo.RevokeByUserAndClient("user-1", "client-1"); err != nil {
+		t.Fatalf("RevokeByUserAndClient() error = %v", err)
+	}
+	if _, err := repo.FindByAccessToken(target.Value()); err == nil {
+		t.Error("access token of the revoked client should be deleted")
+	}
+	if _, err := repo.FindByRefreshToken(targetRefresh.Value()); err == nil {
+		t.Error("refresh token of the revoked client should be deleted")
+	}
+	// 他のクライアントのトークンは残ること
+	if _, err := repo.FindByAccessToken(otherClient.Value()); err != nil {
+		t.Errorf("access token of another client should remain: %v", err)
+	}
+}
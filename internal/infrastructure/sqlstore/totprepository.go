@@ -0,0 +1,155 @@
+package sqlstore
+
+import (
+	"database/sql"
+	"errors"
+	"oauth-tutorial/internal/domain"
+	"oauth-tutorial/internal/infrastructure"
+	"time"
+)
+
+type TOTPRepository struct {
+	db *sql.DB
+}
+
+var _ infrastructure.TOTPStore = (*TOTPRepository)(nil)
+
+func NewTOTPRepository(db *sql.DB) *TOTPRepository {
+	return &TOTPRepository{db: db}
+}
+
+// 1つのトランザクションで登録とリカバリーコードを置き換える
+func (r *TOTPRepository) Save(credential *domain.TOTPCredential) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`INSERT INTO totp_credentials (user_id, secret, confirmed_at, last_used_step, failed_attempts, locked_until) VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (user_id) DO UPDATE SET secret = excluded.secret, confirmed_at = excluded.confirmed_at, last_used_step = excluded.last_used_step,
+			failed_attempts = excluded.failed_attempts, locked_until = excluded.locked_until`,
+		credential.UserID(), credential.Secret(), toNullTime(credential.ConfirmedAt()), credential.LastUsedStep(),
+		credential.FailedAttempts(), toNullTime(credential.LockedUntil())); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM totp_recovery_codes WHERE user_id = ?`, credential.UserID()); err != nil {
+		return err
+	}
+	for _, codeHash := range credential.RecoveryCodeHashes() {
+		if _, err := tx.Exec(`INSERT INTO totp_recovery_codes (user_id, code_hash) VALUES (?, ?)`, credential.UserID(), codeHash); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (r *TOTPRepository) FindByUserID(userID string) (*domain.TOTPCredential, error) {
+	var (
+		secret         []byte
+		confirmedAt    sql.NullInt64
+		lastUsedStep   int64
+		failedAttempts int
+		lockedUntil    sql.NullInt64
+	)
+	err := r.db.QueryRow(`SELECT secret, confirmed_at, last_used_step, failed_attempts, locked_until FROM totp_credentials WHERE user_id = ?`, userID).
+		Scan(&secret, &confirmedAt, &lastUsedStep, &failedAttempts, &lockedUntil)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, infrastructure.ErrTOTPCredentialNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := r.db.Query(`SELECT code_hash FROM totp_recovery_codes WHERE user_id = ? ORDER BY code_hash`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	hashes := make([]string, 0)
+	for rows.Next() {
+		var codeHash string
+		if err := rows.Scan(&codeHash); err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, codeHash)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return domain.ReconstructTOTPCredential(userID, secret, fromNullTime(confirmedAt), lastUsedStep, hashes, failedAttempts, fromNullTime(lockedUntil)), nil
+}
+
+// 条件付きのUPDATEで、最後に使ったステップの比較と更新を1つの文で行う
+func (r *TOTPRepository) UseStep(userID string, step int64) (bool, error) {
+	result, err := r.db.Exec(`UPDATE totp_credentials SET last_used_step = ? WHERE user_id = ? AND last_used_step < ?`, step, userID, step)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if n == 1 {
+		return true, nil
+	}
+	if _, err := r.FindByUserID(userID); err != nil {
+		return false, err
+	}
+	return false, nil
+}
+
+func (r *TOTPRepository) ConsumeRecoveryCode(userID string, codeHash string) (bool, error) {
+	result, err := r.db.Exec(`DELETE FROM totp_recovery_codes WHERE user_id = ? AND code_hash = ?`, userID, codeHash)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if n == 1 {
+		return true, nil
+	}
+	if _, err := r.FindByUserID(userID); err != nil {
+		return false, err
+	}
+	return false, nil
+}
+
+// 失敗した回数は1つの文で加算し、並行した失敗を取りこぼさない
+// 期限は加算後の回数から計算する。並行した失敗では遅い方の期限が残るよう、より後の期限のみ書き込む
+func (r *TOTPRepository) RecordFailedAttempt(userID string, now time.Time) (*domain.TOTPCredential, error) {
+	var failedAttempts int
+	err := r.db.QueryRow(`UPDATE totp_credentials SET failed_attempts = failed_attempts + 1 WHERE user_id = ? RETURNING failed_attempts`, userID).
+		Scan(&failedAttempts)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, infrastructure.ErrTOTPCredentialNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if lockout := domain.TOTPLockout(failedAttempts); lockout > 0 {
+		lockedUntil := now.Add(lockout).UnixNano()
+		if _, err := r.db.Exec(`UPDATE totp_credentials SET locked_until = ? WHERE user_id = ? AND (locked_until IS NULL OR locked_until < ?)`,
+			lockedUntil, userID, lockedUntil); err != nil {
+			return nil, err
+		}
+	}
+	return r.FindByUserID(userID)
+}
+
+func (r *TOTPRepository) ResetFailedAttempts(userID string) error {
+	result, err := r.db.Exec(`UPDATE totp_credentials SET failed_attempts = 0, locked_until = NULL WHERE user_id = ?`, userID)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return infrastructure.ErrTOTPCredentialNotFound
+	}
+	return nil
+}
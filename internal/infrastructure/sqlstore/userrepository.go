@@ -0,0 +1,122 @@
+package sqlstore
+
+import (
+	"database/sql"
+	"errors"
+	"oauth-tutorial/internal/domain"
+	"oauth-tutorial/internal/infrastructure"
+)
+
+type UserRepository struct {
+	db *sql.DB
+}
+
+var _ infrastructure.UserStore = (*UserRepository)(nil)
+
+func NewUserRepository(db *sql.DB) *UserRepository {
+	return &UserRepository{db: db}
+}
+
+func (r *UserRepository) Save(user *domain.User) error {
+	var issuer, subject sql.NullString
+	if identity := user.FederatedIdentity(); identity != nil {
+		issuer = sql.NullString{String: identity.Issuer(), Valid: true}
+		subject = sql.NullString{String: identity.Subject(), Valid: true}
+	}
+	_, err := r.db.Exec(`INSERT INTO users (user_id, login_id, password_hash, federated_issuer, federated_subject) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (user_id) DO UPDATE SET login_id = excluded.login_id, password_hash = excluded.password_hash,
+			federated_issuer = excluded.federated_issuer, federated_subject = excluded.federated_subject`,
+		user.UserID(), user.LoginID(), user.PasswordHash(), issuer, subject)
+	return err
+}
+
+func (r *UserRepository) SaveSeedUser(user *domain.User) error {
+	_, err := r.db.Exec(`INSERT INTO users (user_id, login_id, password_hash, seeded_password_hash) VALUES (?, ?, ?, ?)
+		ON CONFLICT (user_id) DO UPDATE SET login_id = excluded.login_id, password_hash = excluded.password_hash,
+			seeded_password_hash = excluded.seeded_password_hash, federated_issuer = NULL, federated_subject = NULL`,
+		user.UserID(), user.LoginID(), user.PasswordHash(), user.PasswordHash())
+	return err
+}
+
+func (r *UserRepository) FindSeededPasswordHash(loginID string) (string, error) {
+	var seeded string
+	err := r.db.QueryRow(`SELECT seeded_password_hash FROM users WHERE login_id = ?`, loginID).Scan(&seeded)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", infrastructure.ErrUserNotFound
+	}
+	return seeded, err
+}
+
+func (r *UserRepository) FindByLoginID(loginID string) (*domain.User, error) {
+	var userID, passwordHash string
+	var issuer, subject sql.NullString
+	err := r.db.QueryRow(`SELECT user_id, password_hash, federated_issuer, federated_subject FROM users WHERE login_id = ?`, loginID).
+		Scan(&userID, &passwordHash, &issuer, &subject)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, infrastructure.ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return reconstructUser(userID, loginID, passwordHash, issuer, subject), nil
+}
+
+func (r *UserRepository) FindByFederatedIdentity(identity domain.FederatedIdentity) (*domain.User, error) {
+	var userID, loginID string
+	err := r.db.QueryRow(`SELECT user_id, login_id FROM users WHERE federated_issuer = ? AND federated_subject = ?`,
+		identity.Issuer(), identity.Subject()).Scan(&userID, &loginID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, infrastructure.ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return domain.NewFederatedUser(userID, loginID, identity), nil
+}
+
+// マイグレーション0006で列名のみ変更した、平文のパスワードの行を置き換える。ハッシュ値は全て$で始まる
+func (r *UserRepository) HashPlaintextPasswords(hash func(password string) string) ([]string, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	rows, err := tx.Query(`SELECT user_id, login_id, password_hash FROM users WHERE password_hash <> '' AND password_hash NOT LIKE '$%'`)
+	if err != nil {
+		return nil, err
+	}
+	type plaintextRow struct{ userID, loginID, password string }
+	var plaintexts []plaintextRow
+	for rows.Next() {
+		var row plaintextRow
+		if err := rows.Scan(&row.userID, &row.loginID, &row.password); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		plaintexts = append(plaintexts, row)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	hashed := make([]string, 0, len(plaintexts))
+	for _, row := range plaintexts {
+		if _, err := tx.Exec(`UPDATE users SET password_hash = ? WHERE user_id = ?`, hash(row.password), row.userID); err != nil {
+			return nil, err
+		}
+		hashed = append(hashed, row.loginID)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return hashed, nil
+}
+
+// usersテーブルの行からユーザーを復元する
+func reconstructUser(userID, loginID, passwordHash string, issuer, subject sql.NullString) *domain.User {
+	if identity, err := domain.NewFederatedIdentity(issuer.String, subject.String); err == nil {
+		return domain.NewFederatedUser(userID, loginID, identity)
+	}
+	return domain.ReconstructUser(userID, loginID, passwordHash)
+}
@@ -0,0 +1,131 @@
+package infrastructure
+
+import (
+	"oauth-tutorial/internal/domain"
+	"oauth-tutorial/internal/infrastructure/dto"
+	"oauth-tutorial/internal/session"
+	"sync"
+	"time"
+)
+
+// 永続化の実装(インメモリ、SQLなど)が満たすべきインターフェース
+// 見つからない場合はこのパッケージのErrXxxNotFoundを返すこと
+// PurgeExpired・Sweepは有効期限切れのデータを最大limit件(limitは1以上)削除し、削除した数を返す
+// 長時間ロックを保持しないよう、呼び出し元は削除した数がlimit未満になるまで繰り返し呼び出す
+
+type ClientStore interface {
+	Save(client *domain.Client) error
+	SelectByClientID(clientID domain.ClientID) (*domain.Client, error)
+	FindByID(clientID string) (*domain.Client, error)
+	// 登録済みのクライアントを全て置き換える
+	// 設定の再読み込みで使うため、置き換えの途中の状態(一部のクライアントのみ存在する状態)を検索できないようにすること
+	ReplaceAll(clients []*domain.Client) error
+}
+
+type UserStore interface {
+	Save(user *domain.User) error
+	FindByLoginID(loginID string) (*domain.User, error)
+	// 上流のIdPのユーザーを検索する
+	FindByFederatedIdentity(identity domain.FederatedIdentity) (*domain.User, error)
+	// ハッシュ化する前のバージョンで保存した平文のパスワード(mycrypto.IsPlaintextPassword)をhashでハッシュ値に置き換え、
+	// 置き換えたユーザーのログインIDを返す
+	HashPlaintextPasswords(hash func(password string) string) ([]string, error)
+	// 設定ファイルから登録するユーザーを保存する。保存したパスワードのハッシュ値を、登録に使った設定のパスワードのハッシュ値としても記録する
+	// 記録した値はログイン時に再ハッシュしても変わらず、次回の起動時に設定のパスワードが変わったかの判定に使う
+	SaveSeedUser(user *domain.User) error
+	// SaveSeedUserで記録した、登録に使った設定のパスワードのハッシュ値。設定ファイルから登録していないユーザーは空文字列
+	FindSeededPasswordHash(loginID string) (string, error)
+}
+
+type AuthCodeStore interface {
+	Save(code *domain.AuthorizationCode) error
+	FindByCode(code string) (*domain.AuthorizationCode, error)
+	Delete(code string) error
+	// 認可コードを取得すると同時に使用済みにする。同じ認可コードを並行して消費しても、取得できるのは1回のみ
+	// 使用済みの認可コードは有効期限までFindByCodeでは見つからず、再度消費するとErrAuthorizationCodeAlreadyUsedを返す
+	Consume(code string) (*domain.AuthorizationCode, error)
+	// ユーザーがクライアントに発行した未使用の認可コードを削除し、削除した数を返す。使用済みの認可コードは再利用の検知のため残す
+	DeleteByUserAndClient(userID string, clientID string) (int, error)
+	// 使用済みのものを含め、有効期限切れの認可コードを削除する
+	PurgeExpired(now time.Time, limit int) (int, error)
+}
+
+type TokenStore interface {
+	Save(token *domain.AccessToken) error
+	SaveRefreshToken(token *domain.RefreshToken, accessToken *domain.AccessToken) error
+	FindByAccessToken(token string) (*domain.AccessToken, error)
+	FindByRefreshToken(token string) (*domain.RefreshToken, error)
+	DeleteRefreshToken(token string) error
+	// リフレッシュトークンを取得すると同時に削除する。同じリフレッシュトークンを並行して消費しても、取得できるのは1回のみ
+	ConsumeRefreshToken(token string) (*domain.RefreshToken, error)
+	// ユーザーに発行した有効期限内のリフレッシュトークンを、クライアントを問わず発行日時の順に返す
+	FindRefreshTokensByUser(userID string, now time.Time) ([]*domain.RefreshToken, error)
+	FindRefreshTokensByUserAndClient(userID string, clientID string, now time.Time) ([]*domain.RefreshToken, error)
+	// ユーザーがクライアントに発行した全てのアクセストークンとリフレッシュトークンを無効にし、無効にした数を返す
+	RevokeByUserAndClient(userID string, clientID string) (int, error)
+	// 同じ認可から発行した全てのアクセストークンとリフレッシュトークンを無効にし、無効にした数を返す
+	RevokeByGrantID(grantID string) (int, error)
+	// 有効期限切れのアクセストークンとリフレッシュトークンを削除する
+	PurgeExpired(now time.Time, limit int) (int, error)
+}
+
+type ConsentStore interface {
+	Save(consent *domain.Consent) error
+	FindByUserAndClient(userID string, clientID string) (*domain.Consent, error)
+	FindByUserID(userID string) ([]*domain.Consent, error)
+	Delete(userID string, clientID string) error
+	// 有効期限切れの同意を削除する
+	PurgeExpired(now time.Time, limit int) (int, error)
+}
+
+// ユーザー毎のTOTPの登録。1ユーザーにつき1件で、同じユーザーの登録は上書きする
+type TOTPStore interface {
+	Save(credential *domain.TOTPCredential) error
+	FindByUserID(userID string) (*domain.TOTPCredential, error)
+	// stepが最後に使ったステップより後の場合のみ最後に使ったステップを更新し、trueを返す
+	// 同じコードを並行して使っても、trueを返すのは1回のみ
+	UseStep(userID string, step int64) (bool, error)
+	// 未使用のリカバリーコードを使用済みにし、trueを返す。未登録・使用済みの場合はfalseを返す
+	// 同じリカバリーコードを並行して使っても、trueを返すのは1回のみ
+	ConsumeRecoveryCode(userID string, codeHash string) (bool, error)
+	// 二要素目のコードの確認に失敗したことを記録し、記録後の登録を返す。並行して失敗しても全ての失敗を数える
+	RecordFailedAttempt(userID string, now time.Time) (*domain.TOTPCredential, error)
+	// 二要素目のコードの確認に成功した場合に、連続して失敗した回数を0に戻す
+	ResetFailedAttempts(userID string) error
+}
+
+// ユーザーが登録したパスキー。1ユーザーにつき複数件登録でき、同じクレデンシャルIDの登録は上書きする
+type PasskeyStore interface {
+	Save(credential *domain.PasskeyCredential) error
+	FindByCredentialID(credentialID []byte) (*domain.PasskeyCredential, error)
+	// 登録日時の順に返す。登録がない場合は空のスライスを返す
+	FindByUserID(userID string) ([]*domain.PasskeyCredential, error)
+}
+
+type SessionStore interface {
+	Save(sessionID session.SessionID, sessionData *dto.SessionData) error
+	Get(sessionID session.SessionID) (*dto.SessionData, error)
+	Delete(sessionID session.SessionID) error
+	// 有効期間切れのセッションを破棄する
+	Sweep(limit int) (int, error)
+	Stats() (SessionStats, error)
+}
+
+// 一定間隔でfnを実行するゴルーチンを起動する。返り値の関数を呼ぶと停止する
+func RunPeriodically(interval time.Duration, fn func()) (stop func()) {
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				fn()
+			case <-done:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
+}
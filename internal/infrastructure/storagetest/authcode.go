@@ -0,0 +1,166 @@
+package storagetest
+
+import (
+	"errors"
+	"oauth-tutorial/internal/domain"
+	"oauth-tutorial/internal/infrastructure"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newAuthorizationCode(value string, now time.Time) *domain.AuthorizationCode {
+	return domain.ReconstructAuthorizationCode(value, "user-1", "client-1", []string{"read", "write"}, "https://client.example.com/callback",
+		now.Add(domain.AUTHORIZATION_CODE_DURATION).Unix())
+}
+
+func testAuthCodes(t *testing.T, newBackend Factory) {
+	t.Run("保存と検索と削除", func(t *testing.T) {
+		store := newBackend(t).AuthCodes
+		now := time.Now()
+		code := newAuthorizationCode("code-1", now)
+		if err := store.Save(code); err != nil {
+			t.Fatalf("Save() error = %v", err)
+		}
+
+		actual, err := store.FindByCode("code-1")
+		if err != nil {
+			t.Fatalf("FindByCode() error = %v", err)
+		}
+		assertAuthorizationCode(t, actual, code)
+		// 有効期限は保存した値のまま判定できること
+		if actual.IsExpired(now) || !actual.IsExpired(now.Add(domain.AUTHORIZATION_CODE_DURATION+time.Second)) {
+			t.Errorf("IsExpired() does not match the saved expiresAt %d", code.ExpiresAt())
+		}
+
+		if err := store.Delete("code-1"); err != nil {
+			t.Fatalf("Delete() error = %v", err)
+		}
+		if _, err := store.FindByCode("code-1"); !errors.Is(err, infrastructure.ErrAuthorizationCodeNotFound) {
+			t.Errorf("FindByCode() error = %v, want %v", err, infrastructure.ErrAuthorizationCodeNotFound)
+		}
+		// 存在しない認可コードの削除はエラーにしない
+		if err := store.Delete("code-1"); err != nil {
+			t.Errorf("Delete() of a missing code error = %v", err)
+		}
+	})
+
+	t.Run("消費できるのは1回のみ", func(t *testing.T) {
+		store := newBackend(t).AuthCodes
+		code := newAuthorizationCode("code-1", time.Now())
+		store.Save(code)
+
+		actual, err := store.Consume("code-1")
+		if err != nil {
+			t.Fatalf("Consume() error = %v", err)
+		}
+		assertAuthorizationCode(t, actual, code)
+		// 再利用を検知できるよう、存在しない認可コードとは区別する
+		if _, err := store.Consume("code-1"); !errors.Is(err, infrastructure.ErrAuthorizationCodeAlreadyUsed) {
+			t.Errorf("second Consume() error = %v, want %v", err, infrastructure.ErrAuthorizationCodeAlreadyUsed)
+		}
+		if _, err := store.FindByCode("code-1"); !errors.Is(err, infrastructure.ErrAuthorizationCodeNotFound) {
+			t.Errorf("FindByCode() after Consume() error = %v, want %v", err, infrastructure.ErrAuthorizationCodeNotFound)
+		}
+		if _, err := store.Consume("unknown"); !errors.Is(err, infrastructure.ErrAuthorizationCodeNotFound) {
+			t.Errorf("Consume() of unknown code error = %v, want %v", err, infrastructure.ErrAuthorizationCodeNotFound)
+		}
+	})
+
+	t.Run("削除した認可コードは使用済みとしても扱わない", func(t *testing.T) {
+		store := newBackend(t).AuthCodes
+		store.Save(newAuthorizationCode("code-1", time.Now()))
+		store.Consume("code-1")
+
+		if err := store.Delete("code-1"); err != nil {
+			t.Fatalf("Delete() error = %v", err)
+		}
+		if _, err := store.Consume("code-1"); !errors.Is(err, infrastructure.ErrAuthorizationCodeNotFound) {
+			t.Errorf("Consume() after Delete() error = %v, want %v", err, infrastructure.ErrAuthorizationCodeNotFound)
+		}
+	})
+
+	t.Run("ユーザーとクライアントの認可コードの削除", func(t *testing.T) {
+		store := newBackend(t).AuthCodes
+		now := time.Now()
+		store.Save(newAuthorizationCode("target", now))
+		store.Save(newAuthorizationCode("used", now))
+		store.Consume("used")
+		otherClient := domain.ReconstructAuthorizationCode("other-client", "user-1", "client-2", []string{"read"}, "https://client.example.com/callback", now.Add(domain.AUTHORIZATION_CODE_DURATION).Unix())
+		otherUser := domain.ReconstructAuthorizationCode("other-user", "user-2", "client-1", []string{"read"}, "https://client.example.com/callback", now.Add(domain.AUTHORIZATION_CODE_DURATION).Unix())
+		store.Save(otherClient)
+		store.Save(otherUser)
+
+		// 使用済みの認可コードは削除した数に含めない
+		if n, err := store.DeleteByUserAndClient("user-1", "client-1"); err != nil || n != 1 {
+			t.Fatalf("DeleteByUserAndClient() = %d, %v, want 1", n, err)
+		}
+		if _, err := store.Consume("target"); !errors.Is(err, infrastructure.ErrAuthorizationCodeNotFound) {
+			t.Errorf("Consume() of deleted code error = %v, want %v", err, infrastructure.ErrAuthorizationCodeNotFound)
+		}
+		// 使用済みの認可コードは再利用を検知できるよう残す
+		if _, err := store.Consume("used"); !errors.Is(err, infrastructure.ErrAuthorizationCodeAlreadyUsed) {
+			t.Errorf("Consume() of used code error = %v, want %v", err, infrastructure.ErrAuthorizationCodeAlreadyUsed)
+		}
+		for _, code := range []string{"other-client", "other-user"} {
+			if _, err := store.FindByCode(code); err != nil {
+				t.Errorf("FindByCode(%q) error = %v", code, err)
+			}
+		}
+	})
+
+	t.Run("有効期限切れの認可コードの一括削除", func(t *testing.T) {
+		store := newBackend(t).AuthCodes
+		now := time.Now()
+		expired := now.Add(-domain.AUTHORIZATION_CODE_DURATION - time.Minute)
+		store.Save(newAuthorizationCode("expired-1", expired))
+		store.Save(newAuthorizationCode("expired-2", expired))
+		// 使用済みの認可コードも有効期限が切れれば削除する
+		store.Save(newAuthorizationCode("expired-used", expired))
+		store.Consume("expired-used")
+		store.Save(newAuthorizationCode("live", now))
+
+		purged := purgeAll(t, func(limit int) (int, error) { return store.PurgeExpired(now, limit) }, 2)
+		if purged != 3 {
+			t.Errorf("PurgeExpired() purged %d codes, want 3", purged)
+		}
+		if _, err := store.FindByCode("live"); err != nil {
+			t.Errorf("FindByCode() of live code error = %v", err)
+		}
+		if _, err := store.Consume("expired-used"); !errors.Is(err, infrastructure.ErrAuthorizationCodeNotFound) {
+			t.Errorf("Consume() of purged code error = %v, want %v", err, infrastructure.ErrAuthorizationCodeNotFound)
+		}
+	})
+
+	t.Run("並行して消費しても取得できるのは1回のみ", func(t *testing.T) {
+		store := newBackend(t).AuthCodes
+		store.Save(newAuthorizationCode("code-1", time.Now()))
+
+		var succeeded atomic.Int32
+		parallel(concurrency, func(int) {
+			_, err := store.Consume("code-1")
+			switch {
+			case err == nil:
+				succeeded.Add(1)
+			case !errors.Is(err, infrastructure.ErrAuthorizationCodeAlreadyUsed):
+				t.Errorf("Consume() error = %v", err)
+			}
+		})
+		if n := succeeded.Load(); n != 1 {
+			t.Errorf("Consume() succeeded %d times, want 1", n)
+		}
+	})
+}
+
+func assertAuthorizationCode(t *testing.T, actual *domain.AuthorizationCode, want *domain.AuthorizationCode) {
+	t.Helper()
+	if actual.Value() != want.Value() ||
+		actual.UserID() != want.UserID() ||
+		actual.ClientID() != want.ClientID() ||
+		!slices.Equal(actual.Scopes(), want.Scopes()) ||
+		actual.RedirectURI() != want.RedirectURI() ||
+		actual.ExpiresAt() != want.ExpiresAt() {
+		t.Errorf("authorization code = %+v, want %+v", actual, want)
+	}
+}
@@ -0,0 +1,244 @@
+package storagetest
+
+import (
+	"errors"
+	"fmt"
+	"oauth-tutorial/internal/domain"
+	"oauth-tutorial/internal/infrastructure"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+)
+
+func testClients(t *testing.T, b *Backend) {
+	client := domain.ReconstructClient("client-1", "Client 1", domain.ConfidentialClient, "secret",
+		[]string{"https://client.example.com/callback", "https://client.example.com/callback2"})
+	if err := b.Clients.Save(client); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	for name, find := range map[string]func() (*domain.Client, error){
+		"SelectByClientID": func() (*domain.Client, error) { return b.Clients.SelectByClientID("client-1") },
+		"FindByID":         func() (*domain.Client, error) { return b.Clients.FindByID("client-1") },
+	} {
+		actual, err := find()
+		if err != nil {
+			t.Fatalf("%s() error = %v", name, err)
+		}
+		assertClient(t, actual, client)
+	}
+
+	// 同じclient_idで保存した場合は上書きする
+	updated := domain.ReconstructClient("client-1", "Client 1 (renamed)", domain.PublicClient, "", []string{"https://client.example.com/new"})
+	if err := b.Clients.Save(updated); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	actual, err := b.Clients.SelectByClientID("client-1")
+	if err != nil {
+		t.Fatalf("SelectByClientID() error = %v", err)
+	}
+	assertClient(t, actual, updated)
+
+	if _, err := b.Clients.SelectByClientID("unknown"); !errors.Is(err, infrastructure.ErrClientNotFound) {
+		t.Errorf("SelectByClientID() error = %v, want %v", err, infrastructure.ErrClientNotFound)
+	}
+	if _, err := b.Clients.FindByID("unknown"); !errors.Is(err, infrastructure.ErrClientNotFound) {
+		t.Errorf("FindByID() error = %v, want %v", err, infrastructure.ErrClientNotFound)
+	}
+
+	t.Run("全てのクライアントの置き換え", func(t *testing.T) {
+		kept := domain.ReconstructClient("kept", "Kept", domain.ConfidentialClient, "secret", []string{"https://kept.example.com/callback"})
+		removed := domain.ReconstructClient("removed", "Removed", domain.PublicClient, "", []string{"https://removed.example.com/callback"})
+		b.Clients.Save(kept)
+		b.Clients.Save(removed)
+
+		keptUpdated := domain.ReconstructClient("kept", "Kept (renamed)", domain.ConfidentialClient, "new-secret", []string{"https://kept.example.com/new"})
+		added := domain.ReconstructClient("added", "Added", domain.PublicClient, "", []string{"https://added.example.com/callback"})
+		// 置き換えの前後どちらにも存在するクライアントは、置き換えの最中も検索できること
+		parallel(concurrency, func(i int) {
+			if i == 0 {
+				if err := b.Clients.ReplaceAll([]*domain.Client{keptUpdated, added}); err != nil {
+					t.Errorf("ReplaceAll() error = %v", err)
+				}
+				return
+			}
+			if _, err := b.Clients.SelectByClientID("kept"); err != nil {
+				t.Errorf("SelectByClientID(kept) during ReplaceAll() error = %v", err)
+			}
+		})
+
+		actual, err := b.Clients.SelectByClientID("kept")
+		if err != nil {
+			t.Fatalf("SelectByClientID(kept) error = %v", err)
+		}
+		assertClient(t, actual, keptUpdated)
+		if _, err := b.Clients.SelectByClientID("added"); err != nil {
+			t.Errorf("SelectByClientID(added) error = %v", err)
+		}
+		for _, clientID := range []domain.ClientID{"removed", "client-1"} {
+			if _, err := b.Clients.SelectByClientID(clientID); !errors.Is(err, infrastructure.ErrClientNotFound) {
+				t.Errorf("SelectByClientID(%s) error = %v, want %v", clientID, err, infrastructure.ErrClientNotFound)
+			}
+		}
+	})
+
+	t.Run("ポリシーの保存", func(t *testing.T) {
+		policy := domain.ClientPolicy{
+			GrantTypes:              []domain.GrantType{domain.GrantTypeAuthorizationCode},
+			ResponseTypes:           []domain.ResponseType{domain.ResponseTypeCode},
+			Scopes:                  []string{"read", "write"},
+			DefaultScopes:           []string{"read"},
+			TokenEndpointAuthMethod: domain.AuthMethodClientSecretPost,
+			Lifetimes:               domain.Lifetimes{AccessToken: 15 * time.Minute, RefreshToken: 24 * time.Hour},
+			IssueRefreshToken:       false,
+			ApplicationType:         domain.ApplicationTypeNative,
+		}
+		client := domain.ReconstructClientWithPolicy("policy", "Policy", domain.ConfidentialClient, "secret", []string{"https://policy.example.com/callback", "com.example.app:/callback"}, policy)
+		if err := b.Clients.Save(client); err != nil {
+			t.Fatalf("Save() error = %v", err)
+		}
+		actual, err := b.Clients.SelectByClientID("policy")
+		if err != nil {
+			t.Fatalf("SelectByClientID() error = %v", err)
+		}
+		assertClient(t, actual, client)
+	})
+
+	t.Run("並行した保存と検索", func(t *testing.T) {
+		parallel(concurrency, func(i int) {
+			clientID := domain.ClientID(fmt.Sprintf("concurrent-%d", i))
+			if err := b.Clients.Save(domain.ReconstructClient(clientID, "concurrent", domain.PublicClient, "", []string{"https://client.example.com/callback"})); err != nil {
+				t.Errorf("Save() error = %v", err)
+				return
+			}
+			if _, err := b.Clients.SelectByClientID(clientID); err != nil {
+				t.Errorf("SelectByClientID(%s) error = %v", clientID, err)
+			}
+		})
+	})
+}
+
+func assertClient(t *testing.T, actual *domain.Client, want *domain.Client) {
+	t.Helper()
+	if actual.ClientID() != want.ClientID() ||
+		actual.ClientName() != want.ClientName() ||
+		actual.ClientType() != want.ClientType() ||
+		actual.Secret() != want.Secret() ||
+		!slices.Equal(actual.RedirectURI(), want.RedirectURI()) ||
+		!reflect.DeepEqual(actual.Policy(), want.Policy()) {
+		t.Errorf("client = %+v, want %+v", actual, want)
+	}
+}
+
+func testUsers(t *testing.T, b *Backend) {
+	user := domain.ReconstructUser("user-1", "test-user@example.com", "$argon2id$v=19$m=19456,t=2,p=1$c2FsdA$aGFzaA")
+	if err := b.Users.Save(user); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	actual, err := b.Users.FindByLoginID("test-user@example.com")
+	if err != nil {
+		t.Fatalf("FindByLoginID() error = %v", err)
+	}
+	if actual.UserID() != user.UserID() || actual.LoginID() != user.LoginID() || actual.PasswordHash() != user.PasswordHash() {
+		t.Errorf("FindByLoginID() = %+v, want %+v", actual, user)
+	}
+	if _, err := b.Users.FindByLoginID("unknown@example.com"); !errors.Is(err, infrastructure.ErrUserNotFound) {
+		t.Errorf("FindByLoginID() error = %v, want %v", err, infrastructure.ErrUserNotFound)
+	}
+
+	t.Run("パスワードのハッシュ値の置き換え", func(t *testing.T) {
+		rehashed := user.WithPasswordHash("$argon2id$v=19$m=65536,t=3,p=1$c2FsdA$aGFzaA")
+		if err := b.Users.Save(rehashed); err != nil {
+			t.Fatalf("Save() error = %v", err)
+		}
+		actual, err := b.Users.FindByLoginID("test-user@example.com")
+		if err != nil || actual.PasswordHash() != rehashed.PasswordHash() {
+			t.Errorf("FindByLoginID() = %+v, %v, want %+v", actual, err, rehashed)
+		}
+	})
+
+	t.Run("設定ファイルから登録したユーザー", func(t *testing.T) {
+		// 設定ファイルから登録していないユーザーは空文字列
+		if seeded, err := b.Users.FindSeededPasswordHash("test-user@example.com"); err != nil || seeded != "" {
+			t.Errorf("FindSeededPasswordHash() = %q, %v, want empty", seeded, err)
+		}
+		if _, err := b.Users.FindSeededPasswordHash("unknown@example.com"); !errors.Is(err, infrastructure.ErrUserNotFound) {
+			t.Errorf("FindSeededPasswordHash() error = %v, want %v", err, infrastructure.ErrUserNotFound)
+		}
+
+		seed := domain.ReconstructUser("user-5", "seed@example.com", "$argon2id$v=19$m=19456,t=2,p=1$c2VlZA$aGFzaA")
+		if err := b.Users.SaveSeedUser(seed); err != nil {
+			t.Fatalf("SaveSeedUser() error = %v", err)
+		}
+		// ログイン時の再ハッシュで保存しても、登録に使ったハッシュ値は変わらない
+		if err := b.Users.Save(seed.WithPasswordHash("$argon2id$v=19$m=65536,t=3,p=1$c2VlZA$aGFzaA")); err != nil {
+			t.Fatalf("Save() error = %v", err)
+		}
+		seeded, err := b.Users.FindSeededPasswordHash("seed@example.com")
+		if err != nil || seeded != seed.PasswordHash() {
+			t.Errorf("FindSeededPasswordHash() = %q, %v, want %q", seeded, err, seed.PasswordHash())
+		}
+		if actual, err := b.Users.FindByLoginID("seed@example.com"); err != nil || actual.PasswordHash() == seed.PasswordHash() {
+			t.Errorf("FindByLoginID() = %+v, %v, want the rehashed hash", actual, err)
+		}
+	})
+
+	t.Run("平文のパスワードの置き換え", func(t *testing.T) {
+		plaintext := domain.ReconstructUser("user-3", "plaintext@example.com", "password")
+		federatedIdentity, _ := domain.NewFederatedIdentity("https://idp.example.com", "subject-3")
+		federated := domain.NewFederatedUser("user-4", "corp:bob@example.com", federatedIdentity)
+		for _, u := range []*domain.User{plaintext, federated} {
+			if err := b.Users.Save(u); err != nil {
+				t.Fatalf("Save() error = %v", err)
+			}
+		}
+
+		hashed, err := b.Users.HashPlaintextPasswords(func(password string) string { return "$hashed$" + password })
+		if err != nil {
+			t.Fatalf("HashPlaintextPasswords() error = %v", err)
+		}
+		// ハッシュ値のユーザーとパスワードを持たないユーザーは置き換えない
+		if len(hashed) != 1 || hashed[0] != "plaintext@example.com" {
+			t.Errorf("HashPlaintextPasswords() = %v, want [plaintext@example.com]", hashed)
+		}
+		if actual, err := b.Users.FindByLoginID("plaintext@example.com"); err != nil || actual.PasswordHash() != "$hashed$password" {
+			t.Errorf("FindByLoginID() = %+v, %v", actual, err)
+		}
+		if actual, err := b.Users.FindByLoginID("corp:bob@example.com"); err != nil || actual.PasswordHash() != "" || actual.FederatedIdentity() == nil {
+			t.Errorf("FindByLoginID() = %+v, %v", actual, err)
+		}
+		// 置き換えた後は対象がない
+		if hashed, err := b.Users.HashPlaintextPasswords(func(password string) string { return "$hashed$" + password }); err != nil || len(hashed) != 0 {
+			t.Errorf("HashPlaintextPasswords() = %v, %v, want none", hashed, err)
+		}
+	})
+
+	t.Run("上流のIdPのユーザー", func(t *testing.T) {
+		identity, _ := domain.NewFederatedIdentity("https://idp.example.com", "subject-1")
+		federated := domain.NewFederatedUser("user-2", "corp:alice@example.com", identity)
+		if err := b.Users.Save(federated); err != nil {
+			t.Fatalf("Save() error = %v", err)
+		}
+
+		actual, err := b.Users.FindByFederatedIdentity(identity)
+		if err != nil {
+			t.Fatalf("FindByFederatedIdentity() error = %v", err)
+		}
+		if actual.UserID() != federated.UserID() || actual.LoginID() != federated.LoginID() || actual.FederatedIdentity() == nil || *actual.FederatedIdentity() != identity {
+			t.Errorf("FindByFederatedIdentity() = %+v, want %+v", actual, federated)
+		}
+
+		// subが同じでもissが異なる場合は別のユーザー
+		other, _ := domain.NewFederatedIdentity("https://other.example.com", "subject-1")
+		if _, err := b.Users.FindByFederatedIdentity(other); !errors.Is(err, infrastructure.ErrUserNotFound) {
+			t.Errorf("FindByFederatedIdentity() error = %v, want %v", err, infrastructure.ErrUserNotFound)
+		}
+		// ログインIDでも検索でき、パスワードを持たない
+		byLoginID, err := b.Users.FindByLoginID("corp:alice@example.com")
+		if err != nil || byLoginID.PasswordHash() != "" || byLoginID.FederatedIdentity() == nil {
+			t.Errorf("FindByLoginID() = %+v, %v", byLoginID, err)
+		}
+	})
+}
@@ -0,0 +1,138 @@
+package storagetest
+
+import (
+	"errors"
+	"fmt"
+	"oauth-tutorial/internal/domain"
+	"oauth-tutorial/internal/infrastructure"
+	"slices"
+	"testing"
+	"time"
+)
+
+func testConsents(t *testing.T, newBackend Factory) {
+	t.Run("保存と検索と削除", func(t *testing.T) {
+		store := newBackend(t).Consents
+		now := time.Now()
+		consent := domain.NewConsent("user-1", "client-1", []string{"read"}, now, time.Hour).RecordUse(now.Add(time.Minute))
+		if err := store.Save(consent); err != nil {
+			t.Fatalf("Save() error = %v", err)
+		}
+
+		actual, err := store.FindByUserAndClient("user-1", "client-1")
+		if err != nil {
+			t.Fatalf("FindByUserAndClient() error = %v", err)
+		}
+		assertConsent(t, actual, consent)
+		// 有効期限は保存した値のまま判定できること
+		if actual.IsExpired(now) || !actual.IsExpired(now.Add(2*time.Hour)) {
+			t.Errorf("IsExpired() does not match the saved expiresAt %v", consent.ExpiresAt())
+		}
+
+		// スコープを追加した同意で上書きする
+		granted := actual.Grant([]string{"write"}, now.Add(2*time.Minute), time.Hour)
+		if err := store.Save(granted); err != nil {
+			t.Fatalf("Save() error = %v", err)
+		}
+		actual, err = store.FindByUserAndClient("user-1", "client-1")
+		if err != nil {
+			t.Fatalf("FindByUserAndClient() error = %v", err)
+		}
+		assertConsent(t, actual, granted)
+
+		if err := store.Delete("user-1", "client-1"); err != nil {
+			t.Fatalf("Delete() error = %v", err)
+		}
+		if _, err := store.FindByUserAndClient("user-1", "client-1"); !errors.Is(err, infrastructure.ErrConsentNotFound) {
+			t.Errorf("FindByUserAndClient() error = %v, want %v", err, infrastructure.ErrConsentNotFound)
+		}
+	})
+
+	t.Run("有効期限なし・未使用の同意", func(t *testing.T) {
+		store := newBackend(t).Consents
+		consent := domain.NewConsent("user-1", "client-1", []string{"read"}, time.Now(), 0)
+		store.Save(consent)
+
+		actual, err := store.FindByUserAndClient("user-1", "client-1")
+		if err != nil {
+			t.Fatalf("FindByUserAndClient() error = %v", err)
+		}
+		if !actual.ExpiresAt().IsZero() || !actual.FirstUsedAt().IsZero() || !actual.LastUsedAt().IsZero() {
+			t.Errorf("unset times should stay zero: %+v", actual)
+		}
+	})
+
+	t.Run("ユーザーの同意を同意した日時の順に返す", func(t *testing.T) {
+		store := newBackend(t).Consents
+		now := time.Now()
+		store.Save(domain.NewConsent("user-1", "client-2", []string{"read"}, now, 0))
+		store.Save(domain.NewConsent("user-1", "client-1", []string{"read"}, now.Add(-time.Hour), 0))
+		store.Save(domain.NewConsent("user-2", "client-3", []string{"read"}, now, 0))
+
+		consents, err := store.FindByUserID("user-1")
+		if err != nil {
+			t.Fatalf("FindByUserID() error = %v", err)
+		}
+		clientIDs := make([]string, 0, len(consents))
+		for _, consent := range consents {
+			clientIDs = append(clientIDs, consent.ClientID())
+		}
+		if !slices.Equal(clientIDs, []string{"client-1", "client-2"}) {
+			t.Errorf("FindByUserID() client IDs = %v, want [client-1 client-2]", clientIDs)
+		}
+		if consents, _ := store.FindByUserID("unknown"); len(consents) != 0 {
+			t.Errorf("FindByUserID() of unknown user = %v, want none", consents)
+		}
+	})
+
+	t.Run("有効期限切れの同意の一括削除", func(t *testing.T) {
+		store := newBackend(t).Consents
+		now := time.Now()
+		store.Save(domain.NewConsent("user-1", "client-1", []string{"read"}, now.Add(-2*time.Hour), time.Hour))
+		store.Save(domain.NewConsent("user-1", "client-2", []string{"read"}, now.Add(-3*time.Hour), time.Hour))
+		// 有効期限なしの同意は削除しない
+		store.Save(domain.NewConsent("user-1", "client-3", []string{"read"}, now.Add(-2*time.Hour), 0))
+		store.Save(domain.NewConsent("user-1", "client-4", []string{"read"}, now, time.Hour))
+
+		purged := purgeAll(t, func(limit int) (int, error) { return store.PurgeExpired(now, limit) }, 1)
+		if purged != 2 {
+			t.Errorf("PurgeExpired() purged %d consents, want 2", purged)
+		}
+		consents, _ := store.FindByUserID("user-1")
+		if len(consents) != 2 {
+			t.Errorf("FindByUserID() returned %d consents, want 2", len(consents))
+		}
+	})
+
+	t.Run("並行した保存と検索", func(t *testing.T) {
+		store := newBackend(t).Consents
+		now := time.Now()
+		parallel(concurrency, func(i int) {
+			clientID := fmt.Sprintf("client-%d", i)
+			if err := store.Save(domain.NewConsent("user-1", clientID, []string{"read"}, now, 0)); err != nil {
+				t.Errorf("Save() error = %v", err)
+				return
+			}
+			if _, err := store.FindByUserAndClient("user-1", clientID); err != nil {
+				t.Errorf("FindByUserAndClient() error = %v", err)
+			}
+		})
+		if consents, _ := store.FindByUserID("user-1"); len(consents) != concurrency {
+			t.Errorf("FindByUserID() returned %d consents, want %d", len(consents), concurrency)
+		}
+	})
+}
+
+func assertConsent(t *testing.T, actual *domain.Consent, want *domain.Consent) {
+	t.Helper()
+	if actual.UserID() != want.UserID() ||
+		actual.ClientID() != want.ClientID() ||
+		!slices.Equal(actual.Scopes(), want.Scopes()) ||
+		!actual.GrantedAt().Equal(want.GrantedAt()) ||
+		!actual.UpdatedAt().Equal(want.UpdatedAt()) ||
+		!actual.ExpiresAt().Equal(want.ExpiresAt()) ||
+		!actual.FirstUsedAt().Equal(want.FirstUsedAt()) ||
+		!actual.LastUsedAt().Equal(want.LastUsedAt()) {
+		t.Errorf("consent = %+v, want %+v", actual, want)
+	}
+}
@@ -0,0 +1,85 @@
+package storagetest
+
+import (
+	"errors"
+	"oauth-tutorial/internal/domain"
+	"oauth-tutorial/internal/infrastructure"
+	"slices"
+	"testing"
+	"time"
+)
+
+func testPasskeys(t *testing.T, newBackend Factory) {
+	t.Run("保存と検索と上書き", func(t *testing.T) {
+		store := newBackend(t).Passkeys
+		if _, err := store.FindByCredentialID([]byte{0x01, 0x02}); !errors.Is(err, infrastructure.ErrPasskeyCredentialNotFound) {
+			t.Fatalf("FindByCredentialID() error = %v, want %v", err, infrastructure.ErrPasskeyCredentialNotFound)
+		}
+
+		// クレデンシャルIDは任意のバイト列
+		created := domain.NewPasskeyCredential([]byte{0x00, 0xff, 0x10}, "user-1", "alice@example.com", []byte{0xa5, 0x01, 0x02}, 0, true, "ノートPC", time.Unix(1_700_000_000, 0))
+		if err := store.Save(created); err != nil {
+			t.Fatalf("Save() error = %v", err)
+		}
+		actual, err := store.FindByCredentialID([]byte{0x00, 0xff, 0x10})
+		if err != nil {
+			t.Fatalf("FindByCredentialID() error = %v", err)
+		}
+		assertPasskeyCredential(t, actual, created)
+
+		used, _ := created.Use(7, time.Unix(1_700_000_100, 0))
+		if err := store.Save(used); err != nil {
+			t.Fatalf("Save() error = %v", err)
+		}
+		actual, err = store.FindByCredentialID([]byte{0x00, 0xff, 0x10})
+		if err != nil {
+			t.Fatalf("FindByCredentialID() error = %v", err)
+		}
+		assertPasskeyCredential(t, actual, used)
+	})
+
+	t.Run("ユーザーのパスキーを登録日時の順に返す", func(t *testing.T) {
+		store := newBackend(t).Passkeys
+		now := time.Unix(1_700_000_000, 0)
+		second := domain.NewPasskeyCredential([]byte("credential-2"), "user-1", "alice@example.com", []byte("key-2"), 0, false, "", now.Add(time.Minute))
+		first := domain.NewPasskeyCredential([]byte("credential-1"), "user-1", "alice@example.com", []byte("key-1"), 0, false, "", now)
+		other := domain.NewPasskeyCredential([]byte("credential-3"), "user-2", "bob@example.com", []byte("key-3"), 0, false, "", now)
+		for _, c := range []*domain.PasskeyCredential{second, first, other} {
+			if err := store.Save(c); err != nil {
+				t.Fatalf("Save() error = %v", err)
+			}
+		}
+
+		actual, err := store.FindByUserID("user-1")
+		if err != nil {
+			t.Fatalf("FindByUserID() error = %v", err)
+		}
+		if len(actual) != 2 {
+			t.Fatalf("FindByUserID() returned %d credentials, want 2", len(actual))
+		}
+		assertPasskeyCredential(t, actual[0], first)
+		assertPasskeyCredential(t, actual[1], second)
+
+		none, err := store.FindByUserID("unknown")
+		if err != nil || none == nil || len(none) != 0 {
+			t.Errorf("FindByUserID() = %v, %v, want an empty slice", none, err)
+		}
+	})
+}
+
+func assertPasskeyCredential(t *testing.T, actual *domain.PasskeyCredential, expected *domain.PasskeyCredential) {
+	t.Helper()
+	if !slices.Equal(actual.CredentialID(), expected.CredentialID()) ||
+		actual.UserID() != expected.UserID() ||
+		actual.LoginID() != expected.LoginID() ||
+		!slices.Equal(actual.PublicKey(), expected.PublicKey()) ||
+		actual.SignCount() != expected.SignCount() ||
+		actual.BackupEligible() != expected.BackupEligible() ||
+		actual.Name() != expected.Name() ||
+		!actual.CreatedAt().Equal(expected.CreatedAt()) ||
+		!actual.LastUsedAt().Equal(expected.LastUsedAt()) {
+		t.Errorf("credential = {%x %s %s %x %d %v %q %v %v}, want {%x %s %s %x %d %v %q %v %v}",
+			actual.CredentialID(), actual.UserID(), actual.LoginID(), actual.PublicKey(), actual.SignCount(), actual.BackupEligible(), actual.Name(), actual.CreatedAt(), actual.LastUsedAt(),
+			expected.CredentialID(), expected.UserID(), expected.LoginID(), expected.PublicKey(), expected.SignCount(), expected.BackupEligible(), expected.Name(), expected.CreatedAt(), expected.LastUsedAt())
+	}
+}
@@ -0,0 +1,167 @@
+package storagetest
+
+import (
+	"errors"
+	"fmt"
+	"oauth-tutorial/internal/domain"
+	"oauth-tutorial/internal/infrastructure"
+	"oauth-tutorial/internal/infrastructure/dto"
+	"oauth-tutorial/internal/session"
+	"slices"
+	"testing"
+	"time"
+)
+
+const (
+	sessionIdleTimeout     = time.Hour
+	sessionAbsoluteTimeout = 24 * time.Hour
+)
+
+func testSessions(t *testing.T, newBackend Factory) {
+	// ログイン済みのセッションのユーザーを参照できるよう、ユーザーを保存した上でセッションストアを構築する
+	setup := func(t *testing.T) (infrastructure.SessionStore, *clock, *domain.User) {
+		b := newBackend(t)
+		user := domain.ReconstructUser("user-1", "test-user@example.com", "password")
+		if err := b.Users.Save(user); err != nil {
+			t.Fatalf("Users.Save() error = %v", err)
+		}
+		c := newClock()
+		return b.NewSessionStore(sessionIdleTimeout, sessionAbsoluteTimeout, c.Now), c, user
+	}
+
+	t.Run("保存と取得と削除", func(t *testing.T) {
+		store, c, user := setup(t)
+		if err := store.Save("session-1", dto.NewSessionData(nil, time.Time{}, nil)); err != nil {
+			t.Fatalf("Save() error = %v", err)
+		}
+		actual, err := store.Get("session-1")
+		if err != nil {
+			t.Fatalf("Get() error = %v", err)
+		}
+		if actual.IsAuthenticated() {
+			t.Error("session saved without user should not be authenticated")
+		}
+
+		// ログインしたセッションで上書きする
+		authTime := c.Now()
+		store.Save("session-1", dto.NewSessionData(user, authTime, []string{"pwd"}))
+		actual, err = store.Get("session-1")
+		if err != nil {
+			t.Fatalf("Get() error = %v", err)
+		}
+		if !actual.IsAuthenticated() || actual.User().UserID() != user.UserID() ||
+			!actual.AuthTime().Equal(authTime) || !slices.Equal(actual.AMR(), []string{"pwd"}) {
+			t.Errorf("Get() = %+v, want authenticated session of %s", actual, user.UserID())
+		}
+
+		if err := store.Delete("session-1"); err != nil {
+			t.Fatalf("Delete() error = %v", err)
+		}
+		if _, err := store.Get("session-1"); !errors.Is(err, infrastructure.ErrSessionNotFound) {
+			t.Errorf("Get() error = %v, want %v", err, infrastructure.ErrSessionNotFound)
+		}
+		stats, err := store.Stats()
+		if err != nil {
+			t.Fatalf("Stats() error = %v", err)
+		}
+		if stats.Live != 0 || stats.Created != 1 || stats.Deleted != 1 {
+			t.Errorf("Stats() = %+v, want Live=0 Created=1 Deleted=1", stats)
+		}
+	})
+
+	t.Run("不正な引数", func(t *testing.T) {
+		store, _, _ := setup(t)
+		if err := store.Save("", dto.NewSessionData(nil, time.Time{}, nil)); !errors.Is(err, infrastructure.ErrInvalidSessionID) {
+			t.Errorf("Save() with empty ID error = %v, want %v", err, infrastructure.ErrInvalidSessionID)
+		}
+		if err := store.Save("session-1", nil); !errors.Is(err, infrastructure.ErrInvalidSessionData) {
+			t.Errorf("Save() with nil data error = %v, want %v", err, infrastructure.ErrInvalidSessionData)
+		}
+		if err := store.Delete(""); !errors.Is(err, infrastructure.ErrInvalidSessionID) {
+			t.Errorf("Delete() with empty ID error = %v, want %v", err, infrastructure.ErrInvalidSessionID)
+		}
+	})
+
+	t.Run("最終アクセスから有効期間を過ぎたセッションは無効", func(t *testing.T) {
+		store, c, _ := setup(t)
+		store.Save("session-1", dto.NewSessionData(nil, time.Time{}, nil))
+
+		// アクセスする度に有効期間が延びる
+		c.Advance(sessionIdleTimeout - time.Minute)
+		if _, err := store.Get("session-1"); err != nil {
+			t.Fatalf("Get() within idle timeout error = %v", err)
+		}
+		c.Advance(sessionIdleTimeout - time.Minute)
+		if _, err := store.Get("session-1"); err != nil {
+			t.Fatalf("Get() within idle timeout after access error = %v", err)
+		}
+
+		c.Advance(sessionIdleTimeout)
+		if _, err := store.Get("session-1"); !errors.Is(err, infrastructure.ErrSessionNotFound) {
+			t.Errorf("Get() after idle timeout error = %v, want %v", err, infrastructure.ErrSessionNotFound)
+		}
+		stats, _ := store.Stats()
+		if stats.IdleEvictions != 1 || stats.Live != 0 {
+			t.Errorf("Stats() = %+v, want IdleEvictions=1 Live=0", stats)
+		}
+	})
+
+	t.Run("作成から有効期間を過ぎたセッションは上書きしても無効", func(t *testing.T) {
+		store, c, user := setup(t)
+		store.Save("session-1", dto.NewSessionData(nil, time.Time{}, nil))
+
+		for elapsed := time.Duration(0); elapsed < sessionAbsoluteTimeout-sessionIdleTimeout; elapsed += sessionIdleTimeout / 2 {
+			c.Advance(sessionIdleTimeout / 2)
+			// 上書きしても作成日時は引き継ぐ
+			if err := store.Save("session-1", dto.NewSessionData(user, c.Now(), []string{"pwd"})); err != nil {
+				t.Fatalf("Save() error = %v", err)
+			}
+		}
+		c.Advance(sessionIdleTimeout)
+		if _, err := store.Get("session-1"); !errors.Is(err, infrastructure.ErrSessionNotFound) {
+			t.Errorf("Get() after absolute timeout error = %v, want %v", err, infrastructure.ErrSessionNotFound)
+		}
+		stats, _ := store.Stats()
+		if stats.AbsoluteEvictions != 1 || stats.Created != 1 {
+			t.Errorf("Stats() = %+v, want AbsoluteEvictions=1 Created=1", stats)
+		}
+	})
+
+	t.Run("有効期間切れのセッションの一括破棄", func(t *testing.T) {
+		store, c, _ := setup(t)
+		store.Save("expired-1", dto.NewSessionData(nil, time.Time{}, nil))
+		store.Save("expired-2", dto.NewSessionData(nil, time.Time{}, nil))
+		c.Advance(sessionIdleTimeout)
+		store.Save("live", dto.NewSessionData(nil, time.Time{}, nil))
+
+		evicted := purgeAll(t, store.Sweep, 1)
+		if evicted != 2 {
+			t.Errorf("Sweep() = %d, want 2", evicted)
+		}
+		if _, err := store.Get("live"); err != nil {
+			t.Errorf("Get() of live session error = %v", err)
+		}
+		stats, _ := store.Stats()
+		if stats.Live != 1 || stats.IdleEvictions != 2 {
+			t.Errorf("Stats() = %+v, want Live=1 IdleEvictions=2", stats)
+		}
+	})
+
+	t.Run("並行した保存と取得", func(t *testing.T) {
+		store, _, user := setup(t)
+		parallel(concurrency, func(i int) {
+			sessionID := session.SessionID(fmt.Sprintf("session-%d", i))
+			if err := store.Save(sessionID, dto.NewSessionData(user, time.Now(), []string{"pwd"})); err != nil {
+				t.Errorf("Save() error = %v", err)
+				return
+			}
+			if _, err := store.Get(sessionID); err != nil {
+				t.Errorf("Get() error = %v", err)
+			}
+		})
+		stats, _ := store.Stats()
+		if stats.Live != concurrency || stats.Created != concurrency {
+			t.Errorf("Stats() = %+v, want Live=%d Created=%d", stats, concurrency, concurrency)
+		}
+	})
+}
@@ -0,0 +1,110 @@
+// 永続化の実装がinfrastructureパッケージのインターフェースの仕様を満たしているかを検証するテストスイート
+//
+// 実装毎のテストから、実装を構築するFactoryを渡してRunを呼び出す。
+//
+//	func TestConformance(t *testing.T) {
+//		storagetest.Run(t, func(t *testing.T) *storagetest.Backend {
+//			return &storagetest.Backend{...}
+//		})
+//	}
+package storagetest
+
+import (
+	"oauth-tutorial/internal/infrastructure"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 検証対象の永続化の実装
+type Backend struct {
+	Clients   infrastructure.ClientStore
+	Users     infrastructure.UserStore
+	AuthCodes infrastructure.AuthCodeStore
+	Tokens    infrastructure.TokenStore
+	Consents  infrastructure.ConsentStore
+	TOTP      infrastructure.TOTPStore
+	Passkeys  infrastructure.PasskeyStore
+	// 指定した有効期間と、現在時刻を返す関数nowを使うセッションストアを構築する
+	// 同じBackendのUsersに保存したユーザーを参照できること
+	NewSessionStore func(idleTimeout time.Duration, absoluteTimeout time.Duration, now func() time.Time) infrastructure.SessionStore
+}
+
+// テスト毎に空の実装を構築する
+// 後片付けが必要な場合はt.Cleanupで登録すること
+type Factory func(t *testing.T) *Backend
+
+// 全ての検証を実行する
+func Run(t *testing.T, newBackend Factory) {
+	t.Run("Clients", func(t *testing.T) { testClients(t, newBackend(t)) })
+	t.Run("Users", func(t *testing.T) { testUsers(t, newBackend(t)) })
+	t.Run("AuthCodes", func(t *testing.T) { testAuthCodes(t, newBackend) })
+	t.Run("Tokens", func(t *testing.T) { testTokens(t, newBackend) })
+	t.Run("Consents", func(t *testing.T) { testConsents(t, newBackend) })
+	t.Run("TOTP", func(t *testing.T) { testTOTP(t, newBackend) })
+	t.Run("Passkeys", func(t *testing.T) { testPasskeys(t, newBackend) })
+	t.Run("Sessions", func(t *testing.T) { testSessions(t, newBackend) })
+}
+
+// 並行して実行する数
+const concurrency = 20
+
+// n個のゴルーチンで同時にfnを実行し、全ての完了を待つ
+func parallel(n int, fn func(i int)) {
+	var (
+		wg    sync.WaitGroup
+		start = make(chan struct{})
+	)
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			fn(i)
+		}()
+	}
+	close(start)
+	wg.Wait()
+}
+
+// 削除した数がlimit未満になるまでpurgeを繰り返し、削除した総数を返す
+// 1回の呼び出しでlimitを超えて削除しないことも検証する
+func purgeAll(t *testing.T, purge func(limit int) (int, error), limit int) int {
+	t.Helper()
+	total := 0
+	for {
+		n, err := purge(limit)
+		if err != nil {
+			t.Fatalf("purge error = %v", err)
+		}
+		if n > limit {
+			t.Fatalf("purged %d entries in a batch, want at most %d", n, limit)
+		}
+		total += n
+		if n < limit {
+			return total
+		}
+	}
+}
+
+// テストから進められる時計
+type clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newClock() *clock {
+	return &clock{now: time.Unix(1_700_000_000, 0)}
+}
+
+func (c *clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
@@ -0,0 +1,265 @@
+package storagetest
+
+import (
+	"errors"
+	"fmt"
+	"oauth-tutorial/internal/domain"
+	"oauth-tutorial/internal/infrastructure"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func testTokens(t *testing.T, newBackend Factory) {
+	t.Run("アクセストークンの保存と検索", func(t *testing.T) {
+		store := newBackend(t).Tokens
+		token := domain.NewAccessToken("client-1", "user-1", []string{"read"}, time.Now(), domain.AccessTokenDuration).IssuedFrom("grant-1")
+		if err := store.Save(token); err != nil {
+			t.Fatalf("Save() error = %v", err)
+		}
+
+		actual, err := store.FindByAccessToken(token.Value())
+		if err != nil {
+			t.Fatalf("FindByAccessToken() error = %v", err)
+		}
+		if actual.Value() != token.Value() || actual.ClientID() != token.ClientID() || actual.UserID() != token.UserID() ||
+			!slices.Equal(actual.Scopes(), token.Scopes()) || actual.ExpiresAt() != token.ExpiresAt() || actual.GrantID() != token.GrantID() {
+			t.Errorf("FindByAccessToken() = %+v, want %+v", actual, token)
+		}
+		if _, err := store.FindByAccessToken("unknown"); !errors.Is(err, infrastructure.ErrAccessTokenNotFound) {
+			t.Errorf("FindByAccessToken() error = %v, want %v", err, infrastructure.ErrAccessTokenNotFound)
+		}
+	})
+
+	t.Run("リフレッシュトークンの保存と検索と削除", func(t *testing.T) {
+		store := newBackend(t).Tokens
+		now := time.Now()
+		accessToken := domain.NewAccessToken("client-1", "user-1", []string{"read"}, now, domain.AccessTokenDuration).IssuedFrom("grant-1")
+		refreshToken := domain.NewRefreshToken("client-1", "user-1", []string{"read"}, now, domain.RefreshTokenDuration).IssuedFrom("grant-1")
+		store.Save(accessToken)
+		if err := store.SaveRefreshToken(refreshToken, accessToken); err != nil {
+			t.Fatalf("SaveRefreshToken() error = %v", err)
+		}
+
+		actual, err := store.FindByRefreshToken(refreshToken.Value())
+		if err != nil {
+			t.Fatalf("FindByRefreshToken() error = %v", err)
+		}
+		assertRefreshToken(t, actual, refreshToken)
+		// リフレッシュトークンの削除は同時に発行したアクセストークンに影響しない
+		if err := store.DeleteRefreshToken(refreshToken.Value()); err != nil {
+			t.Fatalf("DeleteRefreshToken() error = %v", err)
+		}
+		if _, err := store.FindByRefreshToken(refreshToken.Value()); !errors.Is(err, infrastructure.ErrRefreshTokenNotFound) {
+			t.Errorf("FindByRefreshToken() error = %v, want %v", err, infrastructure.ErrRefreshTokenNotFound)
+		}
+		if _, err := store.FindByAccessToken(accessToken.Value()); err != nil {
+			t.Errorf("FindByAccessToken() error = %v", err)
+		}
+	})
+
+	t.Run("リフレッシュトークンを消費できるのは1回のみ", func(t *testing.T) {
+		store := newBackend(t).Tokens
+		_, refreshToken := saveTokens(t, store, "client-1", "user-1", time.Now())
+
+		actual, err := store.ConsumeRefreshToken(refreshToken.Value())
+		if err != nil {
+			t.Fatalf("ConsumeRefreshToken() error = %v", err)
+		}
+		assertRefreshToken(t, actual, refreshToken)
+		if _, err := store.ConsumeRefreshToken(refreshToken.Value()); !errors.Is(err, infrastructure.ErrRefreshTokenNotFound) {
+			t.Errorf("second ConsumeRefreshToken() error = %v, want %v", err, infrastructure.ErrRefreshTokenNotFound)
+		}
+	})
+
+	t.Run("リフレッシュトークンを並行して消費しても取得できるのは1回のみ", func(t *testing.T) {
+		store := newBackend(t).Tokens
+		_, refreshToken := saveTokens(t, store, "client-1", "user-1", time.Now())
+
+		var succeeded atomic.Int32
+		parallel(concurrency, func(int) {
+			_, err := store.ConsumeRefreshToken(refreshToken.Value())
+			switch {
+			case err == nil:
+				succeeded.Add(1)
+			case !errors.Is(err, infrastructure.ErrRefreshTokenNotFound):
+				t.Errorf("ConsumeRefreshToken() error = %v", err)
+			}
+		})
+		if n := succeeded.Load(); n != 1 {
+			t.Errorf("ConsumeRefreshToken() succeeded %d times, want 1", n)
+		}
+	})
+
+	t.Run("ユーザーとクライアント単位での検索と無効化", func(t *testing.T) {
+		store := newBackend(t).Tokens
+		now := time.Now()
+		_, older := saveTokens(t, store, "client-1", "user-1", now.Add(-time.Hour))
+		target, newer := saveTokens(t, store, "client-1", "user-1", now)
+		saveTokens(t, store, "client-1", "user-1", now.Add(-2*domain.RefreshTokenDuration))
+		otherClient, otherClientRefresh := saveTokens(t, store, "client-2", "user-1", now)
+		otherUser, _ := saveTokens(t, store, "client-1", "user-2", now)
+
+		// 有効期限切れのリフレッシュトークンは含まず、発行日時の順に返す
+		// 値を返さない実装もあるため、値は比較しない
+		tokens, err := store.FindRefreshTokensByUserAndClient("user-1", "client-1", now)
+		if err != nil {
+			t.Fatalf("FindRefreshTokensByUserAndClient() error = %v", err)
+		}
+		if len(tokens) != 2 || tokens[0].IssuedAt() != older.IssuedAt() || tokens[1].IssuedAt() != newer.IssuedAt() {
+			t.Fatalf("FindRefreshTokensByUserAndClient() = %v, want tokens issued at [%d %d]", tokens, older.IssuedAt(), newer.IssuedAt())
+		}
+		// ユーザー単位では他のクライアントのリフレッシュトークンも含む
+		tokens, err = store.FindRefreshTokensByUser("user-1", now)
+		if err != nil {
+			t.Fatalf("FindRefreshTokensByUser() error = %v", err)
+		}
+		if len(tokens) != 3 || tokens[0].IssuedAt() != older.IssuedAt() ||
+			!slices.ContainsFunc(tokens, func(rt *domain.RefreshToken) bool { return rt.ClientID() == otherClientRefresh.ClientID() }) {
+			t.Fatalf("FindRefreshTokensByUser() = %v, want 3 tokens including client-2, oldest first", tokens)
+		}
+
+		// 有効期限切れのものを含め、3組のアクセストークンとリフレッシュトークンを無効にする
+		if n, err := store.RevokeByUserAndClient("user-1", "client-1"); err != nil || n != 6 {
+			t.Fatalf("RevokeByUserAndClient() = %d, %v, want 6", n, err)
+		}
+		if _, err := store.FindByAccessToken(target.Value()); !errors.Is(err, infrastructure.ErrAccessTokenNotFound) {
+			t.Errorf("FindByAccessToken() of the revoked client error = %v, want %v", err, infrastructure.ErrAccessTokenNotFound)
+		}
+		if _, err := store.FindByRefreshToken(newer.Value()); !errors.Is(err, infrastructure.ErrRefreshTokenNotFound) {
+			t.Errorf("FindByRefreshToken() of the revoked client error = %v, want %v", err, infrastructure.ErrRefreshTokenNotFound)
+		}
+		if tokens, _ := store.FindRefreshTokensByUserAndClient("user-1", "client-1", now); len(tokens) != 0 {
+			t.Errorf("FindRefreshTokensByUserAndClient() after revoke = %v, want none", tokens)
+		}
+		// 他のクライアント、他のユーザーのトークンは残ること
+		if _, err := store.FindByAccessToken(otherClient.Value()); err != nil {
+			t.Errorf("access token of another client should remain: %v", err)
+		}
+		if _, err := store.FindByRefreshToken(otherClientRefresh.Value()); err != nil {
+			t.Errorf("refresh token of another client should remain: %v", err)
+		}
+		if _, err := store.FindByAccessToken(otherUser.Value()); err != nil {
+			t.Errorf("access token of another user should remain: %v", err)
+		}
+	})
+
+	t.Run("認可単位での無効化", func(t *testing.T) {
+		store := newBackend(t).Tokens
+		now := time.Now()
+		// 認可コードから発行したトークンと、それをローテーションしたトークン
+		issued := domain.NewAccessToken("client-1", "user-1", []string{"read"}, now, domain.AccessTokenDuration).IssuedFrom("grant-1")
+		issuedRefresh := domain.NewRefreshToken("client-1", "user-1", []string{"read"}, now, domain.RefreshTokenDuration).IssuedFrom("grant-1")
+		rotated := domain.NewAccessToken("client-1", "user-1", []string{"read"}, now, domain.AccessTokenDuration).IssuedFrom("grant-1")
+		rotatedRefresh := domain.NewRefreshToken("client-1", "user-1", []string{"read"}, now, domain.RefreshTokenDuration).IssuedFrom("grant-1")
+		store.Save(issued)
+		store.SaveRefreshToken(issuedRefresh, issued)
+		store.Save(rotated)
+		store.SaveRefreshToken(rotatedRefresh, rotated)
+		// 同じユーザーとクライアントでも、別の認可や認可の識別子が無いトークンは無効にしない
+		other := domain.NewAccessToken("client-1", "user-1", []string{"read"}, now, domain.AccessTokenDuration).IssuedFrom("grant-2")
+		store.Save(other)
+		withoutGrant, withoutGrantRefresh := saveTokens(t, store, "client-1", "user-1", now)
+
+		revoked, err := store.RevokeByGrantID("grant-1")
+		if err != nil {
+			t.Fatalf("RevokeByGrantID() error = %v", err)
+		}
+		if revoked != 4 {
+			t.Errorf("RevokeByGrantID() = %d, want 4", revoked)
+		}
+		for _, token := range []*domain.AccessToken{issued, rotated} {
+			if _, err := store.FindByAccessToken(token.Value()); !errors.Is(err, infrastructure.ErrAccessTokenNotFound) {
+				t.Errorf("FindByAccessToken() of the revoked grant error = %v, want %v", err, infrastructure.ErrAccessTokenNotFound)
+			}
+		}
+		for _, token := range []*domain.RefreshToken{issuedRefresh, rotatedRefresh} {
+			if _, err := store.FindByRefreshToken(token.Value()); !errors.Is(err, infrastructure.ErrRefreshTokenNotFound) {
+				t.Errorf("FindByRefreshToken() of the revoked grant error = %v, want %v", err, infrastructure.ErrRefreshTokenNotFound)
+			}
+		}
+		for _, token := range []*domain.AccessToken{other, withoutGrant} {
+			if _, err := store.FindByAccessToken(token.Value()); err != nil {
+				t.Errorf("access token of another grant should remain: %v", err)
+			}
+		}
+		if _, err := store.FindByRefreshToken(withoutGrantRefresh.Value()); err != nil {
+			t.Errorf("refresh token without grant should remain: %v", err)
+		}
+
+		// 空の識別子では何も無効にしない
+		if revoked, err := store.RevokeByGrantID(""); err != nil || revoked != 0 {
+			t.Errorf("RevokeByGrantID(\"\") = (%d, %v), want (0, nil)", revoked, err)
+		}
+		if _, err := store.FindByAccessToken(withoutGrant.Value()); err != nil {
+			t.Errorf("access token without grant should remain: %v", err)
+		}
+	})
+
+	t.Run("有効期限切れのトークンの一括削除", func(t *testing.T) {
+		store := newBackend(t).Tokens
+		now := time.Now()
+		for i := range 3 {
+			saveTokens(t, store, fmt.Sprintf("client-%d", i), "user-1", now.Add(-domain.RefreshTokenDuration-time.Hour))
+		}
+		// アクセストークンのみ有効期限が切れている
+		accessExpired, refreshLive := saveTokens(t, store, "client-3", "user-1", now.Add(-domain.AccessTokenDuration-time.Hour))
+		live, _ := saveTokens(t, store, "client-4", "user-1", now)
+
+		purged := purgeAll(t, func(limit int) (int, error) { return store.PurgeExpired(now, limit) }, 2)
+		if purged != 7 {
+			t.Errorf("PurgeExpired() purged %d tokens, want 7", purged)
+		}
+		if _, err := store.FindByAccessToken(accessExpired.Value()); !errors.Is(err, infrastructure.ErrAccessTokenNotFound) {
+			t.Errorf("FindByAccessToken() of expired token error = %v, want %v", err, infrastructure.ErrAccessTokenNotFound)
+		}
+		if _, err := store.FindByRefreshToken(refreshLive.Value()); err != nil {
+			t.Errorf("FindByRefreshToken() of live token error = %v", err)
+		}
+		if _, err := store.FindByAccessToken(live.Value()); err != nil {
+			t.Errorf("FindByAccessToken() of live token error = %v", err)
+		}
+	})
+
+	t.Run("並行した保存と検索", func(t *testing.T) {
+		store := newBackend(t).Tokens
+		now := time.Now()
+		parallel(concurrency, func(i int) {
+			accessToken, refreshToken := saveTokens(t, store, fmt.Sprintf("client-%d", i), "user-1", now)
+			if _, err := store.FindByAccessToken(accessToken.Value()); err != nil {
+				t.Errorf("FindByAccessToken() error = %v", err)
+			}
+			if _, err := store.FindByRefreshToken(refreshToken.Value()); err != nil {
+				t.Errorf("FindByRefreshToken() error = %v", err)
+			}
+		})
+	})
+}
+
+// アクセストークンとリフレッシュトークンの組を発行日時nowで保存する
+func saveTokens(t *testing.T, store infrastructure.TokenStore, clientID string, userID string, now time.Time) (*domain.AccessToken, *domain.RefreshToken) {
+	t.Helper()
+	accessToken := domain.NewAccessToken(clientID, userID, []string{"read"}, now, domain.AccessTokenDuration)
+	refreshToken := domain.NewRefreshToken(clientID, userID, []string{"read"}, now, domain.RefreshTokenDuration)
+	if err := store.Save(accessToken); err != nil {
+		t.Errorf("Save() error = %v", err)
+	}
+	if err := store.SaveRefreshToken(refreshToken, accessToken); err != nil {
+		t.Errorf("SaveRefreshToken() error = %v", err)
+	}
+	return accessToken, refreshToken
+}
+
+func assertRefreshToken(t *testing.T, actual *domain.RefreshToken, want *domain.RefreshToken) {
+	t.Helper()
+	if actual.Value() != want.Value() ||
+		actual.ClientID() != want.ClientID() ||
+		actual.UserID() != want.UserID() ||
+		!slices.Equal(actual.Scopes(), want.Scopes()) ||
+		actual.IssuedAt() != want.IssuedAt() ||
+		actual.ExpiresAt() != want.ExpiresAt() ||
+		actual.GrantID() != want.GrantID() {
+		t.Errorf("refresh token = %+v, want %+v", actual, want)
+	}
+}
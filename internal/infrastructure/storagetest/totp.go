@@ -0,0 +1,198 @@
+package storagetest
+
+import (
+	"errors"
+	"oauth-tutorial/internal/domain"
+	"oauth-tutorial/internal/infrastructure"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func testTOTP(t *testing.T, newBackend Factory) {
+	secret := []byte("12345678901234567890")
+
+	t.Run("保存と検索と上書き", func(t *testing.T) {
+		store := newBackend(t).TOTP
+		if _, err := store.FindByUserID("user-1"); !errors.Is(err, infrastructure.ErrTOTPCredentialNotFound) {
+			t.Fatalf("FindByUserID() error = %v, want %v", err, infrastructure.ErrTOTPCredentialNotFound)
+		}
+
+		pending := domain.NewTOTPCredential("user-1", secret)
+		if err := store.Save(pending); err != nil {
+			t.Fatalf("Save() error = %v", err)
+		}
+		actual, err := store.FindByUserID("user-1")
+		if err != nil {
+			t.Fatalf("FindByUserID() error = %v", err)
+		}
+		assertTOTPCredential(t, actual, pending)
+
+		confirmed := pending.Confirm(time.Unix(1_700_000_000, 0), 56_666_666, []string{"hash-b", "hash-a"})
+		if err := store.Save(confirmed); err != nil {
+			t.Fatalf("Save() error = %v", err)
+		}
+		actual, err = store.FindByUserID("user-1")
+		if err != nil {
+			t.Fatalf("FindByUserID() error = %v", err)
+		}
+		assertTOTPCredential(t, actual, confirmed)
+	})
+
+	t.Run("最後に使ったステップより後のステップのみ使える", func(t *testing.T) {
+		store := newBackend(t).TOTP
+		store.Save(domain.NewTOTPCredential("user-1", secret).Confirm(time.Now(), 100, nil))
+
+		for _, tt := range []struct {
+			step int64
+			want bool
+		}{
+			{step: 100, want: false},
+			{step: 99, want: false},
+			{step: 101, want: true},
+			{step: 101, want: false},
+			{step: 103, want: true},
+			{step: 102, want: false},
+		} {
+			used, err := store.UseStep("user-1", tt.step)
+			if err != nil {
+				t.Fatalf("UseStep(%d) error = %v", tt.step, err)
+			}
+			if used != tt.want {
+				t.Errorf("UseStep(%d) = %v, want %v", tt.step, used, tt.want)
+			}
+		}
+		actual, _ := store.FindByUserID("user-1")
+		if actual.LastUsedStep() != 103 {
+			t.Errorf("LastUsedStep() = %d, want 103", actual.LastUsedStep())
+		}
+		if _, err := store.UseStep("unknown", 200); !errors.Is(err, infrastructure.ErrTOTPCredentialNotFound) {
+			t.Errorf("UseStep() error = %v, want %v", err, infrastructure.ErrTOTPCredentialNotFound)
+		}
+	})
+
+	t.Run("同じステップを並行して使っても成功するのは1回のみ", func(t *testing.T) {
+		store := newBackend(t).TOTP
+		store.Save(domain.NewTOTPCredential("user-1", secret).Confirm(time.Now(), 100, nil))
+
+		var succeeded atomic.Int32
+		parallel(concurrency, func(int) {
+			used, err := store.UseStep("user-1", 101)
+			if err != nil {
+				t.Errorf("UseStep() error = %v", err)
+				return
+			}
+			if used {
+				succeeded.Add(1)
+			}
+		})
+		if n := succeeded.Load(); n != 1 {
+			t.Errorf("UseStep succeeded %d times, want 1", n)
+		}
+	})
+
+	t.Run("リカバリーコードは1回のみ使える", func(t *testing.T) {
+		store := newBackend(t).TOTP
+		store.Save(domain.NewTOTPCredential("user-1", secret).Confirm(time.Now(), 100, []string{"hash-a", "hash-b"}))
+
+		var succeeded atomic.Int32
+		parallel(concurrency, func(int) {
+			consumed, err := store.ConsumeRecoveryCode("user-1", "hash-a")
+			if err != nil {
+				t.Errorf("ConsumeRecoveryCode() error = %v", err)
+				return
+			}
+			if consumed {
+				succeeded.Add(1)
+			}
+		})
+		if n := succeeded.Load(); n != 1 {
+			t.Errorf("ConsumeRecoveryCode succeeded %d times, want 1", n)
+		}
+		if consumed, _ := store.ConsumeRecoveryCode("user-1", "unknown"); consumed {
+			t.Error("ConsumeRecoveryCode() with an unknown code = true, want false")
+		}
+
+		// 他のリカバリーコードと最後に使ったステップは変わらないこと
+		actual, _ := store.FindByUserID("user-1")
+		if !slices.Equal(actual.RecoveryCodeHashes(), []string{"hash-b"}) || actual.LastUsedStep() != 100 {
+			t.Errorf("credential after consuming = %v, %d", actual.RecoveryCodeHashes(), actual.LastUsedStep())
+		}
+		if _, err := store.ConsumeRecoveryCode("unknown", "hash-b"); !errors.Is(err, infrastructure.ErrTOTPCredentialNotFound) {
+			t.Errorf("ConsumeRecoveryCode() error = %v, want %v", err, infrastructure.ErrTOTPCredentialNotFound)
+		}
+	})
+
+	t.Run("失敗の記録と確認しない期限", func(t *testing.T) {
+		store := newBackend(t).TOTP
+		store.Save(domain.NewTOTPCredential("user-1", secret).Confirm(time.Now(), 100, []string{"hash-a"}))
+		now := time.Unix(1_700_000_000, 0)
+
+		// 上限に達するまでは期限を設けず、以降は失敗する毎に期限を延ばす
+		for attempts := 1; attempts <= domain.TOTPMaxFailedAttempts+1; attempts++ {
+			actual, err := store.RecordFailedAttempt("user-1", now)
+			if err != nil {
+				t.Fatalf("RecordFailedAttempt() error = %v", err)
+			}
+			expectedLockedUntil := time.Time{}
+			if lockout := domain.TOTPLockout(attempts); lockout > 0 {
+				expectedLockedUntil = now.Add(lockout)
+			}
+			if actual.FailedAttempts() != attempts || !actual.LockedUntil().Equal(expectedLockedUntil) {
+				t.Fatalf("RecordFailedAttempt() = (%d, %v), want (%d, %v)", actual.FailedAttempts(), actual.LockedUntil(), attempts, expectedLockedUntil)
+			}
+		}
+		// 他の項目は変わらないこと
+		actual, _ := store.FindByUserID("user-1")
+		if !actual.LockedOut(now) || actual.LastUsedStep() != 100 || !slices.Equal(actual.RecoveryCodeHashes(), []string{"hash-a"}) {
+			t.Errorf("credential after failures = %+v", actual)
+		}
+
+		if err := store.ResetFailedAttempts("user-1"); err != nil {
+			t.Fatalf("ResetFailedAttempts() error = %v", err)
+		}
+		actual, _ = store.FindByUserID("user-1")
+		if actual.FailedAttempts() != 0 || actual.LockedOut(now) {
+			t.Errorf("credential after reset = (%d, %v), want no failures", actual.FailedAttempts(), actual.LockedUntil())
+		}
+
+		if _, err := store.RecordFailedAttempt("unknown", now); !errors.Is(err, infrastructure.ErrTOTPCredentialNotFound) {
+			t.Errorf("RecordFailedAttempt() error = %v, want %v", err, infrastructure.ErrTOTPCredentialNotFound)
+		}
+		if err := store.ResetFailedAttempts("unknown"); !errors.Is(err, infrastructure.ErrTOTPCredentialNotFound) {
+			t.Errorf("ResetFailedAttempts() error = %v, want %v", err, infrastructure.ErrTOTPCredentialNotFound)
+		}
+	})
+
+	t.Run("並行した失敗を全て数える", func(t *testing.T) {
+		store := newBackend(t).TOTP
+		store.Save(domain.NewTOTPCredential("user-1", secret).Confirm(time.Now(), 100, nil))
+
+		parallel(concurrency, func(int) {
+			if _, err := store.RecordFailedAttempt("user-1", time.Now()); err != nil {
+				t.Errorf("RecordFailedAttempt() error = %v", err)
+			}
+		})
+		if actual, _ := store.FindByUserID("user-1"); actual.FailedAttempts() != concurrency {
+			t.Errorf("FailedAttempts() = %d, want %d", actual.FailedAttempts(), concurrency)
+		}
+	})
+}
+
+// リカバリーコードの順序は保存しないため、並べ替えて比較する
+func assertTOTPCredential(t *testing.T, actual *domain.TOTPCredential, expected *domain.TOTPCredential) {
+	t.Helper()
+	if actual.UserID() != expected.UserID() ||
+		!slices.Equal(actual.Secret(), expected.Secret()) ||
+		!actual.ConfirmedAt().Equal(expected.ConfirmedAt()) ||
+		actual.Enabled() != expected.Enabled() ||
+		actual.LastUsedStep() != expected.LastUsedStep() ||
+		actual.FailedAttempts() != expected.FailedAttempts() ||
+		!actual.LockedUntil().Equal(expected.LockedUntil()) ||
+		!slices.Equal(slices.Sorted(slices.Values(actual.RecoveryCodeHashes())), slices.Sorted(slices.Values(expected.RecoveryCodeHashes()))) {
+		t.Errorf("credential = {%s %x %v %d %v}, want {%s %x %v %d %v}",
+			actual.UserID(), actual.Secret(), actual.ConfirmedAt(), actual.LastUsedStep(), actual.RecoveryCodeHashes(),
+			expected.UserID(), expected.Secret(), expected.ConfirmedAt(), expected.LastUsedStep(), expected.RecoveryCodeHashes())
+	}
+}
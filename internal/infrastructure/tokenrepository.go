@@ -1,31 +1,52 @@
 package infrastructure
 
 import (
+	"cmp"
 	"errors"
 	"oauth-tutorial/internal/domain"
+	"slices"
 	"sync"
+	"time"
 )
 
-type ITokenRepository interface {
-	Save(token *domain.AccessToken) error
-	FindByAccessToken(token string) (*domain.AccessToken, error)
-}
+var (
+	ErrAccessTokenNotFound  = errors.New("access token not found")
+	ErrRefreshTokenNotFound = errors.New("refresh token not found")
+)
 
 type TokenRepository struct {
-	store map[string]*domain.AccessToken
-	mu    sync.RWMutex
+	store        map[string]*domain.AccessToken
+	refreshStore map[string]refreshTokenEntry
+	mu           sync.RWMutex
+}
+
+// リフレッシュトークンと、同時に発行したアクセストークンの組
+type refreshTokenEntry struct {
+	refreshToken *domain.RefreshToken
+	accessToken  *domain.AccessToken
 }
 
+var _ TokenStore = (*TokenRepository)(nil)
+
 func NewTokenRespository() *TokenRepository {
 	return &TokenRepository{
-		store: make(map[string]*domain.AccessToken),
+		store:        make(map[string]*domain.AccessToken),
+		refreshStore: make(map[string]refreshTokenEntry),
 	}
 }
 
-func (r *TokenRepository) Save(token *domain.AccessToken) {
+func (r *TokenRepository) Save(token *domain.AccessToken) error {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	r.store[token.Value()] = token
+	return nil
+}
+
+func (r *TokenRepository) SaveRefreshToken(token *domain.RefreshToken, accessToken *domain.AccessToken) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.refreshStore[token.Value()] = refreshTokenEntry{refreshToken: token, accessToken: accessToken}
+	return nil
 }
 
 func (r *TokenRepository) FindByAccessToken(token string) (*domain.AccessToken, error) {
@@ -33,7 +54,168 @@ func (r *TokenRepository) FindByAccessToken(token string) (*domain.AccessToken,
 	defer r.mu.RUnlock()
 	t, ok := r.store[token]
 	if !ok {
-		return nil, errors.New("not found")
+		return nil, ErrAccessTokenNotFound
 	}
 	return t, nil
 }
+
+func (r *TokenRepository) FindByRefreshToken(token string) (*domain.RefreshToken, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	entry, ok := r.refreshStore[token]
+	if !ok {
+		return nil, ErrRefreshTokenNotFound
+	}
+	return entry.refreshToken, nil
+}
+
+func (r *TokenRepository) DeleteRefreshToken(token string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.refreshStore, token)
+	return nil
+}
+
+// リフレッシュトークンを取得すると同時に削除する
+func (r *TokenRepository) ConsumeRefreshToken(token string) (*domain.RefreshToken, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	entry, ok := r.refreshStore[token]
+	if !ok {
+		return nil, ErrRefreshTokenNotFound
+	}
+	delete(r.refreshStore, token)
+	return entry.refreshToken, nil
+}
+
+// ユーザーがクライアントに対して発行した有効期限内のリフレッシュトークンを発行日時の順に返す
+func (r *TokenRepository) FindRefreshTokensByUser(userID string, now time.Time) ([]*domain.RefreshToken, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	tokens := make([]*domain.RefreshToken, 0)
+	for _, entry := range r.refreshStore {
+		rt := entry.refreshToken
+		if rt.UserID() == userID && !rt.IsExpired(now) {
+			tokens = append(tokens, rt)
+		}
+	}
+	slices.SortFunc(tokens, func(a, b *domain.RefreshToken) int {
+		return cmp.Compare(a.IssuedAt(), b.IssuedAt())
+	})
+	return tokens, nil
+}
+
+func (r *TokenRepository) FindRefreshTokensByUserAndClient(userID string, clientID string, now time.Time) ([]*domain.RefreshToken, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	tokens := make([]*domain.RefreshToken, 0)
+	for _, entry := range r.refreshStore {
+		rt := entry.refreshToken
+		if rt.UserID() == userID && rt.ClientID() == clientID && !rt.IsExpired(now) {
+			tokens = append(tokens, rt)
+		}
+	}
+	slices.SortFunc(tokens, func(a, b *domain.RefreshToken) int {
+		return cmp.Compare(a.IssuedAt(), b.IssuedAt())
+	})
+	return tokens, nil
+}
+
+// ユーザーがクライアントに対して発行した全てのアクセストークンとリフレッシュトークンを無効にし、無効にした数を返す
+func (r *TokenRepository) RevokeByUserAndClient(userID string, clientID string) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	revoked := 0
+	for value, token := range r.store {
+		if token.UserID() == userID && token.ClientID() == clientID {
+			delete(r.store, value)
+			revoked++
+		}
+	}
+	for value, entry := range r.refreshStore {
+		if entry.refreshToken.UserID() == userID && entry.refreshToken.ClientID() == clientID {
+			delete(r.refreshStore, value)
+			revoked++
+		}
+	}
+	return revoked, nil
+}
+
+// 同じ認可から発行した全てのアクセストークンとリフレッシュトークンを無効にし、無効にした数を返す
+func (r *TokenRepository) RevokeByGrantID(grantID string) (int, error) {
+	// 認可コード以外から発行したトークンを巻き込まないよう、空の識別子では何もしない
+	if grantID == "" {
+		return 0, nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	revoked := 0
+	for value, token := range r.store {
+		if token.GrantID() == grantID {
+			delete(r.store, value)
+			revoked++
+		}
+	}
+	for value, entry := range r.refreshStore {
+		if entry.refreshToken.GrantID() == grantID {
+			delete(r.refreshStore, value)
+			revoked++
+		}
+	}
+	return revoked, nil
+}
+
+// 有効期限切れのアクセストークンとリフレッシュトークンを最大limit件削除し、削除した数を返す
+func (r *TokenRepository) PurgeExpired(now time.Time, limit int) (int, error) {
+	accessExpired := func(token *domain.AccessToken) bool { return now.Unix() > token.ExpiresAt() }
+	refreshExpired := func(entry refreshTokenEntry) bool { return entry.refreshToken.IsExpired(now) }
+	return purgeExpiredKeys(limit, func(limit int) (int, int) {
+		// 全体の走査は読み取りロックで行い、書き込みロックは集めたキーの削除の間だけ取得する
+		r.mu.RLock()
+		accessTokens := collectExpiredKeys(r.store, limit, accessExpired)
+		refreshTokens := collectExpiredKeys(r.refreshStore, limit-len(accessTokens), refreshExpired)
+		r.mu.RUnlock()
+
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		return len(accessTokens) + len(refreshTokens), deleteExpiredKeys(r.store, accessTokens, accessExpired) + deleteExpiredKeys(r.refreshStore, refreshTokens, refreshExpired)
+	}), nil
+}
+
+// スナップショットのために全てのアクセストークンとリフレッシュトークンを書き出す
+func (r *TokenRepository) Export() ([]AccessTokenRecord, []RefreshTokenRecord) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	accessTokens := make([]AccessTokenRecord, 0, len(r.store))
+	for _, token := range r.store {
+		accessTokens = append(accessTokens, newAccessTokenRecord(token))
+	}
+	refreshTokens := make([]RefreshTokenRecord, 0, len(r.refreshStore))
+	for _, entry := range r.refreshStore {
+		refreshTokens = append(refreshTokens, newRefreshTokenRecord(entry.refreshToken, entry.accessToken))
+	}
+	return accessTokens, refreshTokens
+}
+
+// スナップショットから書き出したトークンを取り込む。有効期限切れのものは取り込まず、その数を返す
+func (r *TokenRepository) Import(accessTokens []AccessTokenRecord, refreshTokens []RefreshTokenRecord, now time.Time) (skipped int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, record := range accessTokens {
+		if now.Unix() > record.ExpiresAt {
+			skipped++
+			continue
+		}
+		token := record.reconstruct()
+		r.store[token.Value()] = token
+	}
+	for _, record := range refreshTokens {
+		token := record.reconstruct()
+		if token.IsExpired(now) {
+			skipped++
+			continue
+		}
+		r.refreshStore[token.Value()] = refreshTokenEntry{refreshToken: token, accessToken: record.AccessToken.reconstruct()}
+	}
+	return skipped
+}
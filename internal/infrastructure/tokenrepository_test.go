@@ -0,0 +1,73 @@
+package infrastructure
+
+import (
+	"oauth-tutorial/internal/domain"
+	"testing"
+	"time"
+)
+
+func Test_リフレッシュトークンの保存と検索(t *testing.T) {
+	repo := NewTokenRespository()
+	now := time.Now()
+	accessToken := domain.NewAccessToken("client-1", "user-1", []string{"read"}, now, domain.AccessTokenDuration)
+	refreshToken := domain.NewRefreshToken("client-1", "user-1", []string{"read"}, now, domain.RefreshTokenDuration)
+	repo.Save(accessToken)
+	repo.SaveRefreshToken(refreshToken, accessToken)
+
+	actual, err := repo.FindByRefreshToken(refreshToken.Value())
+	if err != nil {
+		t.Fatalf("FindByRefreshToken() error = %v", err)
+	}
+	if actual != refreshToken {
+		t.Errorf("FindByRefreshToken() = %v, want %v", actual, refreshToken)
+	}
+
+	repo.DeleteRefreshToken(refreshToken.Value())
+	if _, err := repo.FindByRefreshToken(refreshToken.Value()); err != ErrRefreshTokenNotFound {
+		t.Errorf("FindByRefreshToken() error = %v, want %v", err, ErrRefreshTokenNotFound)
+	}
+}
+
+func Test_ユーザーとクライアント単位でのトークンの検索と無効化(t *testing.T) {
+	repo := NewTokenRespository()
+	now := time.Now()
+	save := func(clientID, userID string, issuedAt time.Time) (*domain.AccessToken, *domain.RefreshToken) {
+		at := domain.NewAccessToken(clientID, userID, []string{"read"}, issuedAt, domain.AccessTokenDuration)
+		rt := domain.NewRefreshToken(clientID, userID, []string{"read"}, issuedAt, domain.RefreshTokenDuration)
+		repo.Save(at)
+		repo.SaveRefreshToken(rt, at)
+		return at, rt
+	}
+	target, targetRefresh := save("client-1", "user-1", now)
+	save("client-1", "user-1", now.Add(-2*domain.RefreshTokenDuration))
+	otherClient, otherClientRefresh := save("client-2", "user-1", now)
+	otherUser, _ := save("client-1", "user-2", now)
+
+	// 有効期限切れのリフレッシュトークンは含まない
+	tokens, _ := repo.FindRefreshTokensByUserAndClient("user-1", "client-1", now)
+	if len(tokens) != 1 || tokens[0] != targetRefresh {
+		t.Fatalf("FindRefreshTokensByUserAndClient() = %v, want [%v]", tokens, targetRefresh)
+	}
+
+	repo.RevokeByUserAndClient("user-1", "client-1")
+
+	if _, err := repo.FindByAccessToken(target.Value()); err == nil {
+		t.Error("access token of the revoked client should be deleted")
+	}
+	if _, err := repo.FindByRefreshToken(targetRefresh.Value()); err == nil {
+		t.Error("refresh token of the revoked client should be deleted")
+	}
+	if tokens, _ := repo.FindRefreshTokensByUserAndClient("user-1", "client-1", now); len(tokens) != 0 {
+		t.Error("no refresh token should remain for the revoked client")
+	}
+	// 他のクライアント、他のユーザーのトークンは残ること
+	if _, err := repo.FindByAccessToken(otherClient.Value()); err != nil {
+		t.Errorf("access token of another client should remain: %v", err)
+	}
+	if _, err := repo.FindByRefreshToken(otherClientRefresh.Value()); err != nil {
+		t.Errorf("refresh token of another client should remain: %v", err)
+	}
+	if _, err := repo.FindByAccessToken(otherUser.Value()); err != nil {
+		t.Errorf("access token of another user should remain: %v", err)
+	}
+}
@@ -0,0 +1,115 @@
+package infrastructure
+
+import (
+	"errors"
+	"oauth-tutorial/internal/domain"
+	"slices"
+	"sync"
+	"time"
+)
+
+var ErrTOTPCredentialNotFound = errors.New("totp credential not found")
+
+type TOTPRepository struct {
+	store map[string]*domain.TOTPCredential
+	mu    sync.RWMutex
+}
+
+var _ TOTPStore = (*TOTPRepository)(nil)
+
+func NewTOTPRepository() *TOTPRepository {
+	return &TOTPRepository{
+		store: make(map[string]*domain.TOTPCredential),
+	}
+}
+
+func (r *TOTPRepository) Save(credential *domain.TOTPCredential) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.store[credential.UserID()] = credential
+	return nil
+}
+
+func (r *TOTPRepository) FindByUserID(userID string) (*domain.TOTPCredential, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	credential, ok := r.store[userID]
+	if !ok {
+		return nil, ErrTOTPCredentialNotFound
+	}
+	return credential, nil
+}
+
+func (r *TOTPRepository) UseStep(userID string, step int64) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c, ok := r.store[userID]
+	if !ok {
+		return false, ErrTOTPCredentialNotFound
+	}
+	if step <= c.LastUsedStep() {
+		return false, nil
+	}
+	r.store[userID] = domain.ReconstructTOTPCredential(c.UserID(), c.Secret(), c.ConfirmedAt(), step, c.RecoveryCodeHashes(), c.FailedAttempts(), c.LockedUntil())
+	return true, nil
+}
+
+func (r *TOTPRepository) ConsumeRecoveryCode(userID string, codeHash string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c, ok := r.store[userID]
+	if !ok {
+		return false, ErrTOTPCredentialNotFound
+	}
+	i := slices.Index(c.RecoveryCodeHashes(), codeHash)
+	if i < 0 {
+		return false, nil
+	}
+	// 保存済みの登録のスライスを書き換えないよう、取り除いた新しいスライスで置き換える
+	remaining := slices.Delete(slices.Clone(c.RecoveryCodeHashes()), i, i+1)
+	r.store[userID] = domain.ReconstructTOTPCredential(c.UserID(), c.Secret(), c.ConfirmedAt(), c.LastUsedStep(), remaining, c.FailedAttempts(), c.LockedUntil())
+	return true, nil
+}
+
+func (r *TOTPRepository) RecordFailedAttempt(userID string, now time.Time) (*domain.TOTPCredential, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c, ok := r.store[userID]
+	if !ok {
+		return nil, ErrTOTPCredentialNotFound
+	}
+	failed := c.RecordFailure(now)
+	r.store[userID] = failed
+	return failed, nil
+}
+
+func (r *TOTPRepository) ResetFailedAttempts(userID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c, ok := r.store[userID]
+	if !ok {
+		return ErrTOTPCredentialNotFound
+	}
+	r.store[userID] = domain.ReconstructTOTPCredential(c.UserID(), c.Secret(), c.ConfirmedAt(), c.LastUsedStep(), c.RecoveryCodeHashes(), 0, time.Time{})
+	return nil
+}
+
+// スナップショットのために全ての登録を書き出す
+func (r *TOTPRepository) Export() []TOTPCredentialRecord {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	records := make([]TOTPCredentialRecord, 0, len(r.store))
+	for _, credential := range r.store {
+		records = append(records, newTOTPCredentialRecord(credential))
+	}
+	return records
+}
+
+// スナップショットから書き出した登録を取り込む。同じユーザーの登録は上書きする
+func (r *TOTPRepository) Import(records []TOTPCredentialRecord) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, record := range records {
+		r.store[record.UserID] = record.reconstruct()
+	}
+}
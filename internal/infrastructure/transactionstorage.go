@@ -0,0 +1,78 @@
+package infrastructure
+
+import (
+	"errors"
+	"oauth-tutorial/internal/infrastructure/dto"
+	"oauth-tutorial/internal/session"
+	"sync"
+	"time"
+)
+
+var (
+	ErrInvalidTransactionID   = errors.New("transactionID is required")
+	ErrInvalidTransactionData = errors.New("invalid transaction data")
+	ErrTransactionNotFound    = errors.New("transaction not found")
+)
+
+type TransactionStorage struct {
+	store map[session.TransactionID]*dto.AuthorizationTransaction
+	mu    sync.RWMutex
+}
+
+func NewTransactionStorage() *TransactionStorage {
+	return &TransactionStorage{
+		store: make(map[session.TransactionID]*dto.AuthorizationTransaction),
+	}
+}
+
+func (s *TransactionStorage) Save(transaction *dto.AuthorizationTransaction) error {
+	if transaction == nil {
+		return ErrInvalidTransactionData
+	}
+	if transaction.ID() == "" {
+		return ErrInvalidTransactionID
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.store[transaction.ID()] = transaction
+	return nil
+}
+
+func (s *TransactionStorage) Get(transactionID session.TransactionID) (*dto.AuthorizationTransaction, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	transaction, ok := s.store[transactionID]
+	if !ok {
+		return nil, ErrTransactionNotFound
+	}
+	return transaction, nil
+}
+
+func (s *TransactionStorage) Delete(transactionID session.TransactionID) error {
+	if transactionID == "" {
+		return ErrInvalidTransactionID
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.store, transactionID)
+	return nil
+}
+
+// 有効期限切れのトランザクションを最大limit件削除し、削除した数を返す
+func (s *TransactionStorage) PurgeExpired(now time.Time, limit int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	purged := 0
+	for transactionID, transaction := range s.store {
+		if purged >= limit {
+			break
+		}
+		if transaction.IsExpired(now) {
+			delete(s.store, transactionID)
+			purged++
+		}
+	}
+	return purged, nil
+}
@@ -0,0 +1,141 @@
+package infrastructure
+
+import (
+	"oauth-tutorial/internal/domain"
+	"oauth-tutorial/internal/infrastructure/dto"
+	"oauth-tutorial/internal/session"
+	"oauth-tutorial/pkg/mylogger"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestTransaction(t *testing.T, id session.TransactionID) *dto.AuthorizationTransaction {
+	t.Helper()
+	param, err := domain.NewAuthorizationCodeFlowParam(mylogger.NewMockLogger(), domain.DefaultScopeRegistry(), "code", "test-client", "https://example.com/callback", "read write", "test-state")
+	if err != nil {
+		t.Fatalf("Failed to create AuthorizationCodeFlowParam: %v", err)
+	}
+	return dto.NewAuthorizationTransaction(id, "test-session-id", param, time.Now())
+}
+
+func Test_認可トランザクションの保存と取得(t *testing.T) {
+	tests := []struct {
+		name        string
+		transaction *dto.AuthorizationTransaction
+		expectedErr error
+	}{
+		{
+			name:        "正常ケース",
+			transaction: newTestTransaction(t, "test-transaction-id"),
+			expectedErr: nil,
+		},
+		{
+			name:        "異常ケース - 空のトランザクションID",
+			transaction: newTestTransaction(t, ""),
+			expectedErr: ErrInvalidTransactionID,
+		},
+		{
+			name:        "異常ケース - 空のトランザクション",
+			transaction: nil,
+			expectedErr: ErrInvalidTransactionData,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// given
+			ts := NewTransactionStorage()
+
+			// when
+			err := ts.Save(tt.transaction)
+
+			// then
+			if err != tt.expectedErr {
+				t.Fatalf("Save() error = %v, want %v", err, tt.expectedErr)
+			}
+			if tt.expectedErr != nil {
+				if len(ts.store) != 0 {
+					t.Errorf("store length = %d, want 0", len(ts.store))
+				}
+				return
+			}
+
+			actual, err := ts.Get(tt.transaction.ID())
+			if err != nil {
+				t.Fatalf("Get() error = %v", err)
+			}
+			if actual.SessionID() != tt.transaction.SessionID() {
+				t.Errorf("SessionID() = %v, want %v", actual.SessionID(), tt.transaction.SessionID())
+			}
+			if actual.AuthParam().ClientID() != tt.transaction.AuthParam().ClientID() {
+				t.Errorf("AuthParam().ClientID() = %v, want %v", actual.AuthParam().ClientID(), tt.transaction.AuthParam().ClientID())
+			}
+		})
+	}
+}
+
+func Test_認可トランザクションの削除(t *testing.T) {
+	ts := NewTransactionStorage()
+	transaction := newTestTransaction(t, "test-transaction-id")
+	if err := ts.Save(transaction); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	if err := ts.Delete(""); err != ErrInvalidTransactionID {
+		t.Errorf("Delete() error = %v, want %v", err, ErrInvalidTransactionID)
+	}
+	if err := ts.Delete(transaction.ID()); err != nil {
+		t.Errorf("Delete() error = %v, want nil", err)
+	}
+	if _, err := ts.Get(transaction.ID()); err != ErrTransactionNotFound {
+		t.Errorf("Get() error = %v, want %v", err, ErrTransactionNotFound)
+	}
+}
+
+func Test_認可トランザクションの並行アクセス(t *testing.T) {
+	ts := NewTransactionStorage()
+	gen := session.NewTransactionIDGenerator()
+
+	var wg sync.WaitGroup
+	numGoroutines := 100
+	for range numGoroutines {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			transaction := newTestTransaction(t, gen.Generate())
+			ts.Save(transaction)
+			ts.Get(transaction.ID())
+		}()
+	}
+	wg.Wait()
+
+	if len(ts.store) != numGoroutines {
+		t.Errorf("store length = %d, want %d", len(ts.store), numGoroutines)
+	}
+}
+
+func Test_有効期限切れの認可トランザクションの一括削除(t *testing.T) {
+	// given
+	ts := NewTransactionStorage()
+	ts.Save(newTestTransaction(t, "expired-1"))
+	ts.Save(newTestTransaction(t, "expired-2"))
+	now := time.Now().Add(dto.AUTHORIZATION_TRANSACTION_DURATION + time.Minute)
+	live := dto.NewAuthorizationTransaction("live", "test-session-id", newTestTransaction(t, "live").AuthParam(), now)
+	ts.Save(live)
+
+	// when
+	first, err := ts.PurgeExpired(now, 1)
+	if err != nil {
+		t.Fatalf("PurgeExpired() error = %v", err)
+	}
+	second, _ := ts.PurgeExpired(now, 10)
+
+	// then
+	if first != 1 || second != 1 {
+		t.Errorf("PurgeExpired() = (%d, %d), want (1, 1)", first, second)
+	}
+	if _, err := ts.Get("live"); err != nil {
+		t.Errorf("Get(live) error = %v, want nil", err)
+	}
+}
@@ -3,30 +3,126 @@ package infrastructure
 import (
 	"errors"
 	"oauth-tutorial/internal/domain"
+	"oauth-tutorial/pkg/mycrypto"
+	"sync"
 )
 
-type LoginPasswordPair struct {
-	LoginID  string
-	Password string
-}
-
 var ErrUserNotFound = errors.New("user not found")
 
-var users = map[LoginPasswordPair]*domain.User{
-	{LoginID: "test-user@example.com", Password: "password"}: domain.ReconstructUser("IU7ewbuvey", "test-user@example.com", "password"),
+type UserRepository struct {
+	// ログインIDをキーとする
+	users map[string]*domain.User
+	// 上流のIdPのユーザーを、issとsubの組から検索する
+	federated map[domain.FederatedIdentity]*domain.User
+	// ログインID毎の、登録に使った設定のパスワードのハッシュ値
+	seeded map[string]string
+	mu     sync.RWMutex
 }
 
-type UserRepository struct {
+var _ UserStore = (*UserRepository)(nil)
+
+// 起動時に登録するユーザー。パスワードは"password"をmycrypto.DefaultArgon2Paramsでハッシュ化したもの
+func DefaultUsers() []*domain.User {
+	return []*domain.User{
+		domain.ReconstructUser("IU7ewbuvey", "test-user@example.com", "$argon2id$v=19$m=19456,t=2,p=1$9v5OPZqxQGdXustWK2x/Xg$Ftmb7cTAoO+b78RJTatVMREUbf5m/As4Z5rIqWc/aPI"),
+	}
 }
 
 func NewUserRepository() *UserRepository {
-	return &UserRepository{}
+	return NewUserRepositoryWithUsers(DefaultUsers())
+}
+
+// 起動時に登録するユーザーを指定して構築する
+func NewUserRepositoryWithUsers(seed []*domain.User) *UserRepository {
+	r := &UserRepository{users: map[string]*domain.User{}, federated: map[domain.FederatedIdentity]*domain.User{}, seeded: map[string]string{}}
+	for _, user := range seed {
+		r.put(user)
+	}
+	return r
+}
+
+func (r *UserRepository) Save(user *domain.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.put(user)
+	return nil
+}
+
+// ロックを取得してから呼び出すこと
+func (r *UserRepository) put(user *domain.User) {
+	r.users[user.LoginID()] = user
+	if identity := user.FederatedIdentity(); identity != nil {
+		r.federated[*identity] = user
+	}
+}
+
+func (r *UserRepository) FindByLoginID(loginID string) (*domain.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	user, ok := r.users[loginID]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	return user, nil
 }
 
-func (r *UserRepository) SelectByLoginIDAndPassword(loginID, password string) (*domain.User, error) {
-	user, ok := users[LoginPasswordPair{LoginID: loginID, Password: password}]
+func (r *UserRepository) FindByFederatedIdentity(identity domain.FederatedIdentity) (*domain.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	user, ok := r.federated[identity]
 	if !ok {
 		return nil, ErrUserNotFound
 	}
 	return user, nil
 }
+
+func (r *UserRepository) SaveSeedUser(user *domain.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.put(user)
+	r.seeded[user.LoginID()] = user.PasswordHash()
+	return nil
+}
+
+func (r *UserRepository) FindSeededPasswordHash(loginID string) (string, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if _, ok := r.users[loginID]; !ok {
+		return "", ErrUserNotFound
+	}
+	return r.seeded[loginID], nil
+}
+
+func (r *UserRepository) HashPlaintextPasswords(hash func(password string) string) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var hashed []string
+	for loginID, user := range r.users {
+		if mycrypto.IsPlaintextPassword(user.PasswordHash()) {
+			r.put(user.WithPasswordHash(hash(user.PasswordHash())))
+			hashed = append(hashed, loginID)
+		}
+	}
+	return hashed, nil
+}
+
+// スナップショットのために全てのユーザーを書き出す
+func (r *UserRepository) Export() []UserRecord {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	records := make([]UserRecord, 0, len(r.users))
+	for loginID, user := range r.users {
+		records = append(records, newUserRecord(user, r.seeded[loginID]))
+	}
+	return records
+}
+
+// スナップショットから書き出したユーザーを取り込む。同じログインIDのユーザーは上書きする
+func (r *UserRepository) Import(records []UserRecord) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, record := range records {
+		r.put(record.reconstruct())
+		r.seeded[record.LoginID] = record.SeededPasswordHash
+	}
+}
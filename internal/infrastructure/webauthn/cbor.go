@@ -0,0 +1,128 @@
+package webauthn
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// WebAuthnの構造(attestationObject・COSE_Key)を読むための最小限のCBORのデコーダー RFC 8949
+// 整数・バイト列・文字列・配列・マップ・真偽値・nullのみに対応し、浮動小数点数・タグ・不定長の値はエラーにする
+// 値は int64, []byte, string, []any, map[any]any, bool, nil のいずれかで返す
+
+const maxCBORDepth = 16
+
+var errMalformedCBOR = errors.New("malformed cbor")
+
+// 先頭の1つの値をデコードし、残りのバイト列を返す
+func decodeCBOR(data []byte) (any, []byte, error) {
+	return decodeCBORValue(data, 0)
+}
+
+func decodeCBORValue(data []byte, depth int) (any, []byte, error) {
+	if depth > maxCBORDepth {
+		return nil, nil, fmt.Errorf("%w: nested too deeply", errMalformedCBOR)
+	}
+	if len(data) == 0 {
+		return nil, nil, fmt.Errorf("%w: unexpected end of data", errMalformedCBOR)
+	}
+	major, info := data[0]>>5, data[0]&0x1f
+	if major == 7 {
+		switch info {
+		case 20:
+			return false, data[1:], nil
+		case 21:
+			return true, data[1:], nil
+		case 22:
+			return nil, data[1:], nil
+		}
+		return nil, nil, fmt.Errorf("%w: unsupported simple value %d", errMalformedCBOR, info)
+	}
+	arg, rest, err := decodeCBORArgument(info, data[1:])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch major {
+	case 0:
+		if arg > math.MaxInt64 {
+			return nil, nil, fmt.Errorf("%w: integer overflow", errMalformedCBOR)
+		}
+		return int64(arg), rest, nil
+	case 1:
+		if arg > math.MaxInt64 {
+			return nil, nil, fmt.Errorf("%w: integer overflow", errMalformedCBOR)
+		}
+		return -1 - int64(arg), rest, nil
+	case 2, 3:
+		if arg > uint64(len(rest)) {
+			return nil, nil, fmt.Errorf("%w: unexpected end of data", errMalformedCBOR)
+		}
+		if major == 2 {
+			return rest[:arg], rest[arg:], nil
+		}
+		return string(rest[:arg]), rest[arg:], nil
+	case 4:
+		// 要素は1バイト以上のため、残りのバイト数より多い要素数は不正
+		if arg > uint64(len(rest)) {
+			return nil, nil, fmt.Errorf("%w: unexpected end of data", errMalformedCBOR)
+		}
+		items := make([]any, 0, arg)
+		for range arg {
+			var item any
+			item, rest, err = decodeCBORValue(rest, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			items = append(items, item)
+		}
+		return items, rest, nil
+	case 5:
+		if arg > uint64(len(rest)) {
+			return nil, nil, fmt.Errorf("%w: unexpected end of data", errMalformedCBOR)
+		}
+		m := make(map[any]any, arg)
+		for range arg {
+			var key, value any
+			key, rest, err = decodeCBORValue(rest, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			switch key.(type) {
+			case int64, string:
+			default:
+				return nil, nil, fmt.Errorf("%w: unsupported map key", errMalformedCBOR)
+			}
+			if _, ok := m[key]; ok {
+				return nil, nil, fmt.Errorf("%w: duplicate map key", errMalformedCBOR)
+			}
+			value, rest, err = decodeCBORValue(rest, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			m[key] = value
+		}
+		return m, rest, nil
+	}
+	return nil, nil, fmt.Errorf("%w: unsupported major type %d", errMalformedCBOR, major)
+}
+
+// 初期バイトの下位5ビットと後続のバイトから、値(整数の値・長さ・要素数)を読む RFC 8949 3.
+func decodeCBORArgument(info byte, data []byte) (uint64, []byte, error) {
+	switch {
+	case info < 24:
+		return uint64(info), data, nil
+	case info == 24 && len(data) >= 1:
+		return uint64(data[0]), data[1:], nil
+	case info == 25 && len(data) >= 2:
+		return uint64(binary.BigEndian.Uint16(data)), data[2:], nil
+	case info == 26 && len(data) >= 4:
+		return uint64(binary.BigEndian.Uint32(data)), data[4:], nil
+	case info == 27 && len(data) >= 8:
+		return binary.BigEndian.Uint64(data), data[8:], nil
+	case info >= 28:
+		return 0, nil, fmt.Errorf("%w: indefinite length is not supported", errMalformedCBOR)
+	}
+	return 0, nil, fmt.Errorf("%w: unexpected end of data", errMalformedCBOR)
+}
@@ -0,0 +1,51 @@
+package webauthn
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func Test_CBORのデコード(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     []byte
+		expected any
+		// デコードした後の残りのバイト数
+		expectedRest int
+		expectedErr  error
+	}{
+		// RFC 8949 Appendix A の例
+		{name: "正常系 - 整数", data: []byte{0x18, 0x64}, expected: int64(100)},
+		{name: "正常系 - 負の整数", data: []byte{0x38, 0x63}, expected: int64(-100)},
+		{name: "正常系 - 4バイトの整数", data: []byte{0x1a, 0x00, 0x0f, 0x42, 0x40}, expected: int64(1000000)},
+		{name: "正常系 - バイト列", data: []byte{0x44, 0x01, 0x02, 0x03, 0x04}, expected: []byte{1, 2, 3, 4}},
+		{name: "正常系 - 文字列", data: []byte{0x64, 0x49, 0x45, 0x54, 0x46}, expected: "IETF"},
+		{name: "正常系 - 配列", data: []byte{0x83, 0x01, 0x02, 0x03}, expected: []any{int64(1), int64(2), int64(3)}},
+		{name: "正常系 - マップ", data: []byte{0xa2, 0x01, 0x02, 0x61, 0x61, 0xf5}, expected: map[any]any{int64(1): int64(2), "a": true}},
+		{name: "正常系 - 後続のデータを残す", data: []byte{0x01, 0x02, 0x03}, expected: int64(1), expectedRest: 2},
+		{name: "異常系 - 長さが足りない", data: []byte{0x44, 0x01}, expectedErr: errMalformedCBOR},
+		{name: "異常系 - 不定長", data: []byte{0x5f, 0x41, 0x01, 0xff}, expectedErr: errMalformedCBOR},
+		{name: "異常系 - 浮動小数点数", data: []byte{0xf9, 0x3c, 0x00}, expectedErr: errMalformedCBOR},
+		{name: "異常系 - 重複したキー", data: []byte{0xa2, 0x01, 0x02, 0x01, 0x03}, expectedErr: errMalformedCBOR},
+		{name: "異常系 - 要素数がデータより多い", data: []byte{0x9a, 0xff, 0xff, 0xff, 0xff}, expectedErr: errMalformedCBOR},
+		{name: "異常系 - 空のデータ", data: []byte{}, expectedErr: errMalformedCBOR},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// when
+			actual, rest, err := decodeCBOR(tt.data)
+
+			// then
+			if !errors.Is(err, tt.expectedErr) {
+				t.Fatalf("decodeCBOR() error = %v, want %v", err, tt.expectedErr)
+			}
+			if tt.expectedErr != nil {
+				return
+			}
+			if !reflect.DeepEqual(actual, tt.expected) || len(rest) != tt.expectedRest {
+				t.Errorf("decodeCBOR() = %#v, rest %d, want %#v, rest %d", actual, len(rest), tt.expected, tt.expectedRest)
+			}
+		})
+	}
+}
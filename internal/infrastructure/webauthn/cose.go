@@ -0,0 +1,127 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// 受け付ける署名アルゴリズム(COSEのalgの値) https://www.iana.org/assignments/cose/cose.xhtml#algorithms
+const (
+	AlgES256 int64 = -7
+	AlgEdDSA int64 = -8
+	AlgRS256 int64 = -257
+)
+
+// 登録時に認証器へ提示する順(優先する順)
+var SupportedAlgorithms = []int64{AlgES256, AlgEdDSA, AlgRS256}
+
+// COSE_Keyのパラメータ RFC 9052 7.1, RFC 9053 7.
+const (
+	coseKeyType  int64 = 1
+	coseKeyAlg   int64 = 3
+	coseKeyCurve int64 = -1
+	coseKeyX     int64 = -2
+	coseKeyY     int64 = -3
+	// RSAの場合 RFC 8230 4.
+	coseKeyN int64 = -1
+	coseKeyE int64 = -2
+
+	coseKeyTypeOKP int64 = 1
+	coseKeyTypeEC2 int64 = 2
+	coseKeyTypeRSA int64 = 3
+
+	coseCurveP256    int64 = 1
+	coseCurveEd25519 int64 = 6
+)
+
+// RS256の鍵の最小のビット数
+const minRSAKeyBits = 2048
+
+var errInvalidSignature = errors.New("invalid signature")
+
+type coseKey struct {
+	algorithm int64
+	key       crypto.PublicKey
+}
+
+// COSE_Key形式の公開鍵を読む。受け付けないアルゴリズム・曲線の鍵はエラー
+func parseCOSEKey(data []byte) (*coseKey, error) {
+	value, rest, err := decodeCBOR(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) != 0 {
+		return nil, errors.New("trailing data after public key")
+	}
+	m, ok := value.(map[any]any)
+	if !ok {
+		return nil, errors.New("public key must be a map")
+	}
+	keyType, _ := m[coseKeyType].(int64)
+	alg, _ := m[coseKeyAlg].(int64)
+
+	switch {
+	case keyType == coseKeyTypeEC2 && alg == AlgES256:
+		crv, _ := m[coseKeyCurve].(int64)
+		x, _ := m[coseKeyX].([]byte)
+		y, _ := m[coseKeyY].([]byte)
+		if crv != coseCurveP256 || len(x) != 32 || len(y) != 32 {
+			return nil, errors.New("invalid ec2 public key")
+		}
+		pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, errors.New("invalid ec2 public key")
+		}
+		return &coseKey{algorithm: alg, key: pub}, nil
+	case keyType == coseKeyTypeOKP && alg == AlgEdDSA:
+		crv, _ := m[coseKeyCurve].(int64)
+		x, _ := m[coseKeyX].([]byte)
+		if crv != coseCurveEd25519 || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid okp public key")
+		}
+		return &coseKey{algorithm: alg, key: ed25519.PublicKey(x)}, nil
+	case keyType == coseKeyTypeRSA && alg == AlgRS256:
+		n, _ := m[coseKeyN].([]byte)
+		e, _ := m[coseKeyE].([]byte)
+		if len(e) == 0 || len(e) > 4 {
+			return nil, errors.New("invalid rsa public key")
+		}
+		pub := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		if pub.N.BitLen() < minRSAKeyBits {
+			return nil, errors.New("rsa public key is too short")
+		}
+		return &coseKey{algorithm: alg, key: pub}, nil
+	}
+	return nil, fmt.Errorf("unsupported public key (kty %d, alg %d)", keyType, alg)
+}
+
+// signedDataに対する署名を検証する
+// ES256の署名はJWSと異なりASN.1 DER形式 WebAuthn Level 2 6.5.6
+func (k *coseKey) verify(signedData []byte, signature []byte) error {
+	switch k.algorithm {
+	case AlgES256:
+		digest := sha256.Sum256(signedData)
+		if !ecdsa.VerifyASN1(k.key.(*ecdsa.PublicKey), digest[:], signature) {
+			return errInvalidSignature
+		}
+	case AlgEdDSA:
+		if !ed25519.Verify(k.key.(ed25519.PublicKey), signedData, signature) {
+			return errInvalidSignature
+		}
+	case AlgRS256:
+		digest := sha256.Sum256(signedData)
+		if err := rsa.VerifyPKCS1v15(k.key.(*rsa.PublicKey), crypto.SHA256, digest[:], signature); err != nil {
+			return errInvalidSignature
+		}
+	default:
+		return errInvalidSignature
+	}
+	return nil
+}
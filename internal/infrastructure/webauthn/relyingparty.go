@@ -0,0 +1,250 @@
+// パスキーの登録とログインでブラウザから受け取った値を検証する、WebAuthnのRelying Party(RP)の実装
+//
+// https://www.w3.org/TR/webauthn-2/ の7.1(登録)と7.2(ログイン)の手順のうち、
+// アテステーションの形式noneのみに対応する。認証器の種類(AAGUID)や証明書チェーンは検証しない。
+// チャレンジの発行・照合とクレデンシャルの保存は呼び出し元で行う。
+package webauthn
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+var ErrInvalidCredential = errors.New("invalid webauthn credential")
+
+// 認証器データのフラグ WebAuthn Level 2 6.1
+const (
+	flagUserPresent       byte = 0x01
+	flagUserVerified      byte = 0x04
+	flagBackupEligible    byte = 0x08
+	flagBackupState       byte = 0x10
+	flagAttestedData      byte = 0x40
+	flagExtensionIncluded byte = 0x80
+)
+
+// クレデンシャルIDの最大長 WebAuthn Level 2 5.8.3
+const maxCredentialIDLength = 1023
+
+type RelyingParty struct {
+	// RP ID。認可サーバーのホスト名
+	id string
+	// 登録時に認証器に表示する名前
+	name string
+	// 登録・ログインを受け付けるページのオリジン(例: https://auth.example.com)
+	origins []string
+}
+
+func NewRelyingParty(id string, name string, origins ...string) *RelyingParty {
+	return &RelyingParty{id: id, name: name, origins: origins}
+}
+
+func (rp *RelyingParty) ID() string   { return rp.id }
+func (rp *RelyingParty) Name() string { return rp.name }
+
+// 登録で確認したクレデンシャル
+type Registration struct {
+	CredentialID []byte
+	// COSE_Key形式の公開鍵
+	PublicKey      []byte
+	SignCount      uint32
+	UserVerified   bool
+	BackupEligible bool
+}
+
+// ログインで確認した署名
+type Assertion struct {
+	SignCount    uint32
+	UserPresent  bool
+	UserVerified bool
+	// 複数の端末に同期されるパスキーかどうか(BEフラグ)
+	BackupEligible bool
+	// 同期されたパスキーかどうか(BSフラグ)
+	BackupState bool
+}
+
+// CollectedClientData WebAuthn Level 2 5.8.1
+type clientData struct {
+	Type        string `json:"type"`
+	Challenge   string `json:"challenge"`
+	Origin      string `json:"origin"`
+	CrossOrigin bool   `json:"crossOrigin"`
+}
+
+type authenticatorData struct {
+	rpIDHash  []byte
+	flags     byte
+	signCount uint32
+	// AT(登録)の場合のみ設定する
+	credentialID []byte
+	publicKey    []byte
+}
+
+// clientDataJSONに含まれるチャレンジ(base64url)。呼び出し元は、このチャレンジで発行したチャレンジを取得して照合する
+func (rp *RelyingParty) Challenge(clientDataJSON []byte) (string, error) {
+	var cd clientData
+	if err := json.Unmarshal(clientDataJSON, &cd); err != nil || cd.Challenge == "" {
+		return "", fmt.Errorf("%w: malformed client data", ErrInvalidCredential)
+	}
+	return cd.Challenge, nil
+}
+
+// 登録の結果を検証する WebAuthn Level 2 7.1
+// challengeは発行したチャレンジ(base64url)
+func (rp *RelyingParty) VerifyRegistration(clientDataJSON []byte, attestationObject []byte, challenge string) (Registration, error) {
+	if err := rp.verifyClientData(clientDataJSON, "webauthn.create", challenge); err != nil {
+		return Registration{}, err
+	}
+
+	value, rest, err := decodeCBOR(attestationObject)
+	if err != nil || len(rest) != 0 {
+		return Registration{}, fmt.Errorf("%w: malformed attestation object", ErrInvalidCredential)
+	}
+	attestation, ok := value.(map[any]any)
+	if !ok {
+		return Registration{}, fmt.Errorf("%w: malformed attestation object", ErrInvalidCredential)
+	}
+	// アテステーションを要求しないため、形式noneで空の文のもののみ受け付ける WebAuthn Level 2 8.7
+	format, _ := attestation["fmt"].(string)
+	statement, _ := attestation["attStmt"].(map[any]any)
+	if format != "none" || statement == nil || len(statement) != 0 {
+		return Registration{}, fmt.Errorf("%w: unsupported attestation format %q", ErrInvalidCredential, format)
+	}
+	rawAuthData, _ := attestation["authData"].([]byte)
+	authData, err := rp.verifyAuthenticatorData(rawAuthData)
+	if err != nil {
+		return Registration{}, err
+	}
+	if authData.credentialID == nil {
+		return Registration{}, fmt.Errorf("%w: attested credential data is missing", ErrInvalidCredential)
+	}
+	if _, err := parseCOSEKey(authData.publicKey); err != nil {
+		return Registration{}, fmt.Errorf("%w: %w", ErrInvalidCredential, err)
+	}
+	return Registration{
+		CredentialID:   authData.credentialID,
+		PublicKey:      authData.publicKey,
+		SignCount:      authData.signCount,
+		UserVerified:   authData.flags&flagUserVerified != 0,
+		BackupEligible: authData.flags&flagBackupEligible != 0,
+	}, nil
+}
+
+// ログインの署名を検証する WebAuthn Level 2 7.2
+// publicKeyは登録したクレデンシャルの公開鍵、challengeは発行したチャレンジ(base64url)
+// 署名カウンターの比較は呼び出し元で行う
+func (rp *RelyingParty) VerifyAssertion(clientDataJSON []byte, rawAuthData []byte, signature []byte, publicKey []byte, challenge string) (Assertion, error) {
+	if err := rp.verifyClientData(clientDataJSON, "webauthn.get", challenge); err != nil {
+		return Assertion{}, err
+	}
+	authData, err := rp.verifyAuthenticatorData(rawAuthData)
+	if err != nil {
+		return Assertion{}, err
+	}
+	key, err := parseCOSEKey(publicKey)
+	if err != nil {
+		return Assertion{}, fmt.Errorf("%w: %w", ErrInvalidCredential, err)
+	}
+	// 署名の対象は認証器データとclientDataJSONのハッシュ値を連結したもの
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	if err := key.verify(slices.Concat(rawAuthData, clientDataHash[:]), signature); err != nil {
+		return Assertion{}, fmt.Errorf("%w: %w", ErrInvalidCredential, err)
+	}
+	return Assertion{
+		SignCount:      authData.signCount,
+		UserPresent:    authData.flags&flagUserPresent != 0,
+		UserVerified:   authData.flags&flagUserVerified != 0,
+		BackupEligible: authData.flags&flagBackupEligible != 0,
+		BackupState:    authData.flags&flagBackupState != 0,
+	}, nil
+}
+
+func (rp *RelyingParty) verifyClientData(clientDataJSON []byte, ceremony string, challenge string) error {
+	var cd clientData
+	if err := json.Unmarshal(clientDataJSON, &cd); err != nil {
+		return fmt.Errorf("%w: malformed client data", ErrInvalidCredential)
+	}
+	if cd.Type != ceremony {
+		return fmt.Errorf("%w: unexpected type %q", ErrInvalidCredential, cd.Type)
+	}
+	// 別のページや別のRPで発行したチャレンジの署名を受け付けない
+	if subtle.ConstantTimeCompare([]byte(cd.Challenge), []byte(challenge)) != 1 {
+		return fmt.Errorf("%w: challenge mismatch", ErrInvalidCredential)
+	}
+	if !slices.Contains(rp.origins, cd.Origin) {
+		return fmt.Errorf("%w: unexpected origin %q", ErrInvalidCredential, cd.Origin)
+	}
+	// 他サイトに埋め込まれたiframeからの登録・ログインは受け付けない
+	if cd.CrossOrigin {
+		return fmt.Errorf("%w: cross-origin request", ErrInvalidCredential)
+	}
+	return nil
+}
+
+// 認証器データを読み、RP IDとユーザーの存在の確認(UPフラグ)を検証する WebAuthn Level 2 6.1
+func (rp *RelyingParty) verifyAuthenticatorData(data []byte) (*authenticatorData, error) {
+	if len(data) < 37 {
+		return nil, fmt.Errorf("%w: authenticator data is too short", ErrInvalidCredential)
+	}
+	authData := &authenticatorData{rpIDHash: data[:32], flags: data[32], signCount: binary.BigEndian.Uint32(data[33:37])}
+	rpIDHash := sha256.Sum256([]byte(rp.id))
+	if !bytes.Equal(authData.rpIDHash, rpIDHash[:]) {
+		return nil, fmt.Errorf("%w: rp id hash mismatch", ErrInvalidCredential)
+	}
+	if authData.flags&flagUserPresent == 0 {
+		return nil, fmt.Errorf("%w: user is not present", ErrInvalidCredential)
+	}
+	// 同期されたパスキー(BS)は同期できる(BE)もののみ
+	if authData.flags&flagBackupState != 0 && authData.flags&flagBackupEligible == 0 {
+		return nil, fmt.Errorf("%w: invalid backup flags", ErrInvalidCredential)
+	}
+
+	rest := data[37:]
+	if authData.flags&flagAttestedData != 0 {
+		// AAGUID(16バイト)・クレデンシャルIDの長さ(2バイト)・クレデンシャルID・公開鍵 6.5.1
+		if len(rest) < 18 {
+			return nil, fmt.Errorf("%w: attested credential data is too short", ErrInvalidCredential)
+		}
+		length := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[18:]
+		if length == 0 || length > maxCredentialIDLength || len(rest) < length {
+			return nil, fmt.Errorf("%w: invalid credential id", ErrInvalidCredential)
+		}
+		authData.credentialID = rest[:length]
+		rest = rest[length:]
+		_, afterKey, err := decodeCBOR(rest)
+		if err != nil {
+			return nil, fmt.Errorf("%w: malformed public key", ErrInvalidCredential)
+		}
+		authData.publicKey = rest[:len(rest)-len(afterKey)]
+		rest = afterKey
+	}
+	if authData.flags&flagExtensionIncluded != 0 {
+		extensions, afterExtensions, err := decodeCBOR(rest)
+		if _, ok := extensions.(map[any]any); err != nil || !ok {
+			return nil, fmt.Errorf("%w: malformed extensions", ErrInvalidCredential)
+		}
+		rest = afterExtensions
+	}
+	if len(rest) != 0 {
+		return nil, fmt.Errorf("%w: trailing data after authenticator data", ErrInvalidCredential)
+	}
+	return authData, nil
+}
+
+// ブラウザとの間でバイト列を受け渡す形式(パディングなしのbase64url)
+func EncodeBase64URL(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// パディングの有無に関わらず受け付ける
+func DecodeBase64URL(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
@@ -0,0 +1,162 @@
+package webauthn_test
+
+import (
+	"errors"
+	"oauth-tutorial/internal/infrastructure/webauthn"
+	"oauth-tutorial/internal/infrastructure/webauthn/webauthntest"
+	"slices"
+	"testing"
+)
+
+const (
+	testRPID      = "auth.example.com"
+	testOrigin    = "https://auth.example.com"
+	testChallenge = "c2VydmVyLWNoYWxsZW5nZQ"
+)
+
+func newTestRelyingParty() *webauthn.RelyingParty {
+	return webauthn.NewRelyingParty(testRPID, "auth.example.com", testOrigin)
+}
+
+func Test_パスキーの登録の検証(t *testing.T) {
+	tests := []struct {
+		name string
+		// 認証器を書き換えて不正な登録結果を生成する
+		modify      func(a *webauthntest.Authenticator)
+		algorithm   int64
+		challenge   string
+		expectedErr error
+	}{
+		{name: "正常系 - ES256", algorithm: webauthn.AlgES256, challenge: testChallenge},
+		{name: "正常系 - EdDSA", algorithm: webauthn.AlgEdDSA, challenge: testChallenge},
+		{name: "正常系 - RS256", algorithm: webauthn.AlgRS256, challenge: testChallenge},
+		{name: "異常系 - チャレンジが異なる", algorithm: webauthn.AlgES256, challenge: "b3RoZXItY2hhbGxlbmdl", expectedErr: webauthn.ErrInvalidCredential},
+		{name: "異常系 - オリジンが異なる", algorithm: webauthn.AlgES256, challenge: testChallenge, modify: func(a *webauthntest.Authenticator) { a.Origin = "https://evil.example.com" }, expectedErr: webauthn.ErrInvalidCredential},
+		{name: "異常系 - RP IDが異なる", algorithm: webauthn.AlgES256, challenge: testChallenge, modify: func(a *webauthntest.Authenticator) { a.RPID = "evil.example.com" }, expectedErr: webauthn.ErrInvalidCredential},
+		{name: "異常系 - none以外のアテステーション", algorithm: webauthn.AlgES256, challenge: testChallenge, modify: func(a *webauthntest.Authenticator) { a.Format = "packed" }, expectedErr: webauthn.ErrInvalidCredential},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// given
+			rp := newTestRelyingParty()
+			authenticator := webauthntest.NewAuthenticatorWithAlgorithm(t, testRPID, testOrigin, tt.algorithm)
+			if tt.modify != nil {
+				tt.modify(authenticator)
+			}
+			attestation := authenticator.Create(testChallenge, []byte("user-1"))
+
+			// when
+			registration, err := rp.VerifyRegistration(attestation.ClientDataJSON, attestation.AttestationObject, tt.challenge)
+
+			// then
+			if !errors.Is(err, tt.expectedErr) {
+				t.Fatalf("VerifyRegistration() error = %v, want %v", err, tt.expectedErr)
+			}
+			if tt.expectedErr != nil {
+				return
+			}
+			if !slices.Equal(registration.CredentialID, attestation.CredentialID) || len(registration.PublicKey) == 0 || registration.SignCount != 0 || !registration.UserVerified || registration.BackupEligible {
+				t.Errorf("VerifyRegistration() = %+v", registration)
+			}
+		})
+	}
+}
+
+func Test_パスキーのログインの検証(t *testing.T) {
+	tests := []struct {
+		name        string
+		algorithm   int64
+		challenge   string
+		modify      func(a *webauthntest.Authenticator)
+		tamper      func(assertion *webauthntest.Assertion)
+		expectedErr error
+	}{
+		{name: "正常系 - ES256", algorithm: webauthn.AlgES256, challenge: testChallenge},
+		{name: "正常系 - EdDSA", algorithm: webauthn.AlgEdDSA, challenge: testChallenge},
+		{name: "正常系 - RS256", algorithm: webauthn.AlgRS256, challenge: testChallenge},
+		{name: "異常系 - チャレンジが異なる", algorithm: webauthn.AlgES256, challenge: "b3RoZXItY2hhbGxlbmdl", expectedErr: webauthn.ErrInvalidCredential},
+		{name: "異常系 - オリジンが異なる", algorithm: webauthn.AlgES256, challenge: testChallenge, modify: func(a *webauthntest.Authenticator) { a.Origin = "https://evil.example.com" }, expectedErr: webauthn.ErrInvalidCredential},
+		{name: "異常系 - RP IDが異なる", algorithm: webauthn.AlgES256, challenge: testChallenge, modify: func(a *webauthntest.Authenticator) { a.RPID = "evil.example.com" }, expectedErr: webauthn.ErrInvalidCredential},
+		{
+			name: "異常系 - 認証器データの改ざん", algorithm: webauthn.AlgES256, challenge: testChallenge,
+			tamper:      func(assertion *webauthntest.Assertion) { assertion.AuthenticatorData[36]++ },
+			expectedErr: webauthn.ErrInvalidCredential,
+		},
+		{
+			name: "異常系 - 登録のclientDataJSONでの署名", algorithm: webauthn.AlgES256, challenge: testChallenge,
+			tamper: func(assertion *webauthntest.Assertion) {
+				assertion.ClientDataJSON = []byte(`{"type":"webauthn.create","challenge":"` + testChallenge + `","origin":"` + testOrigin + `"}`)
+			},
+			expectedErr: webauthn.ErrInvalidCredential,
+		},
+		{
+			name: "異常系 - ユーザーの存在を確認していない", algorithm: webauthn.AlgES256, challenge: testChallenge,
+			tamper:      func(assertion *webauthntest.Assertion) { assertion.AuthenticatorData[32] &^= 0x01 },
+			expectedErr: webauthn.ErrInvalidCredential,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// given
+			rp := newTestRelyingParty()
+			authenticator := webauthntest.NewAuthenticatorWithAlgorithm(t, testRPID, testOrigin, tt.algorithm)
+			attestation := authenticator.Create("cmVnaXN0cmF0aW9u", []byte("user-1"))
+			registration, err := rp.VerifyRegistration(attestation.ClientDataJSON, attestation.AttestationObject, "cmVnaXN0cmF0aW9u")
+			if err != nil {
+				t.Fatalf("VerifyRegistration() error = %v", err)
+			}
+			if tt.modify != nil {
+				tt.modify(authenticator)
+			}
+			assertion := authenticator.Get(testChallenge)
+			if tt.tamper != nil {
+				tt.tamper(&assertion)
+			}
+
+			// when
+			result, err := rp.VerifyAssertion(assertion.ClientDataJSON, assertion.AuthenticatorData, assertion.Signature, registration.PublicKey, tt.challenge)
+
+			// then
+			if !errors.Is(err, tt.expectedErr) {
+				t.Fatalf("VerifyAssertion() error = %v, want %v", err, tt.expectedErr)
+			}
+			if tt.expectedErr != nil {
+				return
+			}
+			if result.SignCount != 1 || !result.UserPresent || !result.UserVerified || result.BackupEligible || result.BackupState {
+				t.Errorf("VerifyAssertion() = %+v", result)
+			}
+		})
+	}
+
+	t.Run("異常系 - 別のクレデンシャルの公開鍵", func(t *testing.T) {
+		// given
+		rp := newTestRelyingParty()
+		other := webauthntest.NewAuthenticator(t, testRPID, testOrigin)
+		attestation := other.Create(testChallenge, []byte("user-2"))
+		registration, err := rp.VerifyRegistration(attestation.ClientDataJSON, attestation.AttestationObject, testChallenge)
+		if err != nil {
+			t.Fatalf("VerifyRegistration() error = %v", err)
+		}
+		assertion := webauthntest.NewAuthenticator(t, testRPID, testOrigin).Get(testChallenge)
+
+		// when
+		_, err = rp.VerifyAssertion(assertion.ClientDataJSON, assertion.AuthenticatorData, assertion.Signature, registration.PublicKey, testChallenge)
+
+		// then
+		if !errors.Is(err, webauthn.ErrInvalidCredential) {
+			t.Errorf("VerifyAssertion() error = %v, want %v", err, webauthn.ErrInvalidCredential)
+		}
+	})
+}
+
+func Test_clientDataJSONのチャレンジ(t *testing.T) {
+	rp := newTestRelyingParty()
+	assertion := webauthntest.NewAuthenticator(t, testRPID, testOrigin).Get(testChallenge)
+	if challenge, err := rp.Challenge(assertion.ClientDataJSON); err != nil || challenge != testChallenge {
+		t.Errorf("Challenge() = %q, %v, want %q", challenge, err, testChallenge)
+	}
+	if _, err := rp.Challenge([]byte("not json")); !errors.Is(err, webauthn.ErrInvalidCredential) {
+		t.Errorf("Challenge() error = %v, want %v", err, webauthn.ErrInvalidCredential)
+	}
+}
@@ -0,0 +1,258 @@
+// テスト用のソフトウェアの認証器
+//
+// ブラウザのnavigator.credentials.create・getと認証器の処理を模倣し、アテステーションの形式noneの登録結果と
+// ログインの署名を生成する。ハードウェアの認証器やブラウザなしでパスキーの登録・ログインを試験できる。
+//
+//	authenticator := webauthntest.NewAuthenticator(t, "localhost", "http://localhost:8080")
+//	attestation := authenticator.Create(challenge, []byte("user-id"))
+//	assertion := authenticator.Get(nextChallenge)
+package webauthntest
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"oauth-tutorial/internal/infrastructure/webauthn"
+	"slices"
+	"sort"
+	"testing"
+)
+
+// 認証器データのフラグ WebAuthn Level 2 6.1
+const (
+	flagUserPresent    byte = 0x01
+	flagUserVerified   byte = 0x04
+	flagBackupEligible byte = 0x08
+	flagBackupState    byte = 0x10
+	flagAttestedData   byte = 0x40
+)
+
+type Authenticator struct {
+	// 以下はテストで書き換えて、不正な登録結果・署名を生成できる
+	RPID   string
+	Origin string
+	// 同期されるパスキーとして振る舞う(BE・BSフラグを設定する)
+	BackupEligible bool
+	// 次の署名で使う署名カウンター。Getの度に1増やす。0の場合はカウンターを実装しない認証器として常に0を使う
+	SignCount uint32
+	// アテステーションの形式。既定値はnone
+	Format string
+	// 本人の確認(UV)を行わない認証器(PINを設定していないセキュリティキーなど)として振る舞う
+	SkipUserVerification bool
+
+	algorithm    int64
+	signer       crypto.Signer
+	credentialID []byte
+	userHandle   []byte
+}
+
+// ES256の鍵を持つ認証器
+func NewAuthenticator(t testing.TB, rpID string, origin string) *Authenticator {
+	return NewAuthenticatorWithAlgorithm(t, rpID, origin, webauthn.AlgES256)
+}
+
+func NewAuthenticatorWithAlgorithm(t testing.TB, rpID string, origin string, algorithm int64) *Authenticator {
+	t.Helper()
+	var (
+		signer crypto.Signer
+		err    error
+	)
+	switch algorithm {
+	case webauthn.AlgES256:
+		signer, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case webauthn.AlgEdDSA:
+		_, signer, err = ed25519.GenerateKey(rand.Reader)
+	case webauthn.AlgRS256:
+		signer, err = rsa.GenerateKey(rand.Reader, 2048)
+	default:
+		t.Fatalf("unsupported algorithm %d", algorithm)
+	}
+	if err != nil {
+		t.Fatalf("GenerateKey() error = %v", err)
+	}
+	credentialID := make([]byte, 32)
+	_, _ = rand.Read(credentialID)
+	return &Authenticator{RPID: rpID, Origin: origin, SignCount: 1, Format: "none", algorithm: algorithm, signer: signer, credentialID: credentialID}
+}
+
+func (a *Authenticator) CredentialID() []byte { return a.credentialID }
+
+// 登録の結果(AuthenticatorAttestationResponse)
+type Attestation struct {
+	CredentialID      []byte
+	ClientDataJSON    []byte
+	AttestationObject []byte
+}
+
+// ログインの結果(AuthenticatorAssertionResponse)
+type Assertion struct {
+	CredentialID      []byte
+	ClientDataJSON    []byte
+	AuthenticatorData []byte
+	Signature         []byte
+	UserHandle        []byte
+}
+
+// challengeはサーバーが発行したチャレンジ(base64url)、userHandleはユーザーのID
+// 登録したユーザーのIDをGetのuserHandleとして返す(発見可能なクレデンシャル)
+func (a *Authenticator) Create(challenge string, userHandle []byte) Attestation {
+	a.userHandle = slices.Clone(userHandle)
+	clientDataJSON := a.clientDataJSON("webauthn.create", challenge)
+
+	attested := slices.Concat(make([]byte, 16), binary.BigEndian.AppendUint16(nil, uint16(len(a.credentialID))), a.credentialID, a.publicKey())
+	// 登録時は署名カウンターを0にする
+	authData := slices.Concat(a.authenticatorData(flagAttestedData, 0), attested)
+	attestationObject := EncodeCBOR(map[any]any{
+		"fmt":      a.Format,
+		"attStmt":  map[any]any{},
+		"authData": authData,
+	})
+	return Attestation{CredentialID: a.credentialID, ClientDataJSON: clientDataJSON, AttestationObject: attestationObject}
+}
+
+// challengeはサーバーが発行したチャレンジ(base64url)
+func (a *Authenticator) Get(challenge string) Assertion {
+	clientDataJSON := a.clientDataJSON("webauthn.get", challenge)
+	authData := a.authenticatorData(0, a.SignCount)
+	if a.SignCount != 0 {
+		a.SignCount++
+	}
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	return Assertion{
+		CredentialID:      a.credentialID,
+		ClientDataJSON:    clientDataJSON,
+		AuthenticatorData: authData,
+		Signature:         a.sign(slices.Concat(authData, clientDataHash[:])),
+		UserHandle:        a.userHandle,
+	}
+}
+
+func (a *Authenticator) clientDataJSON(ceremony string, challenge string) []byte {
+	data, _ := json.Marshal(map[string]any{"type": ceremony, "challenge": challenge, "origin": a.Origin, "crossOrigin": false})
+	return data
+}
+
+// ユーザーの存在の確認(UP)は常に行ったものとする。本人の確認(UV)はSkipUserVerificationでない場合に行う
+func (a *Authenticator) authenticatorData(flags byte, signCount uint32) []byte {
+	flags |= flagUserPresent
+	if !a.SkipUserVerification {
+		flags |= flagUserVerified
+	}
+	if a.BackupEligible {
+		flags |= flagBackupEligible | flagBackupState
+	}
+	rpIDHash := sha256.Sum256([]byte(a.RPID))
+	return binary.BigEndian.AppendUint32(append(rpIDHash[:], flags), signCount)
+}
+
+// COSE_Key形式の公開鍵 RFC 9053
+func (a *Authenticator) publicKey() []byte {
+	switch pub := a.signer.Public().(type) {
+	case *ecdsa.PublicKey:
+		return EncodeCBOR(map[any]any{int64(1): int64(2), int64(3): a.algorithm, int64(-1): int64(1), int64(-2): pub.X.FillBytes(make([]byte, 32)), int64(-3): pub.Y.FillBytes(make([]byte, 32))})
+	case ed25519.PublicKey:
+		return EncodeCBOR(map[any]any{int64(1): int64(1), int64(3): a.algorithm, int64(-1): int64(6), int64(-2): []byte(pub)})
+	case *rsa.PublicKey:
+		return EncodeCBOR(map[any]any{int64(1): int64(3), int64(3): a.algorithm, int64(-1): pub.N.Bytes(), int64(-2): big.NewInt(int64(pub.E)).Bytes()})
+	}
+	panic("unsupported key")
+}
+
+func (a *Authenticator) sign(data []byte) []byte {
+	var (
+		signature []byte
+		err       error
+	)
+	switch a.algorithm {
+	case webauthn.AlgEdDSA:
+		signature, err = a.signer.Sign(rand.Reader, data, crypto.Hash(0))
+	default:
+		// ES256はASN.1 DER形式、RS256はPKCS #1 v1.5の署名
+		digest := sha256.Sum256(data)
+		signature, err = a.signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	}
+	if err != nil {
+		panic(err)
+	}
+	return signature
+}
+
+// テストで使う値のみに対応するCBORのエンコーダー RFC 8949
+// int64・int・[]byte・string・[]any・map[any]any・boolに対応し、マップのキーはエンコードした値の順に並べる
+func EncodeCBOR(v any) []byte {
+	var buf bytes.Buffer
+	encodeCBOR(&buf, v)
+	return buf.Bytes()
+}
+
+func encodeCBOR(buf *bytes.Buffer, v any) {
+	switch value := v.(type) {
+	case int:
+		encodeCBOR(buf, int64(value))
+	case int64:
+		if value >= 0 {
+			writeCBORHead(buf, 0, uint64(value))
+		} else {
+			writeCBORHead(buf, 1, uint64(-1-value))
+		}
+	case []byte:
+		writeCBORHead(buf, 2, uint64(len(value)))
+		buf.Write(value)
+	case string:
+		writeCBORHead(buf, 3, uint64(len(value)))
+		buf.WriteString(value)
+	case []any:
+		writeCBORHead(buf, 4, uint64(len(value)))
+		for _, item := range value {
+			encodeCBOR(buf, item)
+		}
+	case map[any]any:
+		type entry struct{ key, value []byte }
+		entries := make([]entry, 0, len(value))
+		for k, item := range value {
+			entries = append(entries, entry{key: EncodeCBOR(k), value: EncodeCBOR(item)})
+		}
+		sort.Slice(entries, func(i, j int) bool { return bytes.Compare(entries[i].key, entries[j].key) < 0 })
+		writeCBORHead(buf, 5, uint64(len(entries)))
+		for _, e := range entries {
+			buf.Write(e.key)
+			buf.Write(e.value)
+		}
+	case bool:
+		if value {
+			buf.WriteByte(0xf5)
+		} else {
+			buf.WriteByte(0xf4)
+		}
+	default:
+		panic(fmt.Sprintf("unsupported cbor value %T", v))
+	}
+}
+
+func writeCBORHead(buf *bytes.Buffer, major byte, arg uint64) {
+	switch {
+	case arg < 24:
+		buf.WriteByte(major<<5 | byte(arg))
+	case arg <= 0xff:
+		buf.WriteByte(major<<5 | 24)
+		buf.WriteByte(byte(arg))
+	case arg <= 0xffff:
+		buf.WriteByte(major<<5 | 25)
+		buf.Write(binary.BigEndian.AppendUint16(nil, uint16(arg)))
+	case arg <= 0xffffffff:
+		buf.WriteByte(major<<5 | 26)
+		buf.Write(binary.BigEndian.AppendUint32(nil, uint32(arg)))
+	default:
+		buf.WriteByte(major<<5 | 27)
+		buf.Write(binary.BigEndian.AppendUint64(nil, arg))
+	}
+}
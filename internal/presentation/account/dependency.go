@@ -0,0 +1,48 @@
+package account
+
+import (
+	"net/http"
+	"oauth-tutorial/internal/session"
+	"oauth-tutorial/internal/usecase/account"
+)
+
+type IListConnectedAppsUseCase interface {
+	Execute(sessionID session.SessionID) ([]account.ConnectedApp, error)
+}
+
+type IRevokeConnectedAppUseCase interface {
+	Execute(sessionID session.SessionID, clientID string) error
+}
+
+type IRenderer interface {
+	Render(w http.ResponseWriter, statusCode int, name string, data any) error
+}
+
+type IGetTOTPStatusUseCase interface {
+	Execute(sessionID session.SessionID) (account.TOTPStatus, error)
+}
+
+type IStartTOTPEnrollmentUseCase interface {
+	Execute(sessionID session.SessionID) (account.TOTPEnrollment, error)
+}
+
+type IConfirmTOTPEnrollmentUseCase interface {
+	Execute(sessionID session.SessionID, code string) ([]string, error)
+}
+
+type ICSRFProtector interface {
+	Issue(sessionID session.SessionID) string
+	Verify(sessionID session.SessionID, token string) bool
+}
+
+type IListPasskeysUseCase interface {
+	Execute(sessionID session.SessionID) ([]account.RegisteredPasskey, error)
+}
+
+type IBeginPasskeyRegistrationUseCase interface {
+	Execute(sessionID session.SessionID) (account.PasskeyRegistrationOptions, error)
+}
+
+type IFinishPasskeyRegistrationUseCase interface {
+	Execute(sessionID session.SessionID, clientDataJSON []byte, attestationObject []byte, name string) error
+}
@@ -0,0 +1,143 @@
+package account
+
+import (
+	"errors"
+	"net/http"
+	"oauth-tutorial/internal/presentation"
+	"oauth-tutorial/internal/presentation/view"
+	"oauth-tutorial/internal/usecase/account"
+	"oauth-tutorial/pkg/mylogger"
+)
+
+// ログイン済みのユーザーが連携中のアプリを確認・解除するためのハンドラー
+// HTML画面(/account)とJSON API(/account/apps)を提供する
+// 画面からの連携解除は、同一オリジンの確認とCSRFトークンの検証を行う
+type AccountHandler struct {
+	logger             mylogger.Logger
+	listConnectedApps  IListConnectedAppsUseCase
+	revokeConnectedApp IRevokeConnectedAppUseCase
+	renderer           IRenderer
+	csrfProtector      ICSRFProtector
+}
+
+func NewAccountHandler(logger mylogger.Logger, listConnectedApps IListConnectedAppsUseCase, revokeConnectedApp IRevokeConnectedAppUseCase, renderer IRenderer, csrfProtector ICSRFProtector) *AccountHandler {
+	return &AccountHandler{logger: logger, listConnectedApps: listConnectedApps, revokeConnectedApp: revokeConnectedApp, renderer: renderer, csrfProtector: csrfProtector}
+}
+
+// GET /account: 連携中のアプリの一覧画面
+func (h *AccountHandler) ServePage(w http.ResponseWriter, r *http.Request) {
+	sessionID := presentation.SessionIDFromCookie(r)
+	apps, err := h.listConnectedApps.Execute(sessionID)
+	if err != nil {
+		if errors.Is(err, account.ErrLoginRequired) {
+			h.writePage(w, http.StatusUnauthorized, view.AccountPage{Message: "ログインしてください"})
+			return
+		}
+		h.logger.Error("Unexpected error occurred", "err", err)
+		h.writePage(w, http.StatusInternalServerError, view.AccountPage{Message: "予期しないエラーが発生しました"})
+		return
+	}
+
+	page := newAccountPage(apps)
+	page.CSRFToken = h.csrfProtector.Issue(sessionID)
+	h.writePage(w, http.StatusOK, page)
+}
+
+// POST /account/revoke: 画面からの連携解除。解除後は一覧画面へ戻る
+func (h *AccountHandler) ServeRevoke(w http.ResponseWriter, r *http.Request) {
+	if err := presentation.CheckSameOrigin(r); err != nil {
+		mylogger.SecurityEvent(h.logger, "cross_site_request_rejected", "path", r.URL.Path, "origin", r.Header.Get("Origin"), "secFetchSite", r.Header.Get("Sec-Fetch-Site"))
+		h.writePage(w, http.StatusForbidden, view.AccountPage{Message: "不正なリクエストです"})
+		return
+	}
+	err := r.ParseForm()
+	if err != nil {
+		h.logger.Info("Failed to parse form", "err", err)
+		h.writePage(w, http.StatusBadRequest, view.AccountPage{Message: "パラメータの形式を確認してください"})
+		return
+	}
+	if !h.csrfProtector.Verify(presentation.SessionIDFromCookie(r), r.PostForm.Get(presentation.CSRFTokenFieldName)) {
+		mylogger.SecurityEvent(h.logger, "csrf_token_invalid", "path", r.URL.Path)
+		h.writePage(w, http.StatusForbidden, view.AccountPage{Message: "不正なリクエストです"})
+		return
+	}
+
+	err = h.revokeConnectedApp.Execute(presentation.SessionIDFromCookie(r), r.PostForm.Get("client_id"))
+	if err != nil {
+		switch {
+		case errors.Is(err, account.ErrLoginRequired):
+			h.writePage(w, http.StatusUnauthorized, view.AccountPage{Message: "ログインしてください"})
+		case errors.Is(err, account.ErrConnectedAppNotFound):
+			h.writePage(w, http.StatusNotFound, view.AccountPage{Message: "連携中のアプリが見つかりません"})
+		default:
+			h.logger.Error("Unexpected error occurred", "err", err)
+			h.writePage(w, http.StatusInternalServerError, view.AccountPage{Message: "予期しないエラーが発生しました"})
+		}
+		return
+	}
+
+	http.Redirect(w, r, presentation.BasePath(r)+"/account", http.StatusSeeOther)
+}
+
+// GET /account/apps: 連携中のアプリの一覧API
+func (h *AccountHandler) ServeListAPI(w http.ResponseWriter, r *http.Request) {
+	apps, err := h.listConnectedApps.Execute(presentation.SessionIDFromCookie(r))
+	if err != nil {
+		h.writeAPIError(w, err)
+		return
+	}
+
+	presentation.WriteJSONResponse(w, http.StatusOK, NewConnectedAppsResponse(apps))
+}
+
+// DELETE /account/apps/{client_id}: 連携解除API
+func (h *AccountHandler) ServeRevokeAPI(w http.ResponseWriter, r *http.Request) {
+	err := h.revokeConnectedApp.Execute(presentation.SessionIDFromCookie(r), r.PathValue("client_id"))
+	if err != nil {
+		h.writeAPIError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *AccountHandler) writeAPIError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, account.ErrLoginRequired):
+		presentation.WriteJSONResponse(w, http.StatusUnauthorized, ErrorResponse{Message: err.Error()})
+	case errors.Is(err, account.ErrConnectedAppNotFound):
+		presentation.WriteJSONResponse(w, http.StatusNotFound, ErrorResponse{Message: err.Error()})
+	default:
+		h.logger.Error("Unexpected error occurred", "err", err)
+		presentation.WriteJSONResponse(w, http.StatusInternalServerError, ErrorResponse{Message: "予期しないエラーが発生しました"})
+	}
+}
+
+func (h *AccountHandler) writePage(w http.ResponseWriter, statusCode int, page view.AccountPage) {
+	err := h.renderer.Render(w, statusCode, view.AccountTemplate, page)
+	if err != nil {
+		h.logger.Error("Failed to render account page", "err", err)
+	}
+}
+
+func newAccountPage(apps []account.ConnectedApp) view.AccountPage {
+	res := NewConnectedAppsResponse(apps)
+	page := view.AccountPage{Apps: make([]view.AccountApp, 0, len(res.Apps))}
+	for _, app := range res.Apps {
+		refreshTokens := make([]view.AccountRefreshToken, 0, len(app.RefreshTokens))
+		for _, rt := range app.RefreshTokens {
+			refreshTokens = append(refreshTokens, view.AccountRefreshToken(rt))
+		}
+		page.Apps = append(page.Apps, view.AccountApp{
+			ClientID:      app.ClientID,
+			ClientName:    app.ClientName,
+			Scopes:        app.Scopes,
+			GrantedAt:     app.GrantedAt,
+			ExpiresAt:     app.ExpiresAt,
+			FirstUsedAt:   app.FirstUsedAt,
+			LastUsedAt:    app.LastUsedAt,
+			RefreshTokens: refreshTokens,
+		})
+	}
+	return page
+}
@@ -0,0 +1,227 @@
+package account
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"oauth-tutorial/internal/presentation"
+	"oauth-tutorial/internal/presentation/view"
+	"oauth-tutorial/internal/session"
+	"oauth-tutorial/internal/usecase/account"
+	"oauth-tutorial/pkg/mylogger"
+	"strings"
+	"testing"
+	"time"
+)
+
+type mockListConnectedAppsUseCase struct {
+	apps []account.ConnectedApp
+	err  error
+}
+
+func (m *mockListConnectedAppsUseCase) Execute(sessionID session.SessionID) ([]account.ConnectedApp, error) {
+	return m.apps, m.err
+}
+
+type mockRevokeConnectedAppUseCase struct {
+	sessionID session.SessionID
+	clientID  string
+	err       error
+}
+
+func (m *mockRevokeConnectedAppUseCase) Execute(sessionID session.SessionID, clientID string) error {
+	m.sessionID = sessionID
+	m.clientID = clientID
+	return m.err
+}
+
+func newTestApps() []account.ConnectedApp {
+	grantedAt := time.Date(2000, 1, 2, 3, 4, 5, 0, time.UTC)
+	return []account.ConnectedApp{
+		account.NewConnectedApp("client-1", "<テストクライアント>", []string{"read", "write"}, grantedAt, time.Time{}, grantedAt, grantedAt.Add(time.Hour),
+			[]account.ActiveRefreshToken{account.NewActiveRefreshToken([]string{"read"}, grantedAt, grantedAt.Add(24*time.Hour))}),
+	}
+}
+
+func newTestAccountHandler(t *testing.T, list *mockListConnectedAppsUseCase, revoke *mockRevokeConnectedAppUseCase) *AccountHandler {
+	return NewAccountHandler(mylogger.NewMockLogger(), list, revoke, newTestRenderer(t), presentation.NewCSRFProtector([]byte("test-secret")))
+}
+
+func TestAccountHandler_ServeListAPI(t *testing.T) {
+	tests := []struct {
+		name           string
+		mockUseCase    *mockListConnectedAppsUseCase
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "正常ケース",
+			mockUseCase:    &mockListConnectedAppsUseCase{apps: newTestApps()},
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"apps":[{"client_id":"client-1","client_name":"\u003cテストクライアント\u003e","scopes":["read","write"],"granted_at":"2000-01-02T03:04:05Z","first_used_at":"2000-01-02T03:04:05Z","last_used_at":"2000-01-02T04:04:05Z","refresh_tokens":[{"scopes":["read"],"issued_at":"2000-01-02T03:04:05Z","expires_at":"2000-01-03T03:04:05Z"}]}]}`,
+		},
+		{
+			name:           "正常ケース - 連携中のアプリが無い",
+			mockUseCase:    &mockListConnectedAppsUseCase{apps: []account.ConnectedApp{}},
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"apps":[]}`,
+		},
+		{
+			name:           "異常ケース - 未ログイン",
+			mockUseCase:    &mockListConnectedAppsUseCase{err: account.ErrLoginRequired},
+			expectedStatus: http.StatusUnauthorized,
+			expectedBody:   `{"message":"login required"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := newTestAccountHandler(t, tt.mockUseCase, &mockRevokeConnectedAppUseCase{})
+			req := httptest.NewRequest("GET", "/account/apps", nil)
+			rr := httptest.NewRecorder()
+
+			handler.ServeListAPI(rr, req)
+
+			if rr.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, rr.Code)
+			}
+			if actual := strings.TrimSpace(rr.Body.String()); actual != tt.expectedBody {
+				t.Errorf("expected body %s, got %s", tt.expectedBody, actual)
+			}
+		})
+	}
+}
+
+func TestAccountHandler_ServePage(t *testing.T) {
+	handler := newTestAccountHandler(t, &mockListConnectedAppsUseCase{apps: newTestApps()}, &mockRevokeConnectedAppUseCase{})
+	req := httptest.NewRequest("GET", "/account", nil)
+	req.AddCookie(&http.Cookie{Name: session.SessionIDCookieName, Value: "test-session-id"})
+	rr := httptest.NewRecorder()
+
+	handler.ServePage(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rr.Code)
+	}
+	body := rr.Body.String()
+	// クライアント名はエスケープして表示すること
+	for _, expected := range []string{"&lt;テストクライアント&gt;", "read, write", `value="client-1"`, `value="` + testCSRFToken + `"`} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("expected body to contain %q, got %s", expected, body)
+		}
+	}
+}
+
+func TestAccountHandler_ServeRevoke(t *testing.T) {
+	tests := []struct {
+		name             string
+		mockUseCase      *mockRevokeConnectedAppUseCase
+		csrfToken        string
+		header           map[string]string
+		expectedStatus   int
+		expectedLocation string
+		expectedCalled   bool
+	}{
+		{
+			name:             "正常ケース - 一覧画面へ戻る",
+			mockUseCase:      &mockRevokeConnectedAppUseCase{},
+			csrfToken:        testCSRFToken,
+			header:           map[string]string{"Sec-Fetch-Site": "same-origin"},
+			expectedStatus:   http.StatusSeeOther,
+			expectedLocation: "/account",
+			expectedCalled:   true,
+		},
+		{
+			name:           "異常ケース - 連携していないアプリ",
+			mockUseCase:    &mockRevokeConnectedAppUseCase{err: account.ErrConnectedAppNotFound},
+			csrfToken:      testCSRFToken,
+			expectedStatus: http.StatusNotFound,
+			expectedCalled: true,
+		},
+		{
+			name:           "異常ケース - 未ログイン",
+			mockUseCase:    &mockRevokeConnectedAppUseCase{err: account.ErrLoginRequired},
+			csrfToken:      testCSRFToken,
+			expectedStatus: http.StatusUnauthorized,
+			expectedCalled: true,
+		},
+		{
+			name:           "異常ケース - 他サイトからのリクエスト",
+			mockUseCase:    &mockRevokeConnectedAppUseCase{},
+			csrfToken:      testCSRFToken,
+			header:         map[string]string{"Sec-Fetch-Site": "cross-site"},
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "異常ケース - 他のオリジンからのリクエスト",
+			mockUseCase:    &mockRevokeConnectedAppUseCase{},
+			csrfToken:      testCSRFToken,
+			header:         map[string]string{"Origin": "https://evil.example.com"},
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "異常ケース - CSRFトークンがない",
+			mockUseCase:    &mockRevokeConnectedAppUseCase{},
+			expectedStatus: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// given
+			handler := newTestAccountHandler(t, &mockListConnectedAppsUseCase{}, tt.mockUseCase)
+			req := newTOTPFormRequest("/account/revoke", url.Values{"client_id": {"client-1"}, "csrf_token": {tt.csrfToken}})
+			for k, v := range tt.header {
+				req.Header.Set(k, v)
+			}
+			rr := httptest.NewRecorder()
+
+			// when
+			handler.ServeRevoke(rr, req)
+
+			// then
+			if rr.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, rr.Code)
+			}
+			if location := rr.Header().Get("Location"); location != tt.expectedLocation {
+				t.Errorf("expected Location %q, got %q", tt.expectedLocation, location)
+			}
+			if !tt.expectedCalled {
+				if tt.mockUseCase.clientID != "" {
+					t.Errorf("use case called with client_id %v, want not called", tt.mockUseCase.clientID)
+				}
+				return
+			}
+			if tt.mockUseCase.sessionID != "test-session-id" || tt.mockUseCase.clientID != "client-1" {
+				t.Errorf("use case called with (%v, %v), want (test-session-id, client-1)", tt.mockUseCase.sessionID, tt.mockUseCase.clientID)
+			}
+		})
+	}
+}
+
+func TestAccountHandler_ServeRevokeAPI(t *testing.T) {
+	revoke := &mockRevokeConnectedAppUseCase{}
+	handler := newTestAccountHandler(t, &mockListConnectedAppsUseCase{}, revoke)
+	mux := http.NewServeMux()
+	mux.HandleFunc("DELETE /account/apps/{client_id}", handler.ServeRevokeAPI)
+	req := httptest.NewRequest("DELETE", "/account/apps/client-1", nil)
+	rr := httptest.NewRecorder()
+
+	mux.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusNoContent {
+		t.Errorf("expected status %d, got %d", http.StatusNoContent, rr.Code)
+	}
+	if revoke.clientID != "client-1" {
+		t.Errorf("use case called with client_id %v, want client-1", revoke.clientID)
+	}
+}
+
+func newTestRenderer(t *testing.T) *view.Renderer {
+	t.Helper()
+	renderer, err := view.NewRenderer("")
+	if err != nil {
+		t.Fatalf("Failed to create renderer: %v", err)
+	}
+	return renderer
+}
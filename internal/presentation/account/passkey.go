@@ -0,0 +1,133 @@
+package account
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"oauth-tutorial/internal/presentation"
+	"oauth-tutorial/internal/presentation/view"
+	"oauth-tutorial/internal/usecase/account"
+	"oauth-tutorial/pkg/mylogger"
+)
+
+// ログイン済みのユーザーがパスキー(WebAuthn)を登録するためのハンドラー
+// 登録のオプションの取得と登録はセッションの状態を変更するため、同一オリジンの確認とCSRFトークンの検証を行う
+type PasskeyHandler struct {
+	logger        mylogger.Logger
+	list          IListPasskeysUseCase
+	begin         IBeginPasskeyRegistrationUseCase
+	finish        IFinishPasskeyRegistrationUseCase
+	renderer      IRenderer
+	csrfProtector ICSRFProtector
+}
+
+func NewPasskeyHandler(logger mylogger.Logger, list IListPasskeysUseCase, begin IBeginPasskeyRegistrationUseCase, finish IFinishPasskeyRegistrationUseCase, renderer IRenderer, csrfProtector ICSRFProtector) *PasskeyHandler {
+	return &PasskeyHandler{logger: logger, list: list, begin: begin, finish: finish, renderer: renderer, csrfProtector: csrfProtector}
+}
+
+// GET /account/passkeys: 登録済みのパスキーの一覧と登録ボタンの画面
+func (h *PasskeyHandler) ServePage(w http.ResponseWriter, r *http.Request) {
+	h.writePage(w, r, http.StatusOK, "")
+}
+
+// POST /account/passkeys/options: 画面のスクリプトに登録のオプションを返す
+func (h *PasskeyHandler) ServeOptions(w http.ResponseWriter, r *http.Request) {
+	if !h.verifyRequest(r) {
+		presentation.WriteJSONResponse(w, http.StatusForbidden, ErrorResponse{Message: "不正なリクエストです"})
+		return
+	}
+	options, err := h.begin.Execute(presentation.SessionIDFromCookie(r))
+	if err != nil {
+		if errors.Is(err, account.ErrLoginRequired) {
+			presentation.WriteJSONResponse(w, http.StatusUnauthorized, ErrorResponse{Message: err.Error()})
+			return
+		}
+		h.logger.Error("Unexpected error occurred", "err", err)
+		presentation.WriteJSONResponse(w, http.StatusInternalServerError, ErrorResponse{Message: "予期しないエラーが発生しました"})
+		return
+	}
+	presentation.WriteJSONResponse(w, http.StatusOK, NewPasskeyRegistrationOptionsResponse(options))
+}
+
+// POST /account/passkeys: 認証器の登録の結果を検証してパスキーを保存し、一覧画面へ戻る
+// 登録の結果は画面のスクリプトがbase64urlでエンコードしてフォームで送信する
+func (h *PasskeyHandler) ServeRegister(w http.ResponseWriter, r *http.Request) {
+	if !h.verifyRequest(r) {
+		h.render(w, http.StatusForbidden, view.PasskeysPage{Message: "不正なリクエストです"})
+		return
+	}
+	clientDataJSON, err1 := base64.RawURLEncoding.DecodeString(r.PostForm.Get("client_data_json"))
+	attestationObject, err2 := base64.RawURLEncoding.DecodeString(r.PostForm.Get("attestation_object"))
+	if err := errors.Join(err1, err2); err != nil || len(clientDataJSON) == 0 || len(attestationObject) == 0 {
+		h.logger.Info("Invalid passkey registration request", "err", err)
+		h.writePage(w, r, http.StatusBadRequest, "パラメータの形式を確認してください")
+		return
+	}
+
+	err := h.finish.Execute(presentation.SessionIDFromCookie(r), clientDataJSON, attestationObject, r.PostForm.Get("name"))
+	if err != nil {
+		switch {
+		case errors.Is(err, account.ErrLoginRequired):
+			h.writePage(w, r, http.StatusUnauthorized, "ログインしてください")
+		case errors.Is(err, account.ErrPasskeyChallengeNotFound):
+			h.writePage(w, r, http.StatusBadRequest, "登録の有効期限が切れました。もう一度登録してください")
+		case errors.Is(err, account.ErrInvalidPasskey):
+			h.writePage(w, r, http.StatusBadRequest, "パスキーを登録できませんでした。もう一度登録してください")
+		case errors.Is(err, account.ErrPasskeyAlreadyRegistered):
+			h.writePage(w, r, http.StatusConflict, "このパスキーは登録済みです")
+		case errors.Is(err, account.ErrPasskeyNameTooLong):
+			h.writePage(w, r, http.StatusBadRequest, "パスキーの名前が長すぎます")
+		default:
+			h.logger.Error("Unexpected error occurred", "err", err)
+			h.writePage(w, r, http.StatusInternalServerError, "予期しないエラーが発生しました")
+		}
+		return
+	}
+
+	http.Redirect(w, r, presentation.BasePath(r)+"/account/passkeys", http.StatusSeeOther)
+}
+
+// 他サイトからのリクエストとCSRFトークンを検証する
+func (h *PasskeyHandler) verifyRequest(r *http.Request) bool {
+	if err := presentation.CheckSameOrigin(r); err != nil {
+		mylogger.SecurityEvent(h.logger, "cross_site_request_rejected", "path", r.URL.Path, "origin", r.Header.Get("Origin"), "secFetchSite", r.Header.Get("Sec-Fetch-Site"))
+		return false
+	}
+	if err := r.ParseForm(); err != nil {
+		h.logger.Info("Failed to parse form", "err", err)
+		return false
+	}
+	if !h.csrfProtector.Verify(presentation.SessionIDFromCookie(r), r.PostForm.Get(presentation.CSRFTokenFieldName)) {
+		mylogger.SecurityEvent(h.logger, "csrf_token_invalid", "path", r.URL.Path)
+		return false
+	}
+	return true
+}
+
+// 登録済みのパスキーの一覧と、登録をやり直せるようCSRFトークンを含めた画面を表示する
+// 一覧を取得できない場合(未ログインなど)は登録ボタンを表示しない
+func (h *PasskeyHandler) writePage(w http.ResponseWriter, r *http.Request, statusCode int, message string) {
+	sessionID := presentation.SessionIDFromCookie(r)
+	passkeys, err := h.list.Execute(sessionID)
+	if err != nil {
+		switch {
+		case errors.Is(err, account.ErrLoginRequired):
+			h.render(w, http.StatusUnauthorized, view.PasskeysPage{Message: "ログインしてください"})
+		default:
+			h.logger.Error("Unexpected error occurred", "err", err)
+			h.render(w, http.StatusInternalServerError, view.PasskeysPage{Message: "予期しないエラーが発生しました"})
+		}
+		return
+	}
+	page := view.PasskeysPage{CSRFToken: h.csrfProtector.Issue(sessionID), Message: message, Passkeys: make([]view.PasskeyItem, 0, len(passkeys))}
+	for _, p := range passkeys {
+		page.Passkeys = append(page.Passkeys, view.PasskeyItem{Name: p.Name(), CreatedAt: formatTime(p.CreatedAt()), LastUsedAt: formatTime(p.LastUsedAt()), Synced: p.Synced()})
+	}
+	h.render(w, statusCode, page)
+}
+
+func (h *PasskeyHandler) render(w http.ResponseWriter, statusCode int, page view.PasskeysPage) {
+	if err := h.renderer.Render(w, statusCode, view.PasskeysTemplate, page); err != nil {
+		h.logger.Error("Failed to render passkeys page", "err", err)
+	}
+}
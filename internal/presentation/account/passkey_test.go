@@ -0,0 +1,197 @@
+package account
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"oauth-tutorial/internal/presentation"
+	"oauth-tutorial/internal/session"
+	"oauth-tutorial/internal/usecase/account"
+	"oauth-tutorial/pkg/mylogger"
+	"strings"
+	"testing"
+	"time"
+)
+
+type mockListPasskeysUseCase struct {
+	passkeys []account.RegisteredPasskey
+	err      error
+}
+
+func (m *mockListPasskeysUseCase) Execute(sessionID session.SessionID) ([]account.RegisteredPasskey, error) {
+	return m.passkeys, m.err
+}
+
+type mockBeginPasskeyRegistrationUseCase struct {
+	err error
+}
+
+func (m *mockBeginPasskeyRegistrationUseCase) Execute(sessionID session.SessionID) (account.PasskeyRegistrationOptions, error) {
+	if m.err != nil {
+		return account.PasskeyRegistrationOptions{}, m.err
+	}
+	return account.NewPasskeyRegistrationOptions("Y2hhbGxlbmdl", "auth.example.com", "auth.example.com", []byte("user-1"), "user@example.com", [][]byte{[]byte("registered")}, []int64{-7, -257}, 5*time.Minute), nil
+}
+
+// 受け取った値を記録する
+type mockFinishPasskeyRegistrationUseCase struct {
+	clientDataJSON    string
+	attestationObject string
+	name              string
+	err               error
+}
+
+func (m *mockFinishPasskeyRegistrationUseCase) Execute(sessionID session.SessionID, clientDataJSON []byte, attestationObject []byte, name string) error {
+	m.clientDataJSON, m.attestationObject, m.name = string(clientDataJSON), string(attestationObject), name
+	return m.err
+}
+
+func newTestPasskeyHandler(t *testing.T, list *mockListPasskeysUseCase, begin *mockBeginPasskeyRegistrationUseCase, finish *mockFinishPasskeyRegistrationUseCase) *PasskeyHandler {
+	return NewPasskeyHandler(mylogger.NewMockLogger(), list, begin, finish, newTestRenderer(t), presentation.NewCSRFProtector([]byte("test-secret")))
+}
+
+func TestPasskeyHandler_ServePage(t *testing.T) {
+	tests := []struct {
+		name           string
+		mockUseCase    *mockListPasskeysUseCase
+		expectedStatus int
+		wantContains   []string
+	}{
+		{
+			name:           "正常ケース",
+			mockUseCase:    &mockListPasskeysUseCase{passkeys: []account.RegisteredPasskey{account.NewRegisteredPasskey("ノートPC", time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC), time.Time{}, false)}},
+			expectedStatus: http.StatusOK,
+			wantContains:   []string{"ノートPC", "2026-01-02T03:04:05Z", `action="passkeys"`, `value="` + testCSRFToken + `"`},
+		},
+		{name: "異常ケース - 未ログイン", mockUseCase: &mockListPasskeysUseCase{err: account.ErrLoginRequired}, expectedStatus: http.StatusUnauthorized, wantContains: []string{"ログインしてください"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// given
+			handler := newTestPasskeyHandler(t, tt.mockUseCase, &mockBeginPasskeyRegistrationUseCase{}, &mockFinishPasskeyRegistrationUseCase{})
+			req := httptest.NewRequest("GET", "/account/passkeys", nil)
+			req.AddCookie(&http.Cookie{Name: session.SessionIDCookieName, Value: "test-session-id"})
+			rr := httptest.NewRecorder()
+
+			// when
+			handler.ServePage(rr, req)
+
+			// then
+			if rr.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, rr.Code)
+			}
+			for _, want := range tt.wantContains {
+				if !strings.Contains(rr.Body.String(), want) {
+					t.Errorf("expected body to contain %q, got %s", want, rr.Body.String())
+				}
+			}
+		})
+	}
+}
+
+func TestPasskeyHandler_ServeOptions(t *testing.T) {
+	tests := []struct {
+		name           string
+		form           url.Values
+		header         map[string]string
+		mockUseCase    *mockBeginPasskeyRegistrationUseCase
+		expectedStatus int
+	}{
+		{name: "正常ケース", form: url.Values{"csrf_token": {testCSRFToken}}, mockUseCase: &mockBeginPasskeyRegistrationUseCase{}, expectedStatus: http.StatusOK},
+		{name: "異常ケース - 未ログイン", form: url.Values{"csrf_token": {testCSRFToken}}, mockUseCase: &mockBeginPasskeyRegistrationUseCase{err: account.ErrLoginRequired}, expectedStatus: http.StatusUnauthorized},
+		{name: "異常ケース - CSRFトークンが無い", form: url.Values{}, mockUseCase: &mockBeginPasskeyRegistrationUseCase{}, expectedStatus: http.StatusForbidden},
+		{name: "異常ケース - 他サイトからのリクエスト", form: url.Values{"csrf_token": {testCSRFToken}}, header: map[string]string{"Sec-Fetch-Site": "cross-site"}, mockUseCase: &mockBeginPasskeyRegistrationUseCase{}, expectedStatus: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// given
+			handler := newTestPasskeyHandler(t, &mockListPasskeysUseCase{}, tt.mockUseCase, &mockFinishPasskeyRegistrationUseCase{})
+			req := newTOTPFormRequest("/account/passkeys/options", tt.form)
+			for k, v := range tt.header {
+				req.Header.Set(k, v)
+			}
+			rr := httptest.NewRecorder()
+
+			// when
+			handler.ServeOptions(rr, req)
+
+			// then
+			if rr.Code != tt.expectedStatus {
+				t.Fatalf("expected status %d, got %d", tt.expectedStatus, rr.Code)
+			}
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+			var res PasskeyRegistrationOptionsResponse
+			if err := json.NewDecoder(rr.Body).Decode(&res); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			// バイナリの値はbase64urlでエンコードする
+			if res.Challenge != "Y2hhbGxlbmdl" || res.RP.ID != "auth.example.com" || res.User.ID != "dXNlci0x" || res.User.Name != "user@example.com" || res.Timeout != 300000 || res.Attestation != "none" {
+				t.Errorf("response = %+v", res)
+			}
+			if len(res.PubKeyCredParams) != 2 || res.PubKeyCredParams[0] != (PasskeyCredentialParameter{Type: "public-key", Alg: -7}) {
+				t.Errorf("pubKeyCredParams = %+v", res.PubKeyCredParams)
+			}
+			if len(res.ExcludeCredentials) != 1 || res.ExcludeCredentials[0].ID != "cmVnaXN0ZXJlZA" {
+				t.Errorf("excludeCredentials = %+v", res.ExcludeCredentials)
+			}
+			if !res.AuthenticatorSelection.RequireResidentKey || res.AuthenticatorSelection.ResidentKey != "required" || res.AuthenticatorSelection.UserVerification != "required" {
+				t.Errorf("authenticatorSelection = %+v", res.AuthenticatorSelection)
+			}
+		})
+	}
+}
+
+func TestPasskeyHandler_ServeRegister(t *testing.T) {
+	validForm := url.Values{"csrf_token": {testCSRFToken}, "client_data_json": {"e30"}, "attestation_object": {"b2JqZWN0"}, "name": {"ノートPC"}}
+	tests := []struct {
+		name             string
+		modify           func(form url.Values)
+		mockUseCase      *mockFinishPasskeyRegistrationUseCase
+		expectedStatus   int
+		expectedLocation string
+		wantContains     []string
+	}{
+		{name: "正常ケース - 一覧画面へ戻る", mockUseCase: &mockFinishPasskeyRegistrationUseCase{}, expectedStatus: http.StatusSeeOther, expectedLocation: "/account/passkeys"},
+		{name: "異常ケース - base64urlでない", modify: func(form url.Values) { form.Set("attestation_object", "b2Jq+ZWN0") }, mockUseCase: &mockFinishPasskeyRegistrationUseCase{}, expectedStatus: http.StatusBadRequest, wantContains: []string{"パラメータの形式を確認してください"}},
+		{name: "異常ケース - CSRFトークンが不正", modify: func(form url.Values) { form.Set("csrf_token", "invalid") }, mockUseCase: &mockFinishPasskeyRegistrationUseCase{}, expectedStatus: http.StatusForbidden, wantContains: []string{"不正なリクエストです"}},
+		{name: "異常ケース - 検証に失敗", mockUseCase: &mockFinishPasskeyRegistrationUseCase{err: account.ErrInvalidPasskey}, expectedStatus: http.StatusBadRequest, wantContains: []string{"パスキーを登録できませんでした", `value="` + testCSRFToken + `"`}},
+		{name: "異常ケース - 登録済み", mockUseCase: &mockFinishPasskeyRegistrationUseCase{err: account.ErrPasskeyAlreadyRegistered}, expectedStatus: http.StatusConflict, wantContains: []string{"このパスキーは登録済みです"}},
+		{name: "異常ケース - チャレンジの有効期限切れ", mockUseCase: &mockFinishPasskeyRegistrationUseCase{err: account.ErrPasskeyChallengeNotFound}, expectedStatus: http.StatusBadRequest, wantContains: []string{"登録の有効期限が切れました"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// given
+			handler := newTestPasskeyHandler(t, &mockListPasskeysUseCase{}, &mockBeginPasskeyRegistrationUseCase{}, tt.mockUseCase)
+			form := url.Values{}
+			for k, v := range validForm {
+				form[k] = v
+			}
+			if tt.modify != nil {
+				tt.modify(form)
+			}
+			rr := httptest.NewRecorder()
+
+			// when
+			handler.ServeRegister(rr, newTOTPFormRequest("/account/passkeys", form))
+
+			// then
+			if rr.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, rr.Code)
+			}
+			if rr.Header().Get("Location") != tt.expectedLocation {
+				t.Errorf("expected location %q, got %q", tt.expectedLocation, rr.Header().Get("Location"))
+			}
+			if tt.expectedStatus == http.StatusSeeOther && (tt.mockUseCase.clientDataJSON != "{}" || tt.mockUseCase.attestationObject != "object" || tt.mockUseCase.name != "ノートPC") {
+				t.Errorf("use case called with %+v", tt.mockUseCase)
+			}
+			for _, want := range tt.wantContains {
+				if !strings.Contains(rr.Body.String(), want) {
+					t.Errorf("expected body to contain %q, got %s", want, rr.Body.String())
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package account
+
+import (
+	"encoding/base64"
+	"oauth-tutorial/internal/usecase/account"
+	"time"
+)
+
+type ConnectedAppsResponse struct {
+	Apps []ConnectedAppResponse `json:"apps"`
+}
+
+type ConnectedAppResponse struct {
+	ClientID      string                 `json:"client_id"`
+	ClientName    string                 `json:"client_name"`
+	Scopes        []string               `json:"scopes"`
+	GrantedAt     string                 `json:"granted_at"`
+	ExpiresAt     string                 `json:"expires_at,omitempty"`
+	FirstUsedAt   string                 `json:"first_used_at,omitempty"`
+	LastUsedAt    string                 `json:"last_used_at,omitempty"`
+	RefreshTokens []RefreshTokenResponse `json:"refresh_tokens"`
+}
+
+type RefreshTokenResponse struct {
+	Scopes    []string `json:"scopes"`
+	IssuedAt  string   `json:"issued_at"`
+	ExpiresAt string   `json:"expires_at"`
+}
+
+// navigator.credentials.createのpublicKeyに渡すオプション WebAuthn Level 2 5.4
+// バイナリの値はbase64urlでエンコードし、画面のスクリプトでデコードする
+type PasskeyRegistrationOptionsResponse struct {
+	Challenge              string                        `json:"challenge"`
+	RP                     PasskeyRPResponse             `json:"rp"`
+	User                   PasskeyUserResponse           `json:"user"`
+	PubKeyCredParams       []PasskeyCredentialParameter  `json:"pubKeyCredParams"`
+	Timeout                int64                         `json:"timeout"`
+	ExcludeCredentials     []PasskeyCredentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection PasskeyAuthenticatorSelection `json:"authenticatorSelection"`
+	Attestation            string                        `json:"attestation"`
+}
+
+type PasskeyRPResponse struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type PasskeyUserResponse struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+type PasskeyCredentialParameter struct {
+	Type string `json:"type"`
+	Alg  int64  `json:"alg"`
+}
+
+type PasskeyCredentialDescriptor struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+type PasskeyAuthenticatorSelection struct {
+	ResidentKey        string `json:"residentKey"`
+	RequireResidentKey bool   `json:"requireResidentKey"`
+	UserVerification   string `json:"userVerification"`
+}
+
+type ErrorResponse struct {
+	Message string `json:"message"`
+}
+
+func NewConnectedAppsResponse(apps []account.ConnectedApp) ConnectedAppsResponse {
+	res := ConnectedAppsResponse{Apps: make([]ConnectedAppResponse, 0, len(apps))}
+	for _, app := range apps {
+		refreshTokens := make([]RefreshTokenResponse, 0, len(app.RefreshTokens()))
+		for _, rt := range app.RefreshTokens() {
+			refreshTokens = append(refreshTokens, RefreshTokenResponse{
+				Scopes:    rt.Scopes(),
+				IssuedAt:  formatTime(rt.IssuedAt()),
+				ExpiresAt: formatTime(rt.ExpiresAt()),
+			})
+		}
+		res.Apps = append(res.Apps, ConnectedAppResponse{
+			ClientID:      app.ClientID(),
+			ClientName:    app.ClientName(),
+			Scopes:        app.Scopes(),
+			GrantedAt:     formatTime(app.GrantedAt()),
+			ExpiresAt:     formatTime(app.ExpiresAt()),
+			FirstUsedAt:   formatTime(app.FirstUsedAt()),
+			LastUsedAt:    formatTime(app.LastUsedAt()),
+			RefreshTokens: refreshTokens,
+		})
+	}
+	return res
+}
+
+// ゼロ値の日時は未設定として空文字にする
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+// ログインIDを入力せずにログインできるよう、発見可能なクレデンシャル(residentKey)を必須にする
+// ログインと同様に本人の確認を必須にし、アテステーションは検証しないため、認証器にはnoneを求める
+func NewPasskeyRegistrationOptionsResponse(options account.PasskeyRegistrationOptions) PasskeyRegistrationOptionsResponse {
+	params := make([]PasskeyCredentialParameter, 0, len(options.Algorithms()))
+	for _, alg := range options.Algorithms() {
+		params = append(params, PasskeyCredentialParameter{Type: "public-key", Alg: alg})
+	}
+	exclude := make([]PasskeyCredentialDescriptor, 0, len(options.ExcludeCredentialIDs()))
+	for _, id := range options.ExcludeCredentialIDs() {
+		exclude = append(exclude, PasskeyCredentialDescriptor{Type: "public-key", ID: base64.RawURLEncoding.EncodeToString(id)})
+	}
+	return PasskeyRegistrationOptionsResponse{
+		Challenge: options.Challenge(),
+		RP:        PasskeyRPResponse{ID: options.RPID(), Name: options.RPName()},
+		User: PasskeyUserResponse{
+			ID:          base64.RawURLEncoding.EncodeToString(options.UserHandle()),
+			Name:        options.UserName(),
+			DisplayName: options.UserName(),
+		},
+		PubKeyCredParams:       params,
+		Timeout:                options.Timeout().Milliseconds(),
+		ExcludeCredentials:     exclude,
+		AuthenticatorSelection: PasskeyAuthenticatorSelection{ResidentKey: "required", RequireResidentKey: true, UserVerification: "required"},
+		Attestation:            "none",
+	}
+}
@@ -0,0 +1,118 @@
+package account
+
+import (
+	"errors"
+	"html/template"
+	"net/http"
+	"oauth-tutorial/internal/presentation"
+	"oauth-tutorial/internal/presentation/view"
+	"oauth-tutorial/internal/usecase/account"
+	"oauth-tutorial/pkg/mylogger"
+)
+
+// ログイン済みのユーザーがTOTP(認証アプリによる2段階認証)を登録するためのハンドラー
+// 登録の開始と確認はセッションの状態を変更するため、同一オリジンの確認とCSRFトークンの検証を行う
+type TOTPHandler struct {
+	logger        mylogger.Logger
+	status        IGetTOTPStatusUseCase
+	start         IStartTOTPEnrollmentUseCase
+	confirm       IConfirmTOTPEnrollmentUseCase
+	renderer      IRenderer
+	csrfProtector ICSRFProtector
+}
+
+func NewTOTPHandler(logger mylogger.Logger, status IGetTOTPStatusUseCase, start IStartTOTPEnrollmentUseCase, confirm IConfirmTOTPEnrollmentUseCase, renderer IRenderer, csrfProtector ICSRFProtector) *TOTPHandler {
+	return &TOTPHandler{logger: logger, status: status, start: start, confirm: confirm, renderer: renderer, csrfProtector: csrfProtector}
+}
+
+// GET /account/totp: 登録状況と登録を始めるボタンの画面
+func (h *TOTPHandler) ServePage(w http.ResponseWriter, r *http.Request) {
+	sessionID := presentation.SessionIDFromCookie(r)
+	status, err := h.status.Execute(sessionID)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+	h.writePage(w, http.StatusOK, view.TOTPPage{
+		CSRFToken:              h.csrfProtector.Issue(sessionID),
+		Enabled:                status.Enabled(),
+		RemainingRecoveryCodes: status.RemainingRecoveryCodes(),
+	})
+}
+
+// POST /account/totp: シークレットを生成し、認証アプリに登録するためのURIと最初のコードの入力欄を表示する
+func (h *TOTPHandler) ServeStart(w http.ResponseWriter, r *http.Request) {
+	if !h.verifyRequest(w, r) {
+		return
+	}
+	sessionID := presentation.SessionIDFromCookie(r)
+	enrollment, err := h.start.Execute(sessionID)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+	h.writePage(w, http.StatusOK, view.TOTPPage{
+		CSRFToken: h.csrfProtector.Issue(sessionID),
+		// URIはサーバーで組み立てた値のため、otpauthスキームのリンクとして出力してよい
+		ProvisioningURI: template.URL(enrollment.ProvisioningURI()),
+		Secret:          enrollment.Secret(),
+	})
+}
+
+// POST /account/totp/confirm: 最初のコードを確認して有効にし、リカバリーコードを表示する
+func (h *TOTPHandler) ServeConfirm(w http.ResponseWriter, r *http.Request) {
+	if !h.verifyRequest(w, r) {
+		return
+	}
+	recoveryCodes, err := h.confirm.Execute(presentation.SessionIDFromCookie(r), r.PostForm.Get("otp"))
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+	h.writePage(w, http.StatusOK, view.TOTPPage{Enabled: true, RecoveryCodes: recoveryCodes})
+}
+
+// 他サイトからのリクエストとCSRFトークンを検証する。拒否した場合はエラー画面を表示してfalseを返す
+func (h *TOTPHandler) verifyRequest(w http.ResponseWriter, r *http.Request) bool {
+	if err := presentation.CheckSameOrigin(r); err != nil {
+		mylogger.SecurityEvent(h.logger, "cross_site_request_rejected", "path", r.URL.Path, "origin", r.Header.Get("Origin"), "secFetchSite", r.Header.Get("Sec-Fetch-Site"))
+		h.writePage(w, http.StatusForbidden, view.TOTPPage{Message: "不正なリクエストです"})
+		return false
+	}
+	if err := r.ParseForm(); err != nil {
+		h.logger.Info("Failed to parse form", "err", err)
+		h.writePage(w, http.StatusBadRequest, view.TOTPPage{Message: "パラメータの形式を確認してください"})
+		return false
+	}
+	if !h.csrfProtector.Verify(presentation.SessionIDFromCookie(r), r.PostForm.Get(presentation.CSRFTokenFieldName)) {
+		mylogger.SecurityEvent(h.logger, "csrf_token_invalid", "path", r.URL.Path)
+		h.writePage(w, http.StatusForbidden, view.TOTPPage{Message: "不正なリクエストです"})
+		return false
+	}
+	return true
+}
+
+// 登録からやり直せるよう、登録を始めるボタンのCSRFトークンを発行する
+func (h *TOTPHandler) writeError(w http.ResponseWriter, r *http.Request, err error) {
+	csrfToken := h.csrfProtector.Issue(presentation.SessionIDFromCookie(r))
+	switch {
+	case errors.Is(err, account.ErrLoginRequired):
+		h.writePage(w, http.StatusUnauthorized, view.TOTPPage{Message: "ログインしてください"})
+	case errors.Is(err, account.ErrTOTPAlreadyEnabled):
+		h.writePage(w, http.StatusConflict, view.TOTPPage{Message: "2段階認証は既に有効です", Enabled: true})
+	case errors.Is(err, account.ErrTOTPEnrollmentNotStarted):
+		h.writePage(w, http.StatusBadRequest, view.TOTPPage{CSRFToken: csrfToken, Message: "認証アプリの登録からやり直してください"})
+	case errors.Is(err, account.ErrInvalidTOTPCode):
+		// シークレットは画面に再表示しないため、新しいシークレットで登録からやり直させる
+		h.writePage(w, http.StatusBadRequest, view.TOTPPage{CSRFToken: csrfToken, Message: "コードが正しくありません。認証アプリの登録からやり直してください"})
+	default:
+		h.logger.Error("Unexpected error occurred", "err", err)
+		h.writePage(w, http.StatusInternalServerError, view.TOTPPage{Message: "予期しないエラーが発生しました"})
+	}
+}
+
+func (h *TOTPHandler) writePage(w http.ResponseWriter, statusCode int, page view.TOTPPage) {
+	if err := h.renderer.Render(w, statusCode, view.TOTPTemplate, page); err != nil {
+		h.logger.Error("Failed to render totp page", "err", err)
+	}
+}
@@ -0,0 +1,204 @@
+package account
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"oauth-tutorial/internal/presentation"
+	"oauth-tutorial/internal/session"
+	"oauth-tutorial/internal/usecase/account"
+	"oauth-tutorial/pkg/mylogger"
+	"strings"
+	"testing"
+)
+
+type mockGetTOTPStatusUseCase struct {
+	status account.TOTPStatus
+	err    error
+}
+
+func (m *mockGetTOTPStatusUseCase) Execute(sessionID session.SessionID) (account.TOTPStatus, error) {
+	return m.status, m.err
+}
+
+type mockStartTOTPEnrollmentUseCase struct {
+	called bool
+	err    error
+}
+
+func (m *mockStartTOTPEnrollmentUseCase) Execute(sessionID session.SessionID) (account.TOTPEnrollment, error) {
+	m.called = true
+	if m.err != nil {
+		return account.TOTPEnrollment{}, m.err
+	}
+	return account.NewTOTPEnrollment("otpauth://totp/auth.example.com:user?secret=ABCDEFGH", "ABCDEFGH"), nil
+}
+
+type mockConfirmTOTPEnrollmentUseCase struct {
+	code string
+	err  error
+}
+
+func (m *mockConfirmTOTPEnrollmentUseCase) Execute(sessionID session.SessionID, code string) ([]string, error) {
+	m.code = code
+	if m.err != nil {
+		return nil, m.err
+	}
+	return []string{"AAAA-BBBB", "CCCC-DDDD"}, nil
+}
+
+// テスト用のセッション(test-session-id)に紐づくCSRFトークン
+var testCSRFToken = presentation.NewCSRFProtector([]byte("test-secret")).Issue("test-session-id")
+
+func newTestTOTPHandler(t *testing.T, status *mockGetTOTPStatusUseCase, start *mockStartTOTPEnrollmentUseCase, confirm *mockConfirmTOTPEnrollmentUseCase) *TOTPHandler {
+	return NewTOTPHandler(mylogger.NewMockLogger(), status, start, confirm, newTestRenderer(t), presentation.NewCSRFProtector([]byte("test-secret")))
+}
+
+func newTOTPFormRequest(path string, form url.Values) *http.Request {
+	req := httptest.NewRequest("POST", path, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(&http.Cookie{Name: session.SessionIDCookieName, Value: "test-session-id"})
+	return req
+}
+
+func TestTOTPHandler_ServePage(t *testing.T) {
+	tests := []struct {
+		name           string
+		mockUseCase    *mockGetTOTPStatusUseCase
+		expectedStatus int
+		wantContains   []string
+	}{
+		{name: "正常ケース - 未登録", mockUseCase: &mockGetTOTPStatusUseCase{}, expectedStatus: http.StatusOK, wantContains: []string{`action="totp"`, `value="` + testCSRFToken + `"`}},
+		{name: "正常ケース - 登録済み", mockUseCase: &mockGetTOTPStatusUseCase{status: account.NewTOTPStatus(true, 8)}, expectedStatus: http.StatusOK, wantContains: []string{"2段階認証は有効です", "8個"}},
+		{name: "異常ケース - 未ログイン", mockUseCase: &mockGetTOTPStatusUseCase{err: account.ErrLoginRequired}, expectedStatus: http.StatusUnauthorized, wantContains: []string{"ログインしてください"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// given
+			handler := newTestTOTPHandler(t, tt.mockUseCase, &mockStartTOTPEnrollmentUseCase{}, &mockConfirmTOTPEnrollmentUseCase{})
+			req := httptest.NewRequest("GET", "/account/totp", nil)
+			req.AddCookie(&http.Cookie{Name: session.SessionIDCookieName, Value: "test-session-id"})
+			rr := httptest.NewRecorder()
+
+			// when
+			handler.ServePage(rr, req)
+
+			// then
+			if rr.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, rr.Code)
+			}
+			for _, want := range tt.wantContains {
+				if !strings.Contains(rr.Body.String(), want) {
+					t.Errorf("expected body to contain %q, got %s", want, rr.Body.String())
+				}
+			}
+		})
+	}
+}
+
+func TestTOTPHandler_ServeStart(t *testing.T) {
+	tests := []struct {
+		name           string
+		form           url.Values
+		header         map[string]string
+		mockUseCase    *mockStartTOTPEnrollmentUseCase
+		expectedStatus int
+		expectCalled   bool
+		wantContains   []string
+	}{
+		{
+			name:           "正常ケース",
+			form:           url.Values{"csrf_token": {testCSRFToken}},
+			mockUseCase:    &mockStartTOTPEnrollmentUseCase{},
+			expectedStatus: http.StatusOK,
+			expectCalled:   true,
+			wantContains:   []string{`href="otpauth://totp/auth.example.com:user?secret=ABCDEFGH"`, `action="totp/confirm"`, "ABCDEFGH"},
+		},
+		{
+			name:           "異常ケース - 登録済み",
+			form:           url.Values{"csrf_token": {testCSRFToken}},
+			mockUseCase:    &mockStartTOTPEnrollmentUseCase{err: account.ErrTOTPAlreadyEnabled},
+			expectedStatus: http.StatusConflict,
+			expectCalled:   true,
+			wantContains:   []string{"2段階認証は既に有効です"},
+		},
+		{
+			name:           "異常ケース - CSRFトークンが無い",
+			form:           url.Values{},
+			mockUseCase:    &mockStartTOTPEnrollmentUseCase{},
+			expectedStatus: http.StatusForbidden,
+			wantContains:   []string{"不正なリクエストです"},
+		},
+		{
+			name:           "異常ケース - 他サイトからのリクエスト",
+			form:           url.Values{"csrf_token": {testCSRFToken}},
+			header:         map[string]string{"Sec-Fetch-Site": "cross-site"},
+			mockUseCase:    &mockStartTOTPEnrollmentUseCase{},
+			expectedStatus: http.StatusForbidden,
+			wantContains:   []string{"不正なリクエストです"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// given
+			handler := newTestTOTPHandler(t, &mockGetTOTPStatusUseCase{}, tt.mockUseCase, &mockConfirmTOTPEnrollmentUseCase{})
+			req := newTOTPFormRequest("/account/totp", tt.form)
+			for k, v := range tt.header {
+				req.Header.Set(k, v)
+			}
+			rr := httptest.NewRecorder()
+
+			// when
+			handler.ServeStart(rr, req)
+
+			// then
+			if rr.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, rr.Code)
+			}
+			if tt.mockUseCase.called != tt.expectCalled {
+				t.Errorf("use case called = %v, want %v", tt.mockUseCase.called, tt.expectCalled)
+			}
+			for _, want := range tt.wantContains {
+				if !strings.Contains(rr.Body.String(), want) {
+					t.Errorf("expected body to contain %q, got %s", want, rr.Body.String())
+				}
+			}
+		})
+	}
+}
+
+func TestTOTPHandler_ServeConfirm(t *testing.T) {
+	tests := []struct {
+		name           string
+		mockUseCase    *mockConfirmTOTPEnrollmentUseCase
+		expectedStatus int
+		wantContains   []string
+	}{
+		{name: "正常ケース - リカバリーコードを表示する", mockUseCase: &mockConfirmTOTPEnrollmentUseCase{}, expectedStatus: http.StatusOK, wantContains: []string{"<code>AAAA-BBBB</code>", "<code>CCCC-DDDD</code>"}},
+		{name: "異常ケース - コードが誤っている", mockUseCase: &mockConfirmTOTPEnrollmentUseCase{err: account.ErrInvalidTOTPCode}, expectedStatus: http.StatusBadRequest, wantContains: []string{"コードが正しくありません", `action="totp"`, `value="` + testCSRFToken + `"`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// given
+			handler := newTestTOTPHandler(t, &mockGetTOTPStatusUseCase{}, &mockStartTOTPEnrollmentUseCase{}, tt.mockUseCase)
+			req := newTOTPFormRequest("/account/totp/confirm", url.Values{"csrf_token": {testCSRFToken}, "otp": {"123456"}})
+			rr := httptest.NewRecorder()
+
+			// when
+			handler.ServeConfirm(rr, req)
+
+			// then
+			if rr.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, rr.Code)
+			}
+			if tt.mockUseCase.code != "123456" {
+				t.Errorf("use case called with code %q, want 123456", tt.mockUseCase.code)
+			}
+			for _, want := range tt.wantContains {
+				if !strings.Contains(rr.Body.String(), want) {
+					t.Errorf("expected body to contain %q, got %s", want, rr.Body.String())
+				}
+			}
+		})
+	}
+}
@@ -1,10 +1,21 @@
 package authorize
 
 import (
+	"net/http"
 	"oauth-tutorial/internal/domain"
 	"oauth-tutorial/internal/session"
+	uAuthorize "oauth-tutorial/internal/usecase/authorize"
 )
 
 type IAuthorizationFlow interface {
-	Execute(param *domain.AuthorizationCodeFlowParam) (session.SessionID, error)
+	VerifyRedirectURI(clientID string, redirectURI string) error
+	Execute(param *domain.AuthorizationCodeFlowParam, sessionID session.SessionID) (uAuthorize.AuthorizationCodeFlowOutput, error)
+}
+
+type IRenderer interface {
+	Render(w http.ResponseWriter, statusCode int, name string, data any) error
+}
+
+type ICSRFTokenIssuer interface {
+	Issue(sessionID session.SessionID) string
 }
@@ -3,9 +3,10 @@ package authorize
 import (
 	"errors"
 	"net/http"
+	"net/url"
 	"oauth-tutorial/internal/domain"
 	"oauth-tutorial/internal/presentation"
-	"oauth-tutorial/internal/session"
+	"oauth-tutorial/internal/presentation/view"
 	uAuthorize "oauth-tutorial/internal/usecase/authorize"
 	"oauth-tutorial/pkg/mylogger"
 )
@@ -14,12 +15,23 @@ type AuthorizeHandler struct {
 	logger mylogger.Logger
 	// TODO: response_typeによってflowを変える。(認可コードフロー以外にも対応)(factory patternを検討)
 	authorizationFlow IAuthorizationFlow
+	renderer          IRenderer
+	csrfTokenIssuer   ICSRFTokenIssuer
+	// 認可レスポンスに付与するiss RFC 9207
+	issuer string
+	// レルムのスコープの一覧
+	scopes *domain.ScopeProvider
+	// ログイン画面に表示する上流のIdP
+	loginProviders []view.LoginProvider
 }
 
-func NewAuthorizeHandler(logger mylogger.Logger, clientGetter IAuthorizationFlow) *AuthorizeHandler {
-	return &AuthorizeHandler{logger: logger, authorizationFlow: clientGetter}
+func NewAuthorizeHandler(logger mylogger.Logger, clientGetter IAuthorizationFlow, renderer IRenderer, csrfTokenIssuer ICSRFTokenIssuer, issuer string, scopes *domain.ScopeProvider, loginProviders []view.LoginProvider) *AuthorizeHandler {
+	return &AuthorizeHandler{logger: logger, authorizationFlow: clientGetter, renderer: renderer, csrfTokenIssuer: csrfTokenIssuer, issuer: issuer, scopes: scopes, loginProviders: loginProviders}
 }
 
+// 複数指定してはならないパラメータ RFC 6749 3.1
+var singleValueParams = []string{"response_type", "client_id", "redirect_uri", "scope", "state"}
+
 func (h *AuthorizeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	queries := r.URL.Query()
 
@@ -30,49 +42,131 @@ func (h *AuthorizeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	state := queries.Get("state")
 	scope := queries.Get("scope")
 
-	param, err := domain.NewAuthorizationCodeFlowParam(h.logger, responseType, clientID, redirectURI, scope, state)
+	// client_idとredirect_uriを検証するまでは、リダイレクトせずにエラー画面を表示する RFC 6749 4.1.2.1
+	switch {
+	case clientID == "" || len(queries["client_id"]) > 1:
+		h.renderError(w, http.StatusBadRequest, "client_idが不正です。")
+		return
+	case redirectURI == "" || len(queries["redirect_uri"]) > 1:
+		h.renderError(w, http.StatusBadRequest, "redirect_uriが不正です。")
+		return
+	}
+	if err := h.authorizationFlow.VerifyRedirectURI(clientID, redirectURI); err != nil {
+		switch {
+		case errors.Is(err, uAuthorize.ErrClientNotFound):
+			h.renderError(w, http.StatusBadRequest, "クライアントが見つかりません。")
+		case errors.Is(err, uAuthorize.ErrInvalidRedirectURI):
+			h.renderError(w, http.StatusBadRequest, "redirect_uriが登録されていません。")
+		default:
+			h.logger.Error("Failed to verify redirect URI", "error", err)
+			h.renderError(w, http.StatusInternalServerError, "サーバーエラーが発生しました。")
+		}
+		return
+	}
+
+	// 以降のエラーはリダイレクトURIに返す
+	redirectError := func(code string, description string) {
+		h.redirectError(w, r, redirectURI, ErrorResponse{Error: code, ErrorDescription: description, State: state})
+	}
+	for _, name := range singleValueParams {
+		if len(queries[name]) > 1 {
+			redirectError(ErrInvalidRequest, name+" must not be included more than once")
+			return
+		}
+	}
+	if responseType == "" {
+		redirectError(ErrInvalidRequest, "response_type is required")
+		return
+	}
+
+	param, err := domain.NewAuthorizationCodeFlowParam(h.logger, h.scopes.Current(), responseType, clientID, redirectURI, scope, state)
 	if err != nil {
 		var unsupportedErr *domain.UnsupportedResponseTypeError
+		var scopeErr *domain.InvalidScopeError
 		switch {
 		case errors.As(err, &unsupportedErr):
-			presentation.WriteJSONResponse(w, http.StatusBadRequest, ErrorResponse{Error: ErrUnsupportedResponseType, ErrorDescription: unsupportedErr.Error(), State: state})
+			redirectError(ErrUnsupportedResponseType, unsupportedErr.Error())
+		case errors.As(err, &scopeErr):
+			redirectError(ErrInvalidScope, scopeErr.Error())
 		default:
-			presentation.WriteJSONResponse(w, http.StatusBadRequest, ErrorResponse{Error: ErrInvalidRequest, ErrorDescription: err.Error(), State: state})
-			return
+			redirectError(ErrInvalidRequest, err.Error())
 		}
+		return
 	}
 
-	sessionID, err := h.authorizationFlow.Execute(param)
+	output, err := h.authorizationFlow.Execute(param, presentation.SessionIDFromCookie(r))
 	if err != nil {
+		var scopeErr *domain.InvalidScopeError
 		switch {
-		case errors.Is(err, uAuthorize.ErrClientNotFound):
-			h.logger.Info("Client not found", "clientID", clientID)
-			presentation.WriteJSONResponse(w, http.StatusBadRequest, ErrorResponse{Error: ErrInvalidRequest, ErrorDescription: err.Error(), State: state})
-		case errors.Is(err, uAuthorize.ErrInvalidRedirectURI):
-			h.logger.Info("Invalid redirect URI", "redirectURI", redirectURI)
-			presentation.WriteJSONResponse(w, http.StatusBadRequest, ErrorResponse{Error: ErrInvalidRequest, ErrorDescription: err.Error(), State: state})
-		case errors.Is(err, uAuthorize.ErrUnExpected):
-			h.logger.Error("Unexpected error occurred", "error", err)
-			presentation.WriteJSONResponse(w, http.StatusInternalServerError, ErrorResponse{Error: ErrServerError, ErrorDescription: err.Error(), State: state})
-		case errors.Is(err, uAuthorize.ErrServer):
-			h.logger.Error("Server error occurred", "error", err)
-			presentation.WriteJSONResponse(w, http.StatusInternalServerError, ErrorResponse{Error: ErrServerError, ErrorDescription: err.Error(), State: state})
+		case errors.Is(err, uAuthorize.ErrClientNotFound), errors.Is(err, uAuthorize.ErrInvalidRedirectURI):
+			// 検証の後に設定の再読み込みでクライアントが変更された場合
+			h.logger.Info("Client or redirect URI is no longer valid", "clientID", clientID, "redirectURI", redirectURI)
+			h.renderError(w, http.StatusBadRequest, "クライアントまたはredirect_uriが不正です。")
+		case errors.Is(err, uAuthorize.ErrUnauthorizedClient):
+			redirectError(ErrUnauthorized, err.Error())
+		case errors.As(err, &scopeErr):
+			redirectError(ErrInvalidScope, scopeErr.Error())
+		case errors.Is(err, uAuthorize.ErrInvalidScope):
+			redirectError(ErrInvalidScope, err.Error())
 		default:
-			h.logger.Error("Unexpected error occurred while getting client", "error", err)
-			presentation.WriteJSONResponse(w, http.StatusInternalServerError, ErrorResponse{ErrorDescription: err.Error(), State: state})
+			h.logger.Error("Unexpected error occurred", "error", err)
+			redirectError(ErrServerError, "server error occurred")
 		}
 		return
 	}
 
 	h.logger.Info("Client authorized successfully")
 
-	// 本来は認証画面を表示するが、ここではOKのレスポンスを返すだけとする
-	http.SetCookie(w, &http.Cookie{
-		Name:     session.SessionIDCookieName,
-		Value:    string(sessionID),
-		Path:     "/",
-		HttpOnly: true,
-		Secure:   true,
+	presentation.SetSessionCookie(w, r, output.SessionID())
+
+	// ログイン済みかつ同意済みの場合は、認可コードを付与してリダイレクトする
+	if output.Prompt() == uAuthorize.PromptNone {
+		query := url.Values{"code": {output.AuthorizationCode()}, "state": {output.State()}, "iss": {h.issuer}}
+		http.Redirect(w, r, presentation.AppendQuery(output.BaseRedirectUri(), query), http.StatusFound)
+		return
+	}
+
+	// /decisionへの送信に必要なCSRFトークンをセッションに紐づけて発行する
+	csrfToken := h.csrfTokenIssuer.Issue(output.SessionID())
+
+	// ブラウザにはログイン画面または同意画面を表示する
+	if presentation.WantsHTML(r) {
+		h.renderPrompt(w, output, csrfToken)
+		return
+	}
+
+	// ブラウザ以外のクライアントには、トランザクションIDと次に必要な操作を返す
+	presentation.WriteJSONResponse(w, http.StatusOK, SuccessResponse{
+		Message:       "OK",
+		TransactionID: string(output.TransactionID()),
+		Prompt:        output.Prompt().String(),
+		CSRFToken:     csrfToken,
 	})
-	presentation.WriteJSONResponse(w, http.StatusOK, SuccessResponse{Message: "OK"})
+}
+
+func (h *AuthorizeHandler) renderPrompt(w http.ResponseWriter, output uAuthorize.AuthorizationCodeFlowOutput, csrfToken string) {
+	var err error
+	switch output.Prompt() {
+	case uAuthorize.PromptConsent:
+		err = h.renderer.Render(w, http.StatusOK, view.ConsentTemplate, view.NewConsentPage(string(output.TransactionID()), csrfToken, output.ClientName(), output.Scopes(), h.scopes.Current()))
+	default:
+		err = h.renderer.Render(w, http.StatusOK, view.LoginTemplate, view.LoginPage{TransactionID: string(output.TransactionID()), CSRFToken: csrfToken, Providers: h.loginProviders})
+	}
+	if err != nil {
+		h.logger.Error("Failed to render page", "err", err)
+	}
+}
+
+// 検証済みのリダイレクトURIにエラーを返す
+func (h *AuthorizeHandler) redirectError(w http.ResponseWriter, r *http.Request, redirectURI string, response ErrorResponse) {
+	h.logger.Info("Authorization request failed", "error", response.Error, "description", response.ErrorDescription)
+	response.Iss = h.issuer
+	http.Redirect(w, r, presentation.AppendQuery(redirectURI, response.Query()), http.StatusFound)
+}
+
+// リダイレクトURIを信頼できない場合のエラー画面
+func (h *AuthorizeHandler) renderError(w http.ResponseWriter, statusCode int, message string) {
+	if err := h.renderer.Render(w, statusCode, view.ErrorTemplate, view.ErrorPage{Message: message}); err != nil {
+		h.logger.Error("Failed to render error page", "err", err)
+	}
 }
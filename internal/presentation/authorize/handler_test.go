@@ -3,164 +3,177 @@ package authorize
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"oauth-tutorial/internal/domain"
+	"oauth-tutorial/internal/presentation"
+	"oauth-tutorial/internal/presentation/view"
 	"oauth-tutorial/internal/session"
 	usecase "oauth-tutorial/internal/usecase/authorize"
 	"oauth-tutorial/pkg/mylogger"
+	"reflect"
+	"strings"
 	"testing"
 )
 
 type MockAuthorizationFlow struct {
-	err error
+	output    usecase.AuthorizationCodeFlowOutput
+	verifyErr error
+	err       error
 }
 
 func NewMockAuthorizationFlow(err error) *MockAuthorizationFlow {
 	return &MockAuthorizationFlow{
-		err: err,
+		output: usecase.NewAuthorizationCodeFlowOutput("test-session-id", "test-transaction-id", usecase.PromptLogin, "client-1", []string{"read"}),
+		err:    err,
 	}
 }
 
-func (m *MockAuthorizationFlow) Execute(param *domain.AuthorizationCodeFlowParam) (session.SessionID, error) {
-	return "test-session-id", m.err
+func (m *MockAuthorizationFlow) VerifyRedirectURI(clientID string, redirectURI string) error {
+	return m.verifyErr
 }
 
+func (m *MockAuthorizationFlow) Execute(param *domain.AuthorizationCodeFlowParam, sessionID session.SessionID) (usecase.AuthorizationCodeFlowOutput, error) {
+	if m.err != nil {
+		return usecase.AuthorizationCodeFlowOutput{}, m.err
+	}
+	return m.output, nil
+}
+
+const testIssuer = "https://auth.example.com"
+
 func TestAuthorizeHandler_ServeHTTP(t *testing.T) {
+	validQuery := func(overrides map[string]string) string {
+		params := url.Values{
+			"response_type": {"code"},
+			"client_id":     {"test-client"},
+			"redirect_uri":  {"https://example.com/callback"},
+			"scope":         {"read write"},
+			"state":         {"test-state"},
+		}
+		for key, value := range overrides {
+			if value == "" {
+				params.Del(key)
+				continue
+			}
+			params.Set(key, value)
+		}
+		return params.Encode()
+	}
+
 	tests := []struct {
 		name           string
-		queryParams    map[string]string
+		rawQuery       string
+		verifyErr      error
 		mockErr        error
 		wantStatusCode int
-		wantHeader     map[string]string
-		wantResponse   any
+		// リダイレクトする場合のリダイレクト先とクエリ
+		wantRedirectURI string
+		wantQuery       url.Values
+		// エラー画面を表示する場合の本文
+		wantBodyContains string
 	}{
 		{
-			name: "正常ケース - 認可成功",
-			queryParams: map[string]string{
-				"response_type": "code",
-				"client_id":     "test-client",
-				"redirect_uri":  "https://example.com/callback",
-				"scope":         "read write",
-				"state":         "test-state",
-			},
-			mockErr:        nil,
-			wantStatusCode: http.StatusOK,
-			wantHeader: map[string]string{
-				"Set-Cookie":   session.SessionIDCookieName + "=test-session-id; Path=/; HttpOnly; Secure",
-				"Content-Type": "application/json",
-			},
-			wantResponse: SuccessResponse{
-				Message: "OK",
-			},
+			name:            "不正なresponse_typeはリダイレクトURIに返す",
+			rawQuery:        validQuery(map[string]string{"response_type": "token"}),
+			wantStatusCode:  http.StatusFound,
+			wantRedirectURI: "https://example.com/callback",
+			wantQuery:       url.Values{"error": {ErrUnsupportedResponseType}, "error_description": {"unsupported response_type: token"}, "state": {"test-state"}, "iss": {testIssuer}},
 		},
 		{
-			name: "異常ケース - 不正なリクエストパラメーター",
-			queryParams: map[string]string{
-				"response_type": "invalid",
-				"client_id":     "test-client",
-				"redirect_uri":  "https://example.com/callback",
-				"scope":         "read write",
-				"state":         "test-state",
-			},
-			mockErr:        nil,
-			wantStatusCode: http.StatusBadRequest,
-			wantHeader:     map[string]string{"Content-Type": "application/json"},
-			wantResponse: ErrorResponse{
-				Error:            ErrUnsupportedResponseType,
-				ErrorDescription: "unsupported response_type: invalid",
-				State:            "test-state",
-			},
+			name:            "response_typeの省略はinvalid_request",
+			rawQuery:        validQuery(map[string]string{"response_type": ""}),
+			wantStatusCode:  http.StatusFound,
+			wantRedirectURI: "https://example.com/callback",
+			wantQuery:       url.Values{"error": {ErrInvalidRequest}, "error_description": {"response_type is required"}, "state": {"test-state"}, "iss": {testIssuer}},
 		},
 		{
-			name: "異常ケース - クライアントが見つからない",
-			queryParams: map[string]string{
-				"response_type": "code",
-				"client_id":     "nonexistent-client",
-				"redirect_uri":  "https://example.com/callback",
-				"scope":         "read write",
-				"state":         "test-state",
-			},
-			mockErr:        usecase.ErrClientNotFound,
-			wantStatusCode: http.StatusBadRequest,
-			wantHeader:     map[string]string{"Content-Type": "application/json"},
-			wantResponse: ErrorResponse{
-				Error:            ErrInvalidRequest,
-				ErrorDescription: "client not found",
-				State:            "test-state",
-			},
+			name:            "未定義のスコープはinvalid_scope",
+			rawQuery:        validQuery(map[string]string{"scope": "read admin"}),
+			wantStatusCode:  http.StatusFound,
+			wantRedirectURI: "https://example.com/callback",
+			wantQuery:       url.Values{"error": {ErrInvalidScope}, "error_description": {`unknown scope: "admin"`}, "state": {"test-state"}, "iss": {testIssuer}},
 		},
 		{
-			name: "異常ケース - 無効なリダイレクトURI",
-			queryParams: map[string]string{
-				"response_type": "code",
-				"client_id":     "test-client",
-				"redirect_uri":  "https://malicious.com/callback",
-				"scope":         "read write",
-				"state":         "test-state",
-			},
-			mockErr:        usecase.ErrInvalidRedirectURI,
-			wantStatusCode: http.StatusBadRequest,
-			wantHeader:     map[string]string{"Content-Type": "application/json"},
-			wantResponse: ErrorResponse{
-				Error:            ErrInvalidRequest,
-				ErrorDescription: "invalid redirect URI",
-				State:            "test-state",
-			},
+			name:            "stateの省略はinvalid_request",
+			rawQuery:        validQuery(map[string]string{"state": ""}),
+			wantStatusCode:  http.StatusFound,
+			wantRedirectURI: "https://example.com/callback",
+			wantQuery:       url.Values{"error": {ErrInvalidRequest}, "error_description": {"state is required"}, "iss": {testIssuer}},
 		},
 		{
-			name: "異常ケース - 予期しないエラー",
-			queryParams: map[string]string{
-				"response_type": "code",
-				"client_id":     "test-client",
-				"redirect_uri":  "https://example.com/callback",
-				"scope":         "read write",
-				"state":         "test-state",
-			},
-			mockErr:        errors.New("database connection failed"),
-			wantStatusCode: http.StatusInternalServerError,
-			wantHeader:     map[string]string{"Content-Type": "application/json"},
-			wantResponse: ErrorResponse{
-				ErrorDescription: "database connection failed",
-				State:            "test-state",
-			},
+			name:            "パラメータの重複はinvalid_request",
+			rawQuery:        validQuery(nil) + "&scope=read",
+			wantStatusCode:  http.StatusFound,
+			wantRedirectURI: "https://example.com/callback",
+			wantQuery:       url.Values{"error": {ErrInvalidRequest}, "error_description": {"scope must not be included more than once"}, "state": {"test-state"}, "iss": {testIssuer}},
 		},
 		{
-			name: "異常ケース - サーバーエラー",
-			queryParams: map[string]string{
-				"response_type": "code",
-				"client_id":     "test-client",
-				"redirect_uri":  "https://example.com/callback",
-				"scope":         "read write",
-				"state":         "test-state",
-			},
-			mockErr:        usecase.ErrServer,
-			wantStatusCode: http.StatusInternalServerError,
-			wantHeader:     map[string]string{"Content-Type": "application/json"},
-			wantResponse: ErrorResponse{
-				Error:            ErrServerError,
-				ErrorDescription: "server error occurred",
-				State:            "test-state",
-			},
+			name:            "クライアントに許可されていないresponse_typeはunauthorized_client",
+			rawQuery:        validQuery(nil),
+			mockErr:         usecase.ErrUnauthorizedClient,
+			wantStatusCode:  http.StatusFound,
+			wantRedirectURI: "https://example.com/callback",
+			wantQuery:       url.Values{"error": {ErrUnauthorized}, "error_description": {"client is not allowed to use the response type"}, "state": {"test-state"}, "iss": {testIssuer}},
 		},
 		{
-			name: "異常ケース - 予期しないエラー",
-			queryParams: map[string]string{
-				"response_type": "code",
-				"client_id":     "test-client",
-				"redirect_uri":  "https://example.com/callback",
-				"scope":         "read write",
-				"state":         "test-state",
-			},
-			mockErr:        usecase.ErrUnExpected,
-			wantStatusCode: http.StatusInternalServerError,
-			wantHeader:     map[string]string{"Content-Type": "application/json"},
-			wantResponse: ErrorResponse{
-				Error:            ErrServerError,
-				ErrorDescription: "unexpected error occurred",
-				State:            "test-state",
-			},
+			name:            "クライアントに許可されていないスコープはinvalid_scope",
+			rawQuery:        validQuery(nil),
+			mockErr:         fmt.Errorf("%w: %w", usecase.ErrInvalidScope, &domain.InvalidScopeError{Scope: "write", Err: domain.ErrScopeNotAllowed}),
+			wantStatusCode:  http.StatusFound,
+			wantRedirectURI: "https://example.com/callback",
+			wantQuery:       url.Values{"error": {ErrInvalidScope}, "error_description": {`requested scope is not allowed for the client: "write"`}, "state": {"test-state"}, "iss": {testIssuer}},
+		},
+		{
+			name:            "サーバーエラーはserver_error",
+			rawQuery:        validQuery(nil),
+			mockErr:         usecase.ErrServer,
+			wantStatusCode:  http.StatusFound,
+			wantRedirectURI: "https://example.com/callback",
+			wantQuery:       url.Values{"error": {ErrServerError}, "error_description": {"server error occurred"}, "state": {"test-state"}, "iss": {testIssuer}},
+		},
+		{
+			name:            "予期しないエラーはserver_error",
+			rawQuery:        validQuery(nil),
+			mockErr:         errors.New("database connection failed"),
+			wantStatusCode:  http.StatusFound,
+			wantRedirectURI: "https://example.com/callback",
+			wantQuery:       url.Values{"error": {ErrServerError}, "error_description": {"server error occurred"}, "state": {"test-state"}, "iss": {testIssuer}},
+		},
+		{
+			name:             "クライアントが見つからない場合はリダイレクトしない",
+			rawQuery:         validQuery(map[string]string{"client_id": "nonexistent-client"}),
+			verifyErr:        usecase.ErrClientNotFound,
+			wantStatusCode:   http.StatusBadRequest,
+			wantBodyContains: "クライアントが見つかりません。",
+		},
+		{
+			name:             "登録されていないリダイレクトURIにはリダイレクトしない",
+			rawQuery:         validQuery(map[string]string{"redirect_uri": "https://malicious.com/callback", "response_type": "token"}),
+			verifyErr:        usecase.ErrInvalidRedirectURI,
+			wantStatusCode:   http.StatusBadRequest,
+			wantBodyContains: "redirect_uriが登録されていません。",
+		},
+		{
+			name:             "client_idの省略",
+			rawQuery:         validQuery(map[string]string{"client_id": ""}),
+			wantStatusCode:   http.StatusBadRequest,
+			wantBodyContains: "client_idが不正です。",
+		},
+		{
+			name:             "redirect_uriの重複",
+			rawQuery:         validQuery(nil) + "&redirect_uri=https://malicious.com/callback",
+			wantStatusCode:   http.StatusBadRequest,
+			wantBodyContains: "redirect_uriが不正です。",
+		},
+		{
+			name:             "リダイレクトURIの検証で予期しないエラー",
+			rawQuery:         validQuery(nil),
+			verifyErr:        usecase.ErrUnExpected,
+			wantStatusCode:   http.StatusInternalServerError,
+			wantBodyContains: "サーバーエラーが発生しました。",
 		},
 	}
 
@@ -169,12 +182,9 @@ func TestAuthorizeHandler_ServeHTTP(t *testing.T) {
 			// given
 			logger := mylogger.NewMockLogger()
 			flow := NewMockAuthorizationFlow(tt.mockErr)
-			handler := NewAuthorizeHandler(logger, flow)
-
-			reqURL := buildRequestURL(tt.queryParams)
-
-			req := httptest.NewRequest(http.MethodGet, reqURL, nil)
-
+			flow.verifyErr = tt.verifyErr
+			handler := NewAuthorizeHandler(logger, flow, newTestRenderer(t), newTestCSRFProtector(), testIssuer, domain.NewScopeProvider(nil), nil)
+			req := httptest.NewRequest(http.MethodGet, "http://example.com/authorize?"+tt.rawQuery, nil)
 			rr := httptest.NewRecorder()
 
 			// when
@@ -184,37 +194,71 @@ func TestAuthorizeHandler_ServeHTTP(t *testing.T) {
 			if rr.Code != tt.wantStatusCode {
 				t.Errorf("Status code = %d, want %d", rr.Code, tt.wantStatusCode)
 			}
-
-			// headersが正しいこと
-			for key, value := range tt.wantHeader {
-				if rr.Header().Get(key) != value {
-					t.Errorf("Header %s = %s, want %s", key, rr.Header().Get(key), value)
+			if tt.wantRedirectURI != "" {
+				location, err := url.Parse(rr.Header().Get("Location"))
+				if err != nil {
+					t.Fatalf("Location = %q, error = %v", rr.Header().Get("Location"), err)
+				}
+				query := location.Query()
+				location.RawQuery = ""
+				if location.String() != tt.wantRedirectURI {
+					t.Errorf("Location = %s, want %s", location, tt.wantRedirectURI)
+				}
+				if !reflect.DeepEqual(query, tt.wantQuery) {
+					t.Errorf("Location query = %v, want %v", query, tt.wantQuery)
 				}
+				return
 			}
-
-			var actualResponse any
-			switch tt.wantResponse.(type) {
-			case ErrorResponse:
-				actualResponse = &ErrorResponse{}
-			case SuccessResponse:
-				actualResponse = &SuccessResponse{}
+			if rr.Header().Get("Location") != "" {
+				t.Errorf("Location = %s, want no redirect", rr.Header().Get("Location"))
 			}
-
-			if err := json.NewDecoder(rr.Body).Decode(actualResponse); err != nil {
-				t.Fatalf("Failed to decode response: %v", err)
+			if ct := rr.Header().Get("Content-Type"); ct != "text/html; charset=utf-8" {
+				t.Errorf("Content-Type = %s, want text/html; charset=utf-8", ct)
 			}
-
-			// response bodyが正しいこと
-			expectedJSONResponse, _ := json.Marshal(tt.wantResponse)
-			actualJSONResponse, _ := json.Marshal(actualResponse)
-
-			if string(actualJSONResponse) != string(expectedJSONResponse) {
-				t.Errorf("Response body = %s, want %s", actualJSONResponse, expectedJSONResponse)
+			if !strings.Contains(rr.Body.String(), tt.wantBodyContains) {
+				t.Errorf("expected body to contain %q, got %s", tt.wantBodyContains, rr.Body.String())
 			}
 		})
 	}
 }
 
+func TestAuthorizeHandler_ServeHTTP_認可成功(t *testing.T) {
+	// given
+	handler := NewAuthorizeHandler(mylogger.NewMockLogger(), NewMockAuthorizationFlow(nil), newTestRenderer(t), newTestCSRFProtector(), testIssuer, domain.NewScopeProvider(nil), nil)
+	req := httptest.NewRequest(http.MethodGet, buildRequestURL(map[string]string{
+		"response_type": "code",
+		"client_id":     "test-client",
+		"redirect_uri":  "https://example.com/callback",
+		"scope":         "read write",
+		"state":         "test-state",
+	}), nil)
+	rr := httptest.NewRecorder()
+
+	// when
+	handler.ServeHTTP(rr, req)
+
+	// then
+	if rr.Code != http.StatusOK {
+		t.Errorf("Status code = %d, want %d", rr.Code, http.StatusOK)
+	}
+	for key, value := range map[string]string{
+		"Set-Cookie":   session.SessionIDCookieName + "=test-session-id; Path=/; HttpOnly; Secure; SameSite=Lax",
+		"Content-Type": "application/json",
+	} {
+		if rr.Header().Get(key) != value {
+			t.Errorf("Header %s = %s, want %s", key, rr.Header().Get(key), value)
+		}
+	}
+	var actual SuccessResponse
+	if err := json.NewDecoder(rr.Body).Decode(&actual); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	expected := SuccessResponse{Message: "OK", TransactionID: "test-transaction-id", Prompt: "login", CSRFToken: testCSRFToken}
+	if actual != expected {
+		t.Errorf("Response body = %v, want %v", actual, expected)
+	}
+}
+
 func buildRequestURL(queryParams map[string]string) string {
 	reqURL := "http://example.com/authorize"
 	if len(queryParams) > 0 {
@@ -226,3 +270,147 @@ func buildRequestURL(queryParams map[string]string) string {
 	}
 	return reqURL
 }
+
+func TestAuthorizeHandler_ServeHTTP_ログイン済みセッション(t *testing.T) {
+	query := map[string]string{
+		"response_type": "code",
+		"client_id":     "test-client",
+		"redirect_uri":  "https://example.com/callback",
+		"scope":         "read write",
+		"state":         "test-state",
+	}
+
+	tests := []struct {
+		name           string
+		output         usecase.AuthorizationCodeFlowOutput
+		wantStatusCode int
+		wantLocation   string
+		wantResponse   SuccessResponse
+	}{
+		{
+			name:           "同意が必要な場合はトランザクションIDとconsentを返す",
+			output:         usecase.NewAuthorizationCodeFlowOutput("test-session-id", "test-transaction-id", usecase.PromptConsent, "client-1", []string{"read"}),
+			wantStatusCode: http.StatusOK,
+			wantResponse: SuccessResponse{
+				Message:       "OK",
+				TransactionID: "test-transaction-id",
+				Prompt:        "consent",
+				CSRFToken:     testCSRFToken,
+			},
+		},
+		{
+			name:           "同意済みの場合は認可コードを付与してリダイレクトする",
+			output:         usecase.NewAuthorizationCodeIssuedOutput("test-session-id", "https://example.com/callback", "test-code", "test-state"),
+			wantStatusCode: http.StatusFound,
+			wantLocation:   "https://example.com/callback?code=test-code&iss=https%3A%2F%2Fauth.example.com&state=test-state",
+		},
+		{
+			name:           "登録済みのリダイレクトURIのクエリは残す",
+			output:         usecase.NewAuthorizationCodeIssuedOutput("test-session-id", "https://example.com/callback?tenant=a", "test-code", "test-state"),
+			wantStatusCode: http.StatusFound,
+			wantLocation:   "https://example.com/callback?code=test-code&iss=https%3A%2F%2Fauth.example.com&state=test-state&tenant=a",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// given
+			flow := &MockAuthorizationFlow{output: tt.output}
+			handler := NewAuthorizeHandler(mylogger.NewMockLogger(), flow, newTestRenderer(t), newTestCSRFProtector(), testIssuer, domain.NewScopeProvider(nil), nil)
+			req := httptest.NewRequest(http.MethodGet, buildRequestURL(query), nil)
+			req.AddCookie(&http.Cookie{Name: session.SessionIDCookieName, Value: "test-session-id"})
+			rr := httptest.NewRecorder()
+
+			// when
+			handler.ServeHTTP(rr, req)
+
+			// then
+			if rr.Code != tt.wantStatusCode {
+				t.Errorf("Status code = %d, want %d", rr.Code, tt.wantStatusCode)
+			}
+			if tt.wantLocation != "" {
+				if rr.Header().Get("Location") != tt.wantLocation {
+					t.Errorf("Location = %s, want %s", rr.Header().Get("Location"), tt.wantLocation)
+				}
+				return
+			}
+
+			var actual SuccessResponse
+			if err := json.NewDecoder(rr.Body).Decode(&actual); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			if actual != tt.wantResponse {
+				t.Errorf("Response body = %v, want %v", actual, tt.wantResponse)
+			}
+		})
+	}
+}
+
+// テスト用のセッション(test-session-id)に紐づくCSRFトークン
+var testCSRFToken = newTestCSRFProtector().Issue("test-session-id")
+
+func newTestCSRFProtector() *presentation.CSRFProtector {
+	return presentation.NewCSRFProtector([]byte("test-secret"))
+}
+
+func newTestRenderer(t *testing.T) *view.Renderer {
+	t.Helper()
+	renderer, err := view.NewRenderer("")
+	if err != nil {
+		t.Fatalf("Failed to create renderer: %v", err)
+	}
+	return renderer
+}
+
+func TestAuthorizeHandler_ServeHTTP_ブラウザへの画面表示(t *testing.T) {
+	query := map[string]string{
+		"response_type": "code",
+		"client_id":     "test-client",
+		"redirect_uri":  "https://example.com/callback",
+		"scope":         "read",
+		"state":         "test-state",
+	}
+
+	tests := []struct {
+		name         string
+		output       usecase.AuthorizationCodeFlowOutput
+		wantContains []string
+	}{
+		{
+			name:         "未ログインの場合はログイン画面を表示する",
+			output:       usecase.NewAuthorizationCodeFlowOutput("test-session-id", "test-transaction-id", usecase.PromptLogin, "client-1", []string{"read"}),
+			wantContains: []string{`name="login_id"`, `name="password"`, `value="test-transaction-id"`, `name="csrf_token" value="` + testCSRFToken + `"`},
+		},
+		{
+			name:         "ログイン済みの場合は同意画面を表示する",
+			output:       usecase.NewAuthorizationCodeFlowOutput("test-session-id", "test-transaction-id", usecase.PromptConsent, "client-1", []string{"read"}),
+			wantContains: []string{"client-1", `name="scope" value="read"`, "データの参照", `value="test-transaction-id"`, `name="csrf_token" value="` + testCSRFToken + `"`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// given
+			handler := NewAuthorizeHandler(mylogger.NewMockLogger(), &MockAuthorizationFlow{output: tt.output}, newTestRenderer(t), newTestCSRFProtector(), testIssuer, domain.NewScopeProvider(nil), nil)
+			req := httptest.NewRequest(http.MethodGet, buildRequestURL(query), nil)
+			req.Header.Set("Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8")
+			rr := httptest.NewRecorder()
+
+			// when
+			handler.ServeHTTP(rr, req)
+
+			// then
+			if rr.Code != http.StatusOK {
+				t.Errorf("Status code = %d, want %d", rr.Code, http.StatusOK)
+			}
+			if ct := rr.Header().Get("Content-Type"); ct != "text/html; charset=utf-8" {
+				t.Errorf("Content-Type = %s, want text/html; charset=utf-8", ct)
+			}
+			for _, want := range tt.wantContains {
+				if !strings.Contains(rr.Body.String(), want) {
+					t.Errorf("expected body to contain %q, got %s", want, rr.Body.String())
+				}
+			}
+		})
+	}
+}
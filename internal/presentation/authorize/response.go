@@ -1,7 +1,12 @@
 package authorize
 
+import "net/url"
+
 type SuccessResponse struct {
-	Message string `json:"message"`
+	Message       string `json:"message"`
+	TransactionID string `json:"transaction_id"`
+	Prompt        string `json:"prompt"`
+	CSRFToken     string `json:"csrf_token"`
 }
 
 var (
@@ -14,10 +19,21 @@ var (
 	ErrTemporarilyUnavailable  = "temporarily_unavailable"
 )
 
+// リダイレクトURIのクエリで返すエラー RFC 6749 4.1.2.1, RFC 9207
 type ErrorResponse struct {
 	Error            string `json:"error"`
 	ErrorDescription string `json:"error_description"`
 	State            string `json:"state"`
+	Iss              string `json:"iss,omitempty"`
+}
+
+func (e ErrorResponse) Query() url.Values {
+	return url.Values{
+		"error":             {e.Error},
+		"error_description": {e.ErrorDescription},
+		"state":             {e.State},
+		"iss":               {e.Iss},
+	}
 }
 
 type Result interface {
@@ -0,0 +1,64 @@
+package presentation
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"oauth-tutorial/internal/session"
+)
+
+// CSRFトークンを送信するフォームのフィールド名
+const CSRFTokenFieldName = "csrf_token"
+
+var (
+	ErrCrossSiteRequest = errors.New("cross-site request is not allowed")
+	ErrCSRFTokenInvalid = errors.New("invalid csrf token")
+)
+
+// ブラウザセッションに紐づくCSRFトークンを発行・検証する
+// トークンはセッションIDのHMACのため、サーバー側に状態を持たず、セッションIDが再生成されると無効になる
+type CSRFProtector struct {
+	secret []byte
+}
+
+func NewCSRFProtector(secret []byte) *CSRFProtector {
+	return &CSRFProtector{secret: secret}
+}
+
+// セッションIDに紐づくCSRFトークンを発行する
+func (p *CSRFProtector) Issue(sessionID session.SessionID) string {
+	mac := hmac.New(sha256.New, p.secret)
+	mac.Write([]byte(sessionID))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// CSRFトークンがセッションIDに対して発行されたものか検証する
+func (p *CSRFProtector) Verify(sessionID session.SessionID, token string) bool {
+	if sessionID == "" || token == "" {
+		return false
+	}
+	return hmac.Equal([]byte(p.Issue(sessionID)), []byte(token))
+}
+
+// Sec-Fetch-SiteヘッダーとOriginヘッダーから、同一オリジン以外から送信されたリクエストを拒否する
+// ヘッダーを送信しないクライアント(ブラウザ以外)のリクエストはCSRFトークンのみで検証する
+func CheckSameOrigin(r *http.Request) error {
+	switch r.Header.Get("Sec-Fetch-Site") {
+	case "", "same-origin", "none":
+	default:
+		return ErrCrossSiteRequest
+	}
+
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return nil
+	}
+	u, err := url.Parse(origin)
+	if err != nil || u.Host != r.Host {
+		return ErrCrossSiteRequest
+	}
+	return nil
+}
@@ -1,9 +1,20 @@
 package decision
 
 import (
+	"net/http"
+	"oauth-tutorial/internal/session"
 	"oauth-tutorial/internal/usecase/decision"
 )
 
 type IPublishAuthorizationCodeUseCase interface {
 	Execute(param *decision.PublishAuthorizationCodeInput) (decision.PublishAuthorizationCodeOutput, error)
 }
+
+type IRenderer interface {
+	Render(w http.ResponseWriter, statusCode int, name string, data any) error
+}
+
+type ICSRFProtector interface {
+	Issue(sessionID session.SessionID) string
+	Verify(sessionID session.SessionID, token string) bool
+}
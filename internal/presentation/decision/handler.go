@@ -4,70 +4,185 @@ import (
 	"errors"
 	"net/http"
 	"net/url"
+	"oauth-tutorial/internal/domain"
 	"oauth-tutorial/internal/presentation"
+	"oauth-tutorial/internal/presentation/view"
 	"oauth-tutorial/internal/session"
 	"oauth-tutorial/internal/usecase/decision"
 	"oauth-tutorial/pkg/mylogger"
 	"strconv"
 )
 
+// CSRFトークンの検証に失敗した場合のエラー
+var errInvalidCSRFToken = errors.New("不正なリクエストです。もう一度初めからやり直してください")
+
 type DecisionHandler struct {
 	logger                   mylogger.Logger
 	publishAuthorizationCode IPublishAuthorizationCodeUseCase
+	renderer                 IRenderer
+	csrfProtector            ICSRFProtector
+	// 認可レスポンスに付与するiss RFC 9207
+	issuer string
+	// レルムのスコープの一覧
+	scopes *domain.ScopeProvider
+	// ログイン画面に表示する上流のIdP
+	loginProviders []view.LoginProvider
 }
 
-func NewDecisionHandler(logger mylogger.Logger, publishAuthorizationCode IPublishAuthorizationCodeUseCase) *DecisionHandler {
-	return &DecisionHandler{logger: logger, publishAuthorizationCode: publishAuthorizationCode}
+func NewDecisionHandler(logger mylogger.Logger, publishAuthorizationCode IPublishAuthorizationCodeUseCase, renderer IRenderer, csrfProtector ICSRFProtector, issuer string, scopes *domain.ScopeProvider, loginProviders []view.LoginProvider) *DecisionHandler {
+	return &DecisionHandler{logger: logger, publishAuthorizationCode: publishAuthorizationCode, renderer: renderer, csrfProtector: csrfProtector, issuer: issuer, scopes: scopes, loginProviders: loginProviders}
 }
 
 func (h *DecisionHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// 他サイトのフォームから送信されたリクエストは、フォームを読む前に拒否する
+	if err := presentation.CheckSameOrigin(r); err != nil {
+		mylogger.SecurityEvent(h.logger, "cross_site_request_rejected", "path", r.URL.Path, "origin", r.Header.Get("Origin"), "secFetchSite", r.Header.Get("Sec-Fetch-Site"))
+		h.writeError(w, r, http.StatusForbidden, errInvalidCSRFToken.Error())
+		return
+	}
+
 	err := r.ParseForm()
 	if err != nil {
 		h.logger.Info("Failed to parse form", "err", err)
-		presentation.WriteJSONResponse(w, http.StatusBadRequest, ErrorResponse{Message: "パラメータの形式を確認してください"})
+		h.writeError(w, r, http.StatusBadRequest, "パラメータの形式を確認してください")
 		return
 	}
 
 	input, err := h.convertParamToInput(r.Form, r)
 	if err != nil {
-		presentation.WriteJSONResponse(w, http.StatusBadRequest, ErrorResponse{Message: err.Error()})
+		if errors.Is(err, errInvalidCSRFToken) {
+			h.writeError(w, r, http.StatusForbidden, err.Error())
+			return
+		}
+		h.writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	h.execute(w, r, presentation.SessionIDFromCookie(r), input)
+}
+
+// 上流のIdPなど/decision以外でログインした後に、認可リクエストの処理を続ける
+// sessionIDはログインによって再生成したセッションID。同意済みであれば認可コードを発行してリダイレクトし、それ以外は同意画面を表示する
+func (h *DecisionHandler) Resume(w http.ResponseWriter, r *http.Request, sessionID session.SessionID, transactionID session.TransactionID) {
+	input, err := decision.NewPublishAuthorizationCodeInput(sessionID, transactionID, "", "", decision.ConsentUndecided, nil)
+	if err != nil {
+		h.logger.Info("Failed to create param", "err", err)
+		h.writeError(w, r, http.StatusBadRequest, "無効なリクエストです。もう一度初めからやり直してください")
 		return
 	}
+	h.execute(w, r, sessionID, input)
+}
 
+// sessionIDはリクエストのセッションID
+func (h *DecisionHandler) execute(w http.ResponseWriter, r *http.Request, sessionID session.SessionID, input *decision.PublishAuthorizationCodeInput) {
 	result, err := h.publishAuthorizationCode.Execute(input)
 	if err != nil {
 		var errPac *decision.ErrPublishAuthorizationCode
 		if errors.As(err, &errPac) {
 			switch {
 			case errors.Is(errPac, decision.ErrSessionNotFound):
-				// sessionが見つからない場合、redirectURIを取得できないため、JSONでエラーを返す
-				presentation.WriteJSONResponse(w, http.StatusBadRequest, ErrorResponse{Message: err.Error()})
+				// sessionが見つからない場合、redirectURIを取得できないため、リダイレクトせずにエラーを返す
+				h.writeError(w, r, http.StatusBadRequest, err.Error())
 				return
 			case errors.Is(errPac, decision.ErrUnexpectedSessionGetError):
-				// session取得時に予期しないエラーが起きた場合、redirectURIを取得できないため、JSONでエラーを返す
-				presentation.WriteJSONResponse(w, http.StatusBadRequest, ErrorResponse{Message: err.Error()})
+				// session取得時に予期しないエラーが起きた場合、redirectURIを取得できないため、リダイレクトせずにエラーを返す
+				h.writeError(w, r, http.StatusBadRequest, err.Error())
+				return
+			case errors.Is(errPac, decision.ErrTransactionNotFound):
+				// 認可リクエストのトランザクションが見つからない場合、redirectURIを取得できないため、リダイレクトせずにエラーを返す
+				h.writeError(w, r, http.StatusBadRequest, err.Error())
 				return
 			case errors.Is(errPac, decision.ErrAuthorizationDenied):
-				redirectUri := errPac.BaseRedirectUri() + "?error=access_denied&error_description=" + errPac.Error() + "&state=" + errPac.State()
-				http.Redirect(w, r, redirectUri, http.StatusSeeOther)
+				query := url.Values{"error": {"access_denied"}, "error_description": {errPac.Error()}, "state": {errPac.State()}, "iss": {h.issuer}}
+				http.Redirect(w, r, presentation.AppendQuery(errPac.BaseRedirectUri(), query), http.StatusSeeOther)
 				return
 			case errors.Is(errPac, decision.ErrInvalidLoginCredentials):
-				// クレデンシャルが異なる場合、リダイレクトせずにフロントでの再入力を促すためJSONでエラーを返す
-				presentation.WriteJSONResponse(w, http.StatusUnauthorized, ErrorResponse{Message: errPac.Error()})
+				// クレデンシャルが異なる場合、リダイレクトせずに再入力を促す
+				h.writeLoginRequired(w, r, sessionID, errPac.Error(), "ログインIDまたはパスワードが正しくありません")
 				return
+			case errors.Is(errPac, decision.ErrInvalidOTP):
+				// 二要素目のコードが異なる場合、コードの再入力を促す
+				h.writeOTPRequired(w, r, http.StatusUnauthorized, sessionID, session.TransactionID(r.PostForm.Get("transaction_id")), errPac.Error(), "確認コードが正しくありません")
+				return
+			case errors.Is(errPac, decision.ErrTooManyOTPAttempts):
+				// 二要素目のコードを誤った回数が上限に達した場合、パスワードからやり直させる
+				h.writeLoginRequired(w, r, sessionID, errPac.Error(), "確認コードの誤りが多すぎます。もう一度ログインしてください")
+				return
+			case errors.Is(errPac, decision.ErrInvalidApprovedScope):
+				// 要求されていないスコープに同意しようとした場合、不正なリクエストとしてエラーを返す
+				h.writeError(w, r, http.StatusBadRequest, errPac.Error())
+				return
+			case errors.Is(errPac, decision.ErrLoginRequired):
+				// 未ログインのセッションでクレデンシャルが送信されなかった場合、ログインを促す
+				h.writeLoginRequired(w, r, sessionID, errPac.Error(), "ログインしてください")
+				return
+			}
+		}
+		h.logger.Error("Unexpected error occurred", "err", err)
+		h.writeError(w, r, http.StatusInternalServerError, "予期しないエラーが発生しました")
+		return
+	}
+
+	// ログインによってセッションIDが再生成された場合は、新しいセッションIDをCookieに設定する
+	if result.RenewedSessionID() != "" {
+		presentation.SetSessionCookie(w, r, result.RenewedSessionID())
+	}
+
+	// パスワードの確認後に二要素目のコードを求める。セッションIDはまだ再生成していない
+	if result.OTPRequired() {
+		h.writeOTPRequired(w, r, http.StatusOK, sessionID, result.TransactionID(), "one-time password required", "")
+		return
+	}
+
+	// ブラウザには同意画面を表示し、それ以外のクライアントにはトランザクションIDと同意が必要なことを返す
+	// セッションIDが再生成された場合は、新しいセッションに紐づくCSRFトークンを発行し直す
+	if result.ConsentRequired() {
+		if result.RenewedSessionID() != "" {
+			sessionID = result.RenewedSessionID()
+		}
+		csrfToken := h.csrfProtector.Issue(sessionID)
+		if presentation.WantsHTML(r) {
+			page := view.NewConsentPage(string(result.TransactionID()), csrfToken, result.ClientName(), result.Scopes(), h.scopes.Current())
+			if err := h.renderer.Render(w, http.StatusOK, view.ConsentTemplate, page); err != nil {
+				h.logger.Error("Failed to render consent page", "err", err)
 			}
+			return
 		}
+		presentation.WriteJSONResponse(w, http.StatusOK, SuccessResponse{
+			Message:       "consent required",
+			TransactionID: string(result.TransactionID()),
+			Prompt:        "consent",
+			CSRFToken:     csrfToken,
+		})
+		return
 	}
 
-	redirectUri := result.BaseRedirectUri() + "?code=" + result.AuthorizationCode() + "&state=" + result.State()
-	http.Redirect(w, r, redirectUri, http.StatusSeeOther)
+	query := url.Values{"code": {result.AuthorizationCode()}, "state": {result.State()}, "iss": {h.issuer}}
+	http.Redirect(w, r, presentation.AppendQuery(result.BaseRedirectUri(), query), http.StatusSeeOther)
 }
 
 func (h *DecisionHandler) convertParamToInput(formValues url.Values, r *http.Request) (*decision.PublishAuthorizationCodeInput, error) {
-	approved, err := strconv.ParseBool(formValues.Get("approved"))
-	if err != nil {
-		h.logger.Info("Invalid 'approved' parameter", "err", err)
-		return nil, errors.New("無効なリクエストです。もう一度初めからやり直してください")
+	// approvedが無い場合はログインのみのリクエストとして扱う
+	consentDecision := decision.ConsentUndecided
+	if formValues.Has("approved") {
+		approved, err := strconv.ParseBool(formValues.Get("approved"))
+		if err != nil {
+			h.logger.Info("Invalid 'approved' parameter", "err", err)
+			return nil, errors.New("無効なリクエストです。もう一度初めからやり直してください")
+		}
+		consentDecision = decision.ConsentDenied
+		if approved {
+			consentDecision = decision.ConsentApproved
+		}
+	}
+	// scopeが無い場合は要求された全てのスコープに同意したものとして扱う
+	// 同意画面のチェックボックスから送信されることを想定し、複数指定とスペース区切りの両方を受け付ける
+	var approvedScopes []string
+	if formValues.Has("scope") {
+		approvedScopes = []string{}
+		for _, v := range formValues["scope"] {
+			approvedScopes = append(approvedScopes, domain.ParseScope(v)...)
+		}
 	}
 	sessionID, err := r.Cookie(session.SessionIDCookieName)
 	if err != nil {
@@ -75,7 +190,19 @@ func (h *DecisionHandler) convertParamToInput(formValues url.Values, r *http.Req
 		return nil, errors.New("セッションが見つかりません。もう一度初めからやり直してください")
 	}
 
-	input, err := decision.NewPublishAuthorizationCodeInput(session.SessionID(sessionID.Value), formValues.Get("login_id"), formValues.Get("password"), approved)
+	// ログイン画面・同意画面で発行したCSRFトークンが、このセッションに紐づくものか検証する
+	if !h.csrfProtector.Verify(session.SessionID(sessionID.Value), formValues.Get(presentation.CSRFTokenFieldName)) {
+		mylogger.SecurityEvent(h.logger, "csrf_token_invalid", "path", r.URL.Path, "transactionID", formValues.Get("transaction_id"))
+		return nil, errInvalidCSRFToken
+	}
+
+	// 確認コードの入力画面からは、二要素目のコードのみを送信する
+	var input *decision.PublishAuthorizationCodeInput
+	if formValues.Has("otp") {
+		input, err = decision.NewSecondFactorInput(session.SessionID(sessionID.Value), session.TransactionID(formValues.Get("transaction_id")), formValues.Get("otp"))
+	} else {
+		input, err = decision.NewPublishAuthorizationCodeInput(session.SessionID(sessionID.Value), session.TransactionID(formValues.Get("transaction_id")), formValues.Get("login_id"), formValues.Get("password"), consentDecision, approvedScopes)
+	}
 	if err != nil {
 		h.logger.Info("Failed to create param", "err", err)
 		return nil, errors.New("無効なリクエストです。もう一度初めからやり直してください")
@@ -83,3 +210,45 @@ func (h *DecisionHandler) convertParamToInput(formValues url.Values, r *http.Req
 
 	return input, nil
 }
+
+// ブラウザにはログイン画面を再表示し、それ以外のクライアントにはJSONでエラーを返す
+func (h *DecisionHandler) writeLoginRequired(w http.ResponseWriter, r *http.Request, sessionID session.SessionID, message string, pageMessage string) {
+	if !presentation.WantsHTML(r) {
+		presentation.WriteJSONResponse(w, http.StatusUnauthorized, ErrorResponse{Message: message})
+		return
+	}
+	csrfToken := h.csrfProtector.Issue(sessionID)
+	page := view.LoginPage{TransactionID: r.PostForm.Get("transaction_id"), CSRFToken: csrfToken, Message: pageMessage, Providers: h.loginProviders}
+	if err := h.renderer.Render(w, http.StatusUnauthorized, view.LoginTemplate, page); err != nil {
+		h.logger.Error("Failed to render login page", "err", err)
+	}
+}
+
+// ブラウザには確認コードの入力画面を表示し、それ以外のクライアントにはトランザクションIDとコードが必要なことを返す
+func (h *DecisionHandler) writeOTPRequired(w http.ResponseWriter, r *http.Request, statusCode int, sessionID session.SessionID, transactionID session.TransactionID, message string, pageMessage string) {
+	csrfToken := h.csrfProtector.Issue(sessionID)
+	if !presentation.WantsHTML(r) {
+		presentation.WriteJSONResponse(w, statusCode, SuccessResponse{
+			Message:       message,
+			TransactionID: string(transactionID),
+			Prompt:        "otp",
+			CSRFToken:     csrfToken,
+		})
+		return
+	}
+	page := view.OTPPage{TransactionID: string(transactionID), CSRFToken: csrfToken, Message: pageMessage}
+	if err := h.renderer.Render(w, statusCode, view.OTPTemplate, page); err != nil {
+		h.logger.Error("Failed to render otp page", "err", err)
+	}
+}
+
+// ブラウザにはエラー画面を表示し、それ以外のクライアントにはJSONでエラーを返す
+func (h *DecisionHandler) writeError(w http.ResponseWriter, r *http.Request, statusCode int, message string) {
+	if !presentation.WantsHTML(r) {
+		presentation.WriteJSONResponse(w, statusCode, ErrorResponse{Message: message})
+		return
+	}
+	if err := h.renderer.Render(w, statusCode, view.ErrorTemplate, view.ErrorPage{Message: message}); err != nil {
+		h.logger.Error("Failed to render error page", "err", err)
+	}
+}
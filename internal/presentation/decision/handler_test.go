@@ -4,17 +4,25 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"oauth-tutorial/internal/domain"
+	"oauth-tutorial/internal/presentation"
+	"oauth-tutorial/internal/presentation/view"
 	"oauth-tutorial/internal/session"
 	"oauth-tutorial/internal/usecase/decision"
 	"oauth-tutorial/pkg/mylogger"
+	"slices"
 	"strings"
 	"testing"
 )
 
 const (
 	TestBaseRedirectURI = "https://example.com/callback"
+	testIssuer          = "https://auth.example.com"
 )
 
+// テスト用のセッション(test-session-id)に紐づくCSRFトークン
+var testCSRFToken = newTestCSRFProtector().Issue("test-session-id")
+
 // モックのPublishAuthorizationCodeUseCase
 type mockPublishAuthorizationCodeUseCase struct {
 	executeFunc func(*decision.PublishAuthorizationCodeInput) (decision.PublishAuthorizationCodeOutput, error)
@@ -42,9 +50,11 @@ func TestDecisionHandler_ServeHTTP(t *testing.T) {
 		{
 			name: "異常ケース - approvedパラメータが無効",
 			formData: url.Values{
-				"approved": {"invalid"},
-				"login_id": {"testuser"},
-				"password": {"testpass"},
+				"approved":       {"invalid"},
+				"transaction_id": {"test-transaction-id"},
+				"csrf_token":     {testCSRFToken},
+				"login_id":       {"testuser"},
+				"password":       {"testpass"},
 			},
 			sessionCookie: &http.Cookie{
 				Name:  session.SessionIDCookieName,
@@ -57,9 +67,11 @@ func TestDecisionHandler_ServeHTTP(t *testing.T) {
 		{
 			name: "異常ケース - セッションCookieが存在しない",
 			formData: url.Values{
-				"approved": {"true"},
-				"login_id": {"testuser"},
-				"password": {"testpass"},
+				"approved":       {"true"},
+				"transaction_id": {"test-transaction-id"},
+				"csrf_token":     {testCSRFToken},
+				"login_id":       {"testuser"},
+				"password":       {"testpass"},
 			},
 			sessionCookie:  nil,
 			mockUseCase:    &mockPublishAuthorizationCodeUseCase{},
@@ -69,9 +81,11 @@ func TestDecisionHandler_ServeHTTP(t *testing.T) {
 		{
 			name: "正常ケース - 承認された場合",
 			formData: url.Values{
-				"approved": {"true"},
-				"login_id": {"testuser"},
-				"password": {"testpass"},
+				"approved":       {"true"},
+				"transaction_id": {"test-transaction-id"},
+				"csrf_token":     {testCSRFToken},
+				"login_id":       {"testuser"},
+				"password":       {"testpass"},
 			},
 			sessionCookie: &http.Cookie{
 				Name:  session.SessionIDCookieName,
@@ -83,18 +97,21 @@ func TestDecisionHandler_ServeHTTP(t *testing.T) {
 						TestBaseRedirectURI,
 						"test-auth-code",
 						"test-state",
+						"",
 					), nil
 				},
 			},
 			expectedStatus:      http.StatusSeeOther,
-			expectedRedirectURL: "https://example.com/callback?code=test-auth-code&state=test-state",
+			expectedRedirectURL: "https://example.com/callback?code=test-auth-code&iss=https%3A%2F%2Fauth.example.com&state=test-state",
 		},
 		{
 			name: "異常ケース - セッションが見つからない",
 			formData: url.Values{
-				"approved": {"true"},
-				"login_id": {"testuser"},
-				"password": {"testpass"},
+				"approved":       {"true"},
+				"transaction_id": {"test-transaction-id"},
+				"csrf_token":     {newTestCSRFProtector().Issue("invalid-session-id")},
+				"login_id":       {"testuser"},
+				"password":       {"testpass"},
 			},
 			sessionCookie: &http.Cookie{
 				Name:  session.SessionIDCookieName,
@@ -111,9 +128,11 @@ func TestDecisionHandler_ServeHTTP(t *testing.T) {
 		{
 			name: "異常ケース - 認可が拒否された",
 			formData: url.Values{
-				"approved": {"false"},
-				"login_id": {"testuser"},
-				"password": {"testpass"},
+				"approved":       {"false"},
+				"transaction_id": {"test-transaction-id"},
+				"csrf_token":     {testCSRFToken},
+				"login_id":       {"testuser"},
+				"password":       {"testpass"},
 			},
 			sessionCookie: &http.Cookie{
 				Name:  session.SessionIDCookieName,
@@ -126,14 +145,16 @@ func TestDecisionHandler_ServeHTTP(t *testing.T) {
 			},
 			expectedStatus:      http.StatusSeeOther,
 			expectedBody:        "",
-			expectedRedirectURL: TestBaseRedirectURI + "?error=access_denied&error_description=" + decision.ErrAuthorizationDenied.Error() + "&state=test-state",
+			expectedRedirectURL: TestBaseRedirectURI + "?" + url.Values{"error": {"access_denied"}, "error_description": {decision.ErrAuthorizationDenied.Error()}, "state": {"test-state"}, "iss": {testIssuer}}.Encode(),
 		},
 		{
 			name: "異常ケース - ログイン失敗",
 			formData: url.Values{
-				"approved": {"true"},
-				"login_id": {"unknown"},
-				"password": {"testpass"},
+				"approved":       {"true"},
+				"transaction_id": {"test-transaction-id"},
+				"csrf_token":     {testCSRFToken},
+				"login_id":       {"unknown"},
+				"password":       {"testpass"},
 			},
 			sessionCookie: &http.Cookie{
 				Name:  session.SessionIDCookieName,
@@ -153,7 +174,7 @@ func TestDecisionHandler_ServeHTTP(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			// given
-			handler := NewDecisionHandler(logger, tt.mockUseCase)
+			handler := NewDecisionHandler(logger, tt.mockUseCase, newTestRenderer(t), newTestCSRFProtector(), testIssuer, domain.NewScopeProvider(nil), nil)
 
 			// リクエストの準備
 			req := httptest.NewRequest("POST", "/decision", strings.NewReader(tt.formData.Encode()))
@@ -189,36 +210,248 @@ func TestDecisionHandler_ServeHTTP(t *testing.T) {
 	}
 }
 
+func TestDecisionHandler_ServeHTTP_セッションID再生成(t *testing.T) {
+	// given
+	mockUseCase := &mockPublishAuthorizationCodeUseCase{
+		executeFunc: func(input *decision.PublishAuthorizationCodeInput) (decision.PublishAuthorizationCodeOutput, error) {
+			return decision.NewPublishAuthorizationCodeOutput(TestBaseRedirectURI, "test-auth-code", "test-state", "renewed-session-id"), nil
+		},
+	}
+	handler := NewDecisionHandler(mylogger.NewMockLogger(), mockUseCase, newTestRenderer(t), newTestCSRFProtector(), testIssuer, domain.NewScopeProvider(nil), nil)
+	formData := url.Values{
+		"approved":       {"true"},
+		"transaction_id": {"test-transaction-id"},
+		"csrf_token":     {testCSRFToken},
+		"login_id":       {"testuser"},
+		"password":       {"testpass"},
+	}
+	req := httptest.NewRequest("POST", "/decision", strings.NewReader(formData.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(&http.Cookie{Name: session.SessionIDCookieName, Value: "test-session-id"})
+	recorder := httptest.NewRecorder()
+
+	// when
+	handler.ServeHTTP(recorder, req)
+
+	// then
+	if recorder.Code != http.StatusSeeOther {
+		t.Errorf("expected status code %d, got %d", http.StatusSeeOther, recorder.Code)
+	}
+	var sessionCookie *http.Cookie
+	for _, c := range recorder.Result().Cookies() {
+		if c.Name == session.SessionIDCookieName {
+			sessionCookie = c
+		}
+	}
+	if sessionCookie == nil {
+		t.Fatal("expected session cookie to be set")
+	}
+	if sessionCookie.Value != "renewed-session-id" {
+		t.Errorf("expected session cookie %s, got %s", "renewed-session-id", sessionCookie.Value)
+	}
+}
+
+func TestDecisionHandler_ServeHTTP_同意が必要(t *testing.T) {
+	// given
+	mockUseCase := &mockPublishAuthorizationCodeUseCase{
+		executeFunc: func(input *decision.PublishAuthorizationCodeInput) (decision.PublishAuthorizationCodeOutput, error) {
+			return decision.NewConsentRequiredOutput("test-transaction-id", "renewed-session-id", "client-1", []string{"read"}), nil
+		},
+	}
+	handler := NewDecisionHandler(mylogger.NewMockLogger(), mockUseCase, newTestRenderer(t), newTestCSRFProtector(), testIssuer, domain.NewScopeProvider(nil), nil)
+	formData := url.Values{
+		"transaction_id": {"test-transaction-id"},
+		"csrf_token":     {testCSRFToken},
+		"login_id":       {"testuser"},
+		"password":       {"testpass"},
+	}
+	req := httptest.NewRequest("POST", "/decision", strings.NewReader(formData.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(&http.Cookie{Name: session.SessionIDCookieName, Value: "test-session-id"})
+	recorder := httptest.NewRecorder()
+
+	// when
+	handler.ServeHTTP(recorder, req)
+
+	// then
+	if recorder.Code != http.StatusOK {
+		t.Errorf("expected status code %d, got %d", http.StatusOK, recorder.Code)
+	}
+	expectedBody := `{"message":"consent required","transaction_id":"test-transaction-id","prompt":"consent","csrf_token":"` + newTestCSRFProtector().Issue("renewed-session-id") + `"}`
+	if actualBody := strings.TrimSpace(recorder.Body.String()); actualBody != expectedBody {
+		t.Errorf("expected body %s, got %s", expectedBody, actualBody)
+	}
+	if !strings.Contains(recorder.Header().Get("Set-Cookie"), "renewed-session-id") {
+		t.Errorf("expected renewed session cookie, got %s", recorder.Header().Get("Set-Cookie"))
+	}
+}
+
+func TestDecisionHandler_ServeHTTP_確認コードが必要(t *testing.T) {
+	// given
+	mockUseCase := &mockPublishAuthorizationCodeUseCase{
+		executeFunc: func(input *decision.PublishAuthorizationCodeInput) (decision.PublishAuthorizationCodeOutput, error) {
+			return decision.NewOTPRequiredOutput("test-transaction-id"), nil
+		},
+	}
+	handler := NewDecisionHandler(mylogger.NewMockLogger(), mockUseCase, newTestRenderer(t), newTestCSRFProtector(), testIssuer, domain.NewScopeProvider(nil), nil)
+	formData := url.Values{
+		"transaction_id": {"test-transaction-id"},
+		"csrf_token":     {testCSRFToken},
+		"login_id":       {"testuser"},
+		"password":       {"testpass"},
+	}
+	req := httptest.NewRequest("POST", "/decision", strings.NewReader(formData.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(&http.Cookie{Name: session.SessionIDCookieName, Value: "test-session-id"})
+	recorder := httptest.NewRecorder()
+
+	// when
+	handler.ServeHTTP(recorder, req)
+
+	// then
+	if recorder.Code != http.StatusOK {
+		t.Errorf("expected status code %d, got %d", http.StatusOK, recorder.Code)
+	}
+	// 二要素目の確認まではセッションIDを再生成しない
+	expectedBody := `{"message":"one-time password required","transaction_id":"test-transaction-id","prompt":"otp","csrf_token":"` + testCSRFToken + `"}`
+	if actualBody := strings.TrimSpace(recorder.Body.String()); actualBody != expectedBody {
+		t.Errorf("expected body %s, got %s", expectedBody, actualBody)
+	}
+	if cookie := recorder.Header().Get("Set-Cookie"); cookie != "" {
+		t.Errorf("expected no session cookie, got %s", cookie)
+	}
+}
+
+func TestDecisionHandler_ServeHTTP_ブラウザへの画面表示(t *testing.T) {
+	tests := []struct {
+		name           string
+		executeFunc    func(*decision.PublishAuthorizationCodeInput) (decision.PublishAuthorizationCodeOutput, error)
+		expectedStatus int
+		wantContains   []string
+	}{
+		{
+			name: "同意が必要な場合は同意画面を表示する",
+			executeFunc: func(input *decision.PublishAuthorizationCodeInput) (decision.PublishAuthorizationCodeOutput, error) {
+				return decision.NewConsentRequiredOutput("test-transaction-id", "renewed-session-id", "client-1", []string{"read", "write"}), nil
+			},
+			expectedStatus: http.StatusOK,
+			wantContains:   []string{"client-1", `name="scope" value="read"`, `name="scope" value="write"`, "データの参照", `name="approved"`},
+		},
+		{
+			name: "クレデンシャルが誤っている場合はログイン画面を再表示する",
+			executeFunc: func(input *decision.PublishAuthorizationCodeInput) (decision.PublishAuthorizationCodeOutput, error) {
+				return decision.PublishAuthorizationCodeOutput{}, decision.NewErrPublishAuthorizationCode(decision.ErrInvalidLoginCredentials, "", "")
+			},
+			expectedStatus: http.StatusUnauthorized,
+			wantContains:   []string{`name="login_id"`, `value="test-transaction-id"`, "ログインIDまたはパスワードが正しくありません"},
+		},
+		{
+			name: "TOTPを有効にしたユーザーには確認コードの入力画面を表示する",
+			executeFunc: func(input *decision.PublishAuthorizationCodeInput) (decision.PublishAuthorizationCodeOutput, error) {
+				return decision.NewOTPRequiredOutput("test-transaction-id"), nil
+			},
+			expectedStatus: http.StatusOK,
+			wantContains:   []string{`name="otp"`, `value="test-transaction-id"`, `name="csrf_token" value="` + testCSRFToken + `"`},
+		},
+		{
+			name: "確認コードが誤っている場合は確認コードの入力画面を再表示する",
+			executeFunc: func(input *decision.PublishAuthorizationCodeInput) (decision.PublishAuthorizationCodeOutput, error) {
+				return decision.PublishAuthorizationCodeOutput{}, decision.NewErrPublishAuthorizationCode(decision.ErrInvalidOTP, "", "")
+			},
+			expectedStatus: http.StatusUnauthorized,
+			wantContains:   []string{`name="otp"`, `value="test-transaction-id"`, "確認コードが正しくありません"},
+		},
+		{
+			name: "確認コードの誤りが上限に達した場合はログイン画面を表示する",
+			executeFunc: func(input *decision.PublishAuthorizationCodeInput) (decision.PublishAuthorizationCodeOutput, error) {
+				return decision.PublishAuthorizationCodeOutput{}, decision.NewErrPublishAuthorizationCode(decision.ErrTooManyOTPAttempts, "", "")
+			},
+			expectedStatus: http.StatusUnauthorized,
+			wantContains:   []string{`name="login_id"`, "確認コードの誤りが多すぎます"},
+		},
+		{
+			name: "トランザクションが見つからない場合はエラー画面を表示する",
+			executeFunc: func(input *decision.PublishAuthorizationCodeInput) (decision.PublishAuthorizationCodeOutput, error) {
+				return decision.PublishAuthorizationCodeOutput{}, decision.NewErrPublishAuthorizationCode(decision.ErrTransactionNotFound, "", "")
+			},
+			expectedStatus: http.StatusBadRequest,
+			wantContains:   []string{"authorization transaction not found"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// given
+			handler := NewDecisionHandler(mylogger.NewMockLogger(), &mockPublishAuthorizationCodeUseCase{executeFunc: tt.executeFunc}, newTestRenderer(t), newTestCSRFProtector(), testIssuer, domain.NewScopeProvider(nil), nil)
+			formData := url.Values{
+				"transaction_id": {"test-transaction-id"},
+				"csrf_token":     {testCSRFToken},
+				"login_id":       {"testuser"},
+				"password":       {"testpass"},
+			}
+			req := httptest.NewRequest("POST", "/decision", strings.NewReader(formData.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			req.Header.Set("Accept", "text/html")
+			req.AddCookie(&http.Cookie{Name: session.SessionIDCookieName, Value: "test-session-id"})
+			recorder := httptest.NewRecorder()
+
+			// when
+			handler.ServeHTTP(recorder, req)
+
+			// then
+			if recorder.Code != tt.expectedStatus {
+				t.Errorf("expected status code %d, got %d", tt.expectedStatus, recorder.Code)
+			}
+			if ct := recorder.Header().Get("Content-Type"); ct != "text/html; charset=utf-8" {
+				t.Errorf("expected Content-Type text/html; charset=utf-8, got %s", ct)
+			}
+			for _, want := range tt.wantContains {
+				if !strings.Contains(recorder.Body.String(), want) {
+					t.Errorf("expected body to contain %q, got %s", want, recorder.Body.String())
+				}
+			}
+		})
+	}
+}
+
 func Test_リクエストパラメーターからInputへの変換(t *testing.T) {
 	logger := mylogger.NewMockLogger()
-	handler := NewDecisionHandler(logger, nil)
+	handler := NewDecisionHandler(logger, nil, newTestRenderer(t), newTestCSRFProtector(), testIssuer, domain.NewScopeProvider(nil), nil)
 
 	tests := []struct {
-		name          string
-		formValues    url.Values
-		sessionCookie *http.Cookie
-		expectError   bool
-		expectedError string
+		name           string
+		formValues     url.Values
+		sessionCookie  *http.Cookie
+		expectError    bool
+		expectedError  string
+		expectDecision decision.ConsentDecision
+		expectScopes   []string
+		expectOTP      bool
 	}{
 		{
 			name: "正常ケース",
 			formValues: url.Values{
-				"approved": {"true"},
-				"login_id": {"testuser"},
-				"password": {"testpass"},
+				"approved":       {"true"},
+				"transaction_id": {"test-transaction-id"},
+				"csrf_token":     {testCSRFToken},
+				"login_id":       {"testuser"},
+				"password":       {"testpass"},
 			},
 			sessionCookie: &http.Cookie{
 				Name:  session.SessionIDCookieName,
 				Value: "test-session-id",
 			},
-			expectError: false,
+			expectError:    false,
+			expectDecision: decision.ConsentApproved,
 		},
 		{
 			name: "異常ケース - approvedパラメータが無効",
 			formValues: url.Values{
-				"approved": {"invalid"},
-				"login_id": {"testuser"},
-				"password": {"testpass"},
+				"approved":       {"invalid"},
+				"transaction_id": {"test-transaction-id"},
+				"csrf_token":     {testCSRFToken},
+				"login_id":       {"testuser"},
+				"password":       {"testpass"},
 			},
 			sessionCookie: &http.Cookie{
 				Name:  session.SessionIDCookieName,
@@ -227,17 +460,166 @@ func Test_リクエストパラメーターからInputへの変換(t *testing.T)
 			expectError:   true,
 			expectedError: "無効なリクエストです。もう一度初めからやり直してください",
 		},
+		{
+			name: "異常ケース - CSRFトークンが存在しない",
+			formValues: url.Values{
+				"approved":       {"true"},
+				"transaction_id": {"test-transaction-id"},
+			},
+			sessionCookie: &http.Cookie{
+				Name:  session.SessionIDCookieName,
+				Value: "test-session-id",
+			},
+			expectError:   true,
+			expectedError: "不正なリクエストです。もう一度初めからやり直してください",
+		},
+		{
+			name: "異常ケース - 別のセッションに発行されたCSRFトークン",
+			formValues: url.Values{
+				"approved":       {"true"},
+				"transaction_id": {"test-transaction-id"},
+				"csrf_token":     {newTestCSRFProtector().Issue("other-session-id")},
+			},
+			sessionCookie: &http.Cookie{
+				Name:  session.SessionIDCookieName,
+				Value: "test-session-id",
+			},
+			expectError:   true,
+			expectedError: "不正なリクエストです。もう一度初めからやり直してください",
+		},
 		{
 			name: "異常ケース - セッションCookieが存在しない",
 			formValues: url.Values{
-				"approved": {"true"},
-				"login_id": {"testuser"},
-				"password": {"testpass"},
+				"approved":       {"true"},
+				"transaction_id": {"test-transaction-id"},
+				"csrf_token":     {testCSRFToken},
+				"login_id":       {"testuser"},
+				"password":       {"testpass"},
 			},
 			sessionCookie: nil,
 			expectError:   true,
 			expectedError: "セッションが見つかりません。もう一度初めからやり直してください",
 		},
+		{
+			name: "正常ケース - ログイン済みのためクレデンシャルを省略",
+			formValues: url.Values{
+				"approved":       {"false"},
+				"transaction_id": {"test-transaction-id"},
+				"csrf_token":     {testCSRFToken},
+			},
+			sessionCookie: &http.Cookie{
+				Name:  session.SessionIDCookieName,
+				Value: "test-session-id",
+			},
+			expectError:    false,
+			expectDecision: decision.ConsentDenied,
+		},
+		{
+			name: "正常ケース - approvedを省略したログインのみのリクエスト",
+			formValues: url.Values{
+				"transaction_id": {"test-transaction-id"},
+				"csrf_token":     {testCSRFToken},
+				"login_id":       {"testuser"},
+				"password":       {"testpass"},
+			},
+			sessionCookie: &http.Cookie{
+				Name:  session.SessionIDCookieName,
+				Value: "test-session-id",
+			},
+			expectError:    false,
+			expectDecision: decision.ConsentUndecided,
+		},
+		{
+			name: "正常ケース - 同意するスコープを複数指定",
+			formValues: url.Values{
+				"approved":       {"true"},
+				"transaction_id": {"test-transaction-id"},
+				"csrf_token":     {testCSRFToken},
+				"scope":          {"read", "write profile"},
+			},
+			sessionCookie: &http.Cookie{
+				Name:  session.SessionIDCookieName,
+				Value: "test-session-id",
+			},
+			expectError:    false,
+			expectDecision: decision.ConsentApproved,
+			expectScopes:   []string{"read", "write", "profile"},
+		},
+		{
+			name: "正常ケース - 全てのスコープのチェックを外した",
+			formValues: url.Values{
+				"approved":       {"true"},
+				"transaction_id": {"test-transaction-id"},
+				"csrf_token":     {testCSRFToken},
+				"scope":          {""},
+			},
+			sessionCookie: &http.Cookie{
+				Name:  session.SessionIDCookieName,
+				Value: "test-session-id",
+			},
+			expectError:    false,
+			expectDecision: decision.ConsentApproved,
+			expectScopes:   []string{},
+		},
+		{
+			name: "異常ケース - transaction_idが存在しない",
+			formValues: url.Values{
+				"approved":   {"true"},
+				"login_id":   {"testuser"},
+				"password":   {"testpass"},
+				"csrf_token": {testCSRFToken},
+			},
+			sessionCookie: &http.Cookie{
+				Name:  session.SessionIDCookieName,
+				Value: "test-session-id",
+			},
+			expectError:   true,
+			expectedError: "無効なリクエストです。もう一度初めからやり直してください",
+		},
+		{
+			name: "正常ケース - 確認コードの入力画面からのリクエスト",
+			formValues: url.Values{
+				"transaction_id": {"test-transaction-id"},
+				"csrf_token":     {testCSRFToken},
+				"otp":            {"123456"},
+			},
+			sessionCookie: &http.Cookie{
+				Name:  session.SessionIDCookieName,
+				Value: "test-session-id",
+			},
+			expectError:    false,
+			expectDecision: decision.ConsentUndecided,
+			expectOTP:      true,
+		},
+		{
+			name: "異常ケース - 確認コードが空",
+			formValues: url.Values{
+				"transaction_id": {"test-transaction-id"},
+				"csrf_token":     {testCSRFToken},
+				"otp":            {""},
+			},
+			sessionCookie: &http.Cookie{
+				Name:  session.SessionIDCookieName,
+				Value: "test-session-id",
+			},
+			expectError:   true,
+			expectedError: "無効なリクエストです。もう一度初めからやり直してください",
+		},
+		{
+			name: "異常ケース - パスワードのみ省略",
+			formValues: url.Values{
+				"approved":       {"true"},
+				"transaction_id": {"test-transaction-id"},
+				"csrf_token":     {testCSRFToken},
+				"login_id":       {"testuser"},
+			},
+			sessionCookie: &http.Cookie{
+				Name:  session.SessionIDCookieName,
+				Value: "test-session-id",
+			},
+			expectError:   true,
+			expectedError: "無効なリクエストです。もう一度初めからやり直してください",
+		},
 	}
 
 	for _, tt := range tests {
@@ -267,9 +649,107 @@ func Test_リクエストパラメーターからInputへの変換(t *testing.T)
 					t.Errorf("unexpected error: %v", err)
 				}
 				if param == nil {
-					t.Error("expected param but got nil")
+					t.Fatal("expected param but got nil")
 				}
+				if param.Decision() != tt.expectDecision {
+					t.Errorf("expected decision %v, got %v", tt.expectDecision, param.Decision())
+				}
+				if (tt.expectScopes == nil) != (param.ApprovedScopes() == nil) || !slices.Equal(param.ApprovedScopes(), tt.expectScopes) {
+					t.Errorf("expected approved scopes %#v, got %#v", tt.expectScopes, param.ApprovedScopes())
+				}
+				if param.HasOTP() != tt.expectOTP {
+					t.Errorf("expected HasOTP() %v, got %v", tt.expectOTP, param.HasOTP())
+				}
+			}
+		})
+	}
+}
+
+func TestDecisionHandler_ServeHTTP_CSRF対策(t *testing.T) {
+	tests := []struct {
+		name           string
+		headers        map[string]string
+		csrfToken      string
+		expectedStatus int
+	}{
+		{
+			name:           "正常ケース - 同一オリジンからの送信",
+			headers:        map[string]string{"Origin": "http://example.com", "Sec-Fetch-Site": "same-origin"},
+			csrfToken:      testCSRFToken,
+			expectedStatus: http.StatusSeeOther,
+		},
+		{
+			name:           "正常ケース - OriginとSec-Fetch-Siteを送信しないクライアント",
+			csrfToken:      testCSRFToken,
+			expectedStatus: http.StatusSeeOther,
+		},
+		{
+			name:           "異常ケース - 他サイトからの送信(Sec-Fetch-Site)",
+			headers:        map[string]string{"Sec-Fetch-Site": "cross-site"},
+			csrfToken:      testCSRFToken,
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "異常ケース - 他サイトからの送信(Origin)",
+			headers:        map[string]string{"Origin": "https://evil.example.org"},
+			csrfToken:      testCSRFToken,
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "異常ケース - CSRFトークンが不正",
+			csrfToken:      "invalid-token",
+			expectedStatus: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// given
+			executed := false
+			mockUseCase := &mockPublishAuthorizationCodeUseCase{
+				executeFunc: func(input *decision.PublishAuthorizationCodeInput) (decision.PublishAuthorizationCodeOutput, error) {
+					executed = true
+					return decision.NewPublishAuthorizationCodeOutput(TestBaseRedirectURI, "test-auth-code", "test-state", ""), nil
+				},
+			}
+			handler := NewDecisionHandler(mylogger.NewMockLogger(), mockUseCase, newTestRenderer(t), newTestCSRFProtector(), testIssuer, domain.NewScopeProvider(nil), nil)
+			formData := url.Values{
+				"approved":       {"true"},
+				"transaction_id": {"test-transaction-id"},
+				"csrf_token":     {tt.csrfToken},
+			}
+			req := httptest.NewRequest("POST", "http://example.com/decision", strings.NewReader(formData.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			req.AddCookie(&http.Cookie{Name: session.SessionIDCookieName, Value: "test-session-id"})
+			recorder := httptest.NewRecorder()
+
+			// when
+			handler.ServeHTTP(recorder, req)
+
+			// then
+			if recorder.Code != tt.expectedStatus {
+				t.Errorf("expected status code %d, got %d", tt.expectedStatus, recorder.Code)
+			}
+			// 検証に失敗した場合は認可コードを発行しないこと
+			if tt.expectedStatus == http.StatusForbidden && executed {
+				t.Error("expected usecase not to be executed")
 			}
 		})
 	}
 }
+
+func newTestRenderer(t *testing.T) *view.Renderer {
+	t.Helper()
+	renderer, err := view.NewRenderer("")
+	if err != nil {
+		t.Fatalf("Failed to create renderer: %v", err)
+	}
+	return renderer
+}
+
+func newTestCSRFProtector() *presentation.CSRFProtector {
+	return presentation.NewCSRFProtector([]byte("test-secret"))
+}
@@ -1,7 +1,10 @@
 package decision
 
 type SuccessResponse struct {
-	Message string `json:"message"`
+	Message       string `json:"message"`
+	TransactionID string `json:"transaction_id"`
+	Prompt        string `json:"prompt"`
+	CSRFToken     string `json:"csrf_token"`
 }
 
 type ErrorResponse struct {
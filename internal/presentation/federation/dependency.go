@@ -0,0 +1,29 @@
+package federation
+
+import (
+	"context"
+	"net/http"
+	"oauth-tutorial/internal/session"
+	"oauth-tutorial/internal/usecase/federation"
+)
+
+type IStartFederatedLoginUseCase interface {
+	Execute(ctx context.Context, sessionID session.SessionID, transactionID session.TransactionID, providerName string) (string, error)
+}
+
+type ICompleteFederatedLoginUseCase interface {
+	Execute(ctx context.Context, input *federation.CompleteFederatedLoginInput) (federation.CompleteFederatedLoginOutput, error)
+}
+
+// ログインした後に認可リクエストの処理を続ける(/decisionのハンドラー)
+type IAuthorizationResumer interface {
+	Resume(w http.ResponseWriter, r *http.Request, sessionID session.SessionID, transactionID session.TransactionID)
+}
+
+type IRenderer interface {
+	Render(w http.ResponseWriter, statusCode int, name string, data any) error
+}
+
+type ICSRFVerifier interface {
+	Verify(sessionID session.SessionID, token string) bool
+}
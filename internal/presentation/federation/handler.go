@@ -0,0 +1,102 @@
+package federation
+
+import (
+	"errors"
+	"net/http"
+	"oauth-tutorial/internal/presentation"
+	"oauth-tutorial/internal/presentation/view"
+	"oauth-tutorial/internal/session"
+	"oauth-tutorial/internal/usecase/federation"
+	"oauth-tutorial/pkg/mylogger"
+)
+
+// ログイン画面から上流のIdPでログインするためのハンドラー
+type FederationHandler struct {
+	logger        mylogger.Logger
+	startLogin    IStartFederatedLoginUseCase
+	completeLogin ICompleteFederatedLoginUseCase
+	resumer       IAuthorizationResumer
+	renderer      IRenderer
+	csrfVerifier  ICSRFVerifier
+}
+
+func NewFederationHandler(logger mylogger.Logger, startLogin IStartFederatedLoginUseCase, completeLogin ICompleteFederatedLoginUseCase, resumer IAuthorizationResumer, renderer IRenderer, csrfVerifier ICSRFVerifier) *FederationHandler {
+	return &FederationHandler{logger: logger, startLogin: startLogin, completeLogin: completeLogin, resumer: resumer, renderer: renderer, csrfVerifier: csrfVerifier}
+}
+
+// POST /federation/login: ログイン画面から上流のIdPの認可エンドポイントへリダイレクトする
+func (h *FederationHandler) ServeLogin(w http.ResponseWriter, r *http.Request) {
+	// /decisionと同様に、他サイトからの送信とCSRFトークンを検証する
+	if err := presentation.CheckSameOrigin(r); err != nil {
+		mylogger.SecurityEvent(h.logger, "cross_site_request_rejected", "path", r.URL.Path, "origin", r.Header.Get("Origin"), "secFetchSite", r.Header.Get("Sec-Fetch-Site"))
+		h.renderError(w, http.StatusForbidden, "不正なリクエストです。もう一度初めからやり直してください。")
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		h.logger.Info("Failed to parse form", "err", err)
+		h.renderError(w, http.StatusBadRequest, "パラメータの形式を確認してください。")
+		return
+	}
+	sessionID := presentation.SessionIDFromCookie(r)
+	if sessionID == "" {
+		h.renderError(w, http.StatusBadRequest, "セッションが見つかりません。もう一度初めからやり直してください。")
+		return
+	}
+	if !h.csrfVerifier.Verify(sessionID, r.PostForm.Get(presentation.CSRFTokenFieldName)) {
+		mylogger.SecurityEvent(h.logger, "csrf_token_invalid", "path", r.URL.Path, "transactionID", r.PostForm.Get("transaction_id"))
+		h.renderError(w, http.StatusForbidden, "不正なリクエストです。もう一度初めからやり直してください。")
+		return
+	}
+
+	redirectURL, err := h.startLogin.Execute(r.Context(), sessionID, session.TransactionID(r.PostForm.Get("transaction_id")), r.PostForm.Get("provider"))
+	if err != nil {
+		switch {
+		case errors.Is(err, federation.ErrProviderNotFound):
+			h.renderError(w, http.StatusBadRequest, "ログインに使うサービスが見つかりません。")
+		case errors.Is(err, federation.ErrTransactionNotFound):
+			h.renderError(w, http.StatusBadRequest, "認可リクエストが見つかりません。もう一度初めからやり直してください。")
+		case errors.Is(err, federation.ErrProviderUnavailable):
+			h.renderError(w, http.StatusBadGateway, "ログインに使うサービスに接続できません。しばらくしてからやり直してください。")
+		default:
+			h.logger.Error("Unexpected error occurred", "err", err)
+			h.renderError(w, http.StatusInternalServerError, "サーバーエラーが発生しました。")
+		}
+		return
+	}
+	http.Redirect(w, r, redirectURL, http.StatusSeeOther)
+}
+
+// GET /federation/callback: 上流のIdPからのリダイレクトを受けてログインし、認可リクエストの処理を続ける
+func (h *FederationHandler) ServeCallback(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	input, err := federation.NewCompleteFederatedLoginInput(presentation.SessionIDFromCookie(r), query.Get("state"), query.Get("code"), query.Get("error"))
+	if err != nil {
+		h.logger.Info("Invalid federated login callback", "err", err)
+		h.renderError(w, http.StatusBadRequest, "不正なリクエストです。もう一度初めからやり直してください。")
+		return
+	}
+
+	output, err := h.completeLogin.Execute(r.Context(), input)
+	if err != nil {
+		switch {
+		case errors.Is(err, federation.ErrLoginStateNotFound), errors.Is(err, federation.ErrTransactionNotFound), errors.Is(err, federation.ErrProviderNotFound):
+			h.renderError(w, http.StatusBadRequest, "ログインの有効期限が切れたか、不正なリクエストです。もう一度初めからやり直してください。")
+		case errors.Is(err, federation.ErrUpstreamLoginFailed):
+			h.renderError(w, http.StatusUnauthorized, "ログインに使うサービスでのログインに失敗しました。")
+		default:
+			h.logger.Error("Unexpected error occurred", "err", err)
+			h.renderError(w, http.StatusInternalServerError, "サーバーエラーが発生しました。")
+		}
+		return
+	}
+
+	// ログインによって再生成したセッションIDをCookieに設定し、同意画面の表示または認可コードの発行に進む
+	presentation.SetSessionCookie(w, r, output.SessionID())
+	h.resumer.Resume(w, r, output.SessionID(), output.TransactionID())
+}
+
+func (h *FederationHandler) renderError(w http.ResponseWriter, statusCode int, message string) {
+	if err := h.renderer.Render(w, statusCode, view.ErrorTemplate, view.ErrorPage{Message: message}); err != nil {
+		h.logger.Error("Failed to render error page", "err", err)
+	}
+}
@@ -0,0 +1,151 @@
+package federation
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"oauth-tutorial/internal/presentation"
+	"oauth-tutorial/internal/presentation/view"
+	"oauth-tutorial/internal/session"
+	"oauth-tutorial/internal/usecase/federation"
+	"oauth-tutorial/pkg/mylogger"
+	"strings"
+	"testing"
+)
+
+const testSessionID = session.SessionID("test-session-id")
+
+type mockStartUseCase struct {
+	redirectURL string
+	err         error
+}
+
+func (m *mockStartUseCase) Execute(ctx context.Context, sessionID session.SessionID, transactionID session.TransactionID, providerName string) (string, error) {
+	return m.redirectURL, m.err
+}
+
+type mockCompleteUseCase struct {
+	output federation.CompleteFederatedLoginOutput
+	err    error
+}
+
+func (m *mockCompleteUseCase) Execute(ctx context.Context, input *federation.CompleteFederatedLoginInput) (federation.CompleteFederatedLoginOutput, error) {
+	return m.output, m.err
+}
+
+// 呼び出されたセッションIDとトランザクションIDを記録する
+type mockResumer struct {
+	sessionID     session.SessionID
+	transactionID session.TransactionID
+}
+
+func (m *mockResumer) Resume(w http.ResponseWriter, r *http.Request, sessionID session.SessionID, transactionID session.TransactionID) {
+	m.sessionID, m.transactionID = sessionID, transactionID
+	w.WriteHeader(http.StatusOK)
+}
+
+func newTestHandler(t *testing.T, start IStartFederatedLoginUseCase, complete ICompleteFederatedLoginUseCase, resumer IAuthorizationResumer) *FederationHandler {
+	t.Helper()
+	renderer, err := view.NewRenderer("")
+	if err != nil {
+		t.Fatalf("Failed to create renderer: %v", err)
+	}
+	return NewFederationHandler(mylogger.NewMockLogger(), start, complete, resumer, renderer, newTestCSRFProtector())
+}
+
+func newTestCSRFProtector() *presentation.CSRFProtector {
+	return presentation.NewCSRFProtector([]byte("test-secret"))
+}
+
+func Test_上流のIdPでのログインの開始(t *testing.T) {
+	validToken := newTestCSRFProtector().Issue(testSessionID)
+	tests := []struct {
+		name             string
+		csrfToken        string
+		withCookie       bool
+		start            *mockStartUseCase
+		expectedStatus   int
+		expectedLocation string
+	}{
+		{name: "正常系", csrfToken: validToken, withCookie: true, start: &mockStartUseCase{redirectURL: "https://idp.example.com/authorize?state=abc"}, expectedStatus: http.StatusSeeOther, expectedLocation: "https://idp.example.com/authorize?state=abc"},
+		{name: "異常系 - セッションが無い", csrfToken: validToken, start: &mockStartUseCase{}, expectedStatus: http.StatusBadRequest},
+		{name: "異常系 - CSRFトークンが不正", csrfToken: "invalid", withCookie: true, start: &mockStartUseCase{}, expectedStatus: http.StatusForbidden},
+		{name: "異常系 - 存在しないIdP", csrfToken: validToken, withCookie: true, start: &mockStartUseCase{err: federation.ErrProviderNotFound}, expectedStatus: http.StatusBadRequest},
+		{name: "異常系 - IdPに接続できない", csrfToken: validToken, withCookie: true, start: &mockStartUseCase{err: federation.ErrProviderUnavailable}, expectedStatus: http.StatusBadGateway},
+		{name: "異常系 - 想定外のエラー", csrfToken: validToken, withCookie: true, start: &mockStartUseCase{err: errors.New("unexpected")}, expectedStatus: http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// given
+			handler := newTestHandler(t, tt.start, &mockCompleteUseCase{}, &mockResumer{})
+			form := url.Values{"provider": {"corp"}, "transaction_id": {"test-transaction-id"}, "csrf_token": {tt.csrfToken}}
+			req := httptest.NewRequest("POST", "/federation/login", strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if tt.withCookie {
+				req.AddCookie(&http.Cookie{Name: session.SessionIDCookieName, Value: string(testSessionID)})
+			}
+			rec := httptest.NewRecorder()
+
+			// when
+			handler.ServeLogin(rec, req)
+
+			// then
+			if rec.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d", tt.expectedStatus, rec.Code)
+			}
+			if rec.Header().Get("Location") != tt.expectedLocation {
+				t.Errorf("Expected location %q, got %q", tt.expectedLocation, rec.Header().Get("Location"))
+			}
+		})
+	}
+}
+
+func Test_上流のIdPからのコールバック(t *testing.T) {
+	tests := []struct {
+		name           string
+		query          string
+		complete       *mockCompleteUseCase
+		expectedStatus int
+		expectResume   bool
+	}{
+		{name: "正常系", query: "state=abc&code=xyz", complete: &mockCompleteUseCase{output: federation.NewCompleteFederatedLoginOutput("renewed-session-id", "test-transaction-id")}, expectedStatus: http.StatusOK, expectResume: true},
+		{name: "異常系 - codeが無い", query: "state=abc", complete: &mockCompleteUseCase{}, expectedStatus: http.StatusBadRequest},
+		{name: "異常系 - 不明なstate", query: "state=abc&code=xyz", complete: &mockCompleteUseCase{err: federation.ErrLoginStateNotFound}, expectedStatus: http.StatusBadRequest},
+		{name: "異常系 - IdPでのログインに失敗", query: "state=abc&error=access_denied", complete: &mockCompleteUseCase{err: federation.ErrUpstreamLoginFailed}, expectedStatus: http.StatusUnauthorized},
+		{name: "異常系 - 想定外のエラー", query: "state=abc&code=xyz", complete: &mockCompleteUseCase{err: errors.New("unexpected")}, expectedStatus: http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// given
+			resumer := &mockResumer{}
+			handler := newTestHandler(t, &mockStartUseCase{}, tt.complete, resumer)
+			req := httptest.NewRequest("GET", "/federation/callback?"+tt.query, nil)
+			req.AddCookie(&http.Cookie{Name: session.SessionIDCookieName, Value: string(testSessionID)})
+			rec := httptest.NewRecorder()
+
+			// when
+			handler.ServeCallback(rec, req)
+
+			// then
+			if rec.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d", tt.expectedStatus, rec.Code)
+			}
+			if !tt.expectResume {
+				if resumer.sessionID != "" {
+					t.Error("Expected authorization not to be resumed")
+				}
+				return
+			}
+			// 再生成したセッションIDをCookieに設定して、認可リクエストの処理を続ける
+			if resumer.sessionID != "renewed-session-id" || resumer.transactionID != "test-transaction-id" {
+				t.Errorf("Resume() called with %q, %q", resumer.sessionID, resumer.transactionID)
+			}
+			cookies := rec.Result().Cookies()
+			if len(cookies) != 1 || cookies[0].Value != "renewed-session-id" {
+				t.Errorf("Expected renewed session cookie, got %v", cookies)
+			}
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package metadata
+
+import (
+	"net/http"
+	"oauth-tutorial/internal/domain"
+	"oauth-tutorial/internal/presentation"
+	"oauth-tutorial/pkg/mylogger"
+	"strings"
+)
+
+// /.well-known/oauth-authorization-server で認可サーバーのメタデータを公開する RFC 8414 3
+type MetadataHandler struct {
+	logger mylogger.Logger
+	issuer string
+	// レルムのスコープの一覧
+	scopes *domain.ScopeProvider
+}
+
+func NewMetadataHandler(logger mylogger.Logger, issuer string, scopes *domain.ScopeProvider) *MetadataHandler {
+	return &MetadataHandler{logger: logger, issuer: issuer, scopes: scopes}
+}
+
+func (h *MetadataHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// エンドポイントはissuerを基準にする(issuerの末尾の/は除く)
+	base := strings.TrimSuffix(h.issuer, "/")
+	res := Response{
+		Issuer:                h.issuer,
+		AuthorizationEndpoint: base + "/authorize",
+		TokenEndpoint:         base + "/token",
+		// スコープの定義は設定の再読み込みで変わるため、リクエスト毎に取得する
+		ScopesSupported:        h.scopes.Current().Names(),
+		ResponseTypesSupported: []string{"code"},
+		ResponseModesSupported: []string{"query"},
+		GrantTypesSupported:    []string{domain.GrantTypeAuthorizationCode.String(), domain.GrantTypeRefreshToken.String()},
+		TokenEndpointAuthMethodsSupported: []string{
+			string(domain.AuthMethodClientSecretBasic),
+			string(domain.AuthMethodClientSecretPost),
+			string(domain.AuthMethodNone),
+		},
+		AuthorizationResponseIssParameterSupported: true,
+	}
+	if err := presentation.WriteJSONResponse(w, http.StatusOK, res); err != nil {
+		h.logger.Error("メタデータの書き込みに失敗しました。", "err", err)
+	}
+}
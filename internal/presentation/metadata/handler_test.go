@@ -0,0 +1,51 @@
+package metadata
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"oauth-tutorial/internal/domain"
+	"oauth-tutorial/pkg/mylogger"
+	"strings"
+	"testing"
+)
+
+func TestMetadataHandler_ServeHTTP(t *testing.T) {
+	logger := mylogger.NewMockLogger()
+
+	tests := []struct {
+		name         string
+		issuer       string
+		expectedBody string
+	}{
+		{
+			name:         "正常ケース",
+			issuer:       "https://auth.example.com",
+			expectedBody: `{"issuer":"https://auth.example.com","authorization_endpoint":"https://auth.example.com/authorize","token_endpoint":"https://auth.example.com/token","scopes_supported":["read","write"],"response_types_supported":["code"],"response_modes_supported":["query"],"grant_types_supported":["authorization_code","refresh_token"],"token_endpoint_auth_methods_supported":["client_secret_basic","client_secret_post","none"],"authorization_response_iss_parameter_supported":true}`,
+		},
+		{
+			name:         "正常ケース - パスを含むissuer",
+			issuer:       "https://example.com/auth/",
+			expectedBody: `{"issuer":"https://example.com/auth/","authorization_endpoint":"https://example.com/auth/authorize","token_endpoint":"https://example.com/auth/token","scopes_supported":["read","write"],"response_types_supported":["code"],"response_modes_supported":["query"],"grant_types_supported":["authorization_code","refresh_token"],"token_endpoint_auth_methods_supported":["client_secret_basic","client_secret_post","none"],"authorization_response_iss_parameter_supported":true}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := NewMetadataHandler(logger, tt.issuer, domain.NewScopeProvider(nil))
+			req := httptest.NewRequest("GET", "/.well-known/oauth-authorization-server", nil)
+			rr := httptest.NewRecorder()
+
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != http.StatusOK {
+				t.Errorf("expected status %d, got %d", http.StatusOK, rr.Code)
+			}
+			if contentType := rr.Header().Get("Content-Type"); contentType != "application/json" {
+				t.Errorf("expected Content-Type application/json, got %s", contentType)
+			}
+			if actual := strings.TrimSpace(rr.Body.String()); actual != tt.expectedBody {
+				t.Errorf("expected body %s, got %s", tt.expectedBody, actual)
+			}
+		})
+	}
+}
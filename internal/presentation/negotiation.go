@@ -0,0 +1,19 @@
+package presentation
+
+import (
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// ブラウザからのリクエスト(AcceptヘッダーにHTMLを含む)であればHTML画面を返す
+// それ以外のクライアントにはJSONを返す
+func WantsHTML(r *http.Request) bool {
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err == nil && mediaType == "text/html" {
+			return true
+		}
+	}
+	return false
+}
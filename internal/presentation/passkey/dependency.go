@@ -0,0 +1,28 @@
+package passkey
+
+import (
+	"net/http"
+	"oauth-tutorial/internal/session"
+	"oauth-tutorial/internal/usecase/passkey"
+)
+
+type IBeginPasskeyLoginUseCase interface {
+	Execute(sessionID session.SessionID, transactionID session.TransactionID) (passkey.PasskeyLoginOptions, error)
+}
+
+type ICompletePasskeyLoginUseCase interface {
+	Execute(input *passkey.CompletePasskeyLoginInput) (passkey.CompletePasskeyLoginOutput, error)
+}
+
+// ログインした後に認可リクエストの処理を続ける(/decisionのハンドラー)
+type IAuthorizationResumer interface {
+	Resume(w http.ResponseWriter, r *http.Request, sessionID session.SessionID, transactionID session.TransactionID)
+}
+
+type IRenderer interface {
+	Render(w http.ResponseWriter, statusCode int, name string, data any) error
+}
+
+type ICSRFVerifier interface {
+	Verify(sessionID session.SessionID, token string) bool
+}
@@ -0,0 +1,122 @@
+package passkey
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"oauth-tutorial/internal/presentation"
+	"oauth-tutorial/internal/presentation/view"
+	"oauth-tutorial/internal/session"
+	"oauth-tutorial/internal/usecase/passkey"
+	"oauth-tutorial/pkg/mylogger"
+)
+
+// ログイン画面からパスキー(WebAuthn)でログインするためのハンドラー
+type PasskeyHandler struct {
+	logger        mylogger.Logger
+	beginLogin    IBeginPasskeyLoginUseCase
+	completeLogin ICompletePasskeyLoginUseCase
+	resumer       IAuthorizationResumer
+	renderer      IRenderer
+	csrfVerifier  ICSRFVerifier
+}
+
+func NewPasskeyHandler(logger mylogger.Logger, beginLogin IBeginPasskeyLoginUseCase, completeLogin ICompletePasskeyLoginUseCase, resumer IAuthorizationResumer, renderer IRenderer, csrfVerifier ICSRFVerifier) *PasskeyHandler {
+	return &PasskeyHandler{logger: logger, beginLogin: beginLogin, completeLogin: completeLogin, resumer: resumer, renderer: renderer, csrfVerifier: csrfVerifier}
+}
+
+// POST /passkey/login/options: ログイン画面のスクリプトにチャレンジを発行する
+func (h *PasskeyHandler) ServeOptions(w http.ResponseWriter, r *http.Request) {
+	sessionID, ok := h.verifyRequest(r)
+	if !ok {
+		presentation.WriteJSONResponse(w, http.StatusForbidden, ErrorResponse{Message: "不正なリクエストです"})
+		return
+	}
+
+	options, err := h.beginLogin.Execute(sessionID, session.TransactionID(r.PostForm.Get("transaction_id")))
+	if err != nil {
+		if errors.Is(err, passkey.ErrTransactionNotFound) {
+			presentation.WriteJSONResponse(w, http.StatusBadRequest, ErrorResponse{Message: err.Error()})
+			return
+		}
+		h.logger.Error("Unexpected error occurred", "err", err)
+		presentation.WriteJSONResponse(w, http.StatusInternalServerError, ErrorResponse{Message: "予期しないエラーが発生しました"})
+		return
+	}
+	presentation.WriteJSONResponse(w, http.StatusOK, NewLoginOptionsResponse(options))
+}
+
+// POST /passkey/login: 認証器の署名を検証してログインし、認可リクエストの処理を続ける
+// 署名はログイン画面のスクリプトがbase64urlでエンコードしてフォームで送信する
+func (h *PasskeyHandler) ServeLogin(w http.ResponseWriter, r *http.Request) {
+	sessionID, ok := h.verifyRequest(r)
+	if !ok {
+		h.renderError(w, http.StatusForbidden, "不正なリクエストです。もう一度初めからやり直してください。")
+		return
+	}
+	input, err := newCompletePasskeyLoginInput(sessionID, r)
+	if err != nil {
+		h.logger.Info("Invalid passkey login request", "err", err)
+		h.renderError(w, http.StatusBadRequest, "パラメータの形式を確認してください。")
+		return
+	}
+
+	output, err := h.completeLogin.Execute(input)
+	if err != nil {
+		switch {
+		case errors.Is(err, passkey.ErrChallengeNotFound), errors.Is(err, passkey.ErrTransactionNotFound):
+			h.renderError(w, http.StatusBadRequest, "ログインの有効期限が切れたか、不正なリクエストです。もう一度初めからやり直してください。")
+		case errors.Is(err, passkey.ErrInvalidPasskey):
+			h.renderError(w, http.StatusUnauthorized, "パスキーでのログインに失敗しました。登録済みのパスキーを使っているか確認してください。")
+		default:
+			h.logger.Error("Unexpected error occurred", "err", err)
+			h.renderError(w, http.StatusInternalServerError, "サーバーエラーが発生しました。")
+		}
+		return
+	}
+
+	// ログインによって再生成したセッションIDをCookieに設定し、同意画面の表示または認可コードの発行に進む
+	presentation.SetSessionCookie(w, r, output.SessionID())
+	h.resumer.Resume(w, r, output.SessionID(), output.TransactionID())
+}
+
+// /decisionと同様に、他サイトからの送信とCSRFトークンを検証する
+func (h *PasskeyHandler) verifyRequest(r *http.Request) (session.SessionID, bool) {
+	if err := presentation.CheckSameOrigin(r); err != nil {
+		mylogger.SecurityEvent(h.logger, "cross_site_request_rejected", "path", r.URL.Path, "origin", r.Header.Get("Origin"), "secFetchSite", r.Header.Get("Sec-Fetch-Site"))
+		return "", false
+	}
+	if err := r.ParseForm(); err != nil {
+		h.logger.Info("Failed to parse form", "err", err)
+		return "", false
+	}
+	sessionID := presentation.SessionIDFromCookie(r)
+	if sessionID == "" {
+		h.logger.Info("Session not found", "path", r.URL.Path)
+		return "", false
+	}
+	if !h.csrfVerifier.Verify(sessionID, r.PostForm.Get(presentation.CSRFTokenFieldName)) {
+		mylogger.SecurityEvent(h.logger, "csrf_token_invalid", "path", r.URL.Path, "transactionID", r.PostForm.Get("transaction_id"))
+		return "", false
+	}
+	return sessionID, true
+}
+
+func newCompletePasskeyLoginInput(sessionID session.SessionID, r *http.Request) (*passkey.CompletePasskeyLoginInput, error) {
+	fields := []string{"credential_id", "client_data_json", "authenticator_data", "signature", "user_handle"}
+	values := make([][]byte, len(fields))
+	for i, field := range fields {
+		value, err := base64.RawURLEncoding.DecodeString(r.PostForm.Get(field))
+		if err != nil {
+			return nil, errors.New(field + " is not base64url encoded")
+		}
+		values[i] = value
+	}
+	return passkey.NewCompletePasskeyLoginInput(sessionID, values[0], values[1], values[2], values[3], values[4])
+}
+
+func (h *PasskeyHandler) renderError(w http.ResponseWriter, statusCode int, message string) {
+	if err := h.renderer.Render(w, statusCode, view.ErrorTemplate, view.ErrorPage{Message: message}); err != nil {
+		h.logger.Error("Failed to render error page", "err", err)
+	}
+}
@@ -0,0 +1,189 @@
+package passkey
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"oauth-tutorial/internal/presentation"
+	"oauth-tutorial/internal/presentation/view"
+	"oauth-tutorial/internal/session"
+	"oauth-tutorial/internal/usecase/passkey"
+	"oauth-tutorial/pkg/mylogger"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testSessionID = session.SessionID("test-session-id")
+
+type mockBeginUseCase struct {
+	options passkey.PasskeyLoginOptions
+	err     error
+}
+
+func (m *mockBeginUseCase) Execute(sessionID session.SessionID, transactionID session.TransactionID) (passkey.PasskeyLoginOptions, error) {
+	return m.options, m.err
+}
+
+// 受け取った入力を記録する
+type mockCompleteUseCase struct {
+	input  *passkey.CompletePasskeyLoginInput
+	output passkey.CompletePasskeyLoginOutput
+	err    error
+}
+
+func (m *mockCompleteUseCase) Execute(input *passkey.CompletePasskeyLoginInput) (passkey.CompletePasskeyLoginOutput, error) {
+	m.input = input
+	return m.output, m.err
+}
+
+// 呼び出されたセッションIDとトランザクションIDを記録する
+type mockResumer struct {
+	sessionID     session.SessionID
+	transactionID session.TransactionID
+}
+
+func (m *mockResumer) Resume(w http.ResponseWriter, r *http.Request, sessionID session.SessionID, transactionID session.TransactionID) {
+	m.sessionID, m.transactionID = sessionID, transactionID
+	w.WriteHeader(http.StatusOK)
+}
+
+func newTestHandler(t *testing.T, begin IBeginPasskeyLoginUseCase, complete ICompletePasskeyLoginUseCase, resumer IAuthorizationResumer) *PasskeyHandler {
+	t.Helper()
+	renderer, err := view.NewRenderer("")
+	if err != nil {
+		t.Fatalf("Failed to create renderer: %v", err)
+	}
+	return NewPasskeyHandler(mylogger.NewMockLogger(), begin, complete, resumer, renderer, newTestCSRFProtector())
+}
+
+func newTestCSRFProtector() *presentation.CSRFProtector {
+	return presentation.NewCSRFProtector([]byte("test-secret"))
+}
+
+func newFormRequest(target string, form url.Values, withCookie bool) *http.Request {
+	req := httptest.NewRequest("POST", target, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if withCookie {
+		req.AddCookie(&http.Cookie{Name: session.SessionIDCookieName, Value: string(testSessionID)})
+	}
+	return req
+}
+
+func Test_パスキーでのログインのオプション(t *testing.T) {
+	validToken := newTestCSRFProtector().Issue(testSessionID)
+	tests := []struct {
+		name           string
+		csrfToken      string
+		withCookie     bool
+		crossSite      bool
+		begin          *mockBeginUseCase
+		expectedStatus int
+	}{
+		{name: "正常系", csrfToken: validToken, withCookie: true, begin: &mockBeginUseCase{options: passkey.NewPasskeyLoginOptions("Y2hhbGxlbmdl", "auth.example.com", 5*time.Minute)}, expectedStatus: http.StatusOK},
+		{name: "異常系 - セッションが無い", csrfToken: validToken, begin: &mockBeginUseCase{}, expectedStatus: http.StatusForbidden},
+		{name: "異常系 - CSRFトークンが不正", csrfToken: "invalid", withCookie: true, begin: &mockBeginUseCase{}, expectedStatus: http.StatusForbidden},
+		{name: "異常系 - 他サイトからのリクエスト", csrfToken: validToken, withCookie: true, crossSite: true, begin: &mockBeginUseCase{}, expectedStatus: http.StatusForbidden},
+		{name: "異常系 - 存在しないトランザクション", csrfToken: validToken, withCookie: true, begin: &mockBeginUseCase{err: passkey.ErrTransactionNotFound}, expectedStatus: http.StatusBadRequest},
+		{name: "異常系 - 想定外のエラー", csrfToken: validToken, withCookie: true, begin: &mockBeginUseCase{err: errors.New("unexpected")}, expectedStatus: http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// given
+			handler := newTestHandler(t, tt.begin, &mockCompleteUseCase{}, &mockResumer{})
+			req := newFormRequest("/passkey/login/options", url.Values{"transaction_id": {"test-transaction-id"}, "csrf_token": {tt.csrfToken}}, tt.withCookie)
+			if tt.crossSite {
+				req.Header.Set("Sec-Fetch-Site", "cross-site")
+			}
+			rec := httptest.NewRecorder()
+
+			// when
+			handler.ServeOptions(rec, req)
+
+			// then
+			if rec.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d", tt.expectedStatus, rec.Code)
+			}
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+			var res LoginOptionsResponse
+			if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			expected := LoginOptionsResponse{Challenge: "Y2hhbGxlbmdl", RPID: "auth.example.com", Timeout: 300000, UserVerification: "required"}
+			if res != expected {
+				t.Errorf("response = %+v, want %+v", res, expected)
+			}
+		})
+	}
+}
+
+func Test_パスキーでのログイン(t *testing.T) {
+	validToken := newTestCSRFProtector().Issue(testSessionID)
+	validForm := url.Values{
+		"csrf_token":         {validToken},
+		"credential_id":      {"Y3JlZGVudGlhbA"},
+		"client_data_json":   {"e30"},
+		"authenticator_data": {"YXV0aGRhdGE"},
+		"signature":          {"c2lnbmF0dXJl"},
+		"user_handle":        {"dXNlci0x"},
+	}
+	tests := []struct {
+		name           string
+		modify         func(form url.Values)
+		complete       *mockCompleteUseCase
+		expectedStatus int
+		expectResume   bool
+	}{
+		{name: "正常系", complete: &mockCompleteUseCase{output: passkey.NewCompletePasskeyLoginOutput("renewed-session-id", "test-transaction-id")}, expectedStatus: http.StatusOK, expectResume: true},
+		{name: "異常系 - CSRFトークンが不正", modify: func(form url.Values) { form.Set("csrf_token", "invalid") }, complete: &mockCompleteUseCase{}, expectedStatus: http.StatusForbidden},
+		{name: "異常系 - base64urlでない署名", modify: func(form url.Values) { form.Set("signature", "c2lnbmF0dXJl+/") }, complete: &mockCompleteUseCase{}, expectedStatus: http.StatusBadRequest},
+		{name: "異常系 - 署名が無い", modify: func(form url.Values) { form.Del("signature") }, complete: &mockCompleteUseCase{}, expectedStatus: http.StatusBadRequest},
+		{name: "異常系 - 不明なチャレンジ", complete: &mockCompleteUseCase{err: passkey.ErrChallengeNotFound}, expectedStatus: http.StatusBadRequest},
+		{name: "異常系 - 署名の検証に失敗", complete: &mockCompleteUseCase{err: passkey.ErrInvalidPasskey}, expectedStatus: http.StatusUnauthorized},
+		{name: "異常系 - 想定外のエラー", complete: &mockCompleteUseCase{err: errors.New("unexpected")}, expectedStatus: http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// given
+			resumer := &mockResumer{}
+			handler := newTestHandler(t, &mockBeginUseCase{}, tt.complete, resumer)
+			form := url.Values{}
+			for k, v := range validForm {
+				form[k] = v
+			}
+			if tt.modify != nil {
+				tt.modify(form)
+			}
+			rec := httptest.NewRecorder()
+
+			// when
+			handler.ServeLogin(rec, newFormRequest("/passkey/login", form, true))
+
+			// then
+			if rec.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d", tt.expectedStatus, rec.Code)
+			}
+			if !tt.expectResume {
+				if resumer.sessionID != "" {
+					t.Error("Expected authorization not to be resumed")
+				}
+				return
+			}
+			// 再生成したセッションIDをCookieに設定して、認可リクエストの処理を続ける
+			if resumer.sessionID != "renewed-session-id" || resumer.transactionID != "test-transaction-id" {
+				t.Errorf("Resume() called with %q, %q", resumer.sessionID, resumer.transactionID)
+			}
+			cookies := rec.Result().Cookies()
+			if len(cookies) != 1 || cookies[0].Value != "renewed-session-id" {
+				t.Errorf("Expected renewed session cookie, got %v", cookies)
+			}
+			if tt.complete.input == nil {
+				t.Error("Expected the assertion to be verified")
+			}
+		})
+	}
+}
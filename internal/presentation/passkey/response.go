@@ -0,0 +1,27 @@
+package passkey
+
+import "oauth-tutorial/internal/usecase/passkey"
+
+// navigator.credentials.getのpublicKeyに渡すオプション WebAuthn Level 2 5.5
+// バイナリの値はbase64urlでエンコードし、画面のスクリプトでデコードする
+type LoginOptionsResponse struct {
+	Challenge string `json:"challenge"`
+	RPID      string `json:"rpId"`
+	// ミリ秒
+	Timeout          int64  `json:"timeout"`
+	UserVerification string `json:"userVerification"`
+}
+
+type ErrorResponse struct {
+	Message string `json:"message"`
+}
+
+// 本人の確認(生体認証やPIN)を必須にする。確認できない認証器ではログインできない
+func NewLoginOptionsResponse(options passkey.PasskeyLoginOptions) LoginOptionsResponse {
+	return LoginOptionsResponse{
+		Challenge:        options.Challenge(),
+		RPID:             options.RPID(),
+		Timeout:          options.Timeout().Milliseconds(),
+		UserVerification: "required",
+	}
+}
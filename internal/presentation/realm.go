@@ -0,0 +1,88 @@
+package presentation
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+)
+
+const (
+	// パスで選択するレルムのプレフィックス。/realms/{name}/authorize のようにアクセスする
+	RealmPathPrefix = "/realms/"
+	// RFC 8414 3.1 パスを含むissuerのメタデータは、パスの前に/.well-known/oauth-authorization-serverを挿入したURLで公開する
+	MetadataPath = "/.well-known/oauth-authorization-server"
+)
+
+type basePathKey struct{}
+
+// パスのプレフィックスで選択したレルムの場合はプレフィックス(例: /realms/sales)、それ以外は空文字
+func BasePath(r *http.Request) string {
+	basePath, _ := r.Context().Value(basePathKey{}).(string)
+	return basePath
+}
+
+// リクエストをレルム毎のハンドラーに振り分ける
+// ホスト名で選択するレルム、パスのプレフィックスで選択するレルムの順に探し、どちらでもない場合は既定のレルムで処理する
+type RealmRouter struct {
+	fallback http.Handler
+	byName   map[string]http.Handler
+	byHost   map[string]http.Handler
+}
+
+// fallbackは既定のレルムのハンドラー
+func NewRealmRouter(fallback http.Handler) *RealmRouter {
+	return &RealmRouter{fallback: fallback, byName: map[string]http.Handler{}, byHost: map[string]http.Handler{}}
+}
+
+// レルムのハンドラーを登録する。hostが空の場合はパスのプレフィックスでのみ選択する
+func (rt *RealmRouter) Add(name string, host string, handler http.Handler) {
+	rt.byName[name] = handler
+	if host != "" {
+		rt.byHost[strings.ToLower(host)] = handler
+	}
+}
+
+func (rt *RealmRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// ホスト名で選択したレルムでは、他のレルムのパスを受け付けない
+	if handler, ok := rt.byHost[requestHostname(r)]; ok {
+		handler.ServeHTTP(w, r)
+		return
+	}
+
+	path := r.URL.Path
+	wellKnown := strings.HasPrefix(path, MetadataPath+RealmPathPrefix)
+	if wellKnown {
+		path = strings.TrimPrefix(path, MetadataPath)
+	}
+	if !strings.HasPrefix(path, RealmPathPrefix) {
+		rt.fallback.ServeHTTP(w, r)
+		return
+	}
+	name, rest, _ := strings.Cut(strings.TrimPrefix(path, RealmPathPrefix), "/")
+	handler, ok := rt.byName[name]
+	if !ok || (wellKnown && rest != "") {
+		http.NotFound(w, r)
+		return
+	}
+	basePath := RealmPathPrefix + name
+	if wellKnown {
+		rest = strings.TrimPrefix(MetadataPath, "/")
+	}
+
+	// レルムのハンドラーにはプレフィックスを除いたパスを渡す
+	r2 := r.WithContext(context.WithValue(r.Context(), basePathKey{}, basePath))
+	u := *r.URL
+	u.Path = "/" + rest
+	u.RawPath = ""
+	r2.URL = &u
+	handler.ServeHTTP(w, r2)
+}
+
+func requestHostname(r *http.Request) string {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(host)
+}
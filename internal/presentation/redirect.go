@@ -0,0 +1,24 @@
+package presentation
+
+import (
+	"net/url"
+)
+
+// リダイレクトURIにパラメータを付与したURIを返す。空の値のパラメータは付与しない
+// 登録済みのリダイレクトURIがクエリを含む場合は、そのクエリも残す RFC 6749 3.1.2
+func AppendQuery(redirectURI string, params url.Values) string {
+	u, err := url.Parse(redirectURI)
+	if err != nil {
+		return redirectURI
+	}
+	query := u.Query()
+	for name, values := range params {
+		for _, value := range values {
+			if value != "" {
+				query.Add(name, value)
+			}
+		}
+	}
+	u.RawQuery = query.Encode()
+	return u.String()
+}
@@ -0,0 +1,33 @@
+package presentation
+
+import (
+	"net/http"
+	"oauth-tutorial/internal/session"
+)
+
+// ブラウザセッションのIDをCookieに設定する
+// パスで選択したレルムでは、他のレルムにCookieを送信しないようレルムのパスに限定する
+func SetSessionCookie(w http.ResponseWriter, r *http.Request, sessionID session.SessionID) {
+	path := BasePath(r)
+	if path == "" {
+		path = "/"
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     session.SessionIDCookieName,
+		Value:    string(sessionID),
+		Path:     path,
+		HttpOnly: true,
+		Secure:   true,
+		// 他サイトからのPOSTにセッションCookieを送信しない。外部サイトからの/authorizeへの遷移(GET)では送信する
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// CookieからブラウザセッションのIDを取得する。存在しない場合は空文字を返す
+func SessionIDFromCookie(r *http.Request) session.SessionID {
+	cookie, err := r.Cookie(session.SessionIDCookieName)
+	if err != nil {
+		return ""
+	}
+	return session.SessionID(cookie.Value)
+}
@@ -1,6 +1,7 @@
 package token
 
 import (
+	"errors"
 	"net/http"
 	"oauth-tutorial/internal/domain"
 	"oauth-tutorial/internal/presentation"
@@ -9,6 +10,7 @@ import (
 	"oauth-tutorial/internal/usecase/token/refreshtokenflow"
 	"oauth-tutorial/pkg/mylogger"
 	"strings"
+	"time"
 )
 
 type TokenHandler struct {
@@ -45,10 +47,10 @@ func (h *TokenHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// client認証パラメータの解決(クライアント認証をしないクライアントのことを考慮し、この時点ではclient_id, client_secretの空値を許容する)
-	clientID, clientSecret := resolveClientAuthenticationParameters(r)
+	clientID, clientSecret, authMethod := resolveClientAuthenticationParameters(r)
 
 	// トークン発行フローに応じてinputを解決する
-	input := resolveInput(r, grantType, clientID, clientSecret)
+	input := resolveInput(r, grantType, clientID, clientSecret, authMethod)
 
 	// トークン発行フローを実行する
 	accessToken, refreshToken, err := interactor.Execute(input)
@@ -57,22 +59,28 @@ func (h *TokenHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	presentation.WriteJSONResponse(w, http.StatusOK, SuccessResponse{
-		AccessToken:  accessToken.Value(),
-		RefreshToken: refreshToken.Value(),
-		TokenType:    "Bearer",
-		ExpiresIn:    int(domain.AccessTokenDuration.Minutes()),
-	})
+	response := SuccessResponse{
+		AccessToken: accessToken.Value(),
+		TokenType:   "Bearer",
+		ExpiresIn:   int(accessToken.ExpiresIn(time.Now())),
+		Scope:       domain.FormatScope(accessToken.Scopes()),
+	}
+	// リフレッシュトークンを発行しないクライアントの場合は含めない
+	if refreshToken != nil {
+		response.RefreshToken = refreshToken.Value()
+	}
+	presentation.WriteJSONResponse(w, http.StatusOK, response)
 }
 
-func resolveClientAuthenticationParameters(r *http.Request) (clientID string, clientSecret string) {
+// クライアント認証のパラメータと、使われた認証方式を返す
+func resolveClientAuthenticationParameters(r *http.Request) (clientID string, clientSecret string, authMethod domain.TokenEndpointAuthMethod) {
 	// Basic認証のケース
 	authorizationHeader := r.Header.Get("Authorization")
 	if strings.HasPrefix(authorizationHeader, "Basic ") {
 		// Authorizationヘッダーからクレデンシャルを取得
 		clientID, clientSecret, _ := r.BasicAuth()
 		if clientID != "" && clientSecret != "" {
-			return clientID, clientSecret
+			return clientID, clientSecret, domain.AuthMethodClientSecretBasic
 		}
 	}
 
@@ -80,38 +88,40 @@ func resolveClientAuthenticationParameters(r *http.Request) (clientID string, cl
 	clientID = r.PostFormValue("client_id")
 	clientSecret = r.PostFormValue("client_secret")
 	if clientID != "" && clientSecret != "" {
-		return clientID, clientSecret
+		return clientID, clientSecret, domain.AuthMethodClientSecretPost
 	}
 
 	// クライアント認証をしないクライアントのケース
 	// クライアント認証をしない場合でも、client_idは必須なので、client_idのみ返す
 	if clientID != "" {
-		return clientID, ""
+		return clientID, "", domain.AuthMethodNone
 	}
 
-	return "", ""
+	return "", "", domain.AuthMethodNone
 }
 
-func resolveInput(r *http.Request, grantType domain.GrantType, clientID string, clientSecret string) any {
-	redirectURI := r.FormValue("redirect_uri")
-	if redirectURI == "" {
-		return nil
-	}
+func resolveInput(r *http.Request, grantType domain.GrantType, clientID string, clientSecret string, authMethod domain.TokenEndpointAuthMethod) any {
+	// scopeは任意。指定された場合は認可されたスコープより狭いスコープのトークンを発行する
+	scopes := domain.ParseScope(r.FormValue("scope"))
 
 	// grantTypeに応じてinputを解決する
 	switch grantType {
 	case domain.GrantTypeAuthorizationCode:
+		redirectURI := r.FormValue("redirect_uri")
+		if redirectURI == "" {
+			return nil
+		}
 		code := r.FormValue("code")
 		if strings.TrimSpace(code) == "" {
 			return nil
 		}
-		return authorizationcodeflow.NewAuthorizationCodeInput(clientID, clientSecret, code, redirectURI)
+		return authorizationcodeflow.NewAuthorizationCodeInput(clientID, clientSecret, authMethod, code, redirectURI, scopes)
 	case domain.GrantTypeRefreshToken:
 		refreshToken := r.FormValue("refresh_token")
 		if strings.TrimSpace(refreshToken) == "" {
 			return nil
 		}
-		return refreshtokenflow.NewRefreshTokenInput(clientID, clientSecret, refreshToken, redirectURI)
+		return refreshtokenflow.NewRefreshTokenInput(clientID, clientSecret, authMethod, refreshToken, scopes)
 	default:
 		return nil
 	}
@@ -121,6 +131,12 @@ func resolveInput(r *http.Request, grantType domain.GrantType, clientID string,
 // 本当はパターンマッチしたいが。。。
 func handleError(w http.ResponseWriter, err error, logger mylogger.Logger) {
 	if err != nil {
+		// スコープのエラーはどのスコープが不正かを返す
+		var scopeErr *domain.InvalidScopeError
+		if errors.As(err, &scopeErr) {
+			presentation.WriteJSONResponse(w, http.StatusBadRequest, NewErrorResponse(InvalidScope, scopeErr.Error()))
+			return
+		}
 		switch err {
 		// 認可コードフローのエラーハンドリング
 		case authorizationcodeflow.ErrInvalidInputType:
@@ -135,6 +151,9 @@ func handleError(w http.ResponseWriter, err error, logger mylogger.Logger) {
 		case authorizationcodeflow.ErrAuthorizationCodeNotFound:
 			presentation.WriteJSONResponse(w, http.StatusBadRequest, NewErrorResponse(InvalidGrant, "codeが不正です。"))
 			return
+		case authorizationcodeflow.ErrAuthorizationCodeReused:
+			presentation.WriteJSONResponse(w, http.StatusBadRequest, NewErrorResponse(InvalidGrant, "codeが不正です。"))
+			return
 		case authorizationcodeflow.ErrInvalidClientID:
 			presentation.WriteJSONResponse(w, http.StatusBadRequest, NewErrorResponse(InvalidGrant, "codeが不正です。"))
 			return
@@ -144,6 +163,37 @@ func handleError(w http.ResponseWriter, err error, logger mylogger.Logger) {
 		case authorizationcodeflow.ErrAuthorizationCodeExpired:
 			presentation.WriteJSONResponse(w, http.StatusBadRequest, NewErrorResponse(InvalidGrant, "codeの有効期限が切れています。"))
 			return
+		case authorizationcodeflow.ErrInvalidScope:
+			presentation.WriteJSONResponse(w, http.StatusBadRequest, NewErrorResponse(InvalidScope, "認可されていないscopeが含まれています。"))
+			return
+		case authorizationcodeflow.ErrUnauthorizedClient:
+			presentation.WriteJSONResponse(w, http.StatusBadRequest, NewErrorResponse(UnauthorizedClient, "このクライアントには許可されていないgrant_typeです。"))
+			return
+		// リフレッシュトークンフローのエラーハンドリング
+		case refreshtokenflow.ErrInvalidInputType:
+			presentation.WriteJSONResponse(w, http.StatusBadRequest, NewErrorResponse(InvalidRequest, "リクエストのパラメータが不正です。"))
+			return
+		case refreshtokenflow.ErrClientNotFound:
+			presentation.WriteJSONResponse(w, http.StatusUnauthorized, NewErrorResponse(InvalidClient, "該当するクライアントが見つかりません。"))
+			return
+		case refreshtokenflow.ErrInvalidClientCredential:
+			presentation.WriteJSONResponse(w, http.StatusUnauthorized, NewErrorResponse(InvalidClient, "該当するクライアントが見つかりません。"))
+			return
+		case refreshtokenflow.ErrRefreshTokenNotFound:
+			presentation.WriteJSONResponse(w, http.StatusBadRequest, NewErrorResponse(InvalidGrant, "refresh_tokenが不正です。"))
+			return
+		case refreshtokenflow.ErrInvalidClientID:
+			presentation.WriteJSONResponse(w, http.StatusBadRequest, NewErrorResponse(InvalidGrant, "refresh_tokenが不正です。"))
+			return
+		case refreshtokenflow.ErrRefreshTokenExpired:
+			presentation.WriteJSONResponse(w, http.StatusBadRequest, NewErrorResponse(InvalidGrant, "refresh_tokenの有効期限が切れています。"))
+			return
+		case refreshtokenflow.ErrInvalidScope:
+			presentation.WriteJSONResponse(w, http.StatusBadRequest, NewErrorResponse(InvalidScope, "認可されていないscopeが含まれています。"))
+			return
+		case refreshtokenflow.ErrUnauthorizedClient:
+			presentation.WriteJSONResponse(w, http.StatusBadRequest, NewErrorResponse(UnauthorizedClient, "このクライアントには許可されていないgrant_typeです。"))
+			return
 		case utoken.ErrNoMatchingStrategyFound:
 			presentation.WriteJSONResponse(w, http.StatusBadRequest, NewErrorResponse(ServerError, "サーバーエラーが発生しました。"))
 			return
@@ -0,0 +1,115 @@
+package view
+
+import (
+	"html/template"
+	"oauth-tutorial/internal/domain"
+)
+
+// ログイン画面
+type LoginPage struct {
+	TransactionID string
+	CSRFToken     string
+	Message       string
+	// 上流のIdPでログインするボタン
+	Providers []LoginProvider
+}
+
+// ログイン画面に表示する上流のIdP
+type LoginProvider struct {
+	Name        string
+	DisplayName string
+}
+
+// パスワードの後にTOTPのコードを入力する画面
+type OTPPage struct {
+	TransactionID string
+	CSRFToken     string
+	Message       string
+}
+
+// 同意画面
+type ConsentPage struct {
+	TransactionID string
+	CSRFToken     string
+	ClientName    string
+	Scopes        []ScopeItem
+}
+
+type ScopeItem struct {
+	Name        string
+	DisplayName string
+	Description string
+	// 重要度の高いスコープは同意画面で強調する
+	HighSensitivity bool
+}
+
+// アカウント画面(連携中のアプリの一覧)
+type AccountPage struct {
+	CSRFToken string
+	Message   string
+	Apps      []AccountApp
+}
+
+type AccountApp struct {
+	ClientID      string
+	ClientName    string
+	Scopes        []string
+	GrantedAt     string
+	ExpiresAt     string
+	FirstUsedAt   string
+	LastUsedAt    string
+	RefreshTokens []AccountRefreshToken
+}
+
+type AccountRefreshToken struct {
+	Scopes    []string
+	IssuedAt  string
+	ExpiresAt string
+}
+
+// TOTPの登録画面
+// 登録の開始前・認証アプリへの登録と最初のコードの確認・リカバリーコードの表示・登録済みの状態を1つの画面で表示する
+type TOTPPage struct {
+	CSRFToken string
+	Message   string
+	// 確認済みのTOTPを登録しているかどうか
+	Enabled bool
+	// 登録済みの場合の未使用のリカバリーコードの数
+	RemainingRecoveryCodes int
+	// 認証アプリへの登録を始めた場合のみ設定する。ProvisioningURIはQRコードにする内容
+	// otpauthスキームのリンクにするため、template.URLで渡す
+	ProvisioningURI template.URL
+	Secret          string
+	// 最初のコードを確認した直後のみ設定する。この画面以外では再表示できない
+	RecoveryCodes []string
+}
+
+// パスキーの登録画面(登録済みのパスキーの一覧と登録ボタン)
+type PasskeysPage struct {
+	CSRFToken string
+	Message   string
+	Passkeys  []PasskeyItem
+}
+
+type PasskeyItem struct {
+	Name       string
+	CreatedAt  string
+	LastUsedAt string
+	// 複数の端末に同期されるパスキーかどうか
+	Synced bool
+}
+
+// エラー画面
+type ErrorPage struct {
+	Message string
+}
+
+// registryはレルムのスコープの一覧。スコープの説明に使う
+func NewConsentPage(transactionID string, csrfToken string, clientName string, scopes []string, registry *domain.ScopeRegistry) ConsentPage {
+	items := make([]ScopeItem, 0, len(scopes))
+	for _, scope := range scopes {
+		d := registry.Describe(scope)
+		items = append(items, ScopeItem{Name: scope, DisplayName: d.DisplayName, Description: d.Description, HighSensitivity: d.Sensitivity == domain.ScopeSensitivityHigh})
+	}
+	return ConsentPage{TransactionID: transactionID, CSRFToken: csrfToken, ClientName: clientName, Scopes: items}
+}
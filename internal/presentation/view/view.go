@@ -0,0 +1,79 @@
+package view
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+)
+
+// バイナリに埋め込んだ既定のテンプレート
+//
+//go:embed templates/*.html
+var embeddedTemplates embed.FS
+
+const (
+	LoginTemplate    = "login.html"
+	OTPTemplate      = "otp.html"
+	ConsentTemplate  = "consent.html"
+	AccountTemplate  = "account.html"
+	TOTPTemplate     = "totp.html"
+	PasskeysTemplate = "passkeys.html"
+	ErrorTemplate    = "error.html"
+)
+
+var templateNames = []string{LoginTemplate, OTPTemplate, ConsentTemplate, AccountTemplate, TOTPTemplate, PasskeysTemplate, ErrorTemplate}
+
+// HTML画面を描画する
+type Renderer struct {
+	templates map[string]*template.Template
+}
+
+// overrideDirに同名のテンプレートファイルがあれば、埋め込みのテンプレートの代わりに使用する
+// overrideDirが空の場合は埋め込みのテンプレートのみを使用する
+func NewRenderer(overrideDir string) (*Renderer, error) {
+	embedded, err := fs.Sub(embeddedTemplates, "templates")
+	if err != nil {
+		return nil, err
+	}
+	var override fs.FS
+	if overrideDir != "" {
+		override = os.DirFS(overrideDir)
+	}
+
+	templates := make(map[string]*template.Template, len(templateNames))
+	for _, name := range templateNames {
+		fsys := embedded
+		if override != nil {
+			_, err := fs.Stat(override, name)
+			switch {
+			case err == nil:
+				fsys = override
+			case !errors.Is(err, fs.ErrNotExist):
+				return nil, fmt.Errorf("failed to stat template %s: %w", path.Join(overrideDir, name), err)
+			}
+		}
+		tmpl, err := template.ParseFS(fsys, name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse template %s: %w", name, err)
+		}
+		templates[name] = tmpl
+	}
+	return &Renderer{templates: templates}, nil
+}
+
+func (r *Renderer) Render(w http.ResponseWriter, statusCode int, name string, data any) error {
+	tmpl, ok := r.templates[name]
+	if !ok {
+		return fmt.Errorf("template %s not found", name)
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	w.WriteHeader(statusCode)
+	return tmpl.Execute(w, data)
+}
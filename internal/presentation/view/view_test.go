@@ -0,0 +1,152 @@
+package view
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"oauth-tutorial/internal/domain"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_埋め込みテンプレートの描画(t *testing.T) {
+	renderer, err := NewRenderer("")
+	if err != nil {
+		t.Fatalf("NewRenderer() error = %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		template string
+		data     any
+		expected []string
+	}{
+		{
+			name:     "ログイン画面",
+			template: LoginTemplate,
+			data:     LoginPage{TransactionID: "tx-1", CSRFToken: "csrf-1", Message: "ログインしてください"},
+			expected: []string{`action="decision"`, `name="login_id"`, `name="password"`, `value="tx-1"`, `name="csrf_token" value="csrf-1"`, "ログインしてください", `action="passkey/login"`, `"passkey/login/options"`},
+		},
+		{
+			name:     "確認コードの入力画面",
+			template: OTPTemplate,
+			data:     OTPPage{TransactionID: "tx-1", CSRFToken: "csrf-1", Message: "確認コードが正しくありません"},
+			expected: []string{`action="decision"`, `name="otp"`, `value="tx-1"`, `name="csrf_token" value="csrf-1"`, "確認コードが正しくありません"},
+		},
+		{
+			name:     "TOTPの登録画面 - 登録前",
+			template: TOTPTemplate,
+			data:     TOTPPage{CSRFToken: "csrf-1"},
+			expected: []string{`action="totp"`, `name="csrf_token" value="csrf-1"`},
+		},
+		{
+			name:     "TOTPの登録画面 - 最初のコードの確認",
+			template: TOTPTemplate,
+			data:     TOTPPage{CSRFToken: "csrf-1", ProvisioningURI: "otpauth://totp/example:user?secret=ABC", Secret: "ABC"},
+			expected: []string{`href="otpauth://totp/example:user?secret=ABC"`, `action="totp/confirm"`, `name="otp"`, "シークレット: <code>ABC</code>"},
+		},
+		{
+			name:     "TOTPの登録画面 - リカバリーコード",
+			template: TOTPTemplate,
+			data:     TOTPPage{Enabled: true, RecoveryCodes: []string{"AAAA-BBBB", "CCCC-DDDD"}},
+			expected: []string{"<code>AAAA-BBBB</code>", "<code>CCCC-DDDD</code>"},
+		},
+		{
+			name:     "TOTPの登録画面 - 登録済み",
+			template: TOTPTemplate,
+			data:     TOTPPage{Enabled: true, RemainingRecoveryCodes: 9},
+			expected: []string{"2段階認証は有効です", "9個"},
+		},
+		{
+			name:     "パスキーの登録画面",
+			template: PasskeysTemplate,
+			data:     PasskeysPage{CSRFToken: "csrf-1", Passkeys: []PasskeyItem{{Name: "<b>ノートPC</b>", CreatedAt: "2026-01-02T03:04:05Z", Synced: true}}},
+			expected: []string{`action="passkeys"`, `name="csrf_token" value="csrf-1"`, `"passkeys/options"`, "&lt;b&gt;ノートPC&lt;/b&gt; (同期されるパスキー)", "最後に使用: 未使用"},
+		},
+		{
+			name:     "パスキーの登録画面 - 未ログイン",
+			template: PasskeysTemplate,
+			data:     PasskeysPage{Message: "ログインしてください"},
+			expected: []string{"ログインしてください", "登録済みのパスキーはありません"},
+		},
+		{
+			name:     "同意画面",
+			template: ConsentTemplate,
+			data:     NewConsentPage("tx-1", "csrf-1", "<script>client</script>", []string{"read", "custom"}, domain.DefaultScopeRegistry()),
+			expected: []string{`action="decision"`, `value="tx-1"`, `name="csrf_token" value="csrf-1"`, "&lt;script&gt;client&lt;/script&gt;", `value="read"`, "参照: データの参照", `value="custom"`},
+		},
+		{
+			name:     "同意画面 - 重要なスコープ",
+			template: ConsentTemplate,
+			data:     ConsentPage{TransactionID: "tx-1", Scopes: []ScopeItem{{Name: "admin", DisplayName: "管理", Description: "全ての操作", HighSensitivity: true}}},
+			expected: []string{`value="admin"`, "<strong>[重要]</strong> 管理: 全ての操作"},
+		},
+		{
+			name:     "エラー画面",
+			template: ErrorTemplate,
+			data:     ErrorPage{Message: "エラーです"},
+			expected: []string{"エラーです"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			if err := renderer.Render(rr, http.StatusOK, tt.template, tt.data); err != nil {
+				t.Fatalf("Render() error = %v", err)
+			}
+			if ct := rr.Header().Get("Content-Type"); ct != "text/html; charset=utf-8" {
+				t.Errorf("Content-Type = %v, want text/html; charset=utf-8", ct)
+			}
+			for _, expected := range tt.expected {
+				if !strings.Contains(rr.Body.String(), expected) {
+					t.Errorf("expected body to contain %q, got %s", expected, rr.Body.String())
+				}
+			}
+		})
+	}
+}
+
+func Test_テンプレートの差し替え(t *testing.T) {
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, LoginTemplate), []byte(`<p>custom login {{.TransactionID}}</p>`), 0o600)
+	if err != nil {
+		t.Fatalf("Failed to write template: %v", err)
+	}
+
+	renderer, err := NewRenderer(dir)
+	if err != nil {
+		t.Fatalf("NewRenderer() error = %v", err)
+	}
+
+	// 差し替えたテンプレートを使用すること
+	rr := httptest.NewRecorder()
+	if err := renderer.Render(rr, http.StatusOK, LoginTemplate, LoginPage{TransactionID: "tx-1"}); err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+	if body := rr.Body.String(); body != "<p>custom login tx-1</p>" {
+		t.Errorf("body = %q, want custom template", body)
+	}
+
+	// 差し替えていないテンプレートは埋め込みのものを使用すること
+	rr = httptest.NewRecorder()
+	if err := renderer.Render(rr, http.StatusOK, ErrorTemplate, ErrorPage{Message: "エラーです"}); err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+	if !strings.Contains(rr.Body.String(), "<!DOCTYPE html>") {
+		t.Errorf("expected embedded template, got %s", rr.Body.String())
+	}
+}
+
+func Test_不正なテンプレートの差し替え(t *testing.T) {
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, ConsentTemplate), []byte(`{{.Broken`), 0o600)
+	if err != nil {
+		t.Fatalf("Failed to write template: %v", err)
+	}
+
+	if _, err := NewRenderer(dir); err == nil {
+		t.Error("NewRenderer() should fail with a broken template")
+	}
+}
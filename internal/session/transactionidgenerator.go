@@ -0,0 +1,18 @@
+package session
+
+import (
+	"github.com/google/uuid"
+)
+
+// 認可リクエスト毎に払い出すトランザクションのID
+// ブラウザセッション(SessionID)とは別に、1つのセッションで複数の認可リクエストを並行して扱えるようにする
+type TransactionID string
+type TransactionIDGenerator struct{}
+
+func NewTransactionIDGenerator() *TransactionIDGenerator {
+	return &TransactionIDGenerator{}
+}
+
+func (g *TransactionIDGenerator) Generate() TransactionID {
+	return TransactionID(uuid.New().String())
+}
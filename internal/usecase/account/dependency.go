@@ -0,0 +1,60 @@
+package account
+
+import (
+	"oauth-tutorial/internal/domain"
+	inf_dto "oauth-tutorial/internal/infrastructure/dto"
+	"oauth-tutorial/internal/infrastructure/webauthn"
+	"oauth-tutorial/internal/session"
+	"time"
+)
+
+type ISessionStorage interface {
+	Get(sessionID session.SessionID) (*inf_dto.SessionData, error)
+}
+
+type IClientRepository interface {
+	SelectByClientID(clientID domain.ClientID) (*domain.Client, error)
+}
+
+type IConsentRepository interface {
+	FindByUserID(userID string) ([]*domain.Consent, error)
+	FindByUserAndClient(userID string, clientID string) (*domain.Consent, error)
+	Delete(userID string, clientID string) error
+}
+
+type ITokenRepository interface {
+	FindRefreshTokensByUser(userID string, now time.Time) ([]*domain.RefreshToken, error)
+	RevokeByUserAndClient(userID string, clientID string) (int, error)
+}
+
+type IAuthCodeRepository interface {
+	DeleteByUserAndClient(userID string, clientID string) (int, error)
+}
+
+type ITOTPRepository interface {
+	Save(credential *domain.TOTPCredential) error
+	FindByUserID(userID string) (*domain.TOTPCredential, error)
+}
+
+type IRandomGenerator interface {
+	GenerateRandomBytes(n int) []byte
+}
+
+type IPasskeyRepository interface {
+	Save(credential *domain.PasskeyCredential) error
+	FindByCredentialID(credentialID []byte) (*domain.PasskeyCredential, error)
+	FindByUserID(userID string) ([]*domain.PasskeyCredential, error)
+}
+
+type IPasskeyChallengeStorage interface {
+	Save(challenge *inf_dto.PasskeyChallenge) error
+	Consume(challenge string) (*inf_dto.PasskeyChallenge, error)
+}
+
+// WebAuthnのRelying Party。ブラウザから受け取った登録の結果を検証する
+type IPasskeyRelyingParty interface {
+	ID() string
+	Name() string
+	Challenge(clientDataJSON []byte) (string, error)
+	VerifyRegistration(clientDataJSON []byte, attestationObject []byte, challenge string) (webauthn.Registration, error)
+}
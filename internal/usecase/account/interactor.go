@@ -0,0 +1,194 @@
+package account
+
+import (
+	"errors"
+	"oauth-tutorial/internal/domain"
+	"oauth-tutorial/internal/infrastructure"
+	"oauth-tutorial/internal/session"
+	"oauth-tutorial/pkg/mylogger"
+	"slices"
+	"time"
+)
+
+var (
+	ErrLoginRequired        = errors.New("login required")
+	ErrConnectedAppNotFound = errors.New("connected app not found")
+	ErrUnexpected           = errors.New("unexpected error occurred")
+)
+
+// 連携中のアプリの一覧を取得するユースケース
+type ListConnectedAppsUseCase struct {
+	logger            mylogger.Logger
+	sessionStore      ISessionStorage
+	clientRepository  IClientRepository
+	consentRepository IConsentRepository
+	tokenRepository   ITokenRepository
+}
+
+func NewListConnectedAppsUseCase(logger mylogger.Logger, ss ISessionStorage, cr IClientRepository, csr IConsentRepository, tr ITokenRepository) *ListConnectedAppsUseCase {
+	return &ListConnectedAppsUseCase{
+		logger:            logger,
+		sessionStore:      ss,
+		clientRepository:  cr,
+		consentRepository: csr,
+		tokenRepository:   tr,
+	}
+}
+
+func (uc *ListConnectedAppsUseCase) Execute(sessionID session.SessionID) ([]ConnectedApp, error) {
+	now := time.Now()
+	user, err := authenticatedUser(uc.logger, uc.sessionStore, sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	consents, err := uc.consentRepository.FindByUserID(user.UserID())
+	if err != nil {
+		uc.logger.Error("Failed to find consents", "err", err)
+		return nil, ErrUnexpected
+	}
+	refreshTokens, err := uc.tokenRepository.FindRefreshTokensByUser(user.UserID(), now)
+	if err != nil {
+		uc.logger.Error("Failed to find refresh tokens", "err", err)
+		return nil, ErrUnexpected
+	}
+	// 同意が有効期限切れで削除された場合やスナップショットから復元されなかった場合も、
+	// リフレッシュトークンが使える間は連携中として表示し、取り消せるようにする
+	tokensByClient := make(map[string][]*domain.RefreshToken)
+	tokenClientIDs := make([]string, 0)
+	for _, rt := range refreshTokens {
+		if _, ok := tokensByClient[rt.ClientID()]; !ok {
+			tokenClientIDs = append(tokenClientIDs, rt.ClientID())
+		}
+		tokensByClient[rt.ClientID()] = append(tokensByClient[rt.ClientID()], rt)
+	}
+
+	apps := make([]ConnectedApp, 0, len(consents)+len(tokenClientIDs))
+	for _, consent := range consents {
+		apps = append(apps, NewConnectedApp(
+			consent.ClientID(),
+			uc.clientName(consent.ClientID()),
+			consent.Scopes(),
+			consent.GrantedAt(),
+			consent.ExpiresAt(),
+			consent.FirstUsedAt(),
+			consent.LastUsedAt(),
+			newActiveRefreshTokens(tokensByClient[consent.ClientID()]),
+		))
+		delete(tokensByClient, consent.ClientID())
+	}
+	for _, clientID := range tokenClientIDs {
+		tokens, ok := tokensByClient[clientID]
+		if !ok {
+			continue
+		}
+		// 同意の記録がないため、スコープはリフレッシュトークンのものを合わせ、日時は未設定とする
+		scopes := make([]string, 0)
+		for _, rt := range tokens {
+			for _, scope := range rt.Scopes() {
+				if !slices.Contains(scopes, scope) {
+					scopes = append(scopes, scope)
+				}
+			}
+		}
+		apps = append(apps, NewConnectedApp(clientID, uc.clientName(clientID), scopes, time.Time{}, time.Time{}, time.Time{}, time.Time{}, newActiveRefreshTokens(tokens)))
+	}
+	return apps, nil
+}
+
+// クライアントが削除されている場合でも同意の取り消しはできるよう、見つからなければclient_idを名前として表示する
+func (uc *ListConnectedAppsUseCase) clientName(clientID string) string {
+	client, err := uc.clientRepository.SelectByClientID(domain.ClientID(clientID))
+	if err != nil {
+		uc.logger.Info("Client of connected app not found", "clientID", clientID, "err", err)
+		return clientID
+	}
+	return client.ClientName()
+}
+
+func newActiveRefreshTokens(refreshTokens []*domain.RefreshToken) []ActiveRefreshToken {
+	activeRefreshTokens := make([]ActiveRefreshToken, 0, len(refreshTokens))
+	for _, rt := range refreshTokens {
+		activeRefreshTokens = append(activeRefreshTokens, NewActiveRefreshToken(rt.Scopes(), time.Unix(rt.IssuedAt(), 0), time.Unix(rt.ExpiresAt(), 0)))
+	}
+	return activeRefreshTokens
+}
+
+// 連携中のアプリの認可を取り消すユースケース
+type RevokeConnectedAppUseCase struct {
+	logger             mylogger.Logger
+	sessionStore       ISessionStorage
+	consentRepository  IConsentRepository
+	tokenRepository    ITokenRepository
+	authCodeRepository IAuthCodeRepository
+}
+
+func NewRevokeConnectedAppUseCase(logger mylogger.Logger, ss ISessionStorage, csr IConsentRepository, tr ITokenRepository, ar IAuthCodeRepository) *RevokeConnectedAppUseCase {
+	return &RevokeConnectedAppUseCase{
+		logger:             logger,
+		sessionStore:       ss,
+		consentRepository:  csr,
+		tokenRepository:    tr,
+		authCodeRepository: ar,
+	}
+}
+
+// 同意の記録を削除し、そのクライアントに発行した全てのトークンと未使用の認可コードを無効にする
+// 同意の記録がなくても、トークンか未使用の認可コードが残っていれば無効にする
+func (uc *RevokeConnectedAppUseCase) Execute(sessionID session.SessionID, clientID string) error {
+	user, err := authenticatedUser(uc.logger, uc.sessionStore, sessionID)
+	if err != nil {
+		return err
+	}
+
+	hasConsent := true
+	if _, err := uc.consentRepository.FindByUserAndClient(user.UserID(), clientID); err != nil {
+		if !errors.Is(err, infrastructure.ErrConsentNotFound) {
+			uc.logger.Error("Failed to find consent", "clientID", clientID, "err", err)
+			return ErrUnexpected
+		}
+		hasConsent = false
+	}
+
+	// 解除した後に認可コードからトークンを取得できないよう、トークンより先に認可コードを削除する
+	// 無効化に失敗した場合も同意が残り、再度連携を解除できるよう、認可コードとトークンは同意より先に無効にする
+	deletedCodes, err := uc.authCodeRepository.DeleteByUserAndClient(user.UserID(), clientID)
+	if err != nil {
+		uc.logger.Error("Failed to delete authorization codes", "clientID", clientID, "err", err)
+		return ErrUnexpected
+	}
+	revokedTokens, err := uc.tokenRepository.RevokeByUserAndClient(user.UserID(), clientID)
+	if err != nil {
+		uc.logger.Error("Failed to revoke tokens", "clientID", clientID, "err", err)
+		return ErrUnexpected
+	}
+	if !hasConsent {
+		if deletedCodes == 0 && revokedTokens == 0 {
+			uc.logger.Info("Connected app to revoke not found", "clientID", clientID)
+			return ErrConnectedAppNotFound
+		}
+	} else if err := uc.consentRepository.Delete(user.UserID(), clientID); err != nil {
+		uc.logger.Error("Failed to delete consent", "clientID", clientID, "err", err)
+		return ErrUnexpected
+	}
+	uc.logger.Info("Connected app revoked", "clientID", clientID)
+	return nil
+}
+
+// ログイン済みのセッションのユーザーを取得する
+func authenticatedUser(logger mylogger.Logger, ss ISessionStorage, sessionID session.SessionID) (*domain.User, error) {
+	if sessionID == "" {
+		return nil, ErrLoginRequired
+	}
+	sessionData, err := ss.Get(sessionID)
+	if err != nil {
+		if !errors.Is(err, infrastructure.ErrSessionNotFound) {
+			logger.Error("Failed to get session", "err", err)
+		}
+		return nil, ErrLoginRequired
+	}
+	if !sessionData.IsAuthenticated() {
+		return nil, ErrLoginRequired
+	}
+	return sessionData.User(), nil
+}
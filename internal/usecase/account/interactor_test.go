@@ -0,0 +1,229 @@
+package account
+
+import (
+	"errors"
+	"oauth-tutorial/internal/domain"
+	"oauth-tutorial/internal/infrastructure"
+	inf_dto "oauth-tutorial/internal/infrastructure/dto"
+	"oauth-tutorial/internal/session"
+	"oauth-tutorial/pkg/mylogger"
+	"slices"
+	"testing"
+	"time"
+)
+
+type mockSessionStorage struct {
+	sessions map[session.SessionID]*inf_dto.SessionData
+}
+
+func (m *mockSessionStorage) Get(sessionID session.SessionID) (*inf_dto.SessionData, error) {
+	sessionData, ok := m.sessions[sessionID]
+	if !ok {
+		return nil, infrastructure.ErrSessionNotFound
+	}
+	return sessionData, nil
+}
+
+type mockClientRepository struct{}
+
+func (m *mockClientRepository) SelectByClientID(clientID domain.ClientID) (*domain.Client, error) {
+	if clientID != "client-1" {
+		return nil, infrastructure.ErrClientNotFound
+	}
+	return domain.ReconstructClient("client-1", "テストクライアント", domain.ConfidentialClient, "secret", []string{"https://example.com/callback"}), nil
+}
+
+const (
+	authenticatedSessionID = session.SessionID("authenticated-session-id")
+	anonymousSessionID     = session.SessionID("anonymous-session-id")
+)
+
+func newTestSessionStorage() *mockSessionStorage {
+	user := domain.ReconstructUser("user-1", "user@example.com", "password")
+	return &mockSessionStorage{sessions: map[session.SessionID]*inf_dto.SessionData{
+		authenticatedSessionID: inf_dto.NewSessionData(user, time.Now(), []string{"pwd"}),
+		anonymousSessionID:     inf_dto.NewSessionData(nil, time.Time{}, nil),
+	}}
+}
+
+func Test_連携中のアプリの一覧(t *testing.T) {
+	logger := mylogger.NewMockLogger()
+	now := time.Now()
+	csr := infrastructure.NewConsentRepository()
+	csr.Save(domain.NewConsent("user-1", "client-1", []string{"read"}, now.Add(-time.Hour), 0).RecordUse(now.Add(-time.Minute)))
+	csr.Save(domain.NewConsent("user-1", "deleted-client", []string{"write"}, now, 0))
+	csr.Save(domain.NewConsent("user-2", "client-1", []string{"read"}, now, 0))
+	tr := infrastructure.NewTokenRespository()
+	at := domain.NewAccessToken("client-1", "user-1", []string{"read"}, now, domain.AccessTokenDuration)
+	tr.Save(at)
+	tr.SaveRefreshToken(domain.NewRefreshToken("client-1", "user-1", []string{"read"}, now, domain.RefreshTokenDuration), at)
+
+	tests := []struct {
+		name        string
+		sessionID   session.SessionID
+		expectedErr error
+	}{
+		{name: "正常系", sessionID: authenticatedSessionID},
+		{name: "異常系 - 未ログインのセッション", sessionID: anonymousSessionID, expectedErr: ErrLoginRequired},
+		{name: "異常系 - セッションが存在しない", sessionID: "unknown-session-id", expectedErr: ErrLoginRequired},
+		{name: "異常系 - セッションIDが空", sessionID: "", expectedErr: ErrLoginRequired},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			uc := NewListConnectedAppsUseCase(logger, newTestSessionStorage(), &mockClientRepository{}, csr, tr)
+
+			apps, err := uc.Execute(tt.sessionID)
+
+			if tt.expectedErr != nil {
+				if !errors.Is(err, tt.expectedErr) {
+					t.Fatalf("Execute() error = %v, want %v", err, tt.expectedErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Execute() error = %v", err)
+			}
+			if len(apps) != 2 {
+				t.Fatalf("len(apps) = %d, want 2", len(apps))
+			}
+			if apps[0].ClientID() != "client-1" || apps[0].ClientName() != "テストクライアント" {
+				t.Errorf("apps[0] = (%v, %v), want (client-1, テストクライアント)", apps[0].ClientID(), apps[0].ClientName())
+			}
+			if !slices.Equal(apps[0].Scopes(), []string{"read"}) {
+				t.Errorf("apps[0].Scopes() = %v, want [read]", apps[0].Scopes())
+			}
+			if !apps[0].LastUsedAt().Equal(now.Add(-time.Minute)) {
+				t.Errorf("apps[0].LastUsedAt() = %v, want %v", apps[0].LastUsedAt(), now.Add(-time.Minute))
+			}
+			if len(apps[0].RefreshTokens()) != 1 {
+				t.Errorf("len(apps[0].RefreshTokens()) = %d, want 1", len(apps[0].RefreshTokens()))
+			}
+			// クライアントが見つからない場合はclient_idを名前として表示する
+			if apps[1].ClientName() != "deleted-client" || len(apps[1].RefreshTokens()) != 0 {
+				t.Errorf("apps[1] = (%v, %d tokens), want (deleted-client, 0 tokens)", apps[1].ClientName(), len(apps[1].RefreshTokens()))
+			}
+		})
+	}
+}
+
+func Test_連携中のアプリの取り消し(t *testing.T) {
+	logger := mylogger.NewMockLogger()
+
+	tests := []struct {
+		name        string
+		sessionID   session.SessionID
+		clientID    string
+		expectedErr error
+	}{
+		{name: "正常系", sessionID: authenticatedSessionID, clientID: "client-1"},
+		{name: "異常系 - 連携していないクライアント", sessionID: authenticatedSessionID, clientID: "client-2", expectedErr: ErrConnectedAppNotFound},
+		{name: "異常系 - 未ログインのセッション", sessionID: anonymousSessionID, clientID: "client-1", expectedErr: ErrLoginRequired},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// given
+			now := time.Now()
+			csr := infrastructure.NewConsentRepository()
+			csr.Save(domain.NewConsent("user-1", "client-1", []string{"read"}, now, 0))
+			tr := infrastructure.NewTokenRespository()
+			at := domain.NewAccessToken("client-1", "user-1", []string{"read"}, now, domain.AccessTokenDuration)
+			rt := domain.NewRefreshToken("client-1", "user-1", []string{"read"}, now, domain.RefreshTokenDuration)
+			tr.Save(at)
+			tr.SaveRefreshToken(rt, at)
+			// トークンと交換する前の認可コード
+			ar := infrastructure.NewAuthCodeRepository()
+			code := domain.ReconstructAuthorizationCode("code-1", "user-1", "client-1", []string{"read"}, "https://example.com/callback", now.Add(domain.AUTHORIZATION_CODE_DURATION).Unix())
+			ar.Save(code)
+			uc := NewRevokeConnectedAppUseCase(logger, newTestSessionStorage(), csr, tr, ar)
+
+			// when
+			err := uc.Execute(tt.sessionID, tt.clientID)
+
+			// then
+			if tt.expectedErr != nil {
+				if !errors.Is(err, tt.expectedErr) {
+					t.Fatalf("Execute() error = %v, want %v", err, tt.expectedErr)
+				}
+				if _, err := csr.FindByUserAndClient("user-1", "client-1"); err != nil {
+					t.Error("consent should not be deleted on error")
+				}
+				if _, err := ar.FindByCode(code.Value()); err != nil {
+					t.Error("authorization code should not be deleted on error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Execute() error = %v", err)
+			}
+			if _, err := csr.FindByUserAndClient("user-1", tt.clientID); !errors.Is(err, infrastructure.ErrConsentNotFound) {
+				t.Errorf("consent should be deleted, got err = %v", err)
+			}
+			if _, err := tr.FindByAccessToken(at.Value()); err == nil {
+				t.Error("access token should be revoked")
+			}
+			if _, err := tr.FindByRefreshToken(rt.Value()); err == nil {
+				t.Error("refresh token should be revoked")
+			}
+			// 解除した後に認可コードからトークンを取得できない
+			if _, err := ar.Consume(code.Value()); !errors.Is(err, infrastructure.ErrAuthorizationCodeNotFound) {
+				t.Errorf("authorization code should be deleted, got err = %v", err)
+			}
+		})
+	}
+}
+
+func Test_同意の記録がなくてもリフレッシュトークンが有効な間は連携中のアプリとして取り消せる(t *testing.T) {
+	// given: 同意の記録は有効期限切れで削除されたが、リフレッシュトークンは有効なまま
+	logger := mylogger.NewMockLogger()
+	now := time.Now()
+	csr := infrastructure.NewConsentRepository()
+	csr.Save(domain.NewConsent("user-1", "client-1", []string{"read", "write"}, now.Add(-2*time.Hour), time.Hour))
+	if _, err := csr.PurgeExpired(now, 10); err != nil {
+		t.Fatalf("PurgeExpired() error = %v", err)
+	}
+	tr := infrastructure.NewTokenRespository()
+	at := domain.NewAccessToken("client-1", "user-1", []string{"read"}, now, domain.AccessTokenDuration)
+	rt := domain.NewRefreshToken("client-1", "user-1", []string{"read", "write"}, now, domain.RefreshTokenDuration)
+	tr.Save(at)
+	tr.SaveRefreshToken(rt, at)
+	ar := infrastructure.NewAuthCodeRepository()
+	list := NewListConnectedAppsUseCase(logger, newTestSessionStorage(), &mockClientRepository{}, csr, tr)
+	revoke := NewRevokeConnectedAppUseCase(logger, newTestSessionStorage(), csr, tr, ar)
+
+	// when
+	apps, err := list.Execute(authenticatedSessionID)
+
+	// then: リフレッシュトークンのスコープで一覧に含める
+	if err != nil {
+		t.Fatalf("ListConnectedApps Execute() error = %v", err)
+	}
+	if len(apps) != 1 || apps[0].ClientID() != "client-1" || apps[0].ClientName() != "テストクライアント" {
+		t.Fatalf("apps = %+v, want client-1 only", apps)
+	}
+	if !slices.Equal(apps[0].Scopes(), []string{"read", "write"}) || !apps[0].GrantedAt().IsZero() || len(apps[0].RefreshTokens()) != 1 {
+		t.Errorf("apps[0] = (%v, %v, %d tokens), want ([read write], zero time, 1 token)", apps[0].Scopes(), apps[0].GrantedAt(), len(apps[0].RefreshTokens()))
+	}
+
+	// when
+	err = revoke.Execute(authenticatedSessionID, "client-1")
+
+	// then: トークンを無効にし、一覧から消える
+	if err != nil {
+		t.Fatalf("RevokeConnectedApp Execute() error = %v", err)
+	}
+	if _, err := tr.FindByRefreshToken(rt.Value()); err == nil {
+		t.Error("refresh token should be revoked")
+	}
+	if _, err := tr.FindByAccessToken(at.Value()); err == nil {
+		t.Error("access token should be revoked")
+	}
+	if apps, err := list.Execute(authenticatedSessionID); err != nil || len(apps) != 0 {
+		t.Errorf("apps after revoke = %+v, %v, want none", apps, err)
+	}
+	// 無効にするものが残っていなければ見つからない
+	if err := revoke.Execute(authenticatedSessionID, "client-1"); !errors.Is(err, ErrConnectedAppNotFound) {
+		t.Errorf("second revoke error = %v, want %v", err, ErrConnectedAppNotFound)
+	}
+}
@@ -0,0 +1,136 @@
+package account
+
+import "time"
+
+// ユーザーが認可したクライアント(連携中のアプリ)
+type ConnectedApp struct {
+	clientID      string
+	clientName    string
+	scopes        []string
+	grantedAt     time.Time
+	expiresAt     time.Time
+	firstUsedAt   time.Time
+	lastUsedAt    time.Time
+	refreshTokens []ActiveRefreshToken
+}
+
+func NewConnectedApp(clientID, clientName string, scopes []string, grantedAt, expiresAt, firstUsedAt, lastUsedAt time.Time, refreshTokens []ActiveRefreshToken) ConnectedApp {
+	return ConnectedApp{
+		clientID:      clientID,
+		clientName:    clientName,
+		scopes:        scopes,
+		grantedAt:     grantedAt,
+		expiresAt:     expiresAt,
+		firstUsedAt:   firstUsedAt,
+		lastUsedAt:    lastUsedAt,
+		refreshTokens: refreshTokens,
+	}
+}
+
+func (a ConnectedApp) ClientID() string                    { return a.clientID }
+func (a ConnectedApp) ClientName() string                  { return a.clientName }
+func (a ConnectedApp) Scopes() []string                    { return a.scopes }
+func (a ConnectedApp) GrantedAt() time.Time                { return a.grantedAt }
+func (a ConnectedApp) ExpiresAt() time.Time                { return a.expiresAt }
+func (a ConnectedApp) FirstUsedAt() time.Time              { return a.firstUsedAt }
+func (a ConnectedApp) LastUsedAt() time.Time               { return a.lastUsedAt }
+func (a ConnectedApp) RefreshTokens() []ActiveRefreshToken { return a.refreshTokens }
+
+// 有効なリフレッシュトークンの情報。トークンの値そのものは画面に出さない
+type ActiveRefreshToken struct {
+	scopes    []string
+	issuedAt  time.Time
+	expiresAt time.Time
+}
+
+func NewActiveRefreshToken(scopes []string, issuedAt, expiresAt time.Time) ActiveRefreshToken {
+	return ActiveRefreshToken{scopes: scopes, issuedAt: issuedAt, expiresAt: expiresAt}
+}
+
+func (t ActiveRefreshToken) Scopes() []string     { return t.scopes }
+func (t ActiveRefreshToken) IssuedAt() time.Time  { return t.issuedAt }
+func (t ActiveRefreshToken) ExpiresAt() time.Time { return t.expiresAt }
+
+// ユーザーのTOTPの登録状況
+type TOTPStatus struct {
+	enabled bool
+	// 未使用のリカバリーコードの数
+	remainingRecoveryCodes int
+}
+
+func NewTOTPStatus(enabled bool, remainingRecoveryCodes int) TOTPStatus {
+	return TOTPStatus{enabled: enabled, remainingRecoveryCodes: remainingRecoveryCodes}
+}
+
+func (s TOTPStatus) Enabled() bool               { return s.enabled }
+func (s TOTPStatus) RemainingRecoveryCodes() int { return s.remainingRecoveryCodes }
+
+// 認証アプリに登録するための情報。provisioningURIはQRコードにする内容、secretは手入力用
+type TOTPEnrollment struct {
+	provisioningURI string
+	secret          string
+}
+
+func NewTOTPEnrollment(provisioningURI, secret string) TOTPEnrollment {
+	return TOTPEnrollment{provisioningURI: provisioningURI, secret: secret}
+}
+
+func (e TOTPEnrollment) ProvisioningURI() string { return e.provisioningURI }
+func (e TOTPEnrollment) Secret() string          { return e.secret }
+
+// 登録済みのパスキー。公開鍵やクレデンシャルIDは画面に出さない
+type RegisteredPasskey struct {
+	name       string
+	createdAt  time.Time
+	lastUsedAt time.Time
+	// 複数の端末に同期されるパスキーかどうか
+	synced bool
+}
+
+func NewRegisteredPasskey(name string, createdAt, lastUsedAt time.Time, synced bool) RegisteredPasskey {
+	return RegisteredPasskey{name: name, createdAt: createdAt, lastUsedAt: lastUsedAt, synced: synced}
+}
+
+func (p RegisteredPasskey) Name() string          { return p.name }
+func (p RegisteredPasskey) CreatedAt() time.Time  { return p.createdAt }
+func (p RegisteredPasskey) LastUsedAt() time.Time { return p.lastUsedAt }
+func (p RegisteredPasskey) Synced() bool          { return p.synced }
+
+// ブラウザのnavigator.credentials.createに渡す登録のオプション WebAuthn Level 2 5.4
+type PasskeyRegistrationOptions struct {
+	// base64urlでエンコードしたチャレンジ
+	challenge string
+	rpID      string
+	rpName    string
+	// WebAuthnのユーザーハンドル(ユーザーID)と、認証器に表示するログインID
+	userHandle []byte
+	userName   string
+	// 同じ認証器で重複して登録しないよう、登録済みのクレデンシャルIDを渡す
+	excludeCredentialIDs [][]byte
+	// 検証できる公開鍵のアルゴリズム(COSEのアルゴリズムの識別子)を優先する順に並べる
+	algorithms []int64
+	// チャレンジの有効期間
+	timeout time.Duration
+}
+
+func NewPasskeyRegistrationOptions(challenge, rpID, rpName string, userHandle []byte, userName string, excludeCredentialIDs [][]byte, algorithms []int64, timeout time.Duration) PasskeyRegistrationOptions {
+	return PasskeyRegistrationOptions{
+		challenge:            challenge,
+		rpID:                 rpID,
+		rpName:               rpName,
+		userHandle:           userHandle,
+		userName:             userName,
+		excludeCredentialIDs: excludeCredentialIDs,
+		algorithms:           algorithms,
+		timeout:              timeout,
+	}
+}
+
+func (o PasskeyRegistrationOptions) Challenge() string              { return o.challenge }
+func (o PasskeyRegistrationOptions) RPID() string                   { return o.rpID }
+func (o PasskeyRegistrationOptions) RPName() string                 { return o.rpName }
+func (o PasskeyRegistrationOptions) UserHandle() []byte             { return o.userHandle }
+func (o PasskeyRegistrationOptions) UserName() string               { return o.userName }
+func (o PasskeyRegistrationOptions) ExcludeCredentialIDs() [][]byte { return o.excludeCredentialIDs }
+func (o PasskeyRegistrationOptions) Algorithms() []int64            { return o.algorithms }
+func (o PasskeyRegistrationOptions) Timeout() time.Duration         { return o.timeout }
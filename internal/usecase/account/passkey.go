@@ -0,0 +1,161 @@
+package account
+
+import (
+	"encoding/base64"
+	"errors"
+	"oauth-tutorial/internal/domain"
+	"oauth-tutorial/internal/infrastructure"
+	inf_dto "oauth-tutorial/internal/infrastructure/dto"
+	"oauth-tutorial/internal/infrastructure/webauthn"
+	"oauth-tutorial/internal/session"
+	"oauth-tutorial/pkg/mylogger"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+var (
+	ErrPasskeyChallengeNotFound = errors.New("passkey challenge not found")
+	ErrInvalidPasskey           = errors.New("invalid passkey")
+	ErrPasskeyAlreadyRegistered = errors.New("passkey is already registered")
+	ErrPasskeyNameTooLong       = errors.New("passkey name is too long")
+)
+
+// チャレンジのバイト数 WebAuthn Level 2 13.4.3 16バイト以上
+const passkeyChallengeBytes = 32
+
+// パスキーに付ける名前の最大の文字数
+const MaxPasskeyNameLength = 64
+
+// 登録済みのパスキーの一覧を取得するユースケース
+type ListPasskeysUseCase struct {
+	logger            mylogger.Logger
+	sessionStore      ISessionStorage
+	passkeyRepository IPasskeyRepository
+}
+
+func NewListPasskeysUseCase(logger mylogger.Logger, ss ISessionStorage, pr IPasskeyRepository) *ListPasskeysUseCase {
+	return &ListPasskeysUseCase{logger: logger, sessionStore: ss, passkeyRepository: pr}
+}
+
+func (uc *ListPasskeysUseCase) Execute(sessionID session.SessionID) ([]RegisteredPasskey, error) {
+	user, err := authenticatedUser(uc.logger, uc.sessionStore, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	credentials, err := uc.passkeyRepository.FindByUserID(user.UserID())
+	if err != nil {
+		uc.logger.Error("Failed to find passkeys", "err", err)
+		return nil, ErrUnexpected
+	}
+	passkeys := make([]RegisteredPasskey, 0, len(credentials))
+	for _, c := range credentials {
+		passkeys = append(passkeys, NewRegisteredPasskey(c.Name(), c.CreatedAt(), c.LastUsedAt(), c.BackupEligible()))
+	}
+	return passkeys, nil
+}
+
+// パスキーの登録を始めるユースケース
+// チャレンジを発行してログイン中のセッションに紐づけ、ブラウザに渡す登録のオプションを返す
+type BeginPasskeyRegistrationUseCase struct {
+	logger            mylogger.Logger
+	sessionStore      ISessionStorage
+	passkeyRepository IPasskeyRepository
+	challengeStore    IPasskeyChallengeStorage
+	randomGenerator   IRandomGenerator
+	relyingParty      IPasskeyRelyingParty
+}
+
+func NewBeginPasskeyRegistrationUseCase(logger mylogger.Logger, ss ISessionStorage, pr IPasskeyRepository, cs IPasskeyChallengeStorage, rg IRandomGenerator, rp IPasskeyRelyingParty) *BeginPasskeyRegistrationUseCase {
+	return &BeginPasskeyRegistrationUseCase{logger: logger, sessionStore: ss, passkeyRepository: pr, challengeStore: cs, randomGenerator: rg, relyingParty: rp}
+}
+
+func (uc *BeginPasskeyRegistrationUseCase) Execute(sessionID session.SessionID) (PasskeyRegistrationOptions, error) {
+	user, err := authenticatedUser(uc.logger, uc.sessionStore, sessionID)
+	if err != nil {
+		return PasskeyRegistrationOptions{}, err
+	}
+	credentials, err := uc.passkeyRepository.FindByUserID(user.UserID())
+	if err != nil {
+		uc.logger.Error("Failed to find passkeys", "err", err)
+		return PasskeyRegistrationOptions{}, ErrUnexpected
+	}
+	excludeCredentialIDs := make([][]byte, 0, len(credentials))
+	for _, c := range credentials {
+		excludeCredentialIDs = append(excludeCredentialIDs, c.CredentialID())
+	}
+
+	challenge := base64.RawURLEncoding.EncodeToString(uc.randomGenerator.GenerateRandomBytes(passkeyChallengeBytes))
+	if err := uc.challengeStore.Save(inf_dto.NewPasskeyRegistrationChallenge(challenge, sessionID, user.UserID(), time.Now())); err != nil {
+		uc.logger.Error("Failed to save passkey challenge", "err", err)
+		return PasskeyRegistrationOptions{}, ErrUnexpected
+	}
+	// ユーザーハンドルには個人を特定できる情報を含めないよう、ログインIDではなくユーザーIDを使う WebAuthn Level 2 14.6.1
+	return NewPasskeyRegistrationOptions(challenge, uc.relyingParty.ID(), uc.relyingParty.Name(), []byte(user.UserID()), user.LoginID(), excludeCredentialIDs, slices.Clone(webauthn.SupportedAlgorithms), inf_dto.PASSKEY_CHALLENGE_DURATION), nil
+}
+
+// ブラウザから受け取った登録の結果を検証し、パスキーを保存するユースケース
+type FinishPasskeyRegistrationUseCase struct {
+	logger            mylogger.Logger
+	sessionStore      ISessionStorage
+	passkeyRepository IPasskeyRepository
+	challengeStore    IPasskeyChallengeStorage
+	relyingParty      IPasskeyRelyingParty
+}
+
+func NewFinishPasskeyRegistrationUseCase(logger mylogger.Logger, ss ISessionStorage, pr IPasskeyRepository, cs IPasskeyChallengeStorage, rp IPasskeyRelyingParty) *FinishPasskeyRegistrationUseCase {
+	return &FinishPasskeyRegistrationUseCase{logger: logger, sessionStore: ss, passkeyRepository: pr, challengeStore: cs, relyingParty: rp}
+}
+
+// nameはユーザーが付けた名前。前後の空白は取り除く
+func (uc *FinishPasskeyRegistrationUseCase) Execute(sessionID session.SessionID, clientDataJSON []byte, attestationObject []byte, name string) error {
+	now := time.Now()
+	user, err := authenticatedUser(uc.logger, uc.sessionStore, sessionID)
+	if err != nil {
+		return err
+	}
+	name = strings.TrimSpace(name)
+	if utf8.RuneCountInString(name) > MaxPasskeyNameLength {
+		return ErrPasskeyNameTooLong
+	}
+
+	// チャレンジは1回のみ使え、登録を始めたセッションとユーザーでのみ受け付ける
+	challenge, err := uc.relyingParty.Challenge(clientDataJSON)
+	if err != nil {
+		mylogger.SecurityEvent(uc.logger, "passkey_registration_failed", "userID", user.UserID(), "err", err)
+		return ErrInvalidPasskey
+	}
+	issued, err := uc.challengeStore.Consume(challenge)
+	if err != nil || issued.Ceremony() != inf_dto.PasskeyRegistration || issued.SessionID() != sessionID || issued.UserID() != user.UserID() || issued.IsExpired(now) {
+		mylogger.SecurityEvent(uc.logger, "passkey_challenge_invalid", "userID", user.UserID(), "err", err)
+		return ErrPasskeyChallengeNotFound
+	}
+	registration, err := uc.relyingParty.VerifyRegistration(clientDataJSON, attestationObject, issued.Challenge())
+	if err != nil {
+		mylogger.SecurityEvent(uc.logger, "passkey_registration_failed", "userID", user.UserID(), "err", err)
+		return ErrInvalidPasskey
+	}
+	// ログインで本人の確認を必須にするため、本人の確認ができない認証器は登録させない WebAuthn Level 2 7.1 step 15
+	if !registration.UserVerified {
+		mylogger.SecurityEvent(uc.logger, "passkey_registration_failed", "userID", user.UserID(), "err", "user not verified")
+		return ErrInvalidPasskey
+	}
+
+	// 他のユーザーが登録したクレデンシャルを上書きしない WebAuthn Level 2 7.1 step 22
+	if _, err := uc.passkeyRepository.FindByCredentialID(registration.CredentialID); err == nil {
+		mylogger.SecurityEvent(uc.logger, "passkey_registration_failed", "userID", user.UserID(), "err", ErrPasskeyAlreadyRegistered)
+		return ErrPasskeyAlreadyRegistered
+	} else if !errors.Is(err, infrastructure.ErrPasskeyCredentialNotFound) {
+		uc.logger.Error("Failed to find passkey", "err", err)
+		return ErrUnexpected
+	}
+
+	credential := domain.NewPasskeyCredential(registration.CredentialID, user.UserID(), user.LoginID(), registration.PublicKey, registration.SignCount, registration.BackupEligible, name, now)
+	if err := uc.passkeyRepository.Save(credential); err != nil {
+		uc.logger.Error("Failed to save passkey", "err", err)
+		return ErrUnexpected
+	}
+	mylogger.AuditEvent(uc.logger, "passkey_registered", "userID", user.UserID(), "loginID", user.LoginID(), "synced", registration.BackupEligible)
+	return nil
+}
@@ -0,0 +1,150 @@
+package account
+
+import (
+	"errors"
+	"oauth-tutorial/internal/infrastructure"
+	"oauth-tutorial/internal/infrastructure/webauthn"
+	"oauth-tutorial/internal/infrastructure/webauthn/webauthntest"
+	"oauth-tutorial/pkg/mylogger"
+	"slices"
+	"strings"
+	"testing"
+)
+
+const (
+	testRPID   = "auth.example.com"
+	testOrigin = "https://auth.example.com"
+)
+
+type passkeyTestEnv struct {
+	passkeys   *infrastructure.PasskeyRepository
+	challenges *infrastructure.PasskeyChallengeStorage
+	list       *ListPasskeysUseCase
+	begin      *BeginPasskeyRegistrationUseCase
+	finish     *FinishPasskeyRegistrationUseCase
+}
+
+func newPasskeyTestEnv() *passkeyTestEnv {
+	logger := mylogger.NewMockLogger()
+	ss := newTestSessionStorage()
+	rp := webauthn.NewRelyingParty(testRPID, testRPID, testOrigin)
+	env := &passkeyTestEnv{
+		passkeys:   infrastructure.NewPasskeyRepository(),
+		challenges: infrastructure.NewPasskeyChallengeStorage(),
+	}
+	rg := &mockRandomGenerator{}
+	env.list = NewListPasskeysUseCase(logger, ss, env.passkeys)
+	env.begin = NewBeginPasskeyRegistrationUseCase(logger, ss, env.passkeys, env.challenges, rg, rp)
+	env.finish = NewFinishPasskeyRegistrationUseCase(logger, ss, env.passkeys, env.challenges, rp)
+	return env
+}
+
+func Test_パスキーの登録(t *testing.T) {
+	// given
+	env := newPasskeyTestEnv()
+	authenticator := webauthntest.NewAuthenticator(t, testRPID, testOrigin)
+
+	// when
+	options, err := env.begin.Execute(authenticatedSessionID)
+	if err != nil {
+		t.Fatalf("Begin() error = %v", err)
+	}
+	attestation := authenticator.Create(options.Challenge(), options.UserHandle())
+	err = env.finish.Execute(authenticatedSessionID, attestation.ClientDataJSON, attestation.AttestationObject, "  ノートPC  ")
+
+	// then
+	if err != nil {
+		t.Fatalf("Finish() error = %v", err)
+	}
+	if options.RPID() != testRPID || string(options.UserHandle()) != "user-1" || options.UserName() != "user@example.com" || len(options.ExcludeCredentialIDs()) != 0 {
+		t.Errorf("options = %+v", options)
+	}
+	saved, err := env.passkeys.FindByCredentialID(authenticator.CredentialID())
+	if err != nil {
+		t.Fatalf("FindByCredentialID() error = %v", err)
+	}
+	if saved.UserID() != "user-1" || saved.LoginID() != "user@example.com" || saved.Name() != "ノートPC" || saved.BackupEligible() {
+		t.Errorf("saved passkey = %+v", saved)
+	}
+	passkeys, err := env.list.Execute(authenticatedSessionID)
+	if err != nil || len(passkeys) != 1 || passkeys[0].Name() != "ノートPC" {
+		t.Errorf("List() = %+v, %v", passkeys, err)
+	}
+
+	// 2つ目の登録では、登録済みのクレデンシャルを除外するよう認証器に伝える
+	options, err = env.begin.Execute(authenticatedSessionID)
+	if err != nil {
+		t.Fatalf("Begin() error = %v", err)
+	}
+	if len(options.ExcludeCredentialIDs()) != 1 || !slices.Equal(options.ExcludeCredentialIDs()[0], authenticator.CredentialID()) {
+		t.Errorf("ExcludeCredentialIDs() = %x", options.ExcludeCredentialIDs())
+	}
+	// 同じクレデンシャルは登録し直せない
+	attestation = authenticator.Create(options.Challenge(), options.UserHandle())
+	if err := env.finish.Execute(authenticatedSessionID, attestation.ClientDataJSON, attestation.AttestationObject, ""); !errors.Is(err, ErrPasskeyAlreadyRegistered) {
+		t.Errorf("Finish() with a registered credential error = %v, want %v", err, ErrPasskeyAlreadyRegistered)
+	}
+}
+
+func Test_パスキーの登録の失敗(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(a *webauthntest.Authenticator)
+		// 登録の結果を2回送信する
+		replay      bool
+		challenge   string
+		passkeyName string
+		// 登録の結果を未ログインのセッションから送信する
+		sessionMissing bool
+		expectedErr    error
+	}{
+		{name: "異常系 - 未ログイン", sessionMissing: true, expectedErr: ErrLoginRequired},
+		{name: "異常系 - 発行していないチャレンジ", challenge: "dW5rbm93bg", expectedErr: ErrPasskeyChallengeNotFound},
+		{name: "異常系 - 同じチャレンジでの2回目の登録", replay: true, expectedErr: ErrPasskeyChallengeNotFound},
+		{name: "異常系 - オリジンが異なる", modify: func(a *webauthntest.Authenticator) { a.Origin = "https://evil.example.com" }, expectedErr: ErrInvalidPasskey},
+		{name: "異常系 - 本人の確認ができない認証器", modify: func(a *webauthntest.Authenticator) { a.SkipUserVerification = true }, expectedErr: ErrInvalidPasskey},
+		{name: "異常系 - none以外のアテステーション", modify: func(a *webauthntest.Authenticator) { a.Format = "packed" }, expectedErr: ErrInvalidPasskey},
+		{name: "異常系 - 名前が長すぎる", passkeyName: strings.Repeat("あ", MaxPasskeyNameLength+1), expectedErr: ErrPasskeyNameTooLong},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// given
+			env := newPasskeyTestEnv()
+			authenticator := webauthntest.NewAuthenticator(t, testRPID, testOrigin)
+			if tt.modify != nil {
+				tt.modify(authenticator)
+			}
+			options, err := env.begin.Execute(authenticatedSessionID)
+			if err != nil {
+				t.Fatalf("Begin() error = %v", err)
+			}
+			challenge := options.Challenge()
+			if tt.challenge != "" {
+				challenge = tt.challenge
+			}
+			attestation := authenticator.Create(challenge, options.UserHandle())
+			sessionID := authenticatedSessionID
+			if tt.sessionMissing {
+				sessionID = anonymousSessionID
+			}
+			if tt.replay {
+				if err := env.finish.Execute(sessionID, attestation.ClientDataJSON, attestation.AttestationObject, ""); err != nil {
+					t.Fatalf("Finish() error = %v", err)
+				}
+			}
+
+			// when
+			err = env.finish.Execute(sessionID, attestation.ClientDataJSON, attestation.AttestationObject, tt.passkeyName)
+
+			// then
+			if !errors.Is(err, tt.expectedErr) {
+				t.Errorf("Finish() error = %v, want %v", err, tt.expectedErr)
+			}
+			if !tt.replay {
+				if passkeys, _ := env.passkeys.FindByUserID("user-1"); len(passkeys) != 0 {
+					t.Errorf("passkey should not be saved: %v", passkeys)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package account
+
+import (
+	"errors"
+	"oauth-tutorial/internal/domain"
+	"oauth-tutorial/internal/infrastructure"
+	"oauth-tutorial/internal/session"
+	"oauth-tutorial/pkg/mylogger"
+	"time"
+)
+
+var (
+	ErrTOTPAlreadyEnabled       = errors.New("totp is already enabled")
+	ErrTOTPEnrollmentNotStarted = errors.New("totp enrollment is not started")
+	ErrInvalidTOTPCode          = errors.New("invalid totp code")
+)
+
+// TOTPの登録状況を取得するユースケース
+type GetTOTPStatusUseCase struct {
+	logger         mylogger.Logger
+	sessionStore   ISessionStorage
+	totpRepository ITOTPRepository
+}
+
+func NewGetTOTPStatusUseCase(logger mylogger.Logger, ss ISessionStorage, tr ITOTPRepository) *GetTOTPStatusUseCase {
+	return &GetTOTPStatusUseCase{logger: logger, sessionStore: ss, totpRepository: tr}
+}
+
+func (uc *GetTOTPStatusUseCase) Execute(sessionID session.SessionID) (TOTPStatus, error) {
+	user, err := authenticatedUser(uc.logger, uc.sessionStore, sessionID)
+	if err != nil {
+		return TOTPStatus{}, err
+	}
+	credential, err := findTOTPCredential(uc.totpRepository, user.UserID())
+	if err != nil {
+		uc.logger.Error("Failed to find TOTP credential", "err", err)
+		return TOTPStatus{}, ErrUnexpected
+	}
+	if credential == nil || !credential.Enabled() {
+		return TOTPStatus{}, nil
+	}
+	return NewTOTPStatus(true, len(credential.RecoveryCodeHashes())), nil
+}
+
+// TOTPの登録を始めるユースケース
+// シークレットを生成して確認待ちの状態で保存し、認証アプリに登録するためのURIを返す
+// 確認待ちの登録がある場合は新しいシークレットで置き換える
+type StartTOTPEnrollmentUseCase struct {
+	logger          mylogger.Logger
+	sessionStore    ISessionStorage
+	totpRepository  ITOTPRepository
+	randomGenerator IRandomGenerator
+	// 認証アプリに表示する発行者名
+	issuerName string
+}
+
+func NewStartTOTPEnrollmentUseCase(logger mylogger.Logger, ss ISessionStorage, tr ITOTPRepository, rg IRandomGenerator, issuerName string) *StartTOTPEnrollmentUseCase {
+	return &StartTOTPEnrollmentUseCase{logger: logger, sessionStore: ss, totpRepository: tr, randomGenerator: rg, issuerName: issuerName}
+}
+
+func (uc *StartTOTPEnrollmentUseCase) Execute(sessionID session.SessionID) (TOTPEnrollment, error) {
+	user, err := authenticatedUser(uc.logger, uc.sessionStore, sessionID)
+	if err != nil {
+		return TOTPEnrollment{}, err
+	}
+	current, err := findTOTPCredential(uc.totpRepository, user.UserID())
+	if err != nil {
+		uc.logger.Error("Failed to find TOTP credential", "err", err)
+		return TOTPEnrollment{}, ErrUnexpected
+	}
+	// 登録済みのシークレットを画面から置き換えられないよう、有効にした後は登録し直せない
+	if current != nil && current.Enabled() {
+		return TOTPEnrollment{}, ErrTOTPAlreadyEnabled
+	}
+
+	credential := domain.NewTOTPCredential(user.UserID(), uc.randomGenerator.GenerateRandomBytes(domain.TOTPSecretBytes))
+	if err := uc.totpRepository.Save(credential); err != nil {
+		uc.logger.Error("Failed to save TOTP credential", "err", err)
+		return TOTPEnrollment{}, ErrUnexpected
+	}
+	uc.logger.Info("TOTP enrollment started", "userID", user.UserID())
+	return NewTOTPEnrollment(credential.ProvisioningURI(uc.issuerName, user.LoginID()), credential.EncodedSecret()), nil
+}
+
+// 認証アプリに表示された最初のコードを確認し、TOTPを有効にするユースケース
+// 有効にした時点でリカバリーコードを発行し、平文のリカバリーコードはこの時のみ返す
+type ConfirmTOTPEnrollmentUseCase struct {
+	logger          mylogger.Logger
+	sessionStore    ISessionStorage
+	totpRepository  ITOTPRepository
+	randomGenerator IRandomGenerator
+}
+
+func NewConfirmTOTPEnrollmentUseCase(logger mylogger.Logger, ss ISessionStorage, tr ITOTPRepository, rg IRandomGenerator) *ConfirmTOTPEnrollmentUseCase {
+	return &ConfirmTOTPEnrollmentUseCase{logger: logger, sessionStore: ss, totpRepository: tr, randomGenerator: rg}
+}
+
+func (uc *ConfirmTOTPEnrollmentUseCase) Execute(sessionID session.SessionID, code string) ([]string, error) {
+	now := time.Now()
+	user, err := authenticatedUser(uc.logger, uc.sessionStore, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	credential, err := findTOTPCredential(uc.totpRepository, user.UserID())
+	if err != nil {
+		uc.logger.Error("Failed to find TOTP credential", "err", err)
+		return nil, ErrUnexpected
+	}
+	if credential == nil {
+		return nil, ErrTOTPEnrollmentNotStarted
+	}
+	if credential.Enabled() {
+		return nil, ErrTOTPAlreadyEnabled
+	}
+	step, ok := credential.MatchStep(code, now)
+	if !ok {
+		mylogger.SecurityEvent(uc.logger, "totp_enrollment_failed", "userID", user.UserID())
+		return nil, ErrInvalidTOTPCode
+	}
+
+	recoveryCodes := make([]string, 0, domain.RecoveryCodeCount)
+	hashes := make([]string, 0, domain.RecoveryCodeCount)
+	for range domain.RecoveryCodeCount {
+		code := domain.NewRecoveryCode(uc.randomGenerator.GenerateRandomBytes(domain.RecoveryCodeBytes))
+		recoveryCodes = append(recoveryCodes, code)
+		hashes = append(hashes, domain.HashRecoveryCode(code))
+	}
+	// 確認に使ったコードはログインで再び使えないよう、最後に使ったステップとして記録する
+	if err := uc.totpRepository.Save(credential.Confirm(now, step, hashes)); err != nil {
+		uc.logger.Error("Failed to save TOTP credential", "err", err)
+		return nil, ErrUnexpected
+	}
+	mylogger.AuditEvent(uc.logger, "totp_enabled", "userID", user.UserID(), "loginID", user.LoginID())
+	return recoveryCodes, nil
+}
+
+// ユーザーのTOTPの登録を取得する。登録がない場合はnilを返す
+func findTOTPCredential(tr ITOTPRepository, userID string) (*domain.TOTPCredential, error) {
+	credential, err := tr.FindByUserID(userID)
+	if errors.Is(err, infrastructure.ErrTOTPCredentialNotFound) {
+		return nil, nil
+	}
+	return credential, err
+}
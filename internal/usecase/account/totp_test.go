@@ -0,0 +1,110 @@
+package account
+
+import (
+	"errors"
+	"oauth-tutorial/internal/domain"
+	"oauth-tutorial/internal/infrastructure"
+	"oauth-tutorial/pkg/mylogger"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 呼び出す毎に異なる値(1, 2, 3...で埋めたバイト列)を返す
+type mockRandomGenerator struct {
+	calls byte
+}
+
+func (m *mockRandomGenerator) GenerateRandomBytes(n int) []byte {
+	m.calls++
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = m.calls
+	}
+	return b
+}
+
+func Test_TOTPの登録(t *testing.T) {
+	logger := mylogger.NewMockLogger()
+	ss := newTestSessionStorage()
+	tr := infrastructure.NewTOTPRepository()
+	rg := &mockRandomGenerator{}
+	status := NewGetTOTPStatusUseCase(logger, ss, tr)
+	start := NewStartTOTPEnrollmentUseCase(logger, ss, tr, rg, "auth.example.com")
+	confirm := NewConfirmTOTPEnrollmentUseCase(logger, ss, tr, rg)
+
+	// given
+	if s, err := status.Execute(authenticatedSessionID); err != nil || s.Enabled() {
+		t.Fatalf("status before enrollment = %+v, %v, want disabled", s, err)
+	}
+	if _, err := confirm.Execute(authenticatedSessionID, "123456"); !errors.Is(err, ErrTOTPEnrollmentNotStarted) {
+		t.Fatalf("Confirm() before start error = %v, want %v", err, ErrTOTPEnrollmentNotStarted)
+	}
+
+	// when
+	enrollment, err := start.Execute(authenticatedSessionID)
+	if err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	// then
+	if !strings.HasPrefix(enrollment.ProvisioningURI(), "otpauth://totp/auth.example.com:user@example.com?") ||
+		!strings.Contains(enrollment.ProvisioningURI(), "secret="+enrollment.Secret()) {
+		t.Errorf("ProvisioningURI() = %s", enrollment.ProvisioningURI())
+	}
+	// 確認するまではログイン時にコードを求めない
+	if s, _ := status.Execute(authenticatedSessionID); s.Enabled() {
+		t.Error("TOTP should not be enabled before confirmation")
+	}
+	pending, _ := tr.FindByUserID("user-1")
+	// 許容する時計のずれより後のコード
+	if _, err := confirm.Execute(authenticatedSessionID, domain.TOTPCode(pending.Secret(), time.Now().Add(10*domain.TOTPPeriod))); !errors.Is(err, ErrInvalidTOTPCode) {
+		t.Errorf("Confirm() with a wrong code error = %v, want %v", err, ErrInvalidTOTPCode)
+	}
+
+	recoveryCodes, err := confirm.Execute(authenticatedSessionID, domain.TOTPCode(pending.Secret(), time.Now()))
+	if err != nil {
+		t.Fatalf("Confirm() error = %v", err)
+	}
+	if len(recoveryCodes) != domain.RecoveryCodeCount || slices.Contains(recoveryCodes[1:], recoveryCodes[0]) {
+		t.Errorf("recovery codes = %v, want %d distinct codes", recoveryCodes, domain.RecoveryCodeCount)
+	}
+	confirmed, _ := tr.FindByUserID("user-1")
+	for _, code := range recoveryCodes {
+		if !confirmed.HasRecoveryCode(code) {
+			t.Errorf("recovery code %s should be saved", code)
+		}
+	}
+	// 確認に使ったコードはログインで使えない
+	if _, ok := confirmed.MatchStep(domain.TOTPCode(pending.Secret(), time.Now()), time.Now()); ok {
+		t.Error("the code used for confirmation should not be accepted again")
+	}
+	if s, _ := status.Execute(authenticatedSessionID); !s.Enabled() || s.RemainingRecoveryCodes() != domain.RecoveryCodeCount {
+		t.Errorf("status after confirmation = %+v", s)
+	}
+	// 有効にした後は登録し直せない
+	if _, err := start.Execute(authenticatedSessionID); !errors.Is(err, ErrTOTPAlreadyEnabled) {
+		t.Errorf("Start() after confirmation error = %v, want %v", err, ErrTOTPAlreadyEnabled)
+	}
+	if _, err := confirm.Execute(authenticatedSessionID, "123456"); !errors.Is(err, ErrTOTPAlreadyEnabled) {
+		t.Errorf("Confirm() after confirmation error = %v, want %v", err, ErrTOTPAlreadyEnabled)
+	}
+}
+
+func Test_TOTPの登録は未ログインでは使えない(t *testing.T) {
+	logger := mylogger.NewMockLogger()
+	ss := newTestSessionStorage()
+	tr := infrastructure.NewTOTPRepository()
+	rg := &mockRandomGenerator{}
+
+	if _, err := NewGetTOTPStatusUseCase(logger, ss, tr).Execute(anonymousSessionID); !errors.Is(err, ErrLoginRequired) {
+		t.Errorf("Status() error = %v, want %v", err, ErrLoginRequired)
+	}
+	if _, err := NewStartTOTPEnrollmentUseCase(logger, ss, tr, rg, "auth.example.com").Execute(anonymousSessionID); !errors.Is(err, ErrLoginRequired) {
+		t.Errorf("Start() error = %v, want %v", err, ErrLoginRequired)
+	}
+	if _, err := NewConfirmTOTPEnrollmentUseCase(logger, ss, tr, rg).Execute("", "123456"); !errors.Is(err, ErrLoginRequired) {
+		t.Errorf("Confirm() error = %v, want %v", err, ErrLoginRequired)
+	}
+}
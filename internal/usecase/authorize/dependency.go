@@ -11,9 +11,30 @@ type IClientRepository interface {
 }
 
 type ISessionStorage interface {
+	Get(sessionID session.SessionID) (*inf_dto.SessionData, error)
 	Save(sessionID session.SessionID, sessionData *inf_dto.SessionData) error
 }
 
 type ISessionIDGenerator interface {
 	Generate() session.SessionID
 }
+
+type ITransactionStorage interface {
+	Save(transaction *inf_dto.AuthorizationTransaction) error
+}
+
+type ITransactionIDGenerator interface {
+	Generate() session.TransactionID
+}
+
+type IRandomCodeGenerator interface {
+	GenerateURLSafeRandomString(n int) string
+}
+
+type IAuthorizationCodeRepository interface {
+	Save(code *domain.AuthorizationCode) error
+}
+
+type IConsentRepository interface {
+	FindByUserAndClient(userID string, clientID string) (*domain.Consent, error)
+}
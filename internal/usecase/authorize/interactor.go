@@ -2,26 +2,43 @@ package authorize
 
 import (
 	"errors"
+	"fmt"
 	"oauth-tutorial/internal/domain"
 	"oauth-tutorial/internal/infrastructure"
 	inf_dto "oauth-tutorial/internal/infrastructure/dto"
 	"oauth-tutorial/internal/session"
 	"oauth-tutorial/pkg/mylogger"
+	"time"
 )
 
 type AuthorizationCodeFlow struct {
-	logger             mylogger.Logger
-	clientRepository   IClientRepository
-	sessionStore       ISessionStorage
-	sessionIDGenerator ISessionIDGenerator
+	logger                 mylogger.Logger
+	clientRepository       IClientRepository
+	sessionStore           ISessionStorage
+	sessionIDGenerator     ISessionIDGenerator
+	transactionStore       ITransactionStorage
+	transactionIDGenerator ITransactionIDGenerator
+	randomCodeGenerator    IRandomCodeGenerator
+	authCodeRepository     IAuthorizationCodeRepository
+	consentRepository      IConsentRepository
+	lifetimes              domain.Lifetimes
+	// レルムのスコープの一覧
+	scopes *domain.ScopeProvider
 }
 
-func NewAuthorizationCodeFlow(logger mylogger.Logger, cr IClientRepository, sessionIDGenerator ISessionIDGenerator, sessionStorage ISessionStorage) *AuthorizationCodeFlow {
+func NewAuthorizationCodeFlow(logger mylogger.Logger, cr IClientRepository, sessionIDGenerator ISessionIDGenerator, sessionStorage ISessionStorage, transactionIDGenerator ITransactionIDGenerator, transactionStorage ITransactionStorage, randomCodeGenerator IRandomCodeGenerator, authCodeRepository IAuthorizationCodeRepository, consentRepository IConsentRepository, lifetimes domain.Lifetimes, scopes *domain.ScopeProvider) *AuthorizationCodeFlow {
 	return &AuthorizationCodeFlow{
-		logger:             logger,
-		clientRepository:   cr,
-		sessionIDGenerator: sessionIDGenerator,
-		sessionStore:       sessionStorage,
+		logger:                 logger,
+		clientRepository:       cr,
+		sessionIDGenerator:     sessionIDGenerator,
+		sessionStore:           sessionStorage,
+		transactionIDGenerator: transactionIDGenerator,
+		transactionStore:       transactionStorage,
+		randomCodeGenerator:    randomCodeGenerator,
+		authCodeRepository:     authCodeRepository,
+		consentRepository:      consentRepository,
+		lifetimes:              lifetimes,
+		scopes:                 scopes,
 	}
 }
 
@@ -30,40 +47,132 @@ var (
 	ErrUnExpected         = errors.New("unexpected error occurred")
 	ErrInvalidRedirectURI = errors.New("invalid redirect URI")
 	ErrServer             = errors.New("server error occurred")
+	ErrUnauthorizedClient = errors.New("client is not allowed to use the response type")
+	ErrInvalidScope       = errors.New("invalid scope")
 )
 
-func (c *AuthorizationCodeFlow) Execute(param *domain.AuthorizationCodeFlowParam) (session.SessionID, error) {
-	cr := c.clientRepository
-	client, err := cr.SelectByClientID(domain.ClientID(param.ClientID()))
+// クライアントが存在し、リダイレクトURIが登録済みか検証する
+// 検証を満たすまでは、エラーをリダイレクトURIに返してはならない RFC 6749 4.1.2.1
+func (c *AuthorizationCodeFlow) VerifyRedirectURI(clientID string, redirectURI string) error {
+	_, err := c.verifyClient(clientID, redirectURI)
+	return err
+}
+
+func (c *AuthorizationCodeFlow) verifyClient(clientID string, redirectURI string) (*domain.Client, error) {
+	client, err := c.clientRepository.SelectByClientID(domain.ClientID(clientID))
 	if err != nil {
 		switch {
 		case errors.Is(err, infrastructure.ErrClientNotFound):
-			c.logger.Info("client not found", "clientID", param.ClientID())
-			return "", ErrClientNotFound
+			c.logger.Info("client not found", "clientID", clientID)
+			return nil, ErrClientNotFound
 		default:
 			c.logger.Error("unexpected error occured", "error", err)
-			return "", ErrUnExpected
+			return nil, ErrUnExpected
+		}
+	}
+
+	if !client.ContainsRedirectURI(redirectURI) {
+		c.logger.Info("invalid Redirect URI", "redirectURI", redirectURI)
+		return nil, ErrInvalidRedirectURI
+	}
+	return client, nil
+}
+
+// sessionIDにはCookieで受け取ったブラウザセッションのIDを渡す(存在しない場合は空文字)
+func (c *AuthorizationCodeFlow) Execute(param *domain.AuthorizationCodeFlowParam, sessionID session.SessionID) (AuthorizationCodeFlowOutput, error) {
+	now := time.Now()
+	client, err := c.verifyClient(param.ClientID(), param.RedirectURI())
+	if err != nil {
+		return AuthorizationCodeFlowOutput{}, err
+	}
+
+	// クライアントに許可されたresponse_typeとスコープか検証する。scopeを省略した場合はクライアントの既定のスコープにする
+	if !client.AllowsResponseType(param.ResponseType()) {
+		c.logger.Info("response type not allowed for the client", "clientID", param.ClientID(), "responseType", param.ResponseType().String())
+		return AuthorizationCodeFlowOutput{}, ErrUnauthorizedClient
+	}
+	registry := c.scopes.Current()
+	scopes, err := client.ResolveScopes(registry, param.Scopes())
+	if err != nil {
+		c.logger.Info("invalid scope for the client", "clientID", param.ClientID(), "scopes", param.Scopes(), "error", err)
+		return AuthorizationCodeFlowOutput{}, fmt.Errorf("%w: %w", ErrInvalidScope, err)
+	}
+	param = param.WithScopes(scopes)
+
+	// ブラウザセッションの取得。存在しなければ未ログインのセッションを新規作成する
+	sessionID, sessionData, err := c.resolveSession(sessionID)
+	if err != nil {
+		return AuthorizationCodeFlowOutput{}, err
+	}
+
+	// ログイン済みかつ同意済みであれば、ログイン・同意画面を経由せずに認可コードを発行する
+	if sessionData.IsAuthenticated() && c.hasConsent(registry, sessionData.User().UserID(), param, now) {
+		authorizationCode := domain.NewAuthorizationCode(c.randomCodeGenerator, sessionData.User().UserID(), param.ClientID(), param.Scopes(), param.RedirectURI(), now, client.Lifetimes(c.lifetimes).AuthorizationCode)
+		if err := c.authCodeRepository.Save(authorizationCode); err != nil {
+			c.logger.Error("failed to save authorization code", "error", err)
+			return AuthorizationCodeFlowOutput{}, ErrUnExpected
 		}
+		c.logger.Info("authorization code issued without prompt", "clientID", param.ClientID())
+		return NewAuthorizationCodeIssuedOutput(sessionID, param.RedirectURI(), authorizationCode.Value(), param.State()), nil
 	}
 
-	if !client.ContainsRedirectURI(param.RedirectURI()) {
-		c.logger.Info("invalid Redirect URI", "redirectURI", param.RedirectURI())
-		return "", ErrInvalidRedirectURI
+	// 認可リクエスト毎のトランザクションを作成し、ブラウザセッションに紐づける
+	transaction := inf_dto.NewAuthorizationTransaction(c.transactionIDGenerator.Generate(), sessionID, param, now)
+	err = c.transactionStore.Save(transaction)
+	if err != nil {
+		return AuthorizationCodeFlowOutput{}, c.handleStorageError(err)
 	}
 
-	sessionID := c.sessionIDGenerator.Generate()
+	prompt := PromptLogin
+	if sessionData.IsAuthenticated() {
+		prompt = PromptConsent
+	}
+
+	return NewAuthorizationCodeFlowOutput(sessionID, transaction.ID(), prompt, client.ClientName(), param.Scopes()), nil
+}
 
-	err = c.sessionStore.Save(sessionID, inf_dto.NewSessionData(param, nil))
+// 有効期限内の同意があり、要求されたスコープが全て同意済みかどうか
+func (c *AuthorizationCodeFlow) hasConsent(registry *domain.ScopeRegistry, userID string, param *domain.AuthorizationCodeFlowParam, now time.Time) bool {
+	consent, err := c.consentRepository.FindByUserAndClient(userID, param.ClientID())
 	if err != nil {
+		if !errors.Is(err, infrastructure.ErrConsentNotFound) {
+			c.logger.Error("unexpected error occured", "error", err)
+		}
+		return false
+	}
+	return !consent.IsExpired(now) && consent.Covers(registry, param.Scopes())
+}
+
+func (c *AuthorizationCodeFlow) resolveSession(sessionID session.SessionID) (session.SessionID, *inf_dto.SessionData, error) {
+	if sessionID != "" {
+		sessionData, err := c.sessionStore.Get(sessionID)
 		switch {
-		case errors.Is(err, infrastructure.ErrInvalidSessionID) || errors.Is(err, infrastructure.ErrInvalidSessionData):
-			c.logger.Info("invalid session parameter", "error", err)
-			return "", ErrServer
-		default:
+		case err == nil && sessionData != nil:
+			return sessionID, sessionData, nil
+		case err != nil && !errors.Is(err, infrastructure.ErrSessionNotFound):
 			c.logger.Error("unexpected error occured", "error", err)
-			return "", ErrUnExpected
+			return "", nil, ErrUnExpected
 		}
 	}
 
-	return sessionID, nil
+	newSessionID := c.sessionIDGenerator.Generate()
+	sessionData := inf_dto.NewSessionData(nil, time.Time{}, nil)
+	err := c.sessionStore.Save(newSessionID, sessionData)
+	if err != nil {
+		return "", nil, c.handleStorageError(err)
+	}
+
+	return newSessionID, sessionData, nil
+}
+
+func (c *AuthorizationCodeFlow) handleStorageError(err error) error {
+	switch {
+	case errors.Is(err, infrastructure.ErrInvalidSessionID) || errors.Is(err, infrastructure.ErrInvalidSessionData),
+		errors.Is(err, infrastructure.ErrInvalidTransactionID) || errors.Is(err, infrastructure.ErrInvalidTransactionData):
+		c.logger.Info("invalid session parameter", "error", err)
+		return ErrServer
+	default:
+		c.logger.Error("unexpected error occured", "error", err)
+		return ErrUnExpected
+	}
 }
@@ -7,7 +7,9 @@ import (
 	inf_dto "oauth-tutorial/internal/infrastructure/dto"
 	"oauth-tutorial/internal/session"
 	"oauth-tutorial/pkg/mylogger"
+	"reflect"
 	"testing"
+	"time"
 )
 
 type MockClientRepository struct {
@@ -44,22 +46,89 @@ func (m *MockSessionIdGenerator) Generate() session.SessionID {
 }
 
 type MockSessionStorage struct {
-	err error
+	err      error
+	sessions map[session.SessionID]*inf_dto.SessionData
 }
 
 func NewMockSessionStorage(err error) *MockSessionStorage {
 	return &MockSessionStorage{
-		err: err,
+		err:      err,
+		sessions: map[session.SessionID]*inf_dto.SessionData{},
 	}
 }
 
+func (m *MockSessionStorage) Get(sessionID session.SessionID) (*inf_dto.SessionData, error) {
+	sessionData, ok := m.sessions[sessionID]
+	if !ok {
+		return nil, infrastructure.ErrSessionNotFound
+	}
+	return sessionData, nil
+}
+
 func (m *MockSessionStorage) Save(sessionID session.SessionID, sessiondata *inf_dto.SessionData) error {
 	if m.err != nil {
 		return m.err
 	}
+	m.sessions[sessionID] = sessiondata
+	return nil
+}
+
+type MockTransactionIDGenerator struct{}
+
+func (m *MockTransactionIDGenerator) Generate() session.TransactionID {
+	return "test-transaction-id"
+}
+
+type MockTransactionStorage struct {
+	err          error
+	transactions map[session.TransactionID]*inf_dto.AuthorizationTransaction
+}
+
+func NewMockTransactionStorage(err error) *MockTransactionStorage {
+	return &MockTransactionStorage{
+		err:          err,
+		transactions: map[session.TransactionID]*inf_dto.AuthorizationTransaction{},
+	}
+}
+
+func (m *MockTransactionStorage) Save(transaction *inf_dto.AuthorizationTransaction) error {
+	if m.err != nil {
+		return m.err
+	}
+	m.transactions[transaction.ID()] = transaction
+	return nil
+}
+
+type MockRandomCodeGenerator struct{}
+
+func (m *MockRandomCodeGenerator) GenerateURLSafeRandomString(n int) string {
+	return "test-authorization-code"
+}
+
+type MockAuthCodeRepository struct {
+	codes []*domain.AuthorizationCode
+}
+
+func (m *MockAuthCodeRepository) Save(code *domain.AuthorizationCode) error {
+	m.codes = append(m.codes, code)
 	return nil
 }
 
+type MockConsentRepository struct {
+	consent *domain.Consent
+}
+
+func (m *MockConsentRepository) FindByUserAndClient(userID string, clientID string) (*domain.Consent, error) {
+	if m.consent == nil || m.consent.UserID() != userID || m.consent.ClientID() != clientID {
+		return nil, infrastructure.ErrConsentNotFound
+	}
+	return m.consent, nil
+}
+
+func newTestFlow(logger mylogger.Logger, cr IClientRepository, sig ISessionIDGenerator, ss ISessionStorage) *AuthorizationCodeFlow {
+	return NewAuthorizationCodeFlow(logger, cr, sig, ss, &MockTransactionIDGenerator{}, NewMockTransactionStorage(nil), &MockRandomCodeGenerator{}, &MockAuthCodeRepository{}, &MockConsentRepository{}, domain.DefaultLifetimes(), domain.NewScopeProvider(nil))
+}
+
 func Test_認可コードフローユースケース(t *testing.T) {
 	validClient := domain.ReconstructClient(
 		"test-client",
@@ -72,6 +141,7 @@ func Test_認可コードフローユースケース(t *testing.T) {
 	logger := mylogger.NewMockLogger()
 	validParam, err := domain.NewAuthorizationCodeFlowParam(
 		logger,
+		domain.DefaultScopeRegistry(),
 		"code",
 		"test-client",
 		"https://example.com/callback",
@@ -84,6 +154,7 @@ func Test_認可コードフローユースケース(t *testing.T) {
 
 	invalidRedirectParam, err := domain.NewAuthorizationCodeFlowParam(
 		logger,
+		domain.DefaultScopeRegistry(),
 		"code",
 		"test-client",
 		"https://malicious.com/callback",
@@ -108,7 +179,7 @@ func Test_認可コードフローユースケース(t *testing.T) {
 				cr := NewMockClientRepository(validClient, nil)
 				ss := NewMockSessionStorage(nil)
 				sig := NewMockSessionIdGenerator("test-session-id")
-				return NewAuthorizationCodeFlow(logger, cr, sig, ss)
+				return newTestFlow(logger, cr, sig, ss)
 			},
 			wantErr:     false,
 			expectedErr: nil,
@@ -120,7 +191,7 @@ func Test_認可コードフローユースケース(t *testing.T) {
 				clientRepo := NewMockClientRepository(nil, infrastructure.ErrClientNotFound)
 				sessionStore := NewMockSessionStorage(nil)
 				sig := NewMockSessionIdGenerator("test-session-id")
-				return NewAuthorizationCodeFlow(logger, clientRepo, sig, sessionStore)
+				return newTestFlow(logger, clientRepo, sig, sessionStore)
 			},
 			wantErr:     true,
 			expectedErr: ErrClientNotFound,
@@ -132,7 +203,7 @@ func Test_認可コードフローユースケース(t *testing.T) {
 				clientRepo := NewMockClientRepository(nil, errors.New("database error"))
 				sessionStore := NewMockSessionStorage(nil)
 				sig := NewMockSessionIdGenerator("test-session-id")
-				return NewAuthorizationCodeFlow(logger, clientRepo, sig, sessionStore)
+				return newTestFlow(logger, clientRepo, sig, sessionStore)
 			},
 			wantErr:     true,
 			expectedErr: ErrUnExpected,
@@ -144,7 +215,7 @@ func Test_認可コードフローユースケース(t *testing.T) {
 				clientRepo := NewMockClientRepository(validClient, nil)
 				sessionStore := NewMockSessionStorage(nil)
 				sig := NewMockSessionIdGenerator("test-session-id")
-				return NewAuthorizationCodeFlow(logger, clientRepo, sig, sessionStore)
+				return newTestFlow(logger, clientRepo, sig, sessionStore)
 			},
 			wantErr:     true,
 			expectedErr: ErrInvalidRedirectURI,
@@ -156,7 +227,7 @@ func Test_認可コードフローユースケース(t *testing.T) {
 				clientRepo := NewMockClientRepository(validClient, nil)
 				sessionStore := NewMockSessionStorage(infrastructure.ErrInvalidSessionID)
 				sig := NewMockSessionIdGenerator("test-session-id")
-				return NewAuthorizationCodeFlow(logger, clientRepo, sig, sessionStore)
+				return newTestFlow(logger, clientRepo, sig, sessionStore)
 			},
 			wantErr:     true,
 			expectedErr: ErrServer,
@@ -168,7 +239,7 @@ func Test_認可コードフローユースケース(t *testing.T) {
 				clientRepo := NewMockClientRepository(validClient, nil)
 				sessionStore := NewMockSessionStorage(infrastructure.ErrInvalidSessionData)
 				sig := NewMockSessionIdGenerator("test-session-id")
-				return NewAuthorizationCodeFlow(logger, clientRepo, sig, sessionStore)
+				return newTestFlow(logger, clientRepo, sig, sessionStore)
 			},
 			wantErr:     true,
 			expectedErr: ErrServer,
@@ -180,7 +251,7 @@ func Test_認可コードフローユースケース(t *testing.T) {
 				clientRepo := NewMockClientRepository(validClient, nil)
 				sessionStore := NewMockSessionStorage(errors.New("unexpected error"))
 				sig := NewMockSessionIdGenerator("test-session-id")
-				return NewAuthorizationCodeFlow(logger, clientRepo, sig, sessionStore)
+				return newTestFlow(logger, clientRepo, sig, sessionStore)
 			},
 			wantErr:     true,
 			expectedErr: ErrUnExpected,
@@ -193,7 +264,7 @@ func Test_認可コードフローユースケース(t *testing.T) {
 			flow := tt.setupFunc()
 
 			// when
-			sessionID, err := flow.Execute(tt.param)
+			output, err := flow.Execute(tt.param, "")
 
 			// then
 			if tt.wantErr {
@@ -208,9 +279,243 @@ func Test_認可コードフローユースケース(t *testing.T) {
 				if err != nil {
 					t.Errorf("Execute() error = %v, wantErr %v", err, tt.wantErr)
 				}
-				if sessionID != "test-session-id" {
-					t.Errorf("Execute() sessionID = %v, want %v", sessionID, "test-session-id")
+				if output.SessionID() != "test-session-id" {
+					t.Errorf("Execute() sessionID = %v, want %v", output.SessionID(), "test-session-id")
+				}
+				if output.TransactionID() != "test-transaction-id" {
+					t.Errorf("Execute() transactionID = %v, want %v", output.TransactionID(), "test-transaction-id")
+				}
+				if output.Prompt() != PromptLogin {
+					t.Errorf("Execute() prompt = %v, want %v", output.Prompt(), PromptLogin)
+				}
+			}
+		})
+	}
+}
+
+func Test_認可コードフローユースケース_ブラウザセッション(t *testing.T) {
+	validClient := domain.ReconstructClient(
+		"test-client",
+		"Test Client",
+		domain.ConfidentialClient,
+		"test-secret",
+		[]string{"https://example.com/callback"},
+	)
+	logger := mylogger.NewMockLogger()
+	param, err := domain.NewAuthorizationCodeFlowParam(logger, domain.DefaultScopeRegistry(), "code", "test-client", "https://example.com/callback", "read write", "test-state")
+	if err != nil {
+		t.Fatalf("Failed to create AuthorizationCodeFlowParam: %v", err)
+	}
+	user := domain.ReconstructUser("user-1", "user@example.com", "password")
+	loggedIn := inf_dto.NewSessionData(user, time.Now(), []string{"pwd"})
+
+	tests := []struct {
+		name             string
+		cookieSessionID  session.SessionID
+		storedSession    *inf_dto.SessionData
+		consent          *domain.Consent
+		expectedSession  session.SessionID
+		expectedPrompt   Prompt
+		expectedCodeSave bool
+	}{
+		{
+			name:            "未ログインの既存セッションはそのまま使い、ログインを求める",
+			cookieSessionID: "existing-session-id",
+			storedSession:   inf_dto.NewSessionData(nil, time.Time{}, nil),
+			expectedSession: "existing-session-id",
+			expectedPrompt:  PromptLogin,
+		},
+		{
+			name:            "Cookieのセッションが存在しない場合は新規セッションを払い出す",
+			cookieSessionID: "unknown-session-id",
+			expectedSession: "test-session-id",
+			expectedPrompt:  PromptLogin,
+		},
+		{
+			name:            "ログイン済みで未同意の場合は同意のみを求める",
+			cookieSessionID: "existing-session-id",
+			storedSession:   loggedIn,
+			expectedSession: "existing-session-id",
+			expectedPrompt:  PromptConsent,
+		},
+		{
+			name:            "ログイン済みでも一部のスコープしか同意していない場合は同意を求める",
+			cookieSessionID: "existing-session-id",
+			storedSession:   loggedIn,
+			consent:         domain.NewConsent("user-1", "test-client", []string{"read"}, time.Now(), 0),
+			expectedSession: "existing-session-id",
+			expectedPrompt:  PromptConsent,
+		},
+		{
+			name:            "ログイン済みでも同意の有効期限が切れている場合は同意を求める",
+			cookieSessionID: "existing-session-id",
+			storedSession:   loggedIn,
+			consent:         domain.NewConsent("user-1", "test-client", []string{"read", "write"}, time.Now().Add(-2*time.Hour), time.Hour),
+			expectedSession: "existing-session-id",
+			expectedPrompt:  PromptConsent,
+		},
+		{
+			name:            "別のユーザーの同意は使わない",
+			cookieSessionID: "existing-session-id",
+			storedSession:   loggedIn,
+			consent:         domain.NewConsent("user-2", "test-client", []string{"read", "write"}, time.Now(), 0),
+			expectedSession: "existing-session-id",
+			expectedPrompt:  PromptConsent,
+		},
+		{
+			name:             "ログイン済みかつ同意済みの場合は認可コードを発行する",
+			cookieSessionID:  "existing-session-id",
+			storedSession:    loggedIn,
+			consent:          domain.NewConsent("user-1", "test-client", []string{"read", "write"}, time.Now(), 0),
+			expectedSession:  "existing-session-id",
+			expectedPrompt:   PromptNone,
+			expectedCodeSave: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// given
+			ss := NewMockSessionStorage(nil)
+			if tt.storedSession != nil {
+				ss.sessions[tt.cookieSessionID] = tt.storedSession
+			}
+			ts := NewMockTransactionStorage(nil)
+			ar := &MockAuthCodeRepository{}
+			flow := NewAuthorizationCodeFlow(logger, NewMockClientRepository(validClient, nil), NewMockSessionIdGenerator("test-session-id"), ss, &MockTransactionIDGenerator{}, ts, &MockRandomCodeGenerator{}, ar, &MockConsentRepository{consent: tt.consent}, domain.DefaultLifetimes(), domain.NewScopeProvider(nil))
+
+			// when
+			output, err := flow.Execute(param, tt.cookieSessionID)
+
+			// then
+			if err != nil {
+				t.Fatalf("Execute() error = %v", err)
+			}
+			if output.SessionID() != tt.expectedSession {
+				t.Errorf("SessionID() = %v, want %v", output.SessionID(), tt.expectedSession)
+			}
+			if output.Prompt() != tt.expectedPrompt {
+				t.Errorf("Prompt() = %v, want %v", output.Prompt(), tt.expectedPrompt)
+			}
+
+			if tt.expectedCodeSave {
+				if len(ar.codes) != 1 {
+					t.Fatalf("saved codes = %d, want 1", len(ar.codes))
+				}
+				if ar.codes[0].UserID() != user.UserID() {
+					t.Errorf("code UserID() = %v, want %v", ar.codes[0].UserID(), user.UserID())
+				}
+				if output.AuthorizationCode() != "test-authorization-code" {
+					t.Errorf("AuthorizationCode() = %v, want %v", output.AuthorizationCode(), "test-authorization-code")
+				}
+				if output.State() != "test-state" {
+					t.Errorf("State() = %v, want %v", output.State(), "test-state")
+				}
+				if len(ts.transactions) != 0 {
+					t.Errorf("transaction should not be created when code is issued")
 				}
+				return
+			}
+
+			if len(ar.codes) != 0 {
+				t.Errorf("saved codes = %d, want 0", len(ar.codes))
+			}
+			transaction, ok := ts.transactions[output.TransactionID()]
+			if !ok {
+				t.Fatal("transaction should be saved")
+			}
+			if transaction.SessionID() != tt.expectedSession {
+				t.Errorf("transaction SessionID() = %v, want %v", transaction.SessionID(), tt.expectedSession)
+			}
+			if !reflect.DeepEqual(transaction.AuthParam(), param) {
+				t.Errorf("transaction AuthParam() = %v, want %v", transaction.AuthParam(), param)
+			}
+		})
+	}
+}
+
+func Test_認可コードフローユースケース_クライアントのポリシー(t *testing.T) {
+	logger := mylogger.NewMockLogger()
+	newParam := func(scope string) *domain.AuthorizationCodeFlowParam {
+		param, err := domain.NewAuthorizationCodeFlowParam(logger, domain.DefaultScopeRegistry(), "code", "test-client", "https://example.com/callback", scope, "test-state")
+		if err != nil {
+			t.Fatalf("Failed to create AuthorizationCodeFlowParam: %v", err)
+		}
+		return param
+	}
+	policy := domain.DefaultClientPolicy()
+	policy.Scopes = []string{"read"}
+	policy.DefaultScopes = []string{"read"}
+	restricted := domain.ReconstructClientWithPolicy("test-client", "Test Client", domain.ConfidentialClient, "test-secret", []string{"https://example.com/callback"}, policy)
+	noCode := domain.ReconstructClientWithPolicy("test-client", "Test Client", domain.ConfidentialClient, "test-secret", []string{"https://example.com/callback"},
+		domain.ClientPolicy{GrantTypes: []domain.GrantType{domain.GrantTypeRefreshToken}})
+	noDefault := domain.ReconstructClient("test-client", "Test Client", domain.ConfidentialClient, "test-secret", []string{"https://example.com/callback"})
+
+	tests := []struct {
+		name           string
+		client         *domain.Client
+		param          *domain.AuthorizationCodeFlowParam
+		expectedErr    error
+		expectedScopes []string
+	}{
+		{name: "許可されたスコープ", client: restricted, param: newParam("read"), expectedScopes: []string{"read"}},
+		{name: "scopeを省略した場合は既定のスコープ", client: restricted, param: newParam(""), expectedScopes: []string{"read"}},
+		{name: "許可されていないスコープ", client: restricted, param: newParam("read write"), expectedErr: ErrInvalidScope},
+		{name: "既定のスコープが無いクライアントでscopeを省略", client: noDefault, param: newParam(""), expectedErr: ErrInvalidScope},
+		{name: "許可されていないresponse_type", client: noCode, param: newParam("read"), expectedErr: ErrUnauthorizedClient},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// given
+			ts := NewMockTransactionStorage(nil)
+			flow := NewAuthorizationCodeFlow(logger, NewMockClientRepository(tt.client, nil), NewMockSessionIdGenerator("test-session-id"), NewMockSessionStorage(nil), &MockTransactionIDGenerator{}, ts, &MockRandomCodeGenerator{}, &MockAuthCodeRepository{}, &MockConsentRepository{}, domain.DefaultLifetimes(), domain.NewScopeProvider(nil))
+
+			// when
+			output, err := flow.Execute(tt.param, "")
+
+			// then
+			if !errors.Is(err, tt.expectedErr) {
+				t.Fatalf("Execute() error = %v, want %v", err, tt.expectedErr)
+			}
+			if tt.expectedErr != nil {
+				return
+			}
+			if !reflect.DeepEqual(output.Scopes(), tt.expectedScopes) {
+				t.Errorf("Scopes() = %v, want %v", output.Scopes(), tt.expectedScopes)
+			}
+			// 同意後に認可コードを発行する際も、解決したスコープを使う
+			if transaction := ts.transactions[output.TransactionID()]; !reflect.DeepEqual(transaction.AuthParam().Scopes(), tt.expectedScopes) {
+				t.Errorf("transaction scopes = %v, want %v", transaction.AuthParam().Scopes(), tt.expectedScopes)
+			}
+		})
+	}
+}
+
+func Test_認可コードフローユースケース_リダイレクトURIの検証(t *testing.T) {
+	client := domain.ReconstructClient("test-client", "Test Client", domain.ConfidentialClient, "test-secret", []string{"https://example.com/callback"})
+
+	tests := []struct {
+		name        string
+		repository  *MockClientRepository
+		redirectURI string
+		expectedErr error
+	}{
+		{name: "登録済みのリダイレクトURI", repository: NewMockClientRepository(client, nil), redirectURI: "https://example.com/callback"},
+		{name: "登録されていないリダイレクトURI", repository: NewMockClientRepository(client, nil), redirectURI: "https://malicious.example.com/callback", expectedErr: ErrInvalidRedirectURI},
+		{name: "クライアントが見つからない", repository: NewMockClientRepository(nil, infrastructure.ErrClientNotFound), redirectURI: "https://example.com/callback", expectedErr: ErrClientNotFound},
+		{name: "予期しないエラー", repository: NewMockClientRepository(nil, errors.New("db error")), redirectURI: "https://example.com/callback", expectedErr: ErrUnExpected},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// given
+			flow := NewAuthorizationCodeFlow(mylogger.NewMockLogger(), tt.repository, NewMockSessionIdGenerator("test-session-id"), NewMockSessionStorage(nil), &MockTransactionIDGenerator{}, NewMockTransactionStorage(nil), &MockRandomCodeGenerator{}, &MockAuthCodeRepository{}, &MockConsentRepository{}, domain.DefaultLifetimes(), domain.NewScopeProvider(nil))
+
+			// when
+			err := flow.VerifyRedirectURI("test-client", tt.redirectURI)
+
+			// then
+			if !errors.Is(err, tt.expectedErr) {
+				t.Errorf("VerifyRedirectURI() error = %v, want %v", err, tt.expectedErr)
 			}
 		})
 	}
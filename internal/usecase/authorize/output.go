@@ -0,0 +1,67 @@
+package authorize
+
+import "oauth-tutorial/internal/session"
+
+// 認可リクエスト受付後にユーザーへ求める次の操作
+type Prompt int
+
+const (
+	// 未ログインのためログインを求める
+	PromptLogin Prompt = iota
+	// ログイン済みのため同意のみを求める
+	PromptConsent
+	// ログイン済みかつ同意済みのため、認可コードを発行してリダイレクトする
+	PromptNone
+)
+
+func (p Prompt) String() string {
+	switch p {
+	case PromptLogin:
+		return "login"
+	case PromptConsent:
+		return "consent"
+	default:
+		return "none"
+	}
+}
+
+type AuthorizationCodeFlowOutput struct {
+	sessionID         session.SessionID
+	transactionID     session.TransactionID
+	prompt            Prompt
+	baseRedirectUri   string
+	authorizationCode string
+	state             string
+	// 同意画面に表示するクライアント名と要求されたスコープ
+	clientName string
+	scopes     []string
+}
+
+func NewAuthorizationCodeFlowOutput(sessionID session.SessionID, transactionID session.TransactionID, prompt Prompt, clientName string, scopes []string) AuthorizationCodeFlowOutput {
+	return AuthorizationCodeFlowOutput{
+		sessionID:     sessionID,
+		transactionID: transactionID,
+		prompt:        prompt,
+		clientName:    clientName,
+		scopes:        scopes,
+	}
+}
+
+func NewAuthorizationCodeIssuedOutput(sessionID session.SessionID, baseRedirectUri, authorizationCode, state string) AuthorizationCodeFlowOutput {
+	return AuthorizationCodeFlowOutput{
+		sessionID:         sessionID,
+		prompt:            PromptNone,
+		baseRedirectUri:   baseRedirectUri,
+		authorizationCode: authorizationCode,
+		state:             state,
+	}
+}
+
+func (o AuthorizationCodeFlowOutput) SessionID() session.SessionID         { return o.sessionID }
+func (o AuthorizationCodeFlowOutput) TransactionID() session.TransactionID { return o.transactionID }
+func (o AuthorizationCodeFlowOutput) Prompt() Prompt                       { return o.prompt }
+func (o AuthorizationCodeFlowOutput) BaseRedirectUri() string              { return o.baseRedirectUri }
+func (o AuthorizationCodeFlowOutput) AuthorizationCode() string            { return o.authorizationCode }
+func (o AuthorizationCodeFlowOutput) State() string                        { return o.state }
+func (o AuthorizationCodeFlowOutput) ClientName() string                   { return o.clientName }
+func (o AuthorizationCodeFlowOutput) Scopes() []string                     { return o.scopes }
@@ -4,6 +4,7 @@ import (
 	"oauth-tutorial/internal/domain"
 	inf_dto "oauth-tutorial/internal/infrastructure/dto"
 	"oauth-tutorial/internal/session"
+	"time"
 )
 
 type IRandomCodeGenerator interface {
@@ -12,13 +13,44 @@ type IRandomCodeGenerator interface {
 
 type ISessionStorage interface {
 	Get(sessionID session.SessionID) (*inf_dto.SessionData, error)
+	Save(sessionID session.SessionID, sessionData *inf_dto.SessionData) error
 	Delete(sessionID session.SessionID) error
 }
 
-type IUserRepository interface {
-	SelectByLoginIDAndPassword(loginID, password string) (*domain.User, error)
+type ISessionIDGenerator interface {
+	Generate() session.SessionID
+}
+
+type ITransactionStorage interface {
+	Get(transactionID session.TransactionID) (*inf_dto.AuthorizationTransaction, error)
+	Save(transaction *inf_dto.AuthorizationTransaction) error
+	Delete(transactionID session.TransactionID) error
+}
+
+// ログインIDとパスワードでユーザーを認証する。ユーザーの保存先や認証の方式毎に実装する
+// 認証に失敗した場合は失敗した理由を持つ結果を返し、保存先にアクセスできない場合などはエラーを返す
+type IAuthenticator interface {
+	Authenticate(loginID, password string) (domain.AuthenticationResult, error)
 }
 
 type IAuthorizationCodeRepository interface {
-	Save(code *domain.AuthorizationCode)
+	Save(code *domain.AuthorizationCode) error
+}
+
+type IConsentRepository interface {
+	FindByUserAndClient(userID string, clientID string) (*domain.Consent, error)
+	Save(consent *domain.Consent) error
+}
+
+type IClientRepository interface {
+	SelectByClientID(clientID domain.ClientID) (*domain.Client, error)
+}
+
+// ユーザーのTOTPの登録。使用済みのコード・リカバリーコードの記録は並行して呼び出しても1回のみ成功すること
+type ITOTPRepository interface {
+	FindByUserID(userID string) (*domain.TOTPCredential, error)
+	UseStep(userID string, step int64) (bool, error)
+	ConsumeRecoveryCode(userID string, codeHash string) (bool, error)
+	RecordFailedAttempt(userID string, now time.Time) (*domain.TOTPCredential, error)
+	ResetFailedAttempts(userID string) error
 }
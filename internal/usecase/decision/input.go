@@ -5,33 +5,87 @@ import (
 	"oauth-tutorial/internal/session"
 )
 
+// 同意画面でのユーザーの判断
+type ConsentDecision int
+
+const (
+	// 同意画面を経由していない(ログインのみ)
+	ConsentUndecided ConsentDecision = iota
+	ConsentApproved
+	ConsentDenied
+)
+
 type PublishAuthorizationCodeInput struct {
-	sessionId session.SessionID
-	loginID   string
-	password  string
-	approved  bool
+	sessionId     session.SessionID
+	transactionID session.TransactionID
+	loginID       string
+	password      string
+	// パスワードの後に求めたTOTPのコードまたはリカバリーコード
+	otp      string
+	decision ConsentDecision
+	// 同意画面でユーザーが選択したスコープ。nilの場合は要求された全てのスコープに同意したものとする
+	approvedScopes []string
 }
 
 var (
-	ErrEmptySessionID = errors.New("session ID cannot be empty")
-	ErrEmptyLoginID   = errors.New("login ID cannot be empty")
-	ErrEmptyPassword  = errors.New("password cannot be empty")
+	ErrEmptySessionID     = errors.New("session ID cannot be empty")
+	ErrEmptyTransactionID = errors.New("transaction ID cannot be empty")
+	ErrEmptyLoginID       = errors.New("login ID cannot be empty")
+	ErrEmptyPassword      = errors.New("password cannot be empty")
+	ErrEmptyOTP           = errors.New("one-time password cannot be empty")
 )
 
-func NewPublishAuthorizationCodeInput(sessionId session.SessionID, loginID, password string, approved bool) (*PublishAuthorizationCodeInput, error) {
+// ログイン済みのセッションであればloginID, passwordは省略できる(両方とも空にする)
+func NewPublishAuthorizationCodeInput(sessionId session.SessionID, transactionID session.TransactionID, loginID, password string, decision ConsentDecision, approvedScopes []string) (*PublishAuthorizationCodeInput, error) {
 	if sessionId == "" {
 		return nil, ErrEmptySessionID
 	}
-	if loginID == "" {
+	if transactionID == "" {
+		return nil, ErrEmptyTransactionID
+	}
+	if loginID == "" && password != "" {
 		return nil, ErrEmptyLoginID
 	}
-	if password == "" {
+	if loginID != "" && password == "" {
 		return nil, ErrEmptyPassword
 	}
 
-	return &PublishAuthorizationCodeInput{sessionId: sessionId, loginID: loginID, password: password, approved: approved}, nil
+	return &PublishAuthorizationCodeInput{sessionId: sessionId, transactionID: transactionID, loginID: loginID, password: password, decision: decision, approvedScopes: approvedScopes}, nil
+}
+
+// パスワードの確認を終えたトランザクションで、二要素目のコードを送信する場合の入力
+func NewSecondFactorInput(sessionId session.SessionID, transactionID session.TransactionID, otp string) (*PublishAuthorizationCodeInput, error) {
+	if sessionId == "" {
+		return nil, ErrEmptySessionID
+	}
+	if transactionID == "" {
+		return nil, ErrEmptyTransactionID
+	}
+	if otp == "" {
+		return nil, ErrEmptyOTP
+	}
+
+	return &PublishAuthorizationCodeInput{sessionId: sessionId, transactionID: transactionID, otp: otp, decision: ConsentUndecided}, nil
 }
 
 func (p *PublishAuthorizationCodeInput) Approved() bool {
-	return p.approved
+	return p.decision == ConsentApproved
+}
+
+func (p *PublishAuthorizationCodeInput) Decision() ConsentDecision {
+	return p.decision
+}
+
+// ログインIDとパスワードが送信されたかどうか
+func (p *PublishAuthorizationCodeInput) HasCredentials() bool {
+	return p.loginID != ""
+}
+
+// 二要素目のコードが送信されたかどうか
+func (p *PublishAuthorizationCodeInput) HasOTP() bool {
+	return p.otp != ""
+}
+
+func (p *PublishAuthorizationCodeInput) ApprovedScopes() []string {
+	return p.approvedScopes
 }
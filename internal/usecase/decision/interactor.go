@@ -4,54 +4,90 @@ import (
 	"errors"
 	"oauth-tutorial/internal/domain"
 	"oauth-tutorial/internal/infrastructure"
+	inf_dto "oauth-tutorial/internal/infrastructure/dto"
+	"oauth-tutorial/internal/session"
 	"oauth-tutorial/pkg/mylogger"
+	"slices"
 	"time"
 )
 
 var (
 	ErrSessionNotFound           = errors.New("session not found")
 	ErrUnexpectedSessionGetError = errors.New("unexpected error occurred while getting session")
+	ErrTransactionNotFound       = errors.New("authorization transaction not found")
 	ErrAuthorizationDenied       = errors.New("authorization denied by user")
 	ErrInvalidLoginCredentials   = errors.New("invalid login credentials")
+	ErrLoginRequired             = errors.New("login required")
+	ErrInvalidApprovedScope      = errors.New("approved scope is not included in the requested scope")
+	ErrUnexpectedSessionSaveErr  = errors.New("unexpected error occurred while saving session")
+	ErrUnexpectedStorageError    = errors.New("unexpected error occurred while accessing storage")
+	ErrInvalidOTP                = errors.New("invalid one-time password")
+	ErrTooManyOTPAttempts        = errors.New("too many invalid one-time passwords")
 )
 
+// 1回のパスワードの確認に対して、二要素目のコードを試せる回数。超えた場合はパスワードからやり直させる
+// 認可リクエストをやり直して回数を戻せないよう、ユーザー毎にも失敗を数えて一定期間確認しない(domain.TOTPMaxFailedAttempts)
+const MaxOTPAttempts = 5
+
+// ユーザー毎の失敗の回数が上限に達し、二要素目のコードを確認しない期間中
+var errOTPLockedOut = errors.New("one-time password locked out")
+
 type PublishAuthorizationCodeUseCase struct {
 	logger              mylogger.Logger
 	randomCodeGenerator IRandomCodeGenerator
 	sessionStore        ISessionStorage
-	userRepository      IUserRepository
+	sessionIDGenerator  ISessionIDGenerator
+	transactionStore    ITransactionStorage
+	authenticator       IAuthenticator
+	totpRepository      ITOTPRepository
 	authCodeRepository  IAuthorizationCodeRepository
+	consentRepository   IConsentRepository
+	clientRepository    IClientRepository
+	// 同意の有効期間。0以下の場合は無期限
+	consentDuration time.Duration
+	lifetimes       domain.Lifetimes
+	// レルムのスコープの一覧
+	scopes *domain.ScopeProvider
 }
 
-func NewPublishAuthorizationCodeUseCase(logger mylogger.Logger, randomCodeGenerator IRandomCodeGenerator, sessionStore ISessionStorage, userRepository IUserRepository, authCodeRepository IAuthorizationCodeRepository) *PublishAuthorizationCodeUseCase {
+func NewPublishAuthorizationCodeUseCase(logger mylogger.Logger, randomCodeGenerator IRandomCodeGenerator, sessionStore ISessionStorage, sessionIDGenerator ISessionIDGenerator, transactionStore ITransactionStorage, authenticator IAuthenticator, totpRepository ITOTPRepository, authCodeRepository IAuthorizationCodeRepository, consentRepository IConsentRepository, clientRepository IClientRepository, consentDuration time.Duration, lifetimes domain.Lifetimes, scopes *domain.ScopeProvider) *PublishAuthorizationCodeUseCase {
 	return &PublishAuthorizationCodeUseCase{
 		logger:              logger,
 		randomCodeGenerator: randomCodeGenerator,
 		sessionStore:        sessionStore,
-		userRepository:      userRepository,
+		sessionIDGenerator:  sessionIDGenerator,
+		transactionStore:    transactionStore,
+		authenticator:       authenticator,
+		totpRepository:      totpRepository,
 		authCodeRepository:  authCodeRepository,
+		consentRepository:   consentRepository,
+		clientRepository:    clientRepository,
+		consentDuration:     consentDuration,
+		lifetimes:           lifetimes,
+		scopes:              scopes,
 	}
 }
 
 func (uc *PublishAuthorizationCodeUseCase) Execute(input *PublishAuthorizationCodeInput) (PublishAuthorizationCodeOutput, error) {
-	session, err := uc.sessionStore.Get(input.sessionId)
+	now := time.Now()
+	sessionData, err := uc.sessionStore.Get(input.sessionId)
 	switch {
 	case errors.Is(err, infrastructure.ErrSessionNotFound):
-		uc.logger.Info("Session not found", err)
+		uc.logger.Info("Session not found", "err", err)
 		return PublishAuthorizationCodeOutput{}, &ErrPublishAuthorizationCode{
 			err:             ErrSessionNotFound,
 			baseRedirectUri: "",
 			state:           "",
 		}
 	case err != nil:
-		uc.logger.Error("Unexpected error occurred", err)
+		uc.logger.Error("Unexpected error occurred", "err", err)
 		return PublishAuthorizationCodeOutput{}, &ErrPublishAuthorizationCode{
 			err:             ErrUnexpectedSessionGetError,
 			baseRedirectUri: "",
 			state:           "",
 		}
 	}
-	if session == nil {
+	if sessionData == nil {
 		uc.logger.Info("Session is nil")
 		return PublishAuthorizationCodeOutput{}, &ErrPublishAuthorizationCode{
 			err:             ErrSessionNotFound,
@@ -60,43 +96,346 @@ func (uc *PublishAuthorizationCodeUseCase) Execute(input *PublishAuthorizationCo
 		}
 	}
 
-	if !input.approved {
+	// 認可リクエストのトランザクションは、発行時のブラウザセッションからのみ利用できる
+	transaction, err := uc.transactionStore.Get(input.transactionID)
+	if err != nil || transaction == nil || transaction.SessionID() != input.sessionId || transaction.IsExpired(now) {
+		uc.logger.Info("Authorization transaction not found", "err", err, "transactionID", input.transactionID)
+		return PublishAuthorizationCodeOutput{}, &ErrPublishAuthorizationCode{
+			err:             ErrTransactionNotFound,
+			baseRedirectUri: "",
+			state:           "",
+		}
+	}
+	authParam := transaction.AuthParam()
+
+	if input.decision == ConsentDenied {
 		uc.logger.Info("Authorization denied by user")
+		uc.transactionStore.Delete(transaction.ID())
 		return PublishAuthorizationCodeOutput{}, &ErrPublishAuthorizationCode{
 			err:             ErrAuthorizationDenied,
-			baseRedirectUri: session.AuthParam().RedirectURI(),
-			state:           session.AuthParam().State(),
+			baseRedirectUri: authParam.RedirectURI(),
+			state:           authParam.State(),
 		}
 	}
 
-	user, err := uc.userRepository.SelectByLoginIDAndPassword(input.loginID, input.password)
-	if err != nil {
-		uc.logger.Info("Failed to select user by loginID and password", "err", err)
+	// ログインIDとパスワードが送信された場合はログインし、セッション固定攻撃対策としてセッションIDを再生成する
+	// TOTPを有効にしたユーザーは、二要素目のコードを確認するまでログインを完了しない
+	sessionID := input.sessionId
+	var renewedSessionID session.SessionID
+	switch {
+	case input.HasOTP():
+		sessionData, err = uc.verifySecondFactor(transaction, input.otp, now)
+		if err != nil {
+			return PublishAuthorizationCodeOutput{}, err
+		}
+		renewedSessionID, err = uc.regenerateSession(sessionID, sessionData, transaction.WithPendingLogin(nil))
+		if err != nil {
+			return PublishAuthorizationCodeOutput{}, err
+		}
+		sessionID = renewedSessionID
+	case input.HasCredentials():
+		result, err := uc.authenticator.Authenticate(input.loginID, input.password)
+		if err != nil {
+			uc.logger.Error("Failed to authenticate user", "err", err)
+			return PublishAuthorizationCodeOutput{}, &ErrPublishAuthorizationCode{
+				err:             ErrUnexpectedStorageError,
+				baseRedirectUri: "",
+				state:           "",
+			}
+		}
+		// ユーザーの有無を推測されないよう、失敗した理由はログにのみ記録する
+		if !result.Succeeded() {
+			mylogger.SecurityEvent(uc.logger, "login_failed", "reason", result.FailureReason(), "loginID", input.loginID, "transactionID", transaction.ID())
+			return PublishAuthorizationCodeOutput{}, &ErrPublishAuthorizationCode{
+				err:             ErrInvalidLoginCredentials,
+				baseRedirectUri: "",
+				state:           "",
+			}
+		}
+
+		otpRequired, err := uc.otpRequired(result.User().UserID())
+		if err != nil {
+			uc.logger.Error("Failed to find TOTP credential", "err", err)
+			return PublishAuthorizationCodeOutput{}, &ErrPublishAuthorizationCode{
+				err:             ErrUnexpectedStorageError,
+				baseRedirectUri: "",
+				state:           "",
+			}
+		}
+		if otpRequired {
+			if err := uc.transactionStore.Save(transaction.WithPendingLogin(inf_dto.NewPendingLogin(result.User(), result.AMR()))); err != nil {
+				uc.logger.Error("Failed to save authorization transaction", "err", err)
+				return PublishAuthorizationCodeOutput{}, &ErrPublishAuthorizationCode{
+					err:             ErrUnexpectedStorageError,
+					baseRedirectUri: "",
+					state:           "",
+				}
+			}
+			uc.logger.Info("One-time password required", "transactionID", transaction.ID())
+			return NewOTPRequiredOutput(transaction.ID()), nil
+		}
+
+		sessionData = inf_dto.NewSessionData(result.User(), now, result.AMR())
+		renewedSessionID, err = uc.regenerateSession(sessionID, sessionData, transaction)
+		if err != nil {
+			return PublishAuthorizationCodeOutput{}, err
+		}
+		sessionID = renewedSessionID
+	case !sessionData.IsAuthenticated():
+		uc.logger.Info("Login required")
 		return PublishAuthorizationCodeOutput{}, &ErrPublishAuthorizationCode{
-			err:             ErrInvalidLoginCredentials,
+			err:             ErrLoginRequired,
 			baseRedirectUri: "",
 			state:           "",
 		}
 	}
-	if user == nil {
-		uc.logger.Info("Failed to select user by loginID and password", "err", err)
+	user := sessionData.User()
+
+	// 要求されたスコープに同意済みであれば同意画面を省略する。未同意のスコープがあれば同意を求める
+	registry := uc.scopes.Current()
+	scopes := authParam.Scopes()
+	consent := uc.findConsent(user.UserID(), authParam.ClientID(), now)
+	if consent == nil || !consent.Covers(registry, authParam.Scopes()) || input.approvedScopes != nil {
+		if input.decision != ConsentApproved {
+			uc.logger.Info("Consent required", "clientID", authParam.ClientID())
+			return NewConsentRequiredOutput(transaction.ID(), renewedSessionID, uc.clientName(authParam.ClientID()), authParam.Scopes()), nil
+		}
+
+		// 全てのスコープのチェックを外した場合は拒否として扱う
+		if input.approvedScopes != nil && len(input.approvedScopes) == 0 {
+			uc.logger.Info("No scope approved by user")
+			uc.transactionStore.Delete(transaction.ID())
+			return PublishAuthorizationCodeOutput{}, &ErrPublishAuthorizationCode{
+				err:             ErrAuthorizationDenied,
+				baseRedirectUri: authParam.RedirectURI(),
+				state:           authParam.State(),
+			}
+		}
+
+		// ユーザーが一部のスコープのみに同意した場合は、そのスコープに絞り込む
+		scopes, err = domain.NarrowScopes(registry, authParam.Scopes(), input.approvedScopes)
+		if err != nil {
+			uc.logger.Info("Approved scope is not requested", "approvedScopes", input.approvedScopes, "requestedScopes", authParam.Scopes())
+			return PublishAuthorizationCodeOutput{}, &ErrPublishAuthorizationCode{
+				err:             ErrInvalidApprovedScope,
+				baseRedirectUri: "",
+				state:           "",
+			}
+		}
+
+		// 同意を記録し、次回以降の同意を省略できるようにする
+		if consent == nil {
+			consent = domain.NewConsent(user.UserID(), authParam.ClientID(), scopes, now, uc.consentDuration)
+		} else {
+			consent = consent.Grant(scopes, now, uc.consentDuration)
+		}
+		if err := uc.consentRepository.Save(consent); err != nil {
+			uc.logger.Error("Failed to save consent", "err", err)
+			return PublishAuthorizationCodeOutput{}, &ErrPublishAuthorizationCode{
+				err:             ErrUnexpectedStorageError,
+				baseRedirectUri: "",
+				state:           "",
+			}
+		}
+	}
+
+	// 認可コードの発行と登録
+	authorizationCode := domain.NewAuthorizationCode(uc.randomCodeGenerator, user.UserID(), authParam.ClientID(), scopes, authParam.RedirectURI(), now, uc.authorizationCodeLifetime(authParam.ClientID()))
+	if err := uc.authCodeRepository.Save(authorizationCode); err != nil {
+		uc.logger.Error("Failed to save authorization code", "err", err)
 		return PublishAuthorizationCodeOutput{}, &ErrPublishAuthorizationCode{
-			err:             ErrInvalidLoginCredentials,
+			err:             ErrUnexpectedStorageError,
 			baseRedirectUri: "",
 			state:           "",
 		}
 	}
 
-	// 認可コードの発行と登録
-	authorizationCode := domain.NewAuthorizationCode(uc.randomCodeGenerator, user.UserID(), session.AuthParam().ClientID(), session.AuthParam().Scopes(), session.AuthParam().RedirectURI(), time.Now())
-	uc.authCodeRepository.Save(authorizationCode)
-
-	// セッションから認可リクエストのパラメーターを削除
-	uc.sessionStore.Delete(input.sessionId)
+	// 完了した認可リクエストのトランザクションを削除
+	uc.transactionStore.Delete(transaction.ID())
 
 	return NewPublishAuthorizationCodeOutput(
-		session.AuthParam().RedirectURI(),
+		authParam.RedirectURI(),
 		authorizationCode.Value(),
-		session.AuthParam().State(),
+		authParam.State(),
+		renewedSessionID,
 	), nil
 }
+
+// 確認済みのTOTPを登録したユーザーかどうか
+func (uc *PublishAuthorizationCodeUseCase) otpRequired(userID string) (bool, error) {
+	credential, err := uc.totpRepository.FindByUserID(userID)
+	if errors.Is(err, infrastructure.ErrTOTPCredentialNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return credential.Enabled(), nil
+}
+
+// パスワードの確認を終えたログインの二要素目のコードを確認し、ログイン後のセッションを返す
+// 失敗した回数をトランザクションに記録し、上限に達した場合は待ち状態を解除してパスワードからやり直させる
+func (uc *PublishAuthorizationCodeUseCase) verifySecondFactor(transaction *inf_dto.AuthorizationTransaction, otp string, now time.Time) (*inf_dto.SessionData, error) {
+	pendingLogin := transaction.PendingLogin()
+	if pendingLogin == nil {
+		uc.logger.Info("No login is waiting for a one-time password", "transactionID", transaction.ID())
+		return nil, &ErrPublishAuthorizationCode{
+			err:             ErrLoginRequired,
+			baseRedirectUri: "",
+			state:           "",
+		}
+	}
+	user := pendingLogin.User()
+
+	method, err := uc.useSecondFactor(user.UserID(), otp, now)
+	if errors.Is(err, errOTPLockedOut) {
+		mylogger.SecurityEvent(uc.logger, "otp_locked_out", "userID", user.UserID(), "transactionID", transaction.ID())
+		if err := uc.transactionStore.Save(transaction.WithPendingLogin(nil)); err != nil {
+			uc.logger.Error("Failed to save authorization transaction", "err", err)
+		}
+		return nil, &ErrPublishAuthorizationCode{
+			err:             ErrTooManyOTPAttempts,
+			baseRedirectUri: "",
+			state:           "",
+		}
+	}
+	if err != nil {
+		uc.logger.Error("Failed to verify one-time password", "err", err)
+		return nil, &ErrPublishAuthorizationCode{
+			err:             ErrUnexpectedStorageError,
+			baseRedirectUri: "",
+			state:           "",
+		}
+	}
+	if method == "" {
+		pendingLogin = pendingLogin.RecordFailure()
+		lockedOut := false
+		credential, err := uc.totpRepository.RecordFailedAttempt(user.UserID(), now)
+		if err != nil {
+			uc.logger.Error("Failed to record failed one-time password attempt", "err", err)
+		} else {
+			lockedOut = credential.LockedOut(now)
+		}
+		mylogger.SecurityEvent(uc.logger, "otp_failed", "userID", user.UserID(), "attempts", pendingLogin.FailedAttempts(), "lockedOut", lockedOut, "transactionID", transaction.ID())
+		if pendingLogin.FailedAttempts() >= MaxOTPAttempts || lockedOut {
+			pendingLogin = nil
+		}
+		if err := uc.transactionStore.Save(transaction.WithPendingLogin(pendingLogin)); err != nil {
+			uc.logger.Error("Failed to save authorization transaction", "err", err)
+		}
+		failure := ErrInvalidOTP
+		if pendingLogin == nil {
+			failure = ErrTooManyOTPAttempts
+		}
+		return nil, &ErrPublishAuthorizationCode{
+			err:             failure,
+			baseRedirectUri: "",
+			state:           "",
+		}
+	}
+	if method == otpMethodRecoveryCode {
+		mylogger.SecurityEvent(uc.logger, "recovery_code_used", "userID", user.UserID(), "transactionID", transaction.ID())
+	}
+	if err := uc.totpRepository.ResetFailedAttempts(user.UserID()); err != nil {
+		uc.logger.Error("Failed to reset failed one-time password attempts", "err", err)
+	}
+
+	amr := append(slices.Clone(pendingLogin.AMR()), domain.AMROTP)
+	return inf_dto.NewSessionData(user, now, amr), nil
+}
+
+// 二要素目の確認に使った方法
+const (
+	otpMethodTOTP         = "totp"
+	otpMethodRecoveryCode = "recovery_code"
+)
+
+// TOTPのコードまたはリカバリーコードを確認し、使用済みとして記録する。使った方法を返し、一致しない場合は空文字を返す
+// 使用済みの記録は並行して同じコードを送信しても1回のみ成功するため、同じコードを2回使うことはできない
+// 連続した失敗により確認しない期間中は、正しいコードでもerrOTPLockedOutを返す
+func (uc *PublishAuthorizationCodeUseCase) useSecondFactor(userID string, otp string, now time.Time) (string, error) {
+	credential, err := uc.totpRepository.FindByUserID(userID)
+	if err != nil {
+		return "", err
+	}
+	if !credential.Enabled() {
+		return "", nil
+	}
+	if credential.LockedOut(now) {
+		return "", errOTPLockedOut
+	}
+	if step, ok := credential.MatchStep(otp, now); ok {
+		used, err := uc.totpRepository.UseStep(userID, step)
+		if err != nil || !used {
+			return "", err
+		}
+		return otpMethodTOTP, nil
+	}
+	if credential.HasRecoveryCode(otp) {
+		consumed, err := uc.totpRepository.ConsumeRecoveryCode(userID, domain.HashRecoveryCode(otp))
+		if err != nil || !consumed {
+			return "", err
+		}
+		return otpMethodRecoveryCode, nil
+	}
+	return "", nil
+}
+
+// クライアント毎の認可コードの有効期間。クライアントを取得できない場合はサーバー全体の有効期間を使う
+func (uc *PublishAuthorizationCodeUseCase) authorizationCodeLifetime(clientID string) time.Duration {
+	client, err := uc.clientRepository.SelectByClientID(domain.ClientID(clientID))
+	if err != nil {
+		uc.logger.Info("Client not found", "clientID", clientID, "err", err)
+		return uc.lifetimes.AuthorizationCode
+	}
+	return client.Lifetimes(uc.lifetimes).AuthorizationCode
+}
+
+// 同意画面に表示するクライアント名を取得する。取得できない場合はclient_idを返す
+func (uc *PublishAuthorizationCodeUseCase) clientName(clientID string) string {
+	client, err := uc.clientRepository.SelectByClientID(domain.ClientID(clientID))
+	if err != nil {
+		uc.logger.Info("Client not found", "clientID", clientID, "err", err)
+		return clientID
+	}
+	return client.ClientName()
+}
+
+// 有効期限内の同意を取得する。存在しない場合はnilを返す
+func (uc *PublishAuthorizationCodeUseCase) findConsent(userID string, clientID string, now time.Time) *domain.Consent {
+	consent, err := uc.consentRepository.FindByUserAndClient(userID, clientID)
+	if err != nil {
+		if !errors.Is(err, infrastructure.ErrConsentNotFound) {
+			uc.logger.Error("Failed to find consent", "err", err)
+		}
+		return nil
+	}
+	if consent.IsExpired(now) {
+		uc.logger.Info("Consent expired", "clientID", clientID)
+		return nil
+	}
+	return consent
+}
+
+// 新しいセッションIDでセッションを保存し直し、古いセッションを破棄する
+func (uc *PublishAuthorizationCodeUseCase) regenerateSession(oldSessionID session.SessionID, sessionData *inf_dto.SessionData, transaction *inf_dto.AuthorizationTransaction) (session.SessionID, error) {
+	newSessionID := uc.sessionIDGenerator.Generate()
+	err := uc.sessionStore.Save(newSessionID, sessionData)
+	if err != nil {
+		uc.logger.Error("Failed to save regenerated session", "err", err)
+		return "", &ErrPublishAuthorizationCode{
+			err:             ErrUnexpectedSessionSaveErr,
+			baseRedirectUri: "",
+			state:           "",
+		}
+	}
+	uc.sessionStore.Delete(oldSessionID)
+
+	// 処理中のトランザクションを新しいセッションに紐づけ直す
+	err = uc.transactionStore.Save(transaction.BindTo(newSessionID))
+	if err != nil {
+		uc.logger.Error("Failed to rebind authorization transaction", "err", err)
+	}
+
+	return newSessionID, nil
+}
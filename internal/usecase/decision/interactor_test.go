@@ -0,0 +1,774 @@
+package decision
+
+import (
+	"errors"
+	"oauth-tutorial/internal/domain"
+	"oauth-tutorial/internal/infrastructure"
+	inf_dto "oauth-tutorial/internal/infrastructure/dto"
+	"oauth-tutorial/internal/session"
+	"oauth-tutorial/pkg/mylogger"
+	"slices"
+	"testing"
+	"time"
+)
+
+type mockRandomCodeGenerator struct{}
+
+func (m *mockRandomCodeGenerator) GenerateURLSafeRandomString(n int) string {
+	return "test-authorization-code"
+}
+
+type mockSessionStorage struct {
+	sessions map[session.SessionID]*inf_dto.SessionData
+}
+
+func (m *mockSessionStorage) Get(sessionID session.SessionID) (*inf_dto.SessionData, error) {
+	sessionData, ok := m.sessions[sessionID]
+	if !ok {
+		return nil, infrastructure.ErrSessionNotFound
+	}
+	return sessionData, nil
+}
+
+func (m *mockSessionStorage) Save(sessionID session.SessionID, sessionData *inf_dto.SessionData) error {
+	m.sessions[sessionID] = sessionData
+	return nil
+}
+
+func (m *mockSessionStorage) Delete(sessionID session.SessionID) error {
+	delete(m.sessions, sessionID)
+	return nil
+}
+
+type mockSessionIDGenerator struct{}
+
+func (m *mockSessionIDGenerator) Generate() session.SessionID {
+	return "renewed-session-id"
+}
+
+type mockTransactionStorage struct {
+	transactions map[session.TransactionID]*inf_dto.AuthorizationTransaction
+}
+
+func (m *mockTransactionStorage) Get(transactionID session.TransactionID) (*inf_dto.AuthorizationTransaction, error) {
+	transaction, ok := m.transactions[transactionID]
+	if !ok {
+		return nil, infrastructure.ErrTransactionNotFound
+	}
+	return transaction, nil
+}
+
+func (m *mockTransactionStorage) Save(transaction *inf_dto.AuthorizationTransaction) error {
+	m.transactions[transaction.ID()] = transaction
+	return nil
+}
+
+func (m *mockTransactionStorage) Delete(transactionID session.TransactionID) error {
+	delete(m.transactions, transactionID)
+	return nil
+}
+
+// userのログインIDとパスワードが一致する場合にamrで認証に成功する
+type mockAuthenticator struct {
+	user *domain.User
+	amr  []string
+	err  error
+}
+
+func (m *mockAuthenticator) Authenticate(loginID, password string) (domain.AuthenticationResult, error) {
+	if m.err != nil {
+		return domain.AuthenticationResult{}, m.err
+	}
+	if m.user == nil || m.user.LoginID() != loginID {
+		return domain.NewAuthenticationFailure(domain.AuthenticationFailureUnknownUser), nil
+	}
+	// モックのユーザーはハッシュ値の代わりに平文のパスワードを持つ
+	if m.user.PasswordHash() != password {
+		return domain.NewAuthenticationFailure(domain.AuthenticationFailureInvalidPassword), nil
+	}
+	amr := m.amr
+	if amr == nil {
+		amr = []string{domain.AMRPassword}
+	}
+	return domain.NewAuthenticationSuccess(m.user, amr), nil
+}
+
+// ユーザー毎に1件のTOTPの登録を持つ
+type mockTOTPRepository struct {
+	credentials map[string]*domain.TOTPCredential
+	err         error
+}
+
+func (m *mockTOTPRepository) FindByUserID(userID string) (*domain.TOTPCredential, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	credential, ok := m.credentials[userID]
+	if !ok {
+		return nil, infrastructure.ErrTOTPCredentialNotFound
+	}
+	return credential, nil
+}
+
+func (m *mockTOTPRepository) UseStep(userID string, step int64) (bool, error) {
+	c := m.credentials[userID]
+	if step <= c.LastUsedStep() {
+		return false, nil
+	}
+	m.credentials[userID] = domain.ReconstructTOTPCredential(c.UserID(), c.Secret(), c.ConfirmedAt(), step, c.RecoveryCodeHashes(), c.FailedAttempts(), c.LockedUntil())
+	return true, nil
+}
+
+func (m *mockTOTPRepository) ConsumeRecoveryCode(userID string, codeHash string) (bool, error) {
+	c := m.credentials[userID]
+	remaining := slices.DeleteFunc(slices.Clone(c.RecoveryCodeHashes()), func(h string) bool { return h == codeHash })
+	if len(remaining) == len(c.RecoveryCodeHashes()) {
+		return false, nil
+	}
+	m.credentials[userID] = domain.ReconstructTOTPCredential(c.UserID(), c.Secret(), c.ConfirmedAt(), c.LastUsedStep(), remaining, c.FailedAttempts(), c.LockedUntil())
+	return true, nil
+}
+
+func (m *mockTOTPRepository) RecordFailedAttempt(userID string, now time.Time) (*domain.TOTPCredential, error) {
+	m.credentials[userID] = m.credentials[userID].RecordFailure(now)
+	return m.credentials[userID], nil
+}
+
+func (m *mockTOTPRepository) ResetFailedAttempts(userID string) error {
+	c := m.credentials[userID]
+	m.credentials[userID] = domain.ReconstructTOTPCredential(c.UserID(), c.Secret(), c.ConfirmedAt(), c.LastUsedStep(), c.RecoveryCodeHashes(), 0, time.Time{})
+	return nil
+}
+
+type mockAuthCodeRepository struct {
+	codes []*domain.AuthorizationCode
+}
+
+func (m *mockAuthCodeRepository) Save(code *domain.AuthorizationCode) error {
+	m.codes = append(m.codes, code)
+	return nil
+}
+
+type mockConsentRepository struct {
+	consents map[string]*domain.Consent
+}
+
+func (m *mockConsentRepository) FindByUserAndClient(userID string, clientID string) (*domain.Consent, error) {
+	consent, ok := m.consents[userID+":"+clientID]
+	if !ok {
+		return nil, infrastructure.ErrConsentNotFound
+	}
+	return consent, nil
+}
+
+func (m *mockConsentRepository) Save(consent *domain.Consent) error {
+	m.consents[consent.UserID()+":"+consent.ClientID()] = consent
+	return nil
+}
+
+type mockClientRepository struct{}
+
+func (m *mockClientRepository) SelectByClientID(clientID domain.ClientID) (*domain.Client, error) {
+	if clientID != "client-1" {
+		return nil, infrastructure.ErrClientNotFound
+	}
+	return domain.ReconstructClient("client-1", "テストクライアント", domain.ConfidentialClient, "secret", []string{"https://example.com/callback"}), nil
+}
+
+func Test_認可コード発行ユースケース(t *testing.T) {
+	logger := mylogger.NewMockLogger()
+	user := domain.ReconstructUser("user-1", "user@example.com", "password")
+	param, err := domain.NewAuthorizationCodeFlowParam(logger, domain.DefaultScopeRegistry(), "code", "client-1", "https://example.com/callback", "read", "test-state")
+	if err != nil {
+		t.Fatalf("Failed to create AuthorizationCodeFlowParam: %v", err)
+	}
+	const (
+		sessionID     = session.SessionID("test-session-id")
+		transactionID = session.TransactionID("test-transaction-id")
+	)
+
+	tests := []struct {
+		name                string
+		session             *inf_dto.SessionData
+		transaction         *inf_dto.AuthorizationTransaction
+		loginID             string
+		password            string
+		decision            ConsentDecision
+		consent             *domain.Consent
+		expectedErr         error
+		expectConsentPrompt bool
+		expectedRedirectURI string
+		expectedSessionID   session.SessionID
+	}{
+		{
+			name:              "正常系 - ログインして認可コードを発行しセッションIDを再生成する",
+			session:           inf_dto.NewSessionData(nil, time.Time{}, nil),
+			transaction:       inf_dto.NewAuthorizationTransaction(transactionID, sessionID, param, time.Now()),
+			loginID:           "user@example.com",
+			password:          "password",
+			decision:          ConsentApproved,
+			expectedSessionID: "renewed-session-id",
+		},
+		{
+			name:              "正常系 - ログイン済みセッションではクレデンシャル無しで認可コードを発行する",
+			session:           inf_dto.NewSessionData(user, time.Now(), []string{domain.AMRPassword}),
+			transaction:       inf_dto.NewAuthorizationTransaction(transactionID, sessionID, param, time.Now()),
+			decision:          ConsentApproved,
+			expectedSessionID: "",
+		},
+		{
+			name:              "正常系 - 同意済みのスコープであれば同意画面を省略して認可コードを発行する",
+			session:           inf_dto.NewSessionData(nil, time.Time{}, nil),
+			transaction:       inf_dto.NewAuthorizationTransaction(transactionID, sessionID, param, time.Now()),
+			loginID:           "user@example.com",
+			password:          "password",
+			decision:          ConsentUndecided,
+			consent:           domain.NewConsent("user-1", "client-1", []string{"read", "write"}, time.Now(), 0),
+			expectedSessionID: "renewed-session-id",
+		},
+		{
+			name:                "正常系 - 未同意の場合はログイン後に同意を求める",
+			session:             inf_dto.NewSessionData(nil, time.Time{}, nil),
+			transaction:         inf_dto.NewAuthorizationTransaction(transactionID, sessionID, param, time.Now()),
+			loginID:             "user@example.com",
+			password:            "password",
+			decision:            ConsentUndecided,
+			expectConsentPrompt: true,
+			expectedSessionID:   "renewed-session-id",
+		},
+		{
+			name:                "正常系 - 同意の有効期限が切れている場合は同意を求める",
+			session:             inf_dto.NewSessionData(user, time.Now(), []string{domain.AMRPassword}),
+			transaction:         inf_dto.NewAuthorizationTransaction(transactionID, sessionID, param, time.Now()),
+			decision:            ConsentUndecided,
+			consent:             domain.NewConsent("user-1", "client-1", []string{"read"}, time.Now().Add(-2*time.Hour), time.Hour),
+			expectConsentPrompt: true,
+		},
+		{
+			name:        "異常系 - セッションが存在しない",
+			session:     nil,
+			transaction: inf_dto.NewAuthorizationTransaction(transactionID, sessionID, param, time.Now()),
+			decision:    ConsentApproved,
+			expectedErr: ErrSessionNotFound,
+		},
+		{
+			name:        "異常系 - トランザクションが存在しない",
+			session:     inf_dto.NewSessionData(user, time.Now(), []string{domain.AMRPassword}),
+			transaction: nil,
+			decision:    ConsentApproved,
+			expectedErr: ErrTransactionNotFound,
+		},
+		{
+			name:        "異常系 - 別のセッションのトランザクション",
+			session:     inf_dto.NewSessionData(user, time.Now(), []string{domain.AMRPassword}),
+			transaction: inf_dto.NewAuthorizationTransaction(transactionID, "other-session-id", param, time.Now()),
+			decision:    ConsentApproved,
+			expectedErr: ErrTransactionNotFound,
+		},
+		{
+			name:        "異常系 - 有効期限切れのトランザクション",
+			session:     inf_dto.NewSessionData(user, time.Now(), []string{domain.AMRPassword}),
+			transaction: inf_dto.NewAuthorizationTransaction(transactionID, sessionID, param, time.Now().Add(-time.Hour)),
+			decision:    ConsentApproved,
+			expectedErr: ErrTransactionNotFound,
+		},
+		{
+			name:                "異常系 - ユーザーが拒否",
+			session:             inf_dto.NewSessionData(user, time.Now(), []string{domain.AMRPassword}),
+			transaction:         inf_dto.NewAuthorizationTransaction(transactionID, sessionID, param, time.Now()),
+			decision:            ConsentDenied,
+			expectedErr:         ErrAuthorizationDenied,
+			expectedRedirectURI: "https://example.com/callback",
+		},
+		{
+			name:        "異常系 - クレデンシャルが誤っている",
+			session:     inf_dto.NewSessionData(nil, time.Time{}, nil),
+			transaction: inf_dto.NewAuthorizationTransaction(transactionID, sessionID, param, time.Now()),
+			loginID:     "user@example.com",
+			password:    "wrong-password",
+			decision:    ConsentApproved,
+			expectedErr: ErrInvalidLoginCredentials,
+		},
+		{
+			name:        "異常系 - 未ログインでクレデンシャルが無い",
+			session:     inf_dto.NewSessionData(nil, time.Time{}, nil),
+			transaction: inf_dto.NewAuthorizationTransaction(transactionID, sessionID, param, time.Now()),
+			decision:    ConsentApproved,
+			expectedErr: ErrLoginRequired,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// given
+			ss := &mockSessionStorage{sessions: map[session.SessionID]*inf_dto.SessionData{}}
+			if tt.session != nil {
+				ss.sessions[sessionID] = tt.session
+			}
+			ts := &mockTransactionStorage{transactions: map[session.TransactionID]*inf_dto.AuthorizationTransaction{}}
+			if tt.transaction != nil {
+				ts.transactions[transactionID] = tt.transaction
+			}
+			ar := &mockAuthCodeRepository{}
+			cr := &mockConsentRepository{consents: map[string]*domain.Consent{}}
+			if tt.consent != nil {
+				cr.Save(tt.consent)
+			}
+			uc := NewPublishAuthorizationCodeUseCase(logger, &mockRandomCodeGenerator{}, ss, &mockSessionIDGenerator{}, ts, &mockAuthenticator{user: user}, &mockTOTPRepository{}, ar, cr, &mockClientRepository{}, 24*time.Hour, domain.DefaultLifetimes(), domain.NewScopeProvider(nil))
+			input, err := NewPublishAuthorizationCodeInput(sessionID, transactionID, tt.loginID, tt.password, tt.decision, nil)
+			if err != nil {
+				t.Fatalf("Failed to create input: %v", err)
+			}
+
+			// when
+			output, err := uc.Execute(input)
+
+			// then
+			if tt.expectedErr != nil {
+				if !errors.Is(err, tt.expectedErr) {
+					t.Fatalf("Execute() error = %v, want %v", err, tt.expectedErr)
+				}
+				var errPac *ErrPublishAuthorizationCode
+				if errors.As(err, &errPac) && errPac.BaseRedirectUri() != tt.expectedRedirectURI {
+					t.Errorf("BaseRedirectUri() = %v, want %v", errPac.BaseRedirectUri(), tt.expectedRedirectURI)
+				}
+				if len(ar.codes) != 0 {
+					t.Errorf("saved codes = %d, want 0", len(ar.codes))
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Execute() error = %v", err)
+			}
+			if output.RenewedSessionID() != tt.expectedSessionID {
+				t.Errorf("RenewedSessionID() = %v, want %v", output.RenewedSessionID(), tt.expectedSessionID)
+			}
+			if tt.expectConsentPrompt {
+				if !output.ConsentRequired() {
+					t.Error("ConsentRequired() = false, want true")
+				}
+				if output.TransactionID() != transactionID {
+					t.Errorf("TransactionID() = %v, want %v", output.TransactionID(), transactionID)
+				}
+				if output.ClientName() != "テストクライアント" || !slices.Equal(output.Scopes(), []string{"read"}) {
+					t.Errorf("ClientName(), Scopes() = %v, %v, want テストクライアント, [read]", output.ClientName(), output.Scopes())
+				}
+				if len(ar.codes) != 0 {
+					t.Errorf("saved codes = %d, want 0", len(ar.codes))
+				}
+				// 同意を求める間はトランザクションを保持し、新しいセッションに紐づけ直していること
+				transaction, ok := ts.transactions[transactionID]
+				if !ok {
+					t.Fatal("transaction should be kept until consent is given")
+				}
+				expectedBinding := sessionID
+				if tt.expectedSessionID != "" {
+					expectedBinding = tt.expectedSessionID
+				}
+				if transaction.SessionID() != expectedBinding {
+					t.Errorf("transaction SessionID() = %v, want %v", transaction.SessionID(), expectedBinding)
+				}
+				return
+			}
+			if output.AuthorizationCode() != "test-authorization-code" {
+				t.Errorf("AuthorizationCode() = %v, want %v", output.AuthorizationCode(), "test-authorization-code")
+			}
+			if len(ar.codes) != 1 || ar.codes[0].UserID() != user.UserID() {
+				t.Errorf("saved codes = %v, want one code for %v", ar.codes, user.UserID())
+			}
+			if _, ok := ts.transactions[transactionID]; ok {
+				t.Error("transaction should be deleted")
+			}
+
+			currentSessionID := sessionID
+			if tt.expectedSessionID != "" {
+				currentSessionID = tt.expectedSessionID
+				if _, ok := ss.sessions[sessionID]; ok {
+					t.Error("old session should be deleted after regeneration")
+				}
+			}
+			currentSession, ok := ss.sessions[currentSessionID]
+			if !ok {
+				t.Fatalf("session %v should exist", currentSessionID)
+			}
+			if !currentSession.IsAuthenticated() {
+				t.Error("session should be authenticated")
+			}
+			consent, err := cr.FindByUserAndClient(user.UserID(), "client-1")
+			if err != nil {
+				t.Fatalf("consent should be saved: %v", err)
+			}
+			if !consent.Covers(domain.DefaultScopeRegistry(), []string{"read"}) {
+				t.Errorf("consent Scopes() = %v, want to cover %v", consent.Scopes(), []string{"read"})
+			}
+		})
+	}
+}
+
+func Test_一部のスコープへの同意(t *testing.T) {
+	logger := mylogger.NewMockLogger()
+	user := domain.ReconstructUser("user-1", "user@example.com", "password")
+	param, err := domain.NewAuthorizationCodeFlowParam(logger, domain.DefaultScopeRegistry(), "code", "client-1", "https://example.com/callback", "read write", "test-state")
+	if err != nil {
+		t.Fatalf("Failed to create AuthorizationCodeFlowParam: %v", err)
+	}
+	const (
+		sessionID     = session.SessionID("test-session-id")
+		transactionID = session.TransactionID("test-transaction-id")
+	)
+
+	tests := []struct {
+		name           string
+		approvedScopes []string
+		expectedScopes []string
+		expectedErr    error
+	}{
+		{
+			name:           "正常系 - 同意したスコープのみで認可コードを発行する",
+			approvedScopes: []string{"read"},
+			expectedScopes: []string{"read"},
+		},
+		{
+			name:           "正常系 - スコープの指定が無い場合は要求された全てのスコープで発行する",
+			approvedScopes: nil,
+			expectedScopes: []string{"read", "write"},
+		},
+		{
+			name:           "異常系 - 全てのスコープのチェックを外した場合は拒否として扱う",
+			approvedScopes: []string{},
+			expectedErr:    ErrAuthorizationDenied,
+		},
+		{
+			name:           "異常系 - 要求されていないスコープへの同意",
+			approvedScopes: []string{"read", "admin"},
+			expectedErr:    ErrInvalidApprovedScope,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// given
+			ss := &mockSessionStorage{sessions: map[session.SessionID]*inf_dto.SessionData{
+				sessionID: inf_dto.NewSessionData(user, time.Now(), []string{domain.AMRPassword}),
+			}}
+			ts := &mockTransactionStorage{transactions: map[session.TransactionID]*inf_dto.AuthorizationTransaction{
+				transactionID: inf_dto.NewAuthorizationTransaction(transactionID, sessionID, param, time.Now()),
+			}}
+			ar := &mockAuthCodeRepository{}
+			cr := &mockConsentRepository{consents: map[string]*domain.Consent{}}
+			uc := NewPublishAuthorizationCodeUseCase(logger, &mockRandomCodeGenerator{}, ss, &mockSessionIDGenerator{}, ts, &mockAuthenticator{user: user}, &mockTOTPRepository{}, ar, cr, &mockClientRepository{}, 0, domain.DefaultLifetimes(), domain.NewScopeProvider(nil))
+			input, err := NewPublishAuthorizationCodeInput(sessionID, transactionID, "", "", ConsentApproved, tt.approvedScopes)
+			if err != nil {
+				t.Fatalf("Failed to create input: %v", err)
+			}
+
+			// when
+			_, err = uc.Execute(input)
+
+			// then
+			if tt.expectedErr != nil {
+				if !errors.Is(err, tt.expectedErr) {
+					t.Fatalf("Execute() error = %v, want %v", err, tt.expectedErr)
+				}
+				if len(ar.codes) != 0 || len(cr.consents) != 0 {
+					t.Errorf("saved codes = %d, consents = %d, want 0", len(ar.codes), len(cr.consents))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Execute() error = %v", err)
+			}
+			if len(ar.codes) != 1 || !slices.Equal(ar.codes[0].Scopes(), tt.expectedScopes) {
+				t.Fatalf("saved codes = %v, want one code with scopes %v", ar.codes, tt.expectedScopes)
+			}
+			consent, err := cr.FindByUserAndClient(user.UserID(), "client-1")
+			if err != nil {
+				t.Fatalf("consent should be saved: %v", err)
+			}
+			if !slices.Equal(consent.Scopes(), tt.expectedScopes) {
+				t.Errorf("consent Scopes() = %v, want %v", consent.Scopes(), tt.expectedScopes)
+			}
+		})
+	}
+}
+
+func Test_認証方式によるログイン(t *testing.T) {
+	logger := mylogger.NewMockLogger()
+	user := domain.ReconstructUser("user-1", "user@example.com", "password")
+	param, err := domain.NewAuthorizationCodeFlowParam(logger, domain.DefaultScopeRegistry(), "code", "client-1", "https://example.com/callback", "read", "test-state")
+	if err != nil {
+		t.Fatalf("Failed to create AuthorizationCodeFlowParam: %v", err)
+	}
+	const (
+		sessionID     = session.SessionID("test-session-id")
+		transactionID = session.TransactionID("test-transaction-id")
+	)
+
+	tests := []struct {
+		name          string
+		authenticator *mockAuthenticator
+		expectedAMR   []string
+		expectedErr   error
+	}{
+		{name: "正常系 - 認証方式が返したamrをセッションに記録する", authenticator: &mockAuthenticator{user: user, amr: []string{"pwd", "otp"}}, expectedAMR: []string{"pwd", "otp"}},
+		{name: "異常系 - 認証方式の保存先にアクセスできない", authenticator: &mockAuthenticator{user: user, err: errors.New("connection refused")}, expectedErr: ErrUnexpectedStorageError},
+		{name: "異常系 - 存在しないユーザー", authenticator: &mockAuthenticator{}, expectedErr: ErrInvalidLoginCredentials},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// given
+			ss := &mockSessionStorage{sessions: map[session.SessionID]*inf_dto.SessionData{
+				sessionID: inf_dto.NewSessionData(nil, time.Time{}, nil),
+			}}
+			ts := &mockTransactionStorage{transactions: map[session.TransactionID]*inf_dto.AuthorizationTransaction{
+				transactionID: inf_dto.NewAuthorizationTransaction(transactionID, sessionID, param, time.Now()),
+			}}
+			uc := NewPublishAuthorizationCodeUseCase(logger, &mockRandomCodeGenerator{}, ss, &mockSessionIDGenerator{}, ts, tt.authenticator, &mockTOTPRepository{}, &mockAuthCodeRepository{}, &mockConsentRepository{consents: map[string]*domain.Consent{}}, &mockClientRepository{}, 0, domain.DefaultLifetimes(), domain.NewScopeProvider(nil))
+			input, err := NewPublishAuthorizationCodeInput(sessionID, transactionID, "user@example.com", "password", ConsentApproved, nil)
+			if err != nil {
+				t.Fatalf("Failed to create input: %v", err)
+			}
+
+			// when
+			output, err := uc.Execute(input)
+
+			// then
+			if !errors.Is(err, tt.expectedErr) {
+				t.Fatalf("Execute() error = %v, want %v", err, tt.expectedErr)
+			}
+			if tt.expectedErr != nil {
+				return
+			}
+			sessionData := ss.sessions[output.RenewedSessionID()]
+			if sessionData == nil || !slices.Equal(sessionData.AMR(), tt.expectedAMR) {
+				t.Errorf("session = %+v, want amr %v", sessionData, tt.expectedAMR)
+			}
+		})
+	}
+}
+
+func Test_TOTPを有効にしたユーザーのログイン(t *testing.T) {
+	logger := mylogger.NewMockLogger()
+	user := domain.ReconstructUser("user-1", "user@example.com", "password")
+	param, err := domain.NewAuthorizationCodeFlowParam(logger, domain.DefaultScopeRegistry(), "code", "client-1", "https://example.com/callback", "read", "test-state")
+	if err != nil {
+		t.Fatalf("Failed to create AuthorizationCodeFlowParam: %v", err)
+	}
+	const (
+		sessionID     = session.SessionID("test-session-id")
+		transactionID = session.TransactionID("test-transaction-id")
+	)
+	secret := []byte("12345678901234567890")
+	now := time.Now()
+	step := now.Unix() / int64(domain.TOTPPeriod/time.Second)
+	recoveryCode := "ABCD-EFGH"
+
+	tests := []struct {
+		name string
+		// パスワードの確認後、二要素目として送信する値
+		otp string
+		// 確認済みのTOTPで最後に使ったステップ
+		lastUsedStep int64
+		// 既に失敗した回数
+		failedAttempts int
+		expectedErr    error
+		// 失敗した場合に、二要素目の確認を待ったままかどうか
+		expectedPending bool
+	}{
+		{name: "正常系 - 現在のコード", otp: domain.TOTPCode(secret, now), lastUsedStep: step - 10},
+		{name: "正常系 - 1ステップ前のコード(時計のずれ)", otp: domain.TOTPCode(secret, now.Add(-domain.TOTPPeriod)), lastUsedStep: step - 10},
+		{name: "正常系 - リカバリーコード(区切りと大文字小文字を問わない)", otp: "abcdefgh", lastUsedStep: step - 10},
+		{name: "異常系 - 使用済みのコード", otp: domain.TOTPCode(secret, now), lastUsedStep: step, expectedErr: ErrInvalidOTP, expectedPending: true},
+		{name: "異常系 - 2ステップ以上ずれたコード", otp: domain.TOTPCode(secret, now.Add(-2*domain.TOTPPeriod)), lastUsedStep: step - 10, expectedErr: ErrInvalidOTP, expectedPending: true},
+		{name: "異常系 - 誤ったリカバリーコード", otp: "ZZZZ-ZZZZ", lastUsedStep: step - 10, expectedErr: ErrInvalidOTP, expectedPending: true},
+		{name: "異常系 - 失敗の回数が上限に達した", otp: "000000", lastUsedStep: step + 10, failedAttempts: MaxOTPAttempts - 1, expectedErr: ErrTooManyOTPAttempts},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// given
+			ss := &mockSessionStorage{sessions: map[session.SessionID]*inf_dto.SessionData{
+				sessionID: inf_dto.NewSessionData(nil, time.Time{}, nil),
+			}}
+			ts := &mockTransactionStorage{transactions: map[session.TransactionID]*inf_dto.AuthorizationTransaction{
+				transactionID: inf_dto.NewAuthorizationTransaction(transactionID, sessionID, param, now),
+			}}
+			tr := &mockTOTPRepository{credentials: map[string]*domain.TOTPCredential{
+				"user-1": domain.NewTOTPCredential("user-1", secret).Confirm(now, tt.lastUsedStep, []string{domain.HashRecoveryCode(recoveryCode)}),
+			}}
+			cr := &mockConsentRepository{consents: map[string]*domain.Consent{
+				"user-1:client-1": domain.NewConsent("user-1", "client-1", []string{"read"}, now, 0),
+			}}
+			uc := NewPublishAuthorizationCodeUseCase(logger, &mockRandomCodeGenerator{}, ss, &mockSessionIDGenerator{}, ts, &mockAuthenticator{user: user}, tr, &mockAuthCodeRepository{}, cr, &mockClientRepository{}, 0, domain.DefaultLifetimes(), domain.NewScopeProvider(nil))
+
+			// when
+			// パスワードの確認ではログインを完了せず、二要素目のコードを求める
+			loginInput, _ := NewPublishAuthorizationCodeInput(sessionID, transactionID, "user@example.com", "password", ConsentUndecided, nil)
+			output, err := uc.Execute(loginInput)
+			if err != nil || !output.OTPRequired() || output.RenewedSessionID() != "" {
+				t.Fatalf("Execute() with password = %+v, %v, want otp required", output, err)
+			}
+			if ss.sessions[sessionID].IsAuthenticated() {
+				t.Fatal("session should not be authenticated before the second factor")
+			}
+			for range tt.failedAttempts {
+				ts.transactions[transactionID] = ts.transactions[transactionID].WithPendingLogin(ts.transactions[transactionID].PendingLogin().RecordFailure())
+			}
+			otpInput, err := NewSecondFactorInput(sessionID, transactionID, tt.otp)
+			if err != nil {
+				t.Fatalf("Failed to create input: %v", err)
+			}
+			output, err = uc.Execute(otpInput)
+
+			// then
+			if !errors.Is(err, tt.expectedErr) {
+				t.Fatalf("Execute() error = %v, want %v", err, tt.expectedErr)
+			}
+			if tt.expectedErr != nil {
+				transaction := ts.transactions[transactionID]
+				if (transaction.PendingLogin() != nil) != tt.expectedPending {
+					t.Errorf("PendingLogin() = %+v, want pending %v", transaction.PendingLogin(), tt.expectedPending)
+				}
+				if _, ok := ss.sessions["renewed-session-id"]; ok {
+					t.Error("session should not be renewed")
+				}
+				return
+			}
+			sessionData := ss.sessions[output.RenewedSessionID()]
+			if sessionData == nil || !slices.Equal(sessionData.AMR(), []string{domain.AMRPassword, domain.AMROTP}) {
+				t.Errorf("session = %+v, want amr [pwd otp]", sessionData)
+			}
+			if output.AuthorizationCode() == "" {
+				t.Error("authorization code should be issued")
+			}
+
+			// 同じコードは再び使えない
+			ts.transactions[transactionID] = inf_dto.NewAuthorizationTransaction(transactionID, sessionID, param, now)
+			ss.sessions[sessionID] = inf_dto.NewSessionData(nil, time.Time{}, nil)
+			uc.Execute(loginInput)
+			if _, err := uc.Execute(otpInput); !errors.Is(err, ErrInvalidOTP) {
+				t.Errorf("Execute() with the same code error = %v, want %v", err, ErrInvalidOTP)
+			}
+		})
+	}
+
+	t.Run("異常系 - パスワードを確認していないトランザクションにコードを送信した", func(t *testing.T) {
+		// given
+		ss := &mockSessionStorage{sessions: map[session.SessionID]*inf_dto.SessionData{
+			sessionID: inf_dto.NewSessionData(nil, time.Time{}, nil),
+		}}
+		ts := &mockTransactionStorage{transactions: map[session.TransactionID]*inf_dto.AuthorizationTransaction{
+			transactionID: inf_dto.NewAuthorizationTransaction(transactionID, sessionID, param, now),
+		}}
+		uc := NewPublishAuthorizationCodeUseCase(logger, &mockRandomCodeGenerator{}, ss, &mockSessionIDGenerator{}, ts, &mockAuthenticator{user: user}, &mockTOTPRepository{}, &mockAuthCodeRepository{}, &mockConsentRepository{consents: map[string]*domain.Consent{}}, &mockClientRepository{}, 0, domain.DefaultLifetimes(), domain.NewScopeProvider(nil))
+		input, _ := NewSecondFactorInput(sessionID, transactionID, domain.TOTPCode(secret, now))
+
+		// when
+		_, err := uc.Execute(input)
+
+		// then
+		if !errors.Is(err, ErrLoginRequired) {
+			t.Errorf("Execute() error = %v, want %v", err, ErrLoginRequired)
+		}
+	})
+
+	t.Run("異常系 - 認可リクエストをやり直してもユーザー毎の失敗の回数は戻らない", func(t *testing.T) {
+		// given: 別の認可リクエストで上限の1回前まで失敗したユーザー
+		credential := domain.NewTOTPCredential("user-1", secret).Confirm(now, step-10, nil)
+		for range domain.TOTPMaxFailedAttempts - 1 {
+			credential = credential.RecordFailure(now)
+		}
+		tr := &mockTOTPRepository{credentials: map[string]*domain.TOTPCredential{"user-1": credential}}
+		ss := &mockSessionStorage{sessions: map[session.SessionID]*inf_dto.SessionData{
+			sessionID: inf_dto.NewSessionData(nil, time.Time{}, nil),
+		}}
+		ts := &mockTransactionStorage{transactions: map[session.TransactionID]*inf_dto.AuthorizationTransaction{
+			transactionID: inf_dto.NewAuthorizationTransaction(transactionID, sessionID, param, now),
+		}}
+		cr := &mockConsentRepository{consents: map[string]*domain.Consent{
+			"user-1:client-1": domain.NewConsent("user-1", "client-1", []string{"read"}, now, 0),
+		}}
+		uc := NewPublishAuthorizationCodeUseCase(logger, &mockRandomCodeGenerator{}, ss, &mockSessionIDGenerator{}, ts, &mockAuthenticator{user: user}, tr, &mockAuthCodeRepository{}, cr, &mockClientRepository{}, 0, domain.DefaultLifetimes(), domain.NewScopeProvider(nil))
+		loginInput, _ := NewPublishAuthorizationCodeInput(sessionID, transactionID, "user@example.com", "password", ConsentUndecided, nil)
+		wrongInput, _ := NewSecondFactorInput(sessionID, transactionID, "000000")
+		correctInput, _ := NewSecondFactorInput(sessionID, transactionID, domain.TOTPCode(secret, now))
+
+		// when: 新しい認可リクエストで1回目の失敗
+		uc.Execute(loginInput)
+		_, err := uc.Execute(wrongInput)
+
+		// then: ユーザー毎の上限に達し、パスワードからやり直させる
+		if !errors.Is(err, ErrTooManyOTPAttempts) {
+			t.Fatalf("Execute() error = %v, want %v", err, ErrTooManyOTPAttempts)
+		}
+		if ts.transactions[transactionID].PendingLogin() != nil {
+			t.Error("pending login should be cleared")
+		}
+
+		// when: 期間中は正しいコードでも確認しない
+		uc.Execute(loginInput)
+		_, err = uc.Execute(correctInput)
+
+		// then
+		if !errors.Is(err, ErrTooManyOTPAttempts) {
+			t.Fatalf("Execute() with the correct code error = %v, want %v", err, ErrTooManyOTPAttempts)
+		}
+		if _, ok := ss.sessions["renewed-session-id"]; ok {
+			t.Error("session should not be renewed")
+		}
+		if tr.credentials["user-1"].FailedAttempts() != domain.TOTPMaxFailedAttempts {
+			t.Errorf("FailedAttempts() = %d, want %d", tr.credentials["user-1"].FailedAttempts(), domain.TOTPMaxFailedAttempts)
+		}
+	})
+
+	t.Run("正常系 - 確認に成功するとユーザー毎の失敗の回数を戻す", func(t *testing.T) {
+		// given
+		credential := domain.NewTOTPCredential("user-1", secret).Confirm(now, step-10, nil).RecordFailure(now).RecordFailure(now)
+		tr := &mockTOTPRepository{credentials: map[string]*domain.TOTPCredential{"user-1": credential}}
+		ss := &mockSessionStorage{sessions: map[session.SessionID]*inf_dto.SessionData{
+			sessionID: inf_dto.NewSessionData(nil, time.Time{}, nil),
+		}}
+		ts := &mockTransactionStorage{transactions: map[session.TransactionID]*inf_dto.AuthorizationTransaction{
+			transactionID: inf_dto.NewAuthorizationTransaction(transactionID, sessionID, param, now),
+		}}
+		cr := &mockConsentRepository{consents: map[string]*domain.Consent{
+			"user-1:client-1": domain.NewConsent("user-1", "client-1", []string{"read"}, now, 0),
+		}}
+		uc := NewPublishAuthorizationCodeUseCase(logger, &mockRandomCodeGenerator{}, ss, &mockSessionIDGenerator{}, ts, &mockAuthenticator{user: user}, tr, &mockAuthCodeRepository{}, cr, &mockClientRepository{}, 0, domain.DefaultLifetimes(), domain.NewScopeProvider(nil))
+		loginInput, _ := NewPublishAuthorizationCodeInput(sessionID, transactionID, "user@example.com", "password", ConsentUndecided, nil)
+		otpInput, _ := NewSecondFactorInput(sessionID, transactionID, domain.TOTPCode(secret, now))
+
+		// when
+		uc.Execute(loginInput)
+		_, err := uc.Execute(otpInput)
+
+		// then
+		if err != nil {
+			t.Fatalf("Execute() error = %v", err)
+		}
+		if tr.credentials["user-1"].FailedAttempts() != 0 {
+			t.Errorf("FailedAttempts() = %d, want 0", tr.credentials["user-1"].FailedAttempts())
+		}
+	})
+
+	t.Run("正常系 - 確認待ちのTOTPはログイン時に求めない", func(t *testing.T) {
+		// given
+		ss := &mockSessionStorage{sessions: map[session.SessionID]*inf_dto.SessionData{
+			sessionID: inf_dto.NewSessionData(nil, time.Time{}, nil),
+		}}
+		ts := &mockTransactionStorage{transactions: map[session.TransactionID]*inf_dto.AuthorizationTransaction{
+			transactionID: inf_dto.NewAuthorizationTransaction(transactionID, sessionID, param, now),
+		}}
+		tr := &mockTOTPRepository{credentials: map[string]*domain.TOTPCredential{"user-1": domain.NewTOTPCredential("user-1", secret)}}
+		uc := NewPublishAuthorizationCodeUseCase(logger, &mockRandomCodeGenerator{}, ss, &mockSessionIDGenerator{}, ts, &mockAuthenticator{user: user}, tr, &mockAuthCodeRepository{}, &mockConsentRepository{consents: map[string]*domain.Consent{}}, &mockClientRepository{}, 0, domain.DefaultLifetimes(), domain.NewScopeProvider(nil))
+		input, _ := NewPublishAuthorizationCodeInput(sessionID, transactionID, "user@example.com", "password", ConsentUndecided, nil)
+
+		// when
+		output, err := uc.Execute(input)
+
+		// then
+		if err != nil || output.OTPRequired() || output.RenewedSessionID() == "" {
+			t.Errorf("Execute() = %+v, %v, want login without otp", output, err)
+		}
+	})
+}
@@ -1,16 +1,48 @@
 package decision
 
+import "oauth-tutorial/internal/session"
+
 type PublishAuthorizationCodeOutput struct {
 	baseRedirectUri   string
 	authorizationCode string
 	state             string
+	// ログインによってセッションIDを再生成した場合は新しいセッションID、それ以外は空文字
+	renewedSessionID session.SessionID
+	// ログインは完了したが、同意画面での同意が必要な場合はtrue
+	consentRequired bool
+	// パスワードの確認は完了したが、二要素目(TOTP)のコードが必要な場合はtrue
+	otpRequired   bool
+	transactionID session.TransactionID
+	// 同意画面に表示するクライアント名と要求されたスコープ
+	clientName string
+	scopes     []string
 }
 
-func NewPublishAuthorizationCodeOutput(baseRedirectUri, authorizationCode, state string) PublishAuthorizationCodeOutput {
+func NewPublishAuthorizationCodeOutput(baseRedirectUri, authorizationCode, state string, renewedSessionID session.SessionID) PublishAuthorizationCodeOutput {
 	return PublishAuthorizationCodeOutput{
 		baseRedirectUri:   baseRedirectUri,
 		authorizationCode: authorizationCode,
 		state:             state,
+		renewedSessionID:  renewedSessionID,
+	}
+}
+
+// ログイン後に同意を求める場合の出力
+func NewConsentRequiredOutput(transactionID session.TransactionID, renewedSessionID session.SessionID, clientName string, scopes []string) PublishAuthorizationCodeOutput {
+	return PublishAuthorizationCodeOutput{
+		renewedSessionID: renewedSessionID,
+		consentRequired:  true,
+		transactionID:    transactionID,
+		clientName:       clientName,
+		scopes:           scopes,
+	}
+}
+
+// パスワードの確認後に二要素目のコードを求める場合の出力
+func NewOTPRequiredOutput(transactionID session.TransactionID) PublishAuthorizationCodeOutput {
+	return PublishAuthorizationCodeOutput{
+		otpRequired:   true,
+		transactionID: transactionID,
 	}
 }
 
@@ -26,6 +58,30 @@ func (r *PublishAuthorizationCodeOutput) State() string {
 	return r.state
 }
 
+func (r *PublishAuthorizationCodeOutput) RenewedSessionID() session.SessionID {
+	return r.renewedSessionID
+}
+
+func (r *PublishAuthorizationCodeOutput) ConsentRequired() bool {
+	return r.consentRequired
+}
+
+func (r *PublishAuthorizationCodeOutput) OTPRequired() bool {
+	return r.otpRequired
+}
+
+func (r *PublishAuthorizationCodeOutput) TransactionID() session.TransactionID {
+	return r.transactionID
+}
+
+func (r *PublishAuthorizationCodeOutput) ClientName() string {
+	return r.clientName
+}
+
+func (r *PublishAuthorizationCodeOutput) Scopes() []string {
+	return r.scopes
+}
+
 type ErrPublishAuthorizationCode struct {
 	err             error
 	baseRedirectUri string
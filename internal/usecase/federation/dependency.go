@@ -0,0 +1,44 @@
+package federation
+
+import (
+	"context"
+	"oauth-tutorial/internal/domain"
+	inf_dto "oauth-tutorial/internal/infrastructure/dto"
+	"oauth-tutorial/internal/session"
+)
+
+// 上流のOpenID Connectプロバイダー(IdP)
+type IIdentityProvider interface {
+	// codeChallengeはPKCEのコード検証器のSHA-256(S256)
+	AuthorizationURL(ctx context.Context, state, nonce, codeChallenge string) (string, error)
+	// 認可コードをIDトークンと交換して検証し、IdPのユーザーとログインIDを返す
+	Authenticate(ctx context.Context, code, codeVerifier, nonce string) (domain.FederatedIdentity, string, error)
+}
+
+type IRandomGenerator interface {
+	GenerateURLSafeRandomString(n int) string
+}
+
+type ITransactionStorage interface {
+	Get(transactionID session.TransactionID) (*inf_dto.AuthorizationTransaction, error)
+	Save(transaction *inf_dto.AuthorizationTransaction) error
+}
+
+type ILoginStateStorage interface {
+	Save(state *inf_dto.FederatedLoginState) error
+	Consume(state string) (*inf_dto.FederatedLoginState, error)
+}
+
+type IUserRepository interface {
+	FindByFederatedIdentity(identity domain.FederatedIdentity) (*domain.User, error)
+	Save(user *domain.User) error
+}
+
+type ISessionStorage interface {
+	Save(sessionID session.SessionID, sessionData *inf_dto.SessionData) error
+	Delete(sessionID session.SessionID) error
+}
+
+type ISessionIDGenerator interface {
+	Generate() session.SessionID
+}
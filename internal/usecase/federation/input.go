@@ -0,0 +1,34 @@
+package federation
+
+import (
+	"errors"
+	"oauth-tutorial/internal/session"
+)
+
+var (
+	ErrEmptySessionID = errors.New("session ID cannot be empty")
+	ErrEmptyState     = errors.New("state cannot be empty")
+	ErrEmptyCode      = errors.New("code or error is required")
+)
+
+// 上流のIdPからのコールバック
+type CompleteFederatedLoginInput struct {
+	sessionID session.SessionID
+	state     string
+	code      string
+	// IdPが認可を拒否した場合などのerrorパラメータ。codeとどちらかを指定する
+	upstreamError string
+}
+
+func NewCompleteFederatedLoginInput(sessionID session.SessionID, state, code, upstreamError string) (*CompleteFederatedLoginInput, error) {
+	if sessionID == "" {
+		return nil, ErrEmptySessionID
+	}
+	if state == "" {
+		return nil, ErrEmptyState
+	}
+	if code == "" && upstreamError == "" {
+		return nil, ErrEmptyCode
+	}
+	return &CompleteFederatedLoginInput{sessionID: sessionID, state: state, code: code, upstreamError: upstreamError}, nil
+}
@@ -0,0 +1,188 @@
+package federation
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"oauth-tutorial/internal/domain"
+	"oauth-tutorial/internal/infrastructure"
+	inf_dto "oauth-tutorial/internal/infrastructure/dto"
+	"oauth-tutorial/internal/session"
+	"oauth-tutorial/pkg/mylogger"
+	"time"
+)
+
+var (
+	ErrProviderNotFound       = errors.New("identity provider not found")
+	ErrProviderUnavailable    = errors.New("identity provider is unavailable")
+	ErrTransactionNotFound    = errors.New("authorization transaction not found")
+	ErrLoginStateNotFound     = errors.New("federated login state not found")
+	ErrUpstreamLoginFailed    = errors.New("login with the identity provider failed")
+	ErrUnexpectedStorageError = errors.New("unexpected error occurred while accessing storage")
+)
+
+// 上流のIdPでのログインを表すamrの値。RFC 8176に該当する値が無いため独自の値を使う
+const AMRFederated = "fed"
+
+// ランダムな値のバイト数
+const (
+	stateBytes        = 32
+	nonceBytes        = 32
+	codeVerifierBytes = 32
+	userIDBytes       = 16
+)
+
+// ログイン画面から上流のIdPへの認可リクエストを開始するユースケース
+type StartFederatedLoginUseCase struct {
+	logger           mylogger.Logger
+	providers        map[string]IIdentityProvider
+	transactionStore ITransactionStorage
+	loginStateStore  ILoginStateStorage
+	randomGenerator  IRandomGenerator
+}
+
+func NewStartFederatedLoginUseCase(logger mylogger.Logger, providers map[string]IIdentityProvider, ts ITransactionStorage, ls ILoginStateStorage, rg IRandomGenerator) *StartFederatedLoginUseCase {
+	return &StartFederatedLoginUseCase{
+		logger:           logger,
+		providers:        providers,
+		transactionStore: ts,
+		loginStateStore:  ls,
+		randomGenerator:  rg,
+	}
+}
+
+// 処理中の認可リクエストのトランザクションに紐づけてIdPへの認可リクエストの状態を保存し、リダイレクト先のURLを返す
+func (uc *StartFederatedLoginUseCase) Execute(ctx context.Context, sessionID session.SessionID, transactionID session.TransactionID, providerName string) (string, error) {
+	now := time.Now()
+	provider, ok := uc.providers[providerName]
+	if !ok {
+		uc.logger.Info("Identity provider not found", "provider", providerName)
+		return "", ErrProviderNotFound
+	}
+	transaction, err := uc.transactionStore.Get(transactionID)
+	if err != nil || transaction == nil || transaction.SessionID() != sessionID || transaction.IsExpired(now) {
+		uc.logger.Info("Authorization transaction not found", "err", err, "transactionID", transactionID)
+		return "", ErrTransactionNotFound
+	}
+
+	// stateはCSRF対策、nonceはIDトークンのリプレイ対策、コード検証器は認可コードの横取り対策 RFC 7636
+	state := uc.randomGenerator.GenerateURLSafeRandomString(stateBytes)
+	nonce := uc.randomGenerator.GenerateURLSafeRandomString(nonceBytes)
+	codeVerifier := uc.randomGenerator.GenerateURLSafeRandomString(codeVerifierBytes)
+	authorizationURL, err := provider.AuthorizationURL(ctx, state, nonce, codeChallengeS256(codeVerifier))
+	if err != nil {
+		uc.logger.Error("Failed to build authorization url of identity provider", "provider", providerName, "err", err)
+		return "", ErrProviderUnavailable
+	}
+
+	loginState := inf_dto.NewFederatedLoginState(state, providerName, transactionID, sessionID, nonce, codeVerifier, now)
+	if err := uc.loginStateStore.Save(loginState); err != nil {
+		uc.logger.Error("Failed to save federated login state", "err", err)
+		return "", ErrUnexpectedStorageError
+	}
+	return authorizationURL, nil
+}
+
+// RFC 7636 4.2 code_challenge = BASE64URL(SHA256(code_verifier))
+func codeChallengeS256(codeVerifier string) string {
+	digest := sha256.Sum256([]byte(codeVerifier))
+	return base64.RawURLEncoding.EncodeToString(digest[:])
+}
+
+// 上流のIdPからのコールバックを受けてログインするユースケース
+// IdPのユーザーに対応するユーザーが存在しない場合は作成する(JITプロビジョニング)
+type CompleteFederatedLoginUseCase struct {
+	logger             mylogger.Logger
+	providers          map[string]IIdentityProvider
+	loginStateStore    ILoginStateStorage
+	transactionStore   ITransactionStorage
+	userRepository     IUserRepository
+	sessionStore       ISessionStorage
+	sessionIDGenerator ISessionIDGenerator
+	randomGenerator    IRandomGenerator
+}
+
+func NewCompleteFederatedLoginUseCase(logger mylogger.Logger, providers map[string]IIdentityProvider, ls ILoginStateStorage, ts ITransactionStorage, ur IUserRepository, ss ISessionStorage, sig ISessionIDGenerator, rg IRandomGenerator) *CompleteFederatedLoginUseCase {
+	return &CompleteFederatedLoginUseCase{
+		logger:             logger,
+		providers:          providers,
+		loginStateStore:    ls,
+		transactionStore:   ts,
+		userRepository:     ur,
+		sessionStore:       ss,
+		sessionIDGenerator: sig,
+		randomGenerator:    rg,
+	}
+}
+
+func (uc *CompleteFederatedLoginUseCase) Execute(ctx context.Context, input *CompleteFederatedLoginInput) (CompleteFederatedLoginOutput, error) {
+	now := time.Now()
+	// stateは1回のみ使え、IdPへの認可リクエストを開始したブラウザセッションからのみ受け付ける
+	loginState, err := uc.loginStateStore.Consume(input.state)
+	if err != nil || loginState.SessionID() != input.sessionID || loginState.IsExpired(now) {
+		mylogger.SecurityEvent(uc.logger, "federated_login_state_invalid", "err", err)
+		return CompleteFederatedLoginOutput{}, ErrLoginStateNotFound
+	}
+	if input.upstreamError != "" {
+		uc.logger.Info("Identity provider returned an error", "provider", loginState.Provider(), "error", input.upstreamError)
+		return CompleteFederatedLoginOutput{}, ErrUpstreamLoginFailed
+	}
+	provider, ok := uc.providers[loginState.Provider()]
+	if !ok {
+		// 設定の変更でIdPが削除された場合
+		uc.logger.Info("Identity provider not found", "provider", loginState.Provider())
+		return CompleteFederatedLoginOutput{}, ErrProviderNotFound
+	}
+	transaction, err := uc.transactionStore.Get(loginState.TransactionID())
+	if err != nil || transaction == nil || transaction.SessionID() != input.sessionID || transaction.IsExpired(now) {
+		uc.logger.Info("Authorization transaction not found", "err", err, "transactionID", loginState.TransactionID())
+		return CompleteFederatedLoginOutput{}, ErrTransactionNotFound
+	}
+
+	identity, loginID, err := provider.Authenticate(ctx, input.code, loginState.CodeVerifier(), loginState.Nonce())
+	if err != nil {
+		mylogger.SecurityEvent(uc.logger, "federated_login_failed", "provider", loginState.Provider(), "err", err)
+		return CompleteFederatedLoginOutput{}, ErrUpstreamLoginFailed
+	}
+	user, err := uc.findOrCreateUser(loginState.Provider(), identity, loginID)
+	if err != nil {
+		return CompleteFederatedLoginOutput{}, err
+	}
+
+	// セッション固定攻撃対策としてセッションIDを再生成し、処理中のトランザクションを新しいセッションに紐づけ直す
+	newSessionID := uc.sessionIDGenerator.Generate()
+	if err := uc.sessionStore.Save(newSessionID, inf_dto.NewSessionData(user, now, []string{AMRFederated})); err != nil {
+		uc.logger.Error("Failed to save regenerated session", "err", err)
+		return CompleteFederatedLoginOutput{}, ErrUnexpectedStorageError
+	}
+	uc.sessionStore.Delete(input.sessionID)
+	if err := uc.transactionStore.Save(transaction.BindTo(newSessionID)); err != nil {
+		uc.logger.Error("Failed to rebind authorization transaction", "err", err)
+		return CompleteFederatedLoginOutput{}, ErrUnexpectedStorageError
+	}
+
+	uc.logger.Info("Logged in with identity provider", "provider", loginState.Provider(), "userID", user.UserID())
+	return NewCompleteFederatedLoginOutput(newSessionID, transaction.ID()), nil
+}
+
+// IdPのユーザーに対応するユーザーを取得する。存在しない場合は作成する
+// 他のIdPやパスワードでログインするユーザーとログインIDが重複しないよう、ログインIDにはIdPの名前を付ける(例: corp:alice@example.com)
+func (uc *CompleteFederatedLoginUseCase) findOrCreateUser(providerName string, identity domain.FederatedIdentity, loginID string) (*domain.User, error) {
+	user, err := uc.userRepository.FindByFederatedIdentity(identity)
+	if err == nil {
+		return user, nil
+	}
+	if !errors.Is(err, infrastructure.ErrUserNotFound) {
+		uc.logger.Error("Failed to find federated user", "err", err)
+		return nil, ErrUnexpectedStorageError
+	}
+
+	user = domain.NewFederatedUser(uc.randomGenerator.GenerateURLSafeRandomString(userIDBytes), providerName+":"+loginID, identity)
+	if err := uc.userRepository.Save(user); err != nil {
+		uc.logger.Error("Failed to save federated user", "err", err)
+		return nil, ErrUnexpectedStorageError
+	}
+	mylogger.AuditEvent(uc.logger, "federated_user_created", "provider", providerName, "userID", user.UserID(), "loginID", user.LoginID())
+	return user, nil
+}
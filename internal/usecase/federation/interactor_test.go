@@ -0,0 +1,260 @@
+package federation
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"oauth-tutorial/internal/domain"
+	"oauth-tutorial/internal/infrastructure"
+	inf_dto "oauth-tutorial/internal/infrastructure/dto"
+	"oauth-tutorial/internal/session"
+	"oauth-tutorial/pkg/mylogger"
+	"slices"
+	"testing"
+	"time"
+)
+
+const (
+	testIssuer        = "https://idp.example.com"
+	testSessionID     = session.SessionID("test-session-id")
+	testTransactionID = session.TransactionID("test-transaction-id")
+)
+
+// AuthorizationURLで受け取った値を記録し、Authenticateで照合する
+type mockIdentityProvider struct {
+	state, nonce, codeChallenge string
+	subject                     string
+	authenticateErr             error
+}
+
+func (m *mockIdentityProvider) AuthorizationURL(ctx context.Context, state, nonce, codeChallenge string) (string, error) {
+	m.state, m.nonce, m.codeChallenge = state, nonce, codeChallenge
+	return testIssuer + "/authorize?" + url.Values{"state": {state}}.Encode(), nil
+}
+
+func (m *mockIdentityProvider) Authenticate(ctx context.Context, code, codeVerifier, nonce string) (domain.FederatedIdentity, string, error) {
+	if m.authenticateErr != nil {
+		return domain.FederatedIdentity{}, "", m.authenticateErr
+	}
+	if code != "test-code" || nonce != m.nonce || codeChallengeS256(codeVerifier) != m.codeChallenge {
+		return domain.FederatedIdentity{}, "", errors.New("invalid code")
+	}
+	identity, _ := domain.NewFederatedIdentity(testIssuer, m.subject)
+	return identity, m.subject + "@example.com", nil
+}
+
+// 呼び出し毎に異なる値を返す
+type sequenceRandomGenerator struct {
+	n int
+}
+
+func (g *sequenceRandomGenerator) GenerateURLSafeRandomString(n int) string {
+	g.n++
+	return "random-" + string(rune('a'+g.n))
+}
+
+type fixedSessionIDGenerator struct{}
+
+func (fixedSessionIDGenerator) Generate() session.SessionID {
+	return "renewed-session-id"
+}
+
+type federationTestEnv struct {
+	provider     *mockIdentityProvider
+	transactions *infrastructure.TransactionStorage
+	loginStates  *infrastructure.FederatedLoginStateStorage
+	users        *infrastructure.UserRepository
+	sessions     *infrastructure.SessionStorage
+	start        *StartFederatedLoginUseCase
+	complete     *CompleteFederatedLoginUseCase
+}
+
+func newFederationTestEnv(t *testing.T) *federationTestEnv {
+	t.Helper()
+	logger := mylogger.NewMockLogger()
+	env := &federationTestEnv{
+		provider:     &mockIdentityProvider{subject: "alice"},
+		transactions: infrastructure.NewTransactionStorage(),
+		loginStates:  infrastructure.NewFederatedLoginStateStorage(),
+		users:        infrastructure.NewUserRepositoryWithUsers(nil),
+		sessions:     infrastructure.NewSessionStorage(0, 0),
+	}
+	providers := map[string]IIdentityProvider{"corp": env.provider}
+	rg := &sequenceRandomGenerator{}
+	env.start = NewStartFederatedLoginUseCase(logger, providers, env.transactions, env.loginStates, rg)
+	env.complete = NewCompleteFederatedLoginUseCase(logger, providers, env.loginStates, env.transactions, env.users, env.sessions, fixedSessionIDGenerator{}, rg)
+
+	param, err := domain.NewAuthorizationCodeFlowParam(logger, domain.DefaultScopeRegistry(), "code", "test-client", "https://client.example.com/callback", "read", "xyz")
+	if err != nil {
+		t.Fatalf("NewAuthorizationCodeFlowParam() error = %v", err)
+	}
+	_ = env.transactions.Save(inf_dto.NewAuthorizationTransaction(testTransactionID, testSessionID, param, time.Now()))
+	_ = env.sessions.Save(testSessionID, inf_dto.NewSessionData(nil, time.Time{}, nil))
+	return env
+}
+
+// IdPへの認可リクエストを開始し、stateを返す
+func (env *federationTestEnv) startLogin(t *testing.T) string {
+	t.Helper()
+	if _, err := env.start.Execute(context.Background(), testSessionID, testTransactionID, "corp"); err != nil {
+		t.Fatalf("Start Execute() error = %v", err)
+	}
+	return env.provider.state
+}
+
+func Test_上流のIdPへの認可リクエストの開始(t *testing.T) {
+	tests := []struct {
+		name          string
+		sessionID     session.SessionID
+		transactionID session.TransactionID
+		provider      string
+		expectedErr   error
+	}{
+		{name: "正常系", sessionID: testSessionID, transactionID: testTransactionID, provider: "corp"},
+		{name: "異常系 - 存在しないIdP", sessionID: testSessionID, transactionID: testTransactionID, provider: "unknown", expectedErr: ErrProviderNotFound},
+		{name: "異常系 - 存在しないトランザクション", sessionID: testSessionID, transactionID: "unknown", provider: "corp", expectedErr: ErrTransactionNotFound},
+		{name: "異常系 - 別のセッションのトランザクション", sessionID: "other-session-id", transactionID: testTransactionID, provider: "corp", expectedErr: ErrTransactionNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// given
+			env := newFederationTestEnv(t)
+
+			// when
+			redirectURL, err := env.start.Execute(context.Background(), tt.sessionID, tt.transactionID, tt.provider)
+
+			// then
+			if !errors.Is(err, tt.expectedErr) {
+				t.Fatalf("Execute() error = %v, want %v", err, tt.expectedErr)
+			}
+			if tt.expectedErr != nil {
+				return
+			}
+			if redirectURL == "" || env.provider.state == "" || env.provider.nonce == "" || env.provider.codeChallenge == "" {
+				t.Errorf("Execute() = %q, state = %q, nonce = %q, codeChallenge = %q", redirectURL, env.provider.state, env.provider.nonce, env.provider.codeChallenge)
+			}
+			// stateとnonceは推測できないよう別々の値にする
+			if env.provider.state == env.provider.nonce {
+				t.Error("state and nonce must be different")
+			}
+		})
+	}
+}
+
+func Test_上流のIdPでのログイン(t *testing.T) {
+	t.Run("初回はユーザーを作成し、トランザクションを新しいセッションに紐づけ直す", func(t *testing.T) {
+		// given
+		env := newFederationTestEnv(t)
+		state := env.startLogin(t)
+		input, _ := NewCompleteFederatedLoginInput(testSessionID, state, "test-code", "")
+
+		// when
+		output, err := env.complete.Execute(context.Background(), input)
+
+		// then
+		if err != nil {
+			t.Fatalf("Execute() error = %v", err)
+		}
+		if output.SessionID() != "renewed-session-id" || output.TransactionID() != testTransactionID {
+			t.Errorf("Execute() = %+v", output)
+		}
+		identity, _ := domain.NewFederatedIdentity(testIssuer, "alice")
+		user, err := env.users.FindByFederatedIdentity(identity)
+		if err != nil {
+			t.Fatalf("FindByFederatedIdentity() error = %v", err)
+		}
+		if user.LoginID() != "corp:alice@example.com" {
+			t.Errorf("LoginID() = %q", user.LoginID())
+		}
+		sessionData, err := env.sessions.Get("renewed-session-id")
+		if err != nil || sessionData.User().UserID() != user.UserID() || !slices.Equal(sessionData.AMR(), []string{AMRFederated}) {
+			t.Errorf("session = %+v, %v", sessionData, err)
+		}
+		if _, err := env.sessions.Get(testSessionID); err == nil {
+			t.Error("old session was not deleted")
+		}
+		transaction, _ := env.transactions.Get(testTransactionID)
+		if transaction.SessionID() != "renewed-session-id" {
+			t.Errorf("transaction session = %q", transaction.SessionID())
+		}
+	})
+
+	t.Run("2回目以降は同じユーザーでログインする", func(t *testing.T) {
+		// given
+		env := newFederationTestEnv(t)
+		identity, _ := domain.NewFederatedIdentity(testIssuer, "alice")
+		existing := domain.NewFederatedUser("existing-user", "corp:alice@example.com", identity)
+		_ = env.users.Save(existing)
+		input, _ := NewCompleteFederatedLoginInput(testSessionID, env.startLogin(t), "test-code", "")
+
+		// when
+		output, err := env.complete.Execute(context.Background(), input)
+
+		// then
+		if err != nil {
+			t.Fatalf("Execute() error = %v", err)
+		}
+		sessionData, _ := env.sessions.Get(output.SessionID())
+		if sessionData.User().UserID() != "existing-user" {
+			t.Errorf("UserID() = %q, want %q", sessionData.User().UserID(), "existing-user")
+		}
+	})
+
+	tests := []struct {
+		name        string
+		sessionID   session.SessionID
+		state       func(state string) string
+		code        string
+		upstreamErr string
+		authErr     error
+		expectedErr error
+	}{
+		{name: "異常系 - 不明なstate", sessionID: testSessionID, state: func(string) string { return "unknown" }, code: "test-code", expectedErr: ErrLoginStateNotFound},
+		{name: "異常系 - 別のセッションからのコールバック", sessionID: "other-session-id", code: "test-code", expectedErr: ErrLoginStateNotFound},
+		{name: "異常系 - IdPがエラーを返した", sessionID: testSessionID, upstreamErr: "access_denied", expectedErr: ErrUpstreamLoginFailed},
+		{name: "異常系 - IDトークンの検証に失敗", sessionID: testSessionID, code: "test-code", authErr: errors.New("invalid id token"), expectedErr: ErrUpstreamLoginFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// given
+			env := newFederationTestEnv(t)
+			env.provider.authenticateErr = tt.authErr
+			state := env.startLogin(t)
+			if tt.state != nil {
+				state = tt.state(state)
+			}
+			input, err := NewCompleteFederatedLoginInput(tt.sessionID, state, tt.code, tt.upstreamErr)
+			if err != nil {
+				t.Fatalf("NewCompleteFederatedLoginInput() error = %v", err)
+			}
+
+			// when
+			_, err = env.complete.Execute(context.Background(), input)
+
+			// then
+			if !errors.Is(err, tt.expectedErr) {
+				t.Errorf("Execute() error = %v, want %v", err, tt.expectedErr)
+			}
+			if _, err := env.sessions.Get(testSessionID); err != nil {
+				t.Errorf("session was changed: %v", err)
+			}
+		})
+	}
+
+	t.Run("同じstateは1回のみ使える", func(t *testing.T) {
+		// given
+		env := newFederationTestEnv(t)
+		state := env.startLogin(t)
+		input, _ := NewCompleteFederatedLoginInput(testSessionID, state, "test-code", "access_denied")
+		_, _ = env.complete.Execute(context.Background(), input)
+
+		// when
+		input, _ = NewCompleteFederatedLoginInput(testSessionID, state, "test-code", "")
+		_, err := env.complete.Execute(context.Background(), input)
+
+		// then
+		if !errors.Is(err, ErrLoginStateNotFound) {
+			t.Errorf("Execute() error = %v, want %v", err, ErrLoginStateNotFound)
+		}
+	})
+}
@@ -0,0 +1,18 @@
+package federation
+
+import "oauth-tutorial/internal/session"
+
+type CompleteFederatedLoginOutput struct {
+	// ログインによって再生成したセッションID
+	sessionID     session.SessionID
+	transactionID session.TransactionID
+}
+
+func NewCompleteFederatedLoginOutput(sessionID session.SessionID, transactionID session.TransactionID) CompleteFederatedLoginOutput {
+	return CompleteFederatedLoginOutput{sessionID: sessionID, transactionID: transactionID}
+}
+
+func (o CompleteFederatedLoginOutput) SessionID() session.SessionID { return o.sessionID }
+
+// ログインを続ける認可リクエストのトランザクション
+func (o CompleteFederatedLoginOutput) TransactionID() session.TransactionID { return o.transactionID }
@@ -0,0 +1,47 @@
+package passkey
+
+import (
+	"oauth-tutorial/internal/domain"
+	inf_dto "oauth-tutorial/internal/infrastructure/dto"
+	"oauth-tutorial/internal/infrastructure/webauthn"
+	"oauth-tutorial/internal/session"
+)
+
+// WebAuthnのRelying Party。ブラウザから受け取ったログインの署名を検証する
+type IRelyingParty interface {
+	ID() string
+	Challenge(clientDataJSON []byte) (string, error)
+	VerifyAssertion(clientDataJSON []byte, authenticatorData []byte, signature []byte, publicKey []byte, challenge string) (webauthn.Assertion, error)
+}
+
+type IRandomGenerator interface {
+	GenerateRandomBytes(n int) []byte
+}
+
+type ITransactionStorage interface {
+	Get(transactionID session.TransactionID) (*inf_dto.AuthorizationTransaction, error)
+	Save(transaction *inf_dto.AuthorizationTransaction) error
+}
+
+type IChallengeStorage interface {
+	Save(challenge *inf_dto.PasskeyChallenge) error
+	Consume(challenge string) (*inf_dto.PasskeyChallenge, error)
+}
+
+type IPasskeyRepository interface {
+	Save(credential *domain.PasskeyCredential) error
+	FindByCredentialID(credentialID []byte) (*domain.PasskeyCredential, error)
+}
+
+type IUserRepository interface {
+	FindByLoginID(loginID string) (*domain.User, error)
+}
+
+type ISessionStorage interface {
+	Save(sessionID session.SessionID, sessionData *inf_dto.SessionData) error
+	Delete(sessionID session.SessionID) error
+}
+
+type ISessionIDGenerator interface {
+	Generate() session.SessionID
+}
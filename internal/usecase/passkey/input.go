@@ -0,0 +1,51 @@
+package passkey
+
+import (
+	"errors"
+	"oauth-tutorial/internal/session"
+)
+
+var (
+	ErrEmptySessionID         = errors.New("session ID cannot be empty")
+	ErrEmptyCredentialID      = errors.New("credential ID cannot be empty")
+	ErrEmptyClientDataJSON    = errors.New("client data cannot be empty")
+	ErrEmptyAuthenticatorData = errors.New("authenticator data cannot be empty")
+	ErrEmptySignature         = errors.New("signature cannot be empty")
+)
+
+// ブラウザのnavigator.credentials.getの結果(AuthenticatorAssertionResponse)
+type CompletePasskeyLoginInput struct {
+	sessionID         session.SessionID
+	credentialID      []byte
+	clientDataJSON    []byte
+	authenticatorData []byte
+	signature         []byte
+	// 認証器が返したユーザーハンドル(登録時のユーザーID)。返さない認証器もある
+	userHandle []byte
+}
+
+func NewCompletePasskeyLoginInput(sessionID session.SessionID, credentialID, clientDataJSON, authenticatorData, signature, userHandle []byte) (*CompletePasskeyLoginInput, error) {
+	if sessionID == "" {
+		return nil, ErrEmptySessionID
+	}
+	if len(credentialID) == 0 {
+		return nil, ErrEmptyCredentialID
+	}
+	if len(clientDataJSON) == 0 {
+		return nil, ErrEmptyClientDataJSON
+	}
+	if len(authenticatorData) == 0 {
+		return nil, ErrEmptyAuthenticatorData
+	}
+	if len(signature) == 0 {
+		return nil, ErrEmptySignature
+	}
+	return &CompletePasskeyLoginInput{
+		sessionID:         sessionID,
+		credentialID:      credentialID,
+		clientDataJSON:    clientDataJSON,
+		authenticatorData: authenticatorData,
+		signature:         signature,
+		userHandle:        userHandle,
+	}, nil
+}
@@ -0,0 +1,162 @@
+package passkey
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"oauth-tutorial/internal/domain"
+	"oauth-tutorial/internal/infrastructure"
+	inf_dto "oauth-tutorial/internal/infrastructure/dto"
+	"oauth-tutorial/internal/session"
+	"oauth-tutorial/pkg/mylogger"
+	"time"
+)
+
+var (
+	ErrTransactionNotFound    = errors.New("authorization transaction not found")
+	ErrChallengeNotFound      = errors.New("passkey challenge not found")
+	ErrInvalidPasskey         = errors.New("invalid passkey")
+	ErrUnexpectedStorageError = errors.New("unexpected error occurred while accessing storage")
+)
+
+// チャレンジのバイト数 WebAuthn Level 2 13.4.3 16バイト以上
+const challengeBytes = 32
+
+// ログイン画面からパスキーでのログインを始めるユースケース
+type BeginPasskeyLoginUseCase struct {
+	logger           mylogger.Logger
+	transactionStore ITransactionStorage
+	challengeStore   IChallengeStorage
+	randomGenerator  IRandomGenerator
+	relyingParty     IRelyingParty
+}
+
+func NewBeginPasskeyLoginUseCase(logger mylogger.Logger, ts ITransactionStorage, cs IChallengeStorage, rg IRandomGenerator, rp IRelyingParty) *BeginPasskeyLoginUseCase {
+	return &BeginPasskeyLoginUseCase{logger: logger, transactionStore: ts, challengeStore: cs, randomGenerator: rg, relyingParty: rp}
+}
+
+// 処理中の認可リクエストのトランザクションに紐づけてチャレンジを発行し、ブラウザに渡すログインのオプションを返す
+func (uc *BeginPasskeyLoginUseCase) Execute(sessionID session.SessionID, transactionID session.TransactionID) (PasskeyLoginOptions, error) {
+	now := time.Now()
+	transaction, err := uc.transactionStore.Get(transactionID)
+	if err != nil || transaction == nil || transaction.SessionID() != sessionID || transaction.IsExpired(now) {
+		uc.logger.Info("Authorization transaction not found", "err", err, "transactionID", transactionID)
+		return PasskeyLoginOptions{}, ErrTransactionNotFound
+	}
+	challenge := base64.RawURLEncoding.EncodeToString(uc.randomGenerator.GenerateRandomBytes(challengeBytes))
+	if err := uc.challengeStore.Save(inf_dto.NewPasskeyLoginChallenge(challenge, sessionID, transactionID, now)); err != nil {
+		uc.logger.Error("Failed to save passkey challenge", "err", err)
+		return PasskeyLoginOptions{}, ErrUnexpectedStorageError
+	}
+	return NewPasskeyLoginOptions(challenge, uc.relyingParty.ID(), inf_dto.PASSKEY_CHALLENGE_DURATION), nil
+}
+
+// ブラウザから受け取ったパスキーの署名を検証してログインするユースケース
+// 本人の確認(UV)を行った署名のみ受け付け、パスキーが所持と本人の確認を兼ねるため、TOTPを登録したユーザーでも確認コードは求めない
+type CompletePasskeyLoginUseCase struct {
+	logger             mylogger.Logger
+	challengeStore     IChallengeStorage
+	transactionStore   ITransactionStorage
+	passkeyRepository  IPasskeyRepository
+	userRepository     IUserRepository
+	sessionStore       ISessionStorage
+	sessionIDGenerator ISessionIDGenerator
+	relyingParty       IRelyingParty
+}
+
+func NewCompletePasskeyLoginUseCase(logger mylogger.Logger, cs IChallengeStorage, ts ITransactionStorage, pr IPasskeyRepository, ur IUserRepository, ss ISessionStorage, sig ISessionIDGenerator, rp IRelyingParty) *CompletePasskeyLoginUseCase {
+	return &CompletePasskeyLoginUseCase{
+		logger:             logger,
+		challengeStore:     cs,
+		transactionStore:   ts,
+		passkeyRepository:  pr,
+		userRepository:     ur,
+		sessionStore:       ss,
+		sessionIDGenerator: sig,
+		relyingParty:       rp,
+	}
+}
+
+func (uc *CompletePasskeyLoginUseCase) Execute(input *CompletePasskeyLoginInput) (CompletePasskeyLoginOutput, error) {
+	now := time.Now()
+	// チャレンジは1回のみ使え、ログインを始めたブラウザセッションからのみ受け付ける
+	challenge, err := uc.relyingParty.Challenge(input.clientDataJSON)
+	if err != nil {
+		mylogger.SecurityEvent(uc.logger, "passkey_login_failed", "err", err)
+		return CompletePasskeyLoginOutput{}, ErrInvalidPasskey
+	}
+	issued, err := uc.challengeStore.Consume(challenge)
+	if err != nil || issued.Ceremony() != inf_dto.PasskeyLogin || issued.SessionID() != input.sessionID || issued.IsExpired(now) {
+		mylogger.SecurityEvent(uc.logger, "passkey_challenge_invalid", "err", err)
+		return CompletePasskeyLoginOutput{}, ErrChallengeNotFound
+	}
+	transaction, err := uc.transactionStore.Get(issued.TransactionID())
+	if err != nil || transaction == nil || transaction.SessionID() != input.sessionID || transaction.IsExpired(now) {
+		uc.logger.Info("Authorization transaction not found", "err", err, "transactionID", issued.TransactionID())
+		return CompletePasskeyLoginOutput{}, ErrTransactionNotFound
+	}
+
+	credential, err := uc.passkeyRepository.FindByCredentialID(input.credentialID)
+	if err != nil {
+		if !errors.Is(err, infrastructure.ErrPasskeyCredentialNotFound) {
+			uc.logger.Error("Failed to find passkey", "err", err)
+			return CompletePasskeyLoginOutput{}, ErrUnexpectedStorageError
+		}
+		mylogger.SecurityEvent(uc.logger, "passkey_login_failed", "err", err)
+		return CompletePasskeyLoginOutput{}, ErrInvalidPasskey
+	}
+	// ユーザーハンドルを返した場合は、クレデンシャルを登録したユーザーと一致すること WebAuthn Level 2 7.2 step 6
+	if len(input.userHandle) > 0 && !bytes.Equal(input.userHandle, []byte(credential.UserID())) {
+		mylogger.SecurityEvent(uc.logger, "passkey_login_failed", "userID", credential.UserID(), "err", "user handle mismatch")
+		return CompletePasskeyLoginOutput{}, ErrInvalidPasskey
+	}
+	assertion, err := uc.relyingParty.VerifyAssertion(input.clientDataJSON, input.authenticatorData, input.signature, credential.PublicKey(), issued.Challenge())
+	if err != nil {
+		mylogger.SecurityEvent(uc.logger, "passkey_login_failed", "userID", credential.UserID(), "err", err)
+		return CompletePasskeyLoginOutput{}, ErrInvalidPasskey
+	}
+	// 所持の確認のみ(PINなしのセキュリティキーへのタッチなど)では2段階認証の代わりにならないため拒否する WebAuthn Level 2 7.2 step 15
+	if !assertion.UserVerified {
+		mylogger.SecurityEvent(uc.logger, "passkey_login_failed", "userID", credential.UserID(), "err", "user not verified")
+		return CompletePasskeyLoginOutput{}, ErrInvalidPasskey
+	}
+	used, err := credential.Use(assertion.SignCount, now)
+	if err != nil {
+		// 認証器が複製された可能性があるため、ログインさせずに記録する
+		mylogger.SecurityEvent(uc.logger, "passkey_sign_count_regressed", "userID", credential.UserID(), "stored", credential.SignCount(), "received", assertion.SignCount)
+		return CompletePasskeyLoginOutput{}, ErrInvalidPasskey
+	}
+	// 設定から削除されたユーザーのパスキーではログインできない
+	user, err := uc.userRepository.FindByLoginID(credential.LoginID())
+	if err != nil || user.UserID() != credential.UserID() {
+		if err != nil && !errors.Is(err, infrastructure.ErrUserNotFound) {
+			uc.logger.Error("Failed to find user", "err", err)
+			return CompletePasskeyLoginOutput{}, ErrUnexpectedStorageError
+		}
+		mylogger.SecurityEvent(uc.logger, "passkey_login_failed", "userID", credential.UserID(), "err", "user not found")
+		return CompletePasskeyLoginOutput{}, ErrInvalidPasskey
+	}
+	if err := uc.passkeyRepository.Save(used); err != nil {
+		uc.logger.Error("Failed to save passkey", "err", err)
+		return CompletePasskeyLoginOutput{}, ErrUnexpectedStorageError
+	}
+
+	// セッション固定攻撃対策としてセッションIDを再生成し、処理中のトランザクションを新しいセッションに紐づけ直す
+	newSessionID := uc.sessionIDGenerator.Generate()
+	if err := uc.sessionStore.Save(newSessionID, inf_dto.NewSessionData(user, now, domain.PasskeyAssertionFlags{
+		UserPresent:    assertion.UserPresent,
+		UserVerified:   assertion.UserVerified,
+		BackupEligible: assertion.BackupEligible,
+	}.AMR())); err != nil {
+		uc.logger.Error("Failed to save regenerated session", "err", err)
+		return CompletePasskeyLoginOutput{}, ErrUnexpectedStorageError
+	}
+	uc.sessionStore.Delete(input.sessionID)
+	if err := uc.transactionStore.Save(transaction.BindTo(newSessionID)); err != nil {
+		uc.logger.Error("Failed to rebind authorization transaction", "err", err)
+		return CompletePasskeyLoginOutput{}, ErrUnexpectedStorageError
+	}
+
+	uc.logger.Info("Logged in with passkey", "userID", user.UserID())
+	return NewCompletePasskeyLoginOutput(newSessionID, transaction.ID()), nil
+}
@@ -0,0 +1,282 @@
+package passkey
+
+import (
+	"errors"
+	"oauth-tutorial/internal/domain"
+	"oauth-tutorial/internal/infrastructure"
+	inf_dto "oauth-tutorial/internal/infrastructure/dto"
+	"oauth-tutorial/internal/infrastructure/webauthn"
+	"oauth-tutorial/internal/infrastructure/webauthn/webauthntest"
+	"oauth-tutorial/internal/session"
+	"oauth-tutorial/pkg/mylogger"
+	"slices"
+	"testing"
+	"time"
+)
+
+const (
+	testRPID          = "auth.example.com"
+	testOrigin        = "https://auth.example.com"
+	testSessionID     = session.SessionID("test-session-id")
+	testTransactionID = session.TransactionID("test-transaction-id")
+)
+
+// 呼び出し毎に異なる値を返す
+type sequenceRandomGenerator struct {
+	n byte
+}
+
+func (g *sequenceRandomGenerator) GenerateRandomBytes(n int) []byte {
+	g.n++
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = g.n
+	}
+	return b
+}
+
+type fixedSessionIDGenerator struct{}
+
+func (fixedSessionIDGenerator) Generate() session.SessionID {
+	return "renewed-session-id"
+}
+
+type passkeyTestEnv struct {
+	relyingParty  *webauthn.RelyingParty
+	authenticator *webauthntest.Authenticator
+	transactions  *infrastructure.TransactionStorage
+	challenges    *infrastructure.PasskeyChallengeStorage
+	passkeys      *infrastructure.PasskeyRepository
+	users         *infrastructure.UserRepository
+	sessions      *infrastructure.SessionStorage
+	begin         *BeginPasskeyLoginUseCase
+	complete      *CompletePasskeyLoginUseCase
+}
+
+// user-1のパスキーを登録した状態で、ログイン画面を表示中のセッションを用意する
+func newPasskeyTestEnv(t *testing.T, backupEligible bool) *passkeyTestEnv {
+	t.Helper()
+	logger := mylogger.NewMockLogger()
+	env := &passkeyTestEnv{
+		relyingParty:  webauthn.NewRelyingParty(testRPID, testRPID, testOrigin),
+		authenticator: webauthntest.NewAuthenticator(t, testRPID, testOrigin),
+		transactions:  infrastructure.NewTransactionStorage(),
+		challenges:    infrastructure.NewPasskeyChallengeStorage(),
+		passkeys:      infrastructure.NewPasskeyRepository(),
+		users:         infrastructure.NewUserRepositoryWithUsers([]*domain.User{domain.ReconstructUser("user-1", "alice@example.com", "")}),
+		sessions:      infrastructure.NewSessionStorage(0, 0),
+	}
+	env.authenticator.BackupEligible = backupEligible
+	rg := &sequenceRandomGenerator{}
+	env.begin = NewBeginPasskeyLoginUseCase(logger, env.transactions, env.challenges, rg, env.relyingParty)
+	env.complete = NewCompletePasskeyLoginUseCase(logger, env.challenges, env.transactions, env.passkeys, env.users, env.sessions, fixedSessionIDGenerator{}, env.relyingParty)
+
+	attestation := env.authenticator.Create("cmVnaXN0cmF0aW9u", []byte("user-1"))
+	registration, err := env.relyingParty.VerifyRegistration(attestation.ClientDataJSON, attestation.AttestationObject, "cmVnaXN0cmF0aW9u")
+	if err != nil {
+		t.Fatalf("VerifyRegistration() error = %v", err)
+	}
+	_ = env.passkeys.Save(domain.NewPasskeyCredential(registration.CredentialID, "user-1", "alice@example.com", registration.PublicKey, registration.SignCount, registration.BackupEligible, "ノートPC", time.Now()))
+
+	param, err := domain.NewAuthorizationCodeFlowParam(logger, domain.DefaultScopeRegistry(), "code", "test-client", "https://client.example.com/callback", "read", "xyz")
+	if err != nil {
+		t.Fatalf("NewAuthorizationCodeFlowParam() error = %v", err)
+	}
+	_ = env.transactions.Save(inf_dto.NewAuthorizationTransaction(testTransactionID, testSessionID, param, time.Now()))
+	_ = env.sessions.Save(testSessionID, inf_dto.NewSessionData(nil, time.Time{}, nil))
+	return env
+}
+
+// チャレンジを発行し、認証器で署名した結果を返す
+func (env *passkeyTestEnv) assert(t *testing.T) webauthntest.Assertion {
+	t.Helper()
+	options, err := env.begin.Execute(testSessionID, testTransactionID)
+	if err != nil {
+		t.Fatalf("Begin Execute() error = %v", err)
+	}
+	return env.authenticator.Get(options.Challenge())
+}
+
+func (env *passkeyTestEnv) completeInput(t *testing.T, sessionID session.SessionID, assertion webauthntest.Assertion) *CompletePasskeyLoginInput {
+	t.Helper()
+	input, err := NewCompletePasskeyLoginInput(sessionID, assertion.CredentialID, assertion.ClientDataJSON, assertion.AuthenticatorData, assertion.Signature, assertion.UserHandle)
+	if err != nil {
+		t.Fatalf("NewCompletePasskeyLoginInput() error = %v", err)
+	}
+	return input
+}
+
+func Test_パスキーでのログインの開始(t *testing.T) {
+	tests := []struct {
+		name          string
+		sessionID     session.SessionID
+		transactionID session.TransactionID
+		expectedErr   error
+	}{
+		{name: "正常系", sessionID: testSessionID, transactionID: testTransactionID},
+		{name: "異常系 - 存在しないトランザクション", sessionID: testSessionID, transactionID: "unknown", expectedErr: ErrTransactionNotFound},
+		{name: "異常系 - 別のセッションのトランザクション", sessionID: "other-session-id", transactionID: testTransactionID, expectedErr: ErrTransactionNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// given
+			env := newPasskeyTestEnv(t, false)
+
+			// when
+			options, err := env.begin.Execute(tt.sessionID, tt.transactionID)
+
+			// then
+			if !errors.Is(err, tt.expectedErr) {
+				t.Fatalf("Execute() error = %v, want %v", err, tt.expectedErr)
+			}
+			if tt.expectedErr != nil {
+				return
+			}
+			if options.RPID() != testRPID || len(options.Challenge()) != 43 {
+				t.Errorf("Execute() = %+v", options)
+			}
+		})
+	}
+}
+
+func Test_パスキーでのログイン(t *testing.T) {
+	tests := []struct {
+		name           string
+		backupEligible bool
+		expectedAMR    []string
+	}{
+		// 端末に紐づくパスキーはハードウェアの鍵として扱う
+		{name: "正常系 - 端末に紐づくパスキー", expectedAMR: []string{domain.AMRHardwareKey, domain.AMRUserPresence}},
+		{name: "正常系 - 同期されるパスキー", backupEligible: true, expectedAMR: []string{domain.AMRUserPresence}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// given
+			env := newPasskeyTestEnv(t, tt.backupEligible)
+			input := env.completeInput(t, testSessionID, env.assert(t))
+
+			// when
+			output, err := env.complete.Execute(input)
+
+			// then
+			if err != nil {
+				t.Fatalf("Execute() error = %v", err)
+			}
+			if output.SessionID() != "renewed-session-id" || output.TransactionID() != testTransactionID {
+				t.Errorf("Execute() = %+v", output)
+			}
+			sessionData, err := env.sessions.Get("renewed-session-id")
+			if err != nil || sessionData.User().UserID() != "user-1" || !slices.Equal(sessionData.AMR(), tt.expectedAMR) {
+				t.Errorf("session = %+v, %v, want amr %v", sessionData, err, tt.expectedAMR)
+			}
+			if _, err := env.sessions.Get(testSessionID); err == nil {
+				t.Error("old session was not deleted")
+			}
+			transaction, _ := env.transactions.Get(testTransactionID)
+			if transaction.SessionID() != "renewed-session-id" {
+				t.Errorf("transaction session = %q", transaction.SessionID())
+			}
+			credential, _ := env.passkeys.FindByCredentialID(env.authenticator.CredentialID())
+			if credential.SignCount() != 1 || credential.LastUsedAt().IsZero() {
+				t.Errorf("credential = %+v", credential)
+			}
+		})
+	}
+}
+
+func Test_パスキーでのログインの失敗(t *testing.T) {
+	tests := []struct {
+		name      string
+		sessionID session.SessionID
+		// 発行したチャレンジの代わりに署名するチャレンジ
+		challenge string
+		// 認証器を書き換えて不正な署名を生成する
+		modify func(env *passkeyTestEnv)
+		tamper func(assertion *webauthntest.Assertion)
+		// 同じ署名を2回送信する
+		replay      bool
+		expectedErr error
+	}{
+		{name: "異常系 - 別のセッションからの送信", sessionID: "other-session-id", expectedErr: ErrChallengeNotFound},
+		{name: "異常系 - 同じチャレンジでの2回目のログイン", sessionID: testSessionID, replay: true, expectedErr: ErrChallengeNotFound},
+		{name: "異常系 - 発行していないチャレンジ", sessionID: testSessionID, challenge: "dW5rbm93bg", expectedErr: ErrChallengeNotFound},
+		{
+			name: "異常系 - 登録されていないクレデンシャル", sessionID: testSessionID,
+			tamper:      func(assertion *webauthntest.Assertion) { assertion.CredentialID = []byte("unknown") },
+			expectedErr: ErrInvalidPasskey,
+		},
+		{
+			name: "異常系 - 別のユーザーのユーザーハンドル", sessionID: testSessionID,
+			tamper:      func(assertion *webauthntest.Assertion) { assertion.UserHandle = []byte("user-2") },
+			expectedErr: ErrInvalidPasskey,
+		},
+		{
+			name: "異常系 - 署名の改ざん", sessionID: testSessionID,
+			tamper:      func(assertion *webauthntest.Assertion) { assertion.Signature[len(assertion.Signature)-1]++ },
+			expectedErr: ErrInvalidPasskey,
+		},
+		{
+			name: "異常系 - オリジンが異なる", sessionID: testSessionID,
+			modify:      func(env *passkeyTestEnv) { env.authenticator.Origin = "https://evil.example.com" },
+			expectedErr: ErrInvalidPasskey,
+		},
+		{
+			// 所持の確認のみでは2段階認証の代わりにならない
+			name: "異常系 - 本人の確認をしていない", sessionID: testSessionID,
+			modify:      func(env *passkeyTestEnv) { env.authenticator.SkipUserVerification = true },
+			expectedErr: ErrInvalidPasskey,
+		},
+		{
+			// 複製された認証器の可能性がある
+			name: "異常系 - 署名カウンターが増えていない", sessionID: testSessionID,
+			modify: func(env *passkeyTestEnv) {
+				credential, _ := env.passkeys.FindByCredentialID(env.authenticator.CredentialID())
+				used, _ := credential.Use(5, time.Now())
+				_ = env.passkeys.Save(used)
+			},
+			expectedErr: ErrInvalidPasskey,
+		},
+		{
+			name: "異常系 - 削除されたユーザー", sessionID: testSessionID,
+			modify: func(env *passkeyTestEnv) {
+				env.complete.userRepository = infrastructure.NewUserRepositoryWithUsers(nil)
+			},
+			expectedErr: ErrInvalidPasskey,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// given
+			env := newPasskeyTestEnv(t, false)
+			if tt.modify != nil {
+				tt.modify(env)
+			}
+			assertion := env.assert(t)
+			if tt.challenge != "" {
+				assertion = env.authenticator.Get(tt.challenge)
+			}
+			if tt.tamper != nil {
+				tt.tamper(&assertion)
+			}
+			if tt.replay {
+				if _, err := env.complete.Execute(env.completeInput(t, testSessionID, assertion)); err != nil {
+					t.Fatalf("Execute() error = %v", err)
+				}
+			}
+
+			// when
+			_, err := env.complete.Execute(env.completeInput(t, tt.sessionID, assertion))
+
+			// then
+			if !errors.Is(err, tt.expectedErr) {
+				t.Errorf("Execute() error = %v, want %v", err, tt.expectedErr)
+			}
+			if tt.replay {
+				return
+			}
+			if _, err := env.sessions.Get(testSessionID); err != nil {
+				t.Errorf("session was changed: %v", err)
+			}
+		})
+	}
+}
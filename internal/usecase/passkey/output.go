@@ -0,0 +1,39 @@
+package passkey
+
+import (
+	"oauth-tutorial/internal/session"
+	"time"
+)
+
+// ブラウザのnavigator.credentials.getに渡すログインのオプション WebAuthn Level 2 5.5
+// ログインIDを入力させずにログインするため、allowCredentialsは指定しない(発見可能なクレデンシャル)
+type PasskeyLoginOptions struct {
+	// base64urlでエンコードしたチャレンジ
+	challenge string
+	rpID      string
+	// チャレンジの有効期間
+	timeout time.Duration
+}
+
+func NewPasskeyLoginOptions(challenge, rpID string, timeout time.Duration) PasskeyLoginOptions {
+	return PasskeyLoginOptions{challenge: challenge, rpID: rpID, timeout: timeout}
+}
+
+func (o PasskeyLoginOptions) Challenge() string      { return o.challenge }
+func (o PasskeyLoginOptions) RPID() string           { return o.rpID }
+func (o PasskeyLoginOptions) Timeout() time.Duration { return o.timeout }
+
+type CompletePasskeyLoginOutput struct {
+	// ログインによって再生成したセッションID
+	sessionID     session.SessionID
+	transactionID session.TransactionID
+}
+
+func NewCompletePasskeyLoginOutput(sessionID session.SessionID, transactionID session.TransactionID) CompletePasskeyLoginOutput {
+	return CompletePasskeyLoginOutput{sessionID: sessionID, transactionID: transactionID}
+}
+
+func (o CompletePasskeyLoginOutput) SessionID() session.SessionID { return o.sessionID }
+
+// ログインを続ける認可リクエストのトランザクション
+func (o CompletePasskeyLoginOutput) TransactionID() session.TransactionID { return o.transactionID }
@@ -1,19 +1,27 @@
 package authorizationcodeflow
 
+import "oauth-tutorial/internal/domain"
+
 // AuthorizationCodeInput は authorization_code フロー専用の入力
 type AuthorizationCodeInput struct {
 	clientID     string
 	clientSecret string
-	code         string
-	redirectURI  string
+	// トークンエンドポイントでクライアント認証に使われた方式
+	authMethod  domain.TokenEndpointAuthMethod
+	code        string
+	redirectURI string
+	// 認可されたスコープより狭いスコープを要求する場合に指定する。空の場合は認可された全てのスコープ
+	scopes []string
 }
 
-func NewAuthorizationCodeInput(clientID, clientSecret, code, redirectURI string) AuthorizationCodeInput {
+func NewAuthorizationCodeInput(clientID, clientSecret string, authMethod domain.TokenEndpointAuthMethod, code, redirectURI string, scopes []string) AuthorizationCodeInput {
 	return AuthorizationCodeInput{
 		clientID:     clientID,
 		clientSecret: clientSecret,
+		authMethod:   authMethod,
 		code:         code,
 		redirectURI:  redirectURI,
+		scopes:       scopes,
 	}
 }
 
@@ -23,9 +31,15 @@ func (i AuthorizationCodeInput) ClientID() string {
 func (i AuthorizationCodeInput) ClientSecret() string {
 	return i.clientSecret
 }
+func (i AuthorizationCodeInput) AuthMethod() domain.TokenEndpointAuthMethod {
+	return i.authMethod
+}
 func (i AuthorizationCodeInput) Code() string {
 	return i.code
 }
 func (i AuthorizationCodeInput) RedirectURI() string {
 	return i.redirectURI
 }
+func (i AuthorizationCodeInput) Scopes() []string {
+	return i.scopes
+}
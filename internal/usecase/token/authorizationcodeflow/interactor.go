@@ -2,7 +2,9 @@ package authorizationcodeflow
 
 import (
 	"errors"
+	"fmt"
 	"oauth-tutorial/internal/domain"
+	"oauth-tutorial/internal/infrastructure"
 	tokenport "oauth-tutorial/internal/usecase/token/port"
 	"oauth-tutorial/pkg/mylogger"
 	"time"
@@ -13,9 +15,13 @@ var (
 	ErrClientNotFound            = errors.New("client not found")
 	ErrInvalidClientCredential   = errors.New("invalid client credentials")
 	ErrAuthorizationCodeNotFound = errors.New("authorization code not found")
+	ErrAuthorizationCodeReused   = errors.New("authorization code reused")
 	ErrInvalidClientID           = errors.New("invalid client ID")
 	ErrInvalidRedirectURI        = errors.New("invalid redirect URI")
 	ErrAuthorizationCodeExpired  = errors.New("authorization code expired")
+	ErrInvalidScope              = errors.New("invalid scope")
+	ErrUnauthorizedClient        = errors.New("client is not allowed to use the grant type")
+	ErrUnexpected                = errors.New("unexpected error occurred")
 )
 
 type AuthorizationCodeFlow struct {
@@ -23,14 +29,22 @@ type AuthorizationCodeFlow struct {
 	cr     tokenport.IClientRepository
 	ar     tokenport.IAuthorizationCodeRepository
 	tr     tokenport.ITokenRepository
+	csr    tokenport.IConsentRepository
+	// 発行するトークンの有効期間
+	lifetimes domain.Lifetimes
+	// レルムのスコープの一覧
+	scopes *domain.ScopeProvider
 }
 
-func NewAuthorizationCodeFlow(logger mylogger.Logger, cr tokenport.IClientRepository, ar tokenport.IAuthorizationCodeRepository, tr tokenport.ITokenRepository) *AuthorizationCodeFlow {
+func NewAuthorizationCodeFlow(logger mylogger.Logger, cr tokenport.IClientRepository, ar tokenport.IAuthorizationCodeRepository, tr tokenport.ITokenRepository, csr tokenport.IConsentRepository, lifetimes domain.Lifetimes, scopes *domain.ScopeProvider) *AuthorizationCodeFlow {
 	return &AuthorizationCodeFlow{
-		logger: logger,
-		cr:     cr,
-		ar:     ar,
-		tr:     tr,
+		logger:    logger,
+		cr:        cr,
+		ar:        ar,
+		tr:        tr,
+		csr:       csr,
+		lifetimes: lifetimes,
+		scopes:    scopes,
 	}
 }
 
@@ -51,6 +65,12 @@ func (i *AuthorizationCodeFlow) Execute(input any) (*domain.AccessToken, *domain
 		return nil, nil, ErrClientNotFound
 	}
 
+	// クライアントに登録された方式以外でのクライアント認証は認めない
+	if !client.AllowsAuthMethod(ai.AuthMethod()) {
+		i.logger.Info("登録されていない方式でclient認証されました。", "client_id", ai.ClientID(), "auth_method", ai.AuthMethod())
+		return nil, nil, ErrInvalidClientCredential
+	}
+
 	// コンフィデンシャルクライアントはClient認証
 	// TODO: ブルートフォース攻撃対策
 	if client.ClientType() == domain.ConfidentialClient {
@@ -60,10 +80,26 @@ func (i *AuthorizationCodeFlow) Execute(input any) (*domain.AccessToken, *domain
 		}
 	}
 
-	// 認可コード情報取得
-	authCode, err := i.ar.FindByCode(ai.Code())
+	if !client.AllowsGrantType(domain.GrantTypeAuthorizationCode) {
+		i.logger.Info("clientに許可されていないgrant_typeです。", "client_id", ai.ClientID())
+		return nil, nil, ErrUnauthorizedClient
+	}
+
+	// 認可コードを使用済みにして取得する
+	// 同じ認可コードで並行してリクエストされても、取得できるのは1回のみ。検証に失敗した場合も認可コードは使用済みになる
+	grantID := domain.GrantIDFromCode(ai.Code())
+	authCode, err := i.ar.Consume(ai.Code())
+	if errors.Is(err, infrastructure.ErrAuthorizationCodeAlreadyUsed) {
+		// 認可コードが漏洩した可能性があるため、RFC 6749 4.1.2に従い同じ認可コードから発行したトークンを全て無効にする
+		revoked, err := i.tr.RevokeByGrantID(grantID)
+		if err != nil {
+			i.logger.Error("再利用された認可コードから発行したトークンの無効化に失敗しました。", "err", err, "client_id", ai.ClientID())
+		}
+		mylogger.SecurityEvent(i.logger, "authorization_code_reused", "client_id", ai.ClientID(), "grant_id", grantID, "revokedTokens", revoked)
+		return nil, nil, ErrAuthorizationCodeReused
+	}
 	if err != nil {
-		i.logger.Info("codeに該当する認可コードが存在しません。", "err", err, "code", ai.Code())
+		i.logger.Info("codeに該当する認可コードが存在しません。", "err", err)
 		return nil, nil, ErrAuthorizationCodeNotFound
 	}
 
@@ -73,21 +109,56 @@ func (i *AuthorizationCodeFlow) Execute(input any) (*domain.AccessToken, *domain
 		return nil, nil, err
 	}
 
+	// scopeが指定された場合は、認可されたスコープの範囲内でアクセストークンのスコープを絞り込む
+	registry := i.scopes.Current()
+	scopes, err := domain.NarrowScopes(registry, authCode.Scopes(), ai.Scopes())
+	if err != nil {
+		i.logger.Info("認可されていないscopeが要求されました。", "input.scope", ai.Scopes(), "authCode.scope", authCode.Scopes())
+		return nil, nil, fmt.Errorf("%w: %w", ErrInvalidScope, err)
+	}
+	// 認可後にクライアントの設定が変更され、許可されなくなったスコープは発行しない
+	if err := client.CheckScopes(registry, scopes); err != nil {
+		i.logger.Info("clientに許可されていないscopeが要求されました。", "client_id", ai.ClientID(), "scope", scopes, "err", err)
+		return nil, nil, fmt.Errorf("%w: %w", ErrInvalidScope, err)
+	}
+
 	// Token発行
-	token := domain.NewAccessToken(ai.ClientID(), authCode.UserID(), authCode.Scopes(), now)
+	lifetimes := client.Lifetimes(i.lifetimes)
+	token := domain.NewAccessToken(ai.ClientID(), authCode.UserID(), scopes, now, lifetimes.AccessToken).IssuedFrom(grantID)
 	// Token登録
-	i.tr.Save(token)
+	if err := i.tr.Save(token); err != nil {
+		i.logger.Error("アクセストークンの登録に失敗しました。", "err", err)
+		return nil, nil, ErrUnexpected
+	}
 
-	// RefreshToken発行・登録
-	refreshToken := domain.NewRefreshToken(ai.ClientID(), now)
-	i.tr.SaveRefreshToken(refreshToken, token)
+	// RefreshToken発行・登録(後から絞り込む前のスコープのアクセストークンを取得できるよう、認可された全てのスコープを保持する)
+	// リフレッシュトークンを発行しないクライアントの場合はアクセストークンのみ返す
+	var refreshToken *domain.RefreshToken
+	if client.IssuesRefreshToken() {
+		refreshToken = domain.NewRefreshToken(ai.ClientID(), authCode.UserID(), authCode.Scopes(), now, lifetimes.RefreshToken).IssuedFrom(grantID)
+		if err := i.tr.SaveRefreshToken(refreshToken, token); err != nil {
+			i.logger.Error("リフレッシュトークンの登録に失敗しました。", "err", err)
+			return nil, nil, ErrUnexpected
+		}
+	}
 
-	// 認可コード削除
-	i.ar.Delete(authCode.Value())
+	// アカウント画面で表示するため、クライアントが同意に基づいてトークンを取得したことを記録する
+	i.recordConsentUse(authCode.UserID(), ai.ClientID(), now)
 
 	return token, refreshToken, nil
 }
 
+func (i *AuthorizationCodeFlow) recordConsentUse(userID string, clientID string, now time.Time) {
+	consent, err := i.csr.FindByUserAndClient(userID, clientID)
+	if err != nil {
+		i.logger.Info("トークン発行に対応する同意が存在しません。", "err", err, "client_id", clientID)
+		return
+	}
+	if err := i.csr.Save(consent.RecordUse(now)); err != nil {
+		i.logger.Error("同意の使用記録の保存に失敗しました。", "err", err, "client_id", clientID)
+	}
+}
+
 func (*AuthorizationCodeFlow) isExchangeable(authCode *domain.AuthorizationCode, ai AuthorizationCodeInput, now time.Time, logger mylogger.Logger) error {
 	if authCode.IsExpired(now) {
 		logger.Info("認可コードの有効期限が切れています。", "input.client_id", ai.ClientID(), "authCode.client_id", authCode.ClientID())
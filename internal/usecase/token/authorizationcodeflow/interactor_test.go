@@ -0,0 +1,378 @@
+package authorizationcodeflow
+
+import (
+	"errors"
+	"oauth-tutorial/internal/domain"
+	"oauth-tutorial/internal/infrastructure"
+	"oauth-tutorial/pkg/mylogger"
+	"slices"
+	"testing"
+	"time"
+)
+
+type mockClientRepository struct {
+	client *domain.Client
+}
+
+func (m *mockClientRepository) FindByID(clientID string) (*domain.Client, error) {
+	if m.client == nil || string(m.client.ClientID()) != clientID {
+		return nil, errors.New("not found")
+	}
+	return m.client, nil
+}
+
+type mockTokenRepository struct {
+	accessTokens  []*domain.AccessToken
+	refreshTokens []*domain.RefreshToken
+	revokeErr     error
+}
+
+func (m *mockTokenRepository) Save(token *domain.AccessToken) error {
+	m.accessTokens = append(m.accessTokens, token)
+	return nil
+}
+
+func (m *mockTokenRepository) SaveRefreshToken(token *domain.RefreshToken, accessToken *domain.AccessToken) error {
+	m.refreshTokens = append(m.refreshTokens, token)
+	return nil
+}
+
+func (m *mockTokenRepository) FindByAccessToken(token string) (*domain.AccessToken, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (m *mockTokenRepository) FindByRefreshToken(token string) (*domain.RefreshToken, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (m *mockTokenRepository) ConsumeRefreshToken(token string) (*domain.RefreshToken, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (m *mockTokenRepository) RevokeByGrantID(grantID string) (int, error) {
+	if m.revokeErr != nil {
+		return 0, m.revokeErr
+	}
+	revoked := 0
+	m.accessTokens = slices.DeleteFunc(m.accessTokens, func(t *domain.AccessToken) bool {
+		if t.GrantID() == grantID {
+			revoked++
+			return true
+		}
+		return false
+	})
+	m.refreshTokens = slices.DeleteFunc(m.refreshTokens, func(t *domain.RefreshToken) bool {
+		if t.GrantID() == grantID {
+			revoked++
+			return true
+		}
+		return false
+	})
+	return revoked, nil
+}
+
+type mockAuthorizationCodeRepository struct {
+	codes map[string]*domain.AuthorizationCode
+	used  map[string]bool
+}
+
+func (m *mockAuthorizationCodeRepository) Consume(code string) (*domain.AuthorizationCode, error) {
+	if m.used[code] {
+		return nil, infrastructure.ErrAuthorizationCodeAlreadyUsed
+	}
+	authCode, ok := m.codes[code]
+	if !ok {
+		return nil, infrastructure.ErrAuthorizationCodeNotFound
+	}
+	delete(m.codes, code)
+	m.used[code] = true
+	return authCode, nil
+}
+
+// セキュリティイベントの記録を検証するためのロガー
+type recordingLogger struct {
+	mylogger.MockLogger
+	warnings [][]any
+}
+
+func (l *recordingLogger) Warn(msg string, args ...any) {
+	l.warnings = append(l.warnings, append([]any{msg}, args...))
+}
+
+func (l *recordingLogger) hasSecurityEvent(event string) bool {
+	for _, w := range l.warnings {
+		if slices.Contains(w, any("security event")) && slices.Contains(w, any(event)) {
+			return true
+		}
+	}
+	return false
+}
+
+type fixedCodeGenerator struct{}
+
+func (g *fixedCodeGenerator) GenerateURLSafeRandomString(n int) string {
+	return "test-code"
+}
+
+type mockConsentRepository struct {
+	consents map[string]*domain.Consent
+}
+
+func (m *mockConsentRepository) FindByUserAndClient(userID string, clientID string) (*domain.Consent, error) {
+	consent, ok := m.consents[userID+":"+clientID]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	return consent, nil
+}
+
+func (m *mockConsentRepository) Save(consent *domain.Consent) error {
+	m.consents[consent.UserID()+":"+consent.ClientID()] = consent
+	return nil
+}
+
+func Test_認可コードによるToken発行(t *testing.T) {
+	logger := mylogger.NewMockLogger()
+	client := domain.ReconstructClient("client-1", "テストクライアント", domain.ConfidentialClient, "secret", []string{"https://example.com/callback"})
+
+	tests := []struct {
+		name           string
+		input          AuthorizationCodeInput
+		issuedAt       time.Time
+		expectedScopes []string
+		expectedErr    error
+	}{
+		{
+			name:           "正常系 - 認可された全てのスコープで発行する",
+			input:          NewAuthorizationCodeInput("client-1", "secret", domain.AuthMethodClientSecretBasic, "test-code", "https://example.com/callback", nil),
+			issuedAt:       time.Now(),
+			expectedScopes: []string{"read", "write"},
+		},
+		{
+			name:           "正常系 - 要求されたスコープに絞り込んで発行する",
+			input:          NewAuthorizationCodeInput("client-1", "secret", domain.AuthMethodClientSecretBasic, "test-code", "https://example.com/callback", []string{"read"}),
+			issuedAt:       time.Now(),
+			expectedScopes: []string{"read"},
+		},
+		{
+			name:        "異常系 - 認可されていないスコープを要求",
+			input:       NewAuthorizationCodeInput("client-1", "secret", domain.AuthMethodClientSecretBasic, "test-code", "https://example.com/callback", []string{"read", "admin"}),
+			issuedAt:    time.Now(),
+			expectedErr: ErrInvalidScope,
+		},
+		{
+			name:        "異常系 - クライアント認証に失敗",
+			input:       NewAuthorizationCodeInput("client-1", "wrong-secret", domain.AuthMethodClientSecretBasic, "test-code", "https://example.com/callback", nil),
+			issuedAt:    time.Now(),
+			expectedErr: ErrInvalidClientCredential,
+		},
+		{
+			name:        "異常系 - 認可コードが存在しない",
+			input:       NewAuthorizationCodeInput("client-1", "secret", domain.AuthMethodClientSecretBasic, "unknown-code", "https://example.com/callback", nil),
+			issuedAt:    time.Now(),
+			expectedErr: ErrAuthorizationCodeNotFound,
+		},
+		{
+			name:        "異常系 - redirect_uriが一致しない",
+			input:       NewAuthorizationCodeInput("client-1", "secret", domain.AuthMethodClientSecretBasic, "test-code", "https://example.com/other", nil),
+			issuedAt:    time.Now(),
+			expectedErr: ErrInvalidRedirectURI,
+		},
+		{
+			name:        "異常系 - 認可コードの有効期限切れ",
+			input:       NewAuthorizationCodeInput("client-1", "secret", domain.AuthMethodClientSecretBasic, "test-code", "https://example.com/callback", nil),
+			issuedAt:    time.Now().Add(-time.Hour),
+			expectedErr: ErrAuthorizationCodeExpired,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// given
+			authCode := domain.NewAuthorizationCode(&fixedCodeGenerator{}, "user-1", "client-1", []string{"read", "write"}, "https://example.com/callback", tt.issuedAt, domain.AUTHORIZATION_CODE_DURATION)
+			ar := &mockAuthorizationCodeRepository{codes: map[string]*domain.AuthorizationCode{authCode.Value(): authCode}, used: map[string]bool{}}
+			tr := &mockTokenRepository{}
+			csr := &mockConsentRepository{consents: map[string]*domain.Consent{}}
+			csr.Save(domain.NewConsent("user-1", "client-1", []string{"read", "write"}, time.Now(), 0))
+			flow := NewAuthorizationCodeFlow(logger, &mockClientRepository{client: client}, ar, tr, csr, domain.DefaultLifetimes(), domain.NewScopeProvider(nil))
+
+			// when
+			accessToken, refreshToken, err := flow.Execute(tt.input)
+
+			// then
+			if tt.expectedErr != nil {
+				if !errors.Is(err, tt.expectedErr) {
+					t.Fatalf("Execute() error = %v, want %v", err, tt.expectedErr)
+				}
+				if len(tr.accessTokens) != 0 {
+					t.Errorf("saved access tokens = %d, want 0", len(tr.accessTokens))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Execute() error = %v", err)
+			}
+			if !slices.Equal(accessToken.Scopes(), tt.expectedScopes) {
+				t.Errorf("AccessToken Scopes() = %v, want %v", accessToken.Scopes(), tt.expectedScopes)
+			}
+			// リフレッシュトークンは絞り込む前の認可されたスコープを保持する
+			if !slices.Equal(refreshToken.Scopes(), authCode.Scopes()) {
+				t.Errorf("RefreshToken Scopes() = %v, want %v", refreshToken.Scopes(), authCode.Scopes())
+			}
+			if refreshToken.UserID() != "user-1" || refreshToken.ClientID() != "client-1" {
+				t.Errorf("RefreshToken = (%v, %v), want (user-1, client-1)", refreshToken.UserID(), refreshToken.ClientID())
+			}
+			consent, _ := csr.FindByUserAndClient("user-1", "client-1")
+			if consent.LastUsedAt().IsZero() {
+				t.Error("consent usage should be recorded")
+			}
+			if !ar.used[authCode.Value()] {
+				t.Error("authorization code should be consumed")
+			}
+			// 認可コードの再利用を検知した際に無効にできるよう、発行の元になった認可コードを記録する
+			if accessToken.GrantID() != domain.GrantIDFromCode(authCode.Value()) || refreshToken.GrantID() != accessToken.GrantID() {
+				t.Errorf("GrantID() = (%v, %v), want %v", accessToken.GrantID(), refreshToken.GrantID(), domain.GrantIDFromCode(authCode.Value()))
+			}
+		})
+	}
+}
+
+func Test_認可コードの再利用(t *testing.T) {
+	client := domain.ReconstructClient("client-1", "テストクライアント", domain.ConfidentialClient, "secret", []string{"https://example.com/callback"})
+	input := NewAuthorizationCodeInput("client-1", "secret", domain.AuthMethodClientSecretBasic, "test-code", "https://example.com/callback", nil)
+
+	tests := []struct {
+		name                   string
+		revokeErr              error
+		expectedRemainedTokens int
+	}{
+		{
+			name:                   "最初に発行したトークンを全て無効にする",
+			expectedRemainedTokens: 1,
+		},
+		{
+			name:                   "無効化に失敗してもリクエストは拒否する",
+			revokeErr:              errors.New("storage error"),
+			expectedRemainedTokens: 3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// given
+			logger := &recordingLogger{}
+			authCode := domain.NewAuthorizationCode(&fixedCodeGenerator{}, "user-1", "client-1", []string{"read", "write"}, "https://example.com/callback", time.Now(), domain.AUTHORIZATION_CODE_DURATION)
+			ar := &mockAuthorizationCodeRepository{codes: map[string]*domain.AuthorizationCode{authCode.Value(): authCode}, used: map[string]bool{}}
+			tr := &mockTokenRepository{}
+			// 別の認可から発行したトークンは残ること
+			tr.Save(domain.NewAccessToken("client-1", "user-1", []string{"read"}, time.Now(), domain.AccessTokenDuration).IssuedFrom("other-grant"))
+			csr := &mockConsentRepository{consents: map[string]*domain.Consent{}}
+			flow := NewAuthorizationCodeFlow(logger, &mockClientRepository{client: client}, ar, tr, csr, domain.DefaultLifetimes(), domain.NewScopeProvider(nil))
+			if _, _, err := flow.Execute(input); err != nil {
+				t.Fatalf("first Execute() error = %v", err)
+			}
+			tr.revokeErr = tt.revokeErr
+
+			// when
+			accessToken, refreshToken, err := flow.Execute(input)
+
+			// then
+			if !errors.Is(err, ErrAuthorizationCodeReused) {
+				t.Fatalf("Execute() error = %v, want %v", err, ErrAuthorizationCodeReused)
+			}
+			if accessToken != nil || refreshToken != nil {
+				t.Error("no token should be issued for a reused code")
+			}
+			if remained := len(tr.accessTokens) + len(tr.refreshTokens); remained != tt.expectedRemainedTokens {
+				t.Errorf("remained tokens = %d, want %d", remained, tt.expectedRemainedTokens)
+			}
+			if !logger.hasSecurityEvent("authorization_code_reused") {
+				t.Error("security event should be recorded")
+			}
+		})
+	}
+}
+
+func Test_クライアントのポリシーによるToken発行の制限(t *testing.T) {
+	logger := mylogger.NewMockLogger()
+	newClient := func(modify func(p *domain.ClientPolicy)) *domain.Client {
+		policy := domain.DefaultClientPolicy()
+		modify(&policy)
+		return domain.ReconstructClientWithPolicy("client-1", "テストクライアント", domain.ConfidentialClient, "secret", []string{"https://example.com/callback"}, policy)
+	}
+	input := NewAuthorizationCodeInput("client-1", "secret", domain.AuthMethodClientSecretBasic, "test-code", "https://example.com/callback", nil)
+
+	tests := []struct {
+		name              string
+		client            *domain.Client
+		input             AuthorizationCodeInput
+		expectedErr       error
+		expectedRefresh   bool
+		expectedAccessTTL time.Duration
+	}{
+		{
+			name:              "正常系 - クライアントの有効期間で発行する",
+			client:            newClient(func(p *domain.ClientPolicy) { p.Lifetimes.AccessToken = 5 * time.Minute }),
+			input:             input,
+			expectedRefresh:   true,
+			expectedAccessTTL: 5 * time.Minute,
+		},
+		{
+			name:              "正常系 - リフレッシュトークンを発行しない",
+			client:            newClient(func(p *domain.ClientPolicy) { p.IssueRefreshToken = false }),
+			input:             input,
+			expectedRefresh:   false,
+			expectedAccessTTL: domain.AccessTokenDuration,
+		},
+		{
+			name:        "異常系 - authorization_codeのgrant_typeを許可していない",
+			client:      newClient(func(p *domain.ClientPolicy) { p.GrantTypes = []domain.GrantType{domain.GrantTypeRefreshToken} }),
+			input:       input,
+			expectedErr: ErrUnauthorizedClient,
+		},
+		{
+			name:        "異常系 - 登録されていない方式でのクライアント認証",
+			client:      newClient(func(p *domain.ClientPolicy) { p.TokenEndpointAuthMethod = domain.AuthMethodClientSecretPost }),
+			input:       input,
+			expectedErr: ErrInvalidClientCredential,
+		},
+		{
+			name:        "異常系 - 認可後に許可されなくなったスコープ",
+			client:      newClient(func(p *domain.ClientPolicy) { p.Scopes = []string{"read"} }),
+			input:       input,
+			expectedErr: ErrInvalidScope,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// given
+			now := time.Now()
+			authCode := domain.NewAuthorizationCode(&fixedCodeGenerator{}, "user-1", "client-1", []string{"read", "write"}, "https://example.com/callback", now, domain.AUTHORIZATION_CODE_DURATION)
+			ar := &mockAuthorizationCodeRepository{codes: map[string]*domain.AuthorizationCode{authCode.Value(): authCode}, used: map[string]bool{}}
+			tr := &mockTokenRepository{}
+			csr := &mockConsentRepository{consents: map[string]*domain.Consent{}}
+			flow := NewAuthorizationCodeFlow(logger, &mockClientRepository{client: tt.client}, ar, tr, csr, domain.DefaultLifetimes(), domain.NewScopeProvider(nil))
+
+			// when
+			accessToken, refreshToken, err := flow.Execute(tt.input)
+
+			// then
+			if !errors.Is(err, tt.expectedErr) {
+				t.Fatalf("Execute() error = %v, want %v", err, tt.expectedErr)
+			}
+			if tt.expectedErr != nil {
+				if len(tr.accessTokens) != 0 {
+					t.Errorf("saved access tokens = %d, want 0", len(tr.accessTokens))
+				}
+				return
+			}
+			if (refreshToken != nil) != tt.expectedRefresh || (len(tr.refreshTokens) == 1) != tt.expectedRefresh {
+				t.Errorf("refresh token = %v, saved = %d, want issued = %v", refreshToken, len(tr.refreshTokens), tt.expectedRefresh)
+			}
+			if ttl := time.Duration(accessToken.ExpiresIn(now)) * time.Second; ttl != tt.expectedAccessTTL {
+				t.Errorf("ExpiresIn() = %v, want %v", ttl, tt.expectedAccessTTL)
+			}
+		})
+	}
+}
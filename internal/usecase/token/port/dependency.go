@@ -7,12 +7,23 @@ type IClientRepository interface {
 }
 
 type ITokenRepository interface {
-	Save(token *domain.AccessToken)
-	SaveRefreshToken(token *domain.RefreshToken, accessToken *domain.AccessToken)
+	Save(token *domain.AccessToken) error
+	SaveRefreshToken(token *domain.RefreshToken, accessToken *domain.AccessToken) error
 	FindByAccessToken(token string) (*domain.AccessToken, error)
+	FindByRefreshToken(token string) (*domain.RefreshToken, error)
+	// 取得と同時に削除し、同じリフレッシュトークンを並行して使用しても取得できるのは1回のみ
+	ConsumeRefreshToken(token string) (*domain.RefreshToken, error)
+	// 同じ認可から発行した全てのトークンを無効にし、無効にした数を返す
+	RevokeByGrantID(grantID string) (int, error)
 }
 
 type IAuthorizationCodeRepository interface {
-	FindByCode(code string) (*domain.AuthorizationCode, error)
-	Delete(code string)
+	// 取得と同時に使用済みにし、同じ認可コードを並行して使用しても取得できるのは1回のみ
+	// 使用済みの認可コードの場合はinfrastructure.ErrAuthorizationCodeAlreadyUsedを返す
+	Consume(code string) (*domain.AuthorizationCode, error)
+}
+
+type IConsentRepository interface {
+	FindByUserAndClient(userID string, clientID string) (*domain.Consent, error)
+	Save(consent *domain.Consent) error
 }
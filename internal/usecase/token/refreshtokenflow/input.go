@@ -1,19 +1,25 @@
 package refreshtokenflow
 
+import "oauth-tutorial/internal/domain"
+
 // refresh_token フロー用の入力
 type RefreshTokenInput struct {
 	clientID     string
 	clientSecret string
+	// トークンエンドポイントでクライアント認証に使われた方式
+	authMethod   domain.TokenEndpointAuthMethod
 	refreshToken string
-	redirectURI  string
+	// 元の認可より狭いスコープを要求する場合に指定する。空の場合は元の認可の全てのスコープ
+	scopes []string
 }
 
-func NewRefreshTokenInput(clientID, clientSecret, refreshToken, redirectURI string) RefreshTokenInput {
+func NewRefreshTokenInput(clientID, clientSecret string, authMethod domain.TokenEndpointAuthMethod, refreshToken string, scopes []string) RefreshTokenInput {
 	return RefreshTokenInput{
 		clientID:     clientID,
 		clientSecret: clientSecret,
+		authMethod:   authMethod,
 		refreshToken: refreshToken,
-		redirectURI:  redirectURI,
+		scopes:       scopes,
 	}
 }
 
@@ -23,9 +29,12 @@ func (i RefreshTokenInput) ClientID() string {
 func (i RefreshTokenInput) ClientSecret() string {
 	return i.clientSecret
 }
+func (i RefreshTokenInput) AuthMethod() domain.TokenEndpointAuthMethod {
+	return i.authMethod
+}
 func (i RefreshTokenInput) RefreshToken() string {
 	return i.refreshToken
 }
-func (i RefreshTokenInput) RedirectURI() string {
-	return i.redirectURI
+func (i RefreshTokenInput) Scopes() []string {
+	return i.scopes
 }
@@ -6,31 +6,144 @@ import (
 	"oauth-tutorial/internal/domain"
 	tokenport "oauth-tutorial/internal/usecase/token/port"
 	"oauth-tutorial/pkg/mylogger"
+	"time"
 )
 
-var ErrInvalidInputType = errors.New("invalid input type")
+var (
+	ErrInvalidInputType        = errors.New("invalid input type")
+	ErrClientNotFound          = errors.New("client not found")
+	ErrInvalidClientCredential = errors.New("invalid client credentials")
+	ErrRefreshTokenNotFound    = errors.New("refresh token not found")
+	ErrInvalidClientID         = errors.New("invalid client ID")
+	ErrRefreshTokenExpired     = errors.New("refresh token expired")
+	ErrInvalidScope            = errors.New("invalid scope")
+	ErrUnauthorizedClient      = errors.New("client is not allowed to use the grant type")
+	ErrUnexpected              = errors.New("unexpected error occurred")
+)
 
 type RefreshTokenFlow struct {
 	logger mylogger.Logger
 	cr     tokenport.IClientRepository
 	tr     tokenport.ITokenRepository
+	csr    tokenport.IConsentRepository
+	// 発行するトークンの有効期間
+	lifetimes domain.Lifetimes
+	// レルムのスコープの一覧
+	scopes *domain.ScopeProvider
 }
 
-func NewRefreshTokenFlow(logger mylogger.Logger, cr tokenport.IClientRepository, tr tokenport.ITokenRepository) *RefreshTokenFlow {
+func NewRefreshTokenFlow(logger mylogger.Logger, cr tokenport.IClientRepository, tr tokenport.ITokenRepository, csr tokenport.IConsentRepository, lifetimes domain.Lifetimes, scopes *domain.ScopeProvider) *RefreshTokenFlow {
 	return &RefreshTokenFlow{
-		logger: logger,
-		cr:     cr,
-		tr:     tr,
+		logger:    logger,
+		cr:        cr,
+		tr:        tr,
+		csr:       csr,
+		lifetimes: lifetimes,
+		scopes:    scopes,
 	}
 }
 
+// リフレッシュトークンを使ったToken再発行処理
 func (r *RefreshTokenFlow) Execute(input any) (*domain.AccessToken, *domain.RefreshToken, error) {
+	// TODO: 時刻のinjectの仕方考える
+	now := time.Now()
 	rti, ok := input.(RefreshTokenInput)
 	if !ok {
+		r.logger.Info("inputとinteractorの不整合です。", "input", input)
 		return nil, nil, ErrInvalidInputType
 	}
-	// unusedエラー防止
-	fmt.Print(rti)
-	// 実装予定
-	return nil, nil, nil
+
+	client, err := r.cr.FindByID(rti.ClientID())
+	if err != nil {
+		r.logger.Info("client_idに該当するClientが存在しません。", "err", err, "client_id", rti.ClientID())
+		return nil, nil, ErrClientNotFound
+	}
+
+	// クライアントに登録された方式以外でのクライアント認証は認めない
+	if !client.AllowsAuthMethod(rti.AuthMethod()) {
+		r.logger.Info("登録されていない方式でclient認証されました。", "client_id", rti.ClientID(), "auth_method", rti.AuthMethod())
+		return nil, nil, ErrInvalidClientCredential
+	}
+
+	// コンフィデンシャルクライアントはClient認証
+	if client.ClientType() == domain.ConfidentialClient {
+		if rti.ClientSecret() != client.Secret() {
+			r.logger.Info("client認証に失敗しました。", "client_id", rti.ClientID())
+			return nil, nil, ErrInvalidClientCredential
+		}
+	}
+
+	if !client.AllowsGrantType(domain.GrantTypeRefreshToken) {
+		r.logger.Info("clientに許可されていないgrant_typeです。", "client_id", rti.ClientID())
+		return nil, nil, ErrUnauthorizedClient
+	}
+
+	refreshToken, err := r.tr.FindByRefreshToken(rti.RefreshToken())
+	if err != nil {
+		r.logger.Info("refresh_tokenに該当するリフレッシュトークンが存在しません。", "err", err)
+		return nil, nil, ErrRefreshTokenNotFound
+	}
+	if refreshToken.ClientID() != rti.ClientID() {
+		r.logger.Info("リクエストのclient_idがリフレッシュトークンのclient_idと一致しません。", "input.client_id", rti.ClientID(), "refreshToken.client_id", refreshToken.ClientID())
+		return nil, nil, ErrInvalidClientID
+	}
+	if refreshToken.IsExpired(now) {
+		r.logger.Info("リフレッシュトークンの有効期限が切れています。", "client_id", rti.ClientID())
+		return nil, nil, ErrRefreshTokenExpired
+	}
+
+	// scopeが指定された場合は、元の認可のスコープの範囲内でアクセストークンのスコープを絞り込む
+	registry := r.scopes.Current()
+	scopes, err := domain.NarrowScopes(registry, refreshToken.Scopes(), rti.Scopes())
+	if err != nil {
+		r.logger.Info("認可されていないscopeが要求されました。", "input.scope", rti.Scopes(), "refreshToken.scope", refreshToken.Scopes())
+		return nil, nil, fmt.Errorf("%w: %w", ErrInvalidScope, err)
+	}
+	// 認可後にクライアントの設定が変更され、許可されなくなったスコープは発行しない
+	if err := client.CheckScopes(registry, scopes); err != nil {
+		r.logger.Info("clientに許可されていないscopeが要求されました。", "client_id", rti.ClientID(), "scope", scopes, "err", err)
+		return nil, nil, fmt.Errorf("%w: %w", ErrInvalidScope, err)
+	}
+
+	// リフレッシュトークンをローテーションするため、使用済みのリフレッシュトークンを無効にする
+	// 同じリフレッシュトークンで並行してリクエストされた場合、消費できなかったリクエストには発行しない
+	if _, err := r.tr.ConsumeRefreshToken(refreshToken.Value()); err != nil {
+		r.logger.Info("リフレッシュトークンは既に使用されています。", "err", err, "client_id", rti.ClientID())
+		return nil, nil, ErrRefreshTokenNotFound
+	}
+
+	// Token発行・登録
+	// 認可コードの再利用を検知した際に無効にできるよう、発行の元になった認可を引き継ぐ
+	lifetimes := client.Lifetimes(r.lifetimes)
+	token := domain.NewAccessToken(rti.ClientID(), refreshToken.UserID(), scopes, now, lifetimes.AccessToken).IssuedFrom(refreshToken.GrantID())
+	if err := r.tr.Save(token); err != nil {
+		r.logger.Error("アクセストークンの登録に失敗しました。", "err", err)
+		return nil, nil, ErrUnexpected
+	}
+
+	// リフレッシュトークンを発行しないよう設定が変更された場合は、アクセストークンのみ返す
+	var newRefreshToken *domain.RefreshToken
+	if client.IssuesRefreshToken() {
+		newRefreshToken = domain.NewRefreshToken(rti.ClientID(), refreshToken.UserID(), refreshToken.Scopes(), now, lifetimes.RefreshToken).IssuedFrom(refreshToken.GrantID())
+		if err := r.tr.SaveRefreshToken(newRefreshToken, token); err != nil {
+			r.logger.Error("リフレッシュトークンの登録に失敗しました。", "err", err)
+			return nil, nil, ErrUnexpected
+		}
+	}
+
+	// アカウント画面で表示するため、クライアントが同意に基づいてトークンを取得したことを記録する
+	r.recordConsentUse(refreshToken.UserID(), rti.ClientID(), now)
+
+	return token, newRefreshToken, nil
+}
+
+func (r *RefreshTokenFlow) recordConsentUse(userID string, clientID string, now time.Time) {
+	consent, err := r.csr.FindByUserAndClient(userID, clientID)
+	if err != nil {
+		r.logger.Info("トークン発行に対応する同意が存在しません。", "err", err, "client_id", clientID)
+		return
+	}
+	if err := r.csr.Save(consent.RecordUse(now)); err != nil {
+		r.logger.Error("同意の使用記録の保存に失敗しました。", "err", err, "client_id", clientID)
+	}
 }
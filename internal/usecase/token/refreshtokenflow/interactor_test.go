@@ -0,0 +1,274 @@
+package refreshtokenflow
+
+import (
+	"errors"
+	"oauth-tutorial/internal/domain"
+	"oauth-tutorial/pkg/mylogger"
+	"slices"
+	"testing"
+	"time"
+)
+
+type mockClientRepository struct {
+	client *domain.Client
+}
+
+func (m *mockClientRepository) FindByID(clientID string) (*domain.Client, error) {
+	if m.client == nil || string(m.client.ClientID()) != clientID {
+		return nil, errors.New("not found")
+	}
+	return m.client, nil
+}
+
+type mockTokenRepository struct {
+	accessTokens  []*domain.AccessToken
+	refreshTokens map[string]*domain.RefreshToken
+}
+
+func (m *mockTokenRepository) Save(token *domain.AccessToken) error {
+	m.accessTokens = append(m.accessTokens, token)
+	return nil
+}
+
+func (m *mockTokenRepository) SaveRefreshToken(token *domain.RefreshToken, accessToken *domain.AccessToken) error {
+	m.refreshTokens[token.Value()] = token
+	return nil
+}
+
+func (m *mockTokenRepository) FindByAccessToken(token string) (*domain.AccessToken, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (m *mockTokenRepository) FindByRefreshToken(token string) (*domain.RefreshToken, error) {
+	refreshToken, ok := m.refreshTokens[token]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	return refreshToken, nil
+}
+
+func (m *mockTokenRepository) RevokeByGrantID(grantID string) (int, error) {
+	return 0, errors.New("not implemented")
+}
+
+func (m *mockTokenRepository) ConsumeRefreshToken(token string) (*domain.RefreshToken, error) {
+	refreshToken, ok := m.refreshTokens[token]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	delete(m.refreshTokens, token)
+	return refreshToken, nil
+}
+
+type mockConsentRepository struct {
+	consents map[string]*domain.Consent
+}
+
+func (m *mockConsentRepository) FindByUserAndClient(userID string, clientID string) (*domain.Consent, error) {
+	consent, ok := m.consents[userID+":"+clientID]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	return consent, nil
+}
+
+func (m *mockConsentRepository) Save(consent *domain.Consent) error {
+	m.consents[consent.UserID()+":"+consent.ClientID()] = consent
+	return nil
+}
+
+func Test_リフレッシュトークンによるToken再発行(t *testing.T) {
+	logger := mylogger.NewMockLogger()
+	client := domain.ReconstructClient("client-1", "テストクライアント", domain.ConfidentialClient, "secret", []string{"https://example.com/callback"})
+	const refreshTokenValue = "test-refresh-token"
+
+	tests := []struct {
+		name           string
+		input          RefreshTokenInput
+		refreshToken   *domain.RefreshToken
+		expectedScopes []string
+		expectedErr    error
+	}{
+		{
+			name:           "正常系 - 元の認可の全てのスコープで再発行する",
+			input:          NewRefreshTokenInput("client-1", "secret", domain.AuthMethodClientSecretBasic, refreshTokenValue, nil),
+			refreshToken:   domain.ReconstructRefreshToken(refreshTokenValue, "client-1", "user-1", []string{"read", "write"}, time.Now().Unix(), time.Now().Add(time.Hour).Unix(), "grant-1"),
+			expectedScopes: []string{"read", "write"},
+		},
+		{
+			name:           "正常系 - 要求されたスコープに絞り込んで再発行する",
+			input:          NewRefreshTokenInput("client-1", "secret", domain.AuthMethodClientSecretBasic, refreshTokenValue, []string{"write"}),
+			refreshToken:   domain.ReconstructRefreshToken(refreshTokenValue, "client-1", "user-1", []string{"read", "write"}, time.Now().Unix(), time.Now().Add(time.Hour).Unix(), "grant-1"),
+			expectedScopes: []string{"write"},
+		},
+		{
+			name:         "異常系 - 元の認可に無いスコープを要求",
+			input:        NewRefreshTokenInput("client-1", "secret", domain.AuthMethodClientSecretBasic, refreshTokenValue, []string{"admin"}),
+			refreshToken: domain.ReconstructRefreshToken(refreshTokenValue, "client-1", "user-1", []string{"read", "write"}, time.Now().Unix(), time.Now().Add(time.Hour).Unix(), "grant-1"),
+			expectedErr:  ErrInvalidScope,
+		},
+		{
+			name:         "異常系 - クライアント認証に失敗",
+			input:        NewRefreshTokenInput("client-1", "wrong-secret", domain.AuthMethodClientSecretBasic, refreshTokenValue, nil),
+			refreshToken: domain.ReconstructRefreshToken(refreshTokenValue, "client-1", "user-1", []string{"read"}, time.Now().Unix(), time.Now().Add(time.Hour).Unix(), "grant-1"),
+			expectedErr:  ErrInvalidClientCredential,
+		},
+		{
+			name:        "異常系 - リフレッシュトークンが存在しない",
+			input:       NewRefreshTokenInput("client-1", "secret", domain.AuthMethodClientSecretBasic, refreshTokenValue, nil),
+			expectedErr: ErrRefreshTokenNotFound,
+		},
+		{
+			name:         "異常系 - 別のクライアントのリフレッシュトークン",
+			input:        NewRefreshTokenInput("client-1", "secret", domain.AuthMethodClientSecretBasic, refreshTokenValue, nil),
+			refreshToken: domain.ReconstructRefreshToken(refreshTokenValue, "client-2", "user-1", []string{"read"}, time.Now().Unix(), time.Now().Add(time.Hour).Unix(), "grant-1"),
+			expectedErr:  ErrInvalidClientID,
+		},
+		{
+			name:         "異常系 - リフレッシュトークンの有効期限切れ",
+			input:        NewRefreshTokenInput("client-1", "secret", domain.AuthMethodClientSecretBasic, refreshTokenValue, nil),
+			refreshToken: domain.ReconstructRefreshToken(refreshTokenValue, "client-1", "user-1", []string{"read"}, time.Now().Unix(), time.Now().Add(-time.Hour).Unix(), "grant-1"),
+			expectedErr:  ErrRefreshTokenExpired,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// given
+			tr := &mockTokenRepository{refreshTokens: map[string]*domain.RefreshToken{}}
+			if tt.refreshToken != nil {
+				tr.refreshTokens[tt.refreshToken.Value()] = tt.refreshToken
+			}
+			csr := &mockConsentRepository{consents: map[string]*domain.Consent{}}
+			csr.Save(domain.NewConsent("user-1", "client-1", []string{"read", "write"}, time.Now(), 0))
+			flow := NewRefreshTokenFlow(logger, &mockClientRepository{client: client}, tr, csr, domain.DefaultLifetimes(), domain.NewScopeProvider(nil))
+
+			// when
+			accessToken, refreshToken, err := flow.Execute(tt.input)
+
+			// then
+			if tt.expectedErr != nil {
+				if !errors.Is(err, tt.expectedErr) {
+					t.Fatalf("Execute() error = %v, want %v", err, tt.expectedErr)
+				}
+				if len(tr.accessTokens) != 0 {
+					t.Errorf("saved access tokens = %d, want 0", len(tr.accessTokens))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Execute() error = %v", err)
+			}
+			if !slices.Equal(accessToken.Scopes(), tt.expectedScopes) {
+				t.Errorf("AccessToken Scopes() = %v, want %v", accessToken.Scopes(), tt.expectedScopes)
+			}
+			if accessToken.UserID() != "user-1" {
+				t.Errorf("AccessToken UserID() = %v, want %v", accessToken.UserID(), "user-1")
+			}
+			consent, _ := csr.FindByUserAndClient("user-1", "client-1")
+			if consent.LastUsedAt().IsZero() {
+				t.Error("consent usage should be recorded")
+			}
+			// ローテーションにより使用済みのリフレッシュトークンは無効になり、元の認可のスコープを引き継ぐ
+			if _, ok := tr.refreshTokens[refreshTokenValue]; ok {
+				t.Error("used refresh token should be deleted")
+			}
+			if !slices.Equal(refreshToken.Scopes(), tt.refreshToken.Scopes()) {
+				t.Errorf("RefreshToken Scopes() = %v, want %v", refreshToken.Scopes(), tt.refreshToken.Scopes())
+			}
+			// 認可コードの再利用を検知した際に無効にできるよう、発行の元になった認可を引き継ぐ
+			if accessToken.GrantID() != "grant-1" || refreshToken.GrantID() != "grant-1" {
+				t.Errorf("GrantID() = (%v, %v), want grant-1", accessToken.GrantID(), refreshToken.GrantID())
+			}
+		})
+	}
+}
+
+func Test_クライアントのポリシーによるToken再発行の制限(t *testing.T) {
+	logger := mylogger.NewMockLogger()
+	newClient := func(modify func(p *domain.ClientPolicy)) *domain.Client {
+		policy := domain.DefaultClientPolicy()
+		modify(&policy)
+		return domain.ReconstructClientWithPolicy("client-1", "テストクライアント", domain.ConfidentialClient, "secret", []string{"https://example.com/callback"}, policy)
+	}
+	const refreshTokenValue = "test-refresh-token"
+
+	tests := []struct {
+		name               string
+		client             *domain.Client
+		input              RefreshTokenInput
+		expectedErr        error
+		expectedRefresh    bool
+		expectedRefreshTTL time.Duration
+	}{
+		{
+			name:               "正常系 - クライアントの有効期間でローテーションする",
+			client:             newClient(func(p *domain.ClientPolicy) { p.Lifetimes.RefreshToken = 24 * time.Hour }),
+			input:              NewRefreshTokenInput("client-1", "secret", domain.AuthMethodClientSecretBasic, refreshTokenValue, nil),
+			expectedRefresh:    true,
+			expectedRefreshTTL: 24 * time.Hour,
+		},
+		{
+			name:            "正常系 - リフレッシュトークンを発行しないよう変更された場合はアクセストークンのみ",
+			client:          newClient(func(p *domain.ClientPolicy) { p.IssueRefreshToken = false }),
+			input:           NewRefreshTokenInput("client-1", "secret", domain.AuthMethodClientSecretBasic, refreshTokenValue, nil),
+			expectedRefresh: false,
+		},
+		{
+			name:        "異常系 - refresh_tokenのgrant_typeを許可していない",
+			client:      newClient(func(p *domain.ClientPolicy) { p.GrantTypes = []domain.GrantType{domain.GrantTypeAuthorizationCode} }),
+			input:       NewRefreshTokenInput("client-1", "secret", domain.AuthMethodClientSecretBasic, refreshTokenValue, nil),
+			expectedErr: ErrUnauthorizedClient,
+		},
+		{
+			name:        "異常系 - 登録されていない方式でのクライアント認証",
+			client:      newClient(func(p *domain.ClientPolicy) { p.TokenEndpointAuthMethod = domain.AuthMethodClientSecretBasic }),
+			input:       NewRefreshTokenInput("client-1", "secret", domain.AuthMethodClientSecretPost, refreshTokenValue, nil),
+			expectedErr: ErrInvalidClientCredential,
+		},
+		{
+			name:        "異常系 - 許可されなくなったスコープ",
+			client:      newClient(func(p *domain.ClientPolicy) { p.Scopes = []string{"read"} }),
+			input:       NewRefreshTokenInput("client-1", "secret", domain.AuthMethodClientSecretBasic, refreshTokenValue, nil),
+			expectedErr: ErrInvalidScope,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// given
+			now := time.Now()
+			tr := &mockTokenRepository{refreshTokens: map[string]*domain.RefreshToken{
+				refreshTokenValue: domain.ReconstructRefreshToken(refreshTokenValue, "client-1", "user-1", []string{"read", "write"}, now.Unix(), now.Add(time.Hour).Unix(), "grant-1"),
+			}}
+			csr := &mockConsentRepository{consents: map[string]*domain.Consent{}}
+			flow := NewRefreshTokenFlow(logger, &mockClientRepository{client: tt.client}, tr, csr, domain.DefaultLifetimes(), domain.NewScopeProvider(nil))
+
+			// when
+			accessToken, refreshToken, err := flow.Execute(tt.input)
+
+			// then
+			if !errors.Is(err, tt.expectedErr) {
+				t.Fatalf("Execute() error = %v, want %v", err, tt.expectedErr)
+			}
+			if tt.expectedErr != nil {
+				if len(tr.accessTokens) != 0 {
+					t.Errorf("saved access tokens = %d, want 0", len(tr.accessTokens))
+				}
+				// 拒否した場合はリフレッシュトークンを消費しない
+				if _, ok := tr.refreshTokens[refreshTokenValue]; !ok {
+					t.Error("refresh token should not be consumed")
+				}
+				return
+			}
+			if accessToken == nil || (refreshToken != nil) != tt.expectedRefresh {
+				t.Fatalf("Execute() = (%v, %v), want refresh token issued = %v", accessToken, refreshToken, tt.expectedRefresh)
+			}
+			if refreshToken != nil {
+				if ttl := time.Unix(refreshToken.ExpiresAt(), 0).Sub(time.Unix(now.Unix(), 0)); ttl != tt.expectedRefreshTTL {
+					t.Errorf("RefreshToken TTL = %v, want %v", ttl, tt.expectedRefreshTTL)
+				}
+			}
+		})
+	}
+}
@@ -16,14 +16,22 @@ type PublishTokenStrategy struct {
 	cr     tokenport.IClientRepository
 	ar     tokenport.IAuthorizationCodeRepository
 	tr     tokenport.ITokenRepository
+	csr    tokenport.IConsentRepository
+	// 発行するトークンの有効期間
+	lifetimes domain.Lifetimes
+	// レルムのスコープの一覧
+	scopes *domain.ScopeProvider
 }
 
-func NewPublishTokenStrategy(logger mylogger.Logger, cr tokenport.IClientRepository, ar tokenport.IAuthorizationCodeRepository, tr tokenport.ITokenRepository) *PublishTokenStrategy {
+func NewPublishTokenStrategy(logger mylogger.Logger, cr tokenport.IClientRepository, ar tokenport.IAuthorizationCodeRepository, tr tokenport.ITokenRepository, csr tokenport.IConsentRepository, lifetimes domain.Lifetimes, scopes *domain.ScopeProvider) *PublishTokenStrategy {
 	return &PublishTokenStrategy{
-		logger: logger,
-		cr:     cr,
-		ar:     ar,
-		tr:     tr,
+		logger:    logger,
+		cr:        cr,
+		ar:        ar,
+		tr:        tr,
+		csr:       csr,
+		lifetimes: lifetimes,
+		scopes:    scopes,
 	}
 }
 
@@ -36,9 +44,9 @@ type Usecase interface {
 func (s *PublishTokenStrategy) ResolvePublishTokenFlow(grantType domain.GrantType) (Usecase, error) {
 	switch grantType {
 	case domain.GrantTypeAuthorizationCode:
-		return authorizationcodeflow.NewAuthorizationCodeFlow(s.logger, s.cr, s.ar, s.tr), nil
+		return authorizationcodeflow.NewAuthorizationCodeFlow(s.logger, s.cr, s.ar, s.tr, s.csr, s.lifetimes, s.scopes), nil
 	case domain.GrantTypeRefreshToken:
-		return refreshtokenflow.NewRefreshTokenFlow(s.logger, s.cr, s.tr), nil
+		return refreshtokenflow.NewRefreshTokenFlow(s.logger, s.cr, s.tr, s.csr, s.lifetimes, s.scopes), nil
 	default:
 		s.logger.Error("enumでサポートしているgrant_typeがinteractorで実装されていません。")
 		return nil, ErrNoMatchingStrategyFound
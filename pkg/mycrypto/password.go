@@ -0,0 +1,168 @@
+package mycrypto
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var ErrUnsupportedPasswordHash = errors.New("unsupported password hash")
+
+// Argon2idのパラメータ RFC 9106
+type Argon2Params struct {
+	// メモリ使用量(KiB)
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// OWASP Password Storage Cheat Sheetの推奨値(m=19MiB, t=2, p=1)
+func DefaultArgon2Params() Argon2Params {
+	return Argon2Params{Memory: 19 * 1024, Iterations: 2, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+}
+
+// パスワードをソルト付きのArgon2idでハッシュ化し、アルゴリズムとパラメータを含む形式(PHC string format)で表す
+//
+//	$argon2id$v=19$m=19456,t=2,p=1$<ソルト>$<ハッシュ値>
+//
+// 検証では、以前の形式(bcrypt)も受け付けて再ハッシュが必要であることを返す
+// ハッシュ化する前のバージョンで保存した平文のパスワードは受け付けない。起動時にハッシュ値に置き換える(IsPlaintextPassword)
+type PasswordHasher struct {
+	params Argon2Params
+}
+
+func NewPasswordHasher(params Argon2Params) *PasswordHasher {
+	return &PasswordHasher{params: params}
+}
+
+func (h *PasswordHasher) Hash(password string) string {
+	salt := make([]byte, h.params.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		panic(err)
+	}
+	key := argon2.IDKey([]byte(password), salt, h.params.Iterations, h.params.Memory, h.params.Parallelism, h.params.KeyLength)
+	return encodeArgon2id(h.params, salt, key)
+}
+
+// encodedとpasswordが一致するかを定数時間で比較する
+// 一致した場合、現在のアルゴリズムとパラメータ以外でハッシュ化されていればneedsRehashをtrueにする
+func (h *PasswordHasher) Verify(encoded, password string) (match bool, needsRehash bool, err error) {
+	switch {
+	case strings.HasPrefix(encoded, "$argon2id$"):
+		params, salt, key, err := decodeArgon2id(encoded)
+		if err != nil {
+			return false, false, err
+		}
+		actual := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+		if subtle.ConstantTimeCompare(actual, key) != 1 {
+			return false, false, nil
+		}
+		current := h.params
+		return true, params.Memory != current.Memory || params.Iterations != current.Iterations || params.Parallelism != current.Parallelism ||
+			uint32(len(salt)) != current.SaltLength || uint32(len(key)) != current.KeyLength, nil
+	case strings.HasPrefix(encoded, "$2a$"), strings.HasPrefix(encoded, "$2b$"), strings.HasPrefix(encoded, "$2y$"):
+		err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, false, nil
+		}
+		if err != nil {
+			return false, false, fmt.Errorf("%w: %v", ErrUnsupportedPasswordHash, err)
+		}
+		return true, true, nil
+	}
+	return false, false, ErrUnsupportedPasswordHash
+}
+
+// 存在しないユーザーのログインでも応答時間が変わらないよう、ハッシュ値の計算のみを行う
+func (h *PasswordHasher) DummyVerify(password string) {
+	_ = h.Hash(password)
+}
+
+// encodedと同じアルゴリズムとパラメータで、ランダムなパスワードのハッシュ値を作る
+// 存在しないユーザーの場合に検証し、登録済みのハッシュ値の検証と同じ時間をかけるために使う
+func DummyPasswordHash(encoded string) (string, error) {
+	password := make([]byte, 32)
+	if _, err := rand.Read(password); err != nil {
+		return "", err
+	}
+	switch {
+	case strings.HasPrefix(encoded, "$argon2id$"):
+		params, _, _, err := decodeArgon2id(encoded)
+		if err != nil {
+			return "", err
+		}
+		return NewPasswordHasher(params).Hash(string(password)), nil
+	case strings.HasPrefix(encoded, "$2a$"), strings.HasPrefix(encoded, "$2b$"), strings.HasPrefix(encoded, "$2y$"):
+		cost, err := bcrypt.Cost([]byte(encoded))
+		if err != nil {
+			return "", fmt.Errorf("%w: %v", ErrUnsupportedPasswordHash, err)
+		}
+		hash, err := bcrypt.GenerateFromPassword(password, cost)
+		if err != nil {
+			return "", err
+		}
+		return string(hash), nil
+	}
+	return "", ErrUnsupportedPasswordHash
+}
+
+// ハッシュ化する前のバージョンで保存した平文のパスワードか。ハッシュ値は全て$で始まる
+// 上流のIdPのユーザーのようにパスワードを持たない場合(空文字列)は平文のパスワードではない
+func IsPlaintextPassword(encoded string) bool {
+	return encoded != "" && !strings.HasPrefix(encoded, "$")
+}
+
+// Verifyで検証できるハッシュ値の形式か。平文のパスワードはエラー
+func ValidatePasswordHash(encoded string) error {
+	switch {
+	case strings.HasPrefix(encoded, "$argon2id$"):
+		_, _, _, err := decodeArgon2id(encoded)
+		return err
+	case strings.HasPrefix(encoded, "$2a$"), strings.HasPrefix(encoded, "$2b$"), strings.HasPrefix(encoded, "$2y$"):
+		if _, err := bcrypt.Cost([]byte(encoded)); err != nil {
+			return fmt.Errorf("%w: %v", ErrUnsupportedPasswordHash, err)
+		}
+		return nil
+	}
+	return ErrUnsupportedPasswordHash
+}
+
+func encodeArgon2id(params Argon2Params, salt, key []byte) string {
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, params.Memory, params.Iterations, params.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))
+}
+
+func decodeArgon2id(encoded string) (Argon2Params, []byte, []byte, error) {
+	// ["", "argon2id", "v=19", "m=...,t=...,p=...", ソルト, ハッシュ値]
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 {
+		return Argon2Params{}, nil, nil, fmt.Errorf("%w: malformed argon2id hash", ErrUnsupportedPasswordHash)
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return Argon2Params{}, nil, nil, fmt.Errorf("%w: unsupported argon2 version %q", ErrUnsupportedPasswordHash, parts[2])
+	}
+	var params Argon2Params
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism); err != nil ||
+		params.Memory == 0 || params.Iterations == 0 || params.Parallelism == 0 {
+		return Argon2Params{}, nil, nil, fmt.Errorf("%w: malformed argon2id parameters %q", ErrUnsupportedPasswordHash, parts[3])
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil || len(salt) == 0 {
+		return Argon2Params{}, nil, nil, fmt.Errorf("%w: malformed argon2id salt", ErrUnsupportedPasswordHash)
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return Argon2Params{}, nil, nil, fmt.Errorf("%w: malformed argon2id hash", ErrUnsupportedPasswordHash)
+	}
+	params.SaltLength, params.KeyLength = uint32(len(salt)), uint32(len(key))
+	return params, salt, key, nil
+}
@@ -0,0 +1,133 @@
+package mycrypto
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// テストを速くするため、メモリ使用量と繰り返し回数を小さくする
+var testParams = Argon2Params{Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+
+func Test_パスワードのハッシュ化(t *testing.T) {
+	// given
+	hasher := NewPasswordHasher(testParams)
+
+	// when
+	first := hasher.Hash("password")
+	second := hasher.Hash("password")
+
+	// then
+	if !strings.HasPrefix(first, "$argon2id$v=19$m=64,t=1,p=1$") {
+		t.Errorf("Hash() = %q", first)
+	}
+	// ソルトが異なるため、同じパスワードでもハッシュ値は異なる
+	if first == second {
+		t.Error("Hash() returned the same value for the same password")
+	}
+	if err := ValidatePasswordHash(first); err != nil {
+		t.Errorf("ValidatePasswordHash() error = %v", err)
+	}
+}
+
+func Test_パスワードの検証(t *testing.T) {
+	hasher := NewPasswordHasher(testParams)
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("GenerateFromPassword() error = %v", err)
+	}
+	tests := []struct {
+		name                string
+		encoded             string
+		password            string
+		expectedMatch       bool
+		expectedNeedsRehash bool
+		expectedErr         error
+	}{
+		{name: "正常系 - 一致", encoded: hasher.Hash("password"), password: "password", expectedMatch: true},
+		{name: "正常系 - 不一致", encoded: hasher.Hash("password"), password: "wrong"},
+		{name: "正常系 - パラメータが異なる場合は再ハッシュが必要", encoded: NewPasswordHasher(Argon2Params{Memory: 32, Iterations: 2, Parallelism: 1, SaltLength: 16, KeyLength: 32}).Hash("password"), password: "password", expectedMatch: true, expectedNeedsRehash: true},
+		{name: "正常系 - パラメータが異なっても不一致なら再ハッシュしない", encoded: NewPasswordHasher(Argon2Params{Memory: 32, Iterations: 2, Parallelism: 1, SaltLength: 16, KeyLength: 32}).Hash("password"), password: "wrong"},
+		{name: "正常系 - bcryptは再ハッシュが必要", encoded: string(bcryptHash), password: "password", expectedMatch: true, expectedNeedsRehash: true},
+		{name: "異常系 - 以前の平文のパスワードは受け付けない", encoded: "password", password: "password", expectedErr: ErrUnsupportedPasswordHash},
+		{name: "異常系 - 未対応のアルゴリズム", encoded: "$scrypt$ln=15,r=8,p=1$c2FsdA$aGFzaA", password: "password", expectedErr: ErrUnsupportedPasswordHash},
+		{name: "異常系 - 未対応のバージョン", encoded: "$argon2id$v=16$m=64,t=1,p=1$c2FsdA$aGFzaA", password: "password", expectedErr: ErrUnsupportedPasswordHash},
+		{name: "異常系 - パラメータの形式が不正", encoded: "$argon2id$v=19$m=0,t=1,p=1$c2FsdA$aGFzaA", password: "password", expectedErr: ErrUnsupportedPasswordHash},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// when
+			match, needsRehash, err := hasher.Verify(tt.encoded, tt.password)
+
+			// then
+			if !errors.Is(err, tt.expectedErr) {
+				t.Fatalf("Verify() error = %v, want %v", err, tt.expectedErr)
+			}
+			if match != tt.expectedMatch || needsRehash != tt.expectedNeedsRehash {
+				t.Errorf("Verify() = %v, %v, want %v, %v", match, needsRehash, tt.expectedMatch, tt.expectedNeedsRehash)
+			}
+		})
+	}
+}
+
+func Test_平文のパスワードの判定(t *testing.T) {
+	tests := []struct {
+		name     string
+		encoded  string
+		expected bool
+	}{
+		{name: "平文のパスワード", encoded: "password", expected: true},
+		{name: "argon2id", encoded: NewPasswordHasher(testParams).Hash("password")},
+		{name: "bcrypt", encoded: "$2a$04$abcdefghijklmnopqrstuu"},
+		{name: "未対応のアルゴリズムのハッシュ値", encoded: "$scrypt$ln=15,r=8,p=1$c2FsdA$aGFzaA"},
+		{name: "パスワードを持たない", encoded: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if actual := IsPlaintextPassword(tt.encoded); actual != tt.expected {
+				t.Errorf("IsPlaintextPassword(%q) = %v, want %v", tt.encoded, actual, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_ダミーのハッシュ値(t *testing.T) {
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost+1)
+	if err != nil {
+		t.Fatalf("GenerateFromPassword() error = %v", err)
+	}
+	argon2Params := Argon2Params{Memory: 32, Iterations: 2, Parallelism: 1, SaltLength: 8, KeyLength: 16}
+	tests := []struct {
+		name         string
+		encoded      string
+		expectedHash string
+		expectedErr  error
+	}{
+		{name: "正常系 - argon2idは同じパラメータ", encoded: NewPasswordHasher(argon2Params).Hash("password"), expectedHash: "$argon2id$v=19$m=32,t=2,p=1$"},
+		{name: "正常系 - bcryptは同じコスト", encoded: string(bcryptHash), expectedHash: "$2a$05$"},
+		{name: "異常系 - 未対応のアルゴリズム", encoded: "$scrypt$ln=15,r=8,p=1$c2FsdA$aGFzaA", expectedErr: ErrUnsupportedPasswordHash},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// when
+			dummy, err := DummyPasswordHash(tt.encoded)
+
+			// then
+			if !errors.Is(err, tt.expectedErr) {
+				t.Fatalf("DummyPasswordHash() error = %v, want %v", err, tt.expectedErr)
+			}
+			if tt.expectedErr != nil {
+				return
+			}
+			if !strings.HasPrefix(dummy, tt.expectedHash) || dummy == tt.encoded || ValidatePasswordHash(dummy) != nil {
+				t.Errorf("DummyPasswordHash() = %q, want a valid hash starting with %q", dummy, tt.expectedHash)
+			}
+			// 元のパスワードとは一致しない
+			if match, _, _ := NewPasswordHasher(testParams).Verify(dummy, "password"); match {
+				t.Error("dummy hash should not match the original password")
+			}
+		})
+	}
+}
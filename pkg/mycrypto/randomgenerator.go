@@ -15,3 +15,13 @@ func (*RandomGenerator) GenerateURLSafeRandomString(n int) string {
 	}
 	return base64.RawURLEncoding.EncodeToString(b)
 }
+
+// TOTPのシークレットやリカバリーコードなど、エンコードせずに使うランダムなバイト列
+func (*RandomGenerator) GenerateRandomBytes(n int) []byte {
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
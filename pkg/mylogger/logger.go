@@ -33,3 +33,37 @@ func NewLogger() Logger {
 		logger: slog.New(slog.NewJSONHandler(os.Stdout, nil)),
 	}
 }
+
+// CSRFトークンの検証失敗などのセキュリティイベントを記録する
+// 監視で抽出できるよう、category=securityとイベント名を付与して警告レベルで出力する
+func SecurityEvent(l Logger, event string, args ...any) {
+	l.Warn("security event", append([]any{"category", "security", "event", event}, args...)...)
+}
+
+// 設定の再読み込みなどの管理操作を監査イベントとして記録する
+// 監視で抽出できるよう、category=auditとイベント名を付与して出力する
+func AuditEvent(l Logger, event string, args ...any) {
+	l.Info("audit event", append([]any{"category", "audit", "event", event}, args...)...)
+}
+
+type withLogger struct {
+	logger Logger
+	args   []any
+}
+
+func (l *withLogger) Info(msg string, args ...any) {
+	l.logger.Info(msg, append(l.args[:len(l.args):len(l.args)], args...)...)
+}
+
+func (l *withLogger) Error(msg string, args ...any) {
+	l.logger.Error(msg, append(l.args[:len(l.args):len(l.args)], args...)...)
+}
+
+func (l *withLogger) Warn(msg string, args ...any) {
+	l.logger.Warn(msg, append(l.args[:len(l.args):len(l.args)], args...)...)
+}
+
+// 全てのログに属性(例: レルム名)を付与するLoggerを返す
+func With(l Logger, args ...any) Logger {
+	return &withLogger{logger: l, args: args}
+}